@@ -10,17 +10,23 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/nexus/drive-service/config"
+	"github.com/nexus/drive-service/internal/dav"
 	"github.com/nexus/drive-service/internal/handler"
 	"github.com/nexus/drive-service/internal/middleware"
+	"github.com/nexus/drive-service/internal/notification"
 	"github.com/nexus/drive-service/internal/repository"
 	"github.com/nexus/drive-service/internal/service"
 	"github.com/nexus/drive-service/internal/storage"
 )
 
+// userRecordInterval is how often the sign-in of each user is recorded
+const userRecordInterval = 10 * time.Minute
+
 func main() {
 	// Load configuration
 	cfg, err := config.Load()
@@ -41,35 +47,75 @@ func main() {
 	}
 	log.Println("Connected to database")
 
-	// Initialize MinIO storage
-	minioStorage, err := storage.NewMinIOStorage(&cfg.MinIO)
+	// Initialize storage
+	fileStorage, err := storage.New(cfg)
 	if err != nil {
-		log.Fatal("Failed to initialize MinIO storage:", err)
+		log.Fatal("Failed to initialize storage:", err)
 	}
-	log.Println("Connected to MinIO storage")
+	log.Printf("Using %s storage", cfg.Storage.Driver)
 
 	// Initialize repositories
-	fileRepo := repository.NewFileRepository(db)
-	folderRepo := repository.NewFolderRepository(db)
-	permissionRepo := repository.NewPermissionRepository(db)
-	shareLinkRepo := repository.NewShareLinkRepository(db)
-	versionRepo := repository.NewVersionRepository(db)
+	repos := service.Repositories{
+		Files:        repository.NewFileRepository(db),
+		Folders:      repository.NewFolderRepository(db),
+		Permissions:  repository.NewPermissionRepository(db),
+		ShareLinks:   repository.NewShareLinkRepository(db),
+		Versions:     repository.NewVersionRepository(db),
+		Uploads:      repository.NewUploadSessionRepository(db),
+		Blobs:        repository.NewBlobRepository(db),
+		Groups:       repository.NewGroupRepository(db),
+		Previews:     repository.NewPreviewRepository(db),
+		Search:       repository.NewSearchRepository(db),
+		AppPasswords: repository.NewAppPasswordRepository(db),
+		Changes:      repository.NewChangeRepository(db),
+		Quotas:       repository.NewQuotaRepository(db),
+		Archives:     repository.NewArchiveRepository(db),
+		Locks:        repository.NewLockRepository(db),
+		Activity:     repository.NewActivityRepository(db),
+		Users:        repository.NewUserRepository(db),
+	}
+
+	// Quota warnings are sent to the notification service if it is configured
+	var notifier notification.Sender
+	if cfg.Quotas.NotificationURL != "" {
+		notifier = notification.NewClient(cfg.Quotas.NotificationURL, cfg.Quotas.InternalAPIKey)
+	}
 
 	// Initialize service
-	driveService := service.NewDriveService(
-		fileRepo,
-		folderRepo,
-		permissionRepo,
-		shareLinkRepo,
-		versionRepo,
-		minioStorage,
-	)
+	driveService := service.NewDriveService(repos, fileStorage, notifier, cfg)
+
+	// Expire abandoned upload sessions, lapsed permissions and old changes,
+	// prune old versions and activity, verify stored content, delete
+	// unreferenced content, generate previews, extract archives and send
+	// quota warnings in the background
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go expireUploadSessions(workerCtx, driveService, cfg.Upload.CleanupInterval)
+	go expirePermissions(workerCtx, driveService, cfg.Permissions.CleanupInterval)
+	go pruneChanges(workerCtx, driveService, cfg.Changes.CleanupInterval)
+	go pruneVersions(workerCtx, driveService, cfg.Versions.PruneInterval, cfg.Blobs.GCGracePeriod)
+	go pruneActivity(workerCtx, driveService, cfg.Activity.PruneInterval)
+	go maintainBlobs(workerCtx, driveService, cfg.Blobs)
+	go generatePreviews(workerCtx, driveService, cfg.Previews)
+	go extractArchives(workerCtx, driveService, cfg.Archives.PollInterval)
+	if notifier != nil {
+		go deliverQuotaEvents(workerCtx, driveService, cfg.Quotas.DeliveryInterval)
+	}
 
 	// Initialize handler
 	driveHandler := handler.NewDriveHandler(driveService, cfg.Upload.MaxUploadSize)
 
+	// Users are recorded as users of their tenant as they sign in
+	recordUsers := middleware.RecordUsers(driveService.RecordUser, userRecordInterval)
+
+	// WebDAV, for mounting drives in file managers; clients sign in with an
+	// app password or a token
+	davHandler := middleware.BasicAuth(cfg.JWT.Secret, "NEXUS Drive", authenticateAppPassword(driveService))(
+		recordUsers(dav.NewHandler(driveService, "/dav", cfg.Upload.MaxResumableSize)),
+	)
+
 	// Setup router
-	router := setupRouter(driveHandler, cfg)
+	router := setupRouter(driveHandler, davHandler, recordUsers, cfg)
 
 	// Create server
 	server := &http.Server{
@@ -94,6 +140,7 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	stopWorkers()
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -106,18 +153,20 @@ func main() {
 	log.Println("Server exited")
 }
 
-func setupRouter(h *handler.DriveHandler, cfg *config.Config) *mux.Router {
+func setupRouter(h *handler.DriveHandler, davHandler http.Handler, recordUsers func(http.Handler) http.Handler, cfg *config.Config) *mux.Router {
 	router := mux.NewRouter()
 
 	// Apply global middleware
 	router.Use(middleware.Recovery)
 	router.Use(middleware.Logger)
 	router.Use(middleware.RequestID)
+	router.Use(middleware.ClientInfo)
 	router.Use(middleware.CORS(cfg.CORS.AllowedOrigins))
 
 	// API routes
 	api := router.PathPrefix("/api/v1").Subrouter()
 	api.Use(middleware.JWTAuth(cfg.JWT.Secret))
+	api.Use(recordUsers)
 
 	// File routes
 	api.HandleFunc("/files", h.UploadFile).Methods("POST")
@@ -129,8 +178,19 @@ func setupRouter(h *handler.DriveHandler, cfg *config.Config) *mux.Router {
 	api.HandleFunc("/files/{id}", h.UpdateFile).Methods("PUT")
 	api.HandleFunc("/files/{id}", h.DeleteFile).Methods("DELETE")
 	api.HandleFunc("/files/{id}/download", h.DownloadFile).Methods("GET")
+	api.HandleFunc("/files/{id}/thumbnail", h.GetThumbnail).Methods("GET")
+	api.HandleFunc("/files/{id}/text", h.GetFileText).Methods("GET")
 	api.HandleFunc("/files/{id}/move", h.MoveFile).Methods("POST")
 	api.HandleFunc("/files/{id}/copy", h.CopyFile).Methods("POST")
+	api.HandleFunc("/files/{id}/content", h.UpdateFileContent).Methods("PUT")
+	api.HandleFunc("/files/{id}/extract", h.ExtractArchive).Methods("POST")
+
+	// Lock routes
+	api.HandleFunc("/files/{id}/lock", h.LockFile).Methods("POST")
+	api.HandleFunc("/files/{id}/locks", h.ListLocks).Methods("GET")
+	api.HandleFunc("/files/{id}/locks/{lock_id}", h.UnlockFile).Methods("DELETE")
+	api.HandleFunc("/files/{id}/checkout", h.CheckOutFile).Methods("POST")
+	api.HandleFunc("/files/{id}/checkin", h.CheckInFile).Methods("POST")
 
 	// Folder routes
 	api.HandleFunc("/folders", h.CreateFolder).Methods("POST")
@@ -138,6 +198,11 @@ func setupRouter(h *handler.DriveHandler, cfg *config.Config) *mux.Router {
 	api.HandleFunc("/folders/{id}", h.GetFolder).Methods("GET")
 	api.HandleFunc("/folders/{id}", h.UpdateFolder).Methods("PUT")
 	api.HandleFunc("/folders/{id}", h.DeleteFolder).Methods("DELETE")
+	api.HandleFunc("/folders/{id}/download", h.DownloadFolder).Methods("GET")
+
+	// Archive routes
+	api.HandleFunc("/download", h.DownloadSelection).Methods("GET")
+	api.HandleFunc("/extractions/{id}", h.GetArchiveExtraction).Methods("GET")
 
 	// Trash routes
 	api.HandleFunc("/trash", h.ListTrashed).Methods("GET")
@@ -148,15 +213,73 @@ func setupRouter(h *handler.DriveHandler, cfg *config.Config) *mux.Router {
 	api.HandleFunc("/permissions", h.GrantPermission).Methods("POST")
 	api.HandleFunc("/permissions/{id}", h.RevokePermission).Methods("DELETE")
 	api.HandleFunc("/permissions/{resource_type}/{resource_id}", h.ListPermissions).Methods("GET")
+	api.HandleFunc("/access/{resource_type}/{resource_id}", h.GetEffectiveAccess).Methods("GET")
+
+	// Group routes
+	api.HandleFunc("/groups", h.CreateGroup).Methods("POST")
+	api.HandleFunc("/groups", h.ListGroups).Methods("GET")
+	api.HandleFunc("/groups/{id}", h.GetGroup).Methods("GET")
+	api.HandleFunc("/groups/{id}", h.DeleteGroup).Methods("DELETE")
+	api.HandleFunc("/groups/{id}/members", h.AddGroupMember).Methods("POST")
+	api.HandleFunc("/groups/{id}/members/{user_id}", h.RemoveGroupMember).Methods("DELETE")
 
 	// Share link routes
 	api.HandleFunc("/share-links", h.CreateShareLink).Methods("POST")
 	api.HandleFunc("/share-links/{id}", h.DeleteShareLink).Methods("DELETE")
+	api.HandleFunc("/share-links/{id}/access-log", h.GetShareLinkAccessLog).Methods("GET")
 	api.HandleFunc("/share/{token}", h.GetShareLink).Methods("GET")
 
 	// Version routes
 	api.HandleFunc("/files/{file_id}/versions", h.ListVersions).Methods("GET")
 	api.HandleFunc("/files/{file_id}/versions/restore", h.RestoreVersion).Methods("POST")
+	api.HandleFunc("/files/{file_id}/versions/diff", h.DiffVersions).Methods("GET")
+	api.HandleFunc("/files/{file_id}/versions/{version_num:[0-9]+}", h.UpdateVersion).Methods("PATCH")
+	api.HandleFunc("/version-retention", h.GetVersionRetention).Methods("GET")
+	api.Handle("/version-retention", middleware.RequireRole("admin")(http.HandlerFunc(h.SetVersionRetention))).Methods("PUT")
+
+	// Resumable upload routes
+	api.HandleFunc("/uploads", h.CreateUploadSession).Methods("POST")
+	api.HandleFunc("/uploads/{id}", h.GetUploadSession).Methods("GET", "HEAD")
+	api.HandleFunc("/uploads/{id}", h.UploadChunk).Methods("PATCH")
+	api.HandleFunc("/uploads/{id}", h.AbortUpload).Methods("DELETE")
+	api.HandleFunc("/uploads/{id}/complete", h.CompleteUpload).Methods("POST")
+
+	// Change feed routes
+	api.HandleFunc("/changes", h.GetChanges).Methods("GET")
+	api.HandleFunc("/changes/poll", h.PollChanges).Methods("GET")
+
+	// Activity routes
+	api.HandleFunc("/files/{id}/activity", h.GetFileActivity).Methods("GET")
+	api.HandleFunc("/folders/{id}/activity", h.GetFolderActivity).Methods("GET")
+	api.HandleFunc("/activity", h.GetMyActivity).Methods("GET")
+	api.Handle("/users/{user_id}/activity", middleware.RequireRole("admin")(http.HandlerFunc(h.GetUserActivity))).Methods("GET")
+	api.Handle("/tenant/activity", middleware.RequireRole("admin")(http.HandlerFunc(h.GetTenantActivity))).Methods("GET")
+	api.HandleFunc("/activity-retention", h.GetActivityRetention).Methods("GET")
+	api.Handle("/activity-retention", middleware.RequireRole("admin")(http.HandlerFunc(h.SetActivityRetention))).Methods("PUT")
+
+	// Usage routes
+	api.HandleFunc("/usage", h.GetStorageUsage).Methods("GET")
+	api.Handle("/users/{user_id}/quota", middleware.RequireRole("admin")(http.HandlerFunc(h.GetUserQuota))).Methods("GET")
+	api.Handle("/users/{user_id}/quota", middleware.RequireRole("admin")(http.HandlerFunc(h.SetUserQuota))).Methods("PUT")
+	api.Handle("/users/{user_id}/quota", middleware.RequireRole("admin")(http.HandlerFunc(h.ClearUserQuota))).Methods("DELETE")
+	api.Handle("/tenant/quota", middleware.RequireRole("admin")(http.HandlerFunc(h.GetTenantQuota))).Methods("GET")
+	api.Handle("/tenant/quota", middleware.RequireRole("admin")(http.HandlerFunc(h.SetTenantQuota))).Methods("PUT")
+	api.Handle("/tenant/quota", middleware.RequireRole("admin")(http.HandlerFunc(h.ClearTenantQuota))).Methods("DELETE")
+
+	// App password routes
+	api.HandleFunc("/app-passwords", h.CreateAppPassword).Methods("POST")
+	api.HandleFunc("/app-passwords", h.ListAppPasswords).Methods("GET")
+	api.HandleFunc("/app-passwords/{id}", h.DeleteAppPassword).Methods("DELETE")
+
+	// WebDAV, which authenticates its own requests
+	router.PathPrefix("/dav").Handler(davHandler)
+
+	// Public share link routes, authorized by the link token
+	share := router.PathPrefix("/s/{token}").Subrouter()
+	share.HandleFunc("", h.GetSharedResource).Methods("GET")
+	share.HandleFunc("/unlock", h.UnlockShareLink).Methods("POST")
+	share.HandleFunc("/items", h.ListSharedFolder).Methods("GET")
+	share.HandleFunc("/download", h.DownloadShared).Methods("GET")
 
 	// Health check
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -167,3 +290,231 @@ func setupRouter(h *handler.DriveHandler, cfg *config.Config) *mux.Router {
 
 	return router
 }
+
+// authenticateAppPassword signs WebDAV clients in with app passwords
+func authenticateAppPassword(driveService service.DriveService) middleware.PasswordAuthenticator {
+	return func(ctx context.Context, username, password string) (uuid.UUID, uuid.UUID, string, error) {
+		appPassword, err := driveService.AuthenticateAppPassword(ctx, username, password)
+		if err != nil {
+			return uuid.Nil, uuid.Nil, "", err
+		}
+		return appPassword.TenantID, appPassword.UserID, appPassword.Login, nil
+	}
+}
+
+// expireUploadSessions periodically removes abandoned upload sessions
+func expireUploadSessions(ctx context.Context, driveService service.DriveService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := driveService.ExpireUploadSessions(ctx)
+			if err != nil {
+				log.Println("Failed to expire upload sessions:", err)
+			}
+			if expired > 0 {
+				log.Printf("Expired %d upload sessions", expired)
+			}
+		}
+	}
+}
+
+// expirePermissions periodically deletes expired permissions
+func expirePermissions(ctx context.Context, driveService service.DriveService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := driveService.ExpirePermissions(ctx)
+			if err != nil {
+				log.Println("Failed to expire permissions:", err)
+			}
+			if expired > 0 {
+				log.Printf("Deleted %d expired permissions", expired)
+			}
+		}
+	}
+}
+
+// pruneChanges periodically deletes changes older than the retention period
+func pruneChanges(ctx context.Context, driveService service.DriveService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pruned, err := driveService.PruneChanges(ctx)
+			if err != nil {
+				log.Println("Failed to prune changes:", err)
+			}
+			if pruned > 0 {
+				log.Printf("Pruned %d old changes", pruned)
+			}
+		}
+	}
+}
+
+// pruneActivity periodically deletes activity older than its tenant's
+// retention
+func pruneActivity(ctx context.Context, driveService service.DriveService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pruned, err := driveService.PruneActivity(ctx)
+			if err != nil {
+				log.Println("Failed to prune activity:", err)
+			}
+			if pruned > 0 {
+				log.Printf("Pruned %d old activities", pruned)
+			}
+		}
+	}
+}
+
+// pruneVersions periodically deletes versions that retention policies no
+// longer keep, then the content no longer referenced
+func pruneVersions(ctx context.Context, driveService service.DriveService, interval, gracePeriod time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pruned, err := driveService.PruneVersions(ctx)
+			if err != nil {
+				log.Println("Failed to prune versions:", err)
+			}
+			if pruned == 0 {
+				continue
+			}
+			log.Printf("Pruned %d old versions", pruned)
+
+			collected, err := driveService.CollectUnreferencedBlobs(ctx, gracePeriod)
+			if err != nil {
+				log.Println("Failed to collect unreferenced blobs:", err)
+			}
+			if collected > 0 {
+				log.Printf("Deleted %d unreferenced blobs", collected)
+			}
+		}
+	}
+}
+
+// deliverQuotaEvents periodically sends quota warnings to the notification
+// service
+func deliverQuotaEvents(ctx context.Context, driveService service.DriveService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := driveService.DeliverQuotaEvents(ctx); err != nil {
+				log.Println("Failed to deliver quota warnings:", err)
+			}
+		}
+	}
+}
+
+// maintainBlobs periodically scrubs stored content and collects content no
+// longer referenced
+func maintainBlobs(ctx context.Context, driveService service.DriveService, cfg config.BlobConfig) {
+	ticker := time.NewTicker(cfg.ScrubInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := driveService.ScrubBlobs(ctx, cfg.ScrubBatchSize)
+			if err != nil {
+				log.Println("Failed to scrub blobs:", err)
+			}
+			if report != nil {
+				for _, blob := range report.Corrupt {
+					log.Printf("Blob %s is corrupt: content does not match checksum %s", blob.StoragePath, blob.Checksum)
+				}
+				for _, blob := range report.Missing {
+					log.Printf("Blob %s is missing from storage", blob.StoragePath)
+				}
+			}
+
+			collected, err := driveService.CollectUnreferencedBlobs(ctx, cfg.GCGracePeriod)
+			if err != nil {
+				log.Println("Failed to collect unreferenced blobs:", err)
+			}
+			if collected > 0 {
+				log.Printf("Deleted %d unreferenced blobs", collected)
+			}
+		}
+	}
+}
+
+// generatePreviews polls for queued previews and generates them, taking the
+// next batch straight away while there are more
+func generatePreviews(ctx context.Context, driveService service.DriveService, cfg config.PreviewConfig) {
+	ticker := time.NewTicker(cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for ctx.Err() == nil {
+				generated, err := driveService.GeneratePreviews(ctx, cfg.BatchSize)
+				if err != nil {
+					log.Println("Failed to generate previews:", err)
+				}
+				if generated < cfg.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// extractArchives polls for queued archive extractions and runs them one at
+// a time, taking the next straight away while there are more
+func extractArchives(ctx context.Context, driveService service.DriveService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for ctx.Err() == nil {
+				extracted, err := driveService.ProcessArchiveExtractions(ctx, 1)
+				if err != nil {
+					log.Println("Failed to extract archives:", err)
+				}
+				if extracted == 0 {
+					break
+				}
+			}
+		}
+	}
+}
@@ -3,19 +3,33 @@ package config
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	MinIO    MinIOConfig
-	JWT      JWTConfig
-	CORS     CORSConfig
-	Upload   UploadConfig
+	Server      ServerConfig
+	Database    DatabaseConfig
+	Storage     StorageConfig
+	MinIO       MinIOConfig
+	JWT         JWTConfig
+	CORS        CORSConfig
+	Upload      UploadConfig
+	Blobs       BlobConfig
+	Permissions PermissionConfig
+	Share       ShareConfig
+	Previews    PreviewConfig
+	Search      SearchConfig
+	Changes     ChangeConfig
+	Quotas      QuotaConfig
+	Versions    VersionConfig
+	Archives    ArchiveConfig
+	Locks       LockConfig
+	Activity    ActivityConfig
 }
 
 type ServerConfig struct {
@@ -32,6 +46,11 @@ type DatabaseConfig struct {
 	SSLMode  string
 }
 
+type StorageConfig struct {
+	Driver    string
+	LocalPath string
+}
+
 type MinIOConfig struct {
 	Endpoint  string
 	AccessKey string
@@ -51,8 +70,89 @@ type CORSConfig struct {
 }
 
 type UploadConfig struct {
-	MaxUploadSize int64
-	ChunkSize     int64
+	MaxUploadSize    int64
+	ChunkSize        int64
+	MaxResumableSize int64
+	SessionTTL       time.Duration
+	CleanupInterval  time.Duration
+}
+
+type BlobConfig struct {
+	ScrubInterval  time.Duration
+	ScrubBatchSize int
+	GCGracePeriod  time.Duration
+}
+
+type PermissionConfig struct {
+	CleanupInterval time.Duration
+}
+
+type ShareConfig struct {
+	TokenSecret         string
+	AccessTTL           time.Duration
+	MaxPasswordAttempts int
+	PasswordLockout     time.Duration
+}
+
+type PreviewConfig struct {
+	ThumbnailSizes []int
+	MaxSourceSize  int64
+	PollInterval   time.Duration
+	BatchSize      int
+}
+
+type SearchConfig struct {
+	Language string
+}
+
+type ChangeConfig struct {
+	Retention       time.Duration
+	CleanupInterval time.Duration
+	MaxWait         time.Duration // longest a long poll waits
+	PollInterval    time.Duration // how often waiting long polls check for changes from other instances
+}
+
+type QuotaConfig struct {
+	UserQuota         int64 // bytes, unless set for the user in storage_quotas; 0 is unlimited
+	TenantQuota       int64 // bytes, unless set for the tenant in storage_quotas; 0 is unlimited
+	WarningThresholds []int // percentages of a quota at which users are warned
+	NotificationURL   string
+	InternalAPIKey    string
+	DeliveryInterval  time.Duration // how often warnings are sent to the notification service
+}
+
+// VersionConfig is the default retention policy of tenants that have not
+// set their own; all zeros keeps every version
+type VersionConfig struct {
+	KeepLast      int // newest versions kept
+	KeepDays      int // days every version is kept
+	DailyDays     int // days the newest version of each day is kept
+	WeeklyDays    int // days the newest version of each week is kept
+	PruneInterval time.Duration
+}
+
+// ArchiveConfig limits archive extraction, against archives that expand to
+// far more than they take up
+type ArchiveConfig struct {
+	MaxEntries       int   // files and folders in an archive
+	MaxExtractedSize int64 // bytes extracted from an archive
+	MaxRatio         int   // bytes extracted per byte of archive
+	PollInterval     time.Duration
+}
+
+// LockConfig sets how long file locks and check-outs last unless their
+// holders ask for less
+type LockConfig struct {
+	DefaultDuration  time.Duration
+	CheckOutDuration time.Duration
+	MaxDuration      time.Duration
+}
+
+// ActivityConfig is how long activity is kept in tenants that have not set
+// their own retention; 0 keeps it forever
+type ActivityConfig struct {
+	RetentionDays int
+	PruneInterval time.Duration
 }
 
 func Load() (*Config, error) {
@@ -66,6 +166,108 @@ func Load() (*Config, error) {
 
 	maxUploadSize, _ := strconv.ParseInt(getEnv("MAX_UPLOAD_SIZE", "104857600"), 10, 64)
 	chunkSize, _ := strconv.ParseInt(getEnv("CHUNK_SIZE", "5242880"), 10, 64)
+	maxResumableSize, _ := strconv.ParseInt(getEnv("MAX_RESUMABLE_UPLOAD_SIZE", "53687091200"), 10, 64)
+
+	sessionTTL, err := time.ParseDuration(getEnv("UPLOAD_SESSION_TTL", "24h"))
+	if err != nil {
+		sessionTTL = 24 * time.Hour
+	}
+	cleanupInterval, err := time.ParseDuration(getEnv("UPLOAD_CLEANUP_INTERVAL", "1h"))
+	if err != nil {
+		cleanupInterval = time.Hour
+	}
+
+	scrubInterval, err := time.ParseDuration(getEnv("SCRUB_INTERVAL", "6h"))
+	if err != nil {
+		scrubInterval = 6 * time.Hour
+	}
+	scrubBatchSize, _ := strconv.Atoi(getEnv("SCRUB_BATCH_SIZE", "100"))
+	gcGracePeriod, err := time.ParseDuration(getEnv("BLOB_GC_GRACE_PERIOD", "1h"))
+	if err != nil {
+		gcGracePeriod = time.Hour
+	}
+
+	permissionCleanupInterval, err := time.ParseDuration(getEnv("PERMISSION_CLEANUP_INTERVAL", "1h"))
+	if err != nil {
+		permissionCleanupInterval = time.Hour
+	}
+
+	shareAccessTTL, err := time.ParseDuration(getEnv("SHARE_ACCESS_TTL", "1h"))
+	if err != nil {
+		shareAccessTTL = time.Hour
+	}
+	sharePasswordAttempts, _ := strconv.Atoi(getEnv("SHARE_PASSWORD_MAX_ATTEMPTS", "5"))
+	sharePasswordLockout, err := time.ParseDuration(getEnv("SHARE_PASSWORD_LOCKOUT", "15m"))
+	if err != nil {
+		sharePasswordLockout = 15 * time.Minute
+	}
+
+	maxPreviewSourceSize, _ := strconv.ParseInt(getEnv("PREVIEW_MAX_SOURCE_SIZE", "52428800"), 10, 64)
+	previewPollInterval, err := time.ParseDuration(getEnv("PREVIEW_POLL_INTERVAL", "5s"))
+	if err != nil {
+		previewPollInterval = 5 * time.Second
+	}
+	previewBatchSize, _ := strconv.Atoi(getEnv("PREVIEW_BATCH_SIZE", "10"))
+
+	changeRetention, err := time.ParseDuration(getEnv("CHANGE_RETENTION", "720h"))
+	if err != nil {
+		changeRetention = 30 * 24 * time.Hour
+	}
+	changeCleanupInterval, err := time.ParseDuration(getEnv("CHANGE_CLEANUP_INTERVAL", "1h"))
+	if err != nil {
+		changeCleanupInterval = time.Hour
+	}
+	changeMaxWait, err := time.ParseDuration(getEnv("CHANGE_MAX_WAIT", "60s"))
+	if err != nil {
+		changeMaxWait = time.Minute
+	}
+	changePollInterval, err := time.ParseDuration(getEnv("CHANGE_POLL_INTERVAL", "5s"))
+	if err != nil {
+		changePollInterval = 5 * time.Second
+	}
+
+	userQuota, _ := strconv.ParseInt(getEnv("STORAGE_USER_QUOTA", "0"), 10, 64)
+	tenantQuota, _ := strconv.ParseInt(getEnv("STORAGE_TENANT_QUOTA", "0"), 10, 64)
+	quotaDeliveryInterval, err := time.ParseDuration(getEnv("QUOTA_WARNING_INTERVAL", "30s"))
+	if err != nil {
+		quotaDeliveryInterval = 30 * time.Second
+	}
+
+	versionKeepLast, _ := strconv.Atoi(getEnv("VERSION_KEEP_LAST", "0"))
+	versionKeepDays, _ := strconv.Atoi(getEnv("VERSION_KEEP_DAYS", "0"))
+	versionDailyDays, _ := strconv.Atoi(getEnv("VERSION_DAILY_DAYS", "0"))
+	versionWeeklyDays, _ := strconv.Atoi(getEnv("VERSION_WEEKLY_DAYS", "0"))
+	versionPruneInterval, err := time.ParseDuration(getEnv("VERSION_PRUNE_INTERVAL", "1h"))
+	if err != nil {
+		versionPruneInterval = time.Hour
+	}
+
+	archiveMaxEntries, _ := strconv.Atoi(getEnv("ARCHIVE_MAX_ENTRIES", "10000"))
+	archiveMaxExtractedSize, _ := strconv.ParseInt(getEnv("ARCHIVE_MAX_EXTRACTED_SIZE", "10737418240"), 10, 64)
+	archiveMaxRatio, _ := strconv.Atoi(getEnv("ARCHIVE_MAX_RATIO", "100"))
+	archivePollInterval, err := time.ParseDuration(getEnv("ARCHIVE_POLL_INTERVAL", "5s"))
+	if err != nil {
+		archivePollInterval = 5 * time.Second
+	}
+
+	lockDefaultDuration, err := time.ParseDuration(getEnv("LOCK_DEFAULT_DURATION", "30m"))
+	if err != nil {
+		lockDefaultDuration = 30 * time.Minute
+	}
+	lockCheckOutDuration, err := time.ParseDuration(getEnv("LOCK_CHECKOUT_DURATION", "168h"))
+	if err != nil {
+		lockCheckOutDuration = 7 * 24 * time.Hour
+	}
+	lockMaxDuration, err := time.ParseDuration(getEnv("LOCK_MAX_DURATION", "720h"))
+	if err != nil {
+		lockMaxDuration = 30 * 24 * time.Hour
+	}
+
+	activityRetentionDays, _ := strconv.Atoi(getEnv("ACTIVITY_RETENTION_DAYS", "365"))
+	activityPruneInterval, err := time.ParseDuration(getEnv("ACTIVITY_PRUNE_INTERVAL", "1h"))
+	if err != nil {
+		activityPruneInterval = time.Hour
+	}
 
 	config := &Config{
 		Server: ServerConfig{
@@ -80,6 +282,10 @@ func Load() (*Config, error) {
 			DBName:   getEnv("DB_NAME", "nexus_drive"),
 			SSLMode:  getEnv("DB_SSLMODE", "disable"),
 		},
+		Storage: StorageConfig{
+			Driver:    getEnv("STORAGE_DRIVER", "minio"),
+			LocalPath: getEnv("STORAGE_LOCAL_PATH", "./data"),
+		},
 		MinIO: MinIOConfig{
 			Endpoint:  getEnv("MINIO_ENDPOINT", "localhost:9000"),
 			AccessKey: getEnv("MINIO_ACCESS_KEY", "minioadmin"),
@@ -98,8 +304,70 @@ func Load() (*Config, error) {
 			},
 		},
 		Upload: UploadConfig{
-			MaxUploadSize: maxUploadSize,
-			ChunkSize:     chunkSize,
+			MaxUploadSize:    maxUploadSize,
+			ChunkSize:        chunkSize,
+			MaxResumableSize: maxResumableSize,
+			SessionTTL:       sessionTTL,
+			CleanupInterval:  cleanupInterval,
+		},
+		Blobs: BlobConfig{
+			ScrubInterval:  scrubInterval,
+			ScrubBatchSize: scrubBatchSize,
+			GCGracePeriod:  gcGracePeriod,
+		},
+		Permissions: PermissionConfig{
+			CleanupInterval: permissionCleanupInterval,
+		},
+		Share: ShareConfig{
+			TokenSecret:         getEnv("SHARE_TOKEN_SECRET", getEnv("JWT_SECRET", "your-secret-key")),
+			AccessTTL:           shareAccessTTL,
+			MaxPasswordAttempts: sharePasswordAttempts,
+			PasswordLockout:     sharePasswordLockout,
+		},
+		Previews: PreviewConfig{
+			ThumbnailSizes: parsePositiveInts(getEnv("PREVIEW_THUMBNAIL_SIZES", "128,256,1024")),
+			MaxSourceSize:  maxPreviewSourceSize,
+			PollInterval:   previewPollInterval,
+			BatchSize:      previewBatchSize,
+		},
+		Search: SearchConfig{
+			Language: getEnv("SEARCH_LANGUAGE", "english"),
+		},
+		Changes: ChangeConfig{
+			Retention:       changeRetention,
+			CleanupInterval: changeCleanupInterval,
+			MaxWait:         changeMaxWait,
+			PollInterval:    changePollInterval,
+		},
+		Quotas: QuotaConfig{
+			UserQuota:         userQuota,
+			TenantQuota:       tenantQuota,
+			WarningThresholds: parsePositiveInts(getEnv("QUOTA_WARNING_THRESHOLDS", "80,90,100")),
+			NotificationURL:   getEnv("NOTIFICATION_SERVICE_URL", ""),
+			InternalAPIKey:    getEnv("INTERNAL_API_KEY", ""),
+			DeliveryInterval:  quotaDeliveryInterval,
+		},
+		Versions: VersionConfig{
+			KeepLast:      max(versionKeepLast, 0),
+			KeepDays:      max(versionKeepDays, 0),
+			DailyDays:     max(versionDailyDays, 0),
+			WeeklyDays:    max(versionWeeklyDays, 0),
+			PruneInterval: versionPruneInterval,
+		},
+		Archives: ArchiveConfig{
+			MaxEntries:       archiveMaxEntries,
+			MaxExtractedSize: archiveMaxExtractedSize,
+			MaxRatio:         archiveMaxRatio,
+			PollInterval:     archivePollInterval,
+		},
+		Locks: LockConfig{
+			DefaultDuration:  lockDefaultDuration,
+			CheckOutDuration: lockCheckOutDuration,
+			MaxDuration:      lockMaxDuration,
+		},
+		Activity: ActivityConfig{
+			RetentionDays: activityRetentionDays,
+			PruneInterval: activityPruneInterval,
 		},
 	}
 
@@ -120,3 +388,21 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// parsePositiveInts parses a comma-separated list of numbers, such as sizes
+// in pixels, ignoring anything that is not a positive number, and returns
+// them in ascending order
+func parsePositiveInts(value string) []int {
+	seen := make(map[int]bool)
+	var numbers []int
+	for _, field := range strings.Split(value, ",") {
+		number, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || number <= 0 || seen[number] {
+			continue
+		}
+		seen[number] = true
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
@@ -0,0 +1,390 @@
+package dav
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+)
+
+// fakeDrive is a drive service holding each user's folders and files in
+// memory. Deleted items are moved to the trash.
+type fakeDrive struct {
+	service.DriveService
+
+	mu      sync.Mutex
+	clock   time.Time
+	folders []*model.Folder
+	files   []*model.File
+	content map[uuid.UUID][]byte
+	trash   []string
+}
+
+func newFakeDrive() *fakeDrive {
+	return &fakeDrive{clock: time.Now(), content: make(map[uuid.UUID][]byte)}
+}
+
+func (d *fakeDrive) now() time.Time {
+	d.clock = d.clock.Add(time.Second)
+	return d.clock
+}
+
+func sameFolder(a, b *uuid.UUID) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func (d *fakeDrive) ResolvePath(ctx context.Context, tenantID, userID uuid.UUID, p string) (*model.FileInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p = path.Clean("/" + p)
+	info := &model.FileInfo{Type: "folder", Path: p}
+	if p == "/" {
+		return info, nil
+	}
+
+	names := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	var parentID *uuid.UUID
+	for i, name := range names {
+		if folder := d.folderByName(userID, parentID, name); folder != nil {
+			info.Folder = folder
+			parentID = &folder.ID
+			continue
+		}
+		if i < len(names)-1 {
+			return nil, service.ErrPathNotFound
+		}
+		if file := d.fileByName(userID, parentID, name); file != nil {
+			return &model.FileInfo{File: file, Type: "file", Path: p}, nil
+		}
+		return nil, service.ErrPathNotFound
+	}
+	return info, nil
+}
+
+// folderByName and fileByName return the oldest item of a name, as the
+// repositories do
+func (d *fakeDrive) folderByName(userID uuid.UUID, parentID *uuid.UUID, name string) *model.Folder {
+	for _, folder := range d.folders {
+		if folder.OwnerID == userID && sameFolder(folder.ParentID, parentID) && folder.Name == name {
+			return folder
+		}
+	}
+	return nil
+}
+
+func (d *fakeDrive) fileByName(userID uuid.UUID, folderID *uuid.UUID, name string) *model.File {
+	for _, file := range d.files {
+		if file.OwnerID == userID && sameFolder(file.FolderID, folderID) && file.Name == name {
+			return file
+		}
+	}
+	return nil
+}
+
+func (d *fakeDrive) CreateFolder(ctx context.Context, tenantID, userID uuid.UUID, req *model.CreateFolderRequest) (*model.Folder, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	folder := &model.Folder{ID: uuid.New(), TenantID: tenantID, OwnerID: userID, ParentID: req.ParentID, Name: req.Name, CreatedAt: now, UpdatedAt: now}
+	d.folders = append(d.folders, folder)
+	return folder, nil
+}
+
+func (d *fakeDrive) UploadFile(ctx context.Context, tenantID, userID uuid.UUID, folderID *uuid.UUID, filename string, reader io.Reader, size int64, contentType string) (*model.File, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	file := &model.File{ID: uuid.New(), TenantID: tenantID, OwnerID: userID, FolderID: folderID, Name: filename, MimeType: "text/plain", Version: 1, CreatedAt: now}
+	d.setContent(file, content)
+	d.files = append(d.files, file)
+	return file, nil
+}
+
+func (d *fakeDrive) UpdateFileContent(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, reader io.Reader, size int64, contentType, ifMatch string) (*model.File, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, file := range d.files {
+		if file.ID == fileID {
+			file.Version++
+			d.setContent(file, content)
+			return file, nil
+		}
+	}
+	return nil, service.ErrPathNotFound
+}
+
+func (d *fakeDrive) setContent(file *model.File, content []byte) {
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	file.Size, file.Checksum, file.UpdatedAt = int64(len(content)), &checksum, d.now()
+	d.content[file.ID] = content
+}
+
+func (d *fakeDrive) DownloadFileRange(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, offset, length int64) (io.ReadCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	content := d.content[fileID][offset:]
+	if length >= 0 {
+		content = content[:length]
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (d *fakeDrive) ListFolders(ctx context.Context, tenantID, userID uuid.UUID, parentID *uuid.UUID, includeShared bool) ([]*model.Folder, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var folders []*model.Folder
+	for _, folder := range d.folders {
+		if folder.OwnerID == userID && sameFolder(folder.ParentID, parentID) {
+			folders = append(folders, folder)
+		}
+	}
+	return folders, nil
+}
+
+func (d *fakeDrive) ListFiles(ctx context.Context, tenantID, userID uuid.UUID, folderID *uuid.UUID, includeShared bool) ([]*model.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var files []*model.File
+	for _, file := range d.files {
+		if file.OwnerID == userID && sameFolder(file.FolderID, folderID) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func (d *fakeDrive) RenameFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, folderID *uuid.UUID, name string) (*model.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, file := range d.files {
+		if file.ID == fileID {
+			file.FolderID, file.Name = folderID, name
+			return file, nil
+		}
+	}
+	return nil, service.ErrPathNotFound
+}
+
+func (d *fakeDrive) DeleteFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, file := range d.files {
+		if file.ID == fileID {
+			d.files = append(d.files[:i], d.files[i+1:]...)
+			d.trash = append(d.trash, file.Name)
+			return nil
+		}
+	}
+	return service.ErrPathNotFound
+}
+
+// serveDAV makes a WebDAV request as a user
+func serveDAV(h *Handler, userID uuid.UUID, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/dav"+target, strings.NewReader(body))
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	ctx := context.WithValue(req.Context(), "tenant_id", uuid.Nil)
+	ctx = context.WithValue(ctx, "user_id", userID)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req.WithContext(ctx))
+	return rec
+}
+
+func TestWebDAVFiles(t *testing.T) {
+	drive := newFakeDrive()
+	h := NewHandler(drive, "/dav", 1<<20)
+	alice := uuid.New()
+
+	if rec := serveDAV(h, alice, "MKCOL", "/docs", "", nil); rec.Code != http.StatusCreated {
+		t.Fatalf("MKCOL = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if rec := serveDAV(h, alice, "MKCOL", "/docs", "", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("MKCOL of an existing folder = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if rec := serveDAV(h, alice, "PUT", "/missing/a.txt", "hello", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("PUT into a missing folder = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	if rec := serveDAV(h, alice, "PUT", "/docs/a.txt", "hello world", nil); rec.Code != http.StatusCreated {
+		t.Fatalf("PUT = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	file := drive.files[0]
+
+	rec := serveDAV(h, alice, "GET", "/docs/a.txt", "", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello world" {
+		t.Fatalf("GET = %d %q, want the content", rec.Code, rec.Body.String())
+	}
+	if etag := rec.Header().Get("ETag"); etag != `"`+*file.Checksum+`"` {
+		t.Errorf("ETag = %s, want the checksum", etag)
+	}
+	rec = serveDAV(h, alice, "GET", "/docs/a.txt", "", map[string]string{"Range": "bytes=6-"})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "world" {
+		t.Errorf("ranged GET = %d %q, want %q", rec.Code, rec.Body.String(), "world")
+	}
+
+	// Overwriting adds a version of the same file
+	if rec := serveDAV(h, alice, "PUT", "/docs/a.txt", "hello again", nil); rec.Code != http.StatusCreated {
+		t.Fatalf("overwriting PUT = %d", rec.Code)
+	}
+	if len(drive.files) != 1 || file.Version != 2 || string(drive.content[file.ID]) != "hello again" {
+		t.Errorf("after overwriting there are %d files, version %d, want one file at version 2", len(drive.files), file.Version)
+	}
+
+	if rec := serveDAV(h, alice, "MOVE", "/docs/a.txt", "", map[string]string{"Destination": "/dav/b.txt"}); rec.Code != http.StatusCreated {
+		t.Fatalf("MOVE = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if file.FolderID != nil || file.Name != "b.txt" {
+		t.Errorf("moved file is %q in %v, want b.txt at the root", file.Name, file.FolderID)
+	}
+
+	if rec := serveDAV(h, alice, "DELETE", "/b.txt", "", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if len(drive.trash) != 1 || drive.trash[0] != "b.txt" {
+		t.Errorf("trash = %v, want the deleted file", drive.trash)
+	}
+}
+
+func TestWebDAVListing(t *testing.T) {
+	drive := newFakeDrive()
+	h := NewHandler(drive, "/dav", 1<<20)
+	alice, bob := uuid.New(), uuid.New()
+	ctx := context.Background()
+
+	// Of items with the same name only the oldest can be addressed, and a
+	// folder hides a file of its name
+	_, _ = drive.CreateFolder(ctx, uuid.Nil, alice, &model.CreateFolderRequest{Name: "reports"})
+	for _, name := range []string{"reports", "a.txt", "a.txt", "b/c.txt"} {
+		if _, err := drive.UploadFile(ctx, uuid.Nil, alice, nil, name, strings.NewReader(name), -1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldest := drive.files[1]
+
+	rec := serveDAV(h, alice, "PROPFIND", "/", "", map[string]string{"Depth": "1"})
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND = %d, want %d", rec.Code, http.StatusMultiStatus)
+	}
+	body := rec.Body.String()
+	for _, href := range []string{"<D:href>/dav/reports/</D:href>", "<D:href>/dav/a.txt</D:href>"} {
+		if n := strings.Count(body, href); n != 1 {
+			t.Errorf("%s listed %d times, want once", href, n)
+		}
+	}
+	if strings.Contains(body, "c.txt") {
+		t.Error("a file whose name has a slash was listed")
+	}
+	if !strings.Contains(body, fmt.Sprintf(`"%s"`, *oldest.Checksum)) {
+		t.Error("the listed a.txt is not the oldest")
+	}
+
+	// Each user sees their own drive
+	rec = serveDAV(h, bob, "PROPFIND", "/", "", map[string]string{"Depth": "1"})
+	if strings.Contains(rec.Body.String(), "a.txt") {
+		t.Error("another user's files were listed")
+	}
+}
+
+func TestWebDAVLocksArePerUser(t *testing.T) {
+	drive := newFakeDrive()
+	h := NewHandler(drive, "/dav", 1<<20)
+	alice, bob := uuid.New(), uuid.New()
+	lockBody := `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+
+	rec := serveDAV(h, alice, "LOCK", "/a.txt", lockBody, map[string]string{"Timeout": "Second-60"})
+	if rec.Code != http.StatusCreated && rec.Code != http.StatusOK {
+		t.Fatalf("LOCK = %d", rec.Code)
+	}
+	token := rec.Header().Get("Lock-Token")
+
+	if rec := serveDAV(h, alice, "PUT", "/a.txt", "without the lock", nil); rec.Code != http.StatusLocked {
+		t.Errorf("PUT without the lock token = %d, want %d", rec.Code, http.StatusLocked)
+	}
+	if rec := serveDAV(h, alice, "PUT", "/a.txt", "with the lock", map[string]string{"If": "(" + token + ")"}); rec.Code != http.StatusCreated {
+		t.Errorf("PUT with the lock token = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	// The same path in another user's drive is another file
+	if rec := serveDAV(h, bob, "PUT", "/a.txt", "bob's file", nil); rec.Code != http.StatusCreated {
+		t.Errorf("another user's PUT = %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
+
+func TestWebDAVUploadLength(t *testing.T) {
+	drive := newFakeDrive()
+	h := NewHandler(drive, "/dav", 10)
+	alice := uuid.New()
+
+	if rec := serveDAV(h, alice, "PUT", "/big.txt", "more than ten bytes", nil); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT of a file too large = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	// An upload cut short is not stored
+	req := httptest.NewRequest("PUT", "/dav/short.txt", strings.NewReader("short"))
+	req.ContentLength = 8
+	ctx := context.WithValue(context.WithValue(req.Context(), "tenant_id", uuid.Nil), "user_id", alice)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req.WithContext(ctx))
+	if rec.Code == http.StatusCreated || len(drive.files) != 0 {
+		t.Errorf("PUT cut short = %d with %d files stored, want it refused", rec.Code, len(drive.files))
+	}
+}
+
+func TestExactReader(t *testing.T) {
+	tests := []struct {
+		content string
+		length  int64
+		wantErr bool
+	}{
+		{"hello", 5, false},
+		{"hell", 5, true},
+		{"hello!", 5, true},
+	}
+	for _, tt := range tests {
+		got, err := io.ReadAll(&exactReader{r: strings.NewReader(tt.content), remaining: tt.length})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q of length %d: got error %v", tt.content, tt.length, err)
+		}
+		if !tt.wantErr && string(got) != tt.content {
+			t.Errorf("read %q, want %q", got, tt.content)
+		}
+		if errors.Is(err, io.EOF) {
+			t.Errorf("%q of length %d: io.EOF returned as an error", tt.content, tt.length)
+		}
+	}
+}
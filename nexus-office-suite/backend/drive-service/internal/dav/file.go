@@ -0,0 +1,296 @@
+package dav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"golang.org/x/net/webdav"
+)
+
+var (
+	errIsFolder  = errors.New("is a folder")
+	errNotFolder = errors.New("not a folder")
+	errReadOnly  = errors.New("file is open for reading")
+	errWriteOnly = errors.New("file is open for writing")
+)
+
+// fileInfo describes a folder or file. Files report their MIME type and
+// checksum, so that listing a folder does not read every file in it.
+type fileInfo struct {
+	name     string
+	size     int64
+	modTime  time.Time
+	isDir    bool
+	mimeType string
+	checksum *string
+}
+
+func statInfo(info *model.FileInfo) os.FileInfo {
+	switch {
+	case info.File != nil:
+		return fileInfoOf(info.File)
+	case info.Folder != nil:
+		return folderInfo(info.Folder)
+	default:
+		return &fileInfo{name: "/", isDir: true}
+	}
+}
+
+func folderInfo(folder *model.Folder) *fileInfo {
+	return &fileInfo{name: folder.Name, modTime: folder.UpdatedAt, isDir: true}
+}
+
+func fileInfoOf(file *model.File) *fileInfo {
+	return &fileInfo{
+		name:     file.Name,
+		size:     file.Size,
+		modTime:  file.UpdatedAt,
+		mimeType: file.MimeType,
+		checksum: file.Checksum,
+	}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.isDir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ContentType implements webdav.ContentTyper
+func (fi *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.mimeType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.mimeType, nil
+}
+
+// ETag implements webdav.ETager. Files with a checksum are tagged with it;
+// the webdav package tags others by size and modification time.
+func (fi *fileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.checksum == nil {
+		return "", webdav.ErrNotImplemented
+	}
+	return fmt.Sprintf(`"%s"`, *fi.checksum), nil
+}
+
+// dir is an open folder, or the root
+type dir struct {
+	fs      *FileSystem
+	ctx     context.Context
+	info    *model.FileInfo
+	entries []os.FileInfo
+	listed  bool
+}
+
+func (d *dir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.listed {
+		var folderID *uuid.UUID
+		if d.info.Folder != nil {
+			folderID = &d.info.Folder.ID
+		}
+		entries, err := d.fs.readdir(d.ctx, folderID)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.listed = entries, true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *dir) Stat() (os.FileInfo, error)                   { return statInfo(d.info), nil }
+func (d *dir) Read(p []byte) (int, error)                   { return 0, errIsFolder }
+func (d *dir) Seek(offset int64, whence int) (int64, error) { return 0, errIsFolder }
+func (d *dir) Write(p []byte) (int, error)                  { return 0, errIsFolder }
+func (d *dir) Close() error                                 { return nil }
+
+// reader is a file open for reading. Content is downloaded from the current
+// offset on the first read after opening or seeking, so serving a range
+// only downloads that range.
+type reader struct {
+	fs     *FileSystem
+	ctx    context.Context
+	file   *model.File
+	userID uuid.UUID
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.offset >= r.file.Size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.fs.service.DownloadFileRange(r.ctx, r.file.ID, r.userID, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.file.Size
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *reader) Close() error {
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
+
+func (r *reader) Stat() (os.FileInfo, error)               { return fileInfoOf(r.file), nil }
+func (r *reader) Readdir(count int) ([]os.FileInfo, error) { return nil, errNotFolder }
+func (r *reader) Write(p []byte) (int, error)              { return 0, errReadOnly }
+
+// writer is a file open for writing. What is written is streamed to an
+// upload, which stores the file when the writer is closed.
+type writer struct {
+	name    string
+	pw      *io.PipeWriter
+	written int64
+	done    chan struct{}
+	file    *model.File
+	err     error
+}
+
+// newWriter starts upload reading what is written to the returned writer.
+// If the request uploading the content declared its length, upload is
+// given the length, and content of another length is refused.
+func newWriter(ctx context.Context, name string, upload func(r io.Reader, size int64) (*model.File, error)) *writer {
+	pr, pw := io.Pipe()
+	w := &writer{name: name, pw: pw, done: make(chan struct{})}
+
+	size := int64(-1)
+	var content io.Reader = pr
+	if length, ok := ctx.Value(contentLengthKey{}).(int64); ok {
+		size = length
+		content = &exactReader{r: pr, remaining: length}
+	}
+
+	go func() {
+		defer close(w.done)
+		w.file, w.err = upload(content, size)
+		// Fail writes the upload will not read
+		pr.CloseWithError(w.err)
+	}()
+
+	return w
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.pw.Write(p)
+	w.written += int64(n)
+	if err != nil {
+		<-w.done
+		if w.err != nil {
+			return n, w.err
+		}
+	}
+	return n, err
+}
+
+// Close finishes the upload and returns its error
+func (w *writer) Close() error {
+	w.pw.Close()
+	<-w.done
+	return w.err
+}
+
+func (w *writer) Stat() (os.FileInfo, error) {
+	return &writtenInfo{fileInfo: fileInfo{name: w.name, size: w.written, modTime: time.Now()}, w: w}, nil
+}
+
+func (w *writer) Read(p []byte) (int, error)                   { return 0, errWriteOnly }
+func (w *writer) Seek(offset int64, whence int) (int64, error) { return 0, errWriteOnly }
+func (w *writer) Readdir(count int) ([]os.FileInfo, error)     { return nil, errNotFolder }
+
+// writtenInfo describes a file being written. Its ETag is known once the
+// writer is closed.
+type writtenInfo struct {
+	fileInfo
+	w *writer
+}
+
+func (fi *writtenInfo) ETag(ctx context.Context) (string, error) {
+	select {
+	case <-fi.w.done:
+		if fi.w.file != nil {
+			return fileInfoOf(fi.w.file).ETag(ctx)
+		}
+	default:
+	}
+	return "", webdav.ErrNotImplemented
+}
+
+// exactReader reads exactly remaining bytes from r, failing if r ends
+// early or has more, so that an interrupted upload is not stored cut short
+type exactReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	if e.remaining <= 0 {
+		// Check that the content ends here
+		var b [1]byte
+		if n, _ := e.r.Read(b[:]); n > 0 {
+			return 0, errors.New("content is longer than its declared length")
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > e.remaining {
+		p = p[:e.remaining]
+	}
+	n, err := e.r.Read(p)
+	e.remaining -= int64(n)
+	if err == io.EOF && e.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,244 @@
+// Package dav serves users' drives over WebDAV, so that they can be mounted
+// in file managers and synced with tools such as rclone.
+package dav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+	"golang.org/x/net/webdav"
+)
+
+// FileSystem is a webdav.FileSystem over the drive of the user
+// authenticated on each request. Paths are the names of folders and files
+// from the root of the user's drive; see service.ResolvePath. Deleted
+// items go to the trash, and overwriting a file adds a version.
+type FileSystem struct {
+	service service.DriveService
+}
+
+func NewFileSystem(driveService service.DriveService) *FileSystem {
+	return &FileSystem{service: driveService}
+}
+
+// Mkdir creates a folder. Its parent must exist.
+func (fs *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	tenantID, userID := getTenantAndUserID(ctx)
+
+	if _, err := fs.service.ResolvePath(ctx, tenantID, userID, name); err == nil {
+		return pathError("mkdir", name, os.ErrExist)
+	}
+	parentID, err := fs.resolveFolder(ctx, path.Dir(name))
+	if err != nil {
+		return pathError("mkdir", name, err)
+	}
+
+	_, err = fs.service.CreateFolder(ctx, tenantID, userID, &model.CreateFolderRequest{
+		Name:     path.Base(name),
+		ParentID: parentID,
+	})
+	return pathError("mkdir", name, err)
+}
+
+// OpenFile opens a folder or file. Files can be read, or written from the
+// start, which replaces their content when closed; a file that does not
+// exist is created when closed if flag includes os.O_CREATE.
+func (fs *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	tenantID, userID := getTenantAndUserID(ctx)
+	writing := flag&(os.O_WRONLY|os.O_RDWR) != 0
+
+	info, err := fs.service.ResolvePath(ctx, tenantID, userID, name)
+	if errors.Is(err, service.ErrPathNotFound) && flag&os.O_CREATE != 0 {
+		folderID, err := fs.resolveFolder(ctx, path.Dir(name))
+		if err != nil {
+			return nil, pathError("open", name, err)
+		}
+		return newWriter(ctx, path.Base(name), func(r io.Reader, size int64) (*model.File, error) {
+			return fs.service.UploadFile(ctx, tenantID, userID, folderID, path.Base(name), r, size, "")
+		}), nil
+	}
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	switch {
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, pathError("open", name, os.ErrExist)
+	case info.File == nil && writing:
+		return nil, pathError("open", name, errIsFolder)
+	case info.File == nil:
+		return &dir{fs: fs, ctx: ctx, info: info}, nil
+	case writing && flag&os.O_TRUNC == 0:
+		// Content is stored whole, so it can only be replaced
+		return nil, pathError("open", name, os.ErrInvalid)
+	case writing:
+		file := info.File
+		return newWriter(ctx, file.Name, func(r io.Reader, size int64) (*model.File, error) {
+			return fs.service.UpdateFileContent(ctx, file.ID, userID, r, size, file.MimeType, "")
+		}), nil
+	default:
+		return &reader{fs: fs, ctx: ctx, file: info.File, userID: userID}, nil
+	}
+}
+
+// RemoveAll moves a folder or file to the trash
+func (fs *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	tenantID, userID := getTenantAndUserID(ctx)
+
+	info, err := fs.service.ResolvePath(ctx, tenantID, userID, name)
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+
+	switch {
+	case info.File != nil:
+		err = fs.service.DeleteFile(ctx, info.File.ID, userID)
+	case info.Folder != nil:
+		err = fs.service.DeleteFolder(ctx, info.Folder.ID, userID)
+	default:
+		err = service.ErrPermissionDenied
+	}
+	return pathError("remove", name, err)
+}
+
+// Rename moves and renames a folder or file. The destination's parent must
+// exist.
+func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	tenantID, userID := getTenantAndUserID(ctx)
+
+	info, err := fs.service.ResolvePath(ctx, tenantID, userID, oldName)
+	if err != nil {
+		return pathError("rename", oldName, err)
+	}
+	parentID, err := fs.resolveFolder(ctx, path.Dir(newName))
+	if err != nil {
+		return pathError("rename", newName, err)
+	}
+
+	switch {
+	case info.File != nil:
+		_, err = fs.service.RenameFile(ctx, info.File.ID, userID, parentID, path.Base(newName))
+	case info.Folder != nil:
+		_, err = fs.service.RenameFolder(ctx, info.Folder.ID, userID, parentID, path.Base(newName))
+	default:
+		err = service.ErrPermissionDenied
+	}
+	return pathError("rename", oldName, err)
+}
+
+func (fs *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	tenantID, userID := getTenantAndUserID(ctx)
+
+	info, err := fs.service.ResolvePath(ctx, tenantID, userID, name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return statInfo(info), nil
+}
+
+// resolveFolder returns the ID of the folder at a path, or nil for the root
+func (fs *FileSystem) resolveFolder(ctx context.Context, name string) (*uuid.UUID, error) {
+	tenantID, userID := getTenantAndUserID(ctx)
+
+	info, err := fs.service.ResolvePath(ctx, tenantID, userID, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.File != nil {
+		return nil, service.ErrPathNotFound
+	}
+	if info.Folder == nil {
+		return nil, nil
+	}
+	return &info.Folder.ID, nil
+}
+
+// readdir lists the folders and then the files in a folder, or in the root
+// if folderID is nil, leaving out those hidden by another item of the same
+// name as ResolvePath does
+func (fs *FileSystem) readdir(ctx context.Context, folderID *uuid.UUID) ([]os.FileInfo, error) {
+	tenantID, userID := getTenantAndUserID(ctx)
+
+	folders, err := fs.service.ListFolders(ctx, tenantID, userID, folderID, true)
+	if err != nil {
+		return nil, err
+	}
+	files, err := fs.service.ListFiles(ctx, tenantID, userID, folderID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	oldestFolders := make(map[string]*model.Folder)
+	for _, folder := range folders {
+		if oldest, ok := oldestFolders[folder.Name]; !ok || isOlder(folder.CreatedAt, folder.ID, oldest.CreatedAt, oldest.ID) {
+			oldestFolders[folder.Name] = folder
+		}
+	}
+	oldestFiles := make(map[string]*model.File)
+	for _, file := range files {
+		if _, ok := oldestFolders[file.Name]; ok {
+			continue
+		}
+		if oldest, ok := oldestFiles[file.Name]; !ok || isOlder(file.CreatedAt, file.ID, oldest.CreatedAt, oldest.ID) {
+			oldestFiles[file.Name] = file
+		}
+	}
+
+	var infos []os.FileInfo
+	for _, folder := range folders {
+		if oldestFolders[folder.Name] == folder && validName(folder.Name) {
+			infos = append(infos, folderInfo(folder))
+		}
+	}
+	for _, file := range files {
+		if oldestFiles[file.Name] == file && validName(file.Name) {
+			infos = append(infos, fileInfoOf(file))
+		}
+	}
+	return infos, nil
+}
+
+// getTenantAndUserID returns the user authenticated on a request
+func getTenantAndUserID(ctx context.Context) (uuid.UUID, uuid.UUID) {
+	tenantID, _ := ctx.Value("tenant_id").(uuid.UUID)
+	userID, _ := ctx.Value("user_id").(uuid.UUID)
+	return tenantID, userID
+}
+
+// pathError returns err as an *os.PathError, translating service errors
+// into the errors the webdav package maps to status codes
+func pathError(op, name string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, service.ErrPathNotFound):
+		err = os.ErrNotExist
+	case errors.Is(err, service.ErrPermissionDenied), errors.Is(err, service.ErrFileLocked):
+		err = os.ErrPermission
+	case errors.Is(err, service.ErrInvalidName), errors.Is(err, service.ErrInvalidMove):
+		err = os.ErrInvalid
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// isOlder orders items of the same name as ResolvePath does: by creation
+// time, then ID
+func isOlder(createdAt time.Time, id uuid.UUID, otherCreatedAt time.Time, otherID uuid.UUID) bool {
+	if !createdAt.Equal(otherCreatedAt) {
+		return createdAt.Before(otherCreatedAt)
+	}
+	return id.String() < otherID.String()
+}
+
+// validName reports whether an item can be addressed by a path
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
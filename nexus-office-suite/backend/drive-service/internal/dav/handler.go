@@ -0,0 +1,92 @@
+package dav
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/service"
+	"golang.org/x/net/webdav"
+)
+
+// contentLengthKey is the context key of the declared length of the
+// content of a PUT request
+type contentLengthKey struct{}
+
+// Handler serves users' drives over WebDAV to authenticated users. Each
+// user sees their own drive at the same paths, so locks are kept per user:
+// they stop a user's clients overwriting each other's changes. Locks are
+// held in memory and are lost on restart.
+type Handler struct {
+	prefix        string
+	fs            *FileSystem
+	maxUploadSize int64
+
+	mu    sync.Mutex
+	locks map[uuid.UUID]webdav.LockSystem
+}
+
+// NewHandler returns a Handler serving WebDAV under prefix. Files larger
+// than maxUploadSize cannot be uploaded.
+func NewHandler(driveService service.DriveService, prefix string, maxUploadSize int64) *Handler {
+	return &Handler{
+		prefix:        prefix,
+		fs:            NewFileSystem(driveService),
+		maxUploadSize: maxUploadSize,
+		locks:         make(map[uuid.UUID]webdav.LockSystem),
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, userID := getTenantAndUserID(r.Context())
+
+	// Transfers of large files take longer than the server's timeouts
+	controller := http.NewResponseController(w)
+	_ = controller.SetReadDeadline(time.Time{})
+	_ = controller.SetWriteDeadline(time.Time{})
+
+	if r.Method == http.MethodPut {
+		if r.ContentLength > h.maxUploadSize {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
+		if r.ContentLength >= 0 {
+			r = r.WithContext(context.WithValue(r.Context(), contentLengthKey{}, r.ContentLength))
+		}
+	}
+
+	dav := &webdav.Handler{
+		Prefix:     h.prefix,
+		FileSystem: h.fs,
+		LockSystem: h.lockSystem(userID),
+		Logger:     logError,
+	}
+	dav.ServeHTTP(w, r)
+}
+
+func (h *Handler) lockSystem(userID uuid.UUID) webdav.LockSystem {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	locks, ok := h.locks[userID]
+	if !ok {
+		locks = webdav.NewMemLS()
+		h.locks[userID] = locks
+	}
+	return locks
+}
+
+// logError logs failed requests, except for missing and forbidden paths,
+// which clients probe for routinely
+func logError(r *http.Request, err error) {
+	if err == nil || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) || errors.Is(err, os.ErrExist) {
+		return
+	}
+	log.Printf("WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+}
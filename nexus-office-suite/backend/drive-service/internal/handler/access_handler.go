@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+)
+
+// GetEffectiveAccess lists who can access a file or folder and why
+func (h *DriveHandler) GetEffectiveAccess(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	resourceID := getUUID(r, "resource_id")
+	resourceType := model.ResourceType(mux.Vars(r)["resource_type"])
+
+	access, err := h.service.GetEffectiveAccess(ctx, resourceID, resourceType, userID)
+	if err != nil {
+		respondError(w, accessErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, access)
+}
+
+// CreateGroup creates a group
+func (h *DriveHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+
+	var req model.CreateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	group, err := h.service.CreateGroup(ctx, tenantID, userID, &req)
+	if err != nil {
+		respondError(w, accessErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, group)
+}
+
+// ListGroups lists the tenant's groups
+func (h *DriveHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, _ := getTenantAndUserID(r)
+
+	groups, err := h.service.ListGroups(ctx, tenantID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, groups)
+}
+
+// GetGroup gets a group with its members
+func (h *DriveHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, _ := getTenantAndUserID(r)
+	groupID := getUUID(r, "id")
+
+	group, err := h.service.GetGroup(ctx, tenantID, groupID)
+	if err != nil {
+		respondError(w, accessErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, group)
+}
+
+// DeleteGroup deletes a group
+func (h *DriveHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+	groupID := getUUID(r, "id")
+
+	if err := h.service.DeleteGroup(ctx, tenantID, groupID, userID); err != nil {
+		respondError(w, accessErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Group deleted"})
+}
+
+// AddGroupMember adds a user to a group
+func (h *DriveHandler) AddGroupMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+	groupID := getUUID(r, "id")
+
+	var req model.AddGroupMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	group, err := h.service.AddGroupMember(ctx, tenantID, groupID, userID, &req)
+	if err != nil {
+		respondError(w, accessErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, group)
+}
+
+// RemoveGroupMember removes a user from a group
+func (h *DriveHandler) RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+	groupID := getUUID(r, "id")
+	memberID := getUUID(r, "user_id")
+
+	if err := h.service.RemoveGroupMember(ctx, tenantID, groupID, userID, memberID); err != nil {
+		respondError(w, accessErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Member removed"})
+}
+
+func accessErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrGroupNotFound), errors.Is(err, service.ErrPermissionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidPermission), errors.Is(err, service.ErrInvalidGroup):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nexus/drive-service/internal/middleware"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+)
+
+// GetFileActivity returns the activity of a file
+func (h *DriveHandler) GetFileActivity(w http.ResponseWriter, r *http.Request) {
+	fileID := getUUID(r, "id")
+	h.listActivity(w, r, &model.ActivityQuery{FileID: &fileID})
+}
+
+// GetFolderActivity returns the activity of a folder and of everything
+// that is or was inside it
+func (h *DriveHandler) GetFolderActivity(w http.ResponseWriter, r *http.Request) {
+	folderID := getUUID(r, "id")
+	h.listActivity(w, r, &model.ActivityQuery{FolderID: &folderID})
+}
+
+// GetMyActivity returns what the user did
+func (h *DriveHandler) GetMyActivity(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	h.listActivity(w, r, &model.ActivityQuery{ActorID: &userID})
+}
+
+// GetUserActivity returns what a user of the tenant did. Only
+// administrators may see it.
+func (h *DriveHandler) GetUserActivity(w http.ResponseWriter, r *http.Request) {
+	actorID := getUUID(r, "user_id")
+	h.listActivity(w, r, &model.ActivityQuery{ActorID: &actorID})
+}
+
+// GetTenantActivity returns the activity of the whole tenant, optionally
+// of one user selected with user_id. Only administrators may see it.
+func (h *DriveHandler) GetTenantActivity(w http.ResponseWriter, r *http.Request) {
+	actorID, err := queryUUID(r, "user_id")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user_id")
+		return
+	}
+	h.listActivity(w, r, &model.ActivityQuery{ActorID: actorID})
+}
+
+// listActivity responds with a page of the activity query selects, further
+// narrowed by the action, since, until, cursor and limit parameters, or
+// with all of it as a CSV or JSON attachment if format is set
+func (h *DriveHandler) listActivity(w http.ResponseWriter, r *http.Request, query *model.ActivityQuery) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+
+	if err := parseActivityQuery(r, query); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.Admin = middleware.HasRole(ctx, "admin")
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		page, err := h.service.ListActivity(ctx, tenantID, userID, query)
+		if err != nil {
+			respondError(w, activityErrorStatus(err), err.Error())
+			return
+		}
+
+		respondJSON(w, http.StatusOK, page)
+		return
+	}
+
+	write, err := h.service.ExportActivity(ctx, tenantID, userID, query, format)
+	if err != nil {
+		respondError(w, activityErrorStatus(err), err.Error())
+		return
+	}
+
+	// Exports of a whole tenant take longer than the server's write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	name := fmt.Sprintf("activity-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	contentType := "application/json"
+	if format == "csv" {
+		contentType = "text/csv; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	if err := write(w); err != nil {
+		// The response has started, so the client sees a truncated export
+		log.Printf("Failed to export activity: %v", err)
+	}
+}
+
+// parseActivityQuery reads the filters common to all activity requests
+func parseActivityQuery(r *http.Request, query *model.ActivityQuery) error {
+	values := r.URL.Query()
+
+	for _, action := range values["action"] {
+		query.Actions = append(query.Actions, model.ActivityAction(action))
+	}
+
+	for _, key := range []string{"since", "until"} {
+		value := values.Get(key)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s: must be an RFC 3339 time", key)
+		}
+		if key == "since" {
+			query.Since = &t
+		} else {
+			query.Until = &t
+		}
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return errors.New("Invalid limit")
+		}
+		query.Limit = limit
+	}
+
+	query.Cursor = values.Get("cursor")
+	return nil
+}
+
+// GetActivityRetention returns how long the tenant keeps its activity
+func (h *DriveHandler) GetActivityRetention(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, _ := getTenantAndUserID(r)
+
+	policy, err := h.service.GetActivityRetention(ctx, tenantID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+// SetActivityRetention sets how long the tenant keeps its activity. Only
+// administrators may set it.
+func (h *DriveHandler) SetActivityRetention(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+
+	var req model.SetActivityRetentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	policy, err := h.service.SetActivityRetention(ctx, tenantID, userID, &req)
+	if err != nil {
+		respondError(w, activityErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+func activityErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidActivityQuery), errors.Is(err, service.ErrInvalidCursor),
+		errors.Is(err, service.ErrInvalidRetention):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrPermissionDenied):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
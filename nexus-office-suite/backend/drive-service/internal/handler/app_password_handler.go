@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+)
+
+// CreateAppPassword creates an app password for signing in to WebDAV. The
+// password is only returned in this response.
+func (h *DriveHandler) CreateAppPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+	email, _ := ctx.Value("email").(string)
+
+	var req model.CreateAppPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	appPassword, err := h.service.CreateAppPassword(ctx, tenantID, userID, email, &req)
+	if err != nil {
+		respondError(w, appPasswordErrorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusCreated, appPassword)
+}
+
+// ListAppPasswords lists the user's app passwords
+func (h *DriveHandler) ListAppPasswords(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+
+	appPasswords, err := h.service.ListAppPasswords(ctx, tenantID, userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, appPasswords)
+}
+
+// DeleteAppPassword revokes one of the user's app passwords
+func (h *DriveHandler) DeleteAppPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+	appPasswordID := getUUID(r, "id")
+
+	if err := h.service.DeleteAppPassword(ctx, tenantID, userID, appPasswordID); err != nil {
+		respondError(w, appPasswordErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "App password revoked"})
+}
+
+func appPasswordErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrAppPasswordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidAppPassword):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
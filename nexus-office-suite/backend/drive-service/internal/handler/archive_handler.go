@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+)
+
+// DownloadFolder streams a folder and everything in it as a zip archive
+func (h *DriveHandler) DownloadFolder(w http.ResponseWriter, r *http.Request) {
+	h.downloadArchive(w, r, nil, []uuid.UUID{getUUID(r, "id")})
+}
+
+// DownloadSelection streams the files and folders selected with repeated
+// file_id and folder_id parameters as a zip archive
+func (h *DriveHandler) DownloadSelection(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var fileIDs, folderIDs []uuid.UUID
+	for _, value := range query["file_id"] {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid file_id")
+			return
+		}
+		fileIDs = append(fileIDs, id)
+	}
+	for _, value := range query["folder_id"] {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid folder_id")
+			return
+		}
+		folderIDs = append(folderIDs, id)
+	}
+
+	h.downloadArchive(w, r, fileIDs, folderIDs)
+}
+
+func (h *DriveHandler) downloadArchive(w http.ResponseWriter, r *http.Request, fileIDs, folderIDs []uuid.UUID) {
+	ctx := r.Context()
+	userID := getUserID(r)
+
+	name, write, err := h.service.DownloadArchive(ctx, userID, fileIDs, folderIDs)
+	if err != nil {
+		respondError(w, archiveErrorStatus(err), err.Error())
+		return
+	}
+
+	// Archives of large folders take longer than the server's write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	if err := write(w); err != nil {
+		// The response has started, so the client sees a truncated archive
+		log.Printf("Failed to stream archive %s: %v", name, err)
+	}
+}
+
+// ExtractArchive queues a .zip, .tar.gz or .tar file to be extracted into a
+// new folder and returns the extraction, whose progress can be polled
+func (h *DriveHandler) ExtractArchive(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+	fileID := getUUID(r, "id")
+
+	var req model.ExtractArchiveRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	extraction, err := h.service.ExtractArchive(ctx, tenantID, userID, fileID, &req)
+	if err != nil {
+		respondError(w, archiveErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusAccepted, extraction)
+}
+
+// GetArchiveExtraction returns the status and progress of an extraction
+func (h *DriveHandler) GetArchiveExtraction(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	extractionID := getUUID(r, "id")
+
+	extraction, err := h.service.GetArchiveExtraction(ctx, extractionID, userID)
+	if err != nil {
+		respondError(w, archiveErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, extraction)
+}
+
+func archiveErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidArchive), errors.Is(err, service.ErrInvalidName):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrExtractionNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -53,6 +55,10 @@ func (h *DriveHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if !h.checkIfNoneMatch(w, r, folderID, header.Filename) {
+		return
+	}
+
 	// Upload file
 	uploadedFile, err := h.service.UploadFile(
 		ctx,
@@ -65,11 +71,11 @@ func (h *DriveHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		header.Header.Get("Content-Type"),
 	)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, fileErrorStatus(err), err.Error())
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, uploadedFile)
+	respondFile(w, http.StatusCreated, uploadedFile)
 }
 
 // GetFile retrieves file metadata
@@ -84,7 +90,7 @@ func (h *DriveHandler) GetFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, file)
+	respondFile(w, http.StatusOK, file)
 }
 
 // DownloadFile downloads a file
@@ -104,6 +110,11 @@ func (h *DriveHandler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", file.MimeType)
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", file.Name))
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", file.Size))
+	if file.Checksum != nil {
+		if sum, err := hex.DecodeString(*file.Checksum); err == nil {
+			w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+		}
+	}
 
 	// Stream file
 	_, _ = io.Copy(w, reader)
@@ -121,13 +132,13 @@ func (h *DriveHandler) UpdateFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, err := h.service.UpdateFile(ctx, fileID, userID, &req)
+	file, err := h.service.UpdateFile(ctx, fileID, userID, &req, r.Header.Get("If-Match"))
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, fileErrorStatus(err), err.Error())
 		return
 	}
 
-	respondJSON(w, http.StatusOK, file)
+	respondFile(w, http.StatusOK, file)
 }
 
 // DeleteFile deletes a file (moves to trash)
@@ -137,7 +148,7 @@ func (h *DriveHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 	fileID := getUUID(r, "id")
 
 	if err := h.service.DeleteFile(ctx, fileID, userID); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, fileErrorStatus(err), err.Error())
 		return
 	}
 
@@ -170,32 +181,6 @@ func (h *DriveHandler) ListFiles(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, files)
 }
 
-// SearchFiles searches for files
-func (h *DriveHandler) SearchFiles(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	tenantID, _ := getTenantAndUserID(r)
-
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		respondError(w, http.StatusBadRequest, "Search query is required")
-		return
-	}
-
-	var fileType *model.FileType
-	if fileTypeStr := r.URL.Query().Get("type"); fileTypeStr != "" {
-		ft := model.FileType(fileTypeStr)
-		fileType = &ft
-	}
-
-	files, err := h.service.SearchFiles(ctx, tenantID, query, fileType)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-
-	respondJSON(w, http.StatusOK, files)
-}
-
 // GetStarredFiles gets starred files
 func (h *DriveHandler) GetStarredFiles(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -280,7 +265,7 @@ func (h *DriveHandler) UpdateFolder(w http.ResponseWriter, r *http.Request) {
 
 	folder, err := h.service.UpdateFolder(ctx, folderID, userID, &req)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, fileErrorStatus(err), err.Error())
 		return
 	}
 
@@ -294,7 +279,7 @@ func (h *DriveHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
 	folderID := getUUID(r, "id")
 
 	if err := h.service.DeleteFolder(ctx, folderID, userID); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, fileErrorStatus(err), err.Error())
 		return
 	}
 
@@ -339,13 +324,13 @@ func (h *DriveHandler) MoveFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, err := h.service.MoveFile(ctx, fileID, userID, req.FolderID)
+	file, err := h.service.MoveFile(ctx, fileID, userID, req.FolderID, r.Header.Get("If-Match"))
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, fileErrorStatus(err), err.Error())
 		return
 	}
 
-	respondJSON(w, http.StatusOK, file)
+	respondFile(w, http.StatusOK, file)
 }
 
 // CopyFile copies a file
@@ -364,7 +349,7 @@ func (h *DriveHandler) CopyFile(w http.ResponseWriter, r *http.Request) {
 
 	file, err := h.service.CopyFile(ctx, fileID, userID, req.FolderID)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, fileErrorStatus(err), err.Error())
 		return
 	}
 
@@ -420,7 +405,7 @@ func (h *DriveHandler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Trash emptied"})
 }
 
-// GrantPermission grants permission to a user
+// GrantPermission grants permission to a user, email address or group
 func (h *DriveHandler) GrantPermission(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	tenantID, userID := getTenantAndUserID(r)
@@ -433,7 +418,7 @@ func (h *DriveHandler) GrantPermission(w http.ResponseWriter, r *http.Request) {
 
 	permission, err := h.service.GrantPermission(ctx, tenantID, userID, &req)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, accessErrorStatus(err), err.Error())
 		return
 	}
 
@@ -443,11 +428,11 @@ func (h *DriveHandler) GrantPermission(w http.ResponseWriter, r *http.Request) {
 // RevokePermission revokes a permission
 func (h *DriveHandler) RevokePermission(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	userID := getUserID(r)
+	tenantID, userID := getTenantAndUserID(r)
 	permissionID := getUUID(r, "id")
 
-	if err := h.service.RevokePermission(ctx, permissionID, userID); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	if err := h.service.RevokePermission(ctx, tenantID, permissionID, userID); err != nil {
+		respondError(w, accessErrorStatus(err), err.Error())
 		return
 	}
 
@@ -457,13 +442,13 @@ func (h *DriveHandler) RevokePermission(w http.ResponseWriter, r *http.Request)
 // ListPermissions lists permissions for a resource
 func (h *DriveHandler) ListPermissions(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	userID := getUserID(r)
+	tenantID, userID := getTenantAndUserID(r)
 	resourceID := getUUID(r, "resource_id")
 	resourceType := model.ResourceType(mux.Vars(r)["resource_type"])
 
-	permissions, err := h.service.ListPermissions(ctx, resourceID, resourceType, userID)
+	permissions, err := h.service.ListPermissions(ctx, tenantID, resourceID, resourceType, userID)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, accessErrorStatus(err), err.Error())
 		return
 	}
 
@@ -483,7 +468,7 @@ func (h *DriveHandler) CreateShareLink(w http.ResponseWriter, r *http.Request) {
 
 	link, err := h.service.CreateShareLink(ctx, tenantID, userID, &req)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondShareError(w, err)
 		return
 	}
 
@@ -507,11 +492,11 @@ func (h *DriveHandler) GetShareLink(w http.ResponseWriter, r *http.Request) {
 // DeleteShareLink deletes a share link
 func (h *DriveHandler) DeleteShareLink(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	userID := getUserID(r)
+	tenantID, userID := getTenantAndUserID(r)
 	linkID := getUUID(r, "id")
 
-	if err := h.service.DeleteShareLink(ctx, linkID, userID); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	if err := h.service.DeleteShareLink(ctx, tenantID, linkID, userID); err != nil {
+		respondShareError(w, err)
 		return
 	}
 
@@ -549,7 +534,7 @@ func (h *DriveHandler) RestoreVersion(w http.ResponseWriter, r *http.Request) {
 
 	file, err := h.service.RestoreVersion(ctx, fileID, req.VersionNum, userID)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, fileErrorStatus(err), err.Error())
 		return
 	}
 
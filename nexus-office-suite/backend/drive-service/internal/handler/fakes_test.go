@@ -0,0 +1,367 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/nexus/drive-service/config"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/repository"
+	"github.com/nexus/drive-service/internal/service"
+	"github.com/nexus/drive-service/internal/storage"
+)
+
+// fakeStore holds the state behind the fake repositories the handlers'
+// service is built on. Each fake embeds its repository interface, so
+// calling a method it does not implement panics and shows what a test is
+// missing.
+type fakeStore struct {
+	files      map[uuid.UUID]*model.File
+	folders    map[uuid.UUID]*model.Folder
+	grants     map[uuid.UUID]map[uuid.UUID]model.PermissionRole // resource, user
+	shareLinks map[uuid.UUID]*model.ShareLink
+	accesses   []*model.ShareLinkAccess
+	activities []*model.Activity
+	storage    *storage.MemoryStorage
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		files:      make(map[uuid.UUID]*model.File),
+		folders:    make(map[uuid.UUID]*model.Folder),
+		grants:     make(map[uuid.UUID]map[uuid.UUID]model.PermissionRole),
+		shareLinks: make(map[uuid.UUID]*model.ShareLink),
+		storage:    storage.NewMemoryStorage(),
+	}
+}
+
+// newTestRouter returns the API routes of a handler whose service is backed
+// by the store
+func newTestRouter(store *fakeStore) *mux.Router {
+	svc := service.NewDriveService(service.Repositories{
+		Files:       &fakeFileRepo{store: store},
+		Folders:     &fakeFolderRepo{store: store},
+		Permissions: &fakePermissionRepo{store: store},
+		ShareLinks:  &fakeShareLinkRepo{store: store},
+		Activity:    &fakeActivityRepo{store: store},
+	}, store.storage, nil, &config.Config{Share: config.ShareConfig{
+		TokenSecret:         "test-secret",
+		AccessTTL:           time.Hour,
+		MaxPasswordAttempts: 3,
+		PasswordLockout:     time.Hour,
+	}})
+	h := NewDriveHandler(svc, 1<<20)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/share-links", h.CreateShareLink).Methods("POST")
+	router.HandleFunc("/share-links/{id}", h.DeleteShareLink).Methods("DELETE")
+	router.HandleFunc("/share-links/{id}/access-log", h.GetShareLinkAccessLog).Methods("GET")
+
+	share := router.PathPrefix("/s/{token}").Subrouter()
+	share.HandleFunc("", h.GetSharedResource).Methods("GET")
+	share.HandleFunc("/unlock", h.UnlockShareLink).Methods("POST")
+	share.HandleFunc("/items", h.ListSharedFolder).Methods("GET")
+	share.HandleFunc("/download", h.DownloadShared).Methods("GET")
+	return router
+}
+
+// serve sends a request as a user of a tenant, with body encoded as JSON
+// unless it is nil
+func serve(t *testing.T, router http.Handler, tenantID, userID uuid.UUID, method, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest(method, target, &buf)
+	ctx := context.WithValue(r.Context(), "tenant_id", tenantID)
+	ctx = context.WithValue(ctx, "user_id", userID)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r.WithContext(ctx))
+	return w
+}
+
+func (s *fakeStore) addFolder(tenantID, ownerID uuid.UUID) *model.Folder {
+	folder := &model.Folder{ID: uuid.New(), TenantID: tenantID, OwnerID: ownerID, Name: "folder"}
+	s.folders[folder.ID] = folder
+	return folder
+}
+
+func (s *fakeStore) addFile(tenantID, ownerID uuid.UUID, folderID *uuid.UUID) *model.File {
+	file := &model.File{ID: uuid.New(), TenantID: tenantID, OwnerID: ownerID, FolderID: folderID, Name: "file.txt", Version: 1}
+	s.files[file.ID] = file
+	return file
+}
+
+// addContent stores content as the file's current content
+func (s *fakeStore) addContent(t *testing.T, file *model.File, content string) {
+	t.Helper()
+
+	file.StoragePath = "blobs/" + file.ID.String()
+	file.Size = int64(len(content))
+	file.MimeType = "text/plain"
+	if err := s.storage.UploadObject(context.Background(), file.StoragePath, strings.NewReader(content), file.Size, file.MimeType); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *fakeStore) grant(resourceID, userID uuid.UUID, role model.PermissionRole) {
+	if s.grants[resourceID] == nil {
+		s.grants[resourceID] = make(map[uuid.UUID]model.PermissionRole)
+	}
+	s.grants[resourceID][userID] = role
+}
+
+// role mirrors effective_role: owning the resource or a folder containing it
+// makes the user an owner, otherwise the nearest grant decides
+func (s *fakeStore) role(resourceType model.ResourceType, resourceID, userID uuid.UUID) model.PermissionRole {
+	var ownerID uuid.UUID
+	var parentID *uuid.UUID
+	if resourceType == model.ResourceTypeFile {
+		file, ok := s.files[resourceID]
+		if !ok {
+			return ""
+		}
+		ownerID, parentID = file.OwnerID, file.FolderID
+	} else {
+		folder, ok := s.folders[resourceID]
+		if !ok {
+			return ""
+		}
+		ownerID, parentID = folder.OwnerID, folder.ParentID
+	}
+
+	ids := []uuid.UUID{resourceID}
+	owners := []uuid.UUID{ownerID}
+	for parentID != nil {
+		folder := s.folders[*parentID]
+		ids = append(ids, folder.ID)
+		owners = append(owners, folder.OwnerID)
+		parentID = folder.ParentID
+	}
+
+	for _, owner := range owners {
+		if owner == userID {
+			return model.PermissionOwner
+		}
+	}
+	for _, id := range ids {
+		if role, ok := s.grants[id][userID]; ok {
+			return role
+		}
+	}
+	return ""
+}
+
+type fakeFileRepo struct {
+	repository.FileRepository
+	store *fakeStore
+}
+
+func (r *fakeFileRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.File, error) {
+	file, ok := r.store.files[id]
+	if !ok {
+		return nil, fmt.Errorf("file not found")
+	}
+	copied := *file
+	return &copied, nil
+}
+
+func (r *fakeFileRepo) GetByFolders(ctx context.Context, folderIDs []uuid.UUID) ([]*model.File, error) {
+	files := []*model.File{}
+	for _, file := range r.store.files {
+		for _, folderID := range folderIDs {
+			if file.FolderID != nil && *file.FolderID == folderID && !file.IsTrashed {
+				copied := *file
+				files = append(files, &copied)
+			}
+		}
+	}
+	return files, nil
+}
+
+type fakeFolderRepo struct {
+	repository.FolderRepository
+	store *fakeStore
+}
+
+func (r *fakeFolderRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.Folder, error) {
+	folder, ok := r.store.folders[id]
+	if !ok {
+		return nil, fmt.Errorf("folder not found")
+	}
+	copied := *folder
+	return &copied, nil
+}
+
+func (r *fakeFolderRepo) GetChildren(ctx context.Context, folderID uuid.UUID) ([]*model.Folder, error) {
+	folders := []*model.Folder{}
+	for _, folder := range r.store.folders {
+		if folder.ParentID != nil && *folder.ParentID == folderID && !folder.IsTrashed {
+			copied := *folder
+			folders = append(folders, &copied)
+		}
+	}
+	return folders, nil
+}
+
+// GetDescendants returns the folder and the folders inside it, parents
+// before their children
+func (r *fakeFolderRepo) GetDescendants(ctx context.Context, folderID uuid.UUID) ([]*model.Folder, error) {
+	folder, err := r.GetByID(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	folders := []*model.Folder{folder}
+	for i := 0; i < len(folders); i++ {
+		children, _ := r.GetChildren(ctx, folders[i].ID)
+		folders = append(folders, children...)
+	}
+	return folders, nil
+}
+
+type fakePermissionRepo struct {
+	repository.PermissionRepository
+	store *fakeStore
+}
+
+func (r *fakePermissionRepo) HasPermission(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID, role model.PermissionRole) (bool, error) {
+	rank := map[model.PermissionRole]int{
+		model.PermissionOwner:  3,
+		model.PermissionEditor: 2,
+		model.PermissionViewer: 1,
+	}
+	return rank[r.store.role(resourceType, resourceID, userID)] >= rank[role] && rank[role] > 0, nil
+}
+
+// GetAncestors returns the resource and the folders containing it, nearest
+// first
+func (r *fakePermissionRepo) GetAncestors(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType) ([]*model.Ancestor, error) {
+	var parentID *uuid.UUID
+	if resourceType == model.ResourceTypeFile {
+		file, ok := r.store.files[resourceID]
+		if !ok {
+			return nil, nil
+		}
+		parentID = file.FolderID
+	} else {
+		folder, ok := r.store.folders[resourceID]
+		if !ok {
+			return nil, nil
+		}
+		parentID = folder.ParentID
+	}
+
+	ancestors := []*model.Ancestor{{ResourceType: resourceType, ResourceID: resourceID}}
+	for parentID != nil {
+		folder := r.store.folders[*parentID]
+		ancestors = append(ancestors, &model.Ancestor{
+			ResourceType: model.ResourceTypeFolder,
+			ResourceID:   folder.ID,
+			OwnerID:      folder.OwnerID,
+			Depth:        len(ancestors),
+		})
+		parentID = folder.ParentID
+	}
+	return ancestors, nil
+}
+
+type fakeShareLinkRepo struct {
+	repository.ShareLinkRepository
+	store *fakeStore
+}
+
+func (r *fakeShareLinkRepo) Create(ctx context.Context, link *model.ShareLink) error {
+	copied := *link
+	r.store.shareLinks[link.ID] = &copied
+	return nil
+}
+
+func (r *fakeShareLinkRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.ShareLink, error) {
+	link, ok := r.store.shareLinks[id]
+	if !ok {
+		return nil, fmt.Errorf("share link not found")
+	}
+	copied := *link
+	return &copied, nil
+}
+
+// GetByToken returns the link with the token while it is valid and under
+// its download limit
+func (r *fakeShareLinkRepo) GetByToken(ctx context.Context, token string) (*model.ShareLink, error) {
+	for _, link := range r.store.shareLinks {
+		if link.Token == token && shareLinkUsable(link) {
+			copied := *link
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("share link not found or expired")
+}
+
+func (r *fakeShareLinkRepo) IncrementDownloadCount(ctx context.Context, id uuid.UUID) (bool, error) {
+	link, ok := r.store.shareLinks[id]
+	if !ok || !shareLinkUsable(link) {
+		return false, nil
+	}
+	link.DownloadCount++
+	return true, nil
+}
+
+func shareLinkUsable(link *model.ShareLink) bool {
+	return (link.ExpiresAt == nil || link.ExpiresAt.After(time.Now())) &&
+		(link.MaxDownloads == nil || link.DownloadCount < *link.MaxDownloads)
+}
+
+func (r *fakeShareLinkRepo) LogAccess(ctx context.Context, access *model.ShareLinkAccess) error {
+	r.store.accesses = append(r.store.accesses, access)
+	return nil
+}
+
+func (r *fakeShareLinkRepo) CountFailedUnlocks(ctx context.Context, linkID uuid.UUID, ipAddress string, since time.Time) (int, error) {
+	count := 0
+	for _, access := range r.store.accesses {
+		if access.LinkID == linkID && access.IPAddress == ipAddress &&
+			access.Action == model.ShareAccessUnlockFailed && access.CreatedAt.After(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *fakeShareLinkRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	delete(r.store.shareLinks, id)
+	return nil
+}
+
+func (r *fakeShareLinkRepo) GetAccessLog(ctx context.Context, linkID uuid.UUID, limit int) ([]*model.ShareLinkAccess, error) {
+	entries := []*model.ShareLinkAccess{}
+	for _, access := range r.store.accesses {
+		if access.LinkID == linkID && len(entries) < limit {
+			entries = append(entries, access)
+		}
+	}
+	return entries, nil
+}
+
+type fakeActivityRepo struct {
+	repository.ActivityRepository
+	store *fakeStore
+}
+
+func (r *fakeActivityRepo) Record(ctx context.Context, activity *model.Activity) error {
+	r.store.activities = append(r.store.activities, activity)
+	return nil
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+)
+
+// LockFile locks a file, or refreshes the caller's lock on it
+func (h *DriveHandler) LockFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	fileID := getUUID(r, "id")
+
+	var req model.LockFileRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	lock, err := h.service.LockFile(ctx, fileID, userID, &req)
+	if err != nil {
+		respondError(w, lockErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, lock)
+}
+
+// ListLocks lists the locks held on a file
+func (h *DriveHandler) ListLocks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	fileID := getUUID(r, "id")
+
+	locks, err := h.service.ListLocks(ctx, fileID, userID)
+	if err != nil {
+		respondError(w, lockErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, locks)
+}
+
+// UnlockFile releases a lock, or breaks it if the caller is an owner of the file
+func (h *DriveHandler) UnlockFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	fileID := getUUID(r, "id")
+	lockID := getUUID(r, "lock_id")
+
+	if err := h.service.UnlockFile(ctx, fileID, lockID, userID); err != nil {
+		respondError(w, lockErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Lock released"})
+}
+
+// CheckOutFile checks a file out to the caller
+func (h *DriveHandler) CheckOutFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	fileID := getUUID(r, "id")
+
+	var req model.CheckOutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	lock, err := h.service.CheckOut(ctx, fileID, userID, &req)
+	if err != nil {
+		respondError(w, lockErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, lock)
+}
+
+// CheckInFile checks in a file checked out to the caller. The multipart
+// form has the change's comment and, optionally, the file's new content.
+func (h *DriveHandler) CheckInFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	fileID := getUUID(r, "id")
+
+	if err := r.ParseMultipartForm(h.maxUploadSize); err != nil {
+		respondError(w, http.StatusBadRequest, "Failed to parse form: "+err.Error())
+		return
+	}
+
+	var content io.Reader
+	var size int64
+	var contentType string
+	file, header, err := r.FormFile("file")
+	switch {
+	case err == nil:
+		defer file.Close()
+		content, size, contentType = file, header.Size, header.Header.Get("Content-Type")
+	case !errors.Is(err, http.ErrMissingFile):
+		respondError(w, http.StatusBadRequest, "Invalid file")
+		return
+	}
+
+	checkedIn, err := h.service.CheckIn(ctx, fileID, userID, r.FormValue("comment"), content, size, contentType)
+	if err != nil {
+		respondError(w, lockErrorStatus(err), err.Error())
+		return
+	}
+
+	respondFile(w, http.StatusOK, checkedIn)
+}
+
+func lockErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidLock), errors.Is(err, service.ErrCommentRequired):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrNotCheckedOut):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrLockNotFound):
+		return http.StatusNotFound
+	default:
+		return fileErrorStatus(err)
+	}
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/preview"
+	"github.com/nexus/drive-service/internal/service"
+)
+
+// GetThumbnail serves a thumbnail of a file, at the smallest generated size
+// of at least size pixels along its longest side, or else the largest.
+// While thumbnails are being generated it responds 202 with their status.
+func (h *DriveHandler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	fileID := getUUID(r, "id")
+
+	requested := model.DefaultThumbnailSize
+	if sizeStr := r.URL.Query().Get("size"); sizeStr != "" {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil || size <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid size")
+			return
+		}
+		requested = size
+	}
+
+	filePreview, err := h.service.GetFilePreview(ctx, fileID, userID)
+	if err != nil {
+		respondError(w, previewErrorStatus(err), err.Error())
+		return
+	}
+	if filePreview.IsPending() {
+		respondPreviewPending(w, filePreview)
+		return
+	}
+
+	size, ok := filePreview.ThumbnailSize(requested)
+	if !ok || filePreview.Status != model.PreviewStatusReady {
+		respondError(w, http.StatusNotFound, "File has no thumbnail")
+		return
+	}
+
+	if notModified(w, r, filePreview, fmt.Sprintf(`"%s-%d"`, filePreview.Checksum, size)) {
+		return
+	}
+
+	reader, err := h.service.DownloadThumbnail(ctx, filePreview, size)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", preview.ThumbnailContentType)
+	_, _ = io.Copy(w, reader)
+}
+
+// GetFileText serves the text extracted from a document. While it is being
+// extracted it responds 202 with the preview status.
+func (h *DriveHandler) GetFileText(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	fileID := getUUID(r, "id")
+
+	filePreview, err := h.service.GetFilePreview(ctx, fileID, userID)
+	if err != nil {
+		respondError(w, previewErrorStatus(err), err.Error())
+		return
+	}
+	if filePreview.IsPending() {
+		respondPreviewPending(w, filePreview)
+		return
+	}
+	if filePreview.Status != model.PreviewStatusReady || !filePreview.HasText {
+		respondError(w, http.StatusNotFound, "File has no text")
+		return
+	}
+
+	if notModified(w, r, filePreview, fmt.Sprintf(`"%s-text"`, filePreview.Checksum)) {
+		return
+	}
+
+	reader, err := h.service.DownloadPreviewText(ctx, filePreview)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.Copy(w, reader)
+}
+
+// notModified sets the caching headers of a thumbnail or text extract and,
+// if the client's copy is current, responds 304 and returns true. Previews
+// follow the content of a file, so requests naming that content with
+// v=<checksum> may be cached for good; others must be revalidated.
+func notModified(w http.ResponseWriter, r *http.Request, filePreview *model.Preview, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if r.URL.Query().Get("v") == filePreview.Checksum {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimPrefix(strings.TrimSpace(match), "W/")
+		if match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+func respondPreviewPending(w http.ResponseWriter, filePreview *model.Preview) {
+	w.Header().Set("Retry-After", "5")
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusAccepted, filePreview)
+}
+
+func previewErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrPermissionDenied):
+		return http.StatusForbidden
+	default:
+		return http.StatusNotFound
+	}
+}
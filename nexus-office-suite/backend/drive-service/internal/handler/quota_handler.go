@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+)
+
+// GetStorageUsage returns the storage the user and their tenant use and
+// their quotas, with the user's usage by file type and folder
+func (h *DriveHandler) GetStorageUsage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+
+	usage, err := h.service.GetStorageUsage(ctx, tenantID, userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, usage)
+}
+
+// GetUserQuota returns the quota of a user of the tenant. Only
+// administrators may see it.
+func (h *DriveHandler) GetUserQuota(w http.ResponseWriter, r *http.Request) {
+	if scopeID, ok := quotaUserID(w, r); ok {
+		h.getQuota(w, r, model.QuotaScopeUser, scopeID)
+	}
+}
+
+// SetUserQuota sets the quota of a user of the tenant. Only administrators
+// may set it.
+func (h *DriveHandler) SetUserQuota(w http.ResponseWriter, r *http.Request) {
+	if scopeID, ok := quotaUserID(w, r); ok {
+		h.setQuota(w, r, model.QuotaScopeUser, scopeID)
+	}
+}
+
+// ClearUserQuota returns a user of the tenant to the default quota. Only
+// administrators may clear it.
+func (h *DriveHandler) ClearUserQuota(w http.ResponseWriter, r *http.Request) {
+	if scopeID, ok := quotaUserID(w, r); ok {
+		h.clearQuota(w, r, model.QuotaScopeUser, scopeID)
+	}
+}
+
+// GetTenantQuota returns the quota of the tenant. Only administrators may
+// see it.
+func (h *DriveHandler) GetTenantQuota(w http.ResponseWriter, r *http.Request) {
+	tenantID, _ := getTenantAndUserID(r)
+	h.getQuota(w, r, model.QuotaScopeTenant, tenantID)
+}
+
+// SetTenantQuota sets the quota of the tenant. Only administrators may set
+// it.
+func (h *DriveHandler) SetTenantQuota(w http.ResponseWriter, r *http.Request) {
+	tenantID, _ := getTenantAndUserID(r)
+	h.setQuota(w, r, model.QuotaScopeTenant, tenantID)
+}
+
+// ClearTenantQuota returns the tenant to the default quota. Only
+// administrators may clear it.
+func (h *DriveHandler) ClearTenantQuota(w http.ResponseWriter, r *http.Request) {
+	tenantID, _ := getTenantAndUserID(r)
+	h.clearQuota(w, r, model.QuotaScopeTenant, tenantID)
+}
+
+func (h *DriveHandler) getQuota(w http.ResponseWriter, r *http.Request, scope model.QuotaScope, scopeID uuid.UUID) {
+	ctx := r.Context()
+	tenantID, _ := getTenantAndUserID(r)
+
+	quota, err := h.service.GetQuota(ctx, tenantID, scope, scopeID)
+	if err != nil {
+		respondError(w, quotaErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, quota)
+}
+
+func (h *DriveHandler) setQuota(w http.ResponseWriter, r *http.Request, scope model.QuotaScope, scopeID uuid.UUID) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+
+	var req model.SetQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	quota, err := h.service.SetQuota(ctx, tenantID, userID, scope, scopeID, &req)
+	if err != nil {
+		respondError(w, quotaErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, quota)
+}
+
+func (h *DriveHandler) clearQuota(w http.ResponseWriter, r *http.Request, scope model.QuotaScope, scopeID uuid.UUID) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+
+	quota, err := h.service.ClearQuota(ctx, tenantID, userID, scope, scopeID)
+	if err != nil {
+		respondError(w, quotaErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, quota)
+}
+
+// quotaUserID returns the user_id of the request's path, responding with
+// an error if it is not a user ID
+func quotaUserID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID := getUUID(r, "user_id")
+	if userID == uuid.Nil {
+		respondError(w, http.StatusBadRequest, "Invalid user_id")
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+func quotaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidQuota):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrPermissionDenied):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
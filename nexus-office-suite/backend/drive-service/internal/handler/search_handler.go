@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+)
+
+// SearchFiles searches the names, descriptions, tags and content of the
+// files the user can view
+func (h *DriveHandler) SearchFiles(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+
+	req, err := parseSearchRequest(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, err := h.service.SearchFiles(ctx, tenantID, userID, req)
+	if err != nil {
+		respondError(w, searchErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, results)
+}
+
+func parseSearchRequest(r *http.Request) (*model.SearchRequest, error) {
+	query := r.URL.Query()
+	req := &model.SearchRequest{Query: query.Get("q")}
+
+	var err error
+	if req.OwnerID, err = queryUUID(r, "owner_id"); err != nil {
+		return nil, errors.New("Invalid owner_id")
+	}
+	if req.FolderID, err = queryUUID(r, "folder_id"); err != nil {
+		return nil, errors.New("Invalid folder_id")
+	}
+	if fileTypeStr := query.Get("type"); fileTypeStr != "" {
+		fileType := model.FileType(fileTypeStr)
+		req.FileType = &fileType
+	}
+
+	// Tags may be repeated or comma-separated
+	for _, value := range query["tags"] {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				req.Tags = append(req.Tags, tag)
+			}
+		}
+	}
+
+	if req.ModifiedAfter, err = queryTime(query, "modified_after"); err != nil {
+		return nil, err
+	}
+	if req.ModifiedBefore, err = queryTime(query, "modified_before"); err != nil {
+		return nil, err
+	}
+	if req.MinSize, err = queryInt64(query, "min_size"); err != nil {
+		return nil, err
+	}
+	if req.MaxSize, err = queryInt64(query, "max_size"); err != nil {
+		return nil, err
+	}
+
+	limit, err := queryInt64(query, "limit")
+	if err != nil {
+		return nil, err
+	}
+	if limit != nil {
+		req.Limit = int(*limit)
+	}
+	offset, err := queryInt64(query, "offset")
+	if err != nil {
+		return nil, err
+	}
+	if offset != nil {
+		req.Offset = int(*offset)
+	}
+
+	return req, nil
+}
+
+// queryTime parses an RFC 3339 time or a date, taken as midnight UTC
+func queryTime(query url.Values, key string) (*time.Time, error) {
+	value := query.Get(key)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid %s", key)
+}
+
+func queryInt64(query url.Values, key string) (*int64, error) {
+	value := query.Get(key)
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("Invalid %s", key)
+	}
+	return &n, nil
+}
+
+func searchErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidSearch):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
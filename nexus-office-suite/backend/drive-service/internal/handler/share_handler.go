@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+)
+
+// GetSharedResource describes the file or folder a public share link points at
+func (h *DriveHandler) GetSharedResource(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	token := mux.Vars(r)["token"]
+
+	resource, err := h.service.GetSharedResource(ctx, token, shareClient(r))
+	if err != nil {
+		respondShareError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, resource)
+}
+
+// UnlockShareLink exchanges the password of a share link for an access token,
+// which is then sent in the X-Share-Access header or the access_token query
+// parameter
+func (h *DriveHandler) UnlockShareLink(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	token := mux.Vars(r)["token"]
+
+	var req model.UnlockShareLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	grant, err := h.service.UnlockShareLink(ctx, token, req.Password, shareClient(r))
+	if err != nil {
+		respondShareError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, grant)
+}
+
+// ListSharedFolder lists a shared folder, or the folder_id folder inside it
+func (h *DriveHandler) ListSharedFolder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	token := mux.Vars(r)["token"]
+
+	folderID, err := queryUUID(r, "folder_id")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid folder_id")
+		return
+	}
+
+	contents, err := h.service.ListSharedFolder(ctx, token, folderID, shareClient(r))
+	if err != nil {
+		respondShareError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, contents)
+}
+
+// DownloadShared downloads a shared file. For a shared folder it downloads
+// the file_id file inside it, or else the folder, or the folder_id folder
+// inside it, as a zip archive.
+func (h *DriveHandler) DownloadShared(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	token := mux.Vars(r)["token"]
+	client := shareClient(r)
+
+	fileID, err := queryUUID(r, "file_id")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid file_id")
+		return
+	}
+	folderID, err := queryUUID(r, "folder_id")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid folder_id")
+		return
+	}
+
+	if folderID == nil {
+		reader, file, err := h.service.DownloadSharedFile(ctx, token, fileID, client)
+		if err == nil {
+			defer reader.Close()
+
+			w.Header().Set("Content-Type", file.MimeType)
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", file.Size))
+			_, _ = io.Copy(w, reader)
+			return
+		}
+		if !errors.Is(err, service.ErrShareIsFolder) {
+			respondShareError(w, err)
+			return
+		}
+	}
+
+	folder, write, err := h.service.DownloadSharedFolder(ctx, token, folderID, client)
+	if err != nil {
+		respondShareError(w, err)
+		return
+	}
+
+	// Archives of large folders take longer than the server's write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": folder.Name + ".zip"}))
+	if err := write(w); err != nil {
+		// The response has started, so the client sees a truncated archive
+		log.Printf("Failed to stream archive of folder %s: %v", folder.ID, err)
+	}
+}
+
+// GetShareLinkAccessLog lists the most recent uses of a share link
+func (h *DriveHandler) GetShareLinkAccessLog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+	linkID := getUUID(r, "id")
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	entries, err := h.service.GetShareLinkAccessLog(ctx, tenantID, linkID, userID, limit)
+	if err != nil {
+		respondShareError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, entries)
+}
+
+// shareClient identifies the client of a public share link request. The
+// address is the connection's, not X-Forwarded-For, so that clients cannot
+// dodge the password attempt limit.
+func shareClient(r *http.Request) *model.ShareClient {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	accessToken := r.Header.Get("X-Share-Access")
+	if accessToken == "" {
+		accessToken = r.URL.Query().Get("access_token")
+	}
+
+	return &model.ShareClient{
+		IPAddress:   ip,
+		UserAgent:   r.UserAgent(),
+		AccessToken: accessToken,
+	}
+}
+
+func queryUUID(r *http.Request, key string) (*uuid.UUID, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func respondShareError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrShareLinkNotFound), errors.Is(err, service.ErrNotInShare):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrSharePasswordRequired), errors.Is(err, service.ErrInvalidSharePassword):
+		status = http.StatusUnauthorized
+	case errors.Is(err, service.ErrTooManyUnlockAttempts):
+		status = http.StatusTooManyRequests
+	case errors.Is(err, service.ErrShareDownloadLimit):
+		status = http.StatusGone
+	case errors.Is(err, service.ErrInvalidShareRequest), errors.Is(err, service.ErrInvalidPermission):
+		status = http.StatusBadRequest
+	case errors.Is(err, service.ErrPermissionDenied):
+		status = http.StatusForbidden
+	}
+
+	respondError(w, status, err.Error())
+}
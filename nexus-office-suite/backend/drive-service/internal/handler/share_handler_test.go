@@ -0,0 +1,365 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCreateShareLinkRequiresAccess(t *testing.T) {
+	store := newFakeStore()
+	router := newTestRouter(store)
+	tenantA, tenantB := uuid.New(), uuid.New()
+	alice, viewer, editor, mallory := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	folder := store.addFolder(tenantA, alice)
+	store.grant(folder.ID, viewer, model.PermissionViewer)
+	store.grant(folder.ID, editor, model.PermissionEditor)
+	req := model.CreateShareLinkRequest{
+		ResourceID:   folder.ID,
+		ResourceType: model.ResourceTypeFolder,
+		Role:         model.PermissionViewer,
+	}
+
+	tests := []struct {
+		name     string
+		tenantID uuid.UUID
+		userID   uuid.UUID
+		want     int
+	}{
+		{"another tenant", tenantB, mallory, http.StatusForbidden},
+		{"another tenant as the owner", tenantB, alice, http.StatusForbidden},
+		{"stranger", tenantA, mallory, http.StatusForbidden},
+		{"viewer", tenantA, viewer, http.StatusForbidden},
+		{"editor", tenantA, editor, http.StatusCreated},
+		{"owner", tenantA, alice, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, router, tt.tenantID, tt.userID, "POST", "/share-links", req)
+			if w.Code != tt.want {
+				t.Errorf("got %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+	if len(store.shareLinks) != 2 {
+		t.Errorf("%d share links were created, want 2", len(store.shareLinks))
+	}
+
+	// Links cannot hand out more than editing
+	req.Role = model.PermissionOwner
+	if w := serve(t, router, tenantA, alice, "POST", "/share-links", req); w.Code != http.StatusBadRequest {
+		t.Errorf("owner link: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDeleteShareLinkRequiresAccess(t *testing.T) {
+	store := newFakeStore()
+	router := newTestRouter(store)
+	tenantA, tenantB := uuid.New(), uuid.New()
+	alice, viewer, mallory := uuid.New(), uuid.New(), uuid.New()
+
+	file := store.addFile(tenantA, alice, nil)
+	store.grant(file.ID, viewer, model.PermissionViewer)
+	link := &model.ShareLink{ID: uuid.New(), TenantID: tenantA, ResourceID: file.ID, ResourceType: model.ResourceTypeFile, Role: model.PermissionViewer, CreatedBy: alice}
+	store.shareLinks[link.ID] = link
+	target := "/share-links/" + link.ID.String()
+
+	if w := serve(t, router, tenantB, mallory, "DELETE", target, nil); w.Code != http.StatusNotFound {
+		t.Errorf("another tenant deleting a link: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := serve(t, router, tenantA, viewer, "DELETE", target, nil); w.Code != http.StatusForbidden {
+		t.Errorf("viewer deleting a link: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if _, ok := store.shareLinks[link.ID]; !ok {
+		t.Fatal("share link was deleted by a denied request")
+	}
+
+	if w := serve(t, router, tenantA, alice, "DELETE", target, nil); w.Code != http.StatusOK {
+		t.Errorf("owner deleting a link: got %d %s, want %d", w.Code, w.Body, http.StatusOK)
+	}
+	if _, ok := store.shareLinks[link.ID]; ok {
+		t.Error("share link was not deleted")
+	}
+}
+
+func TestShareLinkAccessLogRequiresAccess(t *testing.T) {
+	store := newFakeStore()
+	router := newTestRouter(store)
+	tenantA, tenantB := uuid.New(), uuid.New()
+	alice, editor, otherEditor, mallory := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	folder := store.addFolder(tenantA, alice)
+	store.grant(folder.ID, editor, model.PermissionEditor)
+	store.grant(folder.ID, otherEditor, model.PermissionEditor)
+	link := &model.ShareLink{ID: uuid.New(), TenantID: tenantA, ResourceID: folder.ID, ResourceType: model.ResourceTypeFolder, Role: model.PermissionViewer, CreatedBy: editor}
+	store.shareLinks[link.ID] = link
+	store.accesses = append(store.accesses, &model.ShareLinkAccess{ID: uuid.New(), LinkID: link.ID, Action: model.ShareAccessView, IPAddress: "192.0.2.1"})
+	target := "/share-links/" + link.ID.String() + "/access-log"
+
+	tests := []struct {
+		name     string
+		tenantID uuid.UUID
+		userID   uuid.UUID
+		want     int
+	}{
+		{"another tenant", tenantB, mallory, http.StatusNotFound},
+		{"another tenant as the creator", tenantB, editor, http.StatusNotFound},
+		{"editor who did not create it", tenantA, otherEditor, http.StatusForbidden},
+		{"creator", tenantA, editor, http.StatusOK},
+		{"owner", tenantA, alice, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, router, tt.tenantID, tt.userID, "GET", target, nil)
+			if w.Code != tt.want {
+				t.Errorf("got %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+
+	// A creator who can no longer edit the folder loses the log too
+	store.grant(folder.ID, editor, model.PermissionViewer)
+	if w := serve(t, router, tenantA, editor, "GET", target, nil); w.Code != http.StatusForbidden {
+		t.Errorf("creator demoted to viewer: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+// servePublic sends an unauthenticated request from remoteAddr, with the
+// access token of an unlocked share link if it is set
+func servePublic(t *testing.T, router http.Handler, method, target, remoteAddr, accessToken string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest(method, target, &buf)
+	r.RemoteAddr = remoteAddr
+	if accessToken != "" {
+		r.Header.Set("X-Share-Access", accessToken)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// addShareLink adds a viewer link with the token to a resource
+func (s *fakeStore) addShareLink(tenantID, resourceID uuid.UUID, resourceType model.ResourceType, token string) *model.ShareLink {
+	link := &model.ShareLink{ID: uuid.New(), TenantID: tenantID, ResourceID: resourceID, ResourceType: resourceType, Token: token, Role: model.PermissionViewer}
+	s.shareLinks[link.ID] = link
+	return link
+}
+
+func TestSharedFileDownloadLimit(t *testing.T) {
+	store := newFakeStore()
+	router := newTestRouter(store)
+	tenantID, alice := uuid.New(), uuid.New()
+	const addr = "192.0.2.1:1234"
+
+	file := store.addFile(tenantID, alice, nil)
+	store.addContent(t, file, "hello")
+	link := store.addShareLink(tenantID, file.ID, model.ResourceTypeFile, "file-token")
+	maxDownloads := 1
+	link.MaxDownloads = &maxDownloads
+
+	w := servePublic(t, router, "GET", "/s/file-token", addr, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("get shared file: got %d %s", w.Code, w.Body)
+	}
+	var resource model.SharedResource
+	if err := json.NewDecoder(w.Body).Decode(&resource); err != nil {
+		t.Fatal(err)
+	}
+	if resource.File == nil || resource.File.ID != file.ID || resource.DownloadsLeft == nil || *resource.DownloadsLeft != 1 {
+		t.Errorf("shared resource = %+v, want the file with one download left", resource)
+	}
+
+	// A file link only gives the file
+	if w := servePublic(t, router, "GET", "/s/file-token/download?file_id="+uuid.NewString(), addr, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("download of another file: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w = servePublic(t, router, "GET", "/s/file-token/download", addr, "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Fatalf("download: got %d %q, want the file's content", w.Code, w.Body)
+	}
+	if w := servePublic(t, router, "GET", "/s/file-token/download", addr, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("download over the limit: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	var actions []model.ShareAccessAction
+	for _, access := range store.accesses {
+		actions = append(actions, access.Action)
+	}
+	if len(actions) != 2 || actions[0] != model.ShareAccessView || actions[1] != model.ShareAccessDownload {
+		t.Errorf("access log = %v, want a view and a download", actions)
+	}
+}
+
+func TestSharedFolderStaysInsideTheFolder(t *testing.T) {
+	store := newFakeStore()
+	router := newTestRouter(store)
+	tenantID, alice := uuid.New(), uuid.New()
+	const addr = "192.0.2.1:1234"
+
+	shared := store.addFolder(tenantID, alice)
+	shared.Name = "shared"
+	sub := store.addFolder(tenantID, alice)
+	sub.Name, sub.ParentID = "sub", &shared.ID
+	inside := store.addFile(tenantID, alice, &sub.ID)
+	store.addContent(t, inside, "inside")
+	outsideFolder := store.addFolder(tenantID, alice)
+	outside := store.addFile(tenantID, alice, &outsideFolder.ID)
+	store.addContent(t, outside, "outside")
+	store.addShareLink(tenantID, shared.ID, model.ResourceTypeFolder, "folder-token")
+
+	w := servePublic(t, router, "GET", "/s/folder-token/items", addr, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list shared folder: got %d %s", w.Code, w.Body)
+	}
+	var contents model.SharedFolderContents
+	if err := json.NewDecoder(w.Body).Decode(&contents); err != nil {
+		t.Fatal(err)
+	}
+	if len(contents.Folders) != 1 || contents.Folders[0].ID != sub.ID || len(contents.Files) != 0 {
+		t.Errorf("shared folder contents = %+v, want only the subfolder", contents)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"subfolder", "/s/folder-token/items?folder_id=" + sub.ID.String(), http.StatusOK},
+		{"folder outside the share", "/s/folder-token/items?folder_id=" + outsideFolder.ID.String(), http.StatusNotFound},
+		{"file in a subfolder", "/s/folder-token/download?file_id=" + inside.ID.String(), http.StatusOK},
+		{"file outside the share", "/s/folder-token/download?file_id=" + outside.ID.String(), http.StatusNotFound},
+		{"archive of a folder outside the share", "/s/folder-token/download?folder_id=" + outsideFolder.ID.String(), http.StatusNotFound},
+		{"unknown token", "/s/no-such-token/items", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := servePublic(t, router, "GET", tt.target, addr, "", nil)
+			if w.Code != tt.want {
+				t.Errorf("got %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if strings.Contains(w.Body.String(), "outside") {
+				t.Error("response contains content from outside the share")
+			}
+		})
+	}
+
+	// The whole folder downloads as a zip archive
+	w = servePublic(t, router, "GET", "/s/folder-token/download", addr, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("download folder: got %d %s", w.Code, w.Body)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range archive.File {
+		names = append(names, entry.Name)
+		if entry.Name == "shared/sub/file.txt" {
+			r, err := entry.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := io.ReadAll(r)
+			r.Close()
+			if string(content) != "inside" {
+				t.Errorf("archived file content = %q, want %q", content, "inside")
+			}
+		}
+	}
+	sort.Strings(names)
+	if want := []string{"shared/", "shared/sub/", "shared/sub/file.txt"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("archive entries = %v, want %v", names, want)
+	}
+}
+
+func TestSharePasswordLockout(t *testing.T) {
+	store := newFakeStore()
+	router := newTestRouter(store)
+	tenantID, alice := uuid.New(), uuid.New()
+	const attacker, visitor = "198.51.100.7:4000", "192.0.2.1:1234"
+
+	file := store.addFile(tenantID, alice, nil)
+	store.addContent(t, file, "secret")
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwordHash := string(hash)
+	link := store.addShareLink(tenantID, file.ID, model.ResourceTypeFile, "locked-token")
+	link.Password = &passwordHash
+	other := store.addShareLink(tenantID, file.ID, model.ResourceTypeFile, "other-token")
+	other.Password = &passwordHash
+
+	if w := servePublic(t, router, "GET", "/s/locked-token/download", visitor, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("download without unlocking: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// Wrong passwords lock the address out, even for the right password
+	for i := 0; i < 3; i++ {
+		w := servePublic(t, router, "POST", "/s/locked-token/unlock", attacker, "", model.UnlockShareLinkRequest{Password: "guess"})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("wrong password %d: got %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+		}
+	}
+	w := servePublic(t, router, "POST", "/s/locked-token/unlock", attacker, "", model.UnlockShareLinkRequest{Password: "correct horse"})
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("right password after the lockout: got %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// Other addresses are not locked out
+	w = servePublic(t, router, "POST", "/s/locked-token/unlock", visitor, "", model.UnlockShareLinkRequest{Password: "correct horse"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("unlock: got %d %s", w.Code, w.Body)
+	}
+	var grant model.ShareLinkGrant
+	if err := json.NewDecoder(w.Body).Decode(&grant); err != nil {
+		t.Fatal(err)
+	}
+
+	w = servePublic(t, router, "GET", "/s/locked-token/download", visitor, grant.AccessToken, nil)
+	if w.Code != http.StatusOK || w.Body.String() != "secret" {
+		t.Errorf("download with the access token: got %d %q", w.Code, w.Body)
+	}
+	w = servePublic(t, router, "GET", "/s/locked-token/download?access_token="+grant.AccessToken, visitor, "", nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("download with the access token in the query: got %d", w.Code)
+	}
+
+	// The token only opens the link it was issued for
+	if w := servePublic(t, router, "GET", "/s/other-token/download", visitor, grant.AccessToken, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("another link with the access token: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	tampered := grant.AccessToken[:len(grant.AccessToken)-2] + "xx"
+	if w := servePublic(t, router, "GET", "/s/locked-token/download", visitor, tampered, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("tampered access token: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// Nor outlives the link
+	expired := time.Now().Add(-time.Minute)
+	link.ExpiresAt = &expired
+	if w := servePublic(t, router, "GET", "/s/locked-token/download", visitor, grant.AccessToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expired link: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+)
+
+// GetChanges returns the changes to what the user can view after the
+// cursor query parameter, or the latest cursor if there is none
+func (h *DriveHandler) GetChanges(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	query := r.URL.Query()
+
+	limit := 0
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+	}
+
+	page, err := h.service.GetChanges(ctx, userID, query.Get("cursor"), limit)
+	if err != nil {
+		respondError(w, changeErrorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, page)
+}
+
+// PollChanges waits until the user has changes after the cursor query
+// parameter, for at most timeout seconds, and reports whether they do
+func (h *DriveHandler) PollChanges(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	query := r.URL.Query()
+
+	var timeout time.Duration
+	if timeoutStr := query.Get("timeout"); timeoutStr != "" {
+		seconds, err := strconv.Atoi(timeoutStr)
+		if err != nil || seconds <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid timeout")
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	// Waiting outlasts the server's write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	changed, err := h.service.WaitForChanges(ctx, userID, query.Get("cursor"), timeout)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		respondError(w, changeErrorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, map[string]bool{"changes": changed})
+}
+
+// UpdateFileContent uploads the request body as a new version of a file.
+// With If-Match, the file must not have changed since the client saw it.
+func (h *DriveHandler) UpdateFileContent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	fileID := getUUID(r, "id")
+
+	if r.ContentLength > h.maxUploadSize {
+		respondError(w, http.StatusRequestEntityTooLarge, "File too large")
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, h.maxUploadSize)
+
+	file, err := h.service.UpdateFileContent(ctx, fileID, userID, body, r.ContentLength, r.Header.Get("Content-Type"), r.Header.Get("If-Match"))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondError(w, http.StatusRequestEntityTooLarge, "File too large")
+			return
+		}
+		respondError(w, fileErrorStatus(err), err.Error())
+		return
+	}
+
+	respondFile(w, http.StatusOK, file)
+}
+
+// checkIfNoneMatch handles If-None-Match: * on uploads, which refuses to
+// upload a file if the folder already has one of the same name. It reports
+// whether the upload can go ahead, having responded if not.
+func (h *DriveHandler) checkIfNoneMatch(w http.ResponseWriter, r *http.Request, folderID *uuid.UUID, name string) bool {
+	if r.Header.Get("If-None-Match") != "*" {
+		return true
+	}
+
+	tenantID, userID := getTenantAndUserID(r)
+	file, err := h.service.GetFileByName(r.Context(), tenantID, userID, folderID, name)
+	switch {
+	case errors.Is(err, service.ErrPathNotFound):
+		return true
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+
+	w.Header().Set("ETag", file.ETag())
+	respondError(w, http.StatusPreconditionFailed, "A file with this name already exists")
+	return false
+}
+
+// respondFile responds with a file and its ETag
+func respondFile(w http.ResponseWriter, status int, file *model.File) {
+	w.Header().Set("ETag", file.ETag())
+	respondJSON(w, status, file)
+}
+
+// fileErrorStatus maps errors of creating and changing files, which may be
+// conditional on their ETag or refused for lack of storage
+func fileErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, service.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrFileLocked):
+		return http.StatusLocked
+	case errors.Is(err, service.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrInvalidMove):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, service.ErrVersionNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func changeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidCursor):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrCursorExpired):
+		return http.StatusGone
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+)
+
+// CreateUploadSession starts a resumable upload
+func (h *DriveHandler) CreateUploadSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+
+	var req model.CreateUploadSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// The name is checked when the upload starts, not when it completes
+	if !h.checkIfNoneMatch(w, r, req.FolderID, req.Filename) {
+		return
+	}
+
+	session, err := h.service.CreateUploadSession(ctx, tenantID, userID, &req)
+	if err != nil {
+		respondError(w, uploadErrorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/uploads/"+session.ID.String())
+	setUploadHeaders(w, session)
+	respondJSON(w, http.StatusCreated, session)
+}
+
+// GetUploadSession returns an upload session; its offset is where the
+// client resumes
+func (h *DriveHandler) GetUploadSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	sessionID := getUUID(r, "id")
+
+	session, err := h.service.GetUploadSession(ctx, sessionID, userID)
+	if err != nil {
+		respondError(w, uploadErrorStatus(err), err.Error())
+		return
+	}
+
+	setUploadHeaders(w, session)
+	respondJSON(w, http.StatusOK, session)
+}
+
+// UploadChunk appends a chunk to an upload session. The Upload-Offset header
+// gives the chunk's offset and the optional Upload-Checksum header its
+// SHA-256 as "sha256 <base64>".
+func (h *DriveHandler) UploadChunk(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	sessionID := getUUID(r, "id")
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondError(w, http.StatusBadRequest, "Upload-Offset header is required")
+		return
+	}
+
+	var checksum []byte
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		algorithm, value, _ := strings.Cut(header, " ")
+		if algorithm != "sha256" {
+			respondError(w, http.StatusBadRequest, "Upload-Checksum must use sha256")
+			return
+		}
+		checksum, err = base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid Upload-Checksum header")
+			return
+		}
+	}
+
+	session, err := h.service.UploadChunk(ctx, sessionID, userID, offset, r.Body, checksum)
+	if errors.Is(err, service.ErrOffsetMismatch) {
+		// Tell the client where to resume
+		if current, getErr := h.service.GetUploadSession(ctx, sessionID, userID); getErr == nil {
+			setUploadHeaders(w, current)
+		}
+	}
+	if err != nil {
+		respondError(w, uploadErrorStatus(err), err.Error())
+		return
+	}
+
+	setUploadHeaders(w, session)
+	respondJSON(w, http.StatusOK, session)
+}
+
+// CompleteUpload assembles an upload session into a file
+func (h *DriveHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	sessionID := getUUID(r, "id")
+
+	file, err := h.service.CompleteUpload(ctx, sessionID, userID)
+	if err != nil {
+		respondError(w, uploadErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, file)
+}
+
+// AbortUpload cancels an upload session
+func (h *DriveHandler) AbortUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	sessionID := getUUID(r, "id")
+
+	if err := h.service.AbortUpload(ctx, sessionID, userID); err != nil {
+		respondError(w, uploadErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Upload aborted"})
+}
+
+func setUploadHeaders(w http.ResponseWriter, session *model.UploadSession) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.Size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrUploadExpired):
+		return http.StatusGone
+	case errors.Is(err, service.ErrOffsetMismatch), errors.Is(err, service.ErrUploadCompleted),
+		errors.Is(err, service.ErrUploadIncomplete):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, service.ErrInvalidUpload), errors.Is(err, service.ErrChecksumMismatch):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
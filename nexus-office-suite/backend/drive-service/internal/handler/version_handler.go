@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/service"
+	"github.com/nexus/drive-service/internal/textdiff"
+)
+
+// UpdateVersion names or pins a file version
+func (h *DriveHandler) UpdateVersion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	fileID := getUUID(r, "file_id")
+
+	versionNum, err := strconv.Atoi(mux.Vars(r)["version_num"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid version number")
+		return
+	}
+
+	var req model.UpdateVersionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	version, err := h.service.UpdateVersion(ctx, fileID, versionNum, userID, &req)
+	if err != nil {
+		respondError(w, versionErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, version)
+}
+
+// DiffVersions compares two versions of a text file, from and to, which
+// default to the version before to and the current version. The diff is
+// JSON hunks, or a unified diff with format=unified.
+func (h *DriveHandler) DiffVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := getUserID(r)
+	fileID := getUUID(r, "file_id")
+	query := r.URL.Query()
+
+	var from, to int
+	var err error
+	if value := query.Get("from"); value != "" {
+		if from, err = strconv.Atoi(value); err != nil || from <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid from")
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if to, err = strconv.Atoi(value); err != nil || to <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid to")
+			return
+		}
+	}
+
+	diff, err := h.service.DiffVersions(ctx, fileID, userID, from, to)
+	if err != nil {
+		respondError(w, versionErrorStatus(err), err.Error())
+		return
+	}
+
+	if query.Get("format") == "unified" {
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, textdiff.Unified(
+			fmt.Sprintf("version %d", diff.FromVersion),
+			fmt.Sprintf("version %d", diff.ToVersion),
+			diff.Hunks,
+		))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, diff)
+}
+
+// GetVersionRetention returns the tenant's version retention policy
+func (h *DriveHandler) GetVersionRetention(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, _ := getTenantAndUserID(r)
+
+	policy, err := h.service.GetVersionRetention(ctx, tenantID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+// SetVersionRetention sets the tenant's version retention policy. Only
+// administrators may set it.
+func (h *DriveHandler) SetVersionRetention(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, userID := getTenantAndUserID(r)
+
+	var req model.SetVersionRetentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	policy, err := h.service.SetVersionRetention(ctx, tenantID, userID, &req)
+	if err != nil {
+		respondError(w, versionErrorStatus(err), err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+func versionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidVersion), errors.Is(err, service.ErrInvalidRetention):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrVersionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrNotText):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, service.ErrDiffTooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -14,9 +16,10 @@ import (
 
 // JWTClaims represents the JWT claims
 type JWTClaims struct {
-	UserID   string `json:"user_id"`
-	TenantID string `json:"tenant_id"`
-	Email    string `json:"email"`
+	UserID   string   `json:"user_id"`
+	TenantID string   `json:"tenant_id"`
+	Email    string   `json:"email"`
+	Roles    []string `json:"roles"`
 	jwt.RegisteredClaims
 }
 
@@ -38,46 +41,167 @@ func JWTAuth(jwtSecret string) func(http.Handler) http.Handler {
 				return
 			}
 
-			tokenString := parts[1]
+			ctx, err := authenticateToken(r.Context(), jwtSecret, parts[1])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
 
-			// Parse and validate token
-			token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// PasswordAuthenticator checks a username and password that are not a
+// token, returning the tenant, user and email address they belong to
+type PasswordAuthenticator func(ctx context.Context, username, password string) (tenantID, userID uuid.UUID, email string, err error)
+
+// BasicAuth middleware authenticates clients that cannot obtain tokens
+// themselves, such as WebDAV clients. It accepts a bearer token, or HTTP
+// Basic credentials whose password is either a token or checked by
+// authenticate. Unauthenticated requests are challenged for credentials.
+func BasicAuth(jwtSecret, realm string, authenticate PasswordAuthenticator) func(http.Handler) http.Handler {
+	challenge := fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, realm)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var ctx context.Context
+			var err error
+
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				ctx, err = authenticateToken(r.Context(), jwtSecret, token)
+			} else if username, password, ok := r.BasicAuth(); ok {
+				// Tokens are too long to be mistaken for passwords
+				ctx, err = authenticateToken(r.Context(), jwtSecret, password)
+				if err != nil {
+					var tenantID, userID uuid.UUID
+					var email string
+					tenantID, userID, email, err = authenticate(r.Context(), username, password)
+					if err == nil {
+						ctx = withUser(r.Context(), tenantID, userID, email)
+					}
 				}
-				return []byte(jwtSecret), nil
-			})
+			} else {
+				err = fmt.Errorf("Missing authorization header")
+			}
 
 			if err != nil {
-				http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+				w.Header().Set("WWW-Authenticate", challenge)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
 				return
 			}
 
-			claims, ok := token.Claims.(*JWTClaims)
-			if !ok || !token.Valid {
-				http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authenticateToken validates a JWT and returns ctx with the user's
+// identity added
+func authenticateToken(ctx context.Context, jwtSecret, tokenString string) (context.Context, error) {
+	// Parse and validate token
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(jwtSecret), nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*JWTClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("Invalid token claims")
+	}
+
+	// Parse UUIDs
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid user ID in token")
+	}
+
+	tenantID, err := uuid.Parse(claims.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid tenant ID in token")
+	}
+
+	ctx = withUser(ctx, tenantID, userID, claims.Email)
+	return context.WithValue(ctx, "roles", claims.Roles), nil
+}
+
+// withUser adds a user's identity to ctx
+func withUser(ctx context.Context, tenantID, userID uuid.UUID, email string) context.Context {
+	ctx = context.WithValue(ctx, "user_id", userID)
+	ctx = context.WithValue(ctx, "tenant_id", tenantID)
+	return context.WithValue(ctx, "email", email)
+}
+
+// RequireRole middleware rejects requests whose token has none of the
+// given roles. Requests authenticated by app passwords have no roles.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasRole(r.Context(), roles...) {
+				http.Error(w, "Insufficient permissions", http.StatusForbidden)
 				return
 			}
 
-			// Parse UUIDs
-			userID, err := uuid.Parse(claims.UserID)
-			if err != nil {
-				http.Error(w, "Invalid user ID in token", http.StatusUnauthorized)
-				return
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HasRole reports whether the request's token has any of the given roles
+func HasRole(ctx context.Context, roles ...string) bool {
+	have, _ := ctx.Value("roles").([]string)
+	for _, role := range have {
+		for _, want := range roles {
+			if role == want {
+				return true
 			}
+		}
+	}
+	return false
+}
 
-			tenantID, err := uuid.Parse(claims.TenantID)
-			if err != nil {
-				http.Error(w, "Invalid tenant ID in token", http.StatusUnauthorized)
-				return
+// UserRecorder records the user of an authenticated request as a user of
+// their tenant
+type UserRecorder func(ctx context.Context, tenantID, userID uuid.UUID, email string) error
+
+// RecordUsers middleware passes the user of each authenticated request to
+// record, at most once every interval for each user. Failures are logged
+// and retried on a later request.
+func RecordUsers(record UserRecorder, interval time.Duration) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+	recorded := make(map[[2]uuid.UUID]time.Time)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			tenantID, _ := ctx.Value("tenant_id").(uuid.UUID)
+			userID, _ := ctx.Value("user_id").(uuid.UUID)
+			email, _ := ctx.Value("email").(string)
+			key := [2]uuid.UUID{tenantID, userID}
+
+			mu.Lock()
+			due := time.Since(recorded[key]) >= interval
+			if due {
+				recorded[key] = time.Now()
 			}
+			mu.Unlock()
 
-			// Add user info to context
-			ctx := context.WithValue(r.Context(), "user_id", userID)
-			ctx = context.WithValue(ctx, "tenant_id", tenantID)
-			ctx = context.WithValue(ctx, "email", claims.Email)
+			if due && userID != uuid.Nil {
+				if err := record(ctx, tenantID, userID, email); err != nil {
+					log.Println("Failed to record user:", err)
+					mu.Lock()
+					delete(recorded, key)
+					mu.Unlock()
+				}
+			}
 
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r)
 		})
 	}
 }
@@ -113,6 +237,11 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // CORS middleware handles Cross-Origin Resource Sharing
 func CORS(allowedOrigins []string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -132,12 +261,14 @@ func CORS(allowedOrigins []string) func(http.Handler) http.Handler {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
 			}
 
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Upload-Offset, Upload-Checksum, X-Share-Access")
+			w.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Content-Disposition")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-			// Handle preflight requests
-			if r.Method == "OPTIONS" {
+			// Handle preflight requests. Other OPTIONS requests, such as
+			// WebDAV clients discovering the server, are passed on.
+			if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
 				w.WriteHeader(http.StatusOK)
 				return
 			}
@@ -157,6 +288,21 @@ func RequestID(next http.Handler) http.Handler {
 	})
 }
 
+// ClientInfo middleware adds the client's IP address and user agent to
+// each request, for the activity log
+func ClientInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		ctx := context.WithValue(r.Context(), "client_ip", ip)
+		ctx = context.WithValue(ctx, "user_agent", r.UserAgent())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 // Recovery middleware recovers from panics
 func Recovery(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccessSource explains where a user's access to a resource comes from
+type AccessSource string
+
+const (
+	// AccessSourceOwner means the user owns the resource or a folder
+	// containing it
+	AccessSourceOwner AccessSource = "owner"
+	// AccessSourceDirect means the access was granted on the resource itself
+	AccessSourceDirect AccessSource = "direct"
+	// AccessSourceInherited means the access was granted on a folder
+	// containing the resource
+	AccessSourceInherited AccessSource = "inherited"
+)
+
+// Ancestor is a resource or one of the folders containing it. Depth is 0 for
+// the resource itself, 1 for its folder and so on.
+type Ancestor struct {
+	ResourceType ResourceType `db:"resource_type"`
+	ResourceID   uuid.UUID    `db:"resource_id"`
+	OwnerID      uuid.UUID    `db:"owner_id"`
+	Depth        int          `db:"depth"`
+}
+
+// InheritedPermission is an unexpired permission on a resource or one of the
+// folders containing it
+type InheritedPermission struct {
+	Permission
+	Depth int `db:"depth"`
+}
+
+// EffectiveAccess is the access one user, or one email address not yet
+// linked to a user, has to a resource and the grant it comes from. A grant
+// nearer the resource overrides one further up; GroupID is set when the
+// access comes from a group the user is in.
+type EffectiveAccess struct {
+	UserID       *uuid.UUID     `json:"user_id,omitempty"`
+	Email        *string        `json:"email,omitempty"`
+	Role         PermissionRole `json:"role"`
+	Source       AccessSource   `json:"source"`
+	ResourceID   uuid.UUID      `json:"resource_id"`
+	ResourceType ResourceType   `json:"resource_type"`
+	PermissionID *uuid.UUID     `json:"permission_id,omitempty"`
+	GroupID      *uuid.UUID     `json:"group_id,omitempty"`
+	ExpiresAt    *time.Time     `json:"expires_at,omitempty"`
+}
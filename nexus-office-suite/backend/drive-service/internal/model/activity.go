@@ -0,0 +1,105 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ActivityAction is what was done to a file or folder
+type ActivityAction string
+
+const (
+	ActivityCreated         ActivityAction = "created"
+	ActivityViewed          ActivityAction = "viewed"
+	ActivityDownloaded      ActivityAction = "downloaded"
+	ActivityUpdated         ActivityAction = "updated"
+	ActivityRenamed         ActivityAction = "renamed"
+	ActivityMoved           ActivityAction = "moved"
+	ActivityCopied          ActivityAction = "copied"
+	ActivityTrashed         ActivityAction = "trashed"
+	ActivityRestored        ActivityAction = "restored"
+	ActivityDeleted         ActivityAction = "deleted"
+	ActivityVersionAdded    ActivityAction = "version_added"
+	ActivityVersionUpdated  ActivityAction = "version_updated"
+	ActivityVersionRestored ActivityAction = "version_restored"
+	ActivityShared          ActivityAction = "shared"
+	ActivityUnshared        ActivityAction = "unshared"
+	ActivityLinkCreated     ActivityAction = "link_created"
+	ActivityLinkDeleted     ActivityAction = "link_deleted"
+	ActivityLocked          ActivityAction = "locked"
+	ActivityUnlocked        ActivityAction = "unlocked"
+	ActivityCheckedOut      ActivityAction = "checked_out"
+	ActivityCheckedIn       ActivityAction = "checked_in"
+	ActivityExtracted       ActivityAction = "extracted"
+)
+
+// ShareActivityAction returns the action recording a use of a public share
+// link, such as "link_download"
+func ShareActivityAction(action ShareAccessAction) ActivityAction {
+	return ActivityAction("link_" + string(action))
+}
+
+// Activity is an entry in the activity log. ActorID is nil for visitors of
+// public share links, who are identified by ShareLinkID and their address.
+type Activity struct {
+	ID           uuid.UUID      `json:"id" db:"id"`
+	TenantID     uuid.UUID      `json:"tenant_id" db:"tenant_id"`
+	ActorID      *uuid.UUID     `json:"actor_id,omitempty" db:"actor_id"`
+	Action       ActivityAction `json:"action" db:"action"`
+	ResourceType ResourceType   `json:"resource_type" db:"resource_type"`
+	ResourceID   uuid.UUID      `json:"resource_id" db:"resource_id"`
+	ResourceName string         `json:"resource_name" db:"resource_name"`
+	FromFolderID *uuid.UUID     `json:"from_folder_id,omitempty" db:"from_folder_id"` // where a moved resource was
+	ShareLinkID  *uuid.UUID     `json:"share_link_id,omitempty" db:"share_link_id"`
+	IPAddress    *string        `json:"ip_address,omitempty" db:"ip_address"`
+	UserAgent    *string        `json:"user_agent,omitempty" db:"user_agent"`
+	Details      Metadata       `json:"details,omitempty" db:"details"`
+	CreatedAt    time.Time      `json:"created_at" db:"created_at"`
+}
+
+// ActivityQuery selects activity, newest first. FileID selects a file's
+// activity, FolderID that of a folder and everything that was inside it,
+// and ActorID what a user did; without them the whole tenant's activity is
+// selected.
+type ActivityQuery struct {
+	FileID   *uuid.UUID
+	FolderID *uuid.UUID
+	ActorID  *uuid.UUID
+	Actions  []ActivityAction
+	Since    *time.Time
+	Until    *time.Time
+	Cursor   string
+	Limit    int
+	// Admin is set for tenant administrators, who can see everything in
+	// their tenant
+	Admin bool
+}
+
+// ActivityCursor is a position in activity ordered newest first
+type ActivityCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// ActivityPage is a page of activity. NextCursor continues after it and
+// is empty on the last page.
+type ActivityPage struct {
+	Activities []*Activity `json:"activities"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// ActivityRetentionPolicy is how many days a tenant keeps its activity; 0
+// keeps it forever
+type ActivityRetentionPolicy struct {
+	TenantID      uuid.UUID  `json:"tenant_id" db:"tenant_id"`
+	RetentionDays int        `json:"retention_days" db:"retention_days"`
+	Default       bool       `json:"default" db:"-"` // the configured default, not set for the tenant
+	UpdatedBy     *uuid.UUID `json:"updated_by,omitempty" db:"updated_by"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// SetActivityRetentionRequest sets a tenant's activity retention
+type SetActivityRetentionRequest struct {
+	RetentionDays int `json:"retention_days"`
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AppPassword is a password a user generates for a client that signs in
+// with a username and password, such as a WebDAV client. Only its hash is
+// stored; Password is set once, when it is created.
+type AppPassword struct {
+	ID           uuid.UUID  `json:"id" db:"id"`
+	TenantID     uuid.UUID  `json:"tenant_id" db:"tenant_id"`
+	UserID       uuid.UUID  `json:"user_id" db:"user_id"`
+	Login        string     `json:"login" db:"login"` // the username to use it with
+	Name         string     `json:"name" db:"name"`
+	PasswordHash string     `json:"-" db:"password_hash"`
+	Password     string     `json:"password,omitempty" db:"-"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+// CreateAppPasswordRequest represents a request to create an app password
+type CreateAppPasswordRequest struct {
+	Name string `json:"name"`
+}
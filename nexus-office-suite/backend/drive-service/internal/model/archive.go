@@ -0,0 +1,51 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxArchiveSelection is the most files and folders a zip download may
+// select
+const MaxArchiveSelection = 1000
+
+// ExtractionStatus represents the state of an archive extraction
+type ExtractionStatus string
+
+const (
+	ExtractionStatusPending   ExtractionStatus = "pending"
+	ExtractionStatusRunning   ExtractionStatus = "running"
+	ExtractionStatusCompleted ExtractionStatus = "completed"
+	ExtractionStatusFailed    ExtractionStatus = "failed"
+)
+
+// ArchiveExtraction is the extraction of an archive file into FolderID, a
+// folder created for it. Entries that are unsafe or cannot be stored, such
+// as links and paths leaving the folder, are skipped.
+type ArchiveExtraction struct {
+	ID               uuid.UUID        `json:"id" db:"id"`
+	TenantID         uuid.UUID        `json:"-" db:"tenant_id"`
+	UserID           uuid.UUID        `json:"user_id" db:"user_id"`
+	FileID           uuid.UUID        `json:"file_id" db:"file_id"`
+	FolderID         uuid.UUID        `json:"folder_id" db:"folder_id"`
+	Status           ExtractionStatus `json:"status" db:"status"`
+	Progress         int              `json:"progress" db:"progress"` // percent
+	EntriesExtracted int              `json:"entries_extracted" db:"entries_extracted"`
+	EntriesSkipped   int              `json:"entries_skipped" db:"entries_skipped"`
+	BytesExtracted   int64            `json:"bytes_extracted" db:"bytes_extracted"`
+	Error            *string          `json:"error,omitempty" db:"error"`
+	Attempts         int              `json:"-" db:"attempts"`
+	RunAt            time.Time        `json:"-" db:"run_at"`
+	CreatedAt        time.Time        `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at" db:"updated_at"`
+	CompletedAt      *time.Time       `json:"completed_at,omitempty" db:"completed_at"`
+}
+
+// ExtractArchiveRequest extracts an archive into a new folder named Name,
+// by default the archive's name without its extension, inside FolderID, by
+// default the archive's folder
+type ExtractArchiveRequest struct {
+	FolderID *uuid.UUID `json:"folder_id,omitempty"`
+	Name     string     `json:"name,omitempty"`
+}
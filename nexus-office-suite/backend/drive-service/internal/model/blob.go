@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BlobStatus represents the outcome of the last integrity check of a blob
+type BlobStatus string
+
+const (
+	BlobStatusOK      BlobStatus = "ok"
+	BlobStatusCorrupt BlobStatus = "corrupt"
+	BlobStatusMissing BlobStatus = "missing"
+)
+
+// Blob represents stored file content, addressed by its SHA-256 within a
+// tenant. Files and file versions with the same content share one blob;
+// RefCount counts them and is kept by database triggers.
+type Blob struct {
+	TenantID    uuid.UUID  `json:"tenant_id" db:"tenant_id"`
+	Checksum    string     `json:"checksum" db:"checksum"`
+	StoragePath string     `json:"storage_path" db:"storage_path"`
+	Size        int64      `json:"size" db:"size"`
+	RefCount    int        `json:"ref_count" db:"ref_count"`
+	Status      BlobStatus `json:"status" db:"status"`
+	VerifiedAt  *time.Time `json:"verified_at,omitempty" db:"verified_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// ScrubReport summarizes an integrity scrub run
+type ScrubReport struct {
+	Checked int     `json:"checked"`
+	Corrupt []*Blob `json:"corrupt,omitempty"`
+	Missing []*Blob `json:"missing,omitempty"`
+}
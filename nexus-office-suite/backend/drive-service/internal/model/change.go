@@ -0,0 +1,62 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ChangeType is what happened to a file or folder
+type ChangeType string
+
+const (
+	ChangeCreated           ChangeType = "created"
+	ChangeUpdated           ChangeType = "updated" // metadata or content
+	ChangeMoved             ChangeType = "moved"   // possibly also renamed
+	ChangeRenamed           ChangeType = "renamed"
+	ChangeTrashed           ChangeType = "trashed"
+	ChangeRestored          ChangeType = "restored"
+	ChangeDeleted           ChangeType = "deleted"
+	ChangePermissionChanged ChangeType = "permission_changed"
+)
+
+const (
+	DefaultChangeLimit = 500
+	MaxChangeLimit     = 2000
+)
+
+// Change is an entry in a user's change journal. Seq numbers a user's
+// changes in the order they happened. File or Folder is the resource as it
+// is now, unless Removed is set because it has been deleted or the user can
+// no longer view it. Changes to a folder are not repeated for what it
+// contains: a client that did not know the contents of a moved, restored or
+// newly shared folder lists them.
+type Change struct {
+	UserID       uuid.UUID    `json:"-" db:"user_id"`
+	Seq          int64        `json:"seq" db:"seq"`
+	TenantID     uuid.UUID    `json:"-" db:"tenant_id"`
+	ResourceType ResourceType `json:"resource_type" db:"resource_type"`
+	ResourceID   uuid.UUID    `json:"resource_id" db:"resource_id"`
+	ChangeType   ChangeType   `json:"change_type" db:"change_type"`
+	ActorID      uuid.UUID    `json:"actor_id" db:"actor_id"`
+	CreatedAt    time.Time    `json:"created_at" db:"created_at"`
+	Visible      bool         `json:"-" db:"visible"` // whether the user can view the resource now
+	Removed      bool         `json:"removed,omitempty" db:"-"`
+	File         *File        `json:"file,omitempty" db:"-"`
+	Folder       *Folder      `json:"folder,omitempty" db:"-"`
+}
+
+// ChangePage is a page of a user's changes. Cursor is passed to get the
+// next page; without a cursor, a page has no changes and Cursor is the
+// user's latest position, to follow changes from after a full listing.
+type ChangePage struct {
+	Changes []*Change `json:"changes"`
+	Cursor  string    `json:"cursor"`
+	HasMore bool      `json:"has_more"`
+}
+
+// ChangeCursor is a user's position in their change journal
+type ChangeCursor struct {
+	LastSeq   int64 `db:"last_seq"`
+	PrunedSeq int64 `db:"pruned_seq"`
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
@@ -34,7 +35,9 @@ type File struct {
 	FileType        FileType   `json:"file_type" db:"file_type"`
 	Size            int64      `json:"size" db:"size"`
 	StoragePath     string     `json:"storage_path" db:"storage_path"`
+	Checksum        *string    `json:"checksum,omitempty" db:"checksum"` // SHA-256 of the content, hex
 	Version         int        `json:"version" db:"version"`
+	Revision        int64      `json:"revision" db:"revision"` // incremented by every change; see ETag
 	IsStarred       bool       `json:"is_starred" db:"is_starred"`
 	IsTrashed       bool       `json:"is_trashed" db:"is_trashed"`
 	TrashedAt       *time.Time `json:"trashed_at,omitempty" db:"trashed_at"`
@@ -47,6 +50,12 @@ type File struct {
 	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
 }
 
+// ETag identifies the file's revision, for requests made conditional on it
+// with If-Match
+func (f *File) ETag() string {
+	return strconv.Quote(strconv.FormatInt(f.Revision, 10))
+}
+
 // Folder represents a folder in the drive
 type Folder struct {
 	ID          uuid.UUID  `json:"id" db:"id"`
@@ -70,8 +79,11 @@ type FileVersion struct {
 	VersionNum  int       `json:"version_num" db:"version_num"`
 	Size        int64     `json:"size" db:"size"`
 	StoragePath string    `json:"storage_path" db:"storage_path"`
+	Checksum    *string   `json:"checksum,omitempty" db:"checksum"`
 	CreatedBy   uuid.UUID `json:"created_by" db:"created_by"`
 	Comment     *string   `json:"comment,omitempty" db:"comment"`
+	Name        *string   `json:"name,omitempty" db:"name"`
+	Pinned      bool      `json:"pinned" db:"pinned"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
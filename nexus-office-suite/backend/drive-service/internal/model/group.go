@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Group is a named set of users in a tenant that permissions can be
+// granted to
+type Group struct {
+	ID          uuid.UUID   `json:"id" db:"id"`
+	TenantID    uuid.UUID   `json:"tenant_id" db:"tenant_id"`
+	Name        string      `json:"name" db:"name"`
+	Description *string     `json:"description,omitempty" db:"description"`
+	CreatedBy   uuid.UUID   `json:"created_by" db:"created_by"`
+	CreatedAt   time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at" db:"updated_at"`
+	Members     []uuid.UUID `json:"members,omitempty" db:"-"`
+}
+
+// GroupMember is a user's membership of a group
+type GroupMember struct {
+	GroupID   uuid.UUID `json:"group_id" db:"group_id"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	AddedBy   uuid.UUID `json:"added_by" db:"added_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// CreateGroupRequest represents a request to create a group
+type CreateGroupRequest struct {
+	Name        string      `json:"name"`
+	Description *string     `json:"description,omitempty"`
+	Members     []uuid.UUID `json:"members,omitempty"`
+}
+
+// AddGroupMemberRequest represents a request to add a user to a group
+type AddGroupMemberRequest struct {
+	UserID uuid.UUID `json:"user_id"`
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LockType represents the kind of lock held on a file
+type LockType string
+
+const (
+	// LockTypeExclusive lets only its holder change a file
+	LockTypeExclusive LockType = "exclusive"
+	// LockTypeShared lets only the holders of shared locks change a file
+	LockTypeShared LockType = "shared"
+)
+
+// FileLock is a lock a user holds on a file until ExpiresAt. Nobody else
+// may change, move or trash the file while it is held. A checked out file
+// has an exclusive lock released by checking the file in; BaseVersion is
+// the version of the file when it was locked.
+type FileLock struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	TenantID    uuid.UUID `json:"-" db:"tenant_id"`
+	FileID      uuid.UUID `json:"file_id" db:"file_id"`
+	UserID      uuid.UUID `json:"user_id" db:"user_id"`
+	Type        LockType  `json:"type" db:"lock_type"`
+	CheckedOut  bool      `json:"checked_out" db:"checked_out"`
+	BaseVersion int       `json:"base_version" db:"base_version"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// LockFileRequest locks a file, or refreshes the user's lock on it, for
+// Duration seconds, by default the configured lock duration
+type LockFileRequest struct {
+	Type     LockType `json:"type"`
+	Duration int      `json:"duration,omitempty"`
+}
+
+// CheckOutRequest checks a file out for Duration seconds, by default the
+// configured check-out duration
+type CheckOutRequest struct {
+	Duration int `json:"duration,omitempty"`
+}
@@ -31,6 +31,7 @@ type Permission struct {
 	ResourceType ResourceType   `json:"resource_type" db:"resource_type"`
 	UserID       *uuid.UUID     `json:"user_id,omitempty" db:"user_id"`
 	Email        *string        `json:"email,omitempty" db:"email"`
+	GroupID      *uuid.UUID     `json:"group_id,omitempty" db:"group_id"`
 	Role         PermissionRole `json:"role" db:"role"`
 	GrantedBy    uuid.UUID      `json:"granted_by" db:"granted_by"`
 	ExpiresAt    *time.Time     `json:"expires_at,omitempty" db:"expires_at"`
@@ -70,6 +71,7 @@ type CreatePermissionRequest struct {
 	ResourceType ResourceType   `json:"resource_type"`
 	UserID       *uuid.UUID     `json:"user_id,omitempty"`
 	Email        *string        `json:"email,omitempty"`
+	GroupID      *uuid.UUID     `json:"group_id,omitempty"`
 	Role         PermissionRole `json:"role"`
 	ExpiresAt    *time.Time     `json:"expires_at,omitempty"`
 }
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// DefaultThumbnailSize is the thumbnail size, in pixels along the longest
+// side, served when none is requested and pointed at by File.ThumbnailPath
+const DefaultThumbnailSize = 256
+
+// PreviewStatus represents the state of preview generation for stored content
+type PreviewStatus string
+
+const (
+	PreviewStatusPending     PreviewStatus = "pending"
+	PreviewStatusProcessing  PreviewStatus = "processing"
+	PreviewStatusReady       PreviewStatus = "ready"
+	PreviewStatusUnsupported PreviewStatus = "unsupported"
+	PreviewStatusFailed      PreviewStatus = "failed"
+)
+
+// Preview represents the thumbnails and text extract generated from a blob.
+// Images are thumbnailed directly; documents are thumbnailed from an image
+// of their first page. MimeType and FileName are those of the file whose
+// upload queued the preview and decide how the content is read.
+type Preview struct {
+	TenantID       uuid.UUID     `json:"-" db:"tenant_id"`
+	Checksum       string        `json:"checksum" db:"checksum"`
+	MimeType       string        `json:"-" db:"mime_type"`
+	FileName       string        `json:"-" db:"file_name"`
+	Size           int64         `json:"-" db:"size"`
+	Status         PreviewStatus `json:"status" db:"status"`
+	ThumbnailSizes pq.Int64Array `json:"thumbnail_sizes" db:"thumbnail_sizes"`
+	HasText        bool          `json:"has_text" db:"has_text"`
+	Width          *int          `json:"width,omitempty" db:"width"` // of the image or first page
+	Height         *int          `json:"height,omitempty" db:"height"`
+	Attempts       int           `json:"-" db:"attempts"`
+	LastError      *string       `json:"error,omitempty" db:"last_error"`
+	RunAt          time.Time     `json:"-" db:"run_at"`
+	CreatedAt      time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at" db:"updated_at"`
+}
+
+// IsPending reports whether the preview is still to be generated
+func (p *Preview) IsPending() bool {
+	return p.Status == PreviewStatusPending || p.Status == PreviewStatusProcessing
+}
+
+// ThumbnailSize returns the smallest generated thumbnail size of at least
+// requested pixels, or the largest if none is that big. It returns false if
+// no thumbnails were generated.
+func (p *Preview) ThumbnailSize(requested int) (int, bool) {
+	best := 0
+	for _, size := range p.ThumbnailSizes {
+		s := int(size)
+		switch {
+		case best == 0,
+			s >= requested && (best < requested || s < best),
+			best < requested && s > best:
+			best = s
+		}
+	}
+	return best, best > 0
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// QuotaScope is whose storage a quota limits
+type QuotaScope string
+
+const (
+	QuotaScopeUser   QuotaScope = "user"
+	QuotaScopeTenant QuotaScope = "tenant"
+)
+
+// Quota is the storage quota of a user or tenant
+type Quota struct {
+	Scope     QuotaScope `json:"scope" db:"scope"`
+	ScopeID   uuid.UUID  `json:"scope_id" db:"scope_id"`
+	Quota     int64      `json:"quota" db:"quota_bytes"` // 0 if unlimited
+	Default   bool       `json:"default" db:"-"`         // the configured default, not set for the user or tenant
+	UpdatedBy *uuid.UUID `json:"updated_by,omitempty" db:"updated_by"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// SetQuotaRequest sets the quota of a user or tenant
+type SetQuotaRequest struct {
+	Quota *int64 `json:"quota"` // bytes, 0 for unlimited
+}
+
+// StorageUsage is how much storage a user uses and how it is divided.
+// Content is counted once however many of the user's files, trashed files
+// and versions hold it: Files is the content of files, Trash the content
+// only trashed files hold, and Versions the content only old versions hold.
+// The breakdowns count each group's content once, so content shared by
+// files of different types or folders is counted in each of them.
+type StorageUsage struct {
+	Used       int64            `json:"used"`
+	Quota      int64            `json:"quota"` // 0 if unlimited
+	Files      int64            `json:"files" db:"files"`
+	Trash      int64            `json:"trash" db:"trash"`
+	Versions   int64            `json:"versions" db:"versions"`
+	Tenant     TenantUsage      `json:"tenant"`
+	ByFileType []*FileTypeUsage `json:"by_file_type"`
+	ByFolder   []*FolderUsage   `json:"by_folder"`
+}
+
+// TenantUsage is how much storage a tenant uses: every blob it stores
+// counted once, whoever holds it
+type TenantUsage struct {
+	Used  int64 `json:"used"`
+	Quota int64 `json:"quota"` // 0 if unlimited
+}
+
+// FileTypeUsage is the storage used by a user's files of a type, with their
+// versions
+type FileTypeUsage struct {
+	FileType FileType `json:"file_type" db:"file_type"`
+	Files    int      `json:"files" db:"files"`
+	Bytes    int64    `json:"bytes" db:"bytes"`
+}
+
+// FolderUsage is the storage used by a user's files in a top-level folder
+// and the folders inside it, with their versions. FolderID is nil for files
+// at the root of the drive.
+type FolderUsage struct {
+	FolderID *uuid.UUID `json:"folder_id" db:"folder_id"`
+	Name     string     `json:"name" db:"name"`
+	Files    int        `json:"files" db:"files"`
+	Bytes    int64      `json:"bytes" db:"bytes"`
+}
+
+// QuotaEvent is a warning that a user's or tenant's usage reached
+// Threshold percent of its quota. UserID is who is warned: the user whose
+// quota it is, or for a tenant's quota the user whose change reached it.
+type QuotaEvent struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	TenantID    uuid.UUID  `json:"tenant_id" db:"tenant_id"`
+	Scope       QuotaScope `json:"scope" db:"scope"`
+	ScopeID     uuid.UUID  `json:"scope_id" db:"scope_id"`
+	UserID      uuid.UUID  `json:"user_id" db:"user_id"`
+	Threshold   int        `json:"threshold" db:"threshold"`
+	UsedBytes   int64      `json:"used_bytes" db:"used_bytes"`
+	QuotaBytes  int64      `json:"quota_bytes" db:"quota_bytes"`
+	Attempts    int        `json:"-" db:"attempts"`
+	RunAt       time.Time  `json:"-" db:"run_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty" db:"delivered_at"`
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 200
+
+	// SearchHighlightStart and SearchHighlightStop surround the matching
+	// words of snippets as the database returns them. They are control
+	// characters, which are removed from text before it is indexed.
+	SearchHighlightStart = "\x02"
+	SearchHighlightStop  = "\x03"
+)
+
+// SearchRequest is a search of the files a user can view. Query is matched
+// against the names, descriptions and tags of files and the text extracted
+// from their content; the other fields narrow the results.
+type SearchRequest struct {
+	Query          string
+	OwnerID        *uuid.UUID
+	FileType       *FileType
+	FolderID       *uuid.UUID // the folder and the folders inside it
+	Tags           []string   // files must have all of them
+	ModifiedAfter  *time.Time
+	ModifiedBefore *time.Time
+	MinSize        *int64
+	MaxSize        *int64
+	Limit          int
+	Offset         int
+}
+
+// SearchResult is a file matching a search. Snippet is set when the query
+// matched the file's content: it is HTML, with the matching words in <mark>
+// elements.
+type SearchResult struct {
+	File
+	Rank    float64 `json:"rank" db:"rank"`
+	Snippet *string `json:"snippet,omitempty" db:"snippet"`
+}
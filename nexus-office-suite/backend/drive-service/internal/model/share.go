@@ -0,0 +1,114 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareAccessAction is what a public share link was used for
+type ShareAccessAction string
+
+const (
+	ShareAccessView         ShareAccessAction = "view"
+	ShareAccessList         ShareAccessAction = "list"
+	ShareAccessDownload     ShareAccessAction = "download"
+	ShareAccessUnlock       ShareAccessAction = "unlock"
+	ShareAccessUnlockFailed ShareAccessAction = "unlock_failed"
+)
+
+// ShareLinkAccess is an entry in a share link's access log
+type ShareLinkAccess struct {
+	ID        uuid.UUID         `json:"id" db:"id"`
+	LinkID    uuid.UUID         `json:"link_id" db:"link_id"`
+	Action    ShareAccessAction `json:"action" db:"action"`
+	FileID    *uuid.UUID        `json:"file_id,omitempty" db:"file_id"`
+	FolderID  *uuid.UUID        `json:"folder_id,omitempty" db:"folder_id"`
+	IPAddress string            `json:"ip_address" db:"ip_address"`
+	UserAgent *string           `json:"user_agent,omitempty" db:"user_agent"`
+	CreatedAt time.Time         `json:"created_at" db:"created_at"`
+}
+
+// ShareClient identifies who is using a public share link
+type ShareClient struct {
+	IPAddress string
+	UserAgent string
+	// AccessToken is the token returned by unlocking a password-protected
+	// link
+	AccessToken string
+}
+
+// SharedResource is what a public share link points at
+type SharedResource struct {
+	ResourceType     ResourceType   `json:"resource_type"`
+	Role             PermissionRole `json:"role"`
+	ExpiresAt        *time.Time     `json:"expires_at,omitempty"`
+	DownloadsLeft    *int           `json:"downloads_left,omitempty"`
+	PasswordRequired bool           `json:"password_required"`
+	File             *SharedFile    `json:"file,omitempty"`
+	Folder           *SharedFolder  `json:"folder,omitempty"`
+}
+
+// SharedFile is the public view of a file reached through a share link
+type SharedFile struct {
+	ID        uuid.UUID  `json:"id"`
+	FolderID  *uuid.UUID `json:"folder_id,omitempty"`
+	Name      string     `json:"name"`
+	MimeType  string     `json:"mime_type"`
+	FileType  FileType   `json:"file_type"`
+	Size      int64      `json:"size"`
+	Checksum  *string    `json:"checksum,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// SharedFolder is the public view of a folder reached through a share link
+type SharedFolder struct {
+	ID        uuid.UUID  `json:"id"`
+	ParentID  *uuid.UUID `json:"parent_id,omitempty"`
+	Name      string     `json:"name"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// NewSharedFile returns the public view of a file
+func NewSharedFile(file *File) *SharedFile {
+	return &SharedFile{
+		ID:        file.ID,
+		FolderID:  file.FolderID,
+		Name:      file.Name,
+		MimeType:  file.MimeType,
+		FileType:  file.FileType,
+		Size:      file.Size,
+		Checksum:  file.Checksum,
+		UpdatedAt: file.UpdatedAt,
+	}
+}
+
+// NewSharedFolder returns the public view of a folder
+func NewSharedFolder(folder *Folder) *SharedFolder {
+	return &SharedFolder{
+		ID:        folder.ID,
+		ParentID:  folder.ParentID,
+		Name:      folder.Name,
+		UpdatedAt: folder.UpdatedAt,
+	}
+}
+
+// SharedFolderContents is a folder reached through a public share link
+type SharedFolderContents struct {
+	Folder  *SharedFolder   `json:"folder"`
+	Folders []*SharedFolder `json:"folders"`
+	Files   []*SharedFile   `json:"files"`
+}
+
+// UnlockShareLinkRequest represents a request to unlock a
+// password-protected share link
+type UnlockShareLinkRequest struct {
+	Password string `json:"password"`
+}
+
+// ShareLinkGrant is a short-lived token allowing access to a
+// password-protected share link
+type ShareLinkGrant struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
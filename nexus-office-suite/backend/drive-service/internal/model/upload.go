@@ -0,0 +1,58 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadStatus represents the state of a resumable upload session
+type UploadStatus string
+
+const (
+	UploadStatusUploading UploadStatus = "uploading"
+	UploadStatusCompleted UploadStatus = "completed"
+)
+
+// UploadSession represents a resumable upload, backed by a multipart upload
+// in storage. Chunks are appended in order at Offset; completing the session
+// assembles the parts into a File.
+type UploadSession struct {
+	ID              uuid.UUID    `json:"id" db:"id"`
+	TenantID        uuid.UUID    `json:"tenant_id" db:"tenant_id"`
+	OwnerID         uuid.UUID    `json:"owner_id" db:"owner_id"`
+	FolderID        *uuid.UUID   `json:"folder_id,omitempty" db:"folder_id"`
+	FileID          uuid.UUID    `json:"file_id" db:"file_id"`
+	Filename        string       `json:"filename" db:"filename"`
+	MimeType        string       `json:"mime_type" db:"mime_type"`
+	Size            int64        `json:"size" db:"size"`
+	ChunkSize       int64        `json:"chunk_size" db:"chunk_size"`
+	Offset          int64        `json:"offset" db:"upload_offset"`
+	Checksum        *string      `json:"checksum,omitempty" db:"checksum"` // expected SHA-256 of the whole file, hex
+	StoragePath     string       `json:"-" db:"storage_path"`
+	StorageUploadID string       `json:"-" db:"storage_upload_id"`
+	HashState       []byte       `json:"-" db:"hash_state"` // SHA-256 state after the last chunk
+	Status          UploadStatus `json:"status" db:"status"`
+	ExpiresAt       time.Time    `json:"expires_at" db:"expires_at"`
+	CreatedAt       time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// UploadPart represents a chunk stored as one part of a session's multipart upload
+type UploadPart struct {
+	SessionID  uuid.UUID `json:"session_id" db:"session_id"`
+	PartNumber int       `json:"part_number" db:"part_number"`
+	Size       int64     `json:"size" db:"size"`
+	ETag       string    `json:"etag" db:"etag"`
+	Checksum   string    `json:"checksum" db:"checksum"` // SHA-256 of the chunk, hex
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// CreateUploadSessionRequest represents a request to start a resumable upload
+type CreateUploadSessionRequest struct {
+	Filename string     `json:"filename"`
+	FolderID *uuid.UUID `json:"folder_id,omitempty"`
+	Size     int64      `json:"size"`
+	MimeType string     `json:"mime_type,omitempty"`
+	Checksum *string    `json:"checksum,omitempty"`
+}
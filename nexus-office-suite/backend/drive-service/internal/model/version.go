@@ -0,0 +1,56 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/textdiff"
+)
+
+// UpdateVersionRequest names or pins a version. An empty name removes the
+// version's name. Named and pinned versions are never pruned.
+type UpdateVersionRequest struct {
+	Name   *string `json:"name,omitempty"`
+	Pinned *bool   `json:"pinned,omitempty"`
+}
+
+// VersionRetentionPolicy is how long a tenant keeps old versions of files.
+// A version is kept if it is one of the KeepLast newest, younger than
+// KeepDays days, or the newest version of its day within DailyDays days or
+// of its week within WeeklyDays days. The newest and current versions and
+// named and pinned versions are always kept. A policy of all zeros keeps
+// every version.
+type VersionRetentionPolicy struct {
+	TenantID   uuid.UUID  `json:"tenant_id" db:"tenant_id"`
+	KeepLast   int        `json:"keep_last" db:"keep_last"`
+	KeepDays   int        `json:"keep_days" db:"keep_days"`
+	DailyDays  int        `json:"daily_days" db:"daily_days"`
+	WeeklyDays int        `json:"weekly_days" db:"weekly_days"`
+	Default    bool       `json:"default" db:"-"` // the configured default, not set for the tenant
+	UpdatedBy  *uuid.UUID `json:"updated_by,omitempty" db:"updated_by"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// KeepsAll reports whether the policy keeps every version
+func (p *VersionRetentionPolicy) KeepsAll() bool {
+	return p.KeepLast == 0 && p.KeepDays == 0 && p.DailyDays == 0 && p.WeeklyDays == 0
+}
+
+// SetVersionRetentionRequest sets a tenant's retention policy
+type SetVersionRetentionRequest struct {
+	KeepLast   int `json:"keep_last"`
+	KeepDays   int `json:"keep_days"`
+	DailyDays  int `json:"daily_days"`
+	WeeklyDays int `json:"weekly_days"`
+}
+
+// VersionDiff is the line-by-line difference between two versions of a
+// text file
+type VersionDiff struct {
+	FileID      uuid.UUID        `json:"file_id"`
+	FromVersion int              `json:"from_version"`
+	ToVersion   int              `json:"to_version"`
+	Added       int              `json:"added"`   // lines
+	Removed     int              `json:"removed"` // lines
+	Hunks       []*textdiff.Hunk `json:"hunks"`
+}
@@ -0,0 +1,77 @@
+// Package notification sends notifications to users through the suite's
+// notification service, which shows them in the apps in real time
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TypeSystem is the notification type of messages from the system rather
+// than from other users
+const TypeSystem = "system"
+
+// Notification is a message for a user
+type Notification struct {
+	UserID  uuid.UUID              `json:"userId"`
+	Type    string                 `json:"type"`
+	Title   string                 `json:"title"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	Link    string                 `json:"link,omitempty"`
+}
+
+// Sender sends notifications
+type Sender interface {
+	Send(ctx context.Context, notification *Notification) error
+}
+
+// Client sends notifications to the notification service's internal API,
+// authenticated with the key the services share
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewClient returns a client of the notification service at baseURL
+func NewClient(baseURL, apiKey string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) Send(ctx context.Context, notification *Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/notifications", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Internal-Key", c.apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to send notification: notification service returned %s", resp.Status)
+	}
+
+	return nil
+}
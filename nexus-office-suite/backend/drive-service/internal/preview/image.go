@@ -0,0 +1,154 @@
+package preview
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	// Image formats decoded by image.Decode
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// Documents are drawn as a US Letter page at 72 dpi
+	pageWidth      = 612
+	pageHeight     = 792
+	pageMargin     = 54
+	pageLineHeight = 15
+
+	thumbnailQuality = 85
+)
+
+// ThumbnailContentType is the content type of thumbnails
+const ThumbnailContentType = "image/jpeg"
+
+// Thumbnails scales an image to fit each size, in pixels along its longest
+// side, and returns the scaled images encoded as JPEG. Images are never
+// scaled up, and transparent areas become white.
+func Thumbnails(img image.Image, sizes []int) (map[int][]byte, error) {
+	sorted := append([]int(nil), sizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+
+	thumbnails := make(map[int][]byte, len(sorted))
+	src := img
+	for _, size := range sorted {
+		// Each size is scaled from the next larger one, which is much
+		// faster than scaling a large image several times
+		scaled := scale(src, size)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+			return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+		}
+		thumbnails[size] = buf.Bytes()
+		src = scaled
+	}
+
+	return thumbnails, nil
+}
+
+func scale(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			height = max(1, height*size/width)
+			width = size
+		} else {
+			width = max(1, width*size/height)
+			height = size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// decodeImage decodes an image, refusing images larger than MaxPixels
+// before decoding them
+func decodeImage(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: image is %dx%d pixels", ErrTooLarge, config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	return img, nil
+}
+
+// renderPage draws the start of a document's text as its first page
+func renderPage(text string) image.Image {
+	page := image.NewRGBA(image.Rect(0, 0, pageWidth, pageHeight))
+	draw.Draw(page, page.Bounds(), image.White, image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	drawer := &font.Drawer{
+		Dst:  page,
+		Src:  image.NewUniform(color.Gray{Y: 0x20}),
+		Face: face,
+	}
+
+	columns := (pageWidth - 2*pageMargin) / face.Advance
+	y := pageMargin + face.Ascent
+	for _, line := range wrapText(text, columns) {
+		if y > pageHeight-pageMargin {
+			break
+		}
+		drawer.Dot = fixed.P(pageMargin, y)
+		drawer.DrawString(line)
+		y += pageLineHeight
+	}
+
+	return page
+}
+
+// wrapText splits text into lines of at most columns characters, breaking
+// long lines at spaces where possible
+func wrapText(text string, columns int) []string {
+	// Nothing past the first page is drawn
+	maxLines := pageHeight/pageLineHeight + 1
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		runes := []rune(strings.ReplaceAll(line, "\t", "    "))
+		for len(runes) > columns && len(lines) < maxLines {
+			cut := columns
+			if space := strings.LastIndex(string(runes[:columns]), " "); space > 0 {
+				cut = utf8.RuneCountInString(string(runes[:columns])[:space])
+			}
+			lines = append(lines, string(runes[:cut]))
+			runes = runes[cut:]
+			for len(runes) > 0 && runes[0] == ' ' {
+				runes = runes[1:]
+			}
+		}
+		if len(lines) >= maxLines {
+			break
+		}
+		lines = append(lines, string(runes))
+	}
+	return lines
+}
@@ -0,0 +1,235 @@
+package preview
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxEntrySize is the most read from one entry of a document's zip
+// archive, so that a small document cannot expand without limit
+const maxEntrySize = 64 << 20
+
+// officeFormat describes where a zip-based document keeps its text and,
+// if the application that saved it stored one, an image of its first page
+type officeFormat struct {
+	textEntries func(zr *zip.Reader) []string
+	thumbnails  []string
+	text        xmlTextRules
+}
+
+// xmlTextRules name, by local name, the XML elements holding text
+type xmlTextRules struct {
+	text       map[string]bool // character data inside is text
+	paragraph  map[string]bool // end with a line break
+	lineBreak  map[string]bool // are a line break
+	tab        map[string]bool // are a tab
+	whitespace map[string]bool // are a space
+}
+
+var ooxmlThumbnails = []string{"docProps/thumbnail.jpeg", "docProps/thumbnail.jpg", "docProps/thumbnail.png"}
+
+var (
+	docx = &officeFormat{
+		textEntries: entries("word/document.xml"),
+		thumbnails:  ooxmlThumbnails,
+		text: xmlTextRules{
+			text:      set("t"),
+			paragraph: set("p"),
+			lineBreak: set("br", "cr"),
+			tab:       set("tab"),
+		},
+	}
+	xlsx = &officeFormat{
+		textEntries: entries("xl/sharedStrings.xml"),
+		thumbnails:  ooxmlThumbnails,
+		text: xmlTextRules{
+			text:      set("t"),
+			paragraph: set("si"),
+		},
+	}
+	pptx = &officeFormat{
+		textEntries: numberedEntries("ppt/slides/slide", ".xml"),
+		thumbnails:  ooxmlThumbnails,
+		text: xmlTextRules{
+			text:      set("t"),
+			paragraph: set("p"),
+			lineBreak: set("br"),
+		},
+	}
+	odf = &officeFormat{
+		textEntries: entries("content.xml"),
+		thumbnails:  []string{"Thumbnails/thumbnail.png"},
+		text: xmlTextRules{
+			text:       set("p", "h"),
+			paragraph:  set("p", "h"),
+			lineBreak:  set("line-break"),
+			tab:        set("tab"),
+			whitespace: set("s"),
+		},
+	}
+)
+
+var officeFormats = map[string]*officeFormat{
+	".docx": docx,
+	".xlsx": xlsx,
+	".pptx": pptx,
+	".odt":  odf,
+	".ods":  odf,
+	".odp":  odf,
+}
+
+var officeMimeTypes = map[string]*officeFormat{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   docx,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         xlsx,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": pptx,
+	"application/vnd.oasis.opendocument.text":                                   odf,
+	"application/vnd.oasis.opendocument.spreadsheet":                            odf,
+	"application/vnd.oasis.opendocument.presentation":                           odf,
+}
+
+func officeFormatOf(mediaType, ext string) *officeFormat {
+	if format, ok := officeFormats[ext]; ok {
+		return format
+	}
+	return officeMimeTypes[mediaType]
+}
+
+// generateOffice extracts the text of a document and uses the image of its
+// first page stored in it, or else draws its text as its first page
+func generateOffice(data []byte, format *officeFormat) (*Result, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	w := newTextWriter()
+	for _, name := range format.textEntries(zr) {
+		if w.full() {
+			break
+		}
+		if err := readEntry(zr, name, func(r io.Reader) error {
+			return extractXMLText(r, &format.text, w)
+		}); err != nil && w.Len() == 0 {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+		}
+	}
+	text := w.String()
+
+	result := &Result{Text: text}
+	for _, name := range format.thumbnails {
+		_ = readEntry(zr, name, func(r io.Reader) error {
+			img, _, err := image.Decode(r)
+			if err == nil {
+				result.Image = img
+			}
+			return err
+		})
+		if result.Image != nil {
+			return result, nil
+		}
+	}
+
+	result.Image = renderPage(text)
+	return result, nil
+}
+
+func readEntry(zr *zip.Reader, name string, read func(r io.Reader) error) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return read(io.LimitReader(f, maxEntrySize))
+}
+
+// extractXMLText writes the text of an XML document to w
+func extractXMLText(r io.Reader, rules *xmlTextRules, w *textWriter) error {
+	decoder := xml.NewDecoder(r)
+	depth := 0
+
+	for !w.full() {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			switch {
+			case rules.text[name]:
+				depth++
+			case rules.lineBreak[name]:
+				w.WriteString("\n")
+			case rules.tab[name]:
+				w.WriteString("\t")
+			case rules.whitespace[name]:
+				w.WriteString(" ")
+			}
+		case xml.EndElement:
+			name := t.Name.Local
+			if rules.text[name] && depth > 0 {
+				depth--
+			}
+			if rules.paragraph[name] {
+				w.WriteString("\n")
+			}
+		case xml.CharData:
+			if depth > 0 {
+				w.Write(t)
+			}
+		}
+	}
+
+	return nil
+}
+
+// entries returns the named entries
+func entries(names ...string) func(zr *zip.Reader) []string {
+	return func(zr *zip.Reader) []string {
+		return names
+	}
+}
+
+// numberedEntries returns the entries named prefix, a number and suffix,
+// such as the slides of a presentation, in numeric order
+func numberedEntries(prefix, suffix string) func(zr *zip.Reader) []string {
+	return func(zr *zip.Reader) []string {
+		numbers := make(map[string]int)
+		var names []string
+		for _, f := range zr.File {
+			if path.Dir(f.Name) != path.Dir(prefix) || !strings.HasPrefix(f.Name, prefix) || !strings.HasSuffix(f.Name, suffix) {
+				continue
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(f.Name, prefix), suffix))
+			if err != nil {
+				continue
+			}
+			numbers[f.Name] = n
+			names = append(names, f.Name)
+		}
+
+		sort.Slice(names, func(i, j int) bool { return numbers[names[i]] < numbers[names[j]] })
+		return names
+	}
+}
+
+func set(names ...string) map[string]bool {
+	m := make(map[string]bool, len(names))
+	for _, name := range names {
+		m[name] = true
+	}
+	return m
+}
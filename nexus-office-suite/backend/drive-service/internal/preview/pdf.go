@@ -0,0 +1,43 @@
+package preview
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// pdfText returns the text layer of a PDF. Scanned documents have none.
+// The PDF reader panics on some malformed files, which is reported as the
+// file being unsupported.
+func pdfText(data []byte) (text string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			text, err = "", fmt.Errorf("%w: malformed PDF: %v", ErrUnsupported, r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	w := newTextWriter()
+	for i := 1; i <= reader.NumPage() && !w.full(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+
+		// Font names are local to a page, so fonts are not cached between
+		// pages
+		pageText, err := page.GetPlainText(nil)
+		if err != nil {
+			continue
+		}
+		w.WriteString(pageText)
+		w.WriteString("\n\n")
+	}
+
+	return w.String(), nil
+}
@@ -0,0 +1,143 @@
+// Package preview generates thumbnails and text extracts of drive files.
+// Everything is decoded in pure Go: JPEG, PNG, GIF, WebP, BMP and TIFF
+// images; Office Open XML and OpenDocument text documents, spreadsheets and
+// presentations, as exported by the suite's editors; the text layer of
+// PDFs; and plain text, CSV, Markdown and HTML.
+package preview
+
+import (
+	"errors"
+	"image"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// MaxPixels is the largest image, in pixels, that is decoded. Larger
+	// images would take too much memory to thumbnail.
+	MaxPixels = 50_000_000
+	// MaxTextSize is the most text, in bytes, extracted from a file
+	MaxTextSize = 1 << 20
+)
+
+var (
+	// ErrUnsupported is returned for files nothing can be generated from
+	ErrUnsupported = errors.New("file type has no preview")
+	// ErrTooLarge is returned for images larger than MaxPixels
+	ErrTooLarge = errors.New("file is too large to preview")
+)
+
+// Result is what is generated from a file
+type Result struct {
+	// Image is the image itself, or an image of the first page of a
+	// document, from which thumbnails are made. It is nil if there is none.
+	Image image.Image
+	// Text is the text of a document, at most MaxTextSize bytes. It is
+	// empty for images.
+	Text string
+}
+
+type kind int
+
+const (
+	kindUnsupported kind = iota
+	kindImage
+	kindOffice
+	kindPDF
+	kindText
+	kindHTML
+)
+
+var imageExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".bmp": true, ".tif": true, ".tiff": true,
+}
+
+var textExtensions = map[string]bool{
+	".txt": true, ".text": true, ".md": true, ".markdown": true, ".csv": true,
+	".tsv": true, ".log": true, ".json": true, ".xml": true, ".yaml": true,
+	".yml": true,
+}
+
+var textMimeTypes = map[string]bool{
+	"application/json": true,
+	"application/xml":  true,
+	"application/yaml": true,
+}
+
+// Generate generates the image and text of a file from its content. The
+// MIME type and file name decide how the content is read. It returns
+// ErrUnsupported for files of other types or that cannot be decoded.
+func Generate(data []byte, mimeType, filename string) (*Result, error) {
+	mediaType := mediaTypeOf(mimeType)
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	switch kindOf(mediaType, ext) {
+	case kindImage:
+		img, err := decodeImage(data)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Image: img}, nil
+
+	case kindOffice:
+		return generateOffice(data, officeFormatOf(mediaType, ext))
+
+	case kindPDF:
+		text, err := pdfText(data)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Image: renderPage(text), Text: text}, nil
+
+	case kindText:
+		text, err := plainText(data)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Image: renderPage(text), Text: text}, nil
+
+	case kindHTML:
+		text, err := htmlText(data)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Image: renderPage(text), Text: text}, nil
+	}
+
+	return nil, ErrUnsupported
+}
+
+// IsText reports whether a file is plain text or markup, which can be
+// compared line by line
+func IsText(mimeType, filename string) bool {
+	k := kindOf(mediaTypeOf(mimeType), strings.ToLower(filepath.Ext(filename)))
+	return k == kindText || k == kindHTML
+}
+
+func kindOf(mediaType, ext string) kind {
+	switch {
+	case imageExtensions[ext], strings.HasPrefix(mediaType, "image/") && mediaType != "image/svg+xml":
+		return kindImage
+	case officeFormatOf(mediaType, ext) != nil:
+		return kindOffice
+	case ext == ".pdf", mediaType == "application/pdf":
+		return kindPDF
+	case ext == ".html", ext == ".htm", mediaType == "text/html", mediaType == "application/xhtml+xml":
+		return kindHTML
+	case textExtensions[ext], strings.HasPrefix(mediaType, "text/"), textMimeTypes[mediaType]:
+		return kindText
+	}
+
+	return kindUnsupported
+}
+
+// mediaTypeOf returns the media type of a MIME type, without parameters
+func mediaTypeOf(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return strings.ToLower(mimeType)
+	}
+	return mediaType
+}
@@ -0,0 +1,245 @@
+package preview
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.RGBA{R: 0xff, A: 0xff})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipDocument builds a document archive from entry names and contents
+func zipDocument(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGenerateImage(t *testing.T) {
+	result, err := Generate(encodePNG(t, 200, 100), "image/png", "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Image == nil || result.Image.Bounds().Dx() != 200 || result.Text != "" {
+		t.Fatalf("Generate = %+v, want the 200x100 image and no text", result)
+	}
+
+	thumbnails, err := Thumbnails(result.Image, []int{64, 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for size, want := range map[int]image.Point{64: {64, 32}, 256: {200, 100}} {
+		config, _, err := image.DecodeConfig(bytes.NewReader(thumbnails[size]))
+		if err != nil {
+			t.Fatalf("thumbnail %d: %v", size, err)
+		}
+		if got := (image.Point{config.Width, config.Height}); got != want {
+			t.Errorf("thumbnail %d is %v, want %v", size, got, want)
+		}
+	}
+}
+
+func TestGenerateImageTooLarge(t *testing.T) {
+	// Claim a size in the header that would not fit in memory
+	data := encodePNG(t, 10, 10)
+	binary.BigEndian.PutUint32(data[16:20], 100_000)
+	binary.BigEndian.PutUint32(data[20:24], 100_000)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+
+	if _, err := Generate(data, "image/png", "huge.png"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestGenerateOfficeDocuments(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries map[string]string
+		want    string
+	}{
+		{
+			name: "report.docx",
+			entries: map[string]string{"word/document.xml": `<w:document xmlns:w="w"><w:body>` +
+				`<w:p><w:r><w:t>Quarterly</w:t></w:r><w:r><w:tab/><w:t>report</w:t></w:r></w:p>` +
+				`<w:p><w:r><w:t>Second</w:t><w:br/><w:t>line</w:t></w:r></w:p>` +
+				`</w:body></w:document>`},
+			want: "Quarterly\treport\nSecond\nline",
+		},
+		{
+			// Slides are read in numeric, not lexical, order
+			name: "deck.pptx",
+			entries: map[string]string{
+				"ppt/slides/slide10.xml":      `<p:sld xmlns:p="p" xmlns:a="a"><a:p><a:t>Ten</a:t></a:p></p:sld>`,
+				"ppt/slides/slide2.xml":       `<p:sld xmlns:p="p" xmlns:a="a"><a:p><a:t>Two</a:t></a:p></p:sld>`,
+				"ppt/slides/slide1.xml":       `<p:sld xmlns:p="p" xmlns:a="a"><a:p><a:t>One</a:t></a:p></p:sld>`,
+				"ppt/slides/_rels/slide1.xml": `<a:p xmlns:a="a"><a:t>Relationships</a:t></a:p>`,
+				"ppt/slides/slideNotes.xml":   `<a:p xmlns:a="a"><a:t>Notes</a:t></a:p>`,
+				"ppt/slideLayouts/slide3.xml": `<a:p xmlns:a="a"><a:t>Layout</a:t></a:p>`,
+			},
+			want: "One\nTwo\nTen",
+		},
+		{
+			name: "minutes.odt",
+			entries: map[string]string{"content.xml": `<office:document-content xmlns:office="o" xmlns:text="t">` +
+				`<text:h>Minutes</text:h><text:p>Present:<text:s/>all</text:p>` +
+				`</office:document-content>`},
+			want: "Minutes\nPresent: all",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Generate(zipDocument(t, tt.entries), "application/octet-stream", tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Text != tt.want {
+				t.Errorf("text = %q, want %q", result.Text, tt.want)
+			}
+			if result.Image == nil || result.Image.Bounds().Dx() != pageWidth {
+				t.Error("no first page was drawn")
+			}
+		})
+	}
+}
+
+func TestGenerateOfficeStoredThumbnail(t *testing.T) {
+	data := zipDocument(t, map[string]string{
+		"xl/sharedStrings.xml":   `<sst><si><t>Total</t></si><si><t>42</t></si></sst>`,
+		"docProps/thumbnail.png": string(encodePNG(t, 30, 20)),
+	})
+
+	result, err := Generate(data, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "budget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != "Total\n42" {
+		t.Errorf("text = %q, want the shared strings", result.Text)
+	}
+	if result.Image == nil || result.Image.Bounds().Dx() != 30 {
+		t.Error("the thumbnail stored in the document was not used")
+	}
+}
+
+func TestGenerateText(t *testing.T) {
+	page := `<html><head><title>Hidden</title><style>p {}</style></head>` +
+		`<body><h1>Title</h1><p>Some   <b>bold</b>` + "\n" + `text</p><script>alert(1)</script><ul><li>one</li><li>two</li></ul></body></html>`
+
+	tests := []struct {
+		data     string
+		mimeType string
+		filename string
+		want     string
+	}{
+		{page, "text/html; charset=utf-8", "page", "Title\n\nSome bold text\n\none\n\ntwo"},
+		{"a,b  \r\nc,d\r\n\r\n\r\n\r\ne,f\n", "application/octet-stream", "data.csv", "a,b\nc,d\n\ne,f"},
+		{`{"key": "value"}`, "application/json", "config", `{"key": "value"}`},
+	}
+	for _, tt := range tests {
+		result, err := Generate([]byte(tt.data), tt.mimeType, tt.filename)
+		if err != nil {
+			t.Errorf("%s: %v", tt.filename, err)
+			continue
+		}
+		if result.Text != tt.want {
+			t.Errorf("%s: text = %q, want %q", tt.filename, result.Text, tt.want)
+		}
+	}
+}
+
+func TestGenerateUnsupported(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		mimeType string
+		filename string
+	}{
+		{[]byte("MZ\x90\x00"), "application/octet-stream", "setup.exe"},
+		{[]byte("text\x00with a NUL"), "text/plain", "binary.txt"},
+		{[]byte("not a zip"), "", "broken.docx"},
+		{[]byte("not an image"), "image/png", "broken.png"},
+		{[]byte("<svg/>"), "image/svg+xml", "drawing"},
+	}
+	for _, tt := range tests {
+		if _, err := Generate(tt.data, tt.mimeType, tt.filename); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: got %v, want %v", tt.filename, err, ErrUnsupported)
+		}
+	}
+}
+
+func TestTextIsLimited(t *testing.T) {
+	result, err := Generate([]byte(strings.Repeat("word ", MaxTextSize)), "text/plain", "long.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Text) > MaxTextSize {
+		t.Errorf("extracted %d bytes of text, want at most %d", len(result.Text), MaxTextSize)
+	}
+}
+
+func TestIsText(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		filename string
+		want     bool
+	}{
+		{"text/plain", "notes", true},
+		{"", "README.md", true},
+		{"text/html", "index.html", true},
+		{"application/octet-stream", "report.docx", false},
+		{"image/png", "photo.png", false},
+		{"application/pdf", "paper.pdf", false},
+	}
+	for _, tt := range tests {
+		if got := IsText(tt.mimeType, tt.filename); got != tt.want {
+			t.Errorf("IsText(%q, %q) = %v, want %v", tt.mimeType, tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	got := wrapText("the quick brown fox\n\tjumps", 10)
+	want := []string{"the quick", "brown fox", "    jumps"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("wrapText = %q, want %q", got, want)
+	}
+
+	// A word longer than a line is cut
+	if got := wrapText("abcdefghijkl", 5); strings.Join(got, "|") != "abcde|fghij|kl" {
+		t.Errorf("wrapText of a long word = %q", got)
+	}
+
+	// Only the first page is wrapped, however long the line
+	if got := wrapText(strings.Repeat("a", 1<<20), 10); len(got) > pageHeight/pageLineHeight+1 {
+		t.Errorf("wrapText returned %d lines, more than a page", len(got))
+	}
+}
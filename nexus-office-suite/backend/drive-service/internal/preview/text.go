@@ -0,0 +1,117 @@
+package preview
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// htmlBlockElements end a line of text extracted from HTML
+var htmlBlockElements = set(
+	"address", "article", "aside", "blockquote", "br", "dd", "div", "dl", "dt",
+	"figcaption", "footer", "h1", "h2", "h3", "h4", "h5", "h6", "header", "hr",
+	"li", "main", "nav", "ol", "p", "pre", "section", "table", "td", "th", "tr", "ul",
+)
+
+// htmlSkippedElements contain no readable text
+var htmlSkippedElements = set("head", "script", "style", "template", "noscript")
+
+var (
+	blankLines = regexp.MustCompile(`\n{3,}`)
+	spaces     = regexp.MustCompile(`[ \t\f\v]+`)
+)
+
+// plainText returns the text of a text file. Files containing NUL bytes are
+// taken to be binary.
+func plainText(data []byte) (string, error) {
+	if len(data) > MaxTextSize {
+		data = data[:MaxTextSize]
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", ErrUnsupported
+	}
+
+	w := newTextWriter()
+	w.Write(data)
+	return w.String(), nil
+}
+
+// htmlText returns the readable text of an HTML document
+func htmlText(data []byte) (string, error) {
+	w := newTextWriter()
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	skip := 0
+
+	for !w.full() {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			// The only error reading from memory is io.EOF
+			return w.String(), nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			if htmlSkippedElements[string(name)] && tokenType == html.StartTagToken {
+				skip++
+			}
+			if htmlBlockElements[string(name)] {
+				w.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if htmlSkippedElements[string(name)] && skip > 0 {
+				skip--
+			}
+			if htmlBlockElements[string(name)] {
+				w.WriteString("\n")
+			}
+		case html.TextToken:
+			if skip == 0 {
+				w.WriteString(spaces.ReplaceAllString(strings.ReplaceAll(string(tokenizer.Text()), "\n", " "), " "))
+			}
+		}
+	}
+
+	return w.String(), nil
+}
+
+// textWriter collects extracted text up to MaxTextSize bytes
+type textWriter struct {
+	bytes.Buffer
+}
+
+func newTextWriter() *textWriter {
+	return &textWriter{}
+}
+
+func (w *textWriter) full() bool {
+	return w.Len() >= MaxTextSize
+}
+
+func (w *textWriter) Write(p []byte) (int, error) {
+	if room := MaxTextSize - w.Len(); len(p) > room {
+		p = p[:max(room, 0)]
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *textWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// String returns the text as valid UTF-8, with line endings normalized,
+// trailing spaces and runs of blank lines removed
+func (w *textWriter) String() string {
+	text := strings.ToValidUTF8(w.Buffer.String(), "")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	text = blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+// activityColumns are the columns of activities read into model.Activity
+const activityColumns = `
+	id, tenant_id, actor_id, action, resource_type, resource_id, resource_name,
+	from_folder_id, share_link_id, ip_address, user_agent, details, created_at`
+
+type ActivityRepository interface {
+	Record(ctx context.Context, activity *model.Activity) error
+	Query(ctx context.Context, tenantID uuid.UUID, query *model.ActivityQuery, after *model.ActivityCursor, limit int) ([]*model.Activity, error)
+	DeleteExpired(ctx context.Context, defaultDays int, now time.Time, limit int) (int, error)
+	GetRetentionPolicy(ctx context.Context, tenantID uuid.UUID) (*model.ActivityRetentionPolicy, error)
+	SetRetentionPolicy(ctx context.Context, policy *model.ActivityRetentionPolicy) error
+}
+
+type activityRepository struct {
+	db *sqlx.DB
+}
+
+func NewActivityRepository(db *sqlx.DB) ActivityRepository {
+	return &activityRepository{db: db}
+}
+
+// Record adds an activity to the log, with the folders that contain the
+// resource and, for moves, those that contained it before. Resources must
+// still exist when their activity is recorded for their folders to be
+// known.
+func (r *activityRepository) Record(ctx context.Context, activity *model.Activity) error {
+	query := `
+		INSERT INTO activities (
+			id, tenant_id, actor_id, action, resource_type, resource_id, resource_name,
+			from_folder_id, folder_ids, share_link_id, ip_address, user_agent, details,
+			created_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8,
+			ARRAY(
+				SELECT resource_id FROM resource_ancestry($5, $6) WHERE resource_type = 'folder'
+				UNION
+				SELECT resource_id FROM resource_ancestry('folder', $8)
+			),
+			$9, $10, $11, $12, $13
+		)
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		activity.ID, activity.TenantID, activity.ActorID, activity.Action,
+		activity.ResourceType, activity.ResourceID, activity.ResourceName,
+		activity.FromFolderID, activity.ShareLinkID, activity.IPAddress,
+		activity.UserAgent, activity.Details, activity.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record activity: %w", err)
+	}
+
+	return nil
+}
+
+// Query returns up to limit activities of a tenant matching query, newest
+// first, after the cursor if it is not nil
+func (r *activityRepository) Query(ctx context.Context, tenantID uuid.UUID, query *model.ActivityQuery, after *model.ActivityCursor, limit int) ([]*model.Activity, error) {
+	var activities []*model.Activity
+
+	args := []interface{}{tenantID}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	var filters strings.Builder
+	if query.FileID != nil {
+		fmt.Fprintf(&filters, ` AND resource_type = 'file' AND resource_id = %s`, arg(*query.FileID))
+	}
+	if query.FolderID != nil {
+		fmt.Fprintf(&filters, ` AND %s = ANY(folder_ids)`, arg(*query.FolderID))
+	}
+	if query.ActorID != nil {
+		fmt.Fprintf(&filters, ` AND actor_id = %s`, arg(*query.ActorID))
+	}
+	if len(query.Actions) > 0 {
+		actions := make([]string, len(query.Actions))
+		for i, action := range query.Actions {
+			actions[i] = string(action)
+		}
+		fmt.Fprintf(&filters, ` AND action = ANY(%s)`, arg(pq.Array(actions)))
+	}
+	if query.Since != nil {
+		fmt.Fprintf(&filters, ` AND created_at >= %s`, arg(*query.Since))
+	}
+	if query.Until != nil {
+		fmt.Fprintf(&filters, ` AND created_at < %s`, arg(*query.Until))
+	}
+	if after != nil {
+		fmt.Fprintf(&filters, ` AND (created_at, id) < (%s, %s)`, arg(after.CreatedAt), arg(after.ID))
+	}
+
+	sqlQuery := fmt.Sprintf(`
+		SELECT %s FROM activities
+		WHERE tenant_id = $1%s
+		ORDER BY created_at DESC, id DESC
+		LIMIT %s
+	`, activityColumns, filters.String(), arg(limit))
+
+	err := r.db.SelectContext(ctx, &activities, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query activity: %w", err)
+	}
+
+	return activities, nil
+}
+
+// DeleteExpired deletes up to limit activities older than their tenant's
+// retention at now, or defaultDays for tenants without a policy, and
+// returns the number deleted. Retentions of 0 keep activity forever.
+func (r *activityRepository) DeleteExpired(ctx context.Context, defaultDays int, now time.Time, limit int) (int, error) {
+	query := `
+		DELETE FROM activities WHERE id IN (
+			SELECT a.id FROM activities a
+			LEFT JOIN activity_retention_policies p ON p.tenant_id = a.tenant_id
+			WHERE COALESCE(p.retention_days, $1) > 0
+			AND a.created_at < $2::timestamp - make_interval(days => COALESCE(p.retention_days, $1))
+			LIMIT $3
+		)
+	`
+
+	result, err := r.db.ExecContext(ctx, query, defaultDays, now, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired activity: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	return int(rows), nil
+}
+
+// GetRetentionPolicy returns the activity retention set for a tenant, or
+// nil if the tenant uses the default
+func (r *activityRepository) GetRetentionPolicy(ctx context.Context, tenantID uuid.UUID) (*model.ActivityRetentionPolicy, error) {
+	var policy model.ActivityRetentionPolicy
+	query := `SELECT * FROM activity_retention_policies WHERE tenant_id = $1`
+
+	err := r.db.GetContext(ctx, &policy, query, tenantID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get activity retention policy: %w", err)
+	}
+
+	return &policy, nil
+}
+
+func (r *activityRepository) SetRetentionPolicy(ctx context.Context, policy *model.ActivityRetentionPolicy) error {
+	query := `
+		INSERT INTO activity_retention_policies (tenant_id, retention_days, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id) DO UPDATE SET
+			retention_days = EXCLUDED.retention_days,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	_, err := r.db.ExecContext(ctx, query, policy.TenantID, policy.RetentionDays, policy.UpdatedBy, policy.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to set activity retention policy: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+type AppPasswordRepository interface {
+	Create(ctx context.Context, appPassword *model.AppPassword) error
+	GetByHash(ctx context.Context, passwordHash string) (*model.AppPassword, error)
+	GetByUser(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.AppPassword, error)
+	Delete(ctx context.Context, tenantID, userID, id uuid.UUID) error
+	Touch(ctx context.Context, id uuid.UUID) error
+}
+
+type appPasswordRepository struct {
+	db *sqlx.DB
+}
+
+func NewAppPasswordRepository(db *sqlx.DB) AppPasswordRepository {
+	return &appPasswordRepository{db: db}
+}
+
+func (r *appPasswordRepository) Create(ctx context.Context, appPassword *model.AppPassword) error {
+	query := `
+		INSERT INTO app_passwords (
+			id, tenant_id, user_id, login, name, password_hash, created_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7
+		)
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		appPassword.ID, appPassword.TenantID, appPassword.UserID, appPassword.Login,
+		appPassword.Name, appPassword.PasswordHash, appPassword.CreatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create app password: %w", err)
+	}
+
+	return nil
+}
+
+func (r *appPasswordRepository) GetByHash(ctx context.Context, passwordHash string) (*model.AppPassword, error) {
+	var appPassword model.AppPassword
+	query := `SELECT * FROM app_passwords WHERE password_hash = $1`
+
+	err := r.db.GetContext(ctx, &appPassword, query, passwordHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("app password not found")
+		}
+		return nil, fmt.Errorf("failed to get app password: %w", err)
+	}
+
+	return &appPassword, nil
+}
+
+func (r *appPasswordRepository) GetByUser(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.AppPassword, error) {
+	var appPasswords []*model.AppPassword
+	query := `
+		SELECT * FROM app_passwords
+		WHERE tenant_id = $1 AND user_id = $2
+		ORDER BY created_at DESC
+	`
+
+	err := r.db.SelectContext(ctx, &appPasswords, query, tenantID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get app passwords: %w", err)
+	}
+
+	return appPasswords, nil
+}
+
+// Delete deletes one of a user's app passwords
+func (r *appPasswordRepository) Delete(ctx context.Context, tenantID, userID, id uuid.UUID) error {
+	query := `DELETE FROM app_passwords WHERE id = $1 AND tenant_id = $2 AND user_id = $3`
+
+	result, err := r.db.ExecContext(ctx, query, id, tenantID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete app password: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("app password not found")
+	}
+
+	return nil
+}
+
+// Touch records that an app password was used. Clients sign in on every
+// request, so it is recorded at most once a minute.
+func (r *appPasswordRepository) Touch(ctx context.Context, id uuid.UUID) error {
+	query := `
+		UPDATE app_passwords SET last_used_at = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $3)
+	`
+
+	now := time.Now()
+	_, err := r.db.ExecContext(ctx, query, id, now, now.Add(-time.Minute))
+	if err != nil {
+		return fmt.Errorf("failed to update app password: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+var ErrExtractionNotFound = errors.New("archive extraction not found")
+
+type ArchiveRepository interface {
+	Create(ctx context.Context, extraction *model.ArchiveExtraction) error
+	GetByID(ctx context.Context, id uuid.UUID) (*model.ArchiveExtraction, error)
+	Claim(ctx context.Context, limit int, leaseUntil time.Time) ([]*model.ArchiveExtraction, error)
+	UpdateProgress(ctx context.Context, extraction *model.ArchiveExtraction, leaseUntil time.Time) error
+	Finish(ctx context.Context, extraction *model.ArchiveExtraction) error
+}
+
+type archiveRepository struct {
+	db *sqlx.DB
+}
+
+func NewArchiveRepository(db *sqlx.DB) ArchiveRepository {
+	return &archiveRepository{db: db}
+}
+
+func (r *archiveRepository) Create(ctx context.Context, extraction *model.ArchiveExtraction) error {
+	query := `
+		INSERT INTO archive_extractions (
+			id, tenant_id, user_id, file_id, folder_id, status,
+			run_at, created_at, updated_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9
+		)
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		extraction.ID, extraction.TenantID, extraction.UserID, extraction.FileID,
+		extraction.FolderID, extraction.Status, extraction.RunAt, extraction.CreatedAt,
+		extraction.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create archive extraction: %w", err)
+	}
+
+	return nil
+}
+
+func (r *archiveRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.ArchiveExtraction, error) {
+	var extraction model.ArchiveExtraction
+	query := `SELECT * FROM archive_extractions WHERE id = $1`
+
+	err := r.db.GetContext(ctx, &extraction, query, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrExtractionNotFound
+		}
+		return nil, fmt.Errorf("failed to get archive extraction: %w", err)
+	}
+
+	return &extraction, nil
+}
+
+// Claim marks up to limit due extractions as running until leaseUntil and
+// returns them, counting the attempt. Extractions whose workers have not
+// reported progress by the end of their lease are claimed again.
+func (r *archiveRepository) Claim(ctx context.Context, limit int, leaseUntil time.Time) ([]*model.ArchiveExtraction, error) {
+	var extractions []*model.ArchiveExtraction
+	query := `
+		UPDATE archive_extractions SET
+			status = $1, attempts = attempts + 1, run_at = $2, updated_at = $3
+		WHERE id IN (
+			SELECT id FROM archive_extractions
+			WHERE status IN ($4, $1) AND run_at <= $3
+			ORDER BY run_at
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`
+
+	err := r.db.SelectContext(ctx, &extractions, query,
+		model.ExtractionStatusRunning, leaseUntil, time.Now(), model.ExtractionStatusPending, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim archive extractions: %w", err)
+	}
+
+	return extractions, nil
+}
+
+// UpdateProgress records the progress of a running extraction and extends
+// its lease to leaseUntil
+func (r *archiveRepository) UpdateProgress(ctx context.Context, extraction *model.ArchiveExtraction, leaseUntil time.Time) error {
+	query := `
+		UPDATE archive_extractions SET
+			progress = $2, entries_extracted = $3, entries_skipped = $4,
+			bytes_extracted = $5, run_at = $6, updated_at = $7
+		WHERE id = $1
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		extraction.ID, extraction.Progress, extraction.EntriesExtracted,
+		extraction.EntriesSkipped, extraction.BytesExtracted, leaseUntil, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update archive extraction: %w", err)
+	}
+
+	return nil
+}
+
+// Finish records the outcome of an extraction
+func (r *archiveRepository) Finish(ctx context.Context, extraction *model.ArchiveExtraction) error {
+	query := `
+		UPDATE archive_extractions SET
+			status = $2, progress = $3, entries_extracted = $4, entries_skipped = $5,
+			bytes_extracted = $6, error = $7, updated_at = $8, completed_at = $9
+		WHERE id = $1
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		extraction.ID, extraction.Status, extraction.Progress, extraction.EntriesExtracted,
+		extraction.EntriesSkipped, extraction.BytesExtracted, extraction.Error,
+		time.Now(), extraction.CompletedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update archive extraction: %w", err)
+	}
+
+	return nil
+}
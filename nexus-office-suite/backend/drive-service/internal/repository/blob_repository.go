@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+type BlobRepository interface {
+	Create(ctx context.Context, blob *model.Blob) error
+	Touch(ctx context.Context, tenantID uuid.UUID, checksum string) (*model.Blob, error)
+	GetForScrub(ctx context.Context, limit int) ([]*model.Blob, error)
+	SetStatus(ctx context.Context, storagePath string, status model.BlobStatus, verifiedAt time.Time) error
+	DeleteUnreferenced(ctx context.Context, before time.Time, limit int) ([]*model.Blob, error)
+}
+
+type blobRepository struct {
+	db *sqlx.DB
+}
+
+func NewBlobRepository(db *sqlx.DB) BlobRepository {
+	return &blobRepository{db: db}
+}
+
+// Create records a blob whose content has just been written. If the tenant
+// already has a blob with the same checksum, that one is touched and,
+// since its content was rewritten, marked ok.
+func (r *blobRepository) Create(ctx context.Context, blob *model.Blob) error {
+	query := `
+		INSERT INTO blobs (
+			storage_path, tenant_id, checksum, size, ref_count, status, created_at, updated_at
+		) VALUES (
+			$1, $2, $3, $4, 0, $5, $6, $7
+		)
+		ON CONFLICT (tenant_id, checksum) DO UPDATE SET
+			status = EXCLUDED.status, updated_at = EXCLUDED.updated_at
+		RETURNING *
+	`
+
+	err := r.db.GetContext(ctx, blob, query,
+		blob.StoragePath, blob.TenantID, blob.Checksum, blob.Size,
+		model.BlobStatusOK, blob.CreatedAt, blob.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+
+	return nil
+}
+
+// Touch returns the tenant's blob with the checksum, marking it as just used
+// so that it is not collected before the caller references it
+func (r *blobRepository) Touch(ctx context.Context, tenantID uuid.UUID, checksum string) (*model.Blob, error) {
+	var blob model.Blob
+	query := `
+		UPDATE blobs SET updated_at = $3
+		WHERE tenant_id = $1 AND checksum = $2
+		RETURNING *
+	`
+
+	err := r.db.GetContext(ctx, &blob, query, tenantID, checksum, time.Now())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("blob not found")
+		}
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+
+	return &blob, nil
+}
+
+// GetForScrub returns the referenced blobs verified longest ago, never
+// verified first
+func (r *blobRepository) GetForScrub(ctx context.Context, limit int) ([]*model.Blob, error) {
+	var blobs []*model.Blob
+	query := `
+		SELECT * FROM blobs
+		WHERE ref_count > 0
+		ORDER BY verified_at ASC NULLS FIRST
+		LIMIT $1
+	`
+
+	err := r.db.SelectContext(ctx, &blobs, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blobs: %w", err)
+	}
+
+	return blobs, nil
+}
+
+func (r *blobRepository) SetStatus(ctx context.Context, storagePath string, status model.BlobStatus, verifiedAt time.Time) error {
+	query := `UPDATE blobs SET status = $2, verified_at = $3 WHERE storage_path = $1`
+
+	_, err := r.db.ExecContext(ctx, query, storagePath, status, verifiedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update blob status: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUnreferenced removes the records of blobs that nothing has
+// referenced since before and returns them, so that their content can be
+// deleted from storage
+func (r *blobRepository) DeleteUnreferenced(ctx context.Context, before time.Time, limit int) ([]*model.Blob, error) {
+	var blobs []*model.Blob
+	query := `
+		DELETE FROM blobs
+		WHERE storage_path IN (
+			SELECT storage_path FROM blobs
+			WHERE ref_count <= 0 AND updated_at < $1
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		AND ref_count <= 0
+		RETURNING *
+	`
+
+	err := r.db.SelectContext(ctx, &blobs, query, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete unreferenced blobs: %w", err)
+	}
+
+	return blobs, nil
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+// ChangeRepository handles users' change journals
+type ChangeRepository interface {
+	Record(ctx context.Context, change *model.Change, userIDs []uuid.UUID) ([]uuid.UUID, error)
+	GetAudience(ctx context.Context, resourceType model.ResourceType, resourceID uuid.UUID) ([]uuid.UUID, error)
+	GetCursor(ctx context.Context, userID uuid.UUID) (*model.ChangeCursor, error)
+	List(ctx context.Context, userID uuid.UUID, afterSeq int64, limit int) ([]*model.Change, error)
+	Prune(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+type changeRepository struct {
+	db *sqlx.DB
+}
+
+func NewChangeRepository(db *sqlx.DB) ChangeRepository {
+	return &changeRepository{db: db}
+}
+
+// Record adds a change to the journal of every user who can view the
+// resource, and of userIDs, such as users who could view it before the
+// change. It returns the users it was recorded for.
+func (r *changeRepository) Record(ctx context.Context, change *model.Change, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	// Cursors are locked in a consistent order, so that changes recorded
+	// at the same time for overlapping users cannot deadlock
+	query := `
+		WITH audience AS (
+			SELECT user_id FROM resource_audience($2, $3)
+			UNION
+			SELECT unnest($7::uuid[])
+		),
+		cursors AS (
+			INSERT INTO change_cursors (user_id, tenant_id, last_seq)
+			SELECT user_id, $1, 1 FROM audience ORDER BY user_id
+			ON CONFLICT (user_id) DO UPDATE SET last_seq = change_cursors.last_seq + 1
+			RETURNING user_id, last_seq
+		)
+		INSERT INTO changes (
+			user_id, seq, tenant_id, resource_type, resource_id, change_type, actor_id, created_at
+		)
+		SELECT user_id, last_seq, $1, $2, $3, $4, $5, $6 FROM cursors
+		RETURNING user_id
+	`
+
+	var recipients []uuid.UUID
+	err := r.db.SelectContext(ctx, &recipients, query,
+		change.TenantID, change.ResourceType, change.ResourceID, change.ChangeType,
+		change.ActorID, change.CreatedAt, pq.Array(userIDs),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record change: %w", err)
+	}
+
+	return recipients, nil
+}
+
+// GetAudience returns the users who can view a resource
+func (r *changeRepository) GetAudience(ctx context.Context, resourceType model.ResourceType, resourceID uuid.UUID) ([]uuid.UUID, error) {
+	var userIDs []uuid.UUID
+	query := `SELECT user_id FROM resource_audience($1, $2)`
+
+	err := r.db.SelectContext(ctx, &userIDs, query, resourceType, resourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audience: %w", err)
+	}
+
+	return userIDs, nil
+}
+
+// GetCursor returns a user's position in their journal, which is zero if
+// nothing has been recorded for them
+func (r *changeRepository) GetCursor(ctx context.Context, userID uuid.UUID) (*model.ChangeCursor, error) {
+	var cursor model.ChangeCursor
+	query := `SELECT last_seq, pruned_seq FROM change_cursors WHERE user_id = $1`
+
+	err := r.db.GetContext(ctx, &cursor, query, userID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get change cursor: %w", err)
+	}
+
+	return &cursor, nil
+}
+
+// List returns up to limit of a user's changes after afterSeq, oldest
+// first, noting whether the user can still view each resource
+func (r *changeRepository) List(ctx context.Context, userID uuid.UUID, afterSeq int64, limit int) ([]*model.Change, error) {
+	var changes []*model.Change
+	query := `
+		SELECT c.*, effective_role(c.resource_type, c.resource_id, c.user_id) IS NOT NULL AS visible
+		FROM changes c
+		WHERE c.user_id = $1 AND c.seq > $2
+		ORDER BY c.seq
+		LIMIT $3
+	`
+
+	err := r.db.SelectContext(ctx, &changes, query, userID, afterSeq, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changes: %w", err)
+	}
+
+	return changes, nil
+}
+
+// Prune deletes up to limit changes recorded before the given time and
+// returns how many were deleted. Users' pruned_seq is advanced past them,
+// so that cursors pointing before them are known to have expired.
+func (r *changeRepository) Prune(ctx context.Context, before time.Time, limit int) (int, error) {
+	query := `
+		WITH deleted AS (
+			DELETE FROM changes
+			WHERE (user_id, seq) IN (
+				SELECT user_id, seq FROM changes
+				WHERE created_at < $1
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING user_id, seq
+		),
+		pruned AS (
+			UPDATE change_cursors c SET pruned_seq = GREATEST(c.pruned_seq, d.seq)
+			FROM (SELECT user_id, MAX(seq) AS seq FROM deleted GROUP BY user_id) d
+			WHERE c.user_id = d.user_id
+		)
+		SELECT COUNT(*) FROM deleted
+	`
+
+	var deleted int
+	if err := r.db.GetContext(ctx, &deleted, query, before, limit); err != nil {
+		return 0, fmt.Errorf("failed to prune changes: %w", err)
+	}
+
+	return deleted, nil
+}
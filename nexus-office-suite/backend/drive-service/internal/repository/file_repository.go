@@ -3,24 +3,34 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/nexus/drive-service/internal/model"
 )
 
+// ErrConflict is returned when a file being updated was changed since it
+// was read
+var ErrConflict = errors.New("file was changed by another request")
+
 type FileRepository interface {
 	Create(ctx context.Context, file *model.File) error
 	GetByID(ctx context.Context, id uuid.UUID) (*model.File, error)
+	GetByIDs(ctx context.Context, ids []uuid.UUID) ([]*model.File, error)
 	GetByTenant(ctx context.Context, tenantID, userID uuid.UUID, folderID *uuid.UUID, includeShared bool) ([]*model.File, error)
+	GetByFolders(ctx context.Context, folderIDs []uuid.UUID) ([]*model.File, error)
+	GetByName(ctx context.Context, tenantID, userID uuid.UUID, folderID *uuid.UUID, name string) (*model.File, error)
 	Update(ctx context.Context, file *model.File) error
+	UpdateContent(ctx context.Context, file *model.File) error
+	SetThumbnailPath(ctx context.Context, tenantID uuid.UUID, checksum string, thumbnailPath *string) error
 	Delete(ctx context.Context, id uuid.UUID) error
 	MoveToTrash(ctx context.Context, id uuid.UUID) error
 	RestoreFromTrash(ctx context.Context, id uuid.UUID) error
 	PermanentDelete(ctx context.Context, id uuid.UUID) error
-	Search(ctx context.Context, tenantID uuid.UUID, query string, fileType *model.FileType) ([]*model.File, error)
 	GetStarred(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.File, error)
 	GetTrashed(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.File, error)
 	GetRecent(ctx context.Context, tenantID, userID uuid.UUID, limit int) ([]*model.File, error)
@@ -39,20 +49,21 @@ func (r *fileRepository) Create(ctx context.Context, file *model.File) error {
 	query := `
 		INSERT INTO files (
 			id, tenant_id, owner_id, folder_id, name, original_name,
-			mime_type, file_type, size, storage_path, version,
+			mime_type, file_type, size, storage_path, checksum, version,
 			is_starred, is_trashed, description, tags, metadata,
 			thumbnail_path, created_at, updated_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20
 		)
+		RETURNING revision
 	`
 
-	_, err := r.db.ExecContext(ctx, query,
+	err := r.db.QueryRowxContext(ctx, query,
 		file.ID, file.TenantID, file.OwnerID, file.FolderID, file.Name, file.OriginalName,
-		file.MimeType, file.FileType, file.Size, file.StoragePath, file.Version,
+		file.MimeType, file.FileType, file.Size, file.StoragePath, file.Checksum, file.Version,
 		file.IsStarred, file.IsTrashed, file.Description, file.Tags, file.Metadata,
 		file.ThumbnailPath, file.CreatedAt, file.UpdatedAt,
-	)
+	).Scan(&file.Revision)
 
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
@@ -78,6 +89,23 @@ func (r *fileRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.File
 	return &file, nil
 }
 
+// GetByIDs returns the files with the given IDs, including trashed files
+func (r *fileRepository) GetByIDs(ctx context.Context, ids []uuid.UUID) ([]*model.File, error) {
+	var files []*model.File
+	if len(ids) == 0 {
+		return files, nil
+	}
+
+	query := `SELECT * FROM files WHERE id = ANY($1)`
+
+	err := r.db.SelectContext(ctx, &files, query, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files: %w", err)
+	}
+
+	return files, nil
+}
+
 func (r *fileRepository) GetByTenant(ctx context.Context, tenantID, userID uuid.UUID, folderID *uuid.UUID, includeShared bool) ([]*model.File, error) {
 	var files []*model.File
 
@@ -85,26 +113,25 @@ func (r *fileRepository) GetByTenant(ctx context.Context, tenantID, userID uuid.
 	var args []interface{}
 
 	if includeShared {
-		// Include files owned by user or shared with user
+		// Include files owned by user or shared with user, directly or
+		// through a folder
 		if folderID == nil {
 			query = `
-				SELECT DISTINCT f.* FROM files f
-				LEFT JOIN permissions p ON f.id = p.resource_id AND p.resource_type = 'file'
+				SELECT f.* FROM files f
 				WHERE f.tenant_id = $1
 				AND f.is_trashed = false
 				AND f.folder_id IS NULL
-				AND (f.owner_id = $2 OR p.user_id = $2)
+				AND (f.owner_id = $2 OR effective_role('file', f.id, $2) IS NOT NULL)
 				ORDER BY f.updated_at DESC
 			`
 			args = []interface{}{tenantID, userID}
 		} else {
 			query = `
-				SELECT DISTINCT f.* FROM files f
-				LEFT JOIN permissions p ON f.id = p.resource_id AND p.resource_type = 'file'
+				SELECT f.* FROM files f
 				WHERE f.tenant_id = $1
 				AND f.is_trashed = false
 				AND f.folder_id = $3
-				AND (f.owner_id = $2 OR p.user_id = $2)
+				AND (f.owner_id = $2 OR effective_role('file', f.id, $2) IS NOT NULL)
 				ORDER BY f.updated_at DESC
 			`
 			args = []interface{}{tenantID, userID, folderID}
@@ -136,33 +163,106 @@ func (r *fileRepository) GetByTenant(ctx context.Context, tenantID, userID uuid.
 	return files, nil
 }
 
+// GetByName returns the oldest file with the given name in a folder, or at
+// the root if folderID is nil, that the user owns or can view, or nil if
+// there is none
+func (r *fileRepository) GetByName(ctx context.Context, tenantID, userID uuid.UUID, folderID *uuid.UUID, name string) (*model.File, error) {
+	var files []*model.File
+	query := `
+		SELECT f.* FROM files f
+		WHERE f.tenant_id = $1
+		AND f.is_trashed = false
+		AND f.folder_id IS NOT DISTINCT FROM $3
+		AND f.name = $4
+		AND (f.owner_id = $2 OR effective_role('file', f.id, $2) IS NOT NULL)
+		ORDER BY f.created_at, f.id
+		LIMIT 1
+	`
+
+	err := r.db.SelectContext(ctx, &files, query, tenantID, userID, folderID, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	return files[0], nil
+}
+
+// Update saves a file's metadata and increments its revision. It fails with
+// ErrConflict if the file's revision is no longer the one it was read at.
 func (r *fileRepository) Update(ctx context.Context, file *model.File) error {
 	query := `
 		UPDATE files SET
 			name = $2, folder_id = $3, description = $4, tags = $5,
-			is_starred = $6, updated_at = $7
-		WHERE id = $1
+			is_starred = $6, updated_at = $7, revision = revision + 1
+		WHERE id = $1 AND revision = $8
+		RETURNING revision
 	`
 
-	_, err := r.db.ExecContext(ctx, query,
+	err := r.db.QueryRowxContext(ctx, query,
 		file.ID, file.Name, file.FolderID, file.Description, file.Tags,
-		file.IsStarred, time.Now(),
-	)
+		file.IsStarred, time.Now(), file.Revision,
+	).Scan(&file.Revision)
 
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrConflict
+		}
 		return fmt.Errorf("failed to update file: %w", err)
 	}
 
 	return nil
 }
 
+// UpdateContent points a file at new content, such as a restored version,
+// and increments its revision. Like Update, it fails with ErrConflict if
+// the file has changed since it was read.
+func (r *fileRepository) UpdateContent(ctx context.Context, file *model.File) error {
+	query := `
+		UPDATE files SET
+			size = $2, storage_path = $3, checksum = $4, version = $5,
+			thumbnail_path = $6, updated_at = $7, revision = revision + 1
+		WHERE id = $1 AND revision = $8
+		RETURNING revision
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		file.ID, file.Size, file.StoragePath, file.Checksum, file.Version,
+		file.ThumbnailPath, time.Now(), file.Revision,
+	).Scan(&file.Revision)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to update file content: %w", err)
+	}
+
+	return nil
+}
+
+// SetThumbnailPath sets the thumbnail of every file of the tenant with the
+// given content
+func (r *fileRepository) SetThumbnailPath(ctx context.Context, tenantID uuid.UUID, checksum string, thumbnailPath *string) error {
+	query := `UPDATE files SET thumbnail_path = $3 WHERE tenant_id = $1 AND checksum = $2`
+
+	_, err := r.db.ExecContext(ctx, query, tenantID, checksum, thumbnailPath)
+	if err != nil {
+		return fmt.Errorf("failed to set thumbnail: %w", err)
+	}
+
+	return nil
+}
+
 func (r *fileRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	return r.MoveToTrash(ctx, id)
 }
 
 func (r *fileRepository) MoveToTrash(ctx context.Context, id uuid.UUID) error {
 	query := `
-		UPDATE files SET is_trashed = true, trashed_at = $2, updated_at = $2
+		UPDATE files SET is_trashed = true, trashed_at = $2, updated_at = $2, revision = revision + 1
 		WHERE id = $1
 	`
 
@@ -177,7 +277,7 @@ func (r *fileRepository) MoveToTrash(ctx context.Context, id uuid.UUID) error {
 
 func (r *fileRepository) RestoreFromTrash(ctx context.Context, id uuid.UUID) error {
 	query := `
-		UPDATE files SET is_trashed = false, trashed_at = NULL, updated_at = $2
+		UPDATE files SET is_trashed = false, trashed_at = NULL, updated_at = $2, revision = revision + 1
 		WHERE id = $1
 	`
 
@@ -200,31 +300,6 @@ func (r *fileRepository) PermanentDelete(ctx context.Context, id uuid.UUID) erro
 	return nil
 }
 
-func (r *fileRepository) Search(ctx context.Context, tenantID uuid.UUID, query string, fileType *model.FileType) ([]*model.File, error) {
-	var files []*model.File
-
-	searchQuery := `
-		SELECT * FROM files
-		WHERE tenant_id = $1 AND is_trashed = false
-		AND (name ILIKE $2 OR description ILIKE $2 OR $2 = ANY(tags))
-	`
-	args := []interface{}{tenantID, "%" + query + "%"}
-
-	if fileType != nil {
-		searchQuery += ` AND file_type = $3`
-		args = append(args, *fileType)
-	}
-
-	searchQuery += ` ORDER BY updated_at DESC LIMIT 50`
-
-	err := r.db.SelectContext(ctx, &files, searchQuery, args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search files: %w", err)
-	}
-
-	return files, nil
-}
-
 func (r *fileRepository) GetStarred(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.File, error) {
 	var files []*model.File
 	query := `
@@ -284,3 +359,30 @@ func (r *fileRepository) UpdateAccessTime(ctx context.Context, id uuid.UUID) err
 
 	return nil
 }
+
+// GetByFolders returns the files directly inside any of the given folders,
+// whoever owns them
+func (r *fileRepository) GetByFolders(ctx context.Context, folderIDs []uuid.UUID) ([]*model.File, error) {
+	files := []*model.File{}
+	if len(folderIDs) == 0 {
+		return files, nil
+	}
+
+	ids := make([]string, len(folderIDs))
+	for i, id := range folderIDs {
+		ids[i] = id.String()
+	}
+
+	query := `
+		SELECT * FROM files
+		WHERE folder_id = ANY($1::uuid[]) AND is_trashed = false
+		ORDER BY name ASC
+	`
+
+	err := r.db.SelectContext(ctx, &files, query, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files: %w", err)
+	}
+
+	return files, nil
+}
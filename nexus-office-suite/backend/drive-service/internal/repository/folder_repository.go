@@ -8,19 +8,24 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/nexus/drive-service/internal/model"
 )
 
 type FolderRepository interface {
 	Create(ctx context.Context, folder *model.Folder) error
 	GetByID(ctx context.Context, id uuid.UUID) (*model.Folder, error)
+	GetByIDs(ctx context.Context, ids []uuid.UUID) ([]*model.Folder, error)
 	GetByTenant(ctx context.Context, tenantID, userID uuid.UUID, parentID *uuid.UUID, includeShared bool) ([]*model.Folder, error)
+	GetByName(ctx context.Context, tenantID, userID uuid.UUID, parentID *uuid.UUID, name string) (*model.Folder, error)
 	Update(ctx context.Context, folder *model.Folder) error
 	Delete(ctx context.Context, id uuid.UUID) error
 	MoveToTrash(ctx context.Context, id uuid.UUID) error
 	RestoreFromTrash(ctx context.Context, id uuid.UUID) error
 	PermanentDelete(ctx context.Context, id uuid.UUID) error
 	GetPath(ctx context.Context, folderID uuid.UUID) (string, error)
+	GetChildren(ctx context.Context, parentID uuid.UUID) ([]*model.Folder, error)
+	GetDescendants(ctx context.Context, folderID uuid.UUID) ([]*model.Folder, error)
 	GetStarred(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.Folder, error)
 	GetTrashed(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.Folder, error)
 }
@@ -72,6 +77,23 @@ func (r *folderRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.Fo
 	return &folder, nil
 }
 
+// GetByIDs returns the folders with the given IDs, including trashed folders
+func (r *folderRepository) GetByIDs(ctx context.Context, ids []uuid.UUID) ([]*model.Folder, error) {
+	var folders []*model.Folder
+	if len(ids) == 0 {
+		return folders, nil
+	}
+
+	query := `SELECT * FROM folders WHERE id = ANY($1)`
+
+	err := r.db.SelectContext(ctx, &folders, query, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folders: %w", err)
+	}
+
+	return folders, nil
+}
+
 func (r *folderRepository) GetByTenant(ctx context.Context, tenantID, userID uuid.UUID, parentID *uuid.UUID, includeShared bool) ([]*model.Folder, error) {
 	var folders []*model.Folder
 
@@ -79,26 +101,25 @@ func (r *folderRepository) GetByTenant(ctx context.Context, tenantID, userID uui
 	var args []interface{}
 
 	if includeShared {
-		// Include folders owned by user or shared with user
+		// Include folders owned by user or shared with user, directly or
+		// through a parent folder
 		if parentID == nil {
 			query = `
-				SELECT DISTINCT f.* FROM folders f
-				LEFT JOIN permissions p ON f.id = p.resource_id AND p.resource_type = 'folder'
+				SELECT f.* FROM folders f
 				WHERE f.tenant_id = $1
 				AND f.is_trashed = false
 				AND f.parent_id IS NULL
-				AND (f.owner_id = $2 OR p.user_id = $2)
+				AND (f.owner_id = $2 OR effective_role('folder', f.id, $2) IS NOT NULL)
 				ORDER BY f.name ASC
 			`
 			args = []interface{}{tenantID, userID}
 		} else {
 			query = `
-				SELECT DISTINCT f.* FROM folders f
-				LEFT JOIN permissions p ON f.id = p.resource_id AND p.resource_type = 'folder'
+				SELECT f.* FROM folders f
 				WHERE f.tenant_id = $1
 				AND f.is_trashed = false
 				AND f.parent_id = $3
-				AND (f.owner_id = $2 OR p.user_id = $2)
+				AND (f.owner_id = $2 OR effective_role('folder', f.id, $2) IS NOT NULL)
 				ORDER BY f.name ASC
 			`
 			args = []interface{}{tenantID, userID, parentID}
@@ -130,6 +151,33 @@ func (r *folderRepository) GetByTenant(ctx context.Context, tenantID, userID uui
 	return folders, nil
 }
 
+// GetByName returns the oldest folder with the given name in a folder, or
+// at the root if parentID is nil, that the user owns or can view, or nil if
+// there is none
+func (r *folderRepository) GetByName(ctx context.Context, tenantID, userID uuid.UUID, parentID *uuid.UUID, name string) (*model.Folder, error) {
+	var folders []*model.Folder
+	query := `
+		SELECT f.* FROM folders f
+		WHERE f.tenant_id = $1
+		AND f.is_trashed = false
+		AND f.parent_id IS NOT DISTINCT FROM $3
+		AND f.name = $4
+		AND (f.owner_id = $2 OR effective_role('folder', f.id, $2) IS NOT NULL)
+		ORDER BY f.created_at, f.id
+		LIMIT 1
+	`
+
+	err := r.db.SelectContext(ctx, &folders, query, tenantID, userID, parentID, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder: %w", err)
+	}
+	if len(folders) == 0 {
+		return nil, nil
+	}
+
+	return folders[0], nil
+}
+
 func (r *folderRepository) Update(ctx context.Context, folder *model.Folder) error {
 	query := `
 		UPDATE folders SET
@@ -182,7 +230,7 @@ func (r *folderRepository) moveChildrenToTrash(ctx context.Context, folderID uui
 
 	// Move child files
 	fileQuery := `
-		UPDATE files SET is_trashed = true, trashed_at = $2, updated_at = $2
+		UPDATE files SET is_trashed = true, trashed_at = $2, updated_at = $2, revision = revision + 1
 		WHERE folder_id = $1
 	`
 	_, _ = r.db.ExecContext(ctx, fileQuery, folderID, trashedAt)
@@ -272,3 +320,46 @@ func (r *folderRepository) GetTrashed(ctx context.Context, tenantID, userID uuid
 
 	return folders, nil
 }
+
+// GetChildren returns the folders directly inside a folder
+func (r *folderRepository) GetChildren(ctx context.Context, parentID uuid.UUID) ([]*model.Folder, error) {
+	var folders []*model.Folder
+	query := `
+		SELECT * FROM folders
+		WHERE parent_id = $1 AND is_trashed = false
+		ORDER BY name ASC
+	`
+
+	err := r.db.SelectContext(ctx, &folders, query, parentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folders: %w", err)
+	}
+
+	return folders, nil
+}
+
+// GetDescendants returns a folder and every folder inside it, parents
+// before their children. Trashed folders and their contents are left out.
+func (r *folderRepository) GetDescendants(ctx context.Context, folderID uuid.UUID) ([]*model.Folder, error) {
+	var folders []*model.Folder
+	query := `
+		WITH RECURSIVE tree AS (
+			SELECT id, 0 AS level FROM folders
+			WHERE id = $1 AND is_trashed = false
+			UNION ALL
+			SELECT f.id, t.level + 1 FROM folders f
+			JOIN tree t ON f.parent_id = t.id
+			WHERE f.is_trashed = false
+		)
+		SELECT f.* FROM folders f
+		JOIN tree t ON f.id = t.id
+		ORDER BY t.level, f.name
+	`
+
+	err := r.db.SelectContext(ctx, &folders, query, folderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folders: %w", err)
+	}
+
+	return folders, nil
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+type GroupRepository interface {
+	Create(ctx context.Context, group *model.Group) error
+	GetByID(ctx context.Context, id uuid.UUID) (*model.Group, error)
+	GetByTenant(ctx context.Context, tenantID uuid.UUID) ([]*model.Group, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	AddMember(ctx context.Context, member *model.GroupMember) error
+	RemoveMember(ctx context.Context, groupID, userID uuid.UUID) error
+	GetMembers(ctx context.Context, groupIDs []uuid.UUID) ([]*model.GroupMember, error)
+}
+
+type groupRepository struct {
+	db *sqlx.DB
+}
+
+func NewGroupRepository(db *sqlx.DB) GroupRepository {
+	return &groupRepository{db: db}
+}
+
+func (r *groupRepository) Create(ctx context.Context, group *model.Group) error {
+	query := `
+		INSERT INTO user_groups (
+			id, tenant_id, name, description, created_by, created_at, updated_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7
+		)
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		group.ID, group.TenantID, group.Name, group.Description,
+		group.CreatedBy, group.CreatedAt, group.UpdatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create group: %w", err)
+	}
+
+	return nil
+}
+
+func (r *groupRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.Group, error) {
+	var group model.Group
+	query := `SELECT * FROM user_groups WHERE id = $1`
+
+	err := r.db.GetContext(ctx, &group, query, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("group not found")
+		}
+		return nil, fmt.Errorf("failed to get group: %w", err)
+	}
+
+	return &group, nil
+}
+
+func (r *groupRepository) GetByTenant(ctx context.Context, tenantID uuid.UUID) ([]*model.Group, error) {
+	var groups []*model.Group
+	query := `SELECT * FROM user_groups WHERE tenant_id = $1 ORDER BY name ASC`
+
+	err := r.db.SelectContext(ctx, &groups, query, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+
+	return groups, nil
+}
+
+func (r *groupRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	query := `DELETE FROM user_groups WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+
+	return nil
+}
+
+// AddMember adds a user to a group; adding an existing member does nothing
+func (r *groupRepository) AddMember(ctx context.Context, member *model.GroupMember) error {
+	query := `
+		INSERT INTO user_group_members (group_id, user_id, added_by, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (group_id, user_id) DO NOTHING
+	`
+
+	_, err := r.db.ExecContext(ctx, query, member.GroupID, member.UserID, member.AddedBy, member.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add group member: %w", err)
+	}
+
+	return nil
+}
+
+func (r *groupRepository) RemoveMember(ctx context.Context, groupID, userID uuid.UUID) error {
+	query := `DELETE FROM user_group_members WHERE group_id = $1 AND user_id = $2`
+
+	_, err := r.db.ExecContext(ctx, query, groupID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove group member: %w", err)
+	}
+
+	return nil
+}
+
+// GetMembers returns the members of the given groups
+func (r *groupRepository) GetMembers(ctx context.Context, groupIDs []uuid.UUID) ([]*model.GroupMember, error) {
+	members := []*model.GroupMember{}
+	if len(groupIDs) == 0 {
+		return members, nil
+	}
+
+	ids := make([]string, len(groupIDs))
+	for i, id := range groupIDs {
+		ids[i] = id.String()
+	}
+
+	query := `
+		SELECT * FROM user_group_members
+		WHERE group_id = ANY($1::uuid[])
+		ORDER BY created_at ASC
+	`
+
+	err := r.db.SelectContext(ctx, &members, query, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group members: %w", err)
+	}
+
+	return members, nil
+}
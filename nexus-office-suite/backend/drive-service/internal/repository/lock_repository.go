@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+var (
+	ErrLockNotFound = errors.New("file lock not found")
+	// ErrLockConflict is returned when another user holds a lock that
+	// conflicts with the one requested
+	ErrLockConflict = errors.New("file is locked by another user")
+)
+
+type LockRepository interface {
+	Acquire(ctx context.Context, lock *model.FileLock) error
+	GetByID(ctx context.Context, id uuid.UUID) (*model.FileLock, error)
+	GetByFile(ctx context.Context, fileID uuid.UUID) ([]*model.FileLock, error)
+	GetHeldInFolders(ctx context.Context, folderIDs []uuid.UUID, userID uuid.UUID) ([]*model.FileLock, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+type lockRepository struct {
+	db *sqlx.DB
+}
+
+func NewLockRepository(db *sqlx.DB) LockRepository {
+	return &lockRepository{db: db}
+}
+
+// Acquire takes lock for its user, replacing the lock they hold on the file
+// if any, unless another user holds a conflicting lock: any lock if lock is
+// exclusive, or else an exclusive one. Lockers of a file are serialized on
+// the file's row.
+func (r *lockRepository) Acquire(ctx context.Context, lock *model.FileLock) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT id FROM files WHERE id = $1 FOR UPDATE`, lock.FileID); err != nil {
+		return fmt.Errorf("failed to lock file: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM file_locks WHERE file_id = $1 AND expires_at <= $2
+	`, lock.FileID, lock.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to delete expired file locks: %w", err)
+	}
+
+	var conflict bool
+	err = tx.GetContext(ctx, &conflict, `
+		SELECT EXISTS (
+			SELECT 1 FROM file_locks
+			WHERE file_id = $1 AND user_id <> $2
+			AND ($3 = 'exclusive' OR lock_type = 'exclusive')
+		)
+	`, lock.FileID, lock.UserID, lock.Type)
+	if err != nil {
+		return fmt.Errorf("failed to check file locks: %w", err)
+	}
+	if conflict {
+		return ErrLockConflict
+	}
+
+	err = tx.QueryRowxContext(ctx, `
+		INSERT INTO file_locks (
+			id, tenant_id, file_id, user_id, lock_type, checked_out,
+			base_version, expires_at, created_at, updated_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+		)
+		ON CONFLICT (file_id, user_id) DO UPDATE SET
+			lock_type = EXCLUDED.lock_type, checked_out = EXCLUDED.checked_out,
+			base_version = EXCLUDED.base_version, expires_at = EXCLUDED.expires_at,
+			updated_at = EXCLUDED.updated_at
+		RETURNING id, created_at
+	`,
+		lock.ID, lock.TenantID, lock.FileID, lock.UserID, lock.Type, lock.CheckedOut,
+		lock.BaseVersion, lock.ExpiresAt, lock.CreatedAt, lock.UpdatedAt,
+	).Scan(&lock.ID, &lock.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create file lock: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit file lock: %w", err)
+	}
+
+	return nil
+}
+
+// GetByID returns a lock that has not expired
+func (r *lockRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.FileLock, error) {
+	var lock model.FileLock
+	query := `SELECT * FROM file_locks WHERE id = $1 AND expires_at > $2`
+
+	err := r.db.GetContext(ctx, &lock, query, id, time.Now())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrLockNotFound
+		}
+		return nil, fmt.Errorf("failed to get file lock: %w", err)
+	}
+
+	return &lock, nil
+}
+
+// GetByFile returns the locks on a file that have not expired, oldest first
+func (r *lockRepository) GetByFile(ctx context.Context, fileID uuid.UUID) ([]*model.FileLock, error) {
+	var locks []*model.FileLock
+	query := `
+		SELECT * FROM file_locks
+		WHERE file_id = $1 AND expires_at > $2
+		ORDER BY created_at ASC
+	`
+
+	err := r.db.SelectContext(ctx, &locks, query, fileID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file locks: %w", err)
+	}
+
+	return locks, nil
+}
+
+// GetHeldInFolders returns the locks that users other than userID hold on
+// files in the folders, and have not expired
+func (r *lockRepository) GetHeldInFolders(ctx context.Context, folderIDs []uuid.UUID, userID uuid.UUID) ([]*model.FileLock, error) {
+	var locks []*model.FileLock
+	if len(folderIDs) == 0 {
+		return locks, nil
+	}
+
+	query := `
+		SELECT l.* FROM file_locks l
+		JOIN files f ON f.id = l.file_id
+		WHERE f.folder_id = ANY($1) AND f.is_trashed = false
+		AND l.user_id <> $2 AND l.expires_at > $3
+		ORDER BY l.created_at ASC
+	`
+
+	err := r.db.SelectContext(ctx, &locks, query, pq.Array(folderIDs), userID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file locks: %w", err)
+	}
+
+	return locks, nil
+}
+
+func (r *lockRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	query := `DELETE FROM file_locks WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete file lock: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rows == 0 {
+		return ErrLockNotFound
+	}
+
+	return nil
+}
@@ -16,10 +16,14 @@ type PermissionRepository interface {
 	GetByID(ctx context.Context, id uuid.UUID) (*model.Permission, error)
 	GetByResource(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType) ([]*model.Permission, error)
 	GetByUser(ctx context.Context, userID uuid.UUID) ([]*model.Permission, error)
+	GetByGroup(ctx context.Context, groupID uuid.UUID) ([]*model.Permission, error)
 	Update(ctx context.Context, permission *model.Permission) error
 	Delete(ctx context.Context, id uuid.UUID) error
 	CheckPermission(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID) (*model.PermissionRole, error)
 	HasPermission(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID, role model.PermissionRole) (bool, error)
+	GetAncestors(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType) ([]*model.Ancestor, error)
+	GetInherited(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType) ([]*model.InheritedPermission, error)
+	DeleteExpired(ctx context.Context, before time.Time, limit int) ([]*model.Permission, error)
 }
 
 type permissionRepository struct {
@@ -33,16 +37,16 @@ func NewPermissionRepository(db *sqlx.DB) PermissionRepository {
 func (r *permissionRepository) Create(ctx context.Context, permission *model.Permission) error {
 	query := `
 		INSERT INTO permissions (
-			id, tenant_id, resource_id, resource_type, user_id, email,
+			id, tenant_id, resource_id, resource_type, user_id, email, group_id,
 			role, granted_by, expires_at, created_at, updated_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
 		)
 	`
 
 	_, err := r.db.ExecContext(ctx, query,
 		permission.ID, permission.TenantID, permission.ResourceID, permission.ResourceType,
-		permission.UserID, permission.Email, permission.Role, permission.GrantedBy,
+		permission.UserID, permission.Email, permission.GroupID, permission.Role, permission.GrantedBy,
 		permission.ExpiresAt, permission.CreatedAt, permission.UpdatedAt,
 	)
 
@@ -102,6 +106,24 @@ func (r *permissionRepository) GetByUser(ctx context.Context, userID uuid.UUID)
 	return permissions, nil
 }
 
+// GetByGroup returns the unexpired permissions granted to a group
+func (r *permissionRepository) GetByGroup(ctx context.Context, groupID uuid.UUID) ([]*model.Permission, error) {
+	var permissions []*model.Permission
+	query := `
+		SELECT * FROM permissions
+		WHERE group_id = $1
+		AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at DESC
+	`
+
+	err := r.db.SelectContext(ctx, &permissions, query, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get permissions: %w", err)
+	}
+
+	return permissions, nil
+}
+
 func (r *permissionRepository) Update(ctx context.Context, permission *model.Permission) error {
 	query := `
 		UPDATE permissions SET
@@ -131,30 +153,24 @@ func (r *permissionRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	return nil
 }
 
+// CheckPermission returns the user's role on the resource, including roles
+// inherited from the folders containing it and granted to the user's
+// groups, or nil if the user has no access
 func (r *permissionRepository) CheckPermission(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID) (*model.PermissionRole, error) {
-	var role model.PermissionRole
-	query := `
-		SELECT role FROM permissions
-		WHERE resource_id = $1 AND resource_type = $2 AND user_id = $3
-		AND (expires_at IS NULL OR expires_at > NOW())
-		ORDER BY
-			CASE role
-				WHEN 'owner' THEN 1
-				WHEN 'editor' THEN 2
-				WHEN 'viewer' THEN 3
-			END
-		LIMIT 1
-	`
+	var role sql.NullString
+	query := `SELECT effective_role($1, $2, $3)`
 
-	err := r.db.GetContext(ctx, &role, query, resourceID, resourceType, userID)
+	err := r.db.GetContext(ctx, &role, query, resourceType, resourceID, userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
 		return nil, fmt.Errorf("failed to check permission: %w", err)
 	}
 
-	return &role, nil
+	if !role.Valid {
+		return nil, nil
+	}
+
+	permissionRole := model.PermissionRole(role.String)
+	return &permissionRole, nil
 }
 
 func (r *permissionRepository) HasPermission(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID, role model.PermissionRole) (bool, error) {
@@ -177,6 +193,62 @@ func (r *permissionRepository) HasPermission(ctx context.Context, resourceID uui
 	return roleHierarchy[*currentRole] >= roleHierarchy[role], nil
 }
 
+// GetAncestors returns the resource and the folders containing it, nearest
+// first
+func (r *permissionRepository) GetAncestors(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType) ([]*model.Ancestor, error) {
+	var ancestors []*model.Ancestor
+	query := `SELECT * FROM resource_ancestry($1, $2) ORDER BY depth`
+
+	err := r.db.SelectContext(ctx, &ancestors, query, resourceType, resourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ancestors: %w", err)
+	}
+
+	return ancestors, nil
+}
+
+// GetInherited returns the unexpired permissions on the resource and the
+// folders containing it, nearest first
+func (r *permissionRepository) GetInherited(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType) ([]*model.InheritedPermission, error) {
+	var permissions []*model.InheritedPermission
+	query := `
+		SELECT p.*, a.depth FROM resource_ancestry($1, $2) a
+		JOIN permissions p ON p.resource_type = a.resource_type AND p.resource_id = a.resource_id
+		WHERE p.expires_at IS NULL OR p.expires_at > NOW()
+		ORDER BY a.depth, p.created_at
+	`
+
+	err := r.db.SelectContext(ctx, &permissions, query, resourceType, resourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get permissions: %w", err)
+	}
+
+	return permissions, nil
+}
+
+// DeleteExpired deletes up to limit permissions that expired before the given
+// time and returns them
+func (r *permissionRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) ([]*model.Permission, error) {
+	var deleted []*model.Permission
+	query := `
+		DELETE FROM permissions
+		WHERE id IN (
+			SELECT id FROM permissions
+			WHERE expires_at < $1
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`
+
+	err := r.db.SelectContext(ctx, &deleted, query, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired permissions: %w", err)
+	}
+
+	return deleted, nil
+}
+
 // ShareLinkRepository handles share links
 type ShareLinkRepository interface {
 	Create(ctx context.Context, link *model.ShareLink) error
@@ -184,7 +256,10 @@ type ShareLinkRepository interface {
 	GetByToken(ctx context.Context, token string) (*model.ShareLink, error)
 	GetByResource(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType) ([]*model.ShareLink, error)
 	Delete(ctx context.Context, id uuid.UUID) error
-	IncrementDownloadCount(ctx context.Context, id uuid.UUID) error
+	IncrementDownloadCount(ctx context.Context, id uuid.UUID) (bool, error)
+	LogAccess(ctx context.Context, access *model.ShareLinkAccess) error
+	GetAccessLog(ctx context.Context, linkID uuid.UUID, limit int) ([]*model.ShareLinkAccess, error)
+	CountFailedUnlocks(ctx context.Context, linkID uuid.UUID, ipAddress string, since time.Time) (int, error)
 }
 
 type shareLinkRepository struct {
@@ -281,13 +356,82 @@ func (r *shareLinkRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	return nil
 }
 
-func (r *shareLinkRepository) IncrementDownloadCount(ctx context.Context, id uuid.UUID) error {
-	query := `UPDATE share_links SET download_count = download_count + 1 WHERE id = $1`
+// IncrementDownloadCount counts a download if the link is still valid and
+// under its download limit, and reports whether it was counted. Concurrent
+// downloads cannot exceed the limit.
+func (r *shareLinkRepository) IncrementDownloadCount(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := `
+		UPDATE share_links SET download_count = download_count + 1
+		WHERE id = $1
+		AND (expires_at IS NULL OR expires_at > NOW())
+		AND (max_downloads IS NULL OR download_count < max_downloads)
+	`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to increment download count: %w", err)
+	}
+
+	counted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to increment download count: %w", err)
+	}
+
+	return counted > 0, nil
+}
+
+func (r *shareLinkRepository) LogAccess(ctx context.Context, access *model.ShareLinkAccess) error {
+	query := `
+		INSERT INTO share_link_access (
+			id, link_id, action, file_id, folder_id, ip_address, user_agent, created_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8
+		)
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		access.ID, access.LinkID, access.Action, access.FileID, access.FolderID,
+		access.IPAddress, access.UserAgent, access.CreatedAt,
+	)
 
-	_, err := r.db.ExecContext(ctx, query, id)
 	if err != nil {
-		return fmt.Errorf("failed to increment download count: %w", err)
+		return fmt.Errorf("failed to log share link access: %w", err)
 	}
 
 	return nil
 }
+
+func (r *shareLinkRepository) GetAccessLog(ctx context.Context, linkID uuid.UUID, limit int) ([]*model.ShareLinkAccess, error) {
+	var entries []*model.ShareLinkAccess
+	query := `
+		SELECT * FROM share_link_access
+		WHERE link_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	err := r.db.SelectContext(ctx, &entries, query, linkID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get share link access log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// CountFailedUnlocks returns the number of wrong passwords given for the link
+// from the address since the given time
+func (r *shareLinkRepository) CountFailedUnlocks(ctx context.Context, linkID uuid.UUID, ipAddress string, since time.Time) (int, error) {
+	var count int
+	query := `
+		SELECT COUNT(*) FROM share_link_access
+		WHERE link_id = $1 AND ip_address = $2 AND action = 'unlock_failed'
+		AND created_at > $3
+	`
+
+	err := r.db.GetContext(ctx, &count, query, linkID, ipAddress, since)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count failed unlocks: %w", err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+type PreviewRepository interface {
+	Request(ctx context.Context, preview *model.Preview) (*model.Preview, error)
+	Get(ctx context.Context, tenantID uuid.UUID, checksum string) (*model.Preview, error)
+	Claim(ctx context.Context, limit int, leaseUntil time.Time) ([]*model.Preview, error)
+	Complete(ctx context.Context, preview *model.Preview) error
+	Retry(ctx context.Context, preview *model.Preview, runAt time.Time) error
+	Delete(ctx context.Context, tenantID uuid.UUID, checksum string) (*model.Preview, error)
+}
+
+type previewRepository struct {
+	db *sqlx.DB
+}
+
+func NewPreviewRepository(db *sqlx.DB) PreviewRepository {
+	return &previewRepository{db: db}
+}
+
+// Request queues generation of a preview and returns it. If the content
+// already has a preview, queued or generated, that one is returned.
+func (r *previewRepository) Request(ctx context.Context, preview *model.Preview) (*model.Preview, error) {
+	var stored model.Preview
+	query := `
+		WITH inserted AS (
+			INSERT INTO blob_previews (
+				tenant_id, checksum, mime_type, file_name, size, status,
+				run_at, created_at, updated_at
+			) VALUES (
+				$1, $2, $3, $4, $5, $6, $7, $7, $7
+			)
+			ON CONFLICT (tenant_id, checksum) DO NOTHING
+			RETURNING *
+		)
+		SELECT * FROM inserted
+		UNION ALL
+		SELECT * FROM blob_previews WHERE tenant_id = $1 AND checksum = $2
+		LIMIT 1
+	`
+
+	err := r.db.GetContext(ctx, &stored, query,
+		preview.TenantID, preview.Checksum, preview.MimeType, preview.FileName,
+		preview.Size, model.PreviewStatusPending, time.Now(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request preview: %w", err)
+	}
+
+	return &stored, nil
+}
+
+func (r *previewRepository) Get(ctx context.Context, tenantID uuid.UUID, checksum string) (*model.Preview, error) {
+	var preview model.Preview
+	query := `SELECT * FROM blob_previews WHERE tenant_id = $1 AND checksum = $2`
+
+	err := r.db.GetContext(ctx, &preview, query, tenantID, checksum)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("preview not found")
+		}
+		return nil, fmt.Errorf("failed to get preview: %w", err)
+	}
+
+	return &preview, nil
+}
+
+// Claim marks up to limit due previews as being processed until leaseUntil
+// and returns them, counting the attempt. Previews claimed by workers that
+// have not finished by the end of their lease are claimed again.
+func (r *previewRepository) Claim(ctx context.Context, limit int, leaseUntil time.Time) ([]*model.Preview, error) {
+	var previews []*model.Preview
+	query := `
+		UPDATE blob_previews SET
+			status = $1, attempts = attempts + 1, run_at = $2, updated_at = $3
+		WHERE (tenant_id, checksum) IN (
+			SELECT tenant_id, checksum FROM blob_previews
+			WHERE status IN ($4, $1) AND run_at <= $3
+			ORDER BY run_at
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`
+
+	err := r.db.SelectContext(ctx, &previews, query,
+		model.PreviewStatusProcessing, leaseUntil, time.Now(), model.PreviewStatusPending, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim previews: %w", err)
+	}
+
+	return previews, nil
+}
+
+// Complete records the outcome of generating a preview
+func (r *previewRepository) Complete(ctx context.Context, preview *model.Preview) error {
+	query := `
+		UPDATE blob_previews SET
+			status = $3, thumbnail_sizes = $4, has_text = $5, width = $6, height = $7,
+			last_error = $8, updated_at = $9
+		WHERE tenant_id = $1 AND checksum = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		preview.TenantID, preview.Checksum, preview.Status, preview.ThumbnailSizes,
+		preview.HasText, preview.Width, preview.Height, preview.LastError, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update preview: %w", err)
+	}
+
+	return nil
+}
+
+// Retry returns a preview that failed to generate to the queue, to be tried
+// again at runAt
+func (r *previewRepository) Retry(ctx context.Context, preview *model.Preview, runAt time.Time) error {
+	query := `
+		UPDATE blob_previews SET status = $3, last_error = $4, run_at = $5, updated_at = $6
+		WHERE tenant_id = $1 AND checksum = $2
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		preview.TenantID, preview.Checksum, model.PreviewStatusPending, preview.LastError, runAt, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update preview: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes the record of a preview and returns it, so that its
+// thumbnails and text can be deleted from storage
+func (r *previewRepository) Delete(ctx context.Context, tenantID uuid.UUID, checksum string) (*model.Preview, error) {
+	var preview model.Preview
+	query := `DELETE FROM blob_previews WHERE tenant_id = $1 AND checksum = $2 RETURNING *`
+
+	err := r.db.GetContext(ctx, &preview, query, tenantID, checksum)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("preview not found")
+		}
+		return nil, fmt.Errorf("failed to delete preview: %w", err)
+	}
+
+	return &preview, nil
+}
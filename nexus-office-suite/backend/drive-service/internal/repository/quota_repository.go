@@ -0,0 +1,364 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+// QuotaRepository handles storage usage, quotas and quota warnings
+type QuotaRepository interface {
+	GetUserUsage(ctx context.Context, tenantID, userID uuid.UUID) (*model.StorageUsage, error)
+	GetTenantUsage(ctx context.Context, tenantID uuid.UUID) (int64, error)
+	GetUsageByFileType(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.FileTypeUsage, error)
+	GetUsageByFolder(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.FolderUsage, error)
+	HoldsContent(ctx context.Context, tenantID, userID uuid.UUID, storagePath string) (byUser, byTenant bool, err error)
+	GetQuota(ctx context.Context, tenantID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID) (*model.Quota, error)
+	SetQuota(ctx context.Context, tenantID uuid.UUID, quota *model.Quota) error
+	DeleteQuota(ctx context.Context, tenantID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID) error
+	SetThreshold(ctx context.Context, scope model.QuotaScope, scopeID uuid.UUID, threshold int) (bool, error)
+	CreateEvent(ctx context.Context, event *model.QuotaEvent) error
+	ClaimEvents(ctx context.Context, limit, maxAttempts int, leaseUntil time.Time) ([]*model.QuotaEvent, error)
+	MarkEventDelivered(ctx context.Context, id uuid.UUID, deliveredAt time.Time) error
+}
+
+type quotaRepository struct {
+	db *sqlx.DB
+}
+
+func NewQuotaRepository(db *sqlx.DB) QuotaRepository {
+	return &quotaRepository{db: db}
+}
+
+// userContent selects the content held by a user's files and their
+// versions, as storage_path and size, with rank 1 for files, 2 for trashed
+// files and 3 for versions. Content-addressed files and versions with the
+// same content share a storage path.
+const userContent = `
+	SELECT f.storage_path, f.size, CASE WHEN f.is_trashed THEN 2 ELSE 1 END AS rank
+	FROM files f
+	WHERE f.tenant_id = $1 AND f.owner_id = $2
+	UNION ALL
+	SELECT v.storage_path, v.size, 3
+	FROM file_versions v JOIN files f ON f.id = v.file_id
+	WHERE f.tenant_id = $1 AND f.owner_id = $2
+`
+
+// GetUserUsage returns the storage a user's files, trashed files and
+// versions use, each piece of content counted once where it ranks first
+func (r *quotaRepository) GetUserUsage(ctx context.Context, tenantID, userID uuid.UUID) (*model.StorageUsage, error) {
+	var usage model.StorageUsage
+	query := `
+		WITH held AS (
+			SELECT storage_path, MAX(size) AS size, MIN(rank) AS rank
+			FROM (` + userContent + `) content
+			GROUP BY storage_path
+		)
+		SELECT
+			COALESCE(SUM(size) FILTER (WHERE rank = 1), 0)::BIGINT AS files,
+			COALESCE(SUM(size) FILTER (WHERE rank = 2), 0)::BIGINT AS trash,
+			COALESCE(SUM(size) FILTER (WHERE rank = 3), 0)::BIGINT AS versions
+		FROM held
+	`
+
+	if err := r.db.GetContext(ctx, &usage, query, tenantID, userID); err != nil {
+		return nil, fmt.Errorf("failed to get user usage: %w", err)
+	}
+	usage.Used = usage.Files + usage.Trash + usage.Versions
+
+	return &usage, nil
+}
+
+// GetTenantUsage returns the storage a tenant uses: its referenced blobs,
+// and the content of files and versions stored before deduplication
+func (r *quotaRepository) GetTenantUsage(ctx context.Context, tenantID uuid.UUID) (int64, error) {
+	var used int64
+	query := `
+		SELECT (
+			COALESCE((
+				SELECT SUM(size) FROM blobs WHERE tenant_id = $1 AND ref_count > 0
+			), 0)
+			+ COALESCE((
+				SELECT SUM(size) FROM (
+					SELECT MAX(size) AS size FROM (
+						SELECT storage_path, size FROM files
+						WHERE tenant_id = $1 AND checksum IS NULL
+						UNION ALL
+						SELECT v.storage_path, v.size
+						FROM file_versions v JOIN files f ON f.id = v.file_id
+						WHERE f.tenant_id = $1 AND v.checksum IS NULL
+					) legacy
+					GROUP BY storage_path
+				) paths
+			), 0)
+		)::BIGINT
+	`
+
+	if err := r.db.GetContext(ctx, &used, query, tenantID); err != nil {
+		return 0, fmt.Errorf("failed to get tenant usage: %w", err)
+	}
+
+	return used, nil
+}
+
+// GetUsageByFileType returns the storage a user's files of each type and
+// their versions use, largest first
+func (r *quotaRepository) GetUsageByFileType(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.FileTypeUsage, error) {
+	var usage []*model.FileTypeUsage
+	query := `
+		WITH content AS (
+			SELECT f.file_type, f.storage_path, f.size, TRUE AS is_file
+			FROM files f
+			WHERE f.tenant_id = $1 AND f.owner_id = $2
+			UNION ALL
+			SELECT f.file_type, v.storage_path, v.size, FALSE
+			FROM file_versions v JOIN files f ON f.id = v.file_id
+			WHERE f.tenant_id = $1 AND f.owner_id = $2
+		),
+		held AS (
+			SELECT file_type, storage_path, MAX(size) AS size, COUNT(*) FILTER (WHERE is_file) AS files
+			FROM content
+			GROUP BY file_type, storage_path
+		)
+		SELECT file_type, SUM(files)::INTEGER AS files, SUM(size)::BIGINT AS bytes
+		FROM held
+		GROUP BY file_type
+		ORDER BY bytes DESC, file_type
+	`
+
+	if err := r.db.SelectContext(ctx, &usage, query, tenantID, userID); err != nil {
+		return nil, fmt.Errorf("failed to get usage by file type: %w", err)
+	}
+
+	return usage, nil
+}
+
+// GetUsageByFolder returns the storage a user's files and their versions
+// use in each top-level folder, counting files in the folders inside it,
+// largest first
+func (r *quotaRepository) GetUsageByFolder(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.FolderUsage, error) {
+	var usage []*model.FolderUsage
+	query := `
+		WITH RECURSIVE chain (start_id, id, parent_id, level) AS (
+			SELECT fo.id, fo.id, fo.parent_id, 0
+			FROM folders fo
+			WHERE fo.id IN (SELECT folder_id FROM files WHERE tenant_id = $1 AND owner_id = $2)
+			UNION ALL
+			SELECT c.start_id, fo.id, fo.parent_id, c.level + 1
+			FROM folders fo JOIN chain c ON fo.id = c.parent_id
+			WHERE c.level < 64
+		),
+		tops AS (
+			SELECT start_id, id AS top_id FROM chain WHERE parent_id IS NULL
+		),
+		content AS (
+			SELECT t.top_id, f.storage_path, f.size, TRUE AS is_file
+			FROM files f LEFT JOIN tops t ON t.start_id = f.folder_id
+			WHERE f.tenant_id = $1 AND f.owner_id = $2
+			UNION ALL
+			SELECT t.top_id, v.storage_path, v.size, FALSE
+			FROM file_versions v
+			JOIN files f ON f.id = v.file_id
+			LEFT JOIN tops t ON t.start_id = f.folder_id
+			WHERE f.tenant_id = $1 AND f.owner_id = $2
+		),
+		held AS (
+			SELECT top_id, storage_path, MAX(size) AS size, COUNT(*) FILTER (WHERE is_file) AS files
+			FROM content
+			GROUP BY top_id, storage_path
+		)
+		SELECT h.top_id AS folder_id, COALESCE(fo.name, '') AS name,
+			SUM(h.files)::INTEGER AS files, SUM(h.size)::BIGINT AS bytes
+		FROM held h LEFT JOIN folders fo ON fo.id = h.top_id
+		GROUP BY h.top_id, fo.name
+		ORDER BY bytes DESC, name
+	`
+
+	if err := r.db.SelectContext(ctx, &usage, query, tenantID, userID); err != nil {
+		return nil, fmt.Errorf("failed to get usage by folder: %w", err)
+	}
+
+	return usage, nil
+}
+
+// HoldsContent reports whether the content at storagePath is already
+// counted in a user's usage, and in their tenant's
+func (r *quotaRepository) HoldsContent(ctx context.Context, tenantID, userID uuid.UUID, storagePath string) (bool, bool, error) {
+	var held struct {
+		ByUser   bool `db:"by_user"`
+		ByTenant bool `db:"by_tenant"`
+	}
+	query := `
+		SELECT
+			EXISTS (
+				SELECT 1 FROM files
+				WHERE owner_id = $2 AND storage_path = $3 AND tenant_id = $1
+			) OR EXISTS (
+				SELECT 1 FROM file_versions v JOIN files f ON f.id = v.file_id
+				WHERE v.storage_path = $3 AND f.owner_id = $2 AND f.tenant_id = $1
+			) AS by_user,
+			EXISTS (
+				SELECT 1 FROM blobs WHERE storage_path = $3 AND ref_count > 0
+			) OR EXISTS (
+				SELECT 1 FROM files WHERE storage_path = $3 AND tenant_id = $1
+			) AS by_tenant
+	`
+
+	if err := r.db.GetContext(ctx, &held, query, tenantID, userID, storagePath); err != nil {
+		return false, false, fmt.Errorf("failed to check held content: %w", err)
+	}
+
+	return held.ByUser, held.ByTenant, nil
+}
+
+// GetQuota returns the quota a tenant set for one of its users or itself,
+// or nil if it has the default quota
+func (r *quotaRepository) GetQuota(ctx context.Context, tenantID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID) (*model.Quota, error) {
+	var quota model.Quota
+	query := `
+		SELECT scope, scope_id, quota_bytes, updated_by, updated_at
+		FROM storage_quotas
+		WHERE tenant_id = $1 AND scope = $2 AND scope_id = $3
+	`
+
+	err := r.db.GetContext(ctx, &quota, query, tenantID, scope, scopeID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get quota: %w", err)
+	}
+
+	return &quota, nil
+}
+
+// SetQuota sets the quota of one of a tenant's users or the tenant itself
+func (r *quotaRepository) SetQuota(ctx context.Context, tenantID uuid.UUID, quota *model.Quota) error {
+	query := `
+		INSERT INTO storage_quotas (tenant_id, scope, scope_id, quota_bytes, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (tenant_id, scope, scope_id) DO UPDATE SET
+			quota_bytes = EXCLUDED.quota_bytes,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		tenantID, quota.Scope, quota.ScopeID, quota.Quota, quota.UpdatedBy, quota.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set quota: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteQuota returns a user or tenant to the default quota
+func (r *quotaRepository) DeleteQuota(ctx context.Context, tenantID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID) error {
+	query := `DELETE FROM storage_quotas WHERE tenant_id = $1 AND scope = $2 AND scope_id = $3`
+
+	if _, err := r.db.ExecContext(ctx, query, tenantID, scope, scopeID); err != nil {
+		return fmt.Errorf("failed to delete quota: %w", err)
+	}
+
+	return nil
+}
+
+// SetThreshold records the highest warning threshold a user's or tenant's
+// usage has reached, and reports whether it is higher than before. Of
+// concurrent changes reaching the same threshold, only one raises it.
+func (r *quotaRepository) SetThreshold(ctx context.Context, scope model.QuotaScope, scopeID uuid.UUID, threshold int) (bool, error) {
+	now := time.Now()
+
+	lower := `
+		UPDATE quota_thresholds SET threshold = $3, updated_at = $4
+		WHERE scope = $1 AND scope_id = $2 AND threshold > $3
+	`
+	if _, err := r.db.ExecContext(ctx, lower, scope, scopeID, threshold, now); err != nil {
+		return false, fmt.Errorf("failed to lower quota threshold: %w", err)
+	}
+	if threshold <= 0 {
+		return false, nil
+	}
+
+	raise := `
+		INSERT INTO quota_thresholds AS t (scope, scope_id, threshold, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (scope, scope_id) DO UPDATE SET
+			threshold = EXCLUDED.threshold, updated_at = EXCLUDED.updated_at
+		WHERE t.threshold < EXCLUDED.threshold
+	`
+	result, err := r.db.ExecContext(ctx, raise, scope, scopeID, threshold, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to raise quota threshold: %w", err)
+	}
+
+	raised, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to raise quota threshold: %w", err)
+	}
+
+	return raised > 0, nil
+}
+
+func (r *quotaRepository) CreateEvent(ctx context.Context, event *model.QuotaEvent) error {
+	query := `
+		INSERT INTO quota_events (
+			id, tenant_id, scope, scope_id, user_id, threshold, used_bytes, quota_bytes,
+			run_at, created_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+		)
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		event.ID, event.TenantID, event.Scope, event.ScopeID, event.UserID, event.Threshold,
+		event.UsedBytes, event.QuotaBytes, event.RunAt, event.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create quota event: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimEvents leases up to limit undelivered events for delivery until
+// leaseUntil and returns them, counting the attempt. Events not delivered
+// by the end of their lease are claimed again, until maxAttempts.
+func (r *quotaRepository) ClaimEvents(ctx context.Context, limit, maxAttempts int, leaseUntil time.Time) ([]*model.QuotaEvent, error) {
+	var events []*model.QuotaEvent
+	query := `
+		UPDATE quota_events SET attempts = attempts + 1, run_at = $1
+		WHERE id IN (
+			SELECT id FROM quota_events
+			WHERE delivered_at IS NULL AND run_at <= $2 AND attempts < $3
+			ORDER BY run_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`
+
+	err := r.db.SelectContext(ctx, &events, query, leaseUntil, time.Now(), maxAttempts, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim quota events: %w", err)
+	}
+
+	return events, nil
+}
+
+func (r *quotaRepository) MarkEventDelivered(ctx context.Context, id uuid.UUID, deliveredAt time.Time) error {
+	query := `UPDATE quota_events SET delivered_at = $2 WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id, deliveredAt)
+	if err != nil {
+		return fmt.Errorf("failed to mark quota event delivered: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+// searchHeadlineOptions configures the snippets of matching content
+var searchHeadlineOptions = fmt.Sprintf(
+	`StartSel="%s", StopSel="%s", MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=" … "`,
+	model.SearchHighlightStart, model.SearchHighlightStop,
+)
+
+type SearchRepository interface {
+	IndexContent(ctx context.Context, tenantID uuid.UUID, checksum, content, language string) error
+	DeleteContent(ctx context.Context, tenantID uuid.UUID, checksum string) error
+	Search(ctx context.Context, tenantID, userID uuid.UUID, req *model.SearchRequest, language string) ([]*model.SearchResult, error)
+}
+
+type searchRepository struct {
+	db *sqlx.DB
+}
+
+func NewSearchRepository(db *sqlx.DB) SearchRepository {
+	return &searchRepository{db: db}
+}
+
+// IndexContent indexes the text extracted from a blob using the given text
+// search configuration, replacing any text indexed before
+func (r *searchRepository) IndexContent(ctx context.Context, tenantID uuid.UUID, checksum, content, language string) error {
+	query := `
+		INSERT INTO blob_contents (tenant_id, checksum, content, content_vector, created_at, updated_at)
+		VALUES ($1, $2, $3, to_tsvector($4::regconfig, $3), $5, $5)
+		ON CONFLICT (tenant_id, checksum) DO UPDATE SET
+			content = EXCLUDED.content,
+			content_vector = EXCLUDED.content_vector,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	_, err := r.db.ExecContext(ctx, query, tenantID, checksum, content, language, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to index content: %w", err)
+	}
+
+	return nil
+}
+
+func (r *searchRepository) DeleteContent(ctx context.Context, tenantID uuid.UUID, checksum string) error {
+	query := `DELETE FROM blob_contents WHERE tenant_id = $1 AND checksum = $2`
+
+	_, err := r.db.ExecContext(ctx, query, tenantID, checksum)
+	if err != nil {
+		return fmt.Errorf("failed to delete indexed content: %w", err)
+	}
+
+	return nil
+}
+
+// Search returns the files userID can view that match a search, best
+// matches first. The query is parsed like a web search: words are ANDed,
+// and quoted phrases, "or" and -excluded words are understood. Matches in
+// names and descriptions rank above matches in content.
+func (r *searchRepository) Search(ctx context.Context, tenantID, userID uuid.UUID, req *model.SearchRequest, language string) ([]*model.SearchResult, error) {
+	var results []*model.SearchResult
+
+	args := []interface{}{tenantID, userID, req.Query, language}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	var filters strings.Builder
+	if req.OwnerID != nil {
+		fmt.Fprintf(&filters, ` AND f.owner_id = %s`, arg(*req.OwnerID))
+	}
+	if req.FileType != nil {
+		fmt.Fprintf(&filters, ` AND f.file_type = %s`, arg(*req.FileType))
+	}
+	if req.FolderID != nil {
+		fmt.Fprintf(&filters, ` AND f.folder_id IN (
+			WITH RECURSIVE subfolders AS (
+				SELECT id FROM folders WHERE id = %s
+				UNION ALL
+				SELECT fo.id FROM folders fo JOIN subfolders s ON fo.parent_id = s.id
+			)
+			SELECT id FROM subfolders
+		)`, arg(*req.FolderID))
+	}
+	if len(req.Tags) > 0 {
+		fmt.Fprintf(&filters, ` AND f.tags @> %s::jsonb`, arg(model.Tags(req.Tags)))
+	}
+	if req.ModifiedAfter != nil {
+		fmt.Fprintf(&filters, ` AND f.updated_at >= %s`, arg(*req.ModifiedAfter))
+	}
+	if req.ModifiedBefore != nil {
+		fmt.Fprintf(&filters, ` AND f.updated_at < %s`, arg(*req.ModifiedBefore))
+	}
+	if req.MinSize != nil {
+		fmt.Fprintf(&filters, ` AND f.size >= %s`, arg(*req.MinSize))
+	}
+	if req.MaxSize != nil {
+		fmt.Fprintf(&filters, ` AND f.size <= %s`, arg(*req.MaxSize))
+	}
+
+	pattern := "%" + escapeLike(req.Query) + "%"
+	query := fmt.Sprintf(`
+		WITH q AS (
+			SELECT websearch_to_tsquery($4::regconfig, $3) AS content_query,
+			       websearch_to_tsquery('simple', $3) AS name_query
+		),
+		matches AS (
+			SELECT f.*,
+				2 * ts_rank(to_tsvector('simple', f.name || ' ' || COALESCE(f.description, '')), q.name_query)
+				+ CASE WHEN f.name ILIKE %[1]s THEN 1 ELSE 0 END
+				+ COALESCE(ts_rank(c.content_vector, q.content_query), 0) AS rank
+			FROM files f
+			CROSS JOIN q
+			LEFT JOIN blob_contents c ON c.tenant_id = f.tenant_id AND c.checksum = f.checksum
+			WHERE f.tenant_id = $1 AND f.is_trashed = false
+			AND (
+				to_tsvector('simple', f.name || ' ' || COALESCE(f.description, '')) @@ q.name_query
+				OR f.name ILIKE %[1]s OR f.description ILIKE %[1]s
+				OR f.tags ? $3
+				OR c.content_vector @@ q.content_query
+			)
+			AND (f.owner_id = $2 OR effective_role('file', f.id, $2) IS NOT NULL)
+			%[2]s
+			ORDER BY rank DESC, f.updated_at DESC
+			LIMIT %[3]s OFFSET %[4]s
+		)
+		SELECT m.*,
+			CASE WHEN c.content_vector @@ q.content_query
+				THEN ts_headline($4::regconfig, c.content, q.content_query, %[5]s)
+			END AS snippet
+		FROM matches m
+		CROSS JOIN q
+		LEFT JOIN blob_contents c ON c.tenant_id = m.tenant_id AND c.checksum = m.checksum
+		ORDER BY m.rank DESC, m.updated_at DESC
+	`, arg(pattern), filters.String(), arg(req.Limit), arg(req.Offset), arg(searchHeadlineOptions))
+
+	err := r.db.SelectContext(ctx, &results, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search files: %w", err)
+	}
+
+	return results, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+type UploadSessionRepository interface {
+	Create(ctx context.Context, session *model.UploadSession) error
+	GetByID(ctx context.Context, id uuid.UUID) (*model.UploadSession, error)
+	AddPart(ctx context.Context, session *model.UploadSession, previousOffset int64, part *model.UploadPart) (bool, error)
+	GetParts(ctx context.Context, sessionID uuid.UUID) ([]*model.UploadPart, error)
+	MarkCompleted(ctx context.Context, id uuid.UUID, expiresAt time.Time) error
+	Delete(ctx context.Context, id uuid.UUID) error
+	GetExpired(ctx context.Context, before time.Time, limit int) ([]*model.UploadSession, error)
+}
+
+type uploadSessionRepository struct {
+	db *sqlx.DB
+}
+
+func NewUploadSessionRepository(db *sqlx.DB) UploadSessionRepository {
+	return &uploadSessionRepository{db: db}
+}
+
+func (r *uploadSessionRepository) Create(ctx context.Context, session *model.UploadSession) error {
+	query := `
+		INSERT INTO upload_sessions (
+			id, tenant_id, owner_id, folder_id, file_id, filename, mime_type,
+			size, chunk_size, upload_offset, checksum, storage_path,
+			storage_upload_id, hash_state, status, expires_at, created_at, updated_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18
+		)
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		session.ID, session.TenantID, session.OwnerID, session.FolderID, session.FileID,
+		session.Filename, session.MimeType, session.Size, session.ChunkSize, session.Offset,
+		session.Checksum, session.StoragePath, session.StorageUploadID, session.HashState,
+		session.Status, session.ExpiresAt, session.CreatedAt, session.UpdatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create upload session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *uploadSessionRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.UploadSession, error) {
+	var session model.UploadSession
+	query := `SELECT * FROM upload_sessions WHERE id = $1`
+
+	err := r.db.GetContext(ctx, &session, query, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("upload session not found")
+		}
+		return nil, fmt.Errorf("failed to get upload session: %w", err)
+	}
+
+	return &session, nil
+}
+
+// AddPart records a received chunk and advances the session to the offset,
+// hash state and expiry set on session. It returns false, recording
+// nothing, if the session is no longer at previousOffset because another
+// request stored a chunk first.
+func (r *uploadSessionRepository) AddPart(ctx context.Context, session *model.UploadSession, previousOffset int64, part *model.UploadPart) (bool, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE upload_sessions SET upload_offset = $2, hash_state = $3, expires_at = $4
+		WHERE id = $1 AND upload_offset = $5 AND status = 'uploading'
+	`, session.ID, session.Offset, session.HashState, session.ExpiresAt, previousOffset)
+	if err != nil {
+		return false, fmt.Errorf("failed to update upload session: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO upload_parts (session_id, part_number, size, etag, checksum, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (session_id, part_number) DO UPDATE SET
+			size = EXCLUDED.size, etag = EXCLUDED.etag,
+			checksum = EXCLUDED.checksum, created_at = EXCLUDED.created_at
+	`, part.SessionID, part.PartNumber, part.Size, part.ETag, part.Checksum, part.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to record upload part: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit upload part: %w", err)
+	}
+
+	return true, nil
+}
+
+func (r *uploadSessionRepository) GetParts(ctx context.Context, sessionID uuid.UUID) ([]*model.UploadPart, error) {
+	var parts []*model.UploadPart
+	query := `
+		SELECT * FROM upload_parts
+		WHERE session_id = $1
+		ORDER BY part_number ASC
+	`
+
+	err := r.db.SelectContext(ctx, &parts, query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload parts: %w", err)
+	}
+
+	return parts, nil
+}
+
+// MarkCompleted marks a session as assembled. It is kept until expiresAt so
+// that a client which lost the response can complete it again.
+func (r *uploadSessionRepository) MarkCompleted(ctx context.Context, id uuid.UUID, expiresAt time.Time) error {
+	query := `UPDATE upload_sessions SET status = 'completed', expires_at = $2 WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to complete upload session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *uploadSessionRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	query := `DELETE FROM upload_sessions WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete upload session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *uploadSessionRepository) GetExpired(ctx context.Context, before time.Time, limit int) ([]*model.UploadSession, error) {
+	var sessions []*model.UploadSession
+	query := `
+		SELECT * FROM upload_sessions
+		WHERE expires_at < $1
+		ORDER BY expires_at ASC
+		LIMIT $2
+	`
+
+	err := r.db.SelectContext(ctx, &sessions, query, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expired upload sessions: %w", err)
+	}
+
+	return sessions, nil
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// UserRepository records the users of each tenant
+type UserRepository interface {
+	Record(ctx context.Context, tenantID, userID uuid.UUID, email string, seenAt time.Time) error
+	IsMember(ctx context.Context, tenantID, userID uuid.UUID) (bool, error)
+}
+
+type userRepository struct {
+	db *sqlx.DB
+}
+
+func NewUserRepository(db *sqlx.DB) UserRepository {
+	return &userRepository{db: db}
+}
+
+// Record notes that a user signed in to a tenant
+func (r *userRepository) Record(ctx context.Context, tenantID, userID uuid.UUID, email string, seenAt time.Time) error {
+	query := `
+		INSERT INTO tenant_users (tenant_id, user_id, email, first_seen_at, last_seen_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $4)
+		ON CONFLICT (tenant_id, user_id) DO UPDATE SET
+			email = COALESCE(EXCLUDED.email, tenant_users.email),
+			last_seen_at = EXCLUDED.last_seen_at
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, tenantID, userID, email, seenAt); err != nil {
+		return fmt.Errorf("failed to record user: %w", err)
+	}
+
+	return nil
+}
+
+// IsMember reports whether a user has signed in to a tenant
+func (r *userRepository) IsMember(ctx context.Context, tenantID, userID uuid.UUID) (bool, error) {
+	var member bool
+	query := `SELECT EXISTS (SELECT 1 FROM tenant_users WHERE tenant_id = $1 AND user_id = $2)`
+
+	if err := r.db.GetContext(ctx, &member, query, tenantID, userID); err != nil {
+		return false, fmt.Errorf("failed to check tenant user: %w", err)
+	}
+
+	return member, nil
+}
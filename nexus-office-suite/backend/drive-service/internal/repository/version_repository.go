@@ -3,13 +3,17 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/nexus/drive-service/internal/model"
 )
 
+var ErrVersionNotFound = errors.New("file version not found")
+
 type VersionRepository interface {
 	Create(ctx context.Context, version *model.FileVersion) error
 	GetByID(ctx context.Context, id uuid.UUID) (*model.FileVersion, error)
@@ -17,6 +21,12 @@ type VersionRepository interface {
 	GetByVersion(ctx context.Context, fileID uuid.UUID, versionNum int) (*model.FileVersion, error)
 	Delete(ctx context.Context, id uuid.UUID) error
 	GetLatestVersionNum(ctx context.Context, fileID uuid.UUID) (int, error)
+	Update(ctx context.Context, version *model.FileVersion) error
+	GetFilesWithOldVersions(ctx context.Context, after uuid.UUID, limit int) ([]*model.File, error)
+	DeletePrunable(ctx context.Context, ids []uuid.UUID) ([]*model.FileVersion, error)
+	IsStoragePathReferenced(ctx context.Context, storagePath string) (bool, error)
+	GetRetentionPolicy(ctx context.Context, tenantID uuid.UUID) (*model.VersionRetentionPolicy, error)
+	SetRetentionPolicy(ctx context.Context, policy *model.VersionRetentionPolicy) error
 }
 
 type versionRepository struct {
@@ -31,15 +41,15 @@ func (r *versionRepository) Create(ctx context.Context, version *model.FileVersi
 	query := `
 		INSERT INTO file_versions (
 			id, file_id, version_num, size, storage_path,
-			created_by, comment, created_at
+			checksum, created_by, comment, created_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8
+			$1, $2, $3, $4, $5, $6, $7, $8, $9
 		)
 	`
 
 	_, err := r.db.ExecContext(ctx, query,
 		version.ID, version.FileID, version.VersionNum, version.Size,
-		version.StoragePath, version.CreatedBy, version.Comment, version.CreatedAt,
+		version.StoragePath, version.Checksum, version.CreatedBy, version.Comment, version.CreatedAt,
 	)
 
 	if err != nil {
@@ -56,7 +66,7 @@ func (r *versionRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.F
 	err := r.db.GetContext(ctx, &version, query, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("file version not found")
+			return nil, ErrVersionNotFound
 		}
 		return nil, fmt.Errorf("failed to get file version: %w", err)
 	}
@@ -90,7 +100,7 @@ func (r *versionRepository) GetByVersion(ctx context.Context, fileID uuid.UUID,
 	err := r.db.GetContext(ctx, &version, query, fileID, versionNum)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("file version not found")
+			return nil, ErrVersionNotFound
 		}
 		return nil, fmt.Errorf("failed to get file version: %w", err)
 	}
@@ -124,3 +134,131 @@ func (r *versionRepository) GetLatestVersionNum(ctx context.Context, fileID uuid
 
 	return versionNum, nil
 }
+
+func (r *versionRepository) Update(ctx context.Context, version *model.FileVersion) error {
+	query := `
+		UPDATE file_versions SET name = $2, pinned = $3, comment = $4
+		WHERE id = $1
+	`
+
+	result, err := r.db.ExecContext(ctx, query, version.ID, version.Name, version.Pinned, version.Comment)
+	if err != nil {
+		return fmt.Errorf("failed to update file version: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rows == 0 {
+		return ErrVersionNotFound
+	}
+
+	return nil
+}
+
+// GetFilesWithOldVersions returns up to limit files, ordered by ID after
+// after, that have more than one version and so may have versions to prune.
+// Trashed files are included; their versions are kept by the same policy.
+func (r *versionRepository) GetFilesWithOldVersions(ctx context.Context, after uuid.UUID, limit int) ([]*model.File, error) {
+	var files []*model.File
+	query := `
+		SELECT f.* FROM files f
+		JOIN (
+			SELECT file_id FROM file_versions
+			WHERE file_id > $1
+			GROUP BY file_id
+			HAVING COUNT(*) > 1
+			ORDER BY file_id
+			LIMIT $2
+		) v ON v.file_id = f.id
+		ORDER BY f.id
+	`
+
+	err := r.db.SelectContext(ctx, &files, query, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files with old versions: %w", err)
+	}
+
+	return files, nil
+}
+
+// DeleteUnpinned deletes the given versions, except any pinned since they
+// were chosen, and returns those deleted
+func (r *versionRepository) DeletePrunable(ctx context.Context, ids []uuid.UUID) ([]*model.FileVersion, error) {
+	var versions []*model.FileVersion
+	query := `
+		DELETE FROM file_versions
+		WHERE id = ANY($1) AND NOT pinned AND name IS NULL
+		RETURNING *
+	`
+
+	err := r.db.SelectContext(ctx, &versions, query, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete file versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// IsStoragePathReferenced reports whether any file or version still holds
+// the content at storagePath
+func (r *versionRepository) IsStoragePathReferenced(ctx context.Context, storagePath string) (bool, error) {
+	var referenced bool
+	query := `
+		SELECT EXISTS (SELECT 1 FROM files WHERE storage_path = $1)
+			OR EXISTS (SELECT 1 FROM file_versions WHERE storage_path = $1)
+	`
+
+	err := r.db.GetContext(ctx, &referenced, query, storagePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to check storage path references: %w", err)
+	}
+
+	return referenced, nil
+}
+
+// GetRetentionPolicy returns the retention policy set for a tenant, or nil
+// if the tenant uses the default
+func (r *versionRepository) GetRetentionPolicy(ctx context.Context, tenantID uuid.UUID) (*model.VersionRetentionPolicy, error) {
+	var policy model.VersionRetentionPolicy
+	query := `SELECT * FROM version_retention_policies WHERE tenant_id = $1`
+
+	err := r.db.GetContext(ctx, &policy, query, tenantID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get version retention policy: %w", err)
+	}
+
+	return &policy, nil
+}
+
+func (r *versionRepository) SetRetentionPolicy(ctx context.Context, policy *model.VersionRetentionPolicy) error {
+	query := `
+		INSERT INTO version_retention_policies (
+			tenant_id, keep_last, keep_days, daily_days, weekly_days,
+			updated_by, updated_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7
+		)
+		ON CONFLICT (tenant_id) DO UPDATE SET
+			keep_last = EXCLUDED.keep_last,
+			keep_days = EXCLUDED.keep_days,
+			daily_days = EXCLUDED.daily_days,
+			weekly_days = EXCLUDED.weekly_days,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		policy.TenantID, policy.KeepLast, policy.KeepDays, policy.DailyDays,
+		policy.WeeklyDays, policy.UpdatedBy, policy.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set version retention policy: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,377 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+var (
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrPermissionNotFound = errors.New("permission not found")
+	ErrInvalidPermission  = errors.New("invalid permission")
+	ErrGroupNotFound      = errors.New("group not found")
+	ErrInvalidGroup       = errors.New("invalid group")
+)
+
+var roleRank = map[model.PermissionRole]int{
+	model.PermissionOwner:  3,
+	model.PermissionEditor: 2,
+	model.PermissionViewer: 1,
+}
+
+// GetEffectiveAccess lists everyone with access to a resource and why: the
+// owners of the resource and the folders containing it, then each user,
+// group member and email address with the grant that decides their role.
+// Grants nearer the resource override grants further up, as in permission
+// checks.
+func (s *driveService) GetEffectiveAccess(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID) ([]*model.EffectiveAccess, error) {
+	hasPermission, err := s.permissionRepo.HasPermission(ctx, resourceID, resourceType, userID, model.PermissionViewer)
+	if err != nil {
+		return nil, err
+	}
+	if !hasPermission {
+		return nil, ErrPermissionDenied
+	}
+
+	ancestors, err := s.permissionRepo.GetAncestors(ctx, resourceID, resourceType)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := s.permissionRepo.GetInherited(ctx, resourceID, resourceType)
+	if err != nil {
+		return nil, err
+	}
+
+	var groupIDs []uuid.UUID
+	for _, permission := range permissions {
+		if permission.GroupID != nil {
+			groupIDs = append(groupIDs, *permission.GroupID)
+		}
+	}
+	members, err := s.groupRepo.GetMembers(ctx, groupIDs)
+	if err != nil {
+		return nil, err
+	}
+	groupMembers := make(map[uuid.UUID][]uuid.UUID)
+	for _, member := range members {
+		groupMembers[member.GroupID] = append(groupMembers[member.GroupID], member.UserID)
+	}
+
+	var order []string
+	access := make(map[string]*model.EffectiveAccess)
+	depths := make(map[string]int)
+	add := func(key string, depth int, entry *model.EffectiveAccess) {
+		current, ok := access[key]
+		if !ok {
+			order = append(order, key)
+		} else if depths[key] < depth || (depths[key] == depth && roleRank[current.Role] >= roleRank[entry.Role]) {
+			return
+		}
+		access[key] = entry
+		depths[key] = depth
+	}
+
+	// Owners are owners whatever they have been granted
+	for _, ancestor := range ancestors {
+		ownerID := ancestor.OwnerID
+		add(ownerID.String(), -1, &model.EffectiveAccess{
+			UserID:       &ownerID,
+			Role:         model.PermissionOwner,
+			Source:       model.AccessSourceOwner,
+			ResourceID:   ancestor.ResourceID,
+			ResourceType: ancestor.ResourceType,
+		})
+	}
+
+	for _, permission := range permissions {
+		source := model.AccessSourceDirect
+		if permission.Depth > 0 {
+			source = model.AccessSourceInherited
+		}
+		grant := func() *model.EffectiveAccess {
+			permissionID := permission.ID
+			return &model.EffectiveAccess{
+				Role:         permission.Role,
+				Source:       source,
+				ResourceID:   permission.ResourceID,
+				ResourceType: permission.ResourceType,
+				PermissionID: &permissionID,
+				ExpiresAt:    permission.ExpiresAt,
+			}
+		}
+
+		switch {
+		case permission.UserID != nil:
+			entry := grant()
+			entry.UserID = permission.UserID
+			add(permission.UserID.String(), permission.Depth, entry)
+		case permission.GroupID != nil:
+			for _, memberID := range groupMembers[*permission.GroupID] {
+				memberID := memberID
+				entry := grant()
+				entry.UserID = &memberID
+				entry.GroupID = permission.GroupID
+				add(memberID.String(), permission.Depth, entry)
+			}
+		case permission.Email != nil:
+			entry := grant()
+			entry.Email = permission.Email
+			add("email:"+strings.ToLower(*permission.Email), permission.Depth, entry)
+		}
+	}
+
+	result := make([]*model.EffectiveAccess, 0, len(order))
+	for _, key := range order {
+		result = append(result, access[key])
+	}
+
+	return result, nil
+}
+
+// ExpirePermissions deletes permissions past their expiry. Expired
+// permissions already grant nothing; this keeps them from piling up.
+// Returns the number deleted.
+func (s *driveService) ExpirePermissions(ctx context.Context) (int, error) {
+	expired := 0
+	for {
+		deleted, err := s.permissionRepo.DeleteExpired(ctx, time.Now(), expireBatchSize)
+		if err != nil {
+			return expired, err
+		}
+		expired += len(deleted)
+
+		// The expiry is recorded as a change by the permission's creator
+		for _, permission := range deleted {
+			s.recordChange(ctx, permission.TenantID, permission.GrantedBy, permission.ResourceType, permission.ResourceID,
+				model.ChangePermissionChanged, s.permissionPrincipals(ctx, permission))
+		}
+
+		if len(deleted) < expireBatchSize {
+			return expired, nil
+		}
+	}
+}
+
+// CreateGroup creates a group with the given members. Only the group's
+// creator can change its members or delete it.
+func (s *driveService) CreateGroup(ctx context.Context, tenantID, userID uuid.UUID, req *model.CreateGroupRequest) (*model.Group, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidGroup)
+	}
+
+	now := time.Now()
+	group := &model.Group{
+		ID:          uuid.New(),
+		TenantID:    tenantID,
+		Name:        name,
+		Description: req.Description,
+		CreatedBy:   userID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if err := s.groupRepo.Create(ctx, group); err != nil {
+		return nil, err
+	}
+
+	for _, memberID := range req.Members {
+		member := &model.GroupMember{
+			GroupID:   group.ID,
+			UserID:    memberID,
+			AddedBy:   userID,
+			CreatedAt: now,
+		}
+		if err := s.groupRepo.AddMember(ctx, member); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.GetGroup(ctx, tenantID, group.ID)
+}
+
+// GetGroup gets a group of the tenant with its members
+func (s *driveService) GetGroup(ctx context.Context, tenantID, groupID uuid.UUID) (*model.Group, error) {
+	group, err := s.getGroup(ctx, tenantID, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := s.groupRepo.GetMembers(ctx, []uuid.UUID{group.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	group.Members = make([]uuid.UUID, len(members))
+	for i, member := range members {
+		group.Members[i] = member.UserID
+	}
+
+	return group, nil
+}
+
+// ListGroups lists the tenant's groups
+func (s *driveService) ListGroups(ctx context.Context, tenantID uuid.UUID) ([]*model.Group, error) {
+	return s.groupRepo.GetByTenant(ctx, tenantID)
+}
+
+// DeleteGroup deletes a group along with the permissions granted to it
+func (s *driveService) DeleteGroup(ctx context.Context, tenantID, groupID, userID uuid.UUID) error {
+	group, err := s.getGroup(ctx, tenantID, groupID)
+	if err != nil {
+		return err
+	}
+	if group.CreatedBy != userID {
+		return ErrPermissionDenied
+	}
+
+	// Members lose what was shared with the group
+	permissions, err := s.permissionRepo.GetByGroup(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	var members []uuid.UUID
+	if len(permissions) > 0 {
+		members = s.permissionPrincipals(ctx, permissions[0])
+	}
+
+	if err := s.groupRepo.Delete(ctx, groupID); err != nil {
+		return err
+	}
+
+	for _, permission := range permissions {
+		s.recordChange(ctx, tenantID, userID, permission.ResourceType, permission.ResourceID, model.ChangePermissionChanged, members)
+	}
+
+	return nil
+}
+
+// AddGroupMember adds a user to a group
+func (s *driveService) AddGroupMember(ctx context.Context, tenantID, groupID, userID uuid.UUID, req *model.AddGroupMemberRequest) (*model.Group, error) {
+	group, err := s.getGroup(ctx, tenantID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if group.CreatedBy != userID {
+		return nil, ErrPermissionDenied
+	}
+	if req.UserID == uuid.Nil {
+		return nil, fmt.Errorf("%w: user_id is required", ErrInvalidGroup)
+	}
+	if err := s.checkTenantUser(ctx, tenantID, req.UserID); err != nil {
+		return nil, err
+	}
+
+	member := &model.GroupMember{
+		GroupID:   groupID,
+		UserID:    req.UserID,
+		AddedBy:   userID,
+		CreatedAt: time.Now(),
+	}
+	if err := s.groupRepo.AddMember(ctx, member); err != nil {
+		return nil, err
+	}
+
+	s.recordGroupChange(ctx, tenantID, groupID, userID, req.UserID)
+
+	return s.GetGroup(ctx, tenantID, groupID)
+}
+
+// RemoveGroupMember removes a user from a group. Members can remove
+// themselves.
+func (s *driveService) RemoveGroupMember(ctx context.Context, tenantID, groupID, userID, memberID uuid.UUID) error {
+	group, err := s.getGroup(ctx, tenantID, groupID)
+	if err != nil {
+		return err
+	}
+	if group.CreatedBy != userID && memberID != userID {
+		return ErrPermissionDenied
+	}
+
+	if err := s.groupRepo.RemoveMember(ctx, groupID, memberID); err != nil {
+		return err
+	}
+
+	s.recordGroupChange(ctx, tenantID, groupID, userID, memberID)
+
+	return nil
+}
+
+// recordGroupChange records that a member joining or leaving a group
+// changed who has access to what was shared with the group
+func (s *driveService) recordGroupChange(ctx context.Context, tenantID, groupID, userID, memberID uuid.UUID) {
+	permissions, err := s.permissionRepo.GetByGroup(ctx, groupID)
+	if err != nil {
+		return
+	}
+	for _, permission := range permissions {
+		s.recordChange(ctx, tenantID, userID, permission.ResourceType, permission.ResourceID,
+			model.ChangePermissionChanged, []uuid.UUID{memberID})
+	}
+}
+
+// RecordUser records that a user signed in to a tenant, making them a user
+// of the tenant that permissions can be granted to
+func (s *driveService) RecordUser(ctx context.Context, tenantID, userID uuid.UUID, email string) error {
+	return s.userRepo.Record(ctx, tenantID, userID, email, time.Now())
+}
+
+// checkTenantUser checks that a user has signed in to the tenant, so that
+// nobody outside it is given access to its files
+func (s *driveService) checkTenantUser(ctx context.Context, tenantID, userID uuid.UUID) error {
+	member, err := s.userRepo.IsMember(ctx, tenantID, userID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return fmt.Errorf("%w: user %s is not a user of the organization; share by email instead", ErrInvalidPermission, userID)
+	}
+	return nil
+}
+
+// checkResourceAccess checks that a file or folder belongs to the tenant
+// and that the user has at least role on it. Resources of other tenants
+// are reported as ErrPermissionDenied, as if they did not exist.
+func (s *driveService) checkResourceAccess(ctx context.Context, tenantID uuid.UUID, resourceType model.ResourceType, resourceID, userID uuid.UUID, role model.PermissionRole) error {
+	var resourceTenantID uuid.UUID
+	switch resourceType {
+	case model.ResourceTypeFile:
+		file, err := s.fileRepo.GetByID(ctx, resourceID)
+		if err != nil {
+			return ErrPermissionDenied
+		}
+		resourceTenantID = file.TenantID
+	case model.ResourceTypeFolder:
+		folder, err := s.folderRepo.GetByID(ctx, resourceID)
+		if err != nil {
+			return ErrPermissionDenied
+		}
+		resourceTenantID = folder.TenantID
+	default:
+		return fmt.Errorf("%w: resource_type must be file or folder", ErrInvalidPermission)
+	}
+	if resourceTenantID != tenantID {
+		return ErrPermissionDenied
+	}
+
+	hasPermission, err := s.permissionRepo.HasPermission(ctx, resourceID, resourceType, userID, role)
+	if err != nil || !hasPermission {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+func (s *driveService) getGroup(ctx context.Context, tenantID, groupID uuid.UUID) (*model.Group, error) {
+	group, err := s.groupRepo.GetByID(ctx, groupID)
+	if err != nil || group.TenantID != tenantID {
+		return nil, ErrGroupNotFound
+	}
+	return group, nil
+}
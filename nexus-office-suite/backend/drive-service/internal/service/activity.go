@@ -0,0 +1,443 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+const (
+	defaultActivityLimit = 100
+	maxActivityLimit     = 1000
+	// activityExportBatchSize is how many activities are read per query
+	// when exporting
+	activityExportBatchSize = 1000
+)
+
+var ErrInvalidActivityQuery = errors.New("invalid activity query")
+
+// activityExportColumns are the columns of CSV exports
+var activityExportColumns = []string{
+	"created_at", "actor_id", "action", "resource_type", "resource_id", "resource_name",
+	"from_folder_id", "share_link_id", "ip_address", "user_agent", "details",
+}
+
+// ListActivity returns a page of activity, newest first. A file's or
+// folder's activity can be seen by its owners; otherwise users see what
+// they did themselves. Tenant administrators can see all of their
+// tenant's activity.
+func (s *driveService) ListActivity(ctx context.Context, tenantID, userID uuid.UUID, query *model.ActivityQuery) (*model.ActivityPage, error) {
+	after, err := s.checkActivityQuery(ctx, tenantID, userID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultActivityLimit
+	}
+	limit = min(limit, maxActivityLimit)
+
+	activities, err := s.activityRepo.Query(ctx, tenantID, query, after, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &model.ActivityPage{Activities: activities}
+	if len(activities) > limit {
+		page.Activities = activities[:limit]
+		last := page.Activities[limit-1]
+		page.NextCursor = formatActivityCursor(&model.ActivityCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	if page.Activities == nil {
+		page.Activities = []*model.Activity{}
+	}
+
+	return page, nil
+}
+
+// ExportActivity returns a function writing all activity matching query,
+// from its cursor on, as CSV or a JSON array. The query is checked as by
+// ListActivity before anything is written.
+func (s *driveService) ExportActivity(ctx context.Context, tenantID, userID uuid.UUID, query *model.ActivityQuery, format string) (func(w io.Writer) error, error) {
+	if format != "csv" && format != "json" {
+		return nil, fmt.Errorf("%w: format must be csv or json", ErrInvalidActivityQuery)
+	}
+
+	after, err := s.checkActivityQuery(ctx, tenantID, userID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w io.Writer) error {
+		export := newActivityExport(w, format)
+		for {
+			activities, err := s.activityRepo.Query(ctx, tenantID, query, after, activityExportBatchSize)
+			if err != nil {
+				return err
+			}
+
+			for _, activity := range activities {
+				if err := export.write(activity); err != nil {
+					return err
+				}
+			}
+
+			if len(activities) < activityExportBatchSize {
+				return export.close()
+			}
+			last := activities[len(activities)-1]
+			after = &model.ActivityCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		}
+	}, nil
+}
+
+// GetActivityRetention returns how long a tenant keeps its activity, which
+// is the configured default unless the tenant has set its own
+func (s *driveService) GetActivityRetention(ctx context.Context, tenantID uuid.UUID) (*model.ActivityRetentionPolicy, error) {
+	policy, err := s.activityRepo.GetRetentionPolicy(ctx, tenantID)
+	if err != nil || policy != nil {
+		return policy, err
+	}
+
+	return &model.ActivityRetentionPolicy{
+		TenantID:      tenantID,
+		RetentionDays: s.activityConfig.RetentionDays,
+		Default:       true,
+	}, nil
+}
+
+// SetActivityRetention sets how long a tenant keeps its activity. Older
+// activity is pruned in the background.
+func (s *driveService) SetActivityRetention(ctx context.Context, tenantID, userID uuid.UUID, req *model.SetActivityRetentionRequest) (*model.ActivityRetentionPolicy, error) {
+	if req.RetentionDays < 0 || req.RetentionDays > maxRetention {
+		return nil, fmt.Errorf("%w: retention_days must be between 0 and %d", ErrInvalidRetention, maxRetention)
+	}
+
+	now := time.Now()
+	policy := &model.ActivityRetentionPolicy{
+		TenantID:      tenantID,
+		RetentionDays: req.RetentionDays,
+		UpdatedBy:     &userID,
+		UpdatedAt:     &now,
+	}
+
+	if err := s.activityRepo.SetRetentionPolicy(ctx, policy); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// PruneActivity deletes activity older than its tenant's retention and
+// returns how much was deleted
+func (s *driveService) PruneActivity(ctx context.Context) (int, error) {
+	now := time.Now()
+	pruned := 0
+	for {
+		deleted, err := s.activityRepo.DeleteExpired(ctx, s.activityConfig.RetentionDays, now, pruneBatchSize)
+		if err != nil {
+			return pruned, err
+		}
+		pruned += deleted
+
+		if deleted < pruneBatchSize {
+			return pruned, nil
+		}
+	}
+}
+
+// checkActivityQuery checks that the user can see the activity query
+// selects and returns the position its cursor continues after, if any
+func (s *driveService) checkActivityQuery(ctx context.Context, tenantID, userID uuid.UUID, query *model.ActivityQuery) (*model.ActivityCursor, error) {
+	if query.FileID != nil && query.FolderID != nil {
+		return nil, fmt.Errorf("%w: select either a file or a folder", ErrInvalidActivityQuery)
+	}
+	if query.Since != nil && query.Until != nil && !query.Since.Before(*query.Until) {
+		return nil, fmt.Errorf("%w: since must be before until", ErrInvalidActivityQuery)
+	}
+
+	var after *model.ActivityCursor
+	if query.Cursor != "" {
+		cursor, err := parseActivityCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = cursor
+	}
+
+	switch {
+	case query.FileID != nil:
+		file, err := s.fileRepo.GetByID(ctx, *query.FileID)
+		if err != nil {
+			return nil, err
+		}
+		if file.TenantID != tenantID {
+			return nil, ErrPermissionDenied
+		}
+		if !query.Admin && file.OwnerID != userID {
+			hasPermission, err := s.permissionRepo.HasPermission(ctx, file.ID, model.ResourceTypeFile, userID, model.PermissionOwner)
+			if err != nil || !hasPermission {
+				return nil, ErrPermissionDenied
+			}
+		}
+	case query.FolderID != nil:
+		folder, err := s.folderRepo.GetByID(ctx, *query.FolderID)
+		if err != nil {
+			return nil, err
+		}
+		if folder.TenantID != tenantID {
+			return nil, ErrPermissionDenied
+		}
+		if !query.Admin && folder.OwnerID != userID {
+			hasPermission, err := s.permissionRepo.HasPermission(ctx, folder.ID, model.ResourceTypeFolder, userID, model.PermissionOwner)
+			if err != nil || !hasPermission {
+				return nil, ErrPermissionDenied
+			}
+		}
+	case !query.Admin:
+		if query.ActorID != nil && *query.ActorID != userID {
+			return nil, ErrPermissionDenied
+		}
+		query.ActorID = &userID
+	}
+
+	return after, nil
+}
+
+// logActivity records an activity, with the address and user agent of the
+// client making the request unless they are already set. The activity log
+// must not fail the operation it records, so errors are ignored.
+func (s *driveService) logActivity(ctx context.Context, activity *model.Activity) {
+	activity.ID = uuid.New()
+	activity.CreatedAt = time.Now()
+	if activity.IPAddress == nil {
+		if ip, _ := ctx.Value("client_ip").(string); ip != "" {
+			activity.IPAddress = &ip
+		}
+	}
+	if activity.UserAgent == nil {
+		if userAgent, _ := ctx.Value("user_agent").(string); userAgent != "" {
+			activity.UserAgent = &userAgent
+		}
+	}
+
+	_ = s.activityRepo.Record(ctx, activity)
+}
+
+func (s *driveService) logFileActivity(ctx context.Context, file *model.File, actorID uuid.UUID, action model.ActivityAction, details model.Metadata) {
+	s.logActivity(ctx, fileActivity(file, actorID, action, details))
+}
+
+func (s *driveService) logFolderActivity(ctx context.Context, folder *model.Folder, actorID uuid.UUID, action model.ActivityAction, details model.Metadata) {
+	s.logActivity(ctx, folderActivity(folder, actorID, action, details))
+}
+
+// logMoveActivity records that the resource of activity was moved or
+// renamed, or that its other metadata changed
+func (s *driveService) logMoveActivity(ctx context.Context, activity *model.Activity, oldName string, oldParentID, parentID *uuid.UUID) {
+	switch fileChangeType(oldName, oldParentID, activity.ResourceName, parentID) {
+	case model.ChangeMoved:
+		activity.Action = model.ActivityMoved
+		activity.FromFolderID = oldParentID
+		if oldName != activity.ResourceName {
+			activity.Details = model.Metadata{"old_name": oldName}
+		}
+	case model.ChangeRenamed:
+		activity.Action = model.ActivityRenamed
+		activity.Details = model.Metadata{"old_name": oldName}
+	default:
+		activity.Action = model.ActivityUpdated
+	}
+
+	s.logActivity(ctx, activity)
+}
+
+// logResourceActivity records an activity on the resource it names,
+// looking up the resource's tenant and name
+func (s *driveService) logResourceActivity(ctx context.Context, activity *model.Activity) {
+	if activity.ResourceType == model.ResourceTypeFile {
+		file, err := s.fileRepo.GetByID(ctx, activity.ResourceID)
+		if err != nil {
+			return
+		}
+		activity.TenantID, activity.ResourceName = file.TenantID, file.Name
+	} else {
+		folder, err := s.folderRepo.GetByID(ctx, activity.ResourceID)
+		if err != nil {
+			return
+		}
+		activity.TenantID, activity.ResourceName = folder.TenantID, folder.Name
+	}
+
+	s.logActivity(ctx, activity)
+}
+
+// permissionDetails describes a permission granted or revoked
+func permissionDetails(permission *model.Permission) model.Metadata {
+	details := model.Metadata{"permission_id": permission.ID, "role": permission.Role}
+	switch {
+	case permission.UserID != nil:
+		details["user_id"] = *permission.UserID
+	case permission.GroupID != nil:
+		details["group_id"] = *permission.GroupID
+	case permission.Email != nil:
+		details["email"] = *permission.Email
+	}
+	return details
+}
+
+func fileActivity(file *model.File, actorID uuid.UUID, action model.ActivityAction, details model.Metadata) *model.Activity {
+	return &model.Activity{
+		TenantID:     file.TenantID,
+		ActorID:      &actorID,
+		Action:       action,
+		ResourceType: model.ResourceTypeFile,
+		ResourceID:   file.ID,
+		ResourceName: file.Name,
+		Details:      details,
+	}
+}
+
+func folderActivity(folder *model.Folder, actorID uuid.UUID, action model.ActivityAction, details model.Metadata) *model.Activity {
+	return &model.Activity{
+		TenantID:     folder.TenantID,
+		ActorID:      &actorID,
+		Action:       action,
+		ResourceType: model.ResourceTypeFolder,
+		ResourceID:   folder.ID,
+		ResourceName: folder.Name,
+		Details:      details,
+	}
+}
+
+// formatActivityCursor encodes a position in the activity log as
+// base64(unixnano.id)
+func formatActivityCursor(cursor *model.ActivityCursor) string {
+	payload := strconv.FormatInt(cursor.CreatedAt.UnixNano(), 10) + "." + cursor.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(payload))
+}
+
+func parseActivityCursor(cursor string) (*model.ActivityCursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	timestamp, id, ok := strings.Cut(string(payload), ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	activityID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &model.ActivityCursor{CreatedAt: time.Unix(0, nanos), ID: activityID}, nil
+}
+
+// activityExport writes activities as CSV or as a JSON array
+type activityExport struct {
+	w       io.Writer
+	csv     *csv.Writer
+	encoder *json.Encoder
+	count   int
+}
+
+func newActivityExport(w io.Writer, format string) *activityExport {
+	if format == "csv" {
+		return &activityExport{w: w, csv: csv.NewWriter(w)}
+	}
+	return &activityExport{w: w, encoder: json.NewEncoder(w)}
+}
+
+func (e *activityExport) write(activity *model.Activity) error {
+	defer func() { e.count++ }()
+
+	if e.csv == nil {
+		separator := ","
+		if e.count == 0 {
+			separator = "["
+		}
+		if _, err := io.WriteString(e.w, separator); err != nil {
+			return err
+		}
+		return e.encoder.Encode(activity)
+	}
+
+	if e.count == 0 {
+		if err := e.csv.Write(activityExportColumns); err != nil {
+			return err
+		}
+	}
+
+	details := ""
+	if len(activity.Details) > 0 {
+		data, err := json.Marshal(activity.Details)
+		if err != nil {
+			return err
+		}
+		details = string(data)
+	}
+
+	return e.csv.Write([]string{
+		activity.CreatedAt.UTC().Format(time.RFC3339Nano),
+		optionalString(activity.ActorID),
+		string(activity.Action),
+		string(activity.ResourceType),
+		activity.ResourceID.String(),
+		activity.ResourceName,
+		optionalString(activity.FromFolderID),
+		optionalString(activity.ShareLinkID),
+		derefString(activity.IPAddress),
+		derefString(activity.UserAgent),
+		details,
+	})
+}
+
+func (e *activityExport) close() error {
+	if e.csv == nil {
+		closing := "]\n"
+		if e.count == 0 {
+			closing = "[]\n"
+		}
+		_, err := io.WriteString(e.w, closing)
+		return err
+	}
+
+	if e.count == 0 {
+		if err := e.csv.Write(activityExportColumns); err != nil {
+			return err
+		}
+	}
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+func optionalString(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
+func derefString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
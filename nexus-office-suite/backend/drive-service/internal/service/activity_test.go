@@ -0,0 +1,173 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+func TestActivityLog(t *testing.T) {
+	store := newFakeStore()
+	s := newTestService(store)
+	tenantID := uuid.New()
+	alice, bob := uuid.New(), uuid.New()
+	file := store.addFile(alice, nil)
+	file.TenantID = tenantID
+	store.grant(file.ID, bob, model.PermissionEditor)
+
+	ctx := context.WithValue(context.Background(), "client_ip", "192.0.2.1")
+	name := "renamed.txt"
+	if _, err := s.UpdateFile(ctx, file.ID, bob, &model.UpdateFileRequest{Name: &name}, ""); err != nil {
+		t.Fatal(err)
+	}
+	folder := store.addFolder(alice, nil)
+	if _, err := s.UpdateFile(ctx, file.ID, alice, &model.UpdateFileRequest{FolderID: &folder.ID}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner sees everything done to the file
+	page, err := s.ListActivity(ctx, tenantID, alice, &model.ActivityQuery{FileID: &file.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Activities) != 2 {
+		t.Fatalf("got %d activities, want the rename and the move", len(page.Activities))
+	}
+	moved, renamed := page.Activities[0], page.Activities[1]
+	if renamed.Action != model.ActivityRenamed || *renamed.ActorID != bob || renamed.Details["old_name"] != "file.txt" {
+		t.Errorf("rename = %+v, want bob renaming file.txt", renamed)
+	}
+	if renamed.IPAddress == nil || *renamed.IPAddress != "192.0.2.1" {
+		t.Errorf("rename recorded from %v, want the client's address", renamed.IPAddress)
+	}
+	if moved.Action != model.ActivityMoved || moved.FromFolderID != nil || moved.ResourceName != name {
+		t.Errorf("move = %+v, want renamed.txt moved from the root", moved)
+	}
+
+	// Others only see what they did themselves
+	if _, err := s.ListActivity(ctx, tenantID, bob, &model.ActivityQuery{FileID: &file.ID}); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("an editor listing the file's activity: got %v, want %v", err, ErrPermissionDenied)
+	}
+	if _, err := s.ListActivity(ctx, tenantID, bob, &model.ActivityQuery{ActorID: &alice}); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("listing another user's activity: got %v, want %v", err, ErrPermissionDenied)
+	}
+	page, err = s.ListActivity(ctx, tenantID, bob, &model.ActivityQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Activities) != 1 || page.Activities[0].Action != model.ActivityRenamed {
+		t.Errorf("bob's activity = %+v, want only his rename", page.Activities)
+	}
+
+	// Administrators see the whole tenant, but only their own tenant
+	page, err = s.ListActivity(ctx, tenantID, bob, &model.ActivityQuery{Admin: true})
+	if err != nil || len(page.Activities) != 2 {
+		t.Errorf("an administrator's listing = %v, %v, want both activities", page, err)
+	}
+	if _, err := s.ListActivity(ctx, uuid.New(), bob, &model.ActivityQuery{FileID: &file.ID, Admin: true}); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("another tenant's administrator: got %v, want %v", err, ErrPermissionDenied)
+	}
+}
+
+func TestListActivityPages(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	alice := uuid.New()
+	file := store.addFile(alice, nil)
+	for i := 0; i < 5; i++ {
+		s.logFileActivity(ctx, file, alice, model.ActivityViewed, nil)
+	}
+
+	var seen []uuid.UUID
+	query := &model.ActivityQuery{Limit: 2}
+	for {
+		page, err := s.ListActivity(ctx, uuid.Nil, alice, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, activity := range page.Activities {
+			seen = append(seen, activity.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	if len(seen) != 5 {
+		t.Fatalf("paged through %d activities, want 5", len(seen))
+	}
+	for i, activity := range s.activityRepo.(*fakeActivityRepo).activities {
+		if seen[len(seen)-1-i] != activity.ID {
+			t.Fatal("activities were not listed newest first")
+		}
+	}
+
+	for _, query := range []*model.ActivityQuery{
+		{Cursor: "not a cursor"},
+		{FileID: &file.ID, FolderID: &file.ID},
+	} {
+		if _, err := s.ListActivity(ctx, uuid.Nil, alice, query); err == nil {
+			t.Errorf("query %+v accepted", query)
+		}
+	}
+}
+
+func TestExportActivity(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	alice := uuid.New()
+	file := store.addFile(alice, nil)
+	s.logFileActivity(ctx, file, alice, model.ActivityCreated, nil)
+	s.logFileActivity(ctx, file, alice, model.ActivityShared, model.Metadata{"role": "viewer"})
+
+	if _, err := s.ExportActivity(ctx, uuid.Nil, alice, &model.ActivityQuery{}, "xml"); !errors.Is(err, ErrInvalidActivityQuery) {
+		t.Errorf("export as XML: got %v, want %v", err, ErrInvalidActivityQuery)
+	}
+
+	write, err := s.ExportActivity(ctx, uuid.Nil, alice, &model.ActivityQuery{}, "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0][2] != "action" || records[1][2] != "shared" || records[1][10] != `{"role":"viewer"}` {
+		t.Errorf("CSV export = %q, want a header and both activities", records)
+	}
+
+	write, err = s.ExportActivity(ctx, uuid.Nil, alice, &model.ActivityQuery{}, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var activities []*model.Activity
+	if err := json.Unmarshal(buf.Bytes(), &activities); err != nil {
+		t.Fatalf("JSON export %q: %v", buf.String(), err)
+	}
+	if len(activities) != 2 || activities[1].Action != model.ActivityCreated {
+		t.Errorf("JSON export = %+v, want both activities, newest first", activities)
+	}
+
+	// Nothing to export is an empty array
+	write, _ = s.ExportActivity(ctx, uuid.Nil, uuid.New(), &model.ActivityQuery{}, "json")
+	buf.Reset()
+	if err := write(&buf); err != nil || buf.String() != "[]\n" {
+		t.Errorf("empty JSON export = %q, %v", buf.String(), err)
+	}
+}
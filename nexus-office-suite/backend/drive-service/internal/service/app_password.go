@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+// appPasswordBytes is the number of random bytes in an app password
+const appPasswordBytes = 16
+
+var (
+	ErrAppPasswordNotFound = errors.New("app password not found")
+	ErrInvalidAppPassword  = errors.New("invalid app password")
+	ErrInvalidCredentials  = errors.New("invalid username or password")
+)
+
+// CreateAppPassword creates an app password for a user to sign in to
+// WebDAV with. login is the username it is to be used with, normally the
+// user's email address; if empty, the user ID is used. The password is only
+// returned now.
+func (s *driveService) CreateAppPassword(ctx context.Context, tenantID, userID uuid.UUID, login string, req *model.CreateAppPasswordRequest) (*model.AppPassword, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 255 {
+		return nil, fmt.Errorf("%w: name is required and must be at most 255 bytes", ErrInvalidAppPassword)
+	}
+	if login == "" {
+		login = userID.String()
+	}
+
+	password, err := generateToken(appPasswordBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	appPassword := &model.AppPassword{
+		ID:           uuid.New(),
+		TenantID:     tenantID,
+		UserID:       userID,
+		Login:        login,
+		Name:         name,
+		PasswordHash: hashAppPassword(password),
+		Password:     password,
+		CreatedAt:    time.Now(),
+	}
+
+	if err := s.appPasswordRepo.Create(ctx, appPassword); err != nil {
+		return nil, err
+	}
+
+	return appPassword, nil
+}
+
+// ListAppPasswords lists a user's app passwords
+func (s *driveService) ListAppPasswords(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.AppPassword, error) {
+	return s.appPasswordRepo.GetByUser(ctx, tenantID, userID)
+}
+
+// DeleteAppPassword revokes one of a user's app passwords
+func (s *driveService) DeleteAppPassword(ctx context.Context, tenantID, userID, appPasswordID uuid.UUID) error {
+	if err := s.appPasswordRepo.Delete(ctx, tenantID, userID, appPasswordID); err != nil {
+		return ErrAppPasswordNotFound
+	}
+	return nil
+}
+
+// AuthenticateAppPassword returns the app password matching a username and
+// password. The username is the password's login or its user's ID.
+func (s *driveService) AuthenticateAppPassword(ctx context.Context, username, password string) (*model.AppPassword, error) {
+	appPassword, err := s.appPasswordRepo.GetByHash(ctx, hashAppPassword(password))
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if !strings.EqualFold(username, appPassword.Login) && username != appPassword.UserID.String() {
+		return nil, ErrInvalidCredentials
+	}
+
+	_ = s.appPasswordRepo.Touch(ctx, appPassword.ID)
+
+	return appPassword, nil
+}
+
+// hashAppPassword hashes an app password for storage. App passwords are
+// random, so a fast hash is enough.
+func hashAppPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
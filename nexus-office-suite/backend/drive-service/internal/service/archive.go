@@ -0,0 +1,748 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/repository"
+	"github.com/nexus/drive-service/internal/storage"
+)
+
+const (
+	// extractionLease is how long an extraction may go without reporting
+	// progress before it is presumed dead and resumed
+	extractionLease = 5 * time.Minute
+	// extractionMaxAttempts is how many times an interrupted extraction is
+	// resumed before it fails
+	extractionMaxAttempts = 3
+	// extractionProgressInterval is how often progress is recorded
+	extractionProgressInterval = 2 * time.Second
+)
+
+var (
+	ErrExtractionNotFound = repository.ErrExtractionNotFound
+	ErrInvalidArchive     = errors.New("invalid archive")
+	ErrArchiveTooLarge    = errors.New("archive is too large to extract")
+)
+
+// archiveFormat is a kind of archive that can be extracted
+type archiveFormat int
+
+const (
+	archiveFormatNone archiveFormat = iota
+	archiveFormatZip
+	archiveFormatTarGz
+	archiveFormatTar
+)
+
+// DownloadArchive prepares a zip archive of files and folders the user can
+// view and returns its name and a function that streams it. Each selected
+// item is at the top of the archive, with the contents of folders below
+// them. The archive is written as it is read; nothing is stored.
+func (s *driveService) DownloadArchive(ctx context.Context, userID uuid.UUID, fileIDs, folderIDs []uuid.UUID) (string, func(w io.Writer) error, error) {
+	if len(fileIDs)+len(folderIDs) == 0 {
+		return "", nil, fmt.Errorf("%w: select at least one file or folder", ErrInvalidArchive)
+	}
+	if len(fileIDs)+len(folderIDs) > model.MaxArchiveSelection {
+		return "", nil, fmt.Errorf("%w: at most %d files and folders can be downloaded together", ErrInvalidArchive, model.MaxArchiveSelection)
+	}
+
+	files := make([]*model.File, 0, len(fileIDs))
+	for _, fileID := range fileIDs {
+		file, err := s.getFile(ctx, fileID, userID)
+		if err != nil {
+			return "", nil, err
+		}
+		files = append(files, file)
+	}
+
+	type folderTree struct {
+		folders []*model.Folder
+		files   []*model.File
+	}
+	trees := make([]folderTree, 0, len(folderIDs))
+	for _, folderID := range folderIDs {
+		if _, err := s.GetFolder(ctx, folderID, userID); err != nil {
+			return "", nil, err
+		}
+
+		// Access to a folder is inherited by everything inside it
+		folders, err := s.folderRepo.GetDescendants(ctx, folderID)
+		if err != nil {
+			return "", nil, err
+		}
+		ids := make([]uuid.UUID, len(folders))
+		for i, folder := range folders {
+			ids[i] = folder.ID
+		}
+		contents, err := s.fileRepo.GetByFolders(ctx, ids)
+		if err != nil {
+			return "", nil, err
+		}
+		trees = append(trees, folderTree{folders: folders, files: contents})
+	}
+
+	name := "download.zip"
+	switch {
+	case len(trees) == 1 && len(files) == 0:
+		name = trees[0].folders[0].Name + ".zip"
+	case len(files) == 1 && len(trees) == 0:
+		name = strings.TrimSuffix(files[0].Name, path.Ext(files[0].Name)) + ".zip"
+	}
+
+	write := func(w io.Writer) error {
+		for _, file := range files {
+			s.logFileActivity(ctx, file, userID, model.ActivityDownloaded, model.Metadata{"archive": name})
+		}
+		for _, tree := range trees {
+			s.logFolderActivity(ctx, tree.folders[0], userID, model.ActivityDownloaded, model.Metadata{"archive": name})
+		}
+
+		archive := s.newArchiveWriter(w)
+		for _, file := range files {
+			if err := archive.addFile(ctx, "", file); err != nil {
+				return err
+			}
+		}
+		for _, tree := range trees {
+			if err := archive.addFolder(ctx, tree.folders, tree.files); err != nil {
+				return err
+			}
+		}
+		return archive.close()
+	}
+	return name, write, nil
+}
+
+// archiveWriter writes files and folders to a zip archive, renaming entries
+// whose names are taken, as in "report (2).pdf"
+type archiveWriter struct {
+	s     *driveService
+	zw    *zip.Writer
+	paths map[string]bool
+}
+
+func (s *driveService) newArchiveWriter(w io.Writer) *archiveWriter {
+	return &archiveWriter{s: s, zw: zip.NewWriter(w), paths: make(map[string]bool)}
+}
+
+// addFolder writes folders, the first of which contains all the others, and
+// their files
+func (a *archiveWriter) addFolder(ctx context.Context, folders []*model.Folder, files []*model.File) error {
+	paths := make(map[uuid.UUID]string, len(folders))
+	for i, folder := range folders {
+		dir := ""
+		if i > 0 && folder.ParentID != nil {
+			dir = paths[*folder.ParentID]
+		}
+		path := a.uniquePath(dir, archiveName(folder.Name))
+		paths[folder.ID] = path
+
+		if _, err := a.zw.CreateHeader(&zip.FileHeader{Name: path + "/", Modified: folder.UpdatedAt}); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		if err := a.addFile(ctx, paths[*file.FolderID], file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addFile writes a file to the dir directory of the archive
+func (a *archiveWriter) addFile(ctx context.Context, dir string, file *model.File) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Images, video, audio and archives are compressed already
+	method := zip.Deflate
+	switch file.FileType {
+	case model.FileTypeImage, model.FileTypeVideo, model.FileTypeAudio, model.FileTypeArchive:
+		method = zip.Store
+	}
+
+	header := &zip.FileHeader{
+		Name:     a.uniquePath(dir, archiveName(file.Name)),
+		Method:   method,
+		Modified: file.UpdatedAt,
+	}
+	entry, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	reader, err := a.s.storage.DownloadFile(ctx, file.StoragePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(entry, reader)
+	return err
+}
+
+// uniquePath returns the path of name in dir, numbering the name if the
+// path is taken
+func (a *archiveWriter) uniquePath(dir, name string) string {
+	join := func(name string) string {
+		if dir == "" {
+			return name
+		}
+		return dir + "/" + name
+	}
+
+	p := join(name)
+	ext := path.Ext(name)
+	for n := 2; a.paths[strings.ToLower(p)]; n++ {
+		p = join(fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext))
+	}
+	a.paths[strings.ToLower(p)] = true
+	return p
+}
+
+func (a *archiveWriter) close() error {
+	return a.zw.Close()
+}
+
+// archiveName makes a file or folder name safe to use as a zip path element
+func archiveName(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// ExtractArchive creates a folder for the contents of an archive and queues
+// the archive to be extracted into it in the background
+func (s *driveService) ExtractArchive(ctx context.Context, tenantID, userID, fileID uuid.UUID, req *model.ExtractArchiveRequest) (*model.ArchiveExtraction, error) {
+	file, err := s.getFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if archiveFormatOf(file.Name) == archiveFormatNone {
+		return nil, fmt.Errorf("%w: only .zip, .tar.gz and .tar files can be extracted", ErrInvalidArchive)
+	}
+
+	parentID := file.FolderID
+	if req.FolderID != nil {
+		parentID = req.FolderID
+	}
+	if err := s.checkFolderAccess(ctx, parentID, userID, model.PermissionEditor); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = archiveBaseName(file.Name)
+	}
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	if name, err = s.uniqueFolderName(ctx, tenantID, userID, parentID, name); err != nil {
+		return nil, err
+	}
+
+	folder, err := s.CreateFolder(ctx, tenantID, userID, &model.CreateFolderRequest{Name: name, ParentID: parentID})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	extraction := &model.ArchiveExtraction{
+		ID:        uuid.New(),
+		TenantID:  tenantID,
+		UserID:    userID,
+		FileID:    file.ID,
+		FolderID:  folder.ID,
+		Status:    model.ExtractionStatusPending,
+		RunAt:     now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.archiveRepo.Create(ctx, extraction); err != nil {
+		return nil, err
+	}
+
+	s.logFileActivity(ctx, file, userID, model.ActivityExtracted, model.Metadata{
+		"extraction_id": extraction.ID,
+		"folder_id":     folder.ID,
+	})
+
+	return extraction, nil
+}
+
+// GetArchiveExtraction returns an extraction the user started, with its
+// progress
+func (s *driveService) GetArchiveExtraction(ctx context.Context, extractionID, userID uuid.UUID) (*model.ArchiveExtraction, error) {
+	extraction, err := s.archiveRepo.GetByID(ctx, extractionID)
+	if err != nil {
+		return nil, err
+	}
+	if extraction.UserID != userID {
+		return nil, ErrExtractionNotFound
+	}
+
+	return extraction, nil
+}
+
+// ProcessArchiveExtractions extracts up to limit queued archives and
+// returns the number finished. An extraction interrupted by a restart is
+// resumed, skipping the files it already extracted.
+func (s *driveService) ProcessArchiveExtractions(ctx context.Context, limit int) (int, error) {
+	extractions, err := s.archiveRepo.Claim(ctx, limit, time.Now().Add(extractionLease))
+	if err != nil {
+		return 0, err
+	}
+
+	finished := 0
+	for _, extraction := range extractions {
+		if ctx.Err() != nil {
+			break
+		}
+
+		err := fmt.Errorf("extraction was interrupted %d times", extraction.Attempts-1)
+		if extraction.Attempts <= extractionMaxAttempts {
+			err = s.extractArchive(ctx, extraction)
+		}
+		if err != nil && ctx.Err() != nil {
+			// Shutting down; the extraction is resumed when its lease ends
+			break
+		}
+
+		now := time.Now()
+		extraction.Status = model.ExtractionStatusCompleted
+		extraction.Progress = 100
+		extraction.CompletedAt = &now
+		if err != nil {
+			message := err.Error()
+			extraction.Status = model.ExtractionStatusFailed
+			extraction.Error = &message
+		}
+
+		if err := s.archiveRepo.Finish(ctx, extraction); err != nil {
+			return finished, err
+		}
+		finished++
+	}
+
+	return finished, nil
+}
+
+// extractArchive extracts an archive into the extraction's folder
+func (s *driveService) extractArchive(ctx context.Context, extraction *model.ArchiveExtraction) error {
+	archive, err := s.fileRepo.GetByID(ctx, extraction.FileID)
+	if err != nil {
+		return fmt.Errorf("%w: the archive has been deleted", ErrInvalidArchive)
+	}
+
+	// Archives may expand to MaxRatio times their size, up to
+	// MaxExtractedSize
+	budget := s.archiveConfig.MaxExtractedSize
+	if budget <= 0 {
+		budget = math.MaxInt64
+	}
+	if s.archiveConfig.MaxRatio > 0 {
+		budget = min(budget, archive.Size*int64(s.archiveConfig.MaxRatio))
+	}
+
+	x := &extractor{
+		s:          s,
+		extraction: extraction,
+		limit:      budget,
+		budget:     budget,
+		resume:     extraction.Attempts > 1,
+		folders:    map[string]uuid.UUID{"": extraction.FolderID},
+		reported:   time.Now(),
+	}
+
+	switch archiveFormatOf(archive.Name) {
+	case archiveFormatZip:
+		return x.extractZip(ctx, archive)
+	case archiveFormatTarGz, archiveFormatTar:
+		return x.extractTar(ctx, archive)
+	}
+	return fmt.Errorf("%w: unsupported archive format", ErrInvalidArchive)
+}
+
+// extractor extracts the entries of an archive one at a time
+type extractor struct {
+	s          *driveService
+	extraction *model.ArchiveExtraction
+	// limit is how many bytes may be read from the archive's entries, and
+	// budget how many more may be
+	limit  int64
+	budget int64
+	// resume is set when resuming an interrupted extraction, whose files
+	// may already exist
+	resume bool
+	// folders maps the paths of the folders created to their IDs
+	folders  map[string]uuid.UUID
+	entries  int
+	reported time.Time
+}
+
+func (x *extractor) extractZip(ctx context.Context, archive *model.File) error {
+	r, err := zip.NewReader(storage.NewReaderAt(ctx, x.s.storage, archive.StoragePath, archive.Size), archive.Size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	// The sizes an archive declares are checked up front, and the bytes
+	// extracted as they are, in case the archive lies
+	if x.s.archiveConfig.MaxEntries > 0 && len(r.File) > x.s.archiveConfig.MaxEntries {
+		return fmt.Errorf("%w: it has more than %d entries", ErrArchiveTooLarge, x.s.archiveConfig.MaxEntries)
+	}
+	var total uint64
+	for _, f := range r.File {
+		total += f.UncompressedSize64
+	}
+	if total > uint64(x.budget) {
+		return fmt.Errorf("%w: it expands to more than %d bytes", ErrArchiveTooLarge, x.budget)
+	}
+
+	var done uint64
+	for _, f := range r.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.addFolder(ctx, f.Name)
+		case mode.IsRegular():
+			err = x.addZipFile(ctx, f)
+		default:
+			// Links and special files
+			x.extraction.EntriesSkipped++
+		}
+		if err != nil {
+			return err
+		}
+
+		done += f.UncompressedSize64
+		if total > 0 {
+			x.extraction.Progress = int(done * 99 / total)
+		}
+		x.reportProgress(ctx)
+	}
+
+	return nil
+}
+
+func (x *extractor) addZipFile(ctx context.Context, f *zip.File) error {
+	reader, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidArchive, f.Name, err)
+	}
+	defer reader.Close()
+
+	return x.addFile(ctx, f.Name, reader, int64(f.UncompressedSize64))
+}
+
+func (x *extractor) extractTar(ctx context.Context, archive *model.File) error {
+	content, err := x.s.storage.DownloadFile(ctx, archive.StoragePath)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	// Progress is measured in bytes of the archive read
+	counter := &byteCounter{}
+	var reader io.Reader = io.TeeReader(content, counter)
+	if archiveFormatOf(archive.Name) == archiveFormatTarGz {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+
+		x.entries++
+		if x.s.archiveConfig.MaxEntries > 0 && x.entries > x.s.archiveConfig.MaxEntries {
+			return fmt.Errorf("%w: it has more than %d entries", ErrArchiveTooLarge, x.s.archiveConfig.MaxEntries)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = x.addFolder(ctx, header.Name)
+		case tar.TypeReg:
+			err = x.addFile(ctx, header.Name, tr, header.Size)
+		default:
+			// Links, devices and other special files
+			x.extraction.EntriesSkipped++
+		}
+		if err != nil {
+			return err
+		}
+		// Whatever of the entry was not extracted would still be
+		// decompressed to reach the next one, so it counts too
+		if err := x.discard(tr); err != nil {
+			return err
+		}
+
+		if archive.Size > 0 {
+			x.extraction.Progress = int(min(counter.n*99/archive.Size, 99))
+		}
+		x.reportProgress(ctx)
+	}
+}
+
+// addFolder creates the folder at an entry's path, and any folders above it
+func (x *extractor) addFolder(ctx context.Context, name string) error {
+	p, ok := entryPath(name)
+	if !ok {
+		x.extraction.EntriesSkipped++
+		return nil
+	}
+
+	_, err := x.folder(ctx, p)
+	return err
+}
+
+// addFile stores an entry's content as a file at its path, creating the
+// folders above it
+func (x *extractor) addFile(ctx context.Context, name string, reader io.Reader, size int64) error {
+	p, ok := entryPath(name)
+	if !ok {
+		x.extraction.EntriesSkipped++
+		return nil
+	}
+	if size > x.budget {
+		return x.errTooLarge()
+	}
+
+	dir, filename := path.Split(p)
+	folderID, err := x.folder(ctx, strings.TrimSuffix(dir, "/"))
+	if err != nil {
+		return err
+	}
+
+	tenantID, userID := x.extraction.TenantID, x.extraction.UserID
+	if x.resume {
+		existing, err := x.s.fileRepo.GetByName(ctx, tenantID, userID, &folderID, filename)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			x.extraction.EntriesExtracted++
+			x.extraction.BytesExtracted += existing.Size
+			return x.discard(reader)
+		}
+	}
+
+	if err := x.s.checkQuota(ctx, tenantID, userID, "", size); err != nil {
+		return err
+	}
+
+	limited := &budgetReader{reader: reader, remaining: x.budget}
+	contentType := detectContentType("", filename)
+	blob, err := x.s.storeBlob(ctx, tenantID, limited, size, contentType)
+	if limited.exceeded {
+		return x.errTooLarge()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", p, err)
+	}
+
+	if _, err := x.s.createFile(ctx, tenantID, userID, uuid.New(), &folderID, filename, contentType, blob); err != nil {
+		return err
+	}
+
+	x.extraction.EntriesExtracted++
+	x.extraction.BytesExtracted += blob.Size
+	x.budget -= blob.Size
+	return nil
+}
+
+// discard reads and drops the rest of an entry, charging it to the budget
+// like extracted content, so that skipped entries cannot hide a
+// decompression bomb
+func (x *extractor) discard(reader io.Reader) error {
+	limited := &budgetReader{reader: reader, remaining: x.budget}
+	n, err := io.Copy(io.Discard, limited)
+	x.budget -= n
+	if limited.exceeded {
+		return x.errTooLarge()
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	return nil
+}
+
+func (x *extractor) errTooLarge() error {
+	return fmt.Errorf("%w: it expands to more than the %d bytes allowed", ErrArchiveTooLarge, x.limit)
+}
+
+// folder returns the ID of the folder at a path in the extraction's folder,
+// creating it and the folders above it if need be
+func (x *extractor) folder(ctx context.Context, p string) (uuid.UUID, error) {
+	if id, ok := x.folders[p]; ok {
+		return id, nil
+	}
+
+	dir, name := path.Split(p)
+	parentID, err := x.folder(ctx, strings.TrimSuffix(dir, "/"))
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	tenantID, userID := x.extraction.TenantID, x.extraction.UserID
+	if x.resume {
+		existing, err := x.s.folderRepo.GetByName(ctx, tenantID, userID, &parentID, name)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if existing != nil {
+			x.folders[p] = existing.ID
+			return existing.ID, nil
+		}
+	}
+
+	folder, err := x.s.CreateFolder(ctx, tenantID, userID, &model.CreateFolderRequest{Name: name, ParentID: &parentID})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	x.folders[p] = folder.ID
+	x.extraction.EntriesExtracted++
+	return folder.ID, nil
+}
+
+// reportProgress records the extraction's progress, at most every
+// extractionProgressInterval, extending its lease
+func (x *extractor) reportProgress(ctx context.Context) {
+	if time.Since(x.reported) < extractionProgressInterval {
+		return
+	}
+	x.reported = time.Now()
+	_ = x.s.archiveRepo.UpdateProgress(ctx, x.extraction, time.Now().Add(extractionLease))
+}
+
+// budgetReader reads up to remaining bytes, failing if there are more
+type budgetReader struct {
+	reader    io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		// Only fail if the reader really has more
+		var b [1]byte
+		if n, _ := r.reader.Read(b[:]); n > 0 {
+			r.exceeded = true
+			return 0, ErrArchiveTooLarge
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+// entryPath returns the path of an archive entry relative to the folder it
+// is extracted into, or false if the entry is unsafe to extract: absolute
+// paths and paths with ".." elements, which could leave the folder, and
+// names the drive cannot store
+func entryPath(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", false
+	}
+
+	var elements []string
+	for _, element := range strings.Split(name, "/") {
+		switch element {
+		case "", ".":
+			continue
+		case "..":
+			return "", false
+		}
+		if len(element) > 255 {
+			return "", false
+		}
+		elements = append(elements, element)
+	}
+	if len(elements) == 0 {
+		return "", false
+	}
+
+	return strings.Join(elements, "/"), true
+}
+
+// uniqueFolderName returns name, or name numbered as in "photos (2)" if the
+// folder already has a folder with that name
+func (s *driveService) uniqueFolderName(ctx context.Context, tenantID, userID uuid.UUID, parentID *uuid.UUID, name string) (string, error) {
+	candidate := name
+	for n := 2; ; n++ {
+		existing, err := s.folderRepo.GetByName(ctx, tenantID, userID, parentID, candidate)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s (%d)", name, n)
+	}
+}
+
+func archiveFormatOf(filename string) archiveFormat {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveFormatZip
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveFormatTarGz
+	case strings.HasSuffix(name, ".tar"):
+		return archiveFormatTar
+	}
+	return archiveFormatNone
+}
+
+// archiveBaseName returns the name of an archive without its extension
+func archiveBaseName(filename string) string {
+	lower := strings.ToLower(filename)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) && len(filename) > len(ext) {
+			return filename[:len(filename)-len(ext)]
+		}
+	}
+	return filename
+}
@@ -0,0 +1,394 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/config"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/storage"
+)
+
+// tarGz returns a gzipped tar archive of entries of size zero bytes each,
+// which compress to almost nothing
+func tarGz(t *testing.T, entries []tar.Header) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, header := range entries {
+		header := header
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(make([]byte, header.Size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractTarChargesSkippedEntries(t *testing.T) {
+	ctx := context.Background()
+	const entrySize = 1 << 20
+
+	tests := []struct {
+		name    string
+		entries []tar.Header
+		limit   int64
+		wantErr error
+	}{
+		{
+			name: "unsafe paths",
+			entries: []tar.Header{
+				{Name: "../escape.bin", Typeflag: tar.TypeReg, Size: entrySize, Mode: 0o644},
+				{Name: "/etc/escape.bin", Typeflag: tar.TypeReg, Size: entrySize, Mode: 0o644},
+			},
+			limit:   entrySize,
+			wantErr: ErrArchiveTooLarge,
+		},
+		{
+			name: "unknown entry types",
+			entries: []tar.Header{
+				{Name: "a.bin", Typeflag: 'Q', Size: entrySize, Mode: 0o644},
+				{Name: "b.bin", Typeflag: 'Q', Size: entrySize, Mode: 0o644},
+			},
+			limit:   entrySize,
+			wantErr: ErrArchiveTooLarge,
+		},
+		{
+			name: "within the limit",
+			entries: []tar.Header{
+				{Name: "../escape.bin", Typeflag: tar.TypeReg, Size: entrySize, Mode: 0o644},
+				{Name: "a.bin", Typeflag: 'Q', Size: entrySize, Mode: 0o644},
+			},
+			limit: 2 * entrySize,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			s := newTestService(store)
+			fileStorage := storage.NewMemoryStorage()
+			s.storage = fileStorage
+			s.archiveConfig = config.ArchiveConfig{MaxExtractedSize: tt.limit}
+
+			content := tarGz(t, tt.entries)
+			archive := store.addFile(uuid.New(), nil)
+			archive.Name, archive.Size, archive.StoragePath = "bomb.tar.gz", int64(len(content)), "archives/bomb.tar.gz"
+			if err := fileStorage.UploadObject(ctx, archive.StoragePath, bytes.NewReader(content), archive.Size, "application/gzip"); err != nil {
+				t.Fatal(err)
+			}
+
+			extraction := &model.ArchiveExtraction{ID: uuid.New(), FileID: archive.ID, UserID: archive.OwnerID, FolderID: uuid.New()}
+			err := s.extractArchive(ctx, extraction)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if extraction.EntriesSkipped != len(tt.entries) || extraction.EntriesExtracted != 0 {
+				t.Errorf("skipped %d and extracted %d entries, want %d skipped", extraction.EntriesSkipped, extraction.EntriesExtracted, len(tt.entries))
+			}
+		})
+	}
+}
+
+func TestExtractTarEnforcesRatioOnSkippedEntries(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	fileStorage := storage.NewMemoryStorage()
+	s.storage = fileStorage
+	s.archiveConfig = config.ArchiveConfig{MaxRatio: 10}
+
+	// A few kilobytes of archive that expand to 64 MiB
+	content := tarGz(t, []tar.Header{{Name: "../zeros.bin", Typeflag: tar.TypeReg, Size: 64 << 20, Mode: 0o644}})
+	archive := store.addFile(uuid.New(), nil)
+	archive.Name, archive.Size, archive.StoragePath = "bomb.tgz", int64(len(content)), "archives/bomb.tgz"
+	if err := fileStorage.UploadObject(ctx, archive.StoragePath, bytes.NewReader(content), archive.Size, "application/gzip"); err != nil {
+		t.Fatal(err)
+	}
+
+	extraction := &model.ArchiveExtraction{ID: uuid.New(), FileID: archive.ID, UserID: archive.OwnerID, FolderID: uuid.New()}
+	if err := s.extractArchive(ctx, extraction); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Errorf("got %v, want %v", err, ErrArchiveTooLarge)
+	}
+}
+
+// zipEntry is an entry of a zip archive built by zipArchive
+type zipEntry struct {
+	name    string
+	content string
+	mode    os.FileMode
+}
+
+func zipArchive(t *testing.T, entries []zipEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		if entry.mode != 0 {
+			header.SetMode(entry.mode)
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, entry.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newExtractionTest returns a service that can store extracted files, an
+// archive of content owned by a user and an extraction of it into a new
+// folder of theirs
+func newExtractionTest(t *testing.T, name string, content []byte) (*driveService, *fakeStore, *model.ArchiveExtraction) {
+	t.Helper()
+
+	store := newFakeStore()
+	s := newTestService(store)
+	s.storage = storage.NewMemoryStorage()
+	s.blobRepo = newFakeBlobRepo()
+	s.previewRepo = newFakePreviewRepo()
+	s.quotaRepo = newFakeQuotaRepo()
+
+	userID := uuid.New()
+	archive := store.addFile(userID, nil)
+	archive.Name, archive.Size, archive.StoragePath = name, int64(len(content)), "archives/"+name
+	if err := s.storage.UploadObject(context.Background(), archive.StoragePath, bytes.NewReader(content), archive.Size, "application/zip"); err != nil {
+		t.Fatal(err)
+	}
+	folder := store.addFolder(userID, nil)
+
+	extraction := &model.ArchiveExtraction{ID: uuid.New(), FileID: archive.ID, UserID: userID, FolderID: folder.ID}
+	return s, store, extraction
+}
+
+// extractedPaths returns the paths of the files under a folder, with their
+// content
+func extractedPaths(t *testing.T, s *driveService, store *fakeStore, folderID uuid.UUID) map[string]string {
+	t.Helper()
+
+	paths := make(map[string]string)
+	for _, file := range store.files {
+		if file.FolderID == nil {
+			continue
+		}
+		p := file.Name
+		inside := false
+		for id := file.FolderID; id != nil; id = store.folders[*id].ParentID {
+			if *id == folderID {
+				inside = true
+				break
+			}
+			p = store.folders[*id].Name + "/" + p
+		}
+		if !inside {
+			continue
+		}
+
+		reader, err := s.storage.DownloadFile(context.Background(), file.StoragePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths[p] = string(content)
+	}
+	return paths
+}
+
+func TestExtractZip(t *testing.T) {
+	ctx := context.Background()
+	content := zipArchive(t, []zipEntry{
+		{name: "docs/", mode: os.ModeDir | 0o755},
+		{name: "docs/readme.txt", content: "read me"},
+		{name: "docs/nested/deep.txt", content: "deep"},
+		{name: "./top.txt", content: "top"},
+		{name: "../escape.txt", content: "escaped"},
+		{name: "docs/../../escape.txt", content: "escaped"},
+		{name: "/etc/passwd", content: "escaped"},
+		{name: "C:\\Windows\\escape.txt", content: "escaped"},
+		{name: "link", content: "/etc/passwd", mode: os.ModeSymlink | 0o777},
+	})
+	s, store, extraction := newExtractionTest(t, "bundle.zip", content)
+
+	if err := s.extractArchive(ctx, extraction); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"docs/readme.txt":      "read me",
+		"docs/nested/deep.txt": "deep",
+		"top.txt":              "top",
+	}
+	got := extractedPaths(t, s, store, extraction.FolderID)
+	if len(got) != len(want) {
+		t.Errorf("extracted %v, want %v", got, want)
+	}
+	for p, content := range want {
+		if got[p] != content {
+			t.Errorf("%s: got %q, want %q", p, got[p], content)
+		}
+	}
+	if extraction.EntriesSkipped != 5 {
+		t.Errorf("skipped %d entries, want the 4 unsafe paths and the link", extraction.EntriesSkipped)
+	}
+	for _, file := range store.files {
+		if strings.Contains(file.Name, "escape") || file.Name == "passwd" {
+			t.Errorf("unsafe entry extracted as %q", file.Name)
+		}
+	}
+}
+
+func TestExtractZipChecksDeclaredSize(t *testing.T) {
+	ctx := context.Background()
+	content := zipArchive(t, []zipEntry{
+		{name: "a.txt", content: strings.Repeat("a", 600)},
+		{name: "b.txt", content: strings.Repeat("b", 600)},
+	})
+	s, store, extraction := newExtractionTest(t, "big.zip", content)
+	s.archiveConfig = config.ArchiveConfig{MaxExtractedSize: 1000}
+
+	if err := s.extractArchive(ctx, extraction); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Fatalf("got %v, want %v", err, ErrArchiveTooLarge)
+	}
+	// Nothing is extracted from an archive that declares too much
+	if got := extractedPaths(t, s, store, extraction.FolderID); len(got) != 0 {
+		t.Errorf("extracted %v", got)
+	}
+}
+
+func TestExtractZipEntryLimit(t *testing.T) {
+	ctx := context.Background()
+	content := zipArchive(t, []zipEntry{{name: "a.txt"}, {name: "b.txt"}, {name: "c.txt"}})
+	s, _, extraction := newExtractionTest(t, "many.zip", content)
+	s.archiveConfig = config.ArchiveConfig{MaxEntries: 2}
+
+	if err := s.extractArchive(ctx, extraction); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Errorf("got %v, want %v", err, ErrArchiveTooLarge)
+	}
+}
+
+func TestEntryPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"a.txt", "a.txt", true},
+		{"dir/./sub//a.txt", "dir/sub/a.txt", true},
+		{"dir\\a.txt", "dir/a.txt", true},
+		{"../a.txt", "", false},
+		{"dir/../../a.txt", "", false},
+		{"dir\\..\\..\\a.txt", "", false},
+		{"/a.txt", "", false},
+		{"C:/a.txt", "", false},
+		{"./", "", false},
+		{strings.Repeat("x", 256), "", false},
+	}
+	for _, tt := range tests {
+		got, ok := entryPath(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("entryPath(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDownloadArchive(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	s.storage = storage.NewMemoryStorage()
+	alice, bob := uuid.New(), uuid.New()
+
+	addFile := func(folderID *uuid.UUID, name, content string) *model.File {
+		file := store.addFile(alice, folderID)
+		file.Name = name
+		store.addVersion(file.ID, content)
+		if err := s.storage.UploadObject(ctx, file.StoragePath, strings.NewReader(content), file.Size, "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	first := addFile(nil, "report.txt", "first")
+	second := addFile(nil, "report.txt", "second")
+	folder := store.addFolder(alice, nil)
+	folder.Name = "photos"
+	addFile(&folder.ID, "cat.jpg", "meow")
+
+	if _, _, err := s.DownloadArchive(ctx, bob, []uuid.UUID{first.ID}, nil); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("download by a stranger: got %v, want %v", err, ErrPermissionDenied)
+	}
+	if _, _, err := s.DownloadArchive(ctx, alice, nil, nil); !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("empty selection: got %v, want %v", err, ErrInvalidArchive)
+	}
+
+	name, write, err := s.DownloadArchive(ctx, alice, []uuid.UUID{first.ID, second.ID}, []uuid.UUID{folder.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "download.zip" {
+		t.Errorf("archive named %q, want download.zip", name)
+	}
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]string)
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+		reader, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		entries[f.Name] = string(content)
+	}
+	sort.Strings(names)
+	if want := "photos/,photos/cat.jpg,report (2).txt,report.txt"; strings.Join(names, ",") != want {
+		t.Errorf("archive entries = %v, want %s", names, want)
+	}
+	if entries["report.txt"] != "first" || entries["report (2).txt"] != "second" || entries["photos/cat.jpg"] != "meow" {
+		t.Errorf("archive content = %v", entries)
+	}
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/storage"
+)
+
+const blobCollectBatchSize = 100
+
+// storeBlob stores content under its SHA-256 and returns its blob. Content
+// the tenant already has is not stored again. Seekable content is hashed
+// before uploading, so duplicates are never uploaded; other content is
+// hashed while it is uploaded to a temporary path. Uploading content of a
+// blob the scrubber found corrupt or missing repairs it.
+func (s *driveService) storeBlob(ctx context.Context, tenantID uuid.UUID, reader io.Reader, size int64, contentType string) (*model.Blob, error) {
+	return s.storeBlobWithin(ctx, tenantID, reader, size, contentType, nil)
+}
+
+// storeBlobWithin stores content like storeBlob, once check, such as a
+// quota check, accepts its storage path and size. Seekable content is
+// checked once it is hashed; other content is checked with the declared
+// size and an empty path, as it is unknown until it has been uploaded.
+func (s *driveService) storeBlobWithin(ctx context.Context, tenantID uuid.UUID, reader io.Reader, size int64, contentType string, check func(storagePath string, size int64) error) (*model.Blob, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		h := sha256.New()
+		n, err := io.Copy(h, seeker)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		checksum := hex.EncodeToString(h.Sum(nil))
+		blobPath := storage.GetBlobPath(tenantID, checksum)
+
+		if check != nil {
+			if err := check(blobPath, n); err != nil {
+				return nil, err
+			}
+		}
+
+		if blob, err := s.blobRepo.Touch(ctx, tenantID, checksum); err == nil && blob.Status == model.BlobStatusOK {
+			return blob, nil
+		}
+
+		if err := s.storage.UploadObject(ctx, blobPath, seeker, n, contentType); err != nil {
+			return nil, err
+		}
+		return s.createBlob(ctx, tenantID, checksum, n, blobPath)
+	}
+
+	if check != nil && size > 0 {
+		if err := check("", size); err != nil {
+			return nil, err
+		}
+	}
+
+	h := sha256.New()
+	counter := &byteCounter{}
+	tempPath := storage.GetTempPath(tenantID, uuid.New())
+	if err := s.storage.UploadObject(ctx, tempPath, io.TeeReader(reader, io.MultiWriter(h, counter)), size, contentType); err != nil {
+		return nil, err
+	}
+
+	return s.promoteBlob(ctx, tenantID, tempPath, hex.EncodeToString(h.Sum(nil)), counter.n)
+}
+
+// promoteBlob turns content uploaded to tempPath with the given checksum
+// into a blob, moving it to its content address unless the tenant already
+// has it. The temporary object is deleted.
+func (s *driveService) promoteBlob(ctx context.Context, tenantID uuid.UUID, tempPath, checksum string, size int64) (*model.Blob, error) {
+	if blob, err := s.blobRepo.Touch(ctx, tenantID, checksum); err == nil && blob.Status == model.BlobStatusOK {
+		_ = s.storage.DeleteFile(ctx, tempPath)
+		return blob, nil
+	}
+
+	blobPath := storage.GetBlobPath(tenantID, checksum)
+	if err := s.storage.CopyFile(ctx, tempPath, blobPath); err != nil {
+		return nil, err
+	}
+
+	blob, err := s.createBlob(ctx, tenantID, checksum, size, blobPath)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = s.storage.DeleteFile(ctx, tempPath)
+	return blob, nil
+}
+
+func (s *driveService) createBlob(ctx context.Context, tenantID uuid.UUID, checksum string, size int64, blobPath string) (*model.Blob, error) {
+	now := time.Now()
+	blob := &model.Blob{
+		TenantID:    tenantID,
+		Checksum:    checksum,
+		StoragePath: blobPath,
+		Size:        size,
+		Status:      model.BlobStatusOK,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if err := s.blobRepo.Create(ctx, blob); err != nil {
+		return nil, err
+	}
+
+	return blob, nil
+}
+
+// ScrubBlobs re-reads up to limit blobs, those verified longest ago first,
+// and checks their content against their checksum and size. Blobs that do
+// not match are marked corrupt, blobs that are gone missing.
+func (s *driveService) ScrubBlobs(ctx context.Context, limit int) (*model.ScrubReport, error) {
+	blobs, err := s.blobRepo.GetForScrub(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &model.ScrubReport{}
+	for _, blob := range blobs {
+		if ctx.Err() != nil {
+			break
+		}
+
+		status, err := s.verifyBlob(ctx, blob)
+		if err != nil {
+			// Storage may be briefly unavailable; check the blob next run
+			continue
+		}
+
+		report.Checked++
+		switch status {
+		case model.BlobStatusCorrupt:
+			report.Corrupt = append(report.Corrupt, blob)
+		case model.BlobStatusMissing:
+			report.Missing = append(report.Missing, blob)
+		}
+
+		blob.Status = status
+		if err := s.blobRepo.SetStatus(ctx, blob.StoragePath, status, time.Now()); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (s *driveService) verifyBlob(ctx context.Context, blob *model.Blob) (model.BlobStatus, error) {
+	reader, err := s.storage.DownloadFile(ctx, blob.StoragePath)
+	if err != nil {
+		exists, existsErr := s.storage.FileExists(ctx, blob.StoragePath)
+		if existsErr != nil || exists {
+			return "", err
+		}
+		return model.BlobStatusMissing, nil
+	}
+	defer reader.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, reader)
+	if err != nil {
+		return "", err
+	}
+
+	if n != blob.Size || hex.EncodeToString(h.Sum(nil)) != blob.Checksum {
+		return model.BlobStatusCorrupt, nil
+	}
+	return model.BlobStatusOK, nil
+}
+
+// CollectUnreferencedBlobs deletes blobs no file or version has referenced
+// for gracePeriod. The grace period covers the time between storing a blob
+// and creating the file that references it. Returns the number deleted.
+func (s *driveService) CollectUnreferencedBlobs(ctx context.Context, gracePeriod time.Duration) (int, error) {
+	collected := 0
+	for {
+		blobs, err := s.blobRepo.DeleteUnreferenced(ctx, time.Now().Add(-gracePeriod), blobCollectBatchSize)
+		if err != nil {
+			return collected, err
+		}
+
+		for _, blob := range blobs {
+			s.deletePreview(ctx, blob)
+			_ = s.storage.DeleteFile(ctx, blob.StoragePath)
+			collected++
+		}
+
+		if len(blobs) < blobCollectBatchSize {
+			return collected, nil
+		}
+	}
+}
+
+// byteCounter counts the bytes written to it
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
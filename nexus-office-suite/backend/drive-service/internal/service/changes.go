@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/repository"
+)
+
+// pruneBatchSize is how many changes are deleted per query when pruning
+const pruneBatchSize = 1000
+
+var (
+	ErrInvalidCursor      = errors.New("invalid cursor")
+	ErrCursorExpired      = errors.New("cursor has expired")
+	ErrPreconditionFailed = errors.New("file has changed")
+	ErrConflict           = errors.New("file was changed by another request")
+)
+
+// GetChanges returns a page of a user's changes after cursor. Without a
+// cursor, it returns the user's latest cursor and no changes, so that a
+// client lists the drive and then follows changes from there.
+func (s *driveService) GetChanges(ctx context.Context, userID uuid.UUID, cursor string, limit int) (*model.ChangePage, error) {
+	position, err := s.changeRepo.GetCursor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if cursor == "" {
+		return &model.ChangePage{Changes: []*model.Change{}, Cursor: formatCursor(position.LastSeq)}, nil
+	}
+
+	seq, err := parseCursor(cursor, position)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = model.DefaultChangeLimit
+	}
+	if limit > model.MaxChangeLimit {
+		limit = model.MaxChangeLimit
+	}
+
+	changes, err := s.changeRepo.List(ctx, userID, seq, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &model.ChangePage{Changes: changes, HasMore: len(changes) > limit}
+	if page.HasMore {
+		page.Changes = changes[:limit]
+	}
+	if len(page.Changes) > 0 {
+		seq = page.Changes[len(page.Changes)-1].Seq
+	}
+	page.Cursor = formatCursor(seq)
+
+	if err := s.loadChangedResources(ctx, page.Changes); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// WaitForChanges waits until a user has changes after cursor, for at most
+// timeout or the configured maximum, and reports whether they do. Changes
+// made through this instance end the wait at once; those made through
+// other instances are noticed by polling.
+func (s *driveService) WaitForChanges(ctx context.Context, userID uuid.UUID, cursor string, timeout time.Duration) (bool, error) {
+	if cursor == "" {
+		return false, fmt.Errorf("%w: cursor is required", ErrInvalidCursor)
+	}
+	if timeout <= 0 || timeout > s.changeConfig.MaxWait {
+		timeout = s.changeConfig.MaxWait
+	}
+
+	// Subscribe before checking, so that a change recorded in between is
+	// not missed
+	notified, unsubscribe := s.changeNotifier.subscribe(userID)
+	defer unsubscribe()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(s.changeConfig.PollInterval)
+	defer poll.Stop()
+
+	for {
+		position, err := s.changeRepo.GetCursor(ctx, userID)
+		if err != nil {
+			return false, err
+		}
+		seq, err := parseCursor(cursor, position)
+		if err != nil {
+			return false, err
+		}
+		if position.LastSeq > seq {
+			return true, nil
+		}
+
+		select {
+		case <-notified:
+		case <-poll.C:
+		case <-deadline.C:
+			return false, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// PruneChanges deletes changes older than the retention period and returns
+// how many were deleted
+func (s *driveService) PruneChanges(ctx context.Context) (int, error) {
+	before := time.Now().Add(-s.changeConfig.Retention)
+	pruned := 0
+	for {
+		deleted, err := s.changeRepo.Prune(ctx, before, pruneBatchSize)
+		if err != nil {
+			return pruned, err
+		}
+		pruned += deleted
+
+		if deleted < pruneBatchSize {
+			return pruned, nil
+		}
+	}
+}
+
+// loadChangedResources sets the current state of the resources of changes
+// the user can still view, and marks the others removed
+func (s *driveService) loadChangedResources(ctx context.Context, changes []*model.Change) error {
+	var fileIDs, folderIDs []uuid.UUID
+	for _, change := range changes {
+		if !change.Visible {
+			continue
+		}
+		if change.ResourceType == model.ResourceTypeFile {
+			fileIDs = append(fileIDs, change.ResourceID)
+		} else {
+			folderIDs = append(folderIDs, change.ResourceID)
+		}
+	}
+
+	files, err := s.fileRepo.GetByIDs(ctx, fileIDs)
+	if err != nil {
+		return err
+	}
+	folders, err := s.folderRepo.GetByIDs(ctx, folderIDs)
+	if err != nil {
+		return err
+	}
+	filesByID := make(map[uuid.UUID]*model.File, len(files))
+	for _, file := range files {
+		filesByID[file.ID] = file
+	}
+	foldersByID := make(map[uuid.UUID]*model.Folder, len(folders))
+	for _, folder := range folders {
+		foldersByID[folder.ID] = folder
+	}
+
+	for _, change := range changes {
+		if change.Visible {
+			change.File = filesByID[change.ResourceID]
+			change.Folder = foldersByID[change.ResourceID]
+		}
+		change.Removed = change.File == nil && change.Folder == nil
+	}
+
+	return nil
+}
+
+// recordChange adds a change to the journals of the users who can view the
+// resource and of before, the users who could view it before the change,
+// and wakes their long polls
+func (s *driveService) recordChange(ctx context.Context, tenantID, actorID uuid.UUID, resourceType model.ResourceType, resourceID uuid.UUID, changeType model.ChangeType, before []uuid.UUID) {
+	change := &model.Change{
+		TenantID:     tenantID,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		ChangeType:   changeType,
+		ActorID:      actorID,
+		CreatedAt:    time.Now(),
+	}
+
+	recipients, err := s.changeRepo.Record(ctx, change, before)
+	if err != nil {
+		return
+	}
+	s.changeNotifier.notify(recipients)
+}
+
+// audience returns the users who can view a resource, to record a change
+// that may take their access away for them too
+func (s *driveService) audience(ctx context.Context, resourceType model.ResourceType, resourceID uuid.UUID) []uuid.UUID {
+	userIDs, _ := s.changeRepo.GetAudience(ctx, resourceType, resourceID)
+	return userIDs
+}
+
+// permissionPrincipals returns the users a permission applies to: its user
+// or the members of its group
+func (s *driveService) permissionPrincipals(ctx context.Context, permission *model.Permission) []uuid.UUID {
+	if permission.UserID != nil {
+		return []uuid.UUID{*permission.UserID}
+	}
+	if permission.GroupID == nil {
+		return nil
+	}
+
+	members, _ := s.groupRepo.GetMembers(ctx, []uuid.UUID{*permission.GroupID})
+	userIDs := make([]uuid.UUID, len(members))
+	for i, member := range members {
+		userIDs[i] = member.UserID
+	}
+	return userIDs
+}
+
+// fileChangeType classifies a change of a file's or folder's name and
+// parent folder
+func fileChangeType(oldName string, oldParentID *uuid.UUID, name string, parentID *uuid.UUID) model.ChangeType {
+	switch {
+	case !sameFolder(oldParentID, parentID):
+		return model.ChangeMoved
+	case oldName != name:
+		return model.ChangeRenamed
+	default:
+		return model.ChangeUpdated
+	}
+}
+
+func sameFolder(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// checkIfMatch checks an If-Match header against a file's ETag. An empty
+// header matches any file.
+func checkIfMatch(file *model.File, ifMatch string) error {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "" || ifMatch == "*" {
+		return nil
+	}
+
+	etag := file.ETag()
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		// Revisions are exact, so weak tags compare the same
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: the current ETag is %s", ErrPreconditionFailed, etag)
+}
+
+// updateError translates the repository's error for a file changed since
+// it was read: the precondition failed if the update was conditional, and
+// otherwise the update conflicted with another one
+func updateError(err error, ifMatch string) error {
+	if !errors.Is(err, repository.ErrConflict) {
+		return err
+	}
+	if ifMatch != "" {
+		return ErrPreconditionFailed
+	}
+	return ErrConflict
+}
+
+func formatCursor(seq int64) string {
+	return strconv.FormatInt(seq, 10)
+}
+
+// parseCursor returns the sequence number of a cursor, which must not be
+// ahead of the user's latest change or behind the changes pruned
+func parseCursor(cursor string, position *model.ChangeCursor) (int64, error) {
+	seq, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || seq < 0 || seq > position.LastSeq {
+		return 0, ErrInvalidCursor
+	}
+	if seq < position.PrunedSeq {
+		return 0, ErrCursorExpired
+	}
+	return seq, nil
+}
+
+// changeNotifier wakes the long polls waiting for users' changes
+type changeNotifier struct {
+	mu      sync.Mutex
+	waiters map[uuid.UUID]map[chan struct{}]bool
+}
+
+func newChangeNotifier() *changeNotifier {
+	return &changeNotifier{waiters: make(map[uuid.UUID]map[chan struct{}]bool)}
+}
+
+// subscribe returns a channel that receives when changes are recorded for
+// a user, and a function to stop receiving
+func (n *changeNotifier) subscribe(userID uuid.UUID) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	n.mu.Lock()
+	if n.waiters[userID] == nil {
+		n.waiters[userID] = make(map[chan struct{}]bool)
+	}
+	n.waiters[userID][ch] = true
+	n.mu.Unlock()
+
+	return ch, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.waiters[userID], ch)
+		if len(n.waiters[userID]) == 0 {
+			delete(n.waiters, userID)
+		}
+	}
+}
+
+func (n *changeNotifier) notify(userIDs []uuid.UUID) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, userID := range userIDs {
+		for ch := range n.waiters[userID] {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/config"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+func TestChangeFeed(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	tenantID := uuid.New()
+	alice, bob := uuid.New(), uuid.New()
+	store.users[[2]uuid.UUID{tenantID, bob}] = true
+
+	file := store.addFile(alice, nil)
+	file.TenantID = tenantID
+
+	// Without a cursor a client gets the position to follow changes from
+	page, err := s.GetChanges(ctx, bob, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 0 || page.Cursor != "0" {
+		t.Fatalf("first page = %+v, want cursor 0 and no changes", page)
+	}
+	cursor := page.Cursor
+
+	permission, err := s.GrantPermission(ctx, tenantID, alice, &model.CreatePermissionRequest{
+		ResourceID:   file.ID,
+		ResourceType: model.ResourceTypeFile,
+		UserID:       &bob,
+		Role:         model.PermissionViewer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "renamed.txt"
+	if _, err := s.UpdateFile(ctx, file.ID, alice, &model.UpdateFileRequest{Name: &name}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages follow each other without gaps
+	var changes []*model.Change
+	for {
+		page, err := s.GetChanges(ctx, bob, cursor, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, page.Changes...)
+		cursor = page.Cursor
+		if !page.HasMore {
+			break
+		}
+	}
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes, want the share and the rename", len(changes))
+	}
+	renamed := changes[1]
+	if renamed.ChangeType != model.ChangeRenamed || renamed.ActorID != alice || renamed.File == nil || renamed.File.Name != name {
+		t.Errorf("rename change = %+v, want the file renamed by alice", renamed)
+	}
+
+	// Losing access is reported, without the file's current state
+	if err := s.RevokePermission(ctx, tenantID, permission.ID, alice); err != nil {
+		t.Fatal(err)
+	}
+	page, err = s.GetChanges(ctx, bob, cursor, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 1 || !page.Changes[0].Removed || page.Changes[0].File != nil {
+		t.Fatalf("changes after revoking = %+v, want the file removed", page.Changes)
+	}
+
+	// Nothing more has happened
+	page, err = s.GetChanges(ctx, bob, page.Cursor, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 0 || page.HasMore {
+		t.Errorf("caught-up page = %+v, want no changes", page)
+	}
+}
+
+func TestGetChangesCursors(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	s.changeConfig = config.ChangeConfig{Retention: time.Hour}
+	alice := uuid.New()
+	file := store.addFile(alice, nil)
+
+	s.recordChange(ctx, uuid.New(), alice, model.ResourceTypeFile, file.ID, model.ChangeCreated, nil)
+	s.recordChange(ctx, uuid.New(), alice, model.ResourceTypeFile, file.ID, model.ChangeUpdated, nil)
+
+	for _, cursor := range []string{"latest", "-1", "3"} {
+		if _, err := s.GetChanges(ctx, alice, cursor, 0); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q: got %v, want %v", cursor, err, ErrInvalidCursor)
+		}
+	}
+
+	// Once changes after a cursor have been pruned the client must resync
+	changes := s.changeRepo.(*fakeChangeRepo).changes[alice]
+	changes[0].CreatedAt = time.Now().Add(-2 * time.Hour)
+	if pruned, err := s.PruneChanges(ctx); err != nil || pruned != 1 {
+		t.Fatalf("PruneChanges = %d, %v, want 1 change pruned", pruned, err)
+	}
+	if _, err := s.GetChanges(ctx, alice, "0", 0); !errors.Is(err, ErrCursorExpired) {
+		t.Errorf("cursor before the pruned change: got %v, want %v", err, ErrCursorExpired)
+	}
+	page, err := s.GetChanges(ctx, alice, "1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 1 || page.Changes[0].ChangeType != model.ChangeUpdated {
+		t.Errorf("changes after the pruned one = %+v, want the update", page.Changes)
+	}
+}
+
+func TestWaitForChanges(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	s.changeConfig = config.ChangeConfig{MaxWait: 5 * time.Second, PollInterval: time.Hour}
+	alice, bob := uuid.New(), uuid.New()
+	file := store.addFile(alice, nil)
+
+	if _, err := s.WaitForChanges(ctx, alice, "", time.Second); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("wait without a cursor: got %v, want %v", err, ErrInvalidCursor)
+	}
+
+	// A change recorded while waiting ends the wait at once
+	done := make(chan bool)
+	go func() {
+		changed, err := s.WaitForChanges(ctx, alice, "0", 0)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- changed
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.recordChange(ctx, uuid.New(), alice, model.ResourceTypeFile, file.ID, model.ChangeUpdated, nil)
+	select {
+	case changed := <-done:
+		if !changed {
+			t.Error("wait ended without changes")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait was not woken by the change")
+	}
+
+	// Changes already there return without waiting
+	if changed, err := s.WaitForChanges(ctx, alice, "0", 0); err != nil || !changed {
+		t.Errorf("wait behind the latest change = %v, %v, want changes", changed, err)
+	}
+
+	// Others' changes do not end the wait
+	start := time.Now()
+	changed, err := s.WaitForChanges(ctx, bob, "0", 50*time.Millisecond)
+	if err != nil || changed {
+		t.Errorf("wait without changes = %v, %v, want no changes", changed, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("wait returned after %v, before its timeout", elapsed)
+	}
+}
+
+func TestUpdateFileIfMatch(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	alice := uuid.New()
+	file := store.addFile(alice, nil)
+	etag := file.ETag()
+
+	first, second := "first.txt", "second.txt"
+	updated, err := s.UpdateFile(ctx, file.ID, alice, &model.UpdateFileRequest{Name: &first}, etag)
+	if err != nil {
+		t.Fatalf("update with the current ETag: %v", err)
+	}
+	if updated.ETag() == etag {
+		t.Error("ETag did not change with the update")
+	}
+
+	// A client that has not seen the first update must not overwrite it
+	if _, err := s.UpdateFile(ctx, file.ID, alice, &model.UpdateFileRequest{Name: &second}, etag); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("update with a stale ETag: got %v, want %v", err, ErrPreconditionFailed)
+	}
+	if _, err := s.UpdateFile(ctx, file.ID, alice, &model.UpdateFileRequest{Name: &second}, "W/"+updated.ETag()); err != nil {
+		t.Errorf("update with the current weak ETag: %v", err)
+	}
+	if store.files[file.ID].Name != second {
+		t.Errorf("file is named %q, want %q", store.files[file.ID].Name, second)
+	}
+}
@@ -12,7 +12,9 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/nexus/drive-service/config"
 	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/notification"
 	"github.com/nexus/drive-service/internal/repository"
 	"github.com/nexus/drive-service/internal/storage"
 	"golang.org/x/crypto/bcrypt"
@@ -23,12 +25,12 @@ type DriveService interface {
 	UploadFile(ctx context.Context, tenantID, userID uuid.UUID, folderID *uuid.UUID, filename string, reader io.Reader, size int64, contentType string) (*model.File, error)
 	GetFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) (*model.File, error)
 	DownloadFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) (io.ReadCloser, *model.File, error)
-	UpdateFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, req *model.UpdateFileRequest) (*model.File, error)
+	UpdateFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, req *model.UpdateFileRequest, ifMatch string) (*model.File, error)
 	DeleteFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) error
-	MoveFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, targetFolderID *uuid.UUID) (*model.File, error)
+	MoveFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, targetFolderID *uuid.UUID, ifMatch string) (*model.File, error)
 	CopyFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, targetFolderID *uuid.UUID) (*model.File, error)
 	ListFiles(ctx context.Context, tenantID, userID uuid.UUID, folderID *uuid.UUID, includeShared bool) ([]*model.File, error)
-	SearchFiles(ctx context.Context, tenantID uuid.UUID, query string, fileType *model.FileType) ([]*model.File, error)
+	SearchFiles(ctx context.Context, tenantID, userID uuid.UUID, req *model.SearchRequest) ([]*model.SearchResult, error)
 	GetStarredFiles(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.File, error)
 	GetRecentFiles(ctx context.Context, tenantID, userID uuid.UUID, limit int) ([]*model.File, error)
 
@@ -48,64 +50,232 @@ type DriveService interface {
 
 	// Permission operations
 	GrantPermission(ctx context.Context, tenantID, userID uuid.UUID, req *model.CreatePermissionRequest) (*model.Permission, error)
-	RevokePermission(ctx context.Context, permissionID uuid.UUID, userID uuid.UUID) error
-	ListPermissions(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID) ([]*model.Permission, error)
+	RevokePermission(ctx context.Context, tenantID, permissionID, userID uuid.UUID) error
+	ListPermissions(ctx context.Context, tenantID, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID) ([]*model.Permission, error)
+	GetEffectiveAccess(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID) ([]*model.EffectiveAccess, error)
+	ExpirePermissions(ctx context.Context) (int, error)
+
+	// Group operations
+	CreateGroup(ctx context.Context, tenantID, userID uuid.UUID, req *model.CreateGroupRequest) (*model.Group, error)
+	GetGroup(ctx context.Context, tenantID, groupID uuid.UUID) (*model.Group, error)
+	ListGroups(ctx context.Context, tenantID uuid.UUID) ([]*model.Group, error)
+	DeleteGroup(ctx context.Context, tenantID, groupID, userID uuid.UUID) error
+	RecordUser(ctx context.Context, tenantID, userID uuid.UUID, email string) error
+	AddGroupMember(ctx context.Context, tenantID, groupID, userID uuid.UUID, req *model.AddGroupMemberRequest) (*model.Group, error)
+	RemoveGroupMember(ctx context.Context, tenantID, groupID, userID, memberID uuid.UUID) error
 
 	// Share link operations
 	CreateShareLink(ctx context.Context, tenantID, userID uuid.UUID, req *model.CreateShareLinkRequest) (*model.ShareLink, error)
 	GetShareLink(ctx context.Context, token string) (*model.ShareLink, error)
-	DeleteShareLink(ctx context.Context, linkID uuid.UUID, userID uuid.UUID) error
+	DeleteShareLink(ctx context.Context, tenantID, linkID, userID uuid.UUID) error
+	GetShareLinkAccessLog(ctx context.Context, tenantID, linkID, userID uuid.UUID, limit int) ([]*model.ShareLinkAccess, error)
+
+	// Public share link access
+	GetSharedResource(ctx context.Context, token string, client *model.ShareClient) (*model.SharedResource, error)
+	UnlockShareLink(ctx context.Context, token, password string, client *model.ShareClient) (*model.ShareLinkGrant, error)
+	ListSharedFolder(ctx context.Context, token string, folderID *uuid.UUID, client *model.ShareClient) (*model.SharedFolderContents, error)
+	DownloadSharedFile(ctx context.Context, token string, fileID *uuid.UUID, client *model.ShareClient) (io.ReadCloser, *model.File, error)
+	DownloadSharedFolder(ctx context.Context, token string, folderID *uuid.UUID, client *model.ShareClient) (*model.Folder, func(w io.Writer) error, error)
 
 	// Version operations
 	ListVersions(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) ([]*model.FileVersion, error)
 	RestoreVersion(ctx context.Context, fileID uuid.UUID, versionNum int, userID uuid.UUID) (*model.File, error)
+	UpdateVersion(ctx context.Context, fileID uuid.UUID, versionNum int, userID uuid.UUID, req *model.UpdateVersionRequest) (*model.FileVersion, error)
+	DiffVersions(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, from, to int) (*model.VersionDiff, error)
+	GetVersionRetention(ctx context.Context, tenantID uuid.UUID) (*model.VersionRetentionPolicy, error)
+	SetVersionRetention(ctx context.Context, tenantID, userID uuid.UUID, req *model.SetVersionRetentionRequest) (*model.VersionRetentionPolicy, error)
+	PruneVersions(ctx context.Context) (int, error)
+
+	// Resumable upload operations
+	CreateUploadSession(ctx context.Context, tenantID, userID uuid.UUID, req *model.CreateUploadSessionRequest) (*model.UploadSession, error)
+	GetUploadSession(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID) (*model.UploadSession, error)
+	UploadChunk(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID, offset int64, reader io.Reader, checksum []byte) (*model.UploadSession, error)
+	CompleteUpload(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID) (*model.File, error)
+	AbortUpload(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID) error
+	ExpireUploadSessions(ctx context.Context) (int, error)
+
+	// Preview operations
+	GetFilePreview(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) (*model.Preview, error)
+	DownloadThumbnail(ctx context.Context, preview *model.Preview, size int) (io.ReadCloser, error)
+	DownloadPreviewText(ctx context.Context, preview *model.Preview) (io.ReadCloser, error)
+	GeneratePreviews(ctx context.Context, limit int) (int, error)
+
+	// Path and content operations, used by WebDAV and sync clients
+	ResolvePath(ctx context.Context, tenantID, userID uuid.UUID, path string) (*model.FileInfo, error)
+	GetFileByName(ctx context.Context, tenantID, userID uuid.UUID, folderID *uuid.UUID, name string) (*model.File, error)
+	DownloadFileRange(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, offset, length int64) (io.ReadCloser, error)
+	UpdateFileContent(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, reader io.Reader, size int64, contentType, ifMatch string) (*model.File, error)
+	RenameFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, folderID *uuid.UUID, name string) (*model.File, error)
+	RenameFolder(ctx context.Context, folderID uuid.UUID, userID uuid.UUID, parentID *uuid.UUID, name string) (*model.Folder, error)
+
+	// App password operations
+	CreateAppPassword(ctx context.Context, tenantID, userID uuid.UUID, login string, req *model.CreateAppPasswordRequest) (*model.AppPassword, error)
+	ListAppPasswords(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.AppPassword, error)
+	DeleteAppPassword(ctx context.Context, tenantID, userID, appPasswordID uuid.UUID) error
+	AuthenticateAppPassword(ctx context.Context, username, password string) (*model.AppPassword, error)
+
+	// Change feed operations
+	GetChanges(ctx context.Context, userID uuid.UUID, cursor string, limit int) (*model.ChangePage, error)
+	WaitForChanges(ctx context.Context, userID uuid.UUID, cursor string, timeout time.Duration) (bool, error)
+	PruneChanges(ctx context.Context) (int, error)
+
+	// Quota operations
+	GetStorageUsage(ctx context.Context, tenantID, userID uuid.UUID) (*model.StorageUsage, error)
+	GetQuota(ctx context.Context, tenantID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID) (*model.Quota, error)
+	SetQuota(ctx context.Context, tenantID, userID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID, req *model.SetQuotaRequest) (*model.Quota, error)
+	ClearQuota(ctx context.Context, tenantID, userID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID) (*model.Quota, error)
+	DeliverQuotaEvents(ctx context.Context) (int, error)
+
+	// Archive operations
+	DownloadArchive(ctx context.Context, userID uuid.UUID, fileIDs, folderIDs []uuid.UUID) (string, func(w io.Writer) error, error)
+	ExtractArchive(ctx context.Context, tenantID, userID, fileID uuid.UUID, req *model.ExtractArchiveRequest) (*model.ArchiveExtraction, error)
+	GetArchiveExtraction(ctx context.Context, extractionID, userID uuid.UUID) (*model.ArchiveExtraction, error)
+	ProcessArchiveExtractions(ctx context.Context, limit int) (int, error)
+
+	// Lock and check-out operations
+	LockFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, req *model.LockFileRequest) (*model.FileLock, error)
+	ListLocks(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) ([]*model.FileLock, error)
+	UnlockFile(ctx context.Context, fileID, lockID uuid.UUID, userID uuid.UUID) error
+	CheckOut(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, req *model.CheckOutRequest) (*model.FileLock, error)
+	CheckIn(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, comment string, reader io.Reader, size int64, contentType string) (*model.File, error)
+
+	// Activity log operations
+	ListActivity(ctx context.Context, tenantID, userID uuid.UUID, query *model.ActivityQuery) (*model.ActivityPage, error)
+	ExportActivity(ctx context.Context, tenantID, userID uuid.UUID, query *model.ActivityQuery, format string) (func(w io.Writer) error, error)
+	GetActivityRetention(ctx context.Context, tenantID uuid.UUID) (*model.ActivityRetentionPolicy, error)
+	SetActivityRetention(ctx context.Context, tenantID, userID uuid.UUID, req *model.SetActivityRetentionRequest) (*model.ActivityRetentionPolicy, error)
+	PruneActivity(ctx context.Context) (int, error)
+
+	// Storage maintenance
+	ScrubBlobs(ctx context.Context, limit int) (*model.ScrubReport, error)
+	CollectUnreferencedBlobs(ctx context.Context, gracePeriod time.Duration) (int, error)
 }
 
 type driveService struct {
-	fileRepo       repository.FileRepository
-	folderRepo     repository.FolderRepository
-	permissionRepo repository.PermissionRepository
-	shareLinkRepo  repository.ShareLinkRepository
-	versionRepo    repository.VersionRepository
-	storage        storage.StorageService
-}
-
-func NewDriveService(
-	fileRepo repository.FileRepository,
-	folderRepo repository.FolderRepository,
-	permissionRepo repository.PermissionRepository,
-	shareLinkRepo repository.ShareLinkRepository,
-	versionRepo repository.VersionRepository,
-	storage storage.StorageService,
-) DriveService {
+	fileRepo        repository.FileRepository
+	folderRepo      repository.FolderRepository
+	permissionRepo  repository.PermissionRepository
+	shareLinkRepo   repository.ShareLinkRepository
+	versionRepo     repository.VersionRepository
+	uploadRepo      repository.UploadSessionRepository
+	blobRepo        repository.BlobRepository
+	groupRepo       repository.GroupRepository
+	previewRepo     repository.PreviewRepository
+	searchRepo      repository.SearchRepository
+	appPasswordRepo repository.AppPasswordRepository
+	changeRepo      repository.ChangeRepository
+	quotaRepo       repository.QuotaRepository
+	archiveRepo     repository.ArchiveRepository
+	lockRepo        repository.LockRepository
+	activityRepo    repository.ActivityRepository
+	userRepo        repository.UserRepository
+	storage         storage.Storage
+	notifier        notification.Sender
+	uploadConfig    config.UploadConfig
+	shareConfig     config.ShareConfig
+	previewConfig   config.PreviewConfig
+	searchConfig    config.SearchConfig
+	changeConfig    config.ChangeConfig
+	quotaConfig     config.QuotaConfig
+	versionConfig   config.VersionConfig
+	archiveConfig   config.ArchiveConfig
+	lockConfig      config.LockConfig
+	activityConfig  config.ActivityConfig
+	changeNotifier  *changeNotifier
+}
+
+// Repositories are the stores a drive service keeps its data in
+type Repositories struct {
+	Files        repository.FileRepository
+	Folders      repository.FolderRepository
+	Permissions  repository.PermissionRepository
+	ShareLinks   repository.ShareLinkRepository
+	Versions     repository.VersionRepository
+	Uploads      repository.UploadSessionRepository
+	Blobs        repository.BlobRepository
+	Groups       repository.GroupRepository
+	Previews     repository.PreviewRepository
+	Search       repository.SearchRepository
+	AppPasswords repository.AppPasswordRepository
+	Changes      repository.ChangeRepository
+	Quotas       repository.QuotaRepository
+	Archives     repository.ArchiveRepository
+	Locks        repository.LockRepository
+	Activity     repository.ActivityRepository
+	Users        repository.UserRepository
+}
+
+// NewDriveService creates a drive service keeping its data in repos and
+// content in storage. Quota warnings are sent with notifier, which may be
+// nil.
+func NewDriveService(repos Repositories, storage storage.Storage, notifier notification.Sender, cfg *config.Config) DriveService {
 	return &driveService{
-		fileRepo:       fileRepo,
-		folderRepo:     folderRepo,
-		permissionRepo: permissionRepo,
-		shareLinkRepo:  shareLinkRepo,
-		versionRepo:    versionRepo,
-		storage:        storage,
+		fileRepo:        repos.Files,
+		folderRepo:      repos.Folders,
+		permissionRepo:  repos.Permissions,
+		shareLinkRepo:   repos.ShareLinks,
+		versionRepo:     repos.Versions,
+		uploadRepo:      repos.Uploads,
+		blobRepo:        repos.Blobs,
+		groupRepo:       repos.Groups,
+		previewRepo:     repos.Previews,
+		searchRepo:      repos.Search,
+		appPasswordRepo: repos.AppPasswords,
+		changeRepo:      repos.Changes,
+		quotaRepo:       repos.Quotas,
+		archiveRepo:     repos.Archives,
+		lockRepo:        repos.Locks,
+		activityRepo:    repos.Activity,
+		userRepo:        repos.Users,
+		storage:         storage,
+		notifier:        notifier,
+		uploadConfig:    cfg.Upload,
+		shareConfig:     cfg.Share,
+		previewConfig:   cfg.Previews,
+		searchConfig:    cfg.Search,
+		changeConfig:    cfg.Changes,
+		quotaConfig:     cfg.Quotas,
+		versionConfig:   cfg.Versions,
+		archiveConfig:   cfg.Archives,
+		lockConfig:      cfg.Locks,
+		activityConfig:  cfg.Activity,
+		changeNotifier:  newChangeNotifier(),
 	}
 }
 
 // UploadFile uploads a new file
 func (s *driveService) UploadFile(ctx context.Context, tenantID, userID uuid.UUID, folderID *uuid.UUID, filename string, reader io.Reader, size int64, contentType string) (*model.File, error) {
+	if err := s.checkFolderAccess(ctx, folderID, userID, model.PermissionEditor); err != nil {
+		return nil, err
+	}
+
 	fileID := uuid.New()
 
 	// Determine MIME type
-	if contentType == "" {
-		contentType = mime.TypeByExtension(filepath.Ext(filename))
-		if contentType == "" {
-			contentType = "application/octet-stream"
-		}
-	}
+	contentType = detectContentType(contentType, filename)
 
-	// Upload to storage
-	storagePath, err := s.storage.UploadFile(ctx, tenantID, fileID, filename, reader, size, contentType)
+	// Upload to storage; a failed file creation leaves the blob
+	// unreferenced, and it is collected later. Content that would exceed a
+	// quota is refused before it is stored.
+	blob, err := s.storeBlobWithin(ctx, tenantID, reader, size, contentType, func(storagePath string, size int64) error {
+		return s.checkQuota(ctx, tenantID, userID, storagePath, size)
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	// Checked again now the content is known, in case it was larger than
+	// declared
+	if err := s.checkQuota(ctx, tenantID, userID, blob.StoragePath, blob.Size); err != nil {
+		return nil, err
+	}
+
+	return s.createFile(ctx, tenantID, userID, fileID, folderID, filename, contentType, blob)
+}
+
+// createFile creates the record and initial version of a file with the
+// content of blob
+func (s *driveService) createFile(ctx context.Context, tenantID, userID, fileID uuid.UUID, folderID *uuid.UUID, filename, contentType string, blob *model.Blob) (*model.File, error) {
 	// Create file record
 	file := &model.File{
 		ID:           fileID,
@@ -116,8 +286,9 @@ func (s *driveService) UploadFile(ctx context.Context, tenantID, userID uuid.UUI
 		OriginalName: filename,
 		MimeType:     contentType,
 		FileType:     determineFileType(contentType, filename),
-		Size:         size,
-		StoragePath:  storagePath,
+		Size:         blob.Size,
+		StoragePath:  blob.StoragePath,
+		Checksum:     &blob.Checksum,
 		Version:      1,
 		IsStarred:    false,
 		IsTrashed:    false,
@@ -128,8 +299,6 @@ func (s *driveService) UploadFile(ctx context.Context, tenantID, userID uuid.UUI
 	}
 
 	if err := s.fileRepo.Create(ctx, file); err != nil {
-		// Cleanup storage on failure
-		_ = s.storage.DeleteFile(ctx, storagePath)
 		return nil, err
 	}
 
@@ -138,18 +307,39 @@ func (s *driveService) UploadFile(ctx context.Context, tenantID, userID uuid.UUI
 		ID:          uuid.New(),
 		FileID:      fileID,
 		VersionNum:  1,
-		Size:        size,
-		StoragePath: storagePath,
+		Size:        blob.Size,
+		StoragePath: blob.StoragePath,
+		Checksum:    &blob.Checksum,
 		CreatedBy:   userID,
 		CreatedAt:   time.Now(),
 	}
 	_ = s.versionRepo.Create(ctx, version)
 
+	// Thumbnails and text are generated in the background
+	s.requestPreview(ctx, file)
+
+	s.recordChange(ctx, tenantID, userID, model.ResourceTypeFile, fileID, model.ChangeCreated, nil)
+	s.logFileActivity(ctx, file, userID, model.ActivityCreated, nil)
+	s.checkQuotaThresholds(ctx, tenantID, userID, userID)
+
 	return file, nil
 }
 
 // GetFile retrieves a file by ID
 func (s *driveService) GetFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) (*model.File, error) {
+	file, err := s.getFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logFileActivity(ctx, file, userID, model.ActivityViewed, nil)
+
+	return file, nil
+}
+
+// getFile returns a file the user can view, without recording that they
+// viewed it
+func (s *driveService) getFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) (*model.File, error) {
 	file, err := s.fileRepo.GetByID(ctx, fileID)
 	if err != nil {
 		return nil, err
@@ -159,7 +349,7 @@ func (s *driveService) GetFile(ctx context.Context, fileID uuid.UUID, userID uui
 	if file.OwnerID != userID {
 		hasPermission, err := s.permissionRepo.HasPermission(ctx, fileID, model.ResourceTypeFile, userID, model.PermissionViewer)
 		if err != nil || !hasPermission {
-			return nil, fmt.Errorf("permission denied")
+			return nil, ErrPermissionDenied
 		}
 	}
 
@@ -171,7 +361,7 @@ func (s *driveService) GetFile(ctx context.Context, fileID uuid.UUID, userID uui
 
 // DownloadFile downloads a file
 func (s *driveService) DownloadFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) (io.ReadCloser, *model.File, error) {
-	file, err := s.GetFile(ctx, fileID, userID)
+	file, err := s.getFile(ctx, fileID, userID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -181,11 +371,14 @@ func (s *driveService) DownloadFile(ctx context.Context, fileID uuid.UUID, userI
 		return nil, nil, err
 	}
 
+	s.logFileActivity(ctx, file, userID, model.ActivityDownloaded, nil)
+
 	return reader, file, nil
 }
 
-// UpdateFile updates file metadata
-func (s *driveService) UpdateFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, req *model.UpdateFileRequest) (*model.File, error) {
+// UpdateFile updates file metadata. If ifMatch is not empty, the file must
+// still have one of the ETags it lists.
+func (s *driveService) UpdateFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, req *model.UpdateFileRequest, ifMatch string) (*model.File, error) {
 	file, err := s.fileRepo.GetByID(ctx, fileID)
 	if err != nil {
 		return nil, err
@@ -195,9 +388,24 @@ func (s *driveService) UpdateFile(ctx context.Context, fileID uuid.UUID, userID
 	if file.OwnerID != userID {
 		hasPermission, err := s.permissionRepo.HasPermission(ctx, fileID, model.ResourceTypeFile, userID, model.PermissionEditor)
 		if err != nil || !hasPermission {
-			return nil, fmt.Errorf("permission denied")
+			return nil, ErrPermissionDenied
 		}
 	}
+	if err := checkIfMatch(file, ifMatch); err != nil {
+		return nil, err
+	}
+	if err := s.checkLock(ctx, fileID, userID); err != nil {
+		return nil, err
+	}
+
+	oldName, oldFolderID := file.Name, file.FolderID
+	var before []uuid.UUID
+	if req.FolderID != nil && !sameFolder(oldFolderID, req.FolderID) {
+		if err := s.checkMoveTarget(ctx, model.ResourceTypeFile, fileID, req.FolderID, userID); err != nil {
+			return nil, err
+		}
+		before = s.audience(ctx, model.ResourceTypeFile, fileID)
+	}
 
 	// Update fields
 	if req.Name != nil {
@@ -217,9 +425,13 @@ func (s *driveService) UpdateFile(ctx context.Context, fileID uuid.UUID, userID
 	}
 
 	if err := s.fileRepo.Update(ctx, file); err != nil {
-		return nil, err
+		return nil, updateError(err, ifMatch)
 	}
 
+	changeType := fileChangeType(oldName, oldFolderID, file.Name, file.FolderID)
+	s.recordChange(ctx, file.TenantID, userID, model.ResourceTypeFile, fileID, changeType, before)
+	s.logMoveActivity(ctx, fileActivity(file, userID, "", nil), oldName, oldFolderID, file.FolderID)
+
 	return file, nil
 }
 
@@ -229,29 +441,42 @@ func (s *driveService) DeleteFile(ctx context.Context, fileID uuid.UUID, userID
 }
 
 // MoveFile moves a file to a different folder
-func (s *driveService) MoveFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, targetFolderID *uuid.UUID) (*model.File, error) {
+func (s *driveService) MoveFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, targetFolderID *uuid.UUID, ifMatch string) (*model.File, error) {
 	return s.UpdateFile(ctx, fileID, userID, &model.UpdateFileRequest{
 		FolderID: targetFolderID,
-	})
+	}, ifMatch)
 }
 
 // CopyFile creates a copy of a file
 func (s *driveService) CopyFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, targetFolderID *uuid.UUID) (*model.File, error) {
 	// Get original file
-	originalFile, err := s.GetFile(ctx, fileID, userID)
+	originalFile, err := s.getFile(ctx, fileID, userID)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.checkFolderAccess(ctx, targetFolderID, userID, model.PermissionEditor); err != nil {
+		return nil, err
+	}
 
-	// Generate new ID and storage path
-	newFileID := uuid.New()
-	newStoragePath := storage.GetStoragePath(originalFile.TenantID, newFileID, originalFile.Name)
-
-	// Copy in storage
-	if err := s.storage.CopyFile(ctx, originalFile.StoragePath, newStoragePath); err != nil {
+	// Content-addressed content is shared with the copy; only files stored
+	// before deduplication are copied in storage, and so cost the tenant
+	sharedPath := ""
+	if originalFile.Checksum != nil {
+		sharedPath = originalFile.StoragePath
+	}
+	if err := s.checkQuota(ctx, originalFile.TenantID, userID, sharedPath, originalFile.Size); err != nil {
 		return nil, err
 	}
 
+	newFileID := uuid.New()
+	newStoragePath := originalFile.StoragePath
+	if originalFile.Checksum == nil {
+		newStoragePath = storage.GetStoragePath(originalFile.TenantID, newFileID, originalFile.Name)
+		if err := s.storage.CopyFile(ctx, originalFile.StoragePath, newStoragePath); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create new file record
 	newFile := &model.File{
 		ID:           newFileID,
@@ -264,20 +489,32 @@ func (s *driveService) CopyFile(ctx context.Context, fileID uuid.UUID, userID uu
 		FileType:     originalFile.FileType,
 		Size:         originalFile.Size,
 		StoragePath:  newStoragePath,
+		Checksum:     originalFile.Checksum,
 		Version:      1,
 		IsStarred:    false,
 		IsTrashed:    false,
 		Tags:         originalFile.Tags,
 		Metadata:     originalFile.Metadata,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		// The copy shares the content, and so the thumbnail
+		ThumbnailPath: originalFile.ThumbnailPath,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
 	}
 
 	if err := s.fileRepo.Create(ctx, newFile); err != nil {
-		_ = s.storage.DeleteFile(ctx, newStoragePath)
+		if originalFile.Checksum == nil {
+			_ = s.storage.DeleteFile(ctx, newStoragePath)
+		}
 		return nil, err
 	}
 
+	s.recordChange(ctx, newFile.TenantID, userID, model.ResourceTypeFile, newFileID, model.ChangeCreated, nil)
+	s.logFileActivity(ctx, newFile, userID, model.ActivityCopied, model.Metadata{
+		"source_file_id":   originalFile.ID,
+		"source_file_name": originalFile.Name,
+	})
+	s.checkQuotaThresholds(ctx, newFile.TenantID, userID, userID)
+
 	return newFile, nil
 }
 
@@ -286,11 +523,6 @@ func (s *driveService) ListFiles(ctx context.Context, tenantID, userID uuid.UUID
 	return s.fileRepo.GetByTenant(ctx, tenantID, userID, folderID, includeShared)
 }
 
-// SearchFiles searches for files
-func (s *driveService) SearchFiles(ctx context.Context, tenantID uuid.UUID, query string, fileType *model.FileType) ([]*model.File, error) {
-	return s.fileRepo.Search(ctx, tenantID, query, fileType)
-}
-
 // GetStarredFiles gets starred files
 func (s *driveService) GetStarredFiles(ctx context.Context, tenantID, userID uuid.UUID) ([]*model.File, error) {
 	return s.fileRepo.GetStarred(ctx, tenantID, userID)
@@ -303,6 +535,10 @@ func (s *driveService) GetRecentFiles(ctx context.Context, tenantID, userID uuid
 
 // CreateFolder creates a new folder
 func (s *driveService) CreateFolder(ctx context.Context, tenantID, userID uuid.UUID, req *model.CreateFolderRequest) (*model.Folder, error) {
+	if err := s.checkFolderAccess(ctx, req.ParentID, userID, model.PermissionEditor); err != nil {
+		return nil, err
+	}
+
 	folder := &model.Folder{
 		ID:          uuid.New(),
 		TenantID:    tenantID,
@@ -321,6 +557,9 @@ func (s *driveService) CreateFolder(ctx context.Context, tenantID, userID uuid.U
 		return nil, err
 	}
 
+	s.recordChange(ctx, tenantID, userID, model.ResourceTypeFolder, folder.ID, model.ChangeCreated, nil)
+	s.logFolderActivity(ctx, folder, userID, model.ActivityCreated, nil)
+
 	return folder, nil
 }
 
@@ -335,7 +574,7 @@ func (s *driveService) GetFolder(ctx context.Context, folderID uuid.UUID, userID
 	if folder.OwnerID != userID {
 		hasPermission, err := s.permissionRepo.HasPermission(ctx, folderID, model.ResourceTypeFolder, userID, model.PermissionViewer)
 		if err != nil || !hasPermission {
-			return nil, fmt.Errorf("permission denied")
+			return nil, ErrPermissionDenied
 		}
 	}
 
@@ -353,10 +592,22 @@ func (s *driveService) UpdateFolder(ctx context.Context, folderID uuid.UUID, use
 	if folder.OwnerID != userID {
 		hasPermission, err := s.permissionRepo.HasPermission(ctx, folderID, model.ResourceTypeFolder, userID, model.PermissionEditor)
 		if err != nil || !hasPermission {
-			return nil, fmt.Errorf("permission denied")
+			return nil, ErrPermissionDenied
 		}
 	}
 
+	oldName, oldParentID := folder.Name, folder.ParentID
+	var before []uuid.UUID
+	if req.ParentID != nil && !sameFolder(oldParentID, req.ParentID) {
+		if err := s.checkMoveTarget(ctx, model.ResourceTypeFolder, folderID, req.ParentID, userID); err != nil {
+			return nil, err
+		}
+		if err := s.checkFolderCycle(ctx, folderID, req.ParentID); err != nil {
+			return nil, err
+		}
+		before = s.audience(ctx, model.ResourceTypeFolder, folderID)
+	}
+
 	// Update fields
 	if req.Name != nil {
 		folder.Name = *req.Name
@@ -378,6 +629,10 @@ func (s *driveService) UpdateFolder(ctx context.Context, folderID uuid.UUID, use
 		return nil, err
 	}
 
+	changeType := fileChangeType(oldName, oldParentID, folder.Name, folder.ParentID)
+	s.recordChange(ctx, folder.TenantID, userID, model.ResourceTypeFolder, folderID, changeType, before)
+	s.logMoveActivity(ctx, folderActivity(folder, userID, "", nil), oldName, oldParentID, folder.ParentID)
+
 	return folder, nil
 }
 
@@ -404,27 +659,69 @@ func (s *driveService) MoveToTrash(ctx context.Context, resourceID uuid.UUID, re
 			return err
 		}
 		if file.OwnerID != userID {
-			return fmt.Errorf("permission denied")
+			return ErrPermissionDenied
+		}
+		if err := s.checkLock(ctx, resourceID, userID); err != nil {
+			return err
+		}
+		if err := s.fileRepo.MoveToTrash(ctx, resourceID); err != nil {
+			return err
 		}
-		return s.fileRepo.MoveToTrash(ctx, resourceID)
+		s.recordChange(ctx, file.TenantID, userID, resourceType, resourceID, model.ChangeTrashed, nil)
+		s.logFileActivity(ctx, file, userID, model.ActivityTrashed, nil)
+		return nil
 	} else {
 		folder, err := s.folderRepo.GetByID(ctx, resourceID)
 		if err != nil {
 			return err
 		}
 		if folder.OwnerID != userID {
-			return fmt.Errorf("permission denied")
+			return ErrPermissionDenied
+		}
+
+		// Nothing inside may be locked by anyone else
+		descendants, err := s.folderRepo.GetDescendants(ctx, resourceID)
+		if err != nil {
+			return err
+		}
+		ids := make([]uuid.UUID, len(descendants))
+		for i, descendant := range descendants {
+			ids[i] = descendant.ID
+		}
+		if err := s.checkFolderLocks(ctx, ids, userID); err != nil {
+			return err
+		}
+
+		if err := s.folderRepo.MoveToTrash(ctx, resourceID); err != nil {
+			return err
 		}
-		return s.folderRepo.MoveToTrash(ctx, resourceID)
+		s.recordChange(ctx, folder.TenantID, userID, resourceType, resourceID, model.ChangeTrashed, nil)
+		s.logFolderActivity(ctx, folder, userID, model.ActivityTrashed, nil)
+		return nil
 	}
 }
 
 // RestoreFromTrash restores a resource from trash
 func (s *driveService) RestoreFromTrash(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID) error {
 	if resourceType == model.ResourceTypeFile {
-		return s.fileRepo.RestoreFromTrash(ctx, resourceID)
+		if err := s.fileRepo.RestoreFromTrash(ctx, resourceID); err != nil {
+			return err
+		}
+		if file, err := s.fileRepo.GetByID(ctx, resourceID); err == nil {
+			s.recordChange(ctx, file.TenantID, userID, resourceType, resourceID, model.ChangeRestored, nil)
+			s.logFileActivity(ctx, file, userID, model.ActivityRestored, nil)
+		}
+		return nil
+	}
+
+	if err := s.folderRepo.RestoreFromTrash(ctx, resourceID); err != nil {
+		return err
 	}
-	return s.folderRepo.RestoreFromTrash(ctx, resourceID)
+	if folder, err := s.folderRepo.GetByID(ctx, resourceID); err == nil {
+		s.recordChange(ctx, folder.TenantID, userID, resourceType, resourceID, model.ChangeRestored, nil)
+		s.logFolderActivity(ctx, folder, userID, model.ActivityRestored, nil)
+	}
+	return nil
 }
 
 // EmptyTrash permanently deletes all trashed items
@@ -435,10 +732,19 @@ func (s *driveService) EmptyTrash(ctx context.Context, tenantID, userID uuid.UUI
 		return err
 	}
 
-	// Delete from storage and database
+	// Delete from database; blobs no longer referenced are collected later,
+	// files stored before deduplication are deleted from storage here.
+	// Deletions are logged beforehand, while the folders they were in are
+	// still known.
 	for _, file := range files {
-		_ = s.storage.DeleteFile(ctx, file.StoragePath)
-		_ = s.fileRepo.PermanentDelete(ctx, file.ID)
+		before := s.audience(ctx, model.ResourceTypeFile, file.ID)
+		s.logFileActivity(ctx, file, userID, model.ActivityDeleted, nil)
+		if file.Checksum == nil {
+			_ = s.storage.DeleteFile(ctx, file.StoragePath)
+		}
+		if err := s.fileRepo.PermanentDelete(ctx, file.ID); err == nil {
+			s.recordChange(ctx, tenantID, userID, model.ResourceTypeFile, file.ID, model.ChangeDeleted, before)
+		}
 	}
 
 	// Get trashed folders
@@ -448,9 +754,16 @@ func (s *driveService) EmptyTrash(ctx context.Context, tenantID, userID uuid.UUI
 	}
 
 	for _, folder := range folders {
-		_ = s.folderRepo.PermanentDelete(ctx, folder.ID)
+		before := s.audience(ctx, model.ResourceTypeFolder, folder.ID)
+		s.logFolderActivity(ctx, folder, userID, model.ActivityDeleted, nil)
+		if err := s.folderRepo.PermanentDelete(ctx, folder.ID); err == nil {
+			s.recordChange(ctx, tenantID, userID, model.ResourceTypeFolder, folder.ID, model.ChangeDeleted, before)
+		}
 	}
 
+	// Lower the warning thresholds reached, so that filling up again warns
+	s.checkQuotaThresholds(ctx, tenantID, userID, userID)
+
 	return nil
 }
 
@@ -477,8 +790,34 @@ func (s *driveService) ListTrashed(ctx context.Context, tenantID, userID uuid.UU
 	return result, nil
 }
 
-// GrantPermission grants permission to a user
+// GrantPermission grants permission on a file or folder to a user, email
+// address or group. Permissions on a folder apply to everything in it.
+// Only owners of the resource may share it, and only with users and groups
+// of its tenant.
 func (s *driveService) GrantPermission(ctx context.Context, tenantID, userID uuid.UUID, req *model.CreatePermissionRequest) (*model.Permission, error) {
+	if req.UserID == nil && req.Email == nil && req.GroupID == nil {
+		return nil, fmt.Errorf("%w: user_id, email or group_id is required", ErrInvalidPermission)
+	}
+	if _, ok := roleRank[req.Role]; !ok {
+		return nil, fmt.Errorf("%w: role must be owner, editor or viewer", ErrInvalidPermission)
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidPermission)
+	}
+	if err := s.checkResourceAccess(ctx, tenantID, req.ResourceType, req.ResourceID, userID, model.PermissionOwner); err != nil {
+		return nil, err
+	}
+	if req.UserID != nil {
+		if err := s.checkTenantUser(ctx, tenantID, *req.UserID); err != nil {
+			return nil, err
+		}
+	}
+	if req.GroupID != nil {
+		if _, err := s.getGroup(ctx, tenantID, *req.GroupID); err != nil {
+			return nil, err
+		}
+	}
+
 	permission := &model.Permission{
 		ID:           uuid.New(),
 		TenantID:     tenantID,
@@ -486,6 +825,7 @@ func (s *driveService) GrantPermission(ctx context.Context, tenantID, userID uui
 		ResourceType: req.ResourceType,
 		UserID:       req.UserID,
 		Email:        req.Email,
+		GroupID:      req.GroupID,
 		Role:         req.Role,
 		GrantedBy:    userID,
 		ExpiresAt:    req.ExpiresAt,
@@ -497,21 +837,65 @@ func (s *driveService) GrantPermission(ctx context.Context, tenantID, userID uui
 		return nil, err
 	}
 
+	s.recordChange(ctx, tenantID, userID, req.ResourceType, req.ResourceID, model.ChangePermissionChanged, nil)
+	s.logResourceActivity(ctx, &model.Activity{
+		ActorID:      &userID,
+		Action:       model.ActivityShared,
+		ResourceType: req.ResourceType,
+		ResourceID:   req.ResourceID,
+		Details:      permissionDetails(permission),
+	})
+
 	return permission, nil
 }
 
-// RevokePermission revokes a permission
-func (s *driveService) RevokePermission(ctx context.Context, permissionID uuid.UUID, userID uuid.UUID) error {
-	return s.permissionRepo.Delete(ctx, permissionID)
+// RevokePermission revokes a permission. Only owners of the resource may
+// revoke permissions on it.
+func (s *driveService) RevokePermission(ctx context.Context, tenantID, permissionID, userID uuid.UUID) error {
+	permission, err := s.permissionRepo.GetByID(ctx, permissionID)
+	if err != nil || permission.TenantID != tenantID {
+		return ErrPermissionNotFound
+	}
+	if err := s.checkResourceAccess(ctx, tenantID, permission.ResourceType, permission.ResourceID, userID, model.PermissionOwner); err != nil {
+		return err
+	}
+	before := s.audience(ctx, permission.ResourceType, permission.ResourceID)
+
+	if err := s.permissionRepo.Delete(ctx, permissionID); err != nil {
+		return err
+	}
+
+	s.recordChange(ctx, permission.TenantID, userID, permission.ResourceType, permission.ResourceID, model.ChangePermissionChanged, before)
+	s.logResourceActivity(ctx, &model.Activity{
+		ActorID:      &userID,
+		Action:       model.ActivityUnshared,
+		ResourceType: permission.ResourceType,
+		ResourceID:   permission.ResourceID,
+		Details:      permissionDetails(permission),
+	})
+
+	return nil
 }
 
-// ListPermissions lists permissions for a resource
-func (s *driveService) ListPermissions(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID) ([]*model.Permission, error) {
+// ListPermissions lists permissions for a resource the user can view
+func (s *driveService) ListPermissions(ctx context.Context, tenantID, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID) ([]*model.Permission, error) {
+	if err := s.checkResourceAccess(ctx, tenantID, resourceType, resourceID, userID, model.PermissionViewer); err != nil {
+		return nil, err
+	}
+
 	return s.permissionRepo.GetByResource(ctx, resourceID, resourceType)
 }
 
-// CreateShareLink creates a public share link
+// CreateShareLink creates a public share link. Owners and editors of a
+// file or folder may share it by link, as a viewer or editor.
 func (s *driveService) CreateShareLink(ctx context.Context, tenantID, userID uuid.UUID, req *model.CreateShareLinkRequest) (*model.ShareLink, error) {
+	if req.Role != model.PermissionViewer && req.Role != model.PermissionEditor {
+		return nil, fmt.Errorf("%w: role must be editor or viewer", ErrInvalidShareRequest)
+	}
+	if err := s.checkResourceAccess(ctx, tenantID, req.ResourceType, req.ResourceID, userID, model.PermissionEditor); err != nil {
+		return nil, err
+	}
+
 	// Generate random token
 	token, err := generateToken(32)
 	if err != nil {
@@ -549,6 +933,15 @@ func (s *driveService) CreateShareLink(ctx context.Context, tenantID, userID uui
 		return nil, err
 	}
 
+	s.logResourceActivity(ctx, &model.Activity{
+		ActorID:      &userID,
+		Action:       model.ActivityLinkCreated,
+		ResourceType: link.ResourceType,
+		ResourceID:   link.ResourceID,
+		ShareLinkID:  &link.ID,
+		Details:      model.Metadata{"role": link.Role, "password": link.Password != nil},
+	})
+
 	return link, nil
 }
 
@@ -557,15 +950,36 @@ func (s *driveService) GetShareLink(ctx context.Context, token string) (*model.S
 	return s.shareLinkRepo.GetByToken(ctx, token)
 }
 
-// DeleteShareLink deletes a share link
-func (s *driveService) DeleteShareLink(ctx context.Context, linkID uuid.UUID, userID uuid.UUID) error {
-	return s.shareLinkRepo.Delete(ctx, linkID)
+// DeleteShareLink deletes a share link. Like creating one, it requires
+// editing the shared file or folder.
+func (s *driveService) DeleteShareLink(ctx context.Context, tenantID, linkID, userID uuid.UUID) error {
+	link, err := s.getShareLink(ctx, tenantID, linkID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkResourceAccess(ctx, tenantID, link.ResourceType, link.ResourceID, userID, model.PermissionEditor); err != nil {
+		return err
+	}
+
+	if err := s.shareLinkRepo.Delete(ctx, linkID); err != nil {
+		return err
+	}
+
+	s.logResourceActivity(ctx, &model.Activity{
+		ActorID:      &userID,
+		Action:       model.ActivityLinkDeleted,
+		ResourceType: link.ResourceType,
+		ResourceID:   link.ResourceID,
+		ShareLinkID:  &link.ID,
+	})
+
+	return nil
 }
 
 // ListVersions lists all versions of a file
 func (s *driveService) ListVersions(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) ([]*model.FileVersion, error) {
 	// Check permission
-	file, err := s.GetFile(ctx, fileID, userID)
+	file, err := s.getFile(ctx, fileID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -573,55 +987,98 @@ func (s *driveService) ListVersions(ctx context.Context, fileID uuid.UUID, userI
 	if file.OwnerID != userID {
 		hasPermission, err := s.permissionRepo.HasPermission(ctx, fileID, model.ResourceTypeFile, userID, model.PermissionViewer)
 		if err != nil || !hasPermission {
-			return nil, fmt.Errorf("permission denied")
+			return nil, ErrPermissionDenied
 		}
 	}
 
 	return s.versionRepo.GetByFile(ctx, fileID)
 }
 
-// RestoreVersion restores a specific version of a file
+// RestoreVersion restores a specific version of a file. The restored
+// content becomes a new version; it is shared with the old one, not copied.
 func (s *driveService) RestoreVersion(ctx context.Context, fileID uuid.UUID, versionNum int, userID uuid.UUID) (*model.File, error) {
 	// Get the file and version
-	file, err := s.GetFile(ctx, fileID, userID)
+	file, err := s.fileRepo.GetByID(ctx, fileID)
 	if err != nil {
 		return nil, err
 	}
 
+	// Restoring adds a version, so it takes the right to edit the file
+	if file.OwnerID != userID {
+		hasPermission, err := s.permissionRepo.HasPermission(ctx, fileID, model.ResourceTypeFile, userID, model.PermissionEditor)
+		if err != nil || !hasPermission {
+			return nil, ErrPermissionDenied
+		}
+	}
+
+	if err := s.checkLock(ctx, fileID, userID); err != nil {
+		return nil, err
+	}
+
 	version, err := s.versionRepo.GetByVersion(ctx, fileID, versionNum)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create new version from current
+	// The owner is charged for the file's content; that of a version they
+	// still hold is already counted
+	if err := s.checkQuota(ctx, file.TenantID, file.OwnerID, version.StoragePath, version.Size); err != nil {
+		return nil, err
+	}
+
+	// Create new version with the restored content
 	latestVersion, _ := s.versionRepo.GetLatestVersionNum(ctx, fileID)
+	comment := fmt.Sprintf("Restored from version %d", versionNum)
 	newVersion := &model.FileVersion{
 		ID:          uuid.New(),
 		FileID:      fileID,
 		VersionNum:  latestVersion + 1,
-		Size:        file.Size,
-		StoragePath: file.StoragePath,
+		Size:        version.Size,
+		StoragePath: version.StoragePath,
+		Checksum:    version.Checksum,
 		CreatedBy:   userID,
-		Comment:     nil,
+		Comment:     &comment,
 		CreatedAt:   time.Now(),
 	}
-	_ = s.versionRepo.Create(ctx, newVersion)
+	if err := s.versionRepo.Create(ctx, newVersion); err != nil {
+		return nil, err
+	}
 
-	// Update file to use old version's storage path
+	// Update file to use the restored content
 	file.StoragePath = version.StoragePath
 	file.Size = version.Size
+	file.Checksum = version.Checksum
 	file.Version = newVersion.VersionNum
+	file.ThumbnailPath = nil
 	file.UpdatedAt = time.Now()
 
-	if err := s.fileRepo.Update(ctx, file); err != nil {
-		return nil, err
+	if err := s.fileRepo.UpdateContent(ctx, file); err != nil {
+		return nil, updateError(err, "")
 	}
 
+	s.requestPreview(ctx, file)
+	s.recordChange(ctx, file.TenantID, userID, model.ResourceTypeFile, fileID, model.ChangeUpdated, nil)
+	s.logFileActivity(ctx, file, userID, model.ActivityVersionRestored, model.Metadata{
+		"version":       newVersion.VersionNum,
+		"restored_from": versionNum,
+	})
+	s.checkQuotaThresholds(ctx, file.TenantID, file.OwnerID, userID)
+
 	return file, nil
 }
 
 // Helper functions
 
+func detectContentType(contentType, filename string) string {
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+	}
+	return contentType
+}
+
 func determineFileType(mimeType, filename string) model.FileType {
 	// Check by MIME type
 	if strings.HasPrefix(mimeType, "image/") {
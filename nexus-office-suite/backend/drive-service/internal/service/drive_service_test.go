@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+// moveFixture is Alice's folder with a file in it that Eve may edit, a
+// folder of Alice's that Eve may edit, Eve's own folder and a folder of
+// Carol's that Eve cannot see
+type moveFixture struct {
+	store                   *fakeStore
+	alice, eve, carol       uuid.UUID
+	aliceFolder, eveFolder  *model.Folder
+	sharedFolder, carolOnly *model.Folder
+	file                    *model.File
+}
+
+func newMoveFixture() *moveFixture {
+	f := &moveFixture{store: newFakeStore(), alice: uuid.New(), eve: uuid.New(), carol: uuid.New()}
+	f.aliceFolder = f.store.addFolder(f.alice, nil)
+	f.sharedFolder = f.store.addFolder(f.alice, nil)
+	f.eveFolder = f.store.addFolder(f.eve, nil)
+	f.carolOnly = f.store.addFolder(f.carol, nil)
+	f.file = f.store.addFile(f.alice, &f.aliceFolder.ID)
+	f.store.grant(f.file.ID, f.eve, model.PermissionEditor)
+	f.store.grant(f.sharedFolder.ID, f.eve, model.PermissionEditor)
+	return f
+}
+
+func TestMoveFileDestinationAccess(t *testing.T) {
+	ctx := context.Background()
+
+	moves := map[string]func(s *driveService, f *moveFixture, userID uuid.UUID, folderID *uuid.UUID) error{
+		"UpdateFile": func(s *driveService, f *moveFixture, userID uuid.UUID, folderID *uuid.UUID) error {
+			_, err := s.UpdateFile(ctx, f.file.ID, userID, &model.UpdateFileRequest{FolderID: folderID}, "")
+			return err
+		},
+		"MoveFile": func(s *driveService, f *moveFixture, userID uuid.UUID, folderID *uuid.UUID) error {
+			_, err := s.MoveFile(ctx, f.file.ID, userID, folderID, "")
+			return err
+		},
+		"RenameFile": func(s *driveService, f *moveFixture, userID uuid.UUID, folderID *uuid.UUID) error {
+			_, err := s.RenameFile(ctx, f.file.ID, userID, folderID, f.file.Name)
+			return err
+		},
+	}
+
+	for name, move := range moves {
+		t.Run(name, func(t *testing.T) {
+			// An editor moving the file into their own folder would become its owner
+			f := newMoveFixture()
+			s := newTestService(f.store)
+			if err := move(s, f, f.eve, &f.eveFolder.ID); !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("editor moving into own folder: got %v, want %v", err, ErrPermissionDenied)
+			}
+			if f.store.role(model.ResourceTypeFile, f.file.ID, f.eve) == model.PermissionOwner {
+				t.Error("editor became owner of the file")
+			}
+
+			if err := move(s, f, f.eve, &f.carolOnly.ID); !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("editor moving into a folder they cannot edit: got %v, want %v", err, ErrPermissionDenied)
+			}
+			if got := f.store.files[f.file.ID].FolderID; got == nil || *got != f.aliceFolder.ID {
+				t.Errorf("file moved to %v after denied moves", got)
+			}
+
+			if err := move(s, f, f.eve, &f.sharedFolder.ID); err != nil {
+				t.Errorf("editor moving into a folder they can edit: %v", err)
+			}
+			if err := move(s, f, f.alice, &f.aliceFolder.ID); err != nil {
+				t.Errorf("owner moving into own folder: %v", err)
+			}
+		})
+	}
+}
+
+func TestCopyFileDestinationAccess(t *testing.T) {
+	f := newMoveFixture()
+	s := newTestService(f.store)
+
+	_, err := s.CopyFile(context.Background(), f.file.ID, f.eve, &f.carolOnly.ID)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("copying into a folder the user cannot edit: got %v, want %v", err, ErrPermissionDenied)
+	}
+}
+
+func TestMoveFolderDestinationAccess(t *testing.T) {
+	ctx := context.Background()
+
+	moves := map[string]func(s *driveService, folder *model.Folder, userID uuid.UUID, parentID *uuid.UUID) error{
+		"UpdateFolder": func(s *driveService, folder *model.Folder, userID uuid.UUID, parentID *uuid.UUID) error {
+			_, err := s.UpdateFolder(ctx, folder.ID, userID, &model.UpdateFolderRequest{ParentID: parentID})
+			return err
+		},
+		"RenameFolder": func(s *driveService, folder *model.Folder, userID uuid.UUID, parentID *uuid.UUID) error {
+			_, err := s.RenameFolder(ctx, folder.ID, userID, parentID, folder.Name)
+			return err
+		},
+	}
+
+	for name, move := range moves {
+		t.Run(name, func(t *testing.T) {
+			f := newMoveFixture()
+			s := newTestService(f.store)
+			child := f.store.addFolder(f.alice, &f.sharedFolder.ID)
+
+			if err := move(s, child, f.eve, &f.eveFolder.ID); !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("editor moving into own folder: got %v, want %v", err, ErrPermissionDenied)
+			}
+			if err := move(s, child, f.eve, &f.carolOnly.ID); !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("editor moving into a folder they cannot edit: got %v, want %v", err, ErrPermissionDenied)
+			}
+			if got := f.store.folders[child.ID].ParentID; got == nil || *got != f.sharedFolder.ID {
+				t.Errorf("folder moved to %v after denied moves", got)
+			}
+
+			if err := move(s, child, f.alice, &f.aliceFolder.ID); err != nil {
+				t.Errorf("owner moving into own folder: %v", err)
+			}
+		})
+	}
+}
+
+func TestMoveFolderIntoOwnSubtree(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	alice := uuid.New()
+
+	parent := store.addFolder(alice, nil)
+	child := store.addFolder(alice, &parent.ID)
+	grandchild := store.addFolder(alice, &child.ID)
+	other := store.addFolder(alice, nil)
+
+	for _, target := range []*model.Folder{parent, child, grandchild} {
+		_, err := s.UpdateFolder(ctx, parent.ID, alice, &model.UpdateFolderRequest{ParentID: &target.ID})
+		if !errors.Is(err, ErrInvalidMove) {
+			t.Errorf("UpdateFolder into its own subtree: got %v, want %v", err, ErrInvalidMove)
+		}
+		_, err = s.RenameFolder(ctx, parent.ID, alice, &target.ID, parent.Name)
+		if !errors.Is(err, ErrInvalidMove) {
+			t.Errorf("RenameFolder into its own subtree: got %v, want %v", err, ErrInvalidMove)
+		}
+	}
+	if store.folders[parent.ID].ParentID != nil {
+		t.Errorf("folder moved to %v after invalid moves", store.folders[parent.ID].ParentID)
+	}
+
+	if _, err := s.UpdateFolder(ctx, parent.ID, alice, &model.UpdateFolderRequest{ParentID: &other.ID}); err != nil {
+		t.Errorf("UpdateFolder into another folder: %v", err)
+	}
+}
@@ -0,0 +1,772 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/repository"
+)
+
+// fakeStore holds the state behind the fake repositories. Each fake embeds
+// its repository interface, so calling a method it does not implement
+// panics and shows what a test is missing.
+type fakeStore struct {
+	files       map[uuid.UUID]*model.File
+	folders     map[uuid.UUID]*model.Folder
+	grants      map[uuid.UUID]map[uuid.UUID]model.PermissionRole // resource, user
+	permissions map[uuid.UUID]*model.Permission
+	users       map[[2]uuid.UUID]bool // tenant, user
+	locks       map[uuid.UUID]*model.FileLock
+	versions    map[uuid.UUID][]*model.FileVersion // by file
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		files:       make(map[uuid.UUID]*model.File),
+		folders:     make(map[uuid.UUID]*model.Folder),
+		grants:      make(map[uuid.UUID]map[uuid.UUID]model.PermissionRole),
+		permissions: make(map[uuid.UUID]*model.Permission),
+		users:       make(map[[2]uuid.UUID]bool),
+		locks:       make(map[uuid.UUID]*model.FileLock),
+		versions:    make(map[uuid.UUID][]*model.FileVersion),
+	}
+}
+
+// newTestService returns a service backed by the store
+func newTestService(store *fakeStore) *driveService {
+	return &driveService{
+		fileRepo:       &fakeFileRepo{store: store},
+		folderRepo:     &fakeFolderRepo{store: store},
+		permissionRepo: &fakePermissionRepo{store: store},
+		lockRepo:       &fakeLockRepo{store: store},
+		versionRepo:    &fakeVersionRepo{store: store},
+		changeRepo:     newFakeChangeRepo(store),
+		activityRepo:   &fakeActivityRepo{},
+		userRepo:       &fakeUserRepo{store: store},
+		changeNotifier: newChangeNotifier(),
+	}
+}
+
+func (s *fakeStore) addFolder(ownerID uuid.UUID, parentID *uuid.UUID) *model.Folder {
+	folder := &model.Folder{ID: uuid.New(), OwnerID: ownerID, ParentID: parentID, Name: "folder"}
+	s.folders[folder.ID] = folder
+	return folder
+}
+
+func (s *fakeStore) addFile(ownerID uuid.UUID, folderID *uuid.UUID) *model.File {
+	file := &model.File{ID: uuid.New(), OwnerID: ownerID, FolderID: folderID, Name: "file.txt", Version: 1}
+	s.files[file.ID] = file
+	return file
+}
+
+// addVersion adds a version with new content to a file, making it current
+func (s *fakeStore) addVersion(fileID uuid.UUID, content string) *model.FileVersion {
+	file := s.files[fileID]
+	version := &model.FileVersion{
+		ID:          uuid.New(),
+		FileID:      fileID,
+		VersionNum:  len(s.versions[fileID]) + 1,
+		Size:        int64(len(content)),
+		StoragePath: "blobs/" + content,
+		CreatedBy:   file.OwnerID,
+	}
+	s.versions[fileID] = append(s.versions[fileID], version)
+	file.Version, file.Size, file.StoragePath = version.VersionNum, version.Size, version.StoragePath
+	return version
+}
+
+func (s *fakeStore) grant(resourceID, userID uuid.UUID, role model.PermissionRole) {
+	if s.grants[resourceID] == nil {
+		s.grants[resourceID] = make(map[uuid.UUID]model.PermissionRole)
+	}
+	s.grants[resourceID][userID] = role
+}
+
+// viewers mirrors resource_audience: the users with any role on the
+// resource
+func (s *fakeStore) viewers(resourceType model.ResourceType, resourceID uuid.UUID) []uuid.UUID {
+	candidates := make(map[uuid.UUID]bool)
+	for _, folder := range s.folders {
+		candidates[folder.OwnerID] = true
+	}
+	for _, file := range s.files {
+		candidates[file.OwnerID] = true
+	}
+	for _, grants := range s.grants {
+		for userID := range grants {
+			candidates[userID] = true
+		}
+	}
+
+	var userIDs []uuid.UUID
+	for userID := range candidates {
+		if s.role(resourceType, resourceID, userID) != "" {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs
+}
+
+// role mirrors effective_role: owning the resource or a folder containing it
+// makes the user an owner, otherwise the nearest grant decides
+func (s *fakeStore) role(resourceType model.ResourceType, resourceID, userID uuid.UUID) model.PermissionRole {
+	var ownerID uuid.UUID
+	var parentID *uuid.UUID
+	if resourceType == model.ResourceTypeFile {
+		file, ok := s.files[resourceID]
+		if !ok {
+			return ""
+		}
+		ownerID, parentID = file.OwnerID, file.FolderID
+	} else {
+		folder, ok := s.folders[resourceID]
+		if !ok {
+			return ""
+		}
+		ownerID, parentID = folder.OwnerID, folder.ParentID
+	}
+
+	ids := []uuid.UUID{resourceID}
+	owners := []uuid.UUID{ownerID}
+	for parentID != nil {
+		folder := s.folders[*parentID]
+		ids = append(ids, folder.ID)
+		owners = append(owners, folder.OwnerID)
+		parentID = folder.ParentID
+	}
+
+	for _, owner := range owners {
+		if owner == userID {
+			return model.PermissionOwner
+		}
+	}
+	for _, id := range ids {
+		if role, ok := s.grants[id][userID]; ok {
+			return role
+		}
+	}
+	return ""
+}
+
+type fakeFileRepo struct {
+	repository.FileRepository
+	store *fakeStore
+}
+
+func (r *fakeFileRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.File, error) {
+	file, ok := r.store.files[id]
+	if !ok {
+		return nil, fmt.Errorf("file not found")
+	}
+	copied := *file
+	return &copied, nil
+}
+
+func (r *fakeFileRepo) GetByIDs(ctx context.Context, ids []uuid.UUID) ([]*model.File, error) {
+	files := []*model.File{}
+	for _, id := range ids {
+		if file, ok := r.store.files[id]; ok {
+			copied := *file
+			files = append(files, &copied)
+		}
+	}
+	return files, nil
+}
+
+func (r *fakeFileRepo) GetByFolders(ctx context.Context, folderIDs []uuid.UUID) ([]*model.File, error) {
+	files := []*model.File{}
+	for _, folderID := range folderIDs {
+		for _, file := range r.store.files {
+			if file.FolderID != nil && *file.FolderID == folderID && !file.IsTrashed {
+				copied := *file
+				files = append(files, &copied)
+			}
+		}
+	}
+	return files, nil
+}
+
+func (r *fakeFileRepo) Create(ctx context.Context, file *model.File) error {
+	copied := *file
+	r.store.files[file.ID] = &copied
+	return nil
+}
+
+// Update stores the file if it has not changed since it was read, and
+// increments its revision
+func (r *fakeFileRepo) Update(ctx context.Context, file *model.File) error {
+	stored, ok := r.store.files[file.ID]
+	if !ok {
+		return fmt.Errorf("file not found")
+	}
+	if stored.Revision != file.Revision {
+		return repository.ErrConflict
+	}
+	file.Revision++
+	copied := *file
+	r.store.files[file.ID] = &copied
+	return nil
+}
+
+func (r *fakeFileRepo) UpdateContent(ctx context.Context, file *model.File) error {
+	return r.Update(ctx, file)
+}
+
+func (r *fakeFileRepo) SetThumbnailPath(ctx context.Context, tenantID uuid.UUID, checksum string, thumbnailPath *string) error {
+	for _, file := range r.store.files {
+		if file.TenantID == tenantID && file.Checksum != nil && *file.Checksum == checksum {
+			file.ThumbnailPath = thumbnailPath
+		}
+	}
+	return nil
+}
+
+func (r *fakeFileRepo) UpdateAccessTime(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+
+type fakeFolderRepo struct {
+	repository.FolderRepository
+	store *fakeStore
+}
+
+func (r *fakeFolderRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.Folder, error) {
+	folder, ok := r.store.folders[id]
+	if !ok {
+		return nil, fmt.Errorf("folder not found")
+	}
+	copied := *folder
+	return &copied, nil
+}
+
+func (r *fakeFolderRepo) GetByIDs(ctx context.Context, ids []uuid.UUID) ([]*model.Folder, error) {
+	folders := []*model.Folder{}
+	for _, id := range ids {
+		if folder, ok := r.store.folders[id]; ok {
+			copied := *folder
+			folders = append(folders, &copied)
+		}
+	}
+	return folders, nil
+}
+
+func (r *fakeFolderRepo) Create(ctx context.Context, folder *model.Folder) error {
+	copied := *folder
+	r.store.folders[folder.ID] = &copied
+	return nil
+}
+
+func (r *fakeFolderRepo) Update(ctx context.Context, folder *model.Folder) error {
+	if _, ok := r.store.folders[folder.ID]; !ok {
+		return fmt.Errorf("folder not found")
+	}
+	copied := *folder
+	r.store.folders[folder.ID] = &copied
+	return nil
+}
+
+func (r *fakeFolderRepo) GetDescendants(ctx context.Context, folderID uuid.UUID) ([]*model.Folder, error) {
+	descendants := []*model.Folder{r.store.folders[folderID]}
+	for i := 0; i < len(descendants); i++ {
+		for _, folder := range r.store.folders {
+			if folder.ParentID != nil && *folder.ParentID == descendants[i].ID {
+				descendants = append(descendants, folder)
+			}
+		}
+	}
+	return descendants, nil
+}
+
+type fakePermissionRepo struct {
+	repository.PermissionRepository
+	store *fakeStore
+}
+
+func (r *fakePermissionRepo) HasPermission(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType, userID uuid.UUID, role model.PermissionRole) (bool, error) {
+	rank := map[model.PermissionRole]int{
+		model.PermissionOwner:  3,
+		model.PermissionEditor: 2,
+		model.PermissionViewer: 1,
+	}
+	return rank[r.store.role(resourceType, resourceID, userID)] >= rank[role] && rank[role] > 0, nil
+}
+
+func (r *fakePermissionRepo) Create(ctx context.Context, permission *model.Permission) error {
+	copied := *permission
+	r.store.permissions[permission.ID] = &copied
+	if permission.UserID != nil {
+		r.store.grant(permission.ResourceID, *permission.UserID, permission.Role)
+	}
+	return nil
+}
+
+func (r *fakePermissionRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.Permission, error) {
+	permission, ok := r.store.permissions[id]
+	if !ok {
+		return nil, fmt.Errorf("permission not found")
+	}
+	copied := *permission
+	return &copied, nil
+}
+
+func (r *fakePermissionRepo) GetByResource(ctx context.Context, resourceID uuid.UUID, resourceType model.ResourceType) ([]*model.Permission, error) {
+	var permissions []*model.Permission
+	for _, permission := range r.store.permissions {
+		if permission.ResourceID == resourceID && permission.ResourceType == resourceType {
+			copied := *permission
+			permissions = append(permissions, &copied)
+		}
+	}
+	return permissions, nil
+}
+
+func (r *fakePermissionRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	if permission, ok := r.store.permissions[id]; ok && permission.UserID != nil {
+		delete(r.store.grants[permission.ResourceID], *permission.UserID)
+	}
+	delete(r.store.permissions, id)
+	return nil
+}
+
+type fakeUserRepo struct {
+	repository.UserRepository
+	store *fakeStore
+}
+
+func (r *fakeUserRepo) Record(ctx context.Context, tenantID, userID uuid.UUID, email string, seenAt time.Time) error {
+	r.store.users[[2]uuid.UUID{tenantID, userID}] = true
+	return nil
+}
+
+func (r *fakeUserRepo) IsMember(ctx context.Context, tenantID, userID uuid.UUID) (bool, error) {
+	return r.store.users[[2]uuid.UUID{tenantID, userID}], nil
+}
+
+type fakeLockRepo struct {
+	repository.LockRepository
+	store *fakeStore
+}
+
+func (r *fakeLockRepo) GetByFile(ctx context.Context, fileID uuid.UUID) ([]*model.FileLock, error) {
+	var locks []*model.FileLock
+	for _, lock := range r.store.locks {
+		if lock.FileID == fileID {
+			locks = append(locks, lock)
+		}
+	}
+	return locks, nil
+}
+
+// Acquire takes the lock unless another user's lock conflicts with it, as
+// the database's lock constraints do
+func (r *fakeLockRepo) Acquire(ctx context.Context, lock *model.FileLock) error {
+	for id, held := range r.store.locks {
+		if held.FileID != lock.FileID {
+			continue
+		}
+		if held.UserID == lock.UserID {
+			delete(r.store.locks, id)
+			continue
+		}
+		if held.Type == model.LockTypeExclusive || lock.Type == model.LockTypeExclusive {
+			return repository.ErrLockConflict
+		}
+	}
+
+	copied := *lock
+	r.store.locks[lock.ID] = &copied
+	return nil
+}
+
+func (r *fakeLockRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.FileLock, error) {
+	lock, ok := r.store.locks[id]
+	if !ok {
+		return nil, repository.ErrLockNotFound
+	}
+	copied := *lock
+	return &copied, nil
+}
+
+func (r *fakeLockRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	delete(r.store.locks, id)
+	return nil
+}
+
+type fakeVersionRepo struct {
+	repository.VersionRepository
+	store *fakeStore
+}
+
+func (r *fakeVersionRepo) Create(ctx context.Context, version *model.FileVersion) error {
+	copied := *version
+	r.store.versions[version.FileID] = append(r.store.versions[version.FileID], &copied)
+	return nil
+}
+
+func (r *fakeVersionRepo) GetByVersion(ctx context.Context, fileID uuid.UUID, versionNum int) (*model.FileVersion, error) {
+	for _, version := range r.store.versions[fileID] {
+		if version.VersionNum == versionNum {
+			copied := *version
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("version not found")
+}
+
+func (r *fakeVersionRepo) GetLatestVersionNum(ctx context.Context, fileID uuid.UUID) (int, error) {
+	versions := r.store.versions[fileID]
+	if len(versions) == 0 {
+		return 0, nil
+	}
+	return versions[len(versions)-1].VersionNum, nil
+}
+
+// fakeChangeRepo keeps each user's journal of changes. It is locked, since
+// long polls read cursors while changes are recorded.
+type fakeChangeRepo struct {
+	repository.ChangeRepository
+	store   *fakeStore
+	mu      sync.Mutex
+	changes map[uuid.UUID][]*model.Change
+	cursors map[uuid.UUID]*model.ChangeCursor
+}
+
+func newFakeChangeRepo(store *fakeStore) *fakeChangeRepo {
+	return &fakeChangeRepo{
+		store:   store,
+		changes: make(map[uuid.UUID][]*model.Change),
+		cursors: make(map[uuid.UUID]*model.ChangeCursor),
+	}
+}
+
+func (r *fakeChangeRepo) Record(ctx context.Context, change *model.Change, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recipients := []uuid.UUID{}
+	seen := make(map[uuid.UUID]bool)
+	for _, userID := range append(r.store.viewers(change.ResourceType, change.ResourceID), userIDs...) {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+
+		if r.cursors[userID] == nil {
+			r.cursors[userID] = &model.ChangeCursor{}
+		}
+		r.cursors[userID].LastSeq++
+		recorded := *change
+		recorded.UserID, recorded.Seq = userID, r.cursors[userID].LastSeq
+		r.changes[userID] = append(r.changes[userID], &recorded)
+		recipients = append(recipients, userID)
+	}
+	return recipients, nil
+}
+
+func (r *fakeChangeRepo) GetAudience(ctx context.Context, resourceType model.ResourceType, resourceID uuid.UUID) ([]uuid.UUID, error) {
+	return r.store.viewers(resourceType, resourceID), nil
+}
+
+func (r *fakeChangeRepo) GetCursor(ctx context.Context, userID uuid.UUID) (*model.ChangeCursor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cursor, ok := r.cursors[userID]; ok {
+		copied := *cursor
+		return &copied, nil
+	}
+	return &model.ChangeCursor{}, nil
+}
+
+func (r *fakeChangeRepo) List(ctx context.Context, userID uuid.UUID, afterSeq int64, limit int) ([]*model.Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changes := []*model.Change{}
+	for _, change := range r.changes[userID] {
+		if change.Seq > afterSeq && len(changes) < limit {
+			copied := *change
+			copied.Visible = r.store.role(change.ResourceType, change.ResourceID, userID) != ""
+			changes = append(changes, &copied)
+		}
+	}
+	return changes, nil
+}
+
+func (r *fakeChangeRepo) Prune(ctx context.Context, before time.Time, limit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for userID, changes := range r.changes {
+		kept := changes[:0]
+		for _, change := range changes {
+			if change.CreatedAt.Before(before) && deleted < limit {
+				r.cursors[userID].PrunedSeq = max(r.cursors[userID].PrunedSeq, change.Seq)
+				deleted++
+				continue
+			}
+			kept = append(kept, change)
+		}
+		r.changes[userID] = kept
+	}
+	return deleted, nil
+}
+
+// fakeActivityRepo keeps the activity log in the order it was recorded
+type fakeActivityRepo struct {
+	repository.ActivityRepository
+	activities []*model.Activity
+}
+
+func (r *fakeActivityRepo) Record(ctx context.Context, activity *model.Activity) error {
+	copied := *activity
+	r.activities = append(r.activities, &copied)
+	return nil
+}
+
+func (r *fakeActivityRepo) Query(ctx context.Context, tenantID uuid.UUID, query *model.ActivityQuery, after *model.ActivityCursor, limit int) ([]*model.Activity, error) {
+	var activities []*model.Activity
+	for i := len(r.activities) - 1; i >= 0 && len(activities) < limit; i-- {
+		activity := r.activities[i]
+		switch {
+		case activity.TenantID != tenantID,
+			query.FileID != nil && activity.ResourceID != *query.FileID,
+			query.ActorID != nil && (activity.ActorID == nil || *activity.ActorID != *query.ActorID),
+			len(query.Actions) > 0 && !slices.Contains(query.Actions, activity.Action),
+			after != nil && !activity.CreatedAt.Before(after.CreatedAt) &&
+				!(activity.CreatedAt.Equal(after.CreatedAt) && activity.ID.String() < after.ID.String()):
+			continue
+		}
+		activities = append(activities, activity)
+	}
+	return activities, nil
+}
+
+type quotaKey struct {
+	tenantID uuid.UUID
+	scope    model.QuotaScope
+	scopeID  uuid.UUID
+}
+
+// fakeQuotaRepo holds quotas and the usage of users and tenants, which
+// tests set directly
+type fakeQuotaRepo struct {
+	repository.QuotaRepository
+	quotas      map[quotaKey]*model.Quota
+	userUsage   map[uuid.UUID]int64
+	tenantUsage map[uuid.UUID]int64
+	thresholds  map[uuid.UUID]int
+	events      []*model.QuotaEvent
+}
+
+func newFakeQuotaRepo() *fakeQuotaRepo {
+	return &fakeQuotaRepo{
+		quotas:      make(map[quotaKey]*model.Quota),
+		userUsage:   make(map[uuid.UUID]int64),
+		tenantUsage: make(map[uuid.UUID]int64),
+		thresholds:  make(map[uuid.UUID]int),
+	}
+}
+
+func (r *fakeQuotaRepo) GetUserUsage(ctx context.Context, tenantID, userID uuid.UUID) (*model.StorageUsage, error) {
+	return &model.StorageUsage{Used: r.userUsage[userID]}, nil
+}
+
+func (r *fakeQuotaRepo) GetTenantUsage(ctx context.Context, tenantID uuid.UUID) (int64, error) {
+	return r.tenantUsage[tenantID], nil
+}
+
+func (r *fakeQuotaRepo) HoldsContent(ctx context.Context, tenantID, userID uuid.UUID, storagePath string) (bool, bool, error) {
+	return false, false, nil
+}
+
+func (r *fakeQuotaRepo) GetQuota(ctx context.Context, tenantID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID) (*model.Quota, error) {
+	return r.quotas[quotaKey{tenantID, scope, scopeID}], nil
+}
+
+func (r *fakeQuotaRepo) SetQuota(ctx context.Context, tenantID uuid.UUID, quota *model.Quota) error {
+	copied := *quota
+	r.quotas[quotaKey{tenantID, quota.Scope, quota.ScopeID}] = &copied
+	return nil
+}
+
+func (r *fakeQuotaRepo) DeleteQuota(ctx context.Context, tenantID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID) error {
+	delete(r.quotas, quotaKey{tenantID, scope, scopeID})
+	return nil
+}
+
+func (r *fakeQuotaRepo) SetThreshold(ctx context.Context, scope model.QuotaScope, scopeID uuid.UUID, threshold int) (bool, error) {
+	raised := threshold > r.thresholds[scopeID]
+	r.thresholds[scopeID] = threshold
+	return raised, nil
+}
+
+func (r *fakeQuotaRepo) CreateEvent(ctx context.Context, event *model.QuotaEvent) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+// fakeUploadRepo stores copies of upload sessions, as the database would
+type fakeUploadRepo struct {
+	repository.UploadSessionRepository
+	sessions map[uuid.UUID]*model.UploadSession
+	parts    map[uuid.UUID][]*model.UploadPart
+}
+
+func newFakeUploadRepo() *fakeUploadRepo {
+	return &fakeUploadRepo{
+		sessions: make(map[uuid.UUID]*model.UploadSession),
+		parts:    make(map[uuid.UUID][]*model.UploadPart),
+	}
+}
+
+func (r *fakeUploadRepo) Create(ctx context.Context, session *model.UploadSession) error {
+	copied := *session
+	r.sessions[session.ID] = &copied
+	return nil
+}
+
+func (r *fakeUploadRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.UploadSession, error) {
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("upload session not found")
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (r *fakeUploadRepo) AddPart(ctx context.Context, session *model.UploadSession, previousOffset int64, part *model.UploadPart) (bool, error) {
+	stored, ok := r.sessions[session.ID]
+	if !ok || stored.Offset != previousOffset {
+		return false, nil
+	}
+	copied := *session
+	r.sessions[session.ID] = &copied
+	r.parts[session.ID] = append(r.parts[session.ID], part)
+	return true, nil
+}
+
+func (r *fakeUploadRepo) GetParts(ctx context.Context, sessionID uuid.UUID) ([]*model.UploadPart, error) {
+	return r.parts[sessionID], nil
+}
+
+func (r *fakeUploadRepo) MarkCompleted(ctx context.Context, id uuid.UUID, expiresAt time.Time) error {
+	if session, ok := r.sessions[id]; ok {
+		session.Status = model.UploadStatusCompleted
+		session.ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+func (r *fakeUploadRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	delete(r.sessions, id)
+	delete(r.parts, id)
+	return nil
+}
+
+func (r *fakeUploadRepo) GetExpired(ctx context.Context, before time.Time, limit int) ([]*model.UploadSession, error) {
+	var expired []*model.UploadSession
+	for _, session := range r.sessions {
+		if session.ExpiresAt.Before(before) && len(expired) < limit {
+			copied := *session
+			expired = append(expired, &copied)
+		}
+	}
+	return expired, nil
+}
+
+// fakeBlobRepo holds blobs by tenant and checksum
+type fakeBlobRepo struct {
+	repository.BlobRepository
+	blobs map[string]*model.Blob
+}
+
+func newFakeBlobRepo() *fakeBlobRepo {
+	return &fakeBlobRepo{blobs: make(map[string]*model.Blob)}
+}
+
+func (r *fakeBlobRepo) Create(ctx context.Context, blob *model.Blob) error {
+	copied := *blob
+	r.blobs[blob.TenantID.String()+"/"+blob.Checksum] = &copied
+	return nil
+}
+
+func (r *fakeBlobRepo) Touch(ctx context.Context, tenantID uuid.UUID, checksum string) (*model.Blob, error) {
+	blob, ok := r.blobs[tenantID.String()+"/"+checksum]
+	if !ok {
+		return nil, fmt.Errorf("blob not found")
+	}
+	copied := *blob
+	return &copied, nil
+}
+
+// fakePreviewRepo holds previews by tenant and checksum
+type fakePreviewRepo struct {
+	repository.PreviewRepository
+	previews map[string]*model.Preview
+}
+
+func newFakePreviewRepo() *fakePreviewRepo {
+	return &fakePreviewRepo{previews: make(map[string]*model.Preview)}
+}
+
+func (r *fakePreviewRepo) Request(ctx context.Context, preview *model.Preview) (*model.Preview, error) {
+	key := preview.TenantID.String() + "/" + preview.Checksum
+	if _, ok := r.previews[key]; !ok {
+		queued := *preview
+		queued.Status = model.PreviewStatusPending
+		queued.RunAt = time.Now()
+		r.previews[key] = &queued
+	}
+	copied := *r.previews[key]
+	return &copied, nil
+}
+
+func (r *fakePreviewRepo) Claim(ctx context.Context, limit int, leaseUntil time.Time) ([]*model.Preview, error) {
+	var claimed []*model.Preview
+	for _, preview := range r.previews {
+		if preview.IsPending() && !preview.RunAt.After(time.Now()) && len(claimed) < limit {
+			preview.Status = model.PreviewStatusProcessing
+			preview.Attempts++
+			preview.RunAt = leaseUntil
+			copied := *preview
+			claimed = append(claimed, &copied)
+		}
+	}
+	return claimed, nil
+}
+
+func (r *fakePreviewRepo) Complete(ctx context.Context, preview *model.Preview) error {
+	copied := *preview
+	r.previews[preview.TenantID.String()+"/"+preview.Checksum] = &copied
+	return nil
+}
+
+func (r *fakePreviewRepo) Retry(ctx context.Context, preview *model.Preview, runAt time.Time) error {
+	if stored, ok := r.previews[preview.TenantID.String()+"/"+preview.Checksum]; ok {
+		stored.Status = model.PreviewStatusPending
+		stored.LastError = preview.LastError
+		stored.RunAt = runAt
+	}
+	return nil
+}
+
+// fakeSearchRepo records the text indexed for each content
+type fakeSearchRepo struct {
+	repository.SearchRepository
+	content map[string]string
+}
+
+func (r *fakeSearchRepo) IndexContent(ctx context.Context, tenantID uuid.UUID, checksum, content, language string) error {
+	if r.content == nil {
+		r.content = make(map[string]string)
+	}
+	r.content[tenantID.String()+"/"+checksum] = content
+	return nil
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/repository"
+)
+
+// maxCommentLength is the longest check-in comment, in bytes
+const maxCommentLength = 1000
+
+var (
+	ErrLockNotFound    = repository.ErrLockNotFound
+	ErrFileLocked      = errors.New("file is locked")
+	ErrInvalidLock     = errors.New("invalid lock")
+	ErrNotCheckedOut   = errors.New("file is not checked out")
+	ErrCommentRequired = errors.New("a comment is required to check in")
+)
+
+// LockFile locks a file for the user, who must be able to edit it, or
+// refreshes or changes the lock they hold. It fails with ErrFileLocked if
+// another user holds a lock it conflicts with.
+func (s *driveService) LockFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, req *model.LockFileRequest) (*model.FileLock, error) {
+	lockType := req.Type
+	if lockType == "" {
+		lockType = model.LockTypeExclusive
+	}
+	if lockType != model.LockTypeExclusive && lockType != model.LockTypeShared {
+		return nil, fmt.Errorf("%w: type must be exclusive or shared", ErrInvalidLock)
+	}
+
+	file, own, err := s.getLockableFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := s.newLock(file, userID, lockType, req.Duration, s.lockConfig.DefaultDuration)
+	if own != nil && own.CheckedOut {
+		// Locking a checked out file keeps it checked out
+		if lockType != model.LockTypeExclusive {
+			return nil, fmt.Errorf("%w: the file is checked out; check it in first", ErrInvalidLock)
+		}
+		lock.CheckedOut = true
+		lock.BaseVersion = own.BaseVersion
+	}
+
+	if err := s.acquireLock(ctx, lock); err != nil {
+		return nil, err
+	}
+
+	s.logFileActivity(ctx, file, userID, model.ActivityLocked, lockDetails(lock))
+
+	return lock, nil
+}
+
+// ListLocks returns the locks held on a file the user can view
+func (s *driveService) ListLocks(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) ([]*model.FileLock, error) {
+	if _, err := s.getFile(ctx, fileID, userID); err != nil {
+		return nil, err
+	}
+
+	return s.lockRepo.GetByFile(ctx, fileID)
+}
+
+// UnlockFile releases a lock on a file. Holders release their own locks;
+// owners of the file, including owners of a folder it is in, can break
+// anyone's. Releasing a check-out discards it.
+func (s *driveService) UnlockFile(ctx context.Context, fileID, lockID uuid.UUID, userID uuid.UUID) error {
+	lock, err := s.lockRepo.GetByID(ctx, lockID)
+	if err != nil {
+		return err
+	}
+	if lock.FileID != fileID {
+		return ErrLockNotFound
+	}
+
+	file, err := s.fileRepo.GetByID(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	if lock.UserID != userID {
+		isOwner, err := s.permissionRepo.HasPermission(ctx, fileID, model.ResourceTypeFile, userID, model.PermissionOwner)
+		if err != nil || !isOwner {
+			return ErrPermissionDenied
+		}
+	}
+
+	if err := s.lockRepo.Delete(ctx, lock.ID); err != nil {
+		return err
+	}
+
+	details := lockDetails(lock)
+	details["holder_id"] = lock.UserID
+	details["forced"] = lock.UserID != userID
+	s.logFileActivity(ctx, file, userID, model.ActivityUnlocked, details)
+
+	return nil
+}
+
+// CheckOut checks a file out to the user: it is locked exclusively until
+// they check it in, or the check-out expires
+func (s *driveService) CheckOut(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, req *model.CheckOutRequest) (*model.FileLock, error) {
+	file, own, err := s.getLockableFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := s.newLock(file, userID, model.LockTypeExclusive, req.Duration, s.lockConfig.CheckOutDuration)
+	lock.CheckedOut = true
+	if own != nil && own.CheckedOut {
+		lock.BaseVersion = own.BaseVersion
+	}
+
+	if err := s.acquireLock(ctx, lock); err != nil {
+		return nil, err
+	}
+
+	s.logFileActivity(ctx, file, userID, model.ActivityCheckedOut, lockDetails(lock))
+
+	return lock, nil
+}
+
+// CheckIn checks in a file the user has checked out and releases it. The
+// comment is recorded on the version it adds from reader, or, without new
+// content, on the latest version the user uploaded while the file was
+// checked out. Reader may be nil if the file has not changed.
+func (s *driveService) CheckIn(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, comment string, reader io.Reader, size int64, contentType string) (*model.File, error) {
+	comment = strings.TrimSpace(comment)
+	if comment == "" {
+		return nil, ErrCommentRequired
+	}
+	if len(comment) > maxCommentLength {
+		return nil, fmt.Errorf("%w: comment must be at most %d bytes", ErrInvalidLock, maxCommentLength)
+	}
+
+	file, own, err := s.getLockableFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if own == nil || !own.CheckedOut {
+		return nil, ErrNotCheckedOut
+	}
+
+	switch {
+	case reader != nil:
+		if file, err = s.addVersion(ctx, file, userID, reader, size, contentType, &comment, ""); err != nil {
+			return nil, err
+		}
+	case file.Version > own.BaseVersion:
+		version, err := s.versionRepo.GetByVersion(ctx, fileID, file.Version)
+		if err != nil {
+			return nil, err
+		}
+		version.Comment = &comment
+		if err := s.versionRepo.Update(ctx, version); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.lockRepo.Delete(ctx, own.ID); err != nil {
+		return nil, err
+	}
+
+	s.logFileActivity(ctx, file, userID, model.ActivityCheckedIn, model.Metadata{
+		"lock_id": own.ID,
+		"version": file.Version,
+		"comment": comment,
+	})
+
+	return file, nil
+}
+
+// getLockableFile returns a file the user can edit, and the lock they hold
+// on it, if any
+func (s *driveService) getLockableFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) (*model.File, *model.FileLock, error) {
+	file, err := s.fileRepo.GetByID(ctx, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if file.OwnerID != userID {
+		hasPermission, err := s.permissionRepo.HasPermission(ctx, fileID, model.ResourceTypeFile, userID, model.PermissionEditor)
+		if err != nil || !hasPermission {
+			return nil, nil, ErrPermissionDenied
+		}
+	}
+
+	locks, err := s.lockRepo.GetByFile(ctx, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, lock := range locks {
+		if lock.UserID == userID {
+			return file, lock, nil
+		}
+	}
+
+	return file, nil, nil
+}
+
+// newLock returns a lock on file for the user lasting the requested
+// duration in seconds, or defaultDuration, up to the configured maximum
+func (s *driveService) newLock(file *model.File, userID uuid.UUID, lockType model.LockType, seconds int, defaultDuration time.Duration) *model.FileLock {
+	duration := defaultDuration
+	if seconds > 0 {
+		duration = time.Duration(seconds) * time.Second
+	}
+	duration = min(duration, s.lockConfig.MaxDuration)
+
+	now := time.Now()
+	return &model.FileLock{
+		ID:          uuid.New(),
+		TenantID:    file.TenantID,
+		FileID:      file.ID,
+		UserID:      userID,
+		Type:        lockType,
+		BaseVersion: file.Version,
+		ExpiresAt:   now.Add(duration),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+func (s *driveService) acquireLock(ctx context.Context, lock *model.FileLock) error {
+	err := s.lockRepo.Acquire(ctx, lock)
+	if !errors.Is(err, repository.ErrLockConflict) {
+		return err
+	}
+
+	// Name the lock in the way
+	locks, err := s.lockRepo.GetByFile(ctx, lock.FileID)
+	if err != nil {
+		return err
+	}
+	for _, held := range locks {
+		if held.UserID != lock.UserID && (lock.Type == model.LockTypeExclusive || held.Type == model.LockTypeExclusive) {
+			return lockedError(held)
+		}
+	}
+	return fmt.Errorf("%w: file %s has a conflicting lock", ErrFileLocked, lock.FileID)
+}
+
+// checkLock returns ErrFileLocked unless userID may change the file: if
+// another user holds an exclusive lock on it, or other users hold shared
+// locks and userID holds none
+func (s *driveService) checkLock(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) error {
+	locks, err := s.lockRepo.GetByFile(ctx, fileID)
+	if err != nil {
+		return err
+	}
+
+	holder := false
+	var shared *model.FileLock
+	for _, lock := range locks {
+		switch {
+		case lock.UserID == userID:
+			holder = true
+		case lock.Type == model.LockTypeExclusive:
+			return lockedError(lock)
+		case shared == nil:
+			shared = lock
+		}
+	}
+
+	if shared != nil && !holder {
+		return lockedError(shared)
+	}
+	return nil
+}
+
+// checkFolderLocks returns ErrFileLocked if a user other than userID holds
+// a lock on a file in the folders
+func (s *driveService) checkFolderLocks(ctx context.Context, folderIDs []uuid.UUID, userID uuid.UUID) error {
+	locks, err := s.lockRepo.GetHeldInFolders(ctx, folderIDs, userID)
+	if err != nil {
+		return err
+	}
+
+	if len(locks) > 0 {
+		return lockedError(locks[0])
+	}
+	return nil
+}
+
+// lockDetails describes a lock in the activity log
+func lockDetails(lock *model.FileLock) model.Metadata {
+	return model.Metadata{
+		"lock_id":    lock.ID,
+		"type":       lock.Type,
+		"expires_at": lock.ExpiresAt,
+	}
+}
+
+func lockedError(lock *model.FileLock) error {
+	if lock.CheckedOut {
+		return fmt.Errorf("%w: file %s is checked out by user %s until %s",
+			ErrFileLocked, lock.FileID, lock.UserID, lock.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	return fmt.Errorf("%w: file %s has a %s lock held by user %s until %s",
+		ErrFileLocked, lock.FileID, lock.Type, lock.UserID, lock.ExpiresAt.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/config"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+// lockFixture is Alice's file in Dave's folder, which Bob, Carol and Eve
+// may edit
+type lockFixture struct {
+	store                        *fakeStore
+	s                            *driveService
+	alice, bob, carol, dave, eve uuid.UUID
+	file                         *model.File
+}
+
+func newLockFixture() *lockFixture {
+	f := &lockFixture{
+		store: newFakeStore(),
+		alice: uuid.New(), bob: uuid.New(), carol: uuid.New(), dave: uuid.New(), eve: uuid.New(),
+	}
+	f.s = newTestService(f.store)
+	f.s.lockConfig = config.LockConfig{DefaultDuration: time.Hour, MaxDuration: time.Hour}
+
+	folder := f.store.addFolder(f.dave, nil)
+	f.file = f.store.addFile(f.alice, &folder.ID)
+	for _, userID := range []uuid.UUID{f.bob, f.carol, f.eve} {
+		f.store.grant(f.file.ID, userID, model.PermissionEditor)
+	}
+	return f
+}
+
+func (f *lockFixture) lock(t *testing.T, userID uuid.UUID, lockType model.LockType) *model.FileLock {
+	t.Helper()
+
+	lock, err := f.s.LockFile(context.Background(), f.file.ID, userID, &model.LockFileRequest{Type: lockType})
+	if err != nil {
+		t.Fatalf("LockFile(%s): %v", lockType, err)
+	}
+	return lock
+}
+
+func (f *lockFixture) rename(userID uuid.UUID) error {
+	name := uuid.NewString() + ".txt"
+	_, err := f.s.UpdateFile(context.Background(), f.file.ID, userID, &model.UpdateFileRequest{Name: &name}, "")
+	return err
+}
+
+func TestSharedLockHolders(t *testing.T) {
+	ctx := context.Background()
+	f := newLockFixture()
+	f.lock(t, f.bob, model.LockTypeShared)
+	f.lock(t, f.carol, model.LockTypeShared)
+
+	for name, userID := range map[string]uuid.UUID{"bob": f.bob, "carol": f.carol} {
+		if err := f.rename(userID); err != nil {
+			t.Errorf("shared holder %s changing the file: %v", name, err)
+		}
+	}
+	for name, userID := range map[string]uuid.UUID{"eve": f.eve, "alice": f.alice} {
+		if err := f.rename(userID); !errors.Is(err, ErrFileLocked) {
+			t.Errorf("%s without a lock changing the file: got %v, want %v", name, err, ErrFileLocked)
+		}
+	}
+
+	// Neither can lock it exclusively while the other holds a shared lock
+	_, err := f.s.LockFile(ctx, f.file.ID, f.bob, &model.LockFileRequest{Type: model.LockTypeExclusive})
+	if !errors.Is(err, ErrFileLocked) {
+		t.Errorf("exclusive lock over another shared lock: got %v, want %v", err, ErrFileLocked)
+	}
+}
+
+func TestExclusiveLockHolder(t *testing.T) {
+	f := newLockFixture()
+	f.lock(t, f.bob, model.LockTypeExclusive)
+
+	if err := f.rename(f.bob); err != nil {
+		t.Errorf("exclusive holder changing the file: %v", err)
+	}
+	if err := f.rename(f.carol); !errors.Is(err, ErrFileLocked) {
+		t.Errorf("another user changing the file: got %v, want %v", err, ErrFileLocked)
+	}
+
+	_, err := f.s.LockFile(context.Background(), f.file.ID, f.carol, &model.LockFileRequest{Type: model.LockTypeShared})
+	if !errors.Is(err, ErrFileLocked) {
+		t.Errorf("shared lock over an exclusive lock: got %v, want %v", err, ErrFileLocked)
+	}
+}
+
+func TestUnlockFileForce(t *testing.T) {
+	ctx := context.Background()
+	f := newLockFixture()
+	lock := f.lock(t, f.bob, model.LockTypeExclusive)
+
+	// An editor cannot break someone else's lock
+	if err := f.s.UnlockFile(ctx, f.file.ID, lock.ID, f.carol); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("editor breaking a lock: got %v, want %v", err, ErrPermissionDenied)
+	}
+
+	// The owner of the folder the file is in owns the file too
+	if err := f.s.UnlockFile(ctx, f.file.ID, lock.ID, f.dave); err != nil {
+		t.Errorf("folder owner breaking a lock: %v", err)
+	}
+	if _, ok := f.store.locks[lock.ID]; ok {
+		t.Error("lock not released")
+	}
+
+	lock = f.lock(t, f.bob, model.LockTypeExclusive)
+	if err := f.s.UnlockFile(ctx, f.file.ID, lock.ID, f.alice); err != nil {
+		t.Errorf("file owner breaking a lock: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+func TestGrantPermissionRequiresOwner(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	tenantID := uuid.New()
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	for _, user := range []uuid.UUID{alice, bob, carol} {
+		store.users[[2]uuid.UUID{tenantID, user}] = true
+	}
+
+	folder := store.addFolder(alice, nil)
+	folder.TenantID = tenantID
+	store.grant(folder.ID, bob, model.PermissionEditor)
+
+	// An editor cannot make themselves an owner
+	selfGrant := &model.CreatePermissionRequest{
+		ResourceID:   folder.ID,
+		ResourceType: model.ResourceTypeFolder,
+		UserID:       &bob,
+		Role:         model.PermissionOwner,
+	}
+	if _, err := s.GrantPermission(ctx, tenantID, bob, selfGrant); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("editor granting themselves owner: got %v, want %v", err, ErrPermissionDenied)
+	}
+	if got := store.role(model.ResourceTypeFolder, folder.ID, bob); got != model.PermissionEditor {
+		t.Errorf("editor has role %q after a denied grant, want %q", got, model.PermissionEditor)
+	}
+
+	// Nor can someone with no access grant themselves any
+	selfGrant.UserID = &carol
+	selfGrant.Role = model.PermissionViewer
+	if _, err := s.GrantPermission(ctx, tenantID, carol, selfGrant); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("stranger granting themselves viewer: got %v, want %v", err, ErrPermissionDenied)
+	}
+
+	grant := &model.CreatePermissionRequest{
+		ResourceID:   folder.ID,
+		ResourceType: model.ResourceTypeFolder,
+		UserID:       &carol,
+		Role:         model.PermissionViewer,
+	}
+	if _, err := s.GrantPermission(ctx, tenantID, alice, grant); err != nil {
+		t.Fatalf("owner sharing a folder: %v", err)
+	}
+	if got := store.role(model.ResourceTypeFolder, folder.ID, carol); got != model.PermissionViewer {
+		t.Errorf("grantee has role %q, want %q", got, model.PermissionViewer)
+	}
+}
+
+func TestGrantPermissionAcrossTenants(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	tenantA, tenantB := uuid.New(), uuid.New()
+	alice, mallory := uuid.New(), uuid.New()
+	store.users[[2]uuid.UUID{tenantA, alice}] = true
+	store.users[[2]uuid.UUID{tenantB, mallory}] = true
+
+	folder := store.addFolder(alice, nil)
+	folder.TenantID = tenantA
+
+	// A user of another tenant cannot share the folder with themselves
+	grant := &model.CreatePermissionRequest{
+		ResourceID:   folder.ID,
+		ResourceType: model.ResourceTypeFolder,
+		UserID:       &mallory,
+		Role:         model.PermissionEditor,
+	}
+	if _, err := s.GrantPermission(ctx, tenantB, mallory, grant); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("granting on another tenant's folder: got %v, want %v", err, ErrPermissionDenied)
+	}
+
+	// Nor can its owner share it with a user of another tenant
+	if _, err := s.GrantPermission(ctx, tenantA, alice, grant); !errors.Is(err, ErrInvalidPermission) {
+		t.Errorf("granting to another tenant's user: got %v, want %v", err, ErrInvalidPermission)
+	}
+	if len(store.permissions) != 0 {
+		t.Errorf("%d permissions were created, want none", len(store.permissions))
+	}
+}
+
+func TestRevokeAndListPermissionsRequireAccess(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	tenantID := uuid.New()
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	for _, user := range []uuid.UUID{alice, bob, carol} {
+		store.users[[2]uuid.UUID{tenantID, user}] = true
+	}
+
+	file := store.addFile(alice, nil)
+	file.TenantID = tenantID
+	permission, err := s.GrantPermission(ctx, tenantID, alice, &model.CreatePermissionRequest{
+		ResourceID:   file.ID,
+		ResourceType: model.ResourceTypeFile,
+		UserID:       &bob,
+		Role:         model.PermissionEditor,
+	})
+	if err != nil {
+		t.Fatalf("granting permission: %v", err)
+	}
+
+	if _, err := s.ListPermissions(ctx, tenantID, file.ID, model.ResourceTypeFile, carol); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("stranger listing permissions: got %v, want %v", err, ErrPermissionDenied)
+	}
+	if _, err := s.ListPermissions(ctx, uuid.New(), file.ID, model.ResourceTypeFile, alice); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("listing permissions from another tenant: got %v, want %v", err, ErrPermissionDenied)
+	}
+	permissions, err := s.ListPermissions(ctx, tenantID, file.ID, model.ResourceTypeFile, bob)
+	if err != nil || len(permissions) != 1 {
+		t.Errorf("editor listing permissions: got %d, %v, want 1 permission", len(permissions), err)
+	}
+
+	if err := s.RevokePermission(ctx, tenantID, permission.ID, bob); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("editor revoking a permission: got %v, want %v", err, ErrPermissionDenied)
+	}
+	if err := s.RevokePermission(ctx, uuid.New(), permission.ID, alice); !errors.Is(err, ErrPermissionNotFound) {
+		t.Errorf("revoking a permission from another tenant: got %v, want %v", err, ErrPermissionNotFound)
+	}
+	if _, ok := store.permissions[permission.ID]; !ok {
+		t.Fatal("permission was deleted by a denied revoke")
+	}
+
+	if err := s.RevokePermission(ctx, tenantID, permission.ID, alice); err != nil {
+		t.Fatalf("owner revoking a permission: %v", err)
+	}
+	if got := store.role(model.ResourceTypeFile, file.ID, bob); got != "" {
+		t.Errorf("grantee has role %q after revoke, want none", got)
+	}
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/preview"
+	"github.com/nexus/drive-service/internal/storage"
+)
+
+const (
+	previewMaxAttempts = 3
+	// previewLease is how long a worker may take to generate a preview
+	// before it is presumed dead and another worker takes over
+	previewLease = 10 * time.Minute
+
+	previewTextName        = "text.txt"
+	previewTextContentType = "text/plain; charset=utf-8"
+)
+
+var ErrPreviewNotFound = errors.New("preview not available")
+
+// GetFilePreview returns the preview of a file's content. Previews are
+// generated in the background, so it may still be pending; content that
+// has never been queued, such as content stored before previews existed,
+// is queued now. Files stored before deduplication have no previews.
+func (s *driveService) GetFilePreview(ctx context.Context, fileID uuid.UUID, userID uuid.UUID) (*model.Preview, error) {
+	file, err := s.fileRepo.GetByID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked here rather than with GetFile, so that viewing thumbnails
+	// does not count as accessing the file
+	if file.OwnerID != userID {
+		hasPermission, err := s.permissionRepo.HasPermission(ctx, fileID, model.ResourceTypeFile, userID, model.PermissionViewer)
+		if err != nil || !hasPermission {
+			return nil, ErrPermissionDenied
+		}
+	}
+
+	stored := s.requestPreview(ctx, file)
+	if stored == nil {
+		return nil, ErrPreviewNotFound
+	}
+	return stored, nil
+}
+
+// DownloadThumbnail downloads the thumbnail of the given size of a preview
+// returned by GetFilePreview
+func (s *driveService) DownloadThumbnail(ctx context.Context, stored *model.Preview, size int) (io.ReadCloser, error) {
+	if !hasThumbnailSize(stored, size) {
+		return nil, ErrPreviewNotFound
+	}
+	return s.storage.DownloadFile(ctx, storage.GetPreviewPath(stored.TenantID, stored.Checksum, thumbnailName(size)))
+}
+
+// DownloadPreviewText downloads the text extracted into a preview returned
+// by GetFilePreview
+func (s *driveService) DownloadPreviewText(ctx context.Context, stored *model.Preview) (io.ReadCloser, error) {
+	if stored.Status != model.PreviewStatusReady || !stored.HasText {
+		return nil, ErrPreviewNotFound
+	}
+	return s.storage.DownloadFile(ctx, storage.GetPreviewPath(stored.TenantID, stored.Checksum, previewTextName))
+}
+
+// GeneratePreviews generates up to limit queued previews and returns the
+// number of previews it tried to generate. Previews that fail are retried
+// with backoff, up to previewMaxAttempts times.
+func (s *driveService) GeneratePreviews(ctx context.Context, limit int) (int, error) {
+	jobs, err := s.previewRepo.Claim(ctx, limit, time.Now().Add(previewLease))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, job := range jobs {
+		if err := s.generatePreview(ctx, job); err != nil {
+			message := err.Error()
+			job.LastError = &message
+
+			if job.Attempts >= previewMaxAttempts {
+				job.Status = model.PreviewStatusFailed
+				_ = s.previewRepo.Complete(ctx, job)
+			} else {
+				_ = s.previewRepo.Retry(ctx, job, time.Now().Add(time.Duration(job.Attempts)*time.Minute))
+			}
+		}
+	}
+
+	return len(jobs), nil
+}
+
+// generatePreview generates and stores the thumbnails and text of a blob,
+// indexes the text for search and points the files with its content at the
+// default-size thumbnail
+func (s *driveService) generatePreview(ctx context.Context, job *model.Preview) error {
+	if job.Attempts > previewMaxAttempts {
+		return fmt.Errorf("gave up after %d attempts", previewMaxAttempts)
+	}
+	if job.Size > s.previewConfig.MaxSourceSize {
+		return s.completeUnsupportedPreview(ctx, job, preview.ErrTooLarge)
+	}
+
+	reader, err := s.storage.DownloadFile(ctx, storage.GetBlobPath(job.TenantID, job.Checksum))
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, s.previewConfig.MaxSourceSize))
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	result, err := preview.Generate(data, job.MimeType, job.FileName)
+	if errors.Is(err, preview.ErrUnsupported) || errors.Is(err, preview.ErrTooLarge) {
+		return s.completeUnsupportedPreview(ctx, job, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	job.ThumbnailSizes = nil
+	if result.Image != nil {
+		thumbnails, err := preview.Thumbnails(result.Image, s.previewConfig.ThumbnailSizes)
+		if err != nil {
+			return err
+		}
+		for _, size := range s.previewConfig.ThumbnailSizes {
+			thumbnail := thumbnails[size]
+			thumbnailPath := storage.GetPreviewPath(job.TenantID, job.Checksum, thumbnailName(size))
+			if err := s.storage.UploadObject(ctx, thumbnailPath, bytes.NewReader(thumbnail), int64(len(thumbnail)), preview.ThumbnailContentType); err != nil {
+				return err
+			}
+			job.ThumbnailSizes = append(job.ThumbnailSizes, int64(size))
+		}
+
+		bounds := result.Image.Bounds()
+		width, height := bounds.Dx(), bounds.Dy()
+		job.Width, job.Height = &width, &height
+	}
+
+	job.HasText = result.Text != ""
+	if job.HasText {
+		textPath := storage.GetPreviewPath(job.TenantID, job.Checksum, previewTextName)
+		if err := s.storage.UploadObject(ctx, textPath, bytes.NewReader([]byte(result.Text)), int64(len(result.Text)), previewTextContentType); err != nil {
+			return err
+		}
+		if err := s.indexContent(ctx, job.TenantID, job.Checksum, result.Text); err != nil {
+			return err
+		}
+	}
+
+	job.Status = model.PreviewStatusReady
+	job.LastError = nil
+	if err := s.previewRepo.Complete(ctx, job); err != nil {
+		return err
+	}
+
+	if thumbnailPath := defaultThumbnailPath(job); thumbnailPath != nil {
+		_ = s.fileRepo.SetThumbnailPath(ctx, job.TenantID, job.Checksum, thumbnailPath)
+	}
+	return nil
+}
+
+func (s *driveService) completeUnsupportedPreview(ctx context.Context, job *model.Preview, reason error) error {
+	message := reason.Error()
+	job.Status = model.PreviewStatusUnsupported
+	job.LastError = &message
+	return s.previewRepo.Complete(ctx, job)
+}
+
+// requestPreview queues generation of the preview of a file's content,
+// unless the content already has one, and returns the preview. If the
+// preview is ready the file is pointed at its thumbnail. Files stored
+// before deduplication have no checksum and no preview, so nil is returned.
+func (s *driveService) requestPreview(ctx context.Context, file *model.File) *model.Preview {
+	if file.Checksum == nil {
+		return nil
+	}
+
+	stored, err := s.previewRepo.Request(ctx, &model.Preview{
+		TenantID: file.TenantID,
+		Checksum: *file.Checksum,
+		MimeType: file.MimeType,
+		FileName: file.Name,
+		Size:     file.Size,
+	})
+	if err != nil {
+		return nil
+	}
+
+	if thumbnailPath := defaultThumbnailPath(stored); thumbnailPath != nil {
+		if err := s.fileRepo.SetThumbnailPath(ctx, file.TenantID, stored.Checksum, thumbnailPath); err == nil {
+			file.ThumbnailPath = thumbnailPath
+		}
+	}
+
+	return stored
+}
+
+// deletePreview deletes the preview and indexed text of a blob being
+// collected
+func (s *driveService) deletePreview(ctx context.Context, blob *model.Blob) {
+	_ = s.searchRepo.DeleteContent(ctx, blob.TenantID, blob.Checksum)
+
+	stored, err := s.previewRepo.Delete(ctx, blob.TenantID, blob.Checksum)
+	if err != nil {
+		return
+	}
+
+	for _, size := range stored.ThumbnailSizes {
+		_ = s.storage.DeleteFile(ctx, storage.GetPreviewPath(stored.TenantID, stored.Checksum, thumbnailName(int(size))))
+	}
+	if stored.HasText {
+		_ = s.storage.DeleteFile(ctx, storage.GetPreviewPath(stored.TenantID, stored.Checksum, previewTextName))
+	}
+}
+
+// defaultThumbnailPath returns the storage path of the thumbnail files with
+// a preview's content point at, or nil if there is none
+func defaultThumbnailPath(stored *model.Preview) *string {
+	if stored.Status != model.PreviewStatusReady {
+		return nil
+	}
+	size, ok := stored.ThumbnailSize(model.DefaultThumbnailSize)
+	if !ok {
+		return nil
+	}
+
+	thumbnailPath := storage.GetPreviewPath(stored.TenantID, stored.Checksum, thumbnailName(size))
+	return &thumbnailPath
+}
+
+func hasThumbnailSize(stored *model.Preview, size int) bool {
+	if stored.Status != model.PreviewStatusReady {
+		return false
+	}
+	for _, s := range stored.ThumbnailSizes {
+		if int(s) == size {
+			return true
+		}
+	}
+	return false
+}
+
+func thumbnailName(size int) string {
+	return fmt.Sprintf("thumbnail-%d.jpg", size)
+}
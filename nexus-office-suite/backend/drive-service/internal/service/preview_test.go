@@ -0,0 +1,171 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/config"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/storage"
+)
+
+func newPreviewTestService(store *fakeStore) (*driveService, *fakePreviewRepo) {
+	previewRepo := newFakePreviewRepo()
+	s := newTestService(store)
+	s.previewRepo = previewRepo
+	s.searchRepo = &fakeSearchRepo{}
+	s.storage = storage.NewMemoryStorage()
+	s.previewConfig = config.PreviewConfig{ThumbnailSizes: []int{128, 256}, MaxSourceSize: 1 << 20}
+	return s, previewRepo
+}
+
+// addContent gives a file content stored by its checksum
+func addContent(t *testing.T, s *driveService, file *model.File, name, content string) {
+	t.Helper()
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+	file.Name, file.Checksum, file.Size = name, &checksum, int64(len(content))
+	err := s.storage.UploadObject(context.Background(), storage.GetBlobPath(file.TenantID, checksum), bytes.NewReader([]byte(content)), file.Size, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeneratePreviews(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s, _ := newPreviewTestService(store)
+	owner := uuid.New()
+	file := store.addFile(owner, nil)
+	addContent(t, s, file, "notes.txt", "Meeting notes\n\nShip on Friday")
+
+	if _, err := s.GetFilePreview(ctx, file.ID, uuid.New()); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("preview for a stranger: got %v, want %v", err, ErrPermissionDenied)
+	}
+
+	// Asking for a preview queues it
+	stored, err := s.GetFilePreview(ctx, file.ID, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.IsPending() {
+		t.Fatalf("preview is %s before generation, want it pending", stored.Status)
+	}
+	if n, err := s.GeneratePreviews(ctx, 10); err != nil || n != 1 {
+		t.Fatalf("GeneratePreviews = %d, %v, want 1 preview generated", n, err)
+	}
+
+	stored, err = s.GetFilePreview(ctx, file.ID, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != model.PreviewStatusReady || !stored.HasText || len(stored.ThumbnailSizes) != 2 {
+		t.Fatalf("generated preview = %+v, want two thumbnails and text", stored)
+	}
+	want := storage.GetPreviewPath(file.TenantID, *file.Checksum, thumbnailName(256))
+	if file.ThumbnailPath == nil || *file.ThumbnailPath != want {
+		t.Errorf("file thumbnail = %v, want %s", file.ThumbnailPath, want)
+	}
+	if indexed := s.searchRepo.(*fakeSearchRepo).content[file.TenantID.String()+"/"+*file.Checksum]; indexed != "Meeting notes\n\nShip on Friday" {
+		t.Errorf("indexed %q, want the file's text", indexed)
+	}
+
+	reader, err := s.DownloadPreviewText(ctx, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(text) != "Meeting notes\n\nShip on Friday" {
+		t.Errorf("preview text = %q", text)
+	}
+
+	reader, err = s.DownloadThumbnail(ctx, stored, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, format, err := image.DecodeConfig(reader)
+	reader.Close()
+	if err != nil || format != "jpeg" || max(config.Width, config.Height) != 128 {
+		t.Errorf("thumbnail is a %dx%d %s (%v), want a 128 pixel JPEG", config.Width, config.Height, format, err)
+	}
+	if _, err := s.DownloadThumbnail(ctx, stored, 64); !errors.Is(err, ErrPreviewNotFound) {
+		t.Errorf("thumbnail of a size not generated: got %v, want %v", err, ErrPreviewNotFound)
+	}
+}
+
+func TestGeneratePreviewsUnsupported(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s, previewRepo := newPreviewTestService(store)
+	s.previewConfig.MaxSourceSize = 100
+	owner := uuid.New()
+
+	binary := store.addFile(owner, nil)
+	addContent(t, s, binary, "setup.exe", "MZ\x90\x00")
+	large := store.addFile(owner, nil)
+	addContent(t, s, large, "large.txt", string(bytes.Repeat([]byte("a"), 101)))
+
+	for _, file := range []*model.File{binary, large} {
+		if _, err := s.GetFilePreview(ctx, file.ID, owner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.GeneratePreviews(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []*model.File{binary, large} {
+		stored := previewRepo.previews[file.TenantID.String()+"/"+*file.Checksum]
+		if stored.Status != model.PreviewStatusUnsupported || stored.LastError == nil {
+			t.Errorf("%s: preview = %+v, want it unsupported with a reason", file.Name, stored)
+		}
+		if file.ThumbnailPath != nil {
+			t.Errorf("%s: file has a thumbnail", file.Name)
+		}
+	}
+}
+
+func TestGeneratePreviewsRetries(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s, previewRepo := newPreviewTestService(store)
+	owner := uuid.New()
+	file := store.addFile(owner, nil)
+	addContent(t, s, file, "notes.txt", "notes")
+	if _, err := s.GetFilePreview(ctx, file.ID, owner); err != nil {
+		t.Fatal(err)
+	}
+	stored := previewRepo.previews[file.TenantID.String()+"/"+*file.Checksum]
+
+	// Content that cannot be read is tried again later, then given up on
+	if err := s.storage.DeleteFile(ctx, storage.GetBlobPath(file.TenantID, *file.Checksum)); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= previewMaxAttempts; attempt++ {
+		if n, err := s.GeneratePreviews(ctx, 10); err != nil || n != 1 {
+			t.Fatalf("attempt %d: GeneratePreviews = %d, %v", attempt, n, err)
+		}
+		if attempt < previewMaxAttempts {
+			if stored.Status != model.PreviewStatusPending || !stored.RunAt.After(time.Now()) {
+				t.Fatalf("after attempt %d the preview is %s at %v, want it retried later", attempt, stored.Status, stored.RunAt)
+			}
+			if n, _ := s.GeneratePreviews(ctx, 10); n != 0 {
+				t.Fatalf("preview retried at once after attempt %d", attempt)
+			}
+			stored.RunAt = time.Now()
+		}
+		stored = previewRepo.previews[file.TenantID.String()+"/"+*file.Checksum]
+	}
+	if stored.Status != model.PreviewStatusFailed || stored.LastError == nil {
+		t.Errorf("after %d attempts the preview is %+v, want it failed", previewMaxAttempts, stored)
+	}
+}
@@ -0,0 +1,351 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/notification"
+)
+
+const (
+	quotaEventBatchSize   = 100
+	quotaEventMaxAttempts = 10
+	// quotaEventLease is how long a claimed warning has to be delivered
+	// before it is claimed again
+	quotaEventLease = 5 * time.Minute
+)
+
+var (
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	ErrInvalidQuota  = errors.New("invalid quota")
+)
+
+// GetStorageUsage returns how much storage a user and their tenant use,
+// with the user's usage broken down by file type and top-level folder
+func (s *driveService) GetStorageUsage(ctx context.Context, tenantID, userID uuid.UUID) (*model.StorageUsage, error) {
+	usage, err := s.quotaRepo.GetUserUsage(ctx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if usage.Quota, err = s.quota(ctx, tenantID, model.QuotaScopeUser, userID); err != nil {
+		return nil, err
+	}
+
+	if usage.Tenant.Used, err = s.quotaRepo.GetTenantUsage(ctx, tenantID); err != nil {
+		return nil, err
+	}
+	if usage.Tenant.Quota, err = s.quota(ctx, tenantID, model.QuotaScopeTenant, tenantID); err != nil {
+		return nil, err
+	}
+
+	if usage.ByFileType, err = s.quotaRepo.GetUsageByFileType(ctx, tenantID, userID); err != nil {
+		return nil, err
+	}
+	if usage.ByFolder, err = s.quotaRepo.GetUsageByFolder(ctx, tenantID, userID); err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// GetQuota returns the quota of one of a tenant's users, or of the tenant
+// if scope is QuotaScopeTenant and scopeID the tenant, which is the
+// configured default unless the tenant has set another
+func (s *driveService) GetQuota(ctx context.Context, tenantID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID) (*model.Quota, error) {
+	if err := checkQuotaScope(tenantID, scope, scopeID); err != nil {
+		return nil, err
+	}
+
+	quota, err := s.quotaRepo.GetQuota(ctx, tenantID, scope, scopeID)
+	if err != nil || quota != nil {
+		return quota, err
+	}
+
+	return &model.Quota{Scope: scope, ScopeID: scopeID, Quota: s.defaultQuota(scope), Default: true}, nil
+}
+
+// SetQuota sets the quota of one of a tenant's users or of the tenant.
+// Usage is not checked against it: a quota below what is used already
+// blocks new content until enough is freed up.
+func (s *driveService) SetQuota(ctx context.Context, tenantID, userID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID, req *model.SetQuotaRequest) (*model.Quota, error) {
+	if err := checkQuotaScope(tenantID, scope, scopeID); err != nil {
+		return nil, err
+	}
+	if req.Quota == nil || *req.Quota < 0 {
+		return nil, fmt.Errorf("%w: quota must be a number of bytes, or 0 for unlimited", ErrInvalidQuota)
+	}
+
+	now := time.Now()
+	quota := &model.Quota{
+		Scope:     scope,
+		ScopeID:   scopeID,
+		Quota:     *req.Quota,
+		UpdatedBy: &userID,
+		UpdatedAt: &now,
+	}
+
+	if err := s.quotaRepo.SetQuota(ctx, tenantID, quota); err != nil {
+		return nil, err
+	}
+
+	s.checkQuotaChange(ctx, tenantID, userID, quota)
+	return quota, nil
+}
+
+// ClearQuota returns one of a tenant's users or the tenant to the default
+// quota
+func (s *driveService) ClearQuota(ctx context.Context, tenantID, userID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID) (*model.Quota, error) {
+	if err := checkQuotaScope(tenantID, scope, scopeID); err != nil {
+		return nil, err
+	}
+
+	if err := s.quotaRepo.DeleteQuota(ctx, tenantID, scope, scopeID); err != nil {
+		return nil, err
+	}
+
+	quota := &model.Quota{Scope: scope, ScopeID: scopeID, Quota: s.defaultQuota(scope), Default: true}
+	s.checkQuotaChange(ctx, tenantID, userID, quota)
+	return quota, nil
+}
+
+// checkQuotaScope checks that a tenant's quota is only set by itself
+func checkQuotaScope(tenantID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID) error {
+	switch scope {
+	case model.QuotaScopeUser:
+		return nil
+	case model.QuotaScopeTenant:
+		if scopeID != tenantID {
+			return ErrPermissionDenied
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown scope %q", ErrInvalidQuota, scope)
+	}
+}
+
+// checkQuotaChange moves the warning threshold of a user or tenant whose
+// quota changed to what its usage has reached of the new quota. If that is
+// higher, the user is warned, or for a tenant quota actorID, who set it.
+func (s *driveService) checkQuotaChange(ctx context.Context, tenantID, actorID uuid.UUID, quota *model.Quota) {
+	if len(s.quotaConfig.WarningThresholds) == 0 {
+		return
+	}
+	if quota.Quota == 0 {
+		_, _ = s.quotaRepo.SetThreshold(ctx, quota.Scope, quota.ScopeID, 0)
+		return
+	}
+
+	if quota.Scope == model.QuotaScopeTenant {
+		if used, err := s.quotaRepo.GetTenantUsage(ctx, tenantID); err == nil {
+			s.raiseQuotaWarning(ctx, tenantID, quota.Scope, quota.ScopeID, actorID, used, quota.Quota)
+		}
+		return
+	}
+
+	if usage, err := s.quotaRepo.GetUserUsage(ctx, tenantID, quota.ScopeID); err == nil {
+		s.raiseQuotaWarning(ctx, tenantID, quota.Scope, quota.ScopeID, quota.ScopeID, usage.Used, quota.Quota)
+	}
+}
+
+// DeliverQuotaEvents sends pending quota warnings to the notification
+// service and returns how many were delivered. Warnings that fail are
+// retried once their lease ends.
+func (s *driveService) DeliverQuotaEvents(ctx context.Context) (int, error) {
+	if s.notifier == nil {
+		return 0, nil
+	}
+
+	delivered := 0
+	for {
+		events, err := s.quotaRepo.ClaimEvents(ctx, quotaEventBatchSize, quotaEventMaxAttempts, time.Now().Add(quotaEventLease))
+		if err != nil {
+			return delivered, err
+		}
+
+		for _, event := range events {
+			if err := s.notifier.Send(ctx, quotaNotification(event)); err != nil {
+				continue
+			}
+			if err := s.quotaRepo.MarkEventDelivered(ctx, event.ID, time.Now()); err != nil {
+				return delivered, err
+			}
+			delivered++
+		}
+
+		if len(events) < quotaEventBatchSize {
+			return delivered, nil
+		}
+	}
+}
+
+// checkQuota checks that storing size bytes of content at storagePath for
+// ownerID keeps the owner and their tenant within their quotas. Content
+// the owner already holds in a file, trashed file or version costs them
+// nothing, and content the tenant already stores costs the tenant nothing.
+// An empty storagePath is content nobody holds yet.
+func (s *driveService) checkQuota(ctx context.Context, tenantID, ownerID uuid.UUID, storagePath string, size int64) error {
+	userQuota, err := s.quota(ctx, tenantID, model.QuotaScopeUser, ownerID)
+	if err != nil {
+		return err
+	}
+	tenantQuota, err := s.quota(ctx, tenantID, model.QuotaScopeTenant, tenantID)
+	if err != nil {
+		return err
+	}
+	if userQuota == 0 && tenantQuota == 0 {
+		return nil
+	}
+
+	heldByUser, heldByTenant := false, false
+	if storagePath != "" {
+		if heldByUser, heldByTenant, err = s.quotaRepo.HoldsContent(ctx, tenantID, ownerID, storagePath); err != nil {
+			return err
+		}
+	}
+
+	if userQuota > 0 && !heldByUser {
+		usage, err := s.quotaRepo.GetUserUsage(ctx, tenantID, ownerID)
+		if err != nil {
+			return err
+		}
+		if usage.Used+size > userQuota {
+			return fmt.Errorf("%w: %d bytes needed, %d of the user's %d bytes free",
+				ErrQuotaExceeded, size, max(userQuota-usage.Used, 0), userQuota)
+		}
+	}
+
+	if tenantQuota > 0 && !heldByTenant {
+		used, err := s.quotaRepo.GetTenantUsage(ctx, tenantID)
+		if err != nil {
+			return err
+		}
+		if used+size > tenantQuota {
+			return fmt.Errorf("%w: %d bytes needed, %d of the organization's %d bytes free",
+				ErrQuotaExceeded, size, max(tenantQuota-used, 0), tenantQuota)
+		}
+	}
+
+	return nil
+}
+
+// checkQuotaThresholds warns the owner if their usage has reached a higher
+// warning threshold since it was last checked, and actorID if the tenant's
+// has. It is called after usage changes, including when it falls, so that
+// reaching a threshold again warns again.
+func (s *driveService) checkQuotaThresholds(ctx context.Context, tenantID, ownerID, actorID uuid.UUID) {
+	if len(s.quotaConfig.WarningThresholds) == 0 {
+		return
+	}
+
+	if quota, err := s.quota(ctx, tenantID, model.QuotaScopeUser, ownerID); err == nil && quota > 0 {
+		if usage, err := s.quotaRepo.GetUserUsage(ctx, tenantID, ownerID); err == nil {
+			s.raiseQuotaWarning(ctx, tenantID, model.QuotaScopeUser, ownerID, ownerID, usage.Used, quota)
+		}
+	}
+
+	if quota, err := s.quota(ctx, tenantID, model.QuotaScopeTenant, tenantID); err == nil && quota > 0 {
+		if used, err := s.quotaRepo.GetTenantUsage(ctx, tenantID); err == nil {
+			s.raiseQuotaWarning(ctx, tenantID, model.QuotaScopeTenant, tenantID, actorID, used, quota)
+		}
+	}
+}
+
+// raiseQuotaWarning records the threshold usage has reached and, if it is
+// higher than before, a warning for userID
+func (s *driveService) raiseQuotaWarning(ctx context.Context, tenantID uuid.UUID, scope model.QuotaScope, scopeID, userID uuid.UUID, used, quota int64) {
+	threshold := reachedThreshold(s.quotaConfig.WarningThresholds, used, quota)
+	raised, err := s.quotaRepo.SetThreshold(ctx, scope, scopeID, threshold)
+	if err != nil || !raised {
+		return
+	}
+
+	now := time.Now()
+	_ = s.quotaRepo.CreateEvent(ctx, &model.QuotaEvent{
+		ID:         uuid.New(),
+		TenantID:   tenantID,
+		Scope:      scope,
+		ScopeID:    scopeID,
+		UserID:     userID,
+		Threshold:  threshold,
+		UsedBytes:  used,
+		QuotaBytes: quota,
+		RunAt:      now,
+		CreatedAt:  now,
+	})
+}
+
+// quota returns the quota of a user or tenant in bytes, 0 if unlimited
+func (s *driveService) quota(ctx context.Context, tenantID uuid.UUID, scope model.QuotaScope, scopeID uuid.UUID) (int64, error) {
+	quota, err := s.quotaRepo.GetQuota(ctx, tenantID, scope, scopeID)
+	if err != nil {
+		return 0, err
+	}
+	if quota != nil {
+		return quota.Quota, nil
+	}
+
+	return s.defaultQuota(scope), nil
+}
+
+func (s *driveService) defaultQuota(scope model.QuotaScope) int64 {
+	if scope == model.QuotaScopeTenant {
+		return s.quotaConfig.TenantQuota
+	}
+	return s.quotaConfig.UserQuota
+}
+
+// reachedThreshold returns the highest of the ascending thresholds, in
+// percent of quota, that used has reached, or 0 if none
+func reachedThreshold(thresholds []int, used, quota int64) int {
+	reached := 0
+	for _, threshold := range thresholds {
+		if used*100 >= int64(threshold)*quota {
+			reached = threshold
+		}
+	}
+	return reached
+}
+
+func quotaNotification(event *model.QuotaEvent) *notification.Notification {
+	whose, title := "your", "Your storage is almost full"
+	switch {
+	case event.Scope == model.QuotaScopeTenant && event.Threshold >= 100:
+		whose, title = "your organization's", "Your organization's storage is full"
+	case event.Scope == model.QuotaScopeTenant:
+		whose, title = "your organization's", "Your organization's storage is almost full"
+	case event.Threshold >= 100:
+		title = "Your storage is full"
+	}
+
+	return &notification.Notification{
+		UserID: event.UserID,
+		Type:   notification.TypeSystem,
+		Title:  title,
+		Message: fmt.Sprintf("%s of %s %s of Drive storage is used (%d%%). Empty the trash or delete files and old versions to free up space.",
+			formatBytes(event.UsedBytes), whose, formatBytes(event.QuotaBytes), event.Threshold),
+		Data: map[string]interface{}{
+			"scope":       event.Scope,
+			"threshold":   event.Threshold,
+			"used_bytes":  event.UsedBytes,
+			"quota_bytes": event.QuotaBytes,
+		},
+	}
+}
+
+// formatBytes formats a size in binary units, such as 1.5 GB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
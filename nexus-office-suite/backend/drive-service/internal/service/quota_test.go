@@ -0,0 +1,209 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/config"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/storage"
+)
+
+func newQuotaTestService(quotaConfig config.QuotaConfig) (*driveService, *fakeQuotaRepo) {
+	quotaRepo := newFakeQuotaRepo()
+	s := newTestService(newFakeStore())
+	s.quotaRepo = quotaRepo
+	s.quotaConfig = quotaConfig
+	return s, quotaRepo
+}
+
+// countingStorage counts the objects uploaded to it
+type countingStorage struct {
+	storage.Storage
+	uploads int
+}
+
+func (s *countingStorage) UploadObject(ctx context.Context, storagePath string, reader io.Reader, size int64, contentType string) error {
+	s.uploads++
+	return s.Storage.UploadObject(ctx, storagePath, reader, size, contentType)
+}
+
+func int64Ptr(n int64) *int64 {
+	return &n
+}
+
+func TestSetAndClearUserQuota(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newQuotaTestService(config.QuotaConfig{UserQuota: 1000})
+	tenantID, adminID, userID := uuid.New(), uuid.New(), uuid.New()
+
+	quota, err := s.GetQuota(ctx, tenantID, model.QuotaScopeUser, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.Quota != 1000 || !quota.Default {
+		t.Errorf("GetQuota before setting = %+v, want the default of 1000", quota)
+	}
+
+	quota, err = s.SetQuota(ctx, tenantID, adminID, model.QuotaScopeUser, userID, &model.SetQuotaRequest{Quota: int64Ptr(5000)})
+	if err != nil {
+		t.Fatalf("SetQuota: %v", err)
+	}
+	if quota.Quota != 5000 || quota.Default || quota.UpdatedBy == nil || *quota.UpdatedBy != adminID {
+		t.Errorf("SetQuota = %+v, want 5000 set by the admin", quota)
+	}
+
+	if quota, _ := s.GetQuota(ctx, tenantID, model.QuotaScopeUser, userID); quota.Quota != 5000 || quota.Default {
+		t.Errorf("GetQuota after setting = %+v, want 5000", quota)
+	}
+	if err := s.checkQuota(ctx, tenantID, userID, "", 4000); err != nil {
+		t.Errorf("checkQuota within the new quota: %v", err)
+	}
+
+	// Another tenant's administrators cannot change it
+	otherTenantID := uuid.New()
+	if _, err := s.SetQuota(ctx, otherTenantID, uuid.New(), model.QuotaScopeUser, userID, &model.SetQuotaRequest{Quota: int64Ptr(0)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.checkQuota(ctx, tenantID, userID, "", 6000); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("checkQuota after another tenant set the quota: got %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	quota, err = s.ClearQuota(ctx, tenantID, adminID, model.QuotaScopeUser, userID)
+	if err != nil {
+		t.Fatalf("ClearQuota: %v", err)
+	}
+	if quota.Quota != 1000 || !quota.Default {
+		t.Errorf("ClearQuota = %+v, want the default of 1000", quota)
+	}
+	if err := s.checkQuota(ctx, tenantID, userID, "", 4000); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("checkQuota after clearing: got %v, want %v", err, ErrQuotaExceeded)
+	}
+}
+
+func TestSetTenantQuota(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newQuotaTestService(config.QuotaConfig{})
+	tenantID, adminID, userID := uuid.New(), uuid.New(), uuid.New()
+
+	if _, err := s.SetQuota(ctx, tenantID, adminID, model.QuotaScopeTenant, tenantID, &model.SetQuotaRequest{Quota: int64Ptr(100)}); err != nil {
+		t.Fatalf("SetQuota: %v", err)
+	}
+	if err := s.checkQuota(ctx, tenantID, userID, "", 200); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("checkQuota beyond the tenant quota: got %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	_, err := s.SetQuota(ctx, tenantID, adminID, model.QuotaScopeTenant, uuid.New(), &model.SetQuotaRequest{Quota: int64Ptr(100)})
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("SetQuota of another tenant: got %v, want %v", err, ErrPermissionDenied)
+	}
+	if _, err := s.ClearQuota(ctx, tenantID, adminID, model.QuotaScopeTenant, uuid.New()); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("ClearQuota of another tenant: got %v, want %v", err, ErrPermissionDenied)
+	}
+}
+
+func TestSetQuotaInvalid(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newQuotaTestService(config.QuotaConfig{})
+	tenantID := uuid.New()
+
+	for _, req := range []*model.SetQuotaRequest{{}, {Quota: int64Ptr(-1)}} {
+		if _, err := s.SetQuota(ctx, tenantID, uuid.New(), model.QuotaScopeUser, uuid.New(), req); !errors.Is(err, ErrInvalidQuota) {
+			t.Errorf("SetQuota(%v): got %v, want %v", req.Quota, err, ErrInvalidQuota)
+		}
+	}
+	if _, err := s.SetQuota(ctx, tenantID, uuid.New(), "group", uuid.New(), &model.SetQuotaRequest{Quota: int64Ptr(1)}); !errors.Is(err, ErrInvalidQuota) {
+		t.Errorf("SetQuota of an unknown scope: got %v, want %v", err, ErrInvalidQuota)
+	}
+}
+
+func TestSetQuotaWarns(t *testing.T) {
+	ctx := context.Background()
+	s, quotaRepo := newQuotaTestService(config.QuotaConfig{WarningThresholds: []int{80, 100}})
+	tenantID, adminID, userID := uuid.New(), uuid.New(), uuid.New()
+	quotaRepo.userUsage[userID] = 900
+
+	if _, err := s.SetQuota(ctx, tenantID, adminID, model.QuotaScopeUser, userID, &model.SetQuotaRequest{Quota: int64Ptr(1000)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(quotaRepo.events) != 1 || quotaRepo.events[0].UserID != userID || quotaRepo.events[0].Threshold != 80 {
+		t.Fatalf("events after lowering the quota = %+v, want an 80%% warning for the user", quotaRepo.events)
+	}
+
+	// An unlimited quota resets the threshold, so a later quota warns again
+	if _, err := s.SetQuota(ctx, tenantID, adminID, model.QuotaScopeUser, userID, &model.SetQuotaRequest{Quota: int64Ptr(0)}); err != nil {
+		t.Fatal(err)
+	}
+	if quotaRepo.thresholds[userID] != 0 {
+		t.Errorf("threshold with an unlimited quota = %d, want 0", quotaRepo.thresholds[userID])
+	}
+	if _, err := s.SetQuota(ctx, tenantID, adminID, model.QuotaScopeUser, userID, &model.SetQuotaRequest{Quota: int64Ptr(900)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(quotaRepo.events) != 2 || quotaRepo.events[1].Threshold != 100 {
+		t.Errorf("events after setting a full quota = %+v, want a 100%% warning", quotaRepo.events)
+	}
+}
+
+func TestUploadFileOverQuotaStoresNothing(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newQuotaTestService(config.QuotaConfig{UserQuota: 10})
+	store := &countingStorage{Storage: storage.NewMemoryStorage()}
+	s.storage = store
+	tenantID, userID := uuid.New(), uuid.New()
+
+	content := []byte("more than ten bytes")
+	readers := map[string]io.Reader{
+		"seekable": bytes.NewReader(content),
+		"stream":   io.MultiReader(bytes.NewReader(content)),
+	}
+	for name, reader := range readers {
+		t.Run(name, func(t *testing.T) {
+			_, err := s.UploadFile(ctx, tenantID, userID, nil, "big.txt", reader, int64(len(content)), "text/plain")
+			if !errors.Is(err, ErrQuotaExceeded) {
+				t.Errorf("UploadFile over quota: got %v, want %v", err, ErrQuotaExceeded)
+			}
+			if store.uploads != 0 {
+				t.Errorf("UploadFile over quota stored %d objects", store.uploads)
+			}
+		})
+	}
+}
+
+func TestStoreBlobWithinChecksContent(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newQuotaTestService(config.QuotaConfig{})
+	s.storage = storage.NewMemoryStorage()
+	tenantID := uuid.New()
+	refused := errors.New("refused")
+
+	var checkedPath string
+	var checkedSize int64
+	check := func(storagePath string, size int64) error {
+		checkedPath, checkedSize = storagePath, size
+		return refused
+	}
+
+	// Seekable content is checked by its content address, so that content
+	// already held can cost nothing
+	if _, err := s.storeBlobWithin(ctx, tenantID, strings.NewReader("hello"), 5, "text/plain", check); !errors.Is(err, refused) {
+		t.Fatalf("storeBlobWithin: got %v, want %v", err, refused)
+	}
+	want := storage.GetBlobPath(tenantID, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+	if checkedPath != want || checkedSize != 5 {
+		t.Errorf("seekable content checked as %q, %d, want %q, 5", checkedPath, checkedSize, want)
+	}
+
+	// Other content is checked by its declared size
+	if _, err := s.storeBlobWithin(ctx, tenantID, io.MultiReader(strings.NewReader("hello")), 5, "text/plain", check); !errors.Is(err, refused) {
+		t.Fatalf("storeBlobWithin: got %v, want %v", err, refused)
+	}
+	if checkedPath != "" || checkedSize != 5 {
+		t.Errorf("streamed content checked as %q, %d, want \"\", 5", checkedPath, checkedSize)
+	}
+}
@@ -0,0 +1,351 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/storage"
+)
+
+const (
+	// S3 multipart limits: every part but the last must be at least 5 MiB,
+	// and an upload has at most 10,000 parts
+	minPartSize = 5 << 20
+	maxParts    = 10000
+
+	expireBatchSize = 100
+)
+
+var (
+	ErrUploadNotFound   = errors.New("upload session not found")
+	ErrUploadExpired    = errors.New("upload session expired")
+	ErrUploadCompleted  = errors.New("upload session already completed")
+	ErrUploadIncomplete = errors.New("upload session is missing chunks")
+	ErrUploadTooLarge   = errors.New("upload exceeds the maximum size")
+	ErrInvalidUpload    = errors.New("invalid upload")
+	ErrOffsetMismatch   = errors.New("chunk offset does not match the upload offset")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// CreateUploadSession starts a resumable upload. The session's chunk size is
+// the size every chunk but the last must have.
+func (s *driveService) CreateUploadSession(ctx context.Context, tenantID, userID uuid.UUID, req *model.CreateUploadSessionRequest) (*model.UploadSession, error) {
+	if req.Filename == "" {
+		return nil, fmt.Errorf("%w: filename is required", ErrInvalidUpload)
+	}
+	if req.Size <= 0 {
+		return nil, fmt.Errorf("%w: size must be positive", ErrInvalidUpload)
+	}
+	if req.Size > s.uploadConfig.MaxResumableSize {
+		return nil, ErrUploadTooLarge
+	}
+	if err := s.checkFolderAccess(ctx, req.FolderID, userID, model.PermissionEditor); err != nil {
+		return nil, err
+	}
+	if req.Checksum != nil {
+		if sum, err := hex.DecodeString(*req.Checksum); err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%w: checksum must be a hex SHA-256", ErrInvalidUpload)
+		}
+		checksum := strings.ToLower(*req.Checksum)
+		req.Checksum = &checksum
+	}
+
+	// Refuse uploads that cannot fit before any chunk is sent. Content the
+	// user or tenant may already have is only known from its checksum.
+	contentPath := ""
+	if req.Checksum != nil {
+		contentPath = storage.GetBlobPath(tenantID, *req.Checksum)
+	}
+	if err := s.checkQuota(ctx, tenantID, userID, contentPath, req.Size); err != nil {
+		return nil, err
+	}
+
+	chunkSize := s.uploadConfig.ChunkSize
+	if chunkSize < minPartSize {
+		chunkSize = minPartSize
+	}
+	for (req.Size+chunkSize-1)/chunkSize > maxParts {
+		chunkSize *= 2
+	}
+
+	hashState, err := sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunks are assembled at a temporary path and moved to the content
+	// address on completion
+	sessionID := uuid.New()
+	contentType := detectContentType(req.MimeType, req.Filename)
+	storagePath := storage.GetTempPath(tenantID, sessionID)
+
+	uploadID, err := s.storage.NewMultipartUpload(ctx, storagePath, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &model.UploadSession{
+		ID:              sessionID,
+		TenantID:        tenantID,
+		OwnerID:         userID,
+		FolderID:        req.FolderID,
+		FileID:          uuid.New(),
+		Filename:        req.Filename,
+		MimeType:        contentType,
+		Size:            req.Size,
+		ChunkSize:       chunkSize,
+		Offset:          0,
+		Checksum:        req.Checksum,
+		StoragePath:     storagePath,
+		StorageUploadID: uploadID,
+		HashState:       hashState,
+		Status:          model.UploadStatusUploading,
+		ExpiresAt:       now.Add(s.uploadConfig.SessionTTL),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	if err := s.uploadRepo.Create(ctx, session); err != nil {
+		_ = s.storage.AbortMultipartUpload(ctx, storagePath, uploadID)
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// GetUploadSession gets an upload session, including its current offset
+func (s *driveService) GetUploadSession(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID) (*model.UploadSession, error) {
+	return s.getUploadSession(ctx, sessionID, userID)
+}
+
+// UploadChunk stores the chunk at offset, which must be the session's
+// current offset. The chunk must be exactly the session's chunk size, or
+// the rest of the file for the last one; a partial chunk is discarded and
+// has to be sent again. If checksum is given it must be the chunk's SHA-256.
+func (s *driveService) UploadChunk(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID, offset int64, reader io.Reader, checksum []byte) (*model.UploadSession, error) {
+	session, err := s.getUploadSession(ctx, sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status == model.UploadStatusCompleted {
+		return nil, ErrUploadCompleted
+	}
+	if offset != session.Offset {
+		return nil, ErrOffsetMismatch
+	}
+
+	length := session.Size - offset
+	if length > session.ChunkSize {
+		length = session.ChunkSize
+	}
+	if length == 0 {
+		return nil, fmt.Errorf("%w: all %d bytes have been received", ErrInvalidUpload, session.Size)
+	}
+
+	chunk := make([]byte, length)
+	if _, err := io.ReadFull(reader, chunk); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: chunk must be %d bytes", ErrInvalidUpload, length)
+		}
+		return nil, err
+	}
+	if _, err := io.ReadFull(reader, make([]byte, 1)); err == nil {
+		return nil, fmt.Errorf("%w: chunk must be %d bytes", ErrInvalidUpload, length)
+	}
+
+	sum := sha256.Sum256(chunk)
+	if checksum != nil && !bytes.Equal(checksum, sum[:]) {
+		return nil, ErrChecksumMismatch
+	}
+
+	// Carry the whole-file hash forward so it can be checked on completion
+	h, err := restoreHash(session.HashState)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(chunk)
+	hashState, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	partNumber := int(offset/session.ChunkSize) + 1
+	etag, err := s.storage.UploadPart(ctx, session.StoragePath, session.StorageUploadID, partNumber, bytes.NewReader(chunk), length, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session.Offset += length
+	session.HashState = hashState
+	session.ExpiresAt = now.Add(s.uploadConfig.SessionTTL)
+	session.UpdatedAt = now
+
+	part := &model.UploadPart{
+		SessionID:  session.ID,
+		PartNumber: partNumber,
+		Size:       length,
+		ETag:       etag,
+		Checksum:   hex.EncodeToString(sum[:]),
+		CreatedAt:  now,
+	}
+
+	recorded, err := s.uploadRepo.AddPart(ctx, session, offset, part)
+	if err != nil {
+		return nil, err
+	}
+	if !recorded {
+		return nil, ErrOffsetMismatch
+	}
+
+	return session, nil
+}
+
+// CompleteUpload assembles the chunks of a fully uploaded session into a new
+// file. Completing a session again returns the same file.
+func (s *driveService) CompleteUpload(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID) (*model.File, error) {
+	session, err := s.getUploadSession(ctx, sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status == model.UploadStatusCompleted {
+		return s.fileRepo.GetByID(ctx, session.FileID)
+	}
+	if session.Offset != session.Size {
+		return nil, ErrUploadIncomplete
+	}
+
+	h, err := restoreHash(session.HashState)
+	if err != nil {
+		return nil, err
+	}
+	checksum := hex.EncodeToString(h.Sum(nil))
+	if session.Checksum != nil && *session.Checksum != checksum {
+		// The content is wrong, so there is nothing to resume
+		_ = s.storage.AbortMultipartUpload(ctx, session.StoragePath, session.StorageUploadID)
+		_ = s.uploadRepo.Delete(ctx, session.ID)
+		return nil, ErrChecksumMismatch
+	}
+
+	parts, err := s.uploadRepo.GetParts(ctx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	storageParts := make([]storage.Part, len(parts))
+	for i, part := range parts {
+		storageParts[i] = storage.Part{PartNumber: part.PartNumber, ETag: part.ETag}
+	}
+
+	var blob *model.Blob
+	if err := s.storage.CompleteMultipartUpload(ctx, session.StoragePath, session.StorageUploadID, storageParts); err != nil {
+		// A previous attempt may have assembled the object, or even stored
+		// the blob, and then failed to create the file
+		if stored, touchErr := s.blobRepo.Touch(ctx, session.TenantID, checksum); touchErr == nil {
+			blob = stored
+			_ = s.storage.AbortMultipartUpload(ctx, session.StoragePath, session.StorageUploadID)
+			_ = s.storage.DeleteFile(ctx, session.StoragePath)
+		} else if exists, existsErr := s.storage.FileExists(ctx, session.StoragePath); existsErr != nil || !exists {
+			return nil, err
+		}
+	}
+	if blob == nil {
+		blob, err = s.promoteBlob(ctx, session.TenantID, session.StoragePath, checksum, session.Size)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Usage may have grown since the session started. The session can be
+	// completed again once space has been freed.
+	if err := s.checkQuota(ctx, session.TenantID, session.OwnerID, blob.StoragePath, blob.Size); err != nil {
+		return nil, err
+	}
+
+	file, err := s.createFile(ctx, session.TenantID, session.OwnerID, session.FileID, session.FolderID, session.Filename, session.MimeType, blob)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = s.uploadRepo.MarkCompleted(ctx, session.ID, time.Now().Add(s.uploadConfig.SessionTTL))
+
+	return file, nil
+}
+
+// AbortUpload cancels an upload session and discards its chunks
+func (s *driveService) AbortUpload(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID) error {
+	session, err := s.getUploadSession(ctx, sessionID, userID)
+	if err != nil && !errors.Is(err, ErrUploadExpired) {
+		return err
+	}
+
+	if session.Status == model.UploadStatusUploading {
+		if err := s.storage.AbortMultipartUpload(ctx, session.StoragePath, session.StorageUploadID); err != nil {
+			return err
+		}
+	}
+
+	return s.uploadRepo.Delete(ctx, session.ID)
+}
+
+// ExpireUploadSessions removes sessions idle for longer than the session
+// TTL. Unfinished uploads are aborted in storage; completed sessions only
+// lose the record. Returns the number of sessions removed.
+func (s *driveService) ExpireUploadSessions(ctx context.Context) (int, error) {
+	expired := 0
+	for {
+		sessions, err := s.uploadRepo.GetExpired(ctx, time.Now(), expireBatchSize)
+		if err != nil {
+			return expired, err
+		}
+
+		for _, session := range sessions {
+			if session.Status == model.UploadStatusUploading {
+				if err := s.storage.AbortMultipartUpload(ctx, session.StoragePath, session.StorageUploadID); err != nil {
+					return expired, err
+				}
+				// Remove the object a failed completion may have assembled
+				_ = s.storage.DeleteFile(ctx, session.StoragePath)
+			}
+			if err := s.uploadRepo.Delete(ctx, session.ID); err != nil {
+				return expired, err
+			}
+			expired++
+		}
+
+		if len(sessions) < expireBatchSize {
+			return expired, nil
+		}
+	}
+}
+
+func (s *driveService) getUploadSession(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID) (*model.UploadSession, error) {
+	session, err := s.uploadRepo.GetByID(ctx, sessionID)
+	if err != nil || session.OwnerID != userID {
+		return nil, ErrUploadNotFound
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return session, ErrUploadExpired
+	}
+	return session, nil
+}
+
+func restoreHash(state []byte) (hash.Hash, error) {
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, fmt.Errorf("failed to restore upload hash: %w", err)
+	}
+	return h, nil
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/config"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/storage"
+)
+
+func newUploadTestService() (*driveService, *fakeUploadRepo) {
+	uploadRepo := newFakeUploadRepo()
+	s := newTestService(newFakeStore())
+	s.uploadRepo = uploadRepo
+	s.blobRepo = newFakeBlobRepo()
+	s.previewRepo = newFakePreviewRepo()
+	s.quotaRepo = newFakeQuotaRepo()
+	s.storage = storage.NewMemoryStorage()
+	s.uploadConfig = config.UploadConfig{
+		ChunkSize:        minPartSize,
+		MaxResumableSize: 1 << 30,
+		SessionTTL:       time.Hour,
+	}
+	return s, uploadRepo
+}
+
+// uploadContent returns content of a chunk and a bit, and its SHA-256
+func uploadContent() ([]byte, string) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), minPartSize/16+1)
+	sum := sha256.Sum256(content)
+	return content, hex.EncodeToString(sum[:])
+}
+
+func TestResumableUpload(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newUploadTestService()
+	tenantID, userID := uuid.New(), uuid.New()
+	content, checksum := uploadContent()
+
+	session, err := s.CreateUploadSession(ctx, tenantID, userID, &model.CreateUploadSessionRequest{
+		Filename: "big.bin",
+		Size:     int64(len(content)),
+		Checksum: &checksum,
+	})
+	if err != nil {
+		t.Fatalf("CreateUploadSession: %v", err)
+	}
+	if session.ChunkSize != minPartSize {
+		t.Fatalf("chunk size = %d, want %d", session.ChunkSize, minPartSize)
+	}
+	first, last := content[:minPartSize], content[minPartSize:]
+
+	// A chunk cut short is discarded and does not move the offset
+	if _, err := s.UploadChunk(ctx, session.ID, userID, 0, bytes.NewReader(first[:100]), nil); !errors.Is(err, ErrInvalidUpload) {
+		t.Errorf("short chunk: got %v, want %v", err, ErrInvalidUpload)
+	}
+	wrongSum := sha256.Sum256([]byte("something else"))
+	if _, err := s.UploadChunk(ctx, session.ID, userID, 0, bytes.NewReader(first), wrongSum[:]); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("corrupted chunk: got %v, want %v", err, ErrChecksumMismatch)
+	}
+	if _, err := s.UploadChunk(ctx, session.ID, userID, minPartSize, bytes.NewReader(last), nil); !errors.Is(err, ErrOffsetMismatch) {
+		t.Errorf("chunk out of order: got %v, want %v", err, ErrOffsetMismatch)
+	}
+	if _, err := s.CompleteUpload(ctx, session.ID, userID); !errors.Is(err, ErrUploadIncomplete) {
+		t.Errorf("CompleteUpload before any chunk: got %v, want %v", err, ErrUploadIncomplete)
+	}
+
+	firstSum := sha256.Sum256(first)
+	session, err = s.UploadChunk(ctx, session.ID, userID, 0, bytes.NewReader(first), firstSum[:])
+	if err != nil {
+		t.Fatalf("UploadChunk: %v", err)
+	}
+	if session.Offset != minPartSize {
+		t.Errorf("offset after the first chunk = %d, want %d", session.Offset, minPartSize)
+	}
+
+	// The upload resumes from the offset the server reports
+	resumed, err := s.GetUploadSession(ctx, session.ID, userID)
+	if err != nil {
+		t.Fatalf("GetUploadSession: %v", err)
+	}
+	if _, err := s.UploadChunk(ctx, session.ID, userID, resumed.Offset, bytes.NewReader(last), nil); err != nil {
+		t.Fatalf("UploadChunk: %v", err)
+	}
+
+	file, err := s.CompleteUpload(ctx, session.ID, userID)
+	if err != nil {
+		t.Fatalf("CompleteUpload: %v", err)
+	}
+	if file.Name != "big.bin" || file.Size != int64(len(content)) || file.Checksum == nil || *file.Checksum != checksum {
+		t.Errorf("CompleteUpload = %+v, want big.bin of %d bytes", file, len(content))
+	}
+	if file.StoragePath != storage.GetBlobPath(tenantID, checksum) {
+		t.Errorf("file stored at %q, want its content address", file.StoragePath)
+	}
+
+	reader, err := s.storage.DownloadFile(ctx, file.StoragePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	stored, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, content) {
+		t.Errorf("stored %d bytes that differ from the %d uploaded", len(stored), len(content))
+	}
+
+	// Completing again, say after a lost response, returns the same file
+	again, err := s.CompleteUpload(ctx, session.ID, userID)
+	if err != nil {
+		t.Fatalf("CompleteUpload again: %v", err)
+	}
+	if again.ID != file.ID {
+		t.Errorf("CompleteUpload again returned file %s, want %s", again.ID, file.ID)
+	}
+	if _, err := s.UploadChunk(ctx, session.ID, userID, 0, bytes.NewReader(first), nil); !errors.Is(err, ErrUploadCompleted) {
+		t.Errorf("chunk after completion: got %v, want %v", err, ErrUploadCompleted)
+	}
+}
+
+func TestCompleteUploadChecksumMismatch(t *testing.T) {
+	ctx := context.Background()
+	s, uploadRepo := newUploadTestService()
+	userID := uuid.New()
+	content, _ := uploadContent()
+	wrong := hex.EncodeToString(make([]byte, sha256.Size))
+
+	session, err := s.CreateUploadSession(ctx, uuid.New(), userID, &model.CreateUploadSessionRequest{
+		Filename: "big.bin",
+		Size:     int64(len(content)),
+		Checksum: &wrong,
+	})
+	if err != nil {
+		t.Fatalf("CreateUploadSession: %v", err)
+	}
+	for offset := int64(0); offset < session.Size; offset += session.ChunkSize {
+		end := min(offset+session.ChunkSize, session.Size)
+		if _, err := s.UploadChunk(ctx, session.ID, userID, offset, bytes.NewReader(content[offset:end]), nil); err != nil {
+			t.Fatalf("UploadChunk at %d: %v", offset, err)
+		}
+	}
+
+	if _, err := s.CompleteUpload(ctx, session.ID, userID); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrChecksumMismatch)
+	}
+	if _, ok := uploadRepo.sessions[session.ID]; ok {
+		t.Error("session with the wrong content was kept")
+	}
+}
+
+func TestUploadSessionBelongsToItsOwner(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newUploadTestService()
+	ownerID, otherID := uuid.New(), uuid.New()
+
+	session, err := s.CreateUploadSession(ctx, uuid.New(), ownerID, &model.CreateUploadSessionRequest{Filename: "a.txt", Size: 5})
+	if err != nil {
+		t.Fatalf("CreateUploadSession: %v", err)
+	}
+
+	if _, err := s.GetUploadSession(ctx, session.ID, otherID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("GetUploadSession: got %v, want %v", err, ErrUploadNotFound)
+	}
+	if _, err := s.UploadChunk(ctx, session.ID, otherID, 0, bytes.NewReader([]byte("hello")), nil); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("UploadChunk: got %v, want %v", err, ErrUploadNotFound)
+	}
+	if err := s.AbortUpload(ctx, session.ID, otherID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("AbortUpload: got %v, want %v", err, ErrUploadNotFound)
+	}
+}
+
+func TestCreateUploadSessionChecksQuota(t *testing.T) {
+	ctx := context.Background()
+	s, uploadRepo := newUploadTestService()
+	s.quotaConfig = config.QuotaConfig{UserQuota: 10}
+
+	_, err := s.CreateUploadSession(ctx, uuid.New(), uuid.New(), &model.CreateUploadSessionRequest{Filename: "big.bin", Size: 11})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v, want %v", err, ErrQuotaExceeded)
+	}
+	if len(uploadRepo.sessions) != 0 {
+		t.Error("session created for an upload over quota")
+	}
+}
+
+func TestExpireUploadSessions(t *testing.T) {
+	ctx := context.Background()
+	s, uploadRepo := newUploadTestService()
+	userID := uuid.New()
+
+	expired, err := s.CreateUploadSession(ctx, uuid.New(), userID, &model.CreateUploadSessionRequest{Filename: "a.txt", Size: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := s.CreateUploadSession(ctx, uuid.New(), userID, &model.CreateUploadSessionRequest{Filename: "b.txt", Size: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadRepo.sessions[expired.ID].ExpiresAt = time.Now().Add(-time.Minute)
+
+	if _, err := s.GetUploadSession(ctx, expired.ID, userID); !errors.Is(err, ErrUploadExpired) {
+		t.Errorf("GetUploadSession of an expired session: got %v, want %v", err, ErrUploadExpired)
+	}
+
+	n, err := s.ExpireUploadSessions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expired %d sessions, want 1", n)
+	}
+	if _, ok := uploadRepo.sessions[active.ID]; !ok {
+		t.Error("active session was expired")
+	}
+}
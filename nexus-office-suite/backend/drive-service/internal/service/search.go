@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+// searchMaxContentSize is the most text of a blob that is indexed, keeping
+// its vector well under the database's limit
+const searchMaxContentSize = 512 << 10
+
+var ErrInvalidSearch = errors.New("invalid search")
+
+// SearchFiles searches the names, descriptions, tags and content of the
+// files a user can view
+func (s *driveService) SearchFiles(ctx context.Context, tenantID, userID uuid.UUID, req *model.SearchRequest) ([]*model.SearchResult, error) {
+	req.Query = strings.TrimSpace(req.Query)
+	if req.Query == "" {
+		return nil, fmt.Errorf("%w: search query is required", ErrInvalidSearch)
+	}
+	if req.MinSize != nil && req.MaxSize != nil && *req.MinSize > *req.MaxSize {
+		return nil, fmt.Errorf("%w: min_size is greater than max_size", ErrInvalidSearch)
+	}
+	if req.ModifiedAfter != nil && req.ModifiedBefore != nil && !req.ModifiedAfter.Before(*req.ModifiedBefore) {
+		return nil, fmt.Errorf("%w: modified_after is not before modified_before", ErrInvalidSearch)
+	}
+	if req.Limit <= 0 {
+		req.Limit = model.DefaultSearchLimit
+	}
+	req.Limit = min(req.Limit, model.MaxSearchLimit)
+	req.Offset = max(req.Offset, 0)
+
+	results, err := s.searchRepo.Search(ctx, tenantID, userID, req, s.searchConfig.Language)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		if result.Snippet != nil {
+			snippet := highlightSnippet(*result.Snippet)
+			result.Snippet = &snippet
+		}
+	}
+
+	return results, nil
+}
+
+// indexContent indexes the text extracted from a blob for search
+func (s *driveService) indexContent(ctx context.Context, tenantID uuid.UUID, checksum, text string) error {
+	if len(text) > searchMaxContentSize {
+		text = text[:searchMaxContentSize]
+		for !utf8.ValidString(text) {
+			text = text[:len(text)-1]
+		}
+	}
+	text = strings.NewReplacer(model.SearchHighlightStart, "", model.SearchHighlightStop, "").Replace(text)
+
+	return s.searchRepo.IndexContent(ctx, tenantID, checksum, text, s.searchConfig.Language)
+}
+
+// highlightSnippet turns a snippet returned by the database into HTML, with
+// the matching words in <mark> elements
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, model.SearchHighlightStart, "<mark>")
+	return strings.ReplaceAll(snippet, model.SearchHighlightStop, "</mark>")
+}
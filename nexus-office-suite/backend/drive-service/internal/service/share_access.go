@@ -0,0 +1,413 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrShareLinkNotFound     = errors.New("share link not found or expired")
+	ErrSharePasswordRequired = errors.New("share link requires a password")
+	ErrInvalidSharePassword  = errors.New("invalid share link password")
+	ErrTooManyUnlockAttempts = errors.New("too many failed password attempts")
+	ErrShareDownloadLimit    = errors.New("share link download limit reached")
+	ErrNotInShare            = errors.New("not found in shared folder")
+	ErrShareIsFolder         = errors.New("share link is for a folder")
+	ErrInvalidShareRequest   = errors.New("invalid share link request")
+)
+
+// GetShareLinkAccessLog returns the most recent uses of a share link. Only
+// the link's creator, while they can still edit the shared resource, and the
+// resource's owners can see it.
+func (s *driveService) GetShareLinkAccessLog(ctx context.Context, tenantID, linkID, userID uuid.UUID, limit int) ([]*model.ShareLinkAccess, error) {
+	link, err := s.getShareLink(ctx, tenantID, linkID)
+	if err != nil {
+		return nil, err
+	}
+
+	role := model.PermissionOwner
+	if link.CreatedBy == userID {
+		role = model.PermissionEditor
+	}
+	if err := s.checkResourceAccess(ctx, tenantID, link.ResourceType, link.ResourceID, userID, role); err != nil {
+		return nil, err
+	}
+
+	return s.shareLinkRepo.GetAccessLog(ctx, linkID, limit)
+}
+
+// getShareLink returns a share link of the tenant by ID
+func (s *driveService) getShareLink(ctx context.Context, tenantID, linkID uuid.UUID) (*model.ShareLink, error) {
+	link, err := s.shareLinkRepo.GetByID(ctx, linkID)
+	if err != nil || link.TenantID != tenantID {
+		return nil, ErrShareLinkNotFound
+	}
+	return link, nil
+}
+
+// GetSharedResource returns the file or folder a public share link points at
+func (s *driveService) GetSharedResource(ctx context.Context, token string, client *model.ShareClient) (*model.SharedResource, error) {
+	link, err := s.openShareLink(ctx, token, client)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := &model.SharedResource{
+		ResourceType:     link.ResourceType,
+		Role:             link.Role,
+		ExpiresAt:        link.ExpiresAt,
+		PasswordRequired: link.Password != nil,
+	}
+	if link.MaxDownloads != nil {
+		left := *link.MaxDownloads - link.DownloadCount
+		resource.DownloadsLeft = &left
+	}
+
+	access := &model.ShareLinkAccess{Action: model.ShareAccessView}
+	if link.ResourceType == model.ResourceTypeFile {
+		file, err := s.fileRepo.GetByID(ctx, link.ResourceID)
+		if err != nil {
+			return nil, ErrShareLinkNotFound
+		}
+		resource.File = model.NewSharedFile(file)
+		access.FileID = &file.ID
+	} else {
+		folder, err := s.folderRepo.GetByID(ctx, link.ResourceID)
+		if err != nil {
+			return nil, ErrShareLinkNotFound
+		}
+		resource.Folder = model.NewSharedFolder(folder)
+		access.FolderID = &folder.ID
+	}
+
+	s.logShareAccess(ctx, link, client, access)
+	return resource, nil
+}
+
+// UnlockShareLink checks the password of a password-protected share link and
+// returns a token that gives access to the link for a while. Addresses that
+// give too many wrong passwords are locked out of the link.
+func (s *driveService) UnlockShareLink(ctx context.Context, token, password string, client *model.ShareClient) (*model.ShareLinkGrant, error) {
+	link, err := s.shareLinkRepo.GetByToken(ctx, token)
+	if err != nil {
+		return nil, ErrShareLinkNotFound
+	}
+	if link.Password == nil {
+		return nil, fmt.Errorf("%w: share link has no password", ErrInvalidShareRequest)
+	}
+
+	failures, err := s.shareLinkRepo.CountFailedUnlocks(ctx, link.ID, client.IPAddress, time.Now().Add(-s.shareConfig.PasswordLockout))
+	if err != nil {
+		return nil, err
+	}
+	if failures >= s.shareConfig.MaxPasswordAttempts {
+		return nil, ErrTooManyUnlockAttempts
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(*link.Password), []byte(password)); err != nil {
+		s.logShareAccess(ctx, link, client, &model.ShareLinkAccess{Action: model.ShareAccessUnlockFailed})
+		return nil, ErrInvalidSharePassword
+	}
+
+	s.logShareAccess(ctx, link, client, &model.ShareLinkAccess{Action: model.ShareAccessUnlock})
+
+	expiresAt := time.Now().Add(s.shareConfig.AccessTTL)
+	if link.ExpiresAt != nil && link.ExpiresAt.Before(expiresAt) {
+		expiresAt = *link.ExpiresAt
+	}
+
+	return &model.ShareLinkGrant{
+		AccessToken: s.signShareAccess(link.ID, expiresAt),
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+// ListSharedFolder lists a folder shared by a public link, or a folder inside
+// it
+func (s *driveService) ListSharedFolder(ctx context.Context, token string, folderID *uuid.UUID, client *model.ShareClient) (*model.SharedFolderContents, error) {
+	link, err := s.openShareLink(ctx, token, client)
+	if err != nil {
+		return nil, err
+	}
+	if link.ResourceType != model.ResourceTypeFolder {
+		return nil, fmt.Errorf("%w: share link is for a file", ErrInvalidShareRequest)
+	}
+
+	folder, err := s.getSharedFolder(ctx, link, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	folders, err := s.folderRepo.GetChildren(ctx, folder.ID)
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.fileRepo.GetByFolders(ctx, []uuid.UUID{folder.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	contents := &model.SharedFolderContents{
+		Folder:  model.NewSharedFolder(folder),
+		Folders: make([]*model.SharedFolder, len(folders)),
+		Files:   make([]*model.SharedFile, len(files)),
+	}
+	for i, child := range folders {
+		contents.Folders[i] = model.NewSharedFolder(child)
+	}
+	for i, file := range files {
+		contents.Files[i] = model.NewSharedFile(file)
+	}
+
+	s.logShareAccess(ctx, link, client, &model.ShareLinkAccess{Action: model.ShareAccessList, FolderID: &folder.ID})
+	return contents, nil
+}
+
+// DownloadSharedFile downloads the file a public share link points at or,
+// for a link to a folder, the given file inside it. Each download counts
+// towards the link's download limit.
+func (s *driveService) DownloadSharedFile(ctx context.Context, token string, fileID *uuid.UUID, client *model.ShareClient) (io.ReadCloser, *model.File, error) {
+	link, err := s.openShareLink(ctx, token, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var file *model.File
+	if link.ResourceType == model.ResourceTypeFile {
+		if fileID != nil && *fileID != link.ResourceID {
+			return nil, nil, ErrNotInShare
+		}
+		file, err = s.fileRepo.GetByID(ctx, link.ResourceID)
+		if err != nil {
+			return nil, nil, ErrShareLinkNotFound
+		}
+	} else {
+		if fileID == nil {
+			return nil, nil, ErrShareIsFolder
+		}
+		file, err = s.fileRepo.GetByID(ctx, *fileID)
+		if err != nil {
+			return nil, nil, ErrNotInShare
+		}
+		if err := s.checkInSharedFolder(ctx, link, model.ResourceTypeFile, file.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	reader, err := s.storage.DownloadFile(ctx, file.StoragePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.countShareDownload(ctx, link); err != nil {
+		reader.Close()
+		return nil, nil, err
+	}
+
+	s.logShareAccess(ctx, link, client, &model.ShareLinkAccess{Action: model.ShareAccessDownload, FileID: &file.ID})
+	return reader, file, nil
+}
+
+// DownloadSharedFolder prepares a zip archive of a folder shared by a public
+// link, or of a folder inside it, and returns a function that streams the
+// archive. The download counts towards the link's download limit.
+func (s *driveService) DownloadSharedFolder(ctx context.Context, token string, folderID *uuid.UUID, client *model.ShareClient) (*model.Folder, func(w io.Writer) error, error) {
+	link, err := s.openShareLink(ctx, token, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	if link.ResourceType != model.ResourceTypeFolder {
+		return nil, nil, fmt.Errorf("%w: share link is for a file", ErrInvalidShareRequest)
+	}
+
+	folder, err := s.getSharedFolder(ctx, link, folderID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	folders, err := s.folderRepo.GetDescendants(ctx, folder.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	folderIDs := make([]uuid.UUID, len(folders))
+	for i, f := range folders {
+		folderIDs[i] = f.ID
+	}
+	files, err := s.fileRepo.GetByFolders(ctx, folderIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.countShareDownload(ctx, link); err != nil {
+		return nil, nil, err
+	}
+
+	s.logShareAccess(ctx, link, client, &model.ShareLinkAccess{Action: model.ShareAccessDownload, FolderID: &folder.ID})
+
+	write := func(w io.Writer) error {
+		return s.writeFolderArchive(ctx, w, folders, files)
+	}
+	return folder, write, nil
+}
+
+// writeFolderArchive writes folders, the first of which contains all the
+// others, and their files to w as a zip archive
+func (s *driveService) writeFolderArchive(ctx context.Context, w io.Writer, folders []*model.Folder, files []*model.File) error {
+	archive := s.newArchiveWriter(w)
+	if err := archive.addFolder(ctx, folders, files); err != nil {
+		return err
+	}
+	return archive.close()
+}
+
+// openShareLink returns the valid share link with the token, checking the
+// client's access token if the link has a password
+func (s *driveService) openShareLink(ctx context.Context, token string, client *model.ShareClient) (*model.ShareLink, error) {
+	link, err := s.shareLinkRepo.GetByToken(ctx, token)
+	if err != nil {
+		return nil, ErrShareLinkNotFound
+	}
+
+	if link.Password != nil && !s.verifyShareAccess(link.ID, client.AccessToken) {
+		return nil, ErrSharePasswordRequired
+	}
+
+	return link, nil
+}
+
+// getSharedFolder returns the folder a share link points at or, if folderID
+// is given, that folder if it is inside the shared one
+func (s *driveService) getSharedFolder(ctx context.Context, link *model.ShareLink, folderID *uuid.UUID) (*model.Folder, error) {
+	if folderID == nil || *folderID == link.ResourceID {
+		folder, err := s.folderRepo.GetByID(ctx, link.ResourceID)
+		if err != nil {
+			return nil, ErrShareLinkNotFound
+		}
+		return folder, nil
+	}
+
+	folder, err := s.folderRepo.GetByID(ctx, *folderID)
+	if err != nil {
+		return nil, ErrNotInShare
+	}
+	if err := s.checkInSharedFolder(ctx, link, model.ResourceTypeFolder, folder.ID); err != nil {
+		return nil, err
+	}
+
+	return folder, nil
+}
+
+func (s *driveService) checkInSharedFolder(ctx context.Context, link *model.ShareLink, resourceType model.ResourceType, resourceID uuid.UUID) error {
+	ancestors, err := s.permissionRepo.GetAncestors(ctx, resourceID, resourceType)
+	if err != nil {
+		return err
+	}
+
+	for _, ancestor := range ancestors {
+		if ancestor.ResourceType == model.ResourceTypeFolder && ancestor.ResourceID == link.ResourceID {
+			return nil
+		}
+	}
+
+	return ErrNotInShare
+}
+
+func (s *driveService) countShareDownload(ctx context.Context, link *model.ShareLink) error {
+	counted, err := s.shareLinkRepo.IncrementDownloadCount(ctx, link.ID)
+	if err != nil {
+		return err
+	}
+	if !counted {
+		return ErrShareDownloadLimit
+	}
+	return nil
+}
+
+// logShareAccess records a use of a share link. Failing to record it does
+// not fail the request.
+func (s *driveService) logShareAccess(ctx context.Context, link *model.ShareLink, client *model.ShareClient, access *model.ShareLinkAccess) {
+	access.ID = uuid.New()
+	access.LinkID = link.ID
+	access.IPAddress = client.IPAddress
+	if client.UserAgent != "" {
+		userAgent := client.UserAgent
+		access.UserAgent = &userAgent
+	}
+	access.CreatedAt = time.Now()
+
+	_ = s.shareLinkRepo.LogAccess(ctx, access)
+
+	// The activity log records it against the file or folder used, or the
+	// link's own resource
+	activity := &model.Activity{
+		Action:       model.ShareActivityAction(access.Action),
+		ResourceType: link.ResourceType,
+		ResourceID:   link.ResourceID,
+		ShareLinkID:  &link.ID,
+		IPAddress:    &access.IPAddress,
+		UserAgent:    access.UserAgent,
+	}
+	switch {
+	case access.FileID != nil:
+		activity.ResourceType, activity.ResourceID = model.ResourceTypeFile, *access.FileID
+	case access.FolderID != nil:
+		activity.ResourceType, activity.ResourceID = model.ResourceTypeFolder, *access.FolderID
+	}
+	s.logResourceActivity(ctx, activity)
+}
+
+// signShareAccess returns an access token for the link valid until expiresAt,
+// of the form base64(linkID.expiry).base64(HMAC-SHA256)
+func (s *driveService) signShareAccess(linkID uuid.UUID, expiresAt time.Time) string {
+	payload := linkID.String() + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(s.shareConfig.TokenSecret))
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *driveService) verifyShareAccess(linkID uuid.UUID, token string) bool {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.shareConfig.TokenSecret))
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return false
+	}
+
+	id, expiry, ok := strings.Cut(string(payload), ".")
+	if !ok || id != linkID.String() {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return time.Now().Unix() < expiresAt
+}
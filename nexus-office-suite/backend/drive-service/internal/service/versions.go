@@ -0,0 +1,338 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+	"github.com/nexus/drive-service/internal/preview"
+	"github.com/nexus/drive-service/internal/repository"
+	"github.com/nexus/drive-service/internal/textdiff"
+)
+
+const (
+	versionPruneBatchSize = 100
+	// maxDiffSize is the largest version, in bytes, that is compared
+	maxDiffSize = 1 << 20
+	// diffContext is the number of unchanged lines shown around changes
+	diffContext = 3
+	// maxRetention bounds retention policies, in versions and in days
+	maxRetention = 36500
+)
+
+var (
+	ErrVersionNotFound  = repository.ErrVersionNotFound
+	ErrInvalidVersion   = errors.New("invalid version")
+	ErrNotText          = errors.New("file is not a text file")
+	ErrDiffTooLarge     = errors.New("version is too large to compare")
+	ErrInvalidRetention = errors.New("invalid retention policy")
+)
+
+// UpdateVersion names or pins a version of a file
+func (s *driveService) UpdateVersion(ctx context.Context, fileID uuid.UUID, versionNum int, userID uuid.UUID, req *model.UpdateVersionRequest) (*model.FileVersion, error) {
+	file, err := s.fileRepo.GetByID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.OwnerID != userID {
+		hasPermission, err := s.permissionRepo.HasPermission(ctx, fileID, model.ResourceTypeFile, userID, model.PermissionEditor)
+		if err != nil || !hasPermission {
+			return nil, ErrPermissionDenied
+		}
+	}
+
+	version, err := s.versionRepo.GetByVersion(ctx, fileID, versionNum)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if len(name) > 255 {
+			return nil, fmt.Errorf("%w: name must be at most 255 bytes", ErrInvalidVersion)
+		}
+		version.Name = nil
+		if name != "" {
+			version.Name = &name
+		}
+	}
+	if req.Pinned != nil {
+		version.Pinned = *req.Pinned
+	}
+
+	if err := s.versionRepo.Update(ctx, version); err != nil {
+		return nil, err
+	}
+
+	details := model.Metadata{"version": version.VersionNum, "pinned": version.Pinned}
+	if version.Name != nil {
+		details["name"] = *version.Name
+	}
+	s.logFileActivity(ctx, file, userID, model.ActivityVersionUpdated, details)
+
+	return version, nil
+}
+
+// DiffVersions compares two versions of a text file line by line. A to of
+// 0 is the current version, and a from of 0 the version before to.
+func (s *driveService) DiffVersions(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, from, to int) (*model.VersionDiff, error) {
+	file, err := s.getFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !preview.IsText(file.MimeType, file.Name) {
+		return nil, fmt.Errorf("%w: only text files can be compared", ErrNotText)
+	}
+
+	if to == 0 {
+		to = file.Version
+	}
+	if from >= to {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidVersion)
+	}
+
+	versions, err := s.versionRepo.GetByFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions are newest first, so the first before to is the previous one
+	var fromVersion, toVersion *model.FileVersion
+	for _, version := range versions {
+		switch {
+		case version.VersionNum == to:
+			toVersion = version
+		case version.VersionNum == from, from == 0 && version.VersionNum < to && fromVersion == nil:
+			fromVersion = version
+		}
+	}
+	if fromVersion == nil || toVersion == nil {
+		return nil, ErrVersionNotFound
+	}
+
+	diff := &model.VersionDiff{
+		FileID:      fileID,
+		FromVersion: fromVersion.VersionNum,
+		ToVersion:   toVersion.VersionNum,
+		Hunks:       []*textdiff.Hunk{},
+	}
+	if fromVersion.StoragePath == toVersion.StoragePath {
+		return diff, nil
+	}
+
+	fromText, err := s.readVersionText(ctx, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	toText, err := s.readVersionText(ctx, toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := textdiff.Diff(fromText, toText)
+	for _, line := range lines {
+		switch line.Op {
+		case textdiff.OpInsert:
+			diff.Added++
+		case textdiff.OpDelete:
+			diff.Removed++
+		}
+	}
+	if hunks := textdiff.Hunks(lines, diffContext); hunks != nil {
+		diff.Hunks = hunks
+	}
+
+	return diff, nil
+}
+
+// readVersionText reads the content of a version, which must be UTF-8 text
+// of at most maxDiffSize bytes
+func (s *driveService) readVersionText(ctx context.Context, version *model.FileVersion) (string, error) {
+	if version.Size > maxDiffSize {
+		return "", fmt.Errorf("%w: version %d is larger than %d bytes", ErrDiffTooLarge, version.VersionNum, maxDiffSize)
+	}
+
+	reader, err := s.storage.DownloadFile(ctx, version.StoragePath)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxDiffSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read version: %w", err)
+	}
+	if len(data) > maxDiffSize {
+		return "", fmt.Errorf("%w: version %d is larger than %d bytes", ErrDiffTooLarge, version.VersionNum, maxDiffSize)
+	}
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("%w: version %d is not UTF-8 text", ErrNotText, version.VersionNum)
+	}
+
+	return strings.TrimPrefix(string(data), "\ufeff"), nil
+}
+
+// GetVersionRetention returns the retention policy of a tenant, which is
+// the configured default unless the tenant has set its own
+func (s *driveService) GetVersionRetention(ctx context.Context, tenantID uuid.UUID) (*model.VersionRetentionPolicy, error) {
+	policy, err := s.versionRepo.GetRetentionPolicy(ctx, tenantID)
+	if err != nil || policy != nil {
+		return policy, err
+	}
+
+	return &model.VersionRetentionPolicy{
+		TenantID:   tenantID,
+		KeepLast:   s.versionConfig.KeepLast,
+		KeepDays:   s.versionConfig.KeepDays,
+		DailyDays:  s.versionConfig.DailyDays,
+		WeeklyDays: s.versionConfig.WeeklyDays,
+		Default:    true,
+	}, nil
+}
+
+// SetVersionRetention sets the retention policy of a tenant. Versions the
+// policy no longer keeps are pruned in the background.
+func (s *driveService) SetVersionRetention(ctx context.Context, tenantID, userID uuid.UUID, req *model.SetVersionRetentionRequest) (*model.VersionRetentionPolicy, error) {
+	for _, value := range []int{req.KeepLast, req.KeepDays, req.DailyDays, req.WeeklyDays} {
+		if value < 0 || value > maxRetention {
+			return nil, fmt.Errorf("%w: values must be between 0 and %d", ErrInvalidRetention, maxRetention)
+		}
+	}
+
+	now := time.Now()
+	policy := &model.VersionRetentionPolicy{
+		TenantID:   tenantID,
+		KeepLast:   req.KeepLast,
+		KeepDays:   req.KeepDays,
+		DailyDays:  req.DailyDays,
+		WeeklyDays: req.WeeklyDays,
+		UpdatedBy:  &userID,
+		UpdatedAt:  &now,
+	}
+
+	if err := s.versionRepo.SetRetentionPolicy(ctx, policy); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// PruneVersions deletes the versions of files that their tenant's
+// retention policy no longer keeps and returns the number deleted. Blobs
+// no longer referenced are collected afterwards by
+// CollectUnreferencedBlobs; content stored before deduplication is deleted
+// here once nothing holds it.
+func (s *driveService) PruneVersions(ctx context.Context) (int, error) {
+	policies := make(map[uuid.UUID]*model.VersionRetentionPolicy)
+	pruned := 0
+	after := uuid.Nil
+
+	for {
+		files, err := s.versionRepo.GetFilesWithOldVersions(ctx, after, versionPruneBatchSize)
+		if err != nil {
+			return pruned, err
+		}
+
+		for _, file := range files {
+			if ctx.Err() != nil {
+				return pruned, ctx.Err()
+			}
+			after = file.ID
+
+			policy, ok := policies[file.TenantID]
+			if !ok {
+				if policy, err = s.GetVersionRetention(ctx, file.TenantID); err != nil {
+					return pruned, err
+				}
+				policies[file.TenantID] = policy
+			}
+			if policy.KeepsAll() {
+				continue
+			}
+
+			versions, err := s.versionRepo.GetByFile(ctx, file.ID)
+			if err != nil {
+				return pruned, err
+			}
+			prune := versionsToPrune(versions, file.Version, policy, time.Now())
+			if len(prune) == 0 {
+				continue
+			}
+
+			ids := make([]uuid.UUID, len(prune))
+			for i, version := range prune {
+				ids[i] = version.ID
+			}
+			deleted, err := s.versionRepo.DeletePrunable(ctx, ids)
+			if err != nil {
+				return pruned, err
+			}
+
+			for _, version := range deleted {
+				if version.Checksum != nil {
+					continue
+				}
+				if referenced, err := s.versionRepo.IsStoragePathReferenced(ctx, version.StoragePath); err == nil && !referenced {
+					_ = s.storage.DeleteFile(ctx, version.StoragePath)
+				}
+			}
+			pruned += len(deleted)
+
+			// Lower the warning thresholds reached, so that filling up again warns
+			if len(deleted) > 0 {
+				s.checkQuotaThresholds(ctx, file.TenantID, file.OwnerID, file.OwnerID)
+			}
+		}
+
+		if len(files) < versionPruneBatchSize {
+			return pruned, nil
+		}
+	}
+}
+
+// versionsToPrune returns the versions of a file, newest first, that a
+// retention policy does not keep at now. The newest and current versions
+// and named and pinned versions are always kept. Days and weeks are UTC,
+// and weeks start on Monday.
+func versionsToPrune(versions []*model.FileVersion, currentVersion int, policy *model.VersionRetentionPolicy, now time.Time) []*model.FileVersion {
+	if policy.KeepsAll() {
+		return nil
+	}
+
+	within := func(version *model.FileVersion, days int) bool {
+		return now.Sub(version.CreatedAt) < time.Duration(days)*24*time.Hour
+	}
+
+	seenDays := make(map[string]bool)
+	seenWeeks := make(map[string]bool)
+	var prune []*model.FileVersion
+
+	for i, version := range versions {
+		created := version.CreatedAt.UTC()
+		day := created.Format("2006-01-02")
+		year, week := created.ISOWeek()
+		weekKey := fmt.Sprintf("%d-W%02d", year, week)
+
+		newestOfDay, newestOfWeek := !seenDays[day], !seenWeeks[weekKey]
+		seenDays[day], seenWeeks[weekKey] = true, true
+
+		keep := i == 0 || version.VersionNum == currentVersion ||
+			version.Pinned || version.Name != nil ||
+			i < policy.KeepLast ||
+			within(version, policy.KeepDays) ||
+			newestOfDay && within(version, policy.DailyDays) ||
+			newestOfWeek && within(version, policy.WeeklyDays)
+		if !keep {
+			prune = append(prune, version)
+		}
+	}
+
+	return prune
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+func TestRestoreVersionRequiresEditor(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	s := newTestService(store)
+	s.quotaRepo = newFakeQuotaRepo()
+	alice, viewer, editor := uuid.New(), uuid.New(), uuid.New()
+
+	file := store.addFile(alice, nil)
+	store.addVersion(file.ID, "first")
+	store.addVersion(file.ID, "second")
+	store.grant(file.ID, viewer, model.PermissionViewer)
+	store.grant(file.ID, editor, model.PermissionEditor)
+
+	if _, err := s.RestoreVersion(ctx, file.ID, 1, viewer); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("viewer restoring a version: got %v, want %v", err, ErrPermissionDenied)
+	}
+	if got := store.files[file.ID].Version; got != 2 {
+		t.Errorf("file is at version %d after a denied restore, want 2", got)
+	}
+
+	restored, err := s.RestoreVersion(ctx, file.ID, 1, editor)
+	if err != nil {
+		t.Fatalf("editor restoring a version: %v", err)
+	}
+	if restored.Version != 3 || restored.StoragePath != "blobs/first" {
+		t.Errorf("restored file is version %d with %q, want version 3 with the first content", restored.Version, restored.StoragePath)
+	}
+}
@@ -0,0 +1,343 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/model"
+)
+
+var (
+	ErrPathNotFound = errors.New("path not found")
+	ErrInvalidName  = errors.New("invalid name")
+	ErrInvalidMove  = errors.New("cannot move a folder into itself")
+)
+
+// ResolvePath finds the folder or file at a slash-separated path of names
+// from the root of a user's drive, among the items the user can view. A
+// folder hides a file of the same name, and of items with the same name the
+// oldest is found. The root is a folder with a nil Folder.
+func (s *driveService) ResolvePath(ctx context.Context, tenantID, userID uuid.UUID, p string) (*model.FileInfo, error) {
+	p = path.Clean("/" + p)
+	info := &model.FileInfo{Type: "folder", Path: p}
+	if p == "/" {
+		return info, nil
+	}
+
+	names := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	var parentID *uuid.UUID
+	for i, name := range names {
+		folder, err := s.folderRepo.GetByName(ctx, tenantID, userID, parentID, name)
+		if err != nil {
+			return nil, err
+		}
+		if folder != nil {
+			info.Folder = folder
+			parentID = &folder.ID
+			continue
+		}
+
+		if i < len(names)-1 {
+			return nil, ErrPathNotFound
+		}
+		file, err := s.fileRepo.GetByName(ctx, tenantID, userID, parentID, name)
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			return nil, ErrPathNotFound
+		}
+		return &model.FileInfo{File: file, Type: "file", Path: p}, nil
+	}
+
+	return info, nil
+}
+
+// GetFileByName returns the file with a name in a folder, or at the root if
+// folderID is nil, among those the user can view. Of files with the same
+// name, the oldest is returned.
+func (s *driveService) GetFileByName(ctx context.Context, tenantID, userID uuid.UUID, folderID *uuid.UUID, name string) (*model.File, error) {
+	file, err := s.fileRepo.GetByName(ctx, tenantID, userID, folderID, name)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, ErrPathNotFound
+	}
+	return file, nil
+}
+
+// DownloadFileRange downloads length bytes of a file from offset, or the
+// rest of it if length is negative
+func (s *driveService) DownloadFileRange(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, offset, length int64) (io.ReadCloser, error) {
+	file, err := s.fileRepo.GetByID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked here rather than with GetFile, as clients read files in many
+	// ranges
+	if file.OwnerID != userID {
+		hasPermission, err := s.permissionRepo.HasPermission(ctx, fileID, model.ResourceTypeFile, userID, model.PermissionViewer)
+		if err != nil || !hasPermission {
+			return nil, ErrPermissionDenied
+		}
+	}
+
+	reader, err := s.storage.DownloadRange(ctx, file.StoragePath, offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	// A download is logged once, by the range it starts with
+	if offset == 0 {
+		s.logFileActivity(ctx, file, userID, model.ActivityDownloaded, nil)
+	}
+
+	return reader, nil
+}
+
+// UpdateFileContent replaces the content of a file with a new version. The
+// previous content is kept as the previous version. If ifMatch is not
+// empty, the file must still have one of the ETags it lists.
+func (s *driveService) UpdateFileContent(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, reader io.Reader, size int64, contentType, ifMatch string) (*model.File, error) {
+	file, err := s.fileRepo.GetByID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check permission
+	if file.OwnerID != userID {
+		hasPermission, err := s.permissionRepo.HasPermission(ctx, fileID, model.ResourceTypeFile, userID, model.PermissionEditor)
+		if err != nil || !hasPermission {
+			return nil, ErrPermissionDenied
+		}
+	}
+	if err := checkIfMatch(file, ifMatch); err != nil {
+		return nil, err
+	}
+	if err := s.checkLock(ctx, fileID, userID); err != nil {
+		return nil, err
+	}
+
+	return s.addVersion(ctx, file, userID, reader, size, contentType, nil, ifMatch)
+}
+
+// addVersion stores content from reader as a new version of a file, with an
+// optional comment
+func (s *driveService) addVersion(ctx context.Context, file *model.File, userID uuid.UUID, reader io.Reader, size int64, contentType string, comment *string, ifMatch string) (*model.File, error) {
+	// The file's owner is charged for its versions, whoever uploads them
+	blob, err := s.storeBlobWithin(ctx, file.TenantID, reader, size, detectContentType(contentType, file.Name), func(storagePath string, size int64) error {
+		return s.checkQuota(ctx, file.TenantID, file.OwnerID, storagePath, size)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkQuota(ctx, file.TenantID, file.OwnerID, blob.StoragePath, blob.Size); err != nil {
+		return nil, err
+	}
+
+	latestVersion, _ := s.versionRepo.GetLatestVersionNum(ctx, file.ID)
+	version := &model.FileVersion{
+		ID:          uuid.New(),
+		FileID:      file.ID,
+		VersionNum:  latestVersion + 1,
+		Size:        blob.Size,
+		StoragePath: blob.StoragePath,
+		Checksum:    &blob.Checksum,
+		CreatedBy:   userID,
+		Comment:     comment,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.versionRepo.Create(ctx, version); err != nil {
+		return nil, err
+	}
+
+	file.StoragePath = blob.StoragePath
+	file.Size = blob.Size
+	file.Checksum = &blob.Checksum
+	file.Version = version.VersionNum
+	file.ThumbnailPath = nil
+	file.UpdatedAt = time.Now()
+
+	if err := s.fileRepo.UpdateContent(ctx, file); err != nil {
+		return nil, updateError(err, ifMatch)
+	}
+
+	s.requestPreview(ctx, file)
+	s.recordChange(ctx, file.TenantID, userID, model.ResourceTypeFile, file.ID, model.ChangeUpdated, nil)
+	details := model.Metadata{"version": version.VersionNum}
+	if comment != nil {
+		details["comment"] = *comment
+	}
+	s.logFileActivity(ctx, file, userID, model.ActivityVersionAdded, details)
+	s.checkQuotaThresholds(ctx, file.TenantID, file.OwnerID, userID)
+
+	return file, nil
+}
+
+// RenameFile moves a file into a folder, or to the root if folderID is nil,
+// under a new name
+func (s *driveService) RenameFile(ctx context.Context, fileID uuid.UUID, userID uuid.UUID, folderID *uuid.UUID, name string) (*model.File, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+
+	file, err := s.fileRepo.GetByID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check permission
+	if file.OwnerID != userID {
+		hasPermission, err := s.permissionRepo.HasPermission(ctx, fileID, model.ResourceTypeFile, userID, model.PermissionEditor)
+		if err != nil || !hasPermission {
+			return nil, ErrPermissionDenied
+		}
+	}
+	if err := s.checkMoveTarget(ctx, model.ResourceTypeFile, fileID, folderID, userID); err != nil {
+		return nil, err
+	}
+	if err := s.checkLock(ctx, fileID, userID); err != nil {
+		return nil, err
+	}
+
+	changeType := fileChangeType(file.Name, file.FolderID, name, folderID)
+	var before []uuid.UUID
+	if changeType == model.ChangeMoved {
+		before = s.audience(ctx, model.ResourceTypeFile, fileID)
+	}
+
+	oldName, oldFolderID := file.Name, file.FolderID
+	file.Name = name
+	file.FolderID = folderID
+
+	if err := s.fileRepo.Update(ctx, file); err != nil {
+		return nil, updateError(err, "")
+	}
+
+	s.recordChange(ctx, file.TenantID, userID, model.ResourceTypeFile, fileID, changeType, before)
+	s.logMoveActivity(ctx, fileActivity(file, userID, "", nil), oldName, oldFolderID, folderID)
+
+	return file, nil
+}
+
+// RenameFolder moves a folder into another folder, or to the root if
+// parentID is nil, under a new name
+func (s *driveService) RenameFolder(ctx context.Context, folderID uuid.UUID, userID uuid.UUID, parentID *uuid.UUID, name string) (*model.Folder, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+
+	folder, err := s.folderRepo.GetByID(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check permission
+	if folder.OwnerID != userID {
+		hasPermission, err := s.permissionRepo.HasPermission(ctx, folderID, model.ResourceTypeFolder, userID, model.PermissionEditor)
+		if err != nil || !hasPermission {
+			return nil, ErrPermissionDenied
+		}
+	}
+	if err := s.checkMoveTarget(ctx, model.ResourceTypeFolder, folderID, parentID, userID); err != nil {
+		return nil, err
+	}
+	if err := s.checkFolderCycle(ctx, folderID, parentID); err != nil {
+		return nil, err
+	}
+
+	changeType := fileChangeType(folder.Name, folder.ParentID, name, parentID)
+	var before []uuid.UUID
+	if changeType == model.ChangeMoved {
+		before = s.audience(ctx, model.ResourceTypeFolder, folderID)
+	}
+
+	oldName, oldParentID := folder.Name, folder.ParentID
+	folder.Name = name
+	folder.ParentID = parentID
+
+	if err := s.folderRepo.Update(ctx, folder); err != nil {
+		return nil, err
+	}
+
+	s.recordChange(ctx, folder.TenantID, userID, model.ResourceTypeFolder, folderID, changeType, before)
+	s.logMoveActivity(ctx, folderActivity(folder, userID, "", nil), oldName, oldParentID, parentID)
+
+	return folder, nil
+}
+
+// checkFolderAccess checks that a user has at least the given role on a
+// folder. Everyone may add to the root of their own drive.
+func (s *driveService) checkFolderAccess(ctx context.Context, folderID *uuid.UUID, userID uuid.UUID, role model.PermissionRole) error {
+	if folderID == nil {
+		return nil
+	}
+
+	hasPermission, err := s.permissionRepo.HasPermission(ctx, *folderID, model.ResourceTypeFolder, userID, role)
+	if err != nil || !hasPermission {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// checkMoveTarget checks that a user may move a resource into a folder, or
+// to the root if folderID is nil. They must be able to edit the folder, and
+// since the owner of a folder owns everything in it, only someone who owns
+// the resource already may move it into a folder they own.
+func (s *driveService) checkMoveTarget(ctx context.Context, resourceType model.ResourceType, resourceID uuid.UUID, folderID *uuid.UUID, userID uuid.UUID) error {
+	if err := s.checkFolderAccess(ctx, folderID, userID, model.PermissionEditor); err != nil {
+		return err
+	}
+	if folderID == nil {
+		return nil
+	}
+
+	ownsFolder, err := s.permissionRepo.HasPermission(ctx, *folderID, model.ResourceTypeFolder, userID, model.PermissionOwner)
+	if err != nil {
+		return ErrPermissionDenied
+	}
+	if !ownsFolder {
+		return nil
+	}
+	ownsResource, err := s.permissionRepo.HasPermission(ctx, resourceID, resourceType, userID, model.PermissionOwner)
+	if err != nil || !ownsResource {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// checkFolderCycle returns ErrInvalidMove if parentID is the folder or one
+// of its subfolders
+func (s *driveService) checkFolderCycle(ctx context.Context, folderID uuid.UUID, parentID *uuid.UUID) error {
+	if parentID == nil {
+		return nil
+	}
+
+	// The folder's descendants include the folder itself
+	descendants, err := s.folderRepo.GetDescendants(ctx, folderID)
+	if err != nil {
+		return err
+	}
+	for _, descendant := range descendants {
+		if descendant.ID == *parentID {
+			return ErrInvalidMove
+		}
+	}
+	return nil
+}
+
+func validateName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") || len(name) > 255 {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return nil
+}
@@ -0,0 +1,395 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LocalStorage implements Storage on the local filesystem, for development
+// and single-node installs. Objects are files under root/objects; writes go
+// to root/tmp first and are renamed into place, so readers never see
+// partial content. Because objects are files, a path cannot be both an
+// object and a prefix of other objects.
+type LocalStorage struct {
+	root string
+}
+
+// NewLocalStorage creates a filesystem storage rooted at the given directory,
+// creating it if needed
+func NewLocalStorage(root string) (*LocalStorage, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve storage path: %w", err)
+	}
+
+	for _, dir := range []string{"objects", "tmp", "multipart"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create storage directory: %w", err)
+		}
+	}
+
+	return &LocalStorage{root: root}, nil
+}
+
+// UploadFile stores a file at tenantID/year/month/fileID/filename
+func (s *LocalStorage) UploadFile(ctx context.Context, tenantID, fileID uuid.UUID, filename string, reader io.Reader, size int64, contentType string) (string, error) {
+	storagePath := uploadFilePath(tenantID, fileID, filename)
+	if err := s.UploadObject(ctx, storagePath, reader, size, contentType); err != nil {
+		return "", err
+	}
+	return storagePath, nil
+}
+
+// UploadObject stores content at the given storage path. A size of -1
+// reads content of unknown length.
+func (s *LocalStorage) UploadObject(ctx context.Context, storagePath string, reader io.Reader, size int64, contentType string) error {
+	objectPath, err := s.objectPath(storagePath)
+	if err != nil {
+		return err
+	}
+
+	if size >= 0 {
+		reader = &exactReader{r: io.LimitReader(reader, size), remaining: size}
+	}
+	if err := s.writeFile(ctx, objectPath, reader); err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	return nil
+}
+
+// DownloadFile opens the content stored at the storage path
+func (s *LocalStorage) DownloadFile(ctx context.Context, storagePath string) (io.ReadCloser, error) {
+	file, _, err := s.open(storagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	return file, nil
+}
+
+// DownloadRange opens part of the content stored at the storage path
+func (s *LocalStorage) DownloadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	file, size, err := s.open(storagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+
+	start, end, err := rangeBounds(size, offset, length)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &sectionReadCloser{
+		Reader: io.NewSectionReader(file, start, end-start),
+		Closer: file,
+	}, nil
+}
+
+// DeleteFile deletes the content stored at the storage path and any
+// directories left empty
+func (s *LocalStorage) DeleteFile(ctx context.Context, storagePath string) error {
+	objectPath, err := s.objectPath(storagePath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(objectPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	objectsRoot := filepath.Join(s.root, "objects")
+	for dir := filepath.Dir(objectPath); dir != objectsRoot; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// GetFileURL is not supported, as nothing serves local files over HTTP
+func (s *LocalStorage) GetFileURL(ctx context.Context, storagePath string, expiryDuration time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+// CopyFile copies content to another storage path
+func (s *LocalStorage) CopyFile(ctx context.Context, sourcePath, destPath string) error {
+	destObjectPath, err := s.objectPath(destPath)
+	if err != nil {
+		return err
+	}
+
+	source, _, err := s.open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	defer source.Close()
+
+	if err := s.writeFile(ctx, destObjectPath, source); err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return nil
+}
+
+// FileExists reports whether content is stored at the storage path
+func (s *LocalStorage) FileExists(ctx context.Context, storagePath string) (bool, error) {
+	objectPath, err := s.objectPath(storagePath)
+	if err != nil {
+		return false, nil
+	}
+
+	info, err := os.Stat(objectPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			return false, nil
+		}
+		return false, err
+	}
+	return info.Mode().IsRegular(), nil
+}
+
+// NewMultipartUpload starts a multipart upload and returns its upload ID.
+// Parts are kept under root/multipart/{uploadID} until the upload is
+// completed or aborted.
+func (s *LocalStorage) NewMultipartUpload(ctx context.Context, storagePath, contentType string) (string, error) {
+	if err := validatePath(storagePath); err != nil {
+		return "", err
+	}
+
+	uploadID := uuid.New().String()
+	uploadDir := s.uploadDir(uploadID)
+	if err := os.Mkdir(uploadDir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(uploadDir, "path"), []byte(storagePath), 0o640); err != nil {
+		os.RemoveAll(uploadDir)
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+
+	return uploadID, nil
+}
+
+// UploadPart stores one part of a multipart upload and returns its ETag,
+// the hex MD5 of the part. The part is checked against sha256Hex if given.
+func (s *LocalStorage) UploadPart(ctx context.Context, storagePath, uploadID string, partNumber int, reader io.Reader, size int64, sha256Hex string) (string, error) {
+	uploadDir, err := s.openUpload(storagePath, uploadID)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part: %w", err)
+	}
+
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	reader = io.TeeReader(&exactReader{r: io.LimitReader(reader, size), remaining: size}, io.MultiWriter(md5Hash, sha256Hash))
+
+	partPath := filepath.Join(uploadDir, fmt.Sprintf("part-%05d", partNumber))
+	if err := s.writeFile(ctx, partPath, reader); err != nil {
+		return "", fmt.Errorf("failed to upload part: %w", err)
+	}
+
+	if sha256Hex != "" && hex.EncodeToString(sha256Hash.Sum(nil)) != sha256Hex {
+		os.Remove(partPath)
+		return "", fmt.Errorf("failed to upload part: %w", ErrChecksumMismatch)
+	}
+
+	return hex.EncodeToString(md5Hash.Sum(nil)), nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the object
+func (s *LocalStorage) CompleteMultipartUpload(ctx context.Context, storagePath, uploadID string, parts []Part) error {
+	uploadDir, err := s.openUpload(storagePath, uploadID)
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	objectPath, err := s.objectPath(storagePath)
+	if err != nil {
+		return err
+	}
+
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return fmt.Errorf("failed to complete multipart upload: %w: part %d", ErrInvalidPart, part.PartNumber)
+		}
+	}
+
+	// Parts are checked against their ETags as they are copied
+	reader := &partsReader{dir: uploadDir, parts: parts}
+	defer reader.Close()
+	if err := s.writeFile(ctx, objectPath, reader); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	return os.RemoveAll(uploadDir)
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (s *LocalStorage) AbortMultipartUpload(ctx context.Context, storagePath, uploadID string) error {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return nil
+	}
+	if err := os.RemoveAll(s.uploadDir(uploadID)); err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStorage) objectPath(storagePath string) (string, error) {
+	if err := validatePath(storagePath); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, "objects", filepath.FromSlash(storagePath)), nil
+}
+
+func (s *LocalStorage) uploadDir(uploadID string) string {
+	return filepath.Join(s.root, "multipart", uploadID)
+}
+
+// open opens the object at the storage path and returns its size
+func (s *LocalStorage) open(storagePath string) (*os.File, int64, error) {
+	objectPath, err := s.objectPath(storagePath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	file, err := os.Open(objectPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			return nil, 0, ErrNotFound
+		}
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, 0, ErrNotFound
+	}
+
+	return file, info.Size(), nil
+}
+
+// openUpload returns the directory of a multipart upload to the storage path
+func (s *LocalStorage) openUpload(storagePath, uploadID string) (string, error) {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return "", ErrNotFound
+	}
+
+	uploadDir := s.uploadDir(uploadID)
+	uploadPath, err := os.ReadFile(filepath.Join(uploadDir, "path"))
+	if err != nil || string(uploadPath) != storagePath {
+		return "", ErrNotFound
+	}
+	return uploadDir, nil
+}
+
+// writeFile writes content to a temporary file and renames it into place
+func (s *LocalStorage) writeFile(ctx context.Context, path string, reader io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.root, "tmp"), "upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// exactReader fails with io.ErrUnexpectedEOF if its reader ends before
+// remaining bytes have been read
+type exactReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (r *exactReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		return n, fmt.Errorf("content is %d bytes short: %w", r.remaining, io.ErrUnexpectedEOF)
+	}
+	return n, err
+}
+
+// partsReader reads the parts of a multipart upload one after another,
+// failing if a part is missing or does not match its ETag
+type partsReader struct {
+	dir     string
+	parts   []Part
+	current *os.File
+	hash    hash.Hash
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			file, err := os.Open(filepath.Join(r.dir, fmt.Sprintf("part-%05d", r.parts[0].PartNumber)))
+			if err != nil {
+				return 0, fmt.Errorf("%w: part %d", ErrInvalidPart, r.parts[0].PartNumber)
+			}
+			r.current = file
+			r.hash = md5.New()
+		}
+
+		n, err := r.current.Read(p)
+		r.hash.Write(p[:n])
+		if err == io.EOF {
+			part := r.parts[0]
+			r.current.Close()
+			r.current = nil
+			r.parts = r.parts[1:]
+			if !strings.EqualFold(hex.EncodeToString(r.hash.Sum(nil)), part.ETag) {
+				return n, fmt.Errorf("%w: part %d", ErrInvalidPart, part.PartNumber)
+			}
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+func (r *partsReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
+
+type sectionReadCloser struct {
+	io.Reader
+	io.Closer
+}
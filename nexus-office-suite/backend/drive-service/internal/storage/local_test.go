@@ -0,0 +1,18 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/nexus/drive-service/internal/storage"
+	"github.com/nexus/drive-service/internal/storage/storagetest"
+)
+
+func TestLocalStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := storage.NewLocalStorage(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewLocalStorage: %v", err)
+		}
+		return s
+	})
+}
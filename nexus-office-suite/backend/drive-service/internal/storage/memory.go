@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStorage implements Storage in memory, for tests and development.
+// Content is lost when the process exits.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+	uploads map[string]*memoryUpload
+}
+
+type memoryUpload struct {
+	storagePath string
+	parts       map[int][]byte
+}
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]*memoryUpload),
+	}
+}
+
+// UploadFile stores a file at tenantID/year/month/fileID/filename
+func (s *MemoryStorage) UploadFile(ctx context.Context, tenantID, fileID uuid.UUID, filename string, reader io.Reader, size int64, contentType string) (string, error) {
+	storagePath := uploadFilePath(tenantID, fileID, filename)
+	if err := s.UploadObject(ctx, storagePath, reader, size, contentType); err != nil {
+		return "", err
+	}
+	return storagePath, nil
+}
+
+// UploadObject stores content at the given storage path. A size of -1
+// reads content of unknown length.
+func (s *MemoryStorage) UploadObject(ctx context.Context, storagePath string, reader io.Reader, size int64, contentType string) error {
+	if err := validatePath(storagePath); err != nil {
+		return err
+	}
+
+	data, err := readObject(reader, size)
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[storagePath] = data
+	return nil
+}
+
+// DownloadFile returns the content stored at the storage path
+func (s *MemoryStorage) DownloadFile(ctx context.Context, storagePath string) (io.ReadCloser, error) {
+	data, err := s.get(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// DownloadRange returns part of the content stored at the storage path
+func (s *MemoryStorage) DownloadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	data, err := s.get(storagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, err := rangeBounds(int64(len(data)), offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data[start:end])), nil
+}
+
+// DeleteFile deletes the content stored at the storage path
+func (s *MemoryStorage) DeleteFile(ctx context.Context, storagePath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, storagePath)
+	return nil
+}
+
+// GetFileURL is not supported, as nothing serves memory content over HTTP
+func (s *MemoryStorage) GetFileURL(ctx context.Context, storagePath string, expiryDuration time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+// CopyFile copies content to another storage path
+func (s *MemoryStorage) CopyFile(ctx context.Context, sourcePath, destPath string) error {
+	if err := validatePath(destPath); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.objects[sourcePath]
+	if !ok {
+		return fmt.Errorf("failed to copy file: %w", ErrNotFound)
+	}
+	// Stored content is never modified in place, so it can be shared
+	s.objects[destPath] = data
+	return nil
+}
+
+// FileExists reports whether content is stored at the storage path
+func (s *MemoryStorage) FileExists(ctx context.Context, storagePath string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.objects[storagePath]
+	return ok, nil
+}
+
+// NewMultipartUpload starts a multipart upload and returns its upload ID
+func (s *MemoryStorage) NewMultipartUpload(ctx context.Context, storagePath, contentType string) (string, error) {
+	if err := validatePath(storagePath); err != nil {
+		return "", err
+	}
+
+	uploadID := uuid.New().String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads[uploadID] = &memoryUpload{storagePath: storagePath, parts: make(map[int][]byte)}
+	return uploadID, nil
+}
+
+// UploadPart stores one part of a multipart upload and returns its ETag,
+// the hex MD5 of the part. The part is checked against sha256Hex if given.
+func (s *MemoryStorage) UploadPart(ctx context.Context, storagePath, uploadID string, partNumber int, reader io.Reader, size int64, sha256Hex string) (string, error) {
+	data, err := readObject(reader, size)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part: %w", err)
+	}
+	if sha256Hex != "" {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != sha256Hex {
+			return "", fmt.Errorf("failed to upload part: %w", ErrChecksumMismatch)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.storagePath != storagePath {
+		return "", fmt.Errorf("failed to upload part: %w", ErrNotFound)
+	}
+	upload.parts[partNumber] = data
+
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the object
+func (s *MemoryStorage) CompleteMultipartUpload(ctx context.Context, storagePath, uploadID string, parts []Part) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.storagePath != storagePath {
+		return fmt.Errorf("failed to complete multipart upload: %w", ErrNotFound)
+	}
+
+	var buf bytes.Buffer
+	for i, part := range parts {
+		data, ok := upload.parts[part.PartNumber]
+		sum := md5.Sum(data)
+		if !ok || hex.EncodeToString(sum[:]) != part.ETag || (i > 0 && part.PartNumber <= parts[i-1].PartNumber) {
+			return fmt.Errorf("failed to complete multipart upload: %w: part %d", ErrInvalidPart, part.PartNumber)
+		}
+		buf.Write(data)
+	}
+
+	s.objects[storagePath] = buf.Bytes()
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (s *MemoryStorage) AbortMultipartUpload(ctx context.Context, storagePath, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+func (s *MemoryStorage) get(storagePath string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.objects[storagePath]
+	if !ok {
+		return nil, fmt.Errorf("failed to download file: %w", ErrNotFound)
+	}
+	return data, nil
+}
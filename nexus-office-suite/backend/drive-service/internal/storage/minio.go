@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"path/filepath"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,17 +12,7 @@ import (
 	"github.com/nexus/drive-service/config"
 )
 
-// StorageService defines the interface for file storage operations
-type StorageService interface {
-	UploadFile(ctx context.Context, tenantID, fileID uuid.UUID, filename string, reader io.Reader, size int64, contentType string) (string, error)
-	DownloadFile(ctx context.Context, storagePath string) (io.ReadCloser, error)
-	DeleteFile(ctx context.Context, storagePath string) error
-	GetFileURL(ctx context.Context, storagePath string, expiryDuration time.Duration) (string, error)
-	CopyFile(ctx context.Context, sourcePath, destPath string) error
-	FileExists(ctx context.Context, storagePath string) (bool, error)
-}
-
-// MinIOStorage implements StorageService using MinIO
+// MinIOStorage implements Storage using MinIO
 type MinIOStorage struct {
 	client *minio.Client
 	bucket string
@@ -73,16 +62,7 @@ func (s *MinIOStorage) ensureBucket(ctx context.Context) error {
 
 // UploadFile uploads a file to MinIO
 func (s *MinIOStorage) UploadFile(ctx context.Context, tenantID, fileID uuid.UUID, filename string, reader io.Reader, size int64, contentType string) (string, error) {
-	// Generate storage path: tenantID/year/month/fileID/filename
-	now := time.Now()
-	storagePath := fmt.Sprintf(
-		"%s/%d/%02d/%s/%s",
-		tenantID.String(),
-		now.Year(),
-		now.Month(),
-		fileID.String(),
-		filename,
-	)
+	storagePath := uploadFilePath(tenantID, fileID, filename)
 
 	// Upload file
 	_, err := s.client.PutObject(ctx, s.bucket, storagePath, reader, size, minio.PutObjectOptions{
@@ -95,6 +75,18 @@ func (s *MinIOStorage) UploadFile(ctx context.Context, tenantID, fileID uuid.UUI
 	return storagePath, nil
 }
 
+// UploadObject uploads content to the given storage path. A size of -1
+// streams content of unknown length.
+func (s *MinIOStorage) UploadObject(ctx context.Context, storagePath string, reader io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, storagePath, reader, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	return nil
+}
+
 // DownloadFile downloads a file from MinIO
 func (s *MinIOStorage) DownloadFile(ctx context.Context, storagePath string) (io.ReadCloser, error) {
 	object, err := s.client.GetObject(ctx, s.bucket, storagePath, minio.GetObjectOptions{})
@@ -106,7 +98,45 @@ func (s *MinIOStorage) DownloadFile(ctx context.Context, storagePath string) (io
 	_, err = object.Stat()
 	if err != nil {
 		object.Close()
-		return nil, fmt.Errorf("file not found: %w", err)
+		return nil, fmt.Errorf("failed to download file: %w", mapMinIOError(err))
+	}
+
+	return object, nil
+}
+
+// DownloadRange downloads part of a file from MinIO
+func (s *MinIOStorage) DownloadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || length == 0 {
+		return nil, ErrInvalidRange
+	}
+
+	opts := minio.GetObjectOptions{}
+	var err error
+	switch {
+	case length > 0:
+		err = opts.SetRange(offset, offset+length-1)
+	case offset > 0:
+		err = opts.SetRange(offset, 0)
+	}
+	if err != nil {
+		return nil, ErrInvalidRange
+	}
+
+	object, err := s.client.GetObject(ctx, s.bucket, storagePath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, fmt.Errorf("failed to download file: %w", mapMinIOError(err))
+	}
+	// The whole object is requested without a Range header, so MinIO
+	// cannot reject an empty one
+	if opts.Header().Get("Range") == "" && info.Size == 0 {
+		object.Close()
+		return nil, ErrInvalidRange
 	}
 
 	return object, nil
@@ -130,7 +160,7 @@ func (s *MinIOStorage) GetFileURL(ctx context.Context, storagePath string, expir
 	return url.String(), nil
 }
 
-// CopyFile copies a file within MinIO
+// CopyFile copies a file within MinIO. Objects over 5GB are copied in parts.
 func (s *MinIOStorage) CopyFile(ctx context.Context, sourcePath, destPath string) error {
 	src := minio.CopySrcOptions{
 		Bucket: s.bucket,
@@ -142,9 +172,9 @@ func (s *MinIOStorage) CopyFile(ctx context.Context, sourcePath, destPath string
 		Object: destPath,
 	}
 
-	_, err := s.client.CopyObject(ctx, dst, src)
+	_, err := s.client.ComposeObject(ctx, dst, src)
 	if err != nil {
-		return fmt.Errorf("failed to copy file: %w", err)
+		return fmt.Errorf("failed to copy file: %w", mapMinIOError(err))
 	}
 
 	return nil
@@ -163,16 +193,72 @@ func (s *MinIOStorage) FileExists(ctx context.Context, storagePath string) (bool
 	return true, nil
 }
 
-// GetStoragePath generates a storage path for a file
-func GetStoragePath(tenantID, fileID uuid.UUID, filename string) string {
-	now := time.Now()
-	ext := filepath.Ext(filename)
-	return fmt.Sprintf(
-		"%s/%d/%02d/%s%s",
-		tenantID.String(),
-		now.Year(),
-		now.Month(),
-		fileID.String(),
-		ext,
-	)
+// NewMultipartUpload starts a multipart upload and returns its upload ID
+func (s *MinIOStorage) NewMultipartUpload(ctx context.Context, storagePath, contentType string) (string, error) {
+	core := minio.Core{Client: s.client}
+	uploadID, err := core.NewMultipartUpload(ctx, s.bucket, storagePath, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	return uploadID, nil
+}
+
+// UploadPart uploads one part of a multipart upload and returns its ETag.
+// MinIO verifies the part against sha256Hex.
+func (s *MinIOStorage) UploadPart(ctx context.Context, storagePath, uploadID string, partNumber int, reader io.Reader, size int64, sha256Hex string) (string, error) {
+	core := minio.Core{Client: s.client}
+	part, err := core.PutObjectPart(ctx, s.bucket, storagePath, uploadID, partNumber, reader, size, minio.PutObjectPartOptions{
+		Sha256Hex: sha256Hex,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part: %w", mapMinIOError(err))
+	}
+	return part.ETag, nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the object
+func (s *MinIOStorage) CompleteMultipartUpload(ctx context.Context, storagePath, uploadID string, parts []Part) error {
+	core := minio.Core{Client: s.client}
+	completeParts := make([]minio.CompletePart, len(parts))
+	for i, part := range parts {
+		completeParts[i] = minio.CompletePart{
+			PartNumber: part.PartNumber,
+			ETag:       part.ETag,
+		}
+	}
+
+	_, err := core.CompleteMultipartUpload(ctx, s.bucket, storagePath, uploadID, completeParts, minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", mapMinIOError(err))
+	}
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (s *MinIOStorage) AbortMultipartUpload(ctx context.Context, storagePath, uploadID string) error {
+	core := minio.Core{Client: s.client}
+	err := core.AbortMultipartUpload(ctx, s.bucket, storagePath, uploadID)
+	if err != nil {
+		errResponse := minio.ToErrorResponse(err)
+		if errResponse.Code == "NoSuchUpload" {
+			return nil
+		}
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return nil
+}
+
+// mapMinIOError maps MinIO error responses to the storage errors
+func mapMinIOError(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchUpload":
+		return ErrNotFound
+	case "InvalidRange":
+		return ErrInvalidRange
+	case "XAmzContentSHA256Mismatch", "BadDigest":
+		return ErrChecksumMismatch
+	}
+	return err
 }
@@ -0,0 +1,39 @@
+package storage_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/nexus/drive-service/config"
+	"github.com/nexus/drive-service/internal/storage"
+	"github.com/nexus/drive-service/internal/storage/storagetest"
+)
+
+// TestMinIOStorage runs against the MinIO server at MINIO_TEST_ENDPOINT,
+// using MINIO_TEST_ACCESS_KEY, MINIO_TEST_SECRET_KEY and MINIO_TEST_BUCKET
+func TestMinIOStorage(t *testing.T) {
+	endpoint := os.Getenv("MINIO_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MINIO_TEST_ENDPOINT not set")
+	}
+
+	cfg := &config.MinIOConfig{
+		Endpoint:  endpoint,
+		AccessKey: os.Getenv("MINIO_TEST_ACCESS_KEY"),
+		SecretKey: os.Getenv("MINIO_TEST_SECRET_KEY"),
+		Bucket:    os.Getenv("MINIO_TEST_BUCKET"),
+		Region:    "us-east-1",
+	}
+	if cfg.Bucket == "" {
+		cfg.Bucket = "nexus-drive-test"
+	}
+
+	s, err := storage.NewMinIOStorage(cfg)
+	if err != nil {
+		t.Fatalf("NewMinIOStorage: %v", err)
+	}
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return s
+	})
+}
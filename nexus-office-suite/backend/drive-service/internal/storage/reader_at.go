@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ReaderAtBlockSize is how much a ReaderAt downloads at a time
+const ReaderAtBlockSize = 1 << 20
+
+// ReaderAt reads an object at any offset with ranged downloads, for readers
+// such as archive/zip that need random access. It downloads a block at a
+// time and keeps the last block, so reading sequentially takes one download
+// per block.
+type ReaderAt struct {
+	ctx         context.Context
+	storage     Storage
+	storagePath string
+	size        int64
+
+	mu          sync.Mutex
+	block       []byte
+	blockOffset int64
+}
+
+// NewReaderAt returns a ReaderAt of the object at storagePath, which is
+// size bytes long
+func NewReaderAt(ctx context.Context, storage Storage, storagePath string, size int64) *ReaderAt {
+	return &ReaderAt{ctx: ctx, storage: storage, storagePath: storagePath, size: size}
+}
+
+// Size returns the size of the object
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("%w: negative offset", ErrInvalidRange)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) && off < r.size {
+		if r.block == nil || off < r.blockOffset || off >= r.blockOffset+int64(len(r.block)) {
+			if err := r.load(off); err != nil {
+				return n, err
+			}
+		}
+
+		copied := copy(p[n:], r.block[off-r.blockOffset:])
+		n += copied
+		off += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// load downloads the block starting at off
+func (r *ReaderAt) load(off int64) error {
+	length := min(int64(ReaderAtBlockSize), r.size-off)
+
+	reader, err := r.storage.DownloadRange(r.ctx, r.storagePath, off, length)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	block := make([]byte, length)
+	if _, err := io.ReadFull(reader, block); err != nil {
+		r.block = nil
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+
+	r.block, r.blockOffset = block, off
+	return nil
+}
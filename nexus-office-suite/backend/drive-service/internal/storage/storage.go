@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/config"
+)
+
+var (
+	// ErrNotFound is returned when an object or multipart upload does not
+	// exist
+	ErrNotFound = errors.New("object not found")
+	// ErrInvalidRange is returned when a range starts past the end of an
+	// object
+	ErrInvalidRange = errors.New("invalid range")
+	// ErrInvalidPath is returned for storage paths a driver cannot store
+	ErrInvalidPath = errors.New("invalid storage path")
+	// ErrInvalidPart is returned when completing a multipart upload with
+	// parts that were not uploaded or are out of order
+	ErrInvalidPart = errors.New("invalid multipart upload part")
+	// ErrChecksumMismatch is returned when uploaded content does not match
+	// its checksum
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrNotSupported is returned by drivers that cannot perform an
+	// operation, such as presigning URLs for objects nothing serves over HTTP
+	ErrNotSupported = errors.New("operation not supported by storage driver")
+)
+
+// Storage defines the interface for file storage operations. Objects are
+// addressed by slash-separated storage paths.
+type Storage interface {
+	UploadFile(ctx context.Context, tenantID, fileID uuid.UUID, filename string, reader io.Reader, size int64, contentType string) (string, error)
+	UploadObject(ctx context.Context, storagePath string, reader io.Reader, size int64, contentType string) error
+	DownloadFile(ctx context.Context, storagePath string) (io.ReadCloser, error)
+	// DownloadRange downloads length bytes from offset, or everything from
+	// offset if length is negative. A range running past the end of the
+	// object is cut short; one starting at or past the end is invalid.
+	DownloadRange(ctx context.Context, storagePath string, offset, length int64) (io.ReadCloser, error)
+	// DeleteFile deletes an object; deleting a missing object succeeds
+	DeleteFile(ctx context.Context, storagePath string) error
+	GetFileURL(ctx context.Context, storagePath string, expiryDuration time.Duration) (string, error)
+	CopyFile(ctx context.Context, sourcePath, destPath string) error
+	FileExists(ctx context.Context, storagePath string) (bool, error)
+
+	// Multipart uploads
+	NewMultipartUpload(ctx context.Context, storagePath, contentType string) (string, error)
+	UploadPart(ctx context.Context, storagePath, uploadID string, partNumber int, reader io.Reader, size int64, sha256Hex string) (string, error)
+	CompleteMultipartUpload(ctx context.Context, storagePath, uploadID string, parts []Part) error
+	AbortMultipartUpload(ctx context.Context, storagePath, uploadID string) error
+}
+
+// Part identifies an uploaded part of a multipart upload
+type Part struct {
+	PartNumber int
+	ETag       string
+}
+
+// Storage drivers
+const (
+	DriverMinIO  = "minio"
+	DriverLocal  = "local"
+	DriverMemory = "memory"
+)
+
+// New creates the storage driver selected in the configuration
+func New(cfg *config.Config) (Storage, error) {
+	switch cfg.Storage.Driver {
+	case DriverMinIO:
+		return NewMinIOStorage(&cfg.MinIO)
+	case DriverLocal:
+		return NewLocalStorage(cfg.Storage.LocalPath)
+	case DriverMemory:
+		return NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Storage.Driver)
+	}
+}
+
+// uploadFilePath generates the storage path UploadFile stores a file at:
+// tenantID/year/month/fileID/filename
+func uploadFilePath(tenantID, fileID uuid.UUID, filename string) string {
+	now := time.Now()
+	return fmt.Sprintf(
+		"%s/%d/%02d/%s/%s",
+		tenantID.String(),
+		now.Year(),
+		now.Month(),
+		fileID.String(),
+		filename,
+	)
+}
+
+// validatePath checks that a storage path is relative, slash-separated and
+// has no empty, "." or ".." elements, so that drivers can map it onto a
+// directory tree
+func validatePath(storagePath string) error {
+	if storagePath == "" || strings.Contains(storagePath, "\\") {
+		return fmt.Errorf("%w: %q", ErrInvalidPath, storagePath)
+	}
+	for _, element := range strings.Split(storagePath, "/") {
+		if element == "" || element == "." || element == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidPath, storagePath)
+		}
+	}
+	return nil
+}
+
+// rangeBounds returns the start and end offsets of a range of an object of
+// the given size, following the DownloadRange rules
+func rangeBounds(size, offset, length int64) (int64, int64, error) {
+	if offset < 0 || length == 0 || offset >= size {
+		return 0, 0, ErrInvalidRange
+	}
+	end := size
+	if length > 0 && offset+length < size {
+		end = offset + length
+	}
+	return offset, end, nil
+}
+
+// readObject reads content being uploaded. A size of -1 reads everything;
+// otherwise the content must be exactly size bytes.
+func readObject(reader io.Reader, size int64) ([]byte, error) {
+	if size < 0 {
+		return io.ReadAll(reader)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, fmt.Errorf("content is shorter than %d bytes: %w", size, err)
+	}
+	return data, nil
+}
+
+// GetStoragePath generates a storage path for a file
+func GetStoragePath(tenantID, fileID uuid.UUID, filename string) string {
+	now := time.Now()
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf(
+		"%s/%d/%02d/%s%s",
+		tenantID.String(),
+		now.Year(),
+		now.Month(),
+		fileID.String(),
+		ext,
+	)
+}
+
+// GetBlobPath generates the storage path of content with the given SHA-256:
+// tenantID/blobs/ab/cd/abcd...
+func GetBlobPath(tenantID uuid.UUID, checksum string) string {
+	return fmt.Sprintf(
+		"%s/blobs/%s/%s/%s",
+		tenantID.String(),
+		checksum[0:2],
+		checksum[2:4],
+		checksum,
+	)
+}
+
+// GetPreviewPath generates the storage path of a thumbnail or text extract
+// generated from content with the given SHA-256:
+// tenantID/previews/ab/cd/abcd.../name
+func GetPreviewPath(tenantID uuid.UUID, checksum, name string) string {
+	return fmt.Sprintf(
+		"%s/previews/%s/%s/%s/%s",
+		tenantID.String(),
+		checksum[0:2],
+		checksum[2:4],
+		checksum,
+		name,
+	)
+}
+
+// GetTempPath generates a storage path for content that is still being
+// uploaded and has no checksum yet
+func GetTempPath(tenantID, id uuid.UUID) string {
+	return fmt.Sprintf("%s/tmp/%s", tenantID.String(), id.String())
+}
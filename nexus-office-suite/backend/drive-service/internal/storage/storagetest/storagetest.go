@@ -0,0 +1,427 @@
+// Package storagetest provides a conformance test suite for storage drivers.
+// Every driver must pass it, so that the drive service behaves the same
+// whichever driver is configured.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nexus/drive-service/internal/storage"
+)
+
+// Run runs the conformance suite against the storage returned by
+// newStorage. Each test stores objects under its own random prefix, so the
+// same storage may be shared between tests.
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storage, prefix string)
+	}{
+		{"UploadAndDownload", testUploadAndDownload},
+		{"UploadUnknownSize", testUploadUnknownSize},
+		{"UploadShortContent", testUploadShortContent},
+		{"Overwrite", testOverwrite},
+		{"UploadFile", testUploadFile},
+		{"Missing", testMissing},
+		{"DownloadRange", testDownloadRange},
+		{"ReaderAt", testReaderAt},
+		{"Delete", testDelete},
+		{"Copy", testCopy},
+		{"FileURL", testFileURL},
+		{"MultipartUpload", testMultipartUpload},
+		{"MultipartChecksum", testMultipartChecksum},
+		{"MultipartInvalidPart", testMultipartInvalidPart},
+		{"MultipartAbort", testMultipartAbort},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t), "conformance/"+uuid.New().String())
+		})
+	}
+}
+
+func testUploadAndDownload(t *testing.T, s storage.Storage, prefix string) {
+	path := prefix + "/a/b/object"
+	content := []byte("hello, storage")
+
+	upload(t, s, path, content)
+
+	assertContent(t, s, path, content)
+	assertExists(t, s, path, true)
+}
+
+func testUploadUnknownSize(t *testing.T, s storage.Storage, prefix string) {
+	ctx := context.Background()
+	path := prefix + "/object"
+	content := bytes.Repeat([]byte("unknown size "), 1000)
+
+	if err := s.UploadObject(ctx, path, bytes.NewReader(content), -1, "text/plain"); err != nil {
+		t.Fatalf("UploadObject with size -1: %v", err)
+	}
+
+	assertContent(t, s, path, content)
+}
+
+func testUploadShortContent(t *testing.T, s storage.Storage, prefix string) {
+	ctx := context.Background()
+	path := prefix + "/object"
+
+	err := s.UploadObject(ctx, path, bytes.NewReader([]byte("short")), 10, "text/plain")
+	if err == nil {
+		t.Fatal("UploadObject with content shorter than size succeeded")
+	}
+	assertExists(t, s, path, false)
+}
+
+func testOverwrite(t *testing.T, s storage.Storage, prefix string) {
+	path := prefix + "/object"
+
+	upload(t, s, path, []byte("first version"))
+	upload(t, s, path, []byte("second"))
+
+	assertContent(t, s, path, []byte("second"))
+}
+
+func testUploadFile(t *testing.T, s storage.Storage, prefix string) {
+	ctx := context.Background()
+	tenantID := uuid.New()
+	content := []byte("file content")
+
+	path, err := s.UploadFile(ctx, tenantID, uuid.New(), "report.txt", bytes.NewReader(content), int64(len(content)), "text/plain")
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	defer s.DeleteFile(ctx, path)
+
+	if !bytes.HasPrefix([]byte(path), []byte(tenantID.String()+"/")) {
+		t.Errorf("UploadFile path %q is not under the tenant", path)
+	}
+	assertContent(t, s, path, content)
+}
+
+func testMissing(t *testing.T, s storage.Storage, prefix string) {
+	ctx := context.Background()
+	path := prefix + "/missing"
+
+	if _, err := s.DownloadFile(ctx, path); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("DownloadFile of missing object: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.DownloadRange(ctx, path, 0, 1); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("DownloadRange of missing object: got %v, want ErrNotFound", err)
+	}
+	assertExists(t, s, path, false)
+}
+
+func testDownloadRange(t *testing.T, s storage.Storage, prefix string) {
+	ctx := context.Background()
+	path := prefix + "/object"
+	content := []byte("0123456789")
+	upload(t, s, path, content)
+
+	valid := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 4, "0123"},
+		{3, 4, "3456"},
+		{6, -1, "6789"},
+		{0, -1, "0123456789"},
+		{8, 100, "89"},
+		{9, 1, "9"},
+	}
+	for _, tc := range valid {
+		reader, err := s.DownloadRange(ctx, path, tc.offset, tc.length)
+		if err != nil {
+			t.Errorf("DownloadRange(%d, %d): %v", tc.offset, tc.length, err)
+			continue
+		}
+		got, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Errorf("DownloadRange(%d, %d): reading: %v", tc.offset, tc.length, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("DownloadRange(%d, %d) = %q, want %q", tc.offset, tc.length, got, tc.want)
+		}
+	}
+
+	invalid := []struct{ offset, length int64 }{
+		{10, -1},
+		{10, 1},
+		{20, 5},
+	}
+	for _, tc := range invalid {
+		reader, err := s.DownloadRange(ctx, path, tc.offset, tc.length)
+		if err == nil {
+			reader.Close()
+		}
+		if !errors.Is(err, storage.ErrInvalidRange) {
+			t.Errorf("DownloadRange(%d, %d): got %v, want ErrInvalidRange", tc.offset, tc.length, err)
+		}
+	}
+}
+
+func testReaderAt(t *testing.T, s storage.Storage, prefix string) {
+	path := prefix + "/object"
+	content := make([]byte, 2*storage.ReaderAtBlockSize+100)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	upload(t, s, path, content)
+
+	r := storage.NewReaderAt(context.Background(), s, path, int64(len(content)))
+
+	// Reads within a block, across blocks, backwards and at the end
+	reads := []struct {
+		offset int64
+		length int
+	}{
+		{0, 10},
+		{storage.ReaderAtBlockSize - 5, 10},
+		{100, 2 * storage.ReaderAtBlockSize},
+		{int64(len(content)) - 10, 10},
+		{5, 1},
+	}
+	for _, tc := range reads {
+		got := make([]byte, tc.length)
+		n, err := r.ReadAt(got, tc.offset)
+		if err != nil || n != tc.length {
+			t.Errorf("ReadAt(%d bytes at %d) = %d, %v", tc.length, tc.offset, n, err)
+			continue
+		}
+		if !bytes.Equal(got, content[tc.offset:tc.offset+int64(tc.length)]) {
+			t.Errorf("ReadAt(%d bytes at %d) returned the wrong content", tc.length, tc.offset)
+		}
+	}
+
+	got := make([]byte, 20)
+	n, err := r.ReadAt(got, int64(len(content))-10)
+	if n != 10 || err != io.EOF {
+		t.Errorf("ReadAt past the end = %d, %v, want 10, EOF", n, err)
+	}
+	if n, err := r.ReadAt(got, int64(len(content))); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt at the end = %d, %v, want 0, EOF", n, err)
+	}
+}
+
+func testDelete(t *testing.T, s storage.Storage, prefix string) {
+	ctx := context.Background()
+	path := prefix + "/dir/object"
+	sibling := prefix + "/dir/sibling"
+	upload(t, s, path, []byte("delete me"))
+	upload(t, s, sibling, []byte("keep me"))
+
+	if err := s.DeleteFile(ctx, path); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	assertExists(t, s, path, false)
+	assertContent(t, s, sibling, []byte("keep me"))
+
+	if err := s.DeleteFile(ctx, path); err != nil {
+		t.Errorf("DeleteFile of missing object: %v", err)
+	}
+}
+
+func testCopy(t *testing.T, s storage.Storage, prefix string) {
+	ctx := context.Background()
+	source := prefix + "/source"
+	dest := prefix + "/copies/dest"
+	content := []byte("copy me")
+	upload(t, s, source, content)
+
+	if err := s.CopyFile(ctx, source, dest); err != nil {
+		t.Fatalf("CopyFile: %v", err)
+	}
+	assertContent(t, s, dest, content)
+	assertContent(t, s, source, content)
+
+	// The copy is independent of the source
+	upload(t, s, source, []byte("changed"))
+	assertContent(t, s, dest, content)
+
+	if err := s.CopyFile(ctx, prefix+"/missing", prefix+"/other"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("CopyFile of missing object: got %v, want ErrNotFound", err)
+	}
+}
+
+func testFileURL(t *testing.T, s storage.Storage, prefix string) {
+	ctx := context.Background()
+	path := prefix + "/object"
+	upload(t, s, path, []byte("presigned"))
+
+	url, err := s.GetFileURL(ctx, path, time.Minute)
+	if errors.Is(err, storage.ErrNotSupported) {
+		return
+	}
+	if err != nil {
+		t.Fatalf("GetFileURL: %v", err)
+	}
+	if url == "" {
+		t.Error("GetFileURL returned an empty URL")
+	}
+}
+
+func testMultipartUpload(t *testing.T, s storage.Storage, prefix string) {
+	ctx := context.Background()
+	path := prefix + "/multipart"
+
+	// S3 requires every part but the last to be at least 5 MiB
+	first := bytes.Repeat([]byte("a"), 5<<20)
+	second := []byte("the end")
+
+	uploadID, err := s.NewMultipartUpload(ctx, path, "application/octet-stream")
+	if err != nil {
+		t.Fatalf("NewMultipartUpload: %v", err)
+	}
+
+	// Parts may be uploaded in any order
+	secondETag := uploadPart(t, s, path, uploadID, 2, second)
+	firstETag := uploadPart(t, s, path, uploadID, 1, first)
+
+	assertExists(t, s, path, false)
+
+	parts := []storage.Part{
+		{PartNumber: 1, ETag: firstETag},
+		{PartNumber: 2, ETag: secondETag},
+	}
+	if err := s.CompleteMultipartUpload(ctx, path, uploadID, parts); err != nil {
+		t.Fatalf("CompleteMultipartUpload: %v", err)
+	}
+
+	assertContent(t, s, path, append(first, second...))
+}
+
+func testMultipartChecksum(t *testing.T, s storage.Storage, prefix string) {
+	ctx := context.Background()
+	path := prefix + "/multipart"
+	content := []byte("checked part")
+
+	uploadID, err := s.NewMultipartUpload(ctx, path, "application/octet-stream")
+	if err != nil {
+		t.Fatalf("NewMultipartUpload: %v", err)
+	}
+	defer s.AbortMultipartUpload(ctx, path, uploadID)
+
+	wrong := sha256.Sum256([]byte("other content"))
+	_, err = s.UploadPart(ctx, path, uploadID, 1, bytes.NewReader(content), int64(len(content)), hex.EncodeToString(wrong[:]))
+	if !errors.Is(err, storage.ErrChecksumMismatch) {
+		t.Errorf("UploadPart with wrong checksum: got %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func testMultipartInvalidPart(t *testing.T, s storage.Storage, prefix string) {
+	ctx := context.Background()
+	path := prefix + "/multipart"
+	content := []byte("only part")
+
+	uploadID, err := s.NewMultipartUpload(ctx, path, "application/octet-stream")
+	if err != nil {
+		t.Fatalf("NewMultipartUpload: %v", err)
+	}
+	defer s.AbortMultipartUpload(ctx, path, uploadID)
+
+	uploadPart(t, s, path, uploadID, 1, content)
+
+	parts := []storage.Part{{PartNumber: 1, ETag: "0123456789abcdef0123456789abcdef"}}
+	if err := s.CompleteMultipartUpload(ctx, path, uploadID, parts); err == nil {
+		t.Error("CompleteMultipartUpload with a wrong ETag succeeded")
+	}
+	assertExists(t, s, path, false)
+}
+
+func testMultipartAbort(t *testing.T, s storage.Storage, prefix string) {
+	ctx := context.Background()
+	path := prefix + "/multipart"
+	content := []byte("aborted part")
+
+	uploadID, err := s.NewMultipartUpload(ctx, path, "application/octet-stream")
+	if err != nil {
+		t.Fatalf("NewMultipartUpload: %v", err)
+	}
+	etag := uploadPart(t, s, path, uploadID, 1, content)
+
+	if err := s.AbortMultipartUpload(ctx, path, uploadID); err != nil {
+		t.Fatalf("AbortMultipartUpload: %v", err)
+	}
+	if err := s.AbortMultipartUpload(ctx, path, uploadID); err != nil {
+		t.Errorf("AbortMultipartUpload of aborted upload: %v", err)
+	}
+
+	parts := []storage.Part{{PartNumber: 1, ETag: etag}}
+	if err := s.CompleteMultipartUpload(ctx, path, uploadID, parts); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("CompleteMultipartUpload of aborted upload: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.UploadPart(ctx, path, uploadID, 2, bytes.NewReader(content), int64(len(content)), ""); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("UploadPart to aborted upload: got %v, want ErrNotFound", err)
+	}
+	assertExists(t, s, path, false)
+}
+
+func upload(t *testing.T, s storage.Storage, path string, content []byte) {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := s.UploadObject(ctx, path, bytes.NewReader(content), int64(len(content)), "application/octet-stream"); err != nil {
+		t.Fatalf("UploadObject(%q): %v", path, err)
+	}
+	t.Cleanup(func() { s.DeleteFile(ctx, path) })
+}
+
+func uploadPart(t *testing.T, s storage.Storage, path, uploadID string, partNumber int, content []byte) string {
+	t.Helper()
+
+	sum := sha256.Sum256(content)
+	etag, err := s.UploadPart(context.Background(), path, uploadID, partNumber, bytes.NewReader(content), int64(len(content)), hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatalf("UploadPart(%d): %v", partNumber, err)
+	}
+	t.Cleanup(func() { s.DeleteFile(context.Background(), path) })
+	return etag
+}
+
+func assertContent(t *testing.T, s storage.Storage, path string, want []byte) {
+	t.Helper()
+
+	reader, err := s.DownloadFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("DownloadFile(%q): %v", path, err)
+	}
+	defer reader.Close()
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("DownloadFile(%q): reading: %v", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("DownloadFile(%q) returned %d bytes, want %d bytes %q", path, len(got), len(want), truncate(want))
+	}
+}
+
+func assertExists(t *testing.T, s storage.Storage, path string, want bool) {
+	t.Helper()
+
+	exists, err := s.FileExists(context.Background(), path)
+	if err != nil {
+		t.Fatalf("FileExists(%q): %v", path, err)
+	}
+	if exists != want {
+		t.Errorf("FileExists(%q) = %v, want %v", path, exists, want)
+	}
+}
+
+func truncate(b []byte) []byte {
+	if len(b) > 32 {
+		return b[:32]
+	}
+	return b
+}
@@ -0,0 +1,265 @@
+// Package textdiff compares texts line by line with Myers' algorithm and
+// groups the differences into hunks, as diff -u shows them
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxEdits is the most lines two texts may differ by before the lines
+// between their common beginning and end are treated as replaced
+// wholesale. Finding a shortest diff takes time and memory quadratic in
+// the number of edits.
+const MaxEdits = 1000
+
+// Op is what happened to a line
+type Op string
+
+const (
+	OpEqual  Op = "equal"
+	OpInsert Op = "insert"
+	OpDelete Op = "delete"
+)
+
+// Line is a line of a diff
+type Line struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// Hunk is a run of changed lines with the unchanged lines around them.
+// Line numbers start at 1; an empty range starts at the line before it,
+// as in unified diffs.
+type Hunk struct {
+	FromLine  int    `json:"from_line"`
+	FromCount int    `json:"from_count"`
+	ToLine    int    `json:"to_line"`
+	ToCount   int    `json:"to_count"`
+	Lines     []Line `json:"lines"`
+}
+
+// Diff returns the lines of a and b as a shortest edit script turning a
+// into b. Line endings are not compared.
+func Diff(a, b string) []Line {
+	return diffLines(splitLines(a), splitLines(b))
+}
+
+// Hunks groups the changes of a diff into hunks with up to context
+// unchanged lines around them. Changes closer together than twice context
+// share a hunk. A diff without changes has no hunks.
+func Hunks(lines []Line, context int) []*Hunk {
+	// from[i] and to[i] are the numbers of lines of each text before line i
+	from := make([]int, len(lines)+1)
+	to := make([]int, len(lines)+1)
+	for i, line := range lines {
+		from[i+1], to[i+1] = from[i], to[i]
+		if line.Op != OpInsert {
+			from[i+1]++
+		}
+		if line.Op != OpDelete {
+			to[i+1]++
+		}
+	}
+
+	var hunks []*Hunk
+	for i := 0; i < len(lines); {
+		if lines[i].Op == OpEqual {
+			i++
+			continue
+		}
+
+		// Extend the hunk over changes separated by few enough equal lines
+		start, end := max(i-context, 0), i+1
+		for j := i + 1; j < len(lines) && j <= end+2*context; j++ {
+			if lines[j].Op != OpEqual {
+				end = j + 1
+			}
+		}
+		i = end
+		end = min(end+context, len(lines))
+
+		hunk := &Hunk{
+			FromLine:  from[start] + 1,
+			FromCount: from[end] - from[start],
+			ToLine:    to[start] + 1,
+			ToCount:   to[end] - to[start],
+			Lines:     lines[start:end],
+		}
+		if hunk.FromCount == 0 {
+			hunk.FromLine--
+		}
+		if hunk.ToCount == 0 {
+			hunk.ToLine--
+		}
+		hunks = append(hunks, hunk)
+	}
+
+	return hunks
+}
+
+// Unified formats hunks as a unified diff between files named fromName
+// and toName
+func Unified(fromName, toName string, hunks []*Hunk) string {
+	var sb strings.Builder
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+	for _, hunk := range hunks {
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", unifiedRange(hunk.FromLine, hunk.FromCount), unifiedRange(hunk.ToLine, hunk.ToCount))
+		for _, line := range hunk.Lines {
+			switch line.Op {
+			case OpInsert:
+				sb.WriteByte('+')
+			case OpDelete:
+				sb.WriteByte('-')
+			default:
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(line.Text)
+			sb.WriteByte('\n')
+		}
+	}
+
+	return sb.String()
+}
+
+func unifiedRange(line, count int) string {
+	if count == 1 {
+		return fmt.Sprint(line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+// splitLines splits text into lines without their line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.Split(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// diffLines diffs the lines between the common beginning and end of a and
+// b, which are usually most of a revised text
+func diffLines(a, b []string) []Line {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]Line, 0, max(len(a), len(b)))
+	for _, text := range a[:prefix] {
+		lines = append(lines, Line{Op: OpEqual, Text: text})
+	}
+	lines = append(lines, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, Line{Op: OpEqual, Text: text})
+	}
+
+	return lines
+}
+
+// myers returns a shortest edit script turning a into b, or replaces all
+// of a with b if that takes more than MaxEdits edits
+func myers(a, b []string) []Line {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replace(a, b)
+	}
+
+	// v[offset+k] is the furthest x reached on diagonal k = x - y. trace
+	// keeps v as it was before each number of edits d, for k from -d-1 to
+	// d+1, to walk the path back.
+	maxD := min(n+m, MaxEdits)
+	offset := maxD + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	for d := 0; d <= maxD; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // insert
+			} else {
+				x = v[offset+k-1] + 1 // delete
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(a, b, trace)
+			}
+		}
+	}
+
+	return replace(a, b)
+}
+
+// backtrack walks the path myers found back from the end of both texts
+func backtrack(a, b []string, trace [][]int) []Line {
+	x, y := len(a), len(b)
+	lines := make([]Line, 0, max(x, y))
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			lines = append(lines, Line{Op: OpEqual, Text: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				lines = append(lines, Line{Op: OpInsert, Text: b[y-1]})
+			} else {
+				lines = append(lines, Line{Op: OpDelete, Text: a[x-1]})
+			}
+			x, y = prevX, prevY
+		}
+	}
+
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+func replace(a, b []string) []Line {
+	lines := make([]Line, 0, len(a)+len(b))
+	for _, text := range a {
+		lines = append(lines, Line{Op: OpDelete, Text: text})
+	}
+	for _, text := range b {
+		lines = append(lines, Line{Op: OpInsert, Text: text})
+	}
+	return lines
+}
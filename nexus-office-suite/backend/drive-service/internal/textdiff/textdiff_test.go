@@ -0,0 +1,137 @@
+package textdiff
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// sides returns the texts a diff turns into each other
+func sides(lines []Line) (from, to []string) {
+	for _, line := range lines {
+		if line.Op != OpInsert {
+			from = append(from, line.Text)
+		}
+		if line.Op != OpDelete {
+			to = append(to, line.Text)
+		}
+	}
+	return from, to
+}
+
+func edits(lines []Line) int {
+	n := 0
+	for _, line := range lines {
+		if line.Op != OpEqual {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"a\nb\n", "a\nb\n", 0},
+		{"", "a\nb\n", 2},
+		{"a\nb\nc\n", "a\nc\n", 1},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 5},
+		// Line endings are not compared
+		{"a\r\nb\r\n", "a\nb", 0},
+	}
+	for _, tt := range tests {
+		lines := Diff(tt.a, tt.b)
+		from, to := sides(lines)
+		if strings.Join(from, "\n") != strings.Join(splitLines(tt.a), "\n") || strings.Join(to, "\n") != strings.Join(splitLines(tt.b), "\n") {
+			t.Errorf("Diff(%q, %q) = %v does not turn one into the other", tt.a, tt.b, lines)
+		}
+		if n := edits(lines); n != tt.edits {
+			t.Errorf("Diff(%q, %q) has %d edits, want %d", tt.a, tt.b, n, tt.edits)
+		}
+	}
+}
+
+func TestDiffRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	text := func() []string {
+		lines := make([]string, rng.Intn(40))
+		for i := range lines {
+			lines[i] = fmt.Sprint(rng.Intn(5))
+		}
+		return lines
+	}
+
+	for i := 0; i < 200; i++ {
+		a, b := text(), text()
+		from, to := sides(diffLines(a, b))
+		if strings.Join(from, ",") != strings.Join(a, ",") || strings.Join(to, ",") != strings.Join(b, ",") {
+			t.Fatalf("diff of %v and %v does not turn one into the other", a, b)
+		}
+	}
+}
+
+func TestDiffTooManyEdits(t *testing.T) {
+	var a, b strings.Builder
+	for i := 0; i < MaxEdits; i++ {
+		fmt.Fprintf(&a, "a%d\n", i)
+		fmt.Fprintf(&b, "b%d\n", i)
+	}
+	a.WriteString("end\n")
+	b.WriteString("end\n")
+
+	lines := Diff(a.String(), b.String())
+	if n := edits(lines); n != 2*MaxEdits {
+		t.Fatalf("got %d edits, want every line replaced", n)
+	}
+	if lines[0].Op != OpDelete || lines[MaxEdits].Op != OpInsert || lines[len(lines)-1] != (Line{Op: OpEqual, Text: "end"}) {
+		t.Error("replaced lines are not the deletions, the insertions and then the common end")
+	}
+}
+
+func TestUnified(t *testing.T) {
+	a := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	b := "a\nb\nC\nd\ne\nf\ng\nh\ni\n"
+
+	got := Unified("v1", "v2", Hunks(Diff(a, b), 1))
+	want := `--- v1
++++ v2
+@@ -2,3 +2,3 @@
+ b
+-c
++C
+ d
+@@ -9,2 +9 @@
+ i
+-j
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// With more context the changes share a hunk
+	if hunks := Hunks(Diff(a, b), 3); len(hunks) != 1 || hunks[0].FromLine != 1 || hunks[0].FromCount != 10 || hunks[0].ToCount != 9 {
+		t.Errorf("hunks with 3 lines of context = %+v, want one hunk of the whole text", hunks)
+	}
+}
+
+func TestHunksEmptyRanges(t *testing.T) {
+	// An empty range starts at the line before it
+	hunks := Hunks(Diff("", "new\n"), 3)
+	if len(hunks) != 1 || hunks[0].FromLine != 0 || hunks[0].FromCount != 0 || hunks[0].ToLine != 1 || hunks[0].ToCount != 1 {
+		t.Errorf("hunks of an added line = %+v", hunks)
+	}
+	if got := Unified("a", "b", hunks); !strings.Contains(got, "@@ -0,0 +1 @@") {
+		t.Errorf("unified diff of an added line:\n%s", got)
+	}
+
+	if hunks := Hunks(Diff("same\n", "same\n"), 3); hunks != nil {
+		t.Errorf("hunks of equal texts = %+v, want none", hunks)
+	}
+	if got := Unified("a", "b", nil); got != "" {
+		t.Errorf("unified diff without hunks = %q, want none", got)
+	}
+}
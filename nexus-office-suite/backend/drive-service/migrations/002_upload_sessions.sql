@@ -0,0 +1,40 @@
+-- NEXUS Drive Service: resumable uploads
+
+-- Upload sessions, each backed by a multipart upload in object storage
+CREATE TABLE upload_sessions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL,
+    owner_id UUID NOT NULL,
+    folder_id UUID REFERENCES folders(id) ON DELETE SET NULL,
+    file_id UUID NOT NULL UNIQUE,
+    filename VARCHAR(255) NOT NULL,
+    mime_type VARCHAR(127) NOT NULL,
+    size BIGINT NOT NULL,
+    chunk_size BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    checksum VARCHAR(64),
+    storage_path VARCHAR(511) NOT NULL,
+    storage_upload_id VARCHAR(1023) NOT NULL,
+    hash_state BYTEA,
+    status VARCHAR(31) NOT NULL DEFAULT 'uploading' CHECK (status IN ('uploading', 'completed')),
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Chunks received so far, one per multipart part
+CREATE TABLE upload_parts (
+    session_id UUID NOT NULL REFERENCES upload_sessions(id) ON DELETE CASCADE,
+    part_number INTEGER NOT NULL,
+    size BIGINT NOT NULL,
+    etag VARCHAR(255) NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (session_id, part_number)
+);
+
+CREATE INDEX idx_upload_sessions_owner ON upload_sessions(tenant_id, owner_id);
+CREATE INDEX idx_upload_sessions_expires ON upload_sessions(expires_at);
+
+CREATE TRIGGER update_upload_sessions_updated_at BEFORE UPDATE ON upload_sessions
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
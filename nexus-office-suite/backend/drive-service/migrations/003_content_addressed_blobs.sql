@@ -0,0 +1,54 @@
+-- NEXUS Drive Service: content-addressed storage
+
+-- File content is stored once per tenant under its SHA-256
+-- ({tenant_id}/blobs/ab/cd/abcd...). ref_count is the number of files and
+-- file versions pointing at the blob and is kept by the triggers below;
+-- unreferenced blobs are removed after a grace period.
+CREATE TABLE blobs (
+    storage_path VARCHAR(511) PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    size BIGINT NOT NULL,
+    ref_count INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(31) NOT NULL DEFAULT 'ok' CHECK (status IN ('ok', 'corrupt', 'missing')),
+    verified_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(tenant_id, checksum)
+);
+
+CREATE INDEX idx_blobs_unreferenced ON blobs(updated_at) WHERE ref_count <= 0;
+CREATE INDEX idx_blobs_verified ON blobs(verified_at NULLS FIRST);
+
+-- Files and versions now share content, so storage paths are no longer unique.
+-- Files stored before this migration keep their own paths and no checksum.
+ALTER TABLE files DROP CONSTRAINT IF EXISTS files_storage_path_key;
+ALTER TABLE file_versions DROP CONSTRAINT IF EXISTS file_versions_storage_path_key;
+
+ALTER TABLE files ADD COLUMN checksum VARCHAR(64);
+ALTER TABLE file_versions ADD COLUMN checksum VARCHAR(64);
+
+CREATE INDEX idx_files_storage_path ON files(storage_path);
+CREATE INDEX idx_file_versions_storage_path ON file_versions(storage_path);
+
+-- Reference counting
+CREATE OR REPLACE FUNCTION adjust_blob_ref_count()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE blobs SET ref_count = ref_count + 1, updated_at = CURRENT_TIMESTAMP
+        WHERE storage_path = NEW.storage_path;
+    END IF;
+    IF TG_OP IN ('DELETE', 'UPDATE') THEN
+        UPDATE blobs SET ref_count = ref_count - 1, updated_at = CURRENT_TIMESTAMP
+        WHERE storage_path = OLD.storage_path;
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER files_blob_ref_count AFTER INSERT OR DELETE OR UPDATE OF storage_path ON files
+    FOR EACH ROW EXECUTE FUNCTION adjust_blob_ref_count();
+
+CREATE TRIGGER file_versions_blob_ref_count AFTER INSERT OR DELETE OR UPDATE OF storage_path ON file_versions
+    FOR EACH ROW EXECUTE FUNCTION adjust_blob_ref_count();
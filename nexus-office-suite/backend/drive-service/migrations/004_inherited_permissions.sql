@@ -0,0 +1,85 @@
+-- NEXUS Drive Service: inherited permissions and groups
+
+-- Groups are tenant-scoped sets of users that permissions can be granted to
+CREATE TABLE user_groups (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    created_by UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(tenant_id, name)
+);
+
+CREATE TABLE user_group_members (
+    group_id UUID NOT NULL REFERENCES user_groups(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL,
+    added_by UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX idx_user_groups_tenant ON user_groups(tenant_id);
+CREATE INDEX idx_user_group_members_user ON user_group_members(user_id);
+
+CREATE TRIGGER update_user_groups_updated_at BEFORE UPDATE ON user_groups
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Permissions can be granted to a group instead of a user or email
+ALTER TABLE permissions ADD COLUMN group_id UUID REFERENCES user_groups(id) ON DELETE CASCADE;
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_check;
+ALTER TABLE permissions ADD CONSTRAINT permissions_principal_check
+    CHECK (user_id IS NOT NULL OR email IS NOT NULL OR group_id IS NOT NULL);
+
+CREATE INDEX idx_permissions_group ON permissions(group_id);
+CREATE INDEX idx_permissions_expires ON permissions(expires_at) WHERE expires_at IS NOT NULL;
+
+-- The resource followed by the folders containing it, nearest first. depth
+-- is 0 for the resource itself.
+CREATE OR REPLACE FUNCTION resource_ancestry(p_resource_type VARCHAR, p_resource_id UUID)
+RETURNS TABLE (resource_type VARCHAR, resource_id UUID, owner_id UUID, depth INTEGER) AS $$
+    WITH RECURSIVE chain (kind, id, owner, parent, level) AS (
+        SELECT 'file'::VARCHAR, f.id, f.owner_id, f.folder_id, 0
+        FROM files f WHERE p_resource_type = 'file' AND f.id = p_resource_id
+        UNION ALL
+        SELECT 'folder'::VARCHAR, f.id, f.owner_id, f.parent_id, 0
+        FROM folders f WHERE p_resource_type = 'folder' AND f.id = p_resource_id
+        UNION ALL
+        SELECT 'folder'::VARCHAR, f.id, f.owner_id, f.parent_id, c.level + 1
+        FROM folders f JOIN chain c ON f.id = c.parent
+        WHERE c.level < 64
+    )
+    SELECT kind, id, owner, level FROM chain
+$$ LANGUAGE sql STABLE;
+
+-- A user's role on a resource, or NULL without access. Owning the resource
+-- or any folder containing it makes the user an owner. Otherwise the
+-- nearest unexpired grant to the user or one of their groups decides, so a
+-- grant on a file or subfolder overrides one inherited from further up; the
+-- highest role wins between grants on the same resource.
+CREATE OR REPLACE FUNCTION effective_role(p_resource_type VARCHAR, p_resource_id UUID, p_user_id UUID)
+RETURNS VARCHAR AS $$
+    WITH ancestry AS (
+        SELECT * FROM resource_ancestry(p_resource_type, p_resource_id)
+    )
+    SELECT grants.role FROM (
+        SELECT 'owner'::VARCHAR AS role, -1 AS depth
+        FROM ancestry a WHERE a.owner_id = p_user_id
+        UNION ALL
+        SELECT p.role, a.depth
+        FROM ancestry a
+        JOIN permissions p ON p.resource_type = a.resource_type AND p.resource_id = a.resource_id
+        WHERE (p.user_id = p_user_id OR p.group_id IN (
+            SELECT m.group_id FROM user_group_members m WHERE m.user_id = p_user_id
+        ))
+        AND (p.expires_at IS NULL OR p.expires_at > NOW())
+    ) grants
+    ORDER BY grants.depth,
+        CASE grants.role
+            WHEN 'owner' THEN 1
+            WHEN 'editor' THEN 2
+            WHEN 'viewer' THEN 3
+        END
+    LIMIT 1
+$$ LANGUAGE sql STABLE;
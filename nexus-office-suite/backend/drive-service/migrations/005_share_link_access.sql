@@ -0,0 +1,18 @@
+-- NEXUS Drive Service: public share link access log
+
+-- Every use of a public share link. Failed password attempts are also used
+-- to rate limit unlocking a link from one address.
+CREATE TABLE share_link_access (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    link_id UUID NOT NULL REFERENCES share_links(id) ON DELETE CASCADE,
+    action VARCHAR(31) NOT NULL CHECK (action IN ('view', 'list', 'download', 'unlock', 'unlock_failed')),
+    file_id UUID,
+    folder_id UUID,
+    ip_address VARCHAR(63) NOT NULL,
+    user_agent TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_share_link_access_link ON share_link_access(link_id, created_at DESC);
+CREATE INDEX idx_share_link_access_failed ON share_link_access(link_id, ip_address, created_at)
+    WHERE action = 'unlock_failed';
@@ -0,0 +1,31 @@
+-- NEXUS Drive Service: thumbnails, previews and text extracts
+
+-- Previews are generated once per stored content, so files and versions
+-- sharing a blob share its previews; they are deleted with the blob.
+-- Uploads queue a pending row, which the preview worker claims. run_at is
+-- when a pending row may next be tried, or when a claimed row's worker is
+-- presumed dead and the row may be claimed again.
+CREATE TABLE blob_previews (
+    tenant_id UUID NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    mime_type VARCHAR(255) NOT NULL,
+    file_name VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    status VARCHAR(31) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'processing', 'ready', 'unsupported', 'failed')),
+    thumbnail_sizes INTEGER[] NOT NULL DEFAULT '{}',
+    has_text BOOLEAN NOT NULL DEFAULT false,
+    width INTEGER,
+    height INTEGER,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, checksum)
+);
+
+CREATE INDEX idx_blob_previews_queue ON blob_previews(run_at)
+    WHERE status IN ('pending', 'processing');
+
+CREATE INDEX idx_files_checksum ON files(tenant_id, checksum);
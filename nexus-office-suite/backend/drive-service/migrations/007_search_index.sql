@@ -0,0 +1,38 @@
+-- NEXUS Drive Service: full-text search of file content
+
+-- The text extracted from stored content, indexed for search. Like previews,
+-- text is indexed once per blob and deleted with it. content_vector is built
+-- with the SEARCH_LANGUAGE text search configuration; after changing it,
+-- reindex by requeueing previews:
+--   UPDATE blob_previews SET status = 'pending', attempts = 0 WHERE has_text;
+CREATE TABLE blob_contents (
+    tenant_id UUID NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    content TEXT NOT NULL,
+    content_vector TSVECTOR NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, checksum)
+);
+
+CREATE INDEX idx_blob_contents_vector ON blob_contents USING GIN(content_vector);
+
+-- Names and descriptions are matched without stemming, as they are in no
+-- particular language
+CREATE INDEX idx_files_name_search ON files
+    USING GIN(to_tsvector('simple', name || ' ' || COALESCE(description, '')));
+
+-- Queue content stored before previews were generated, and content whose
+-- text was extracted before it was indexed or that could not be read before
+-- PDFs were supported
+INSERT INTO blob_previews (tenant_id, checksum, mime_type, file_name, size)
+SELECT DISTINCT ON (tenant_id, checksum) tenant_id, checksum, mime_type, name, size
+FROM files
+WHERE checksum IS NOT NULL
+ORDER BY tenant_id, checksum, updated_at DESC
+ON CONFLICT (tenant_id, checksum) DO NOTHING;
+
+UPDATE blob_previews
+SET status = 'pending', attempts = 0, last_error = NULL, run_at = CURRENT_TIMESTAMP
+WHERE (status = 'ready' AND has_text)
+   OR (status = 'unsupported' AND (mime_type = 'application/pdf' OR file_name ILIKE '%.pdf'));
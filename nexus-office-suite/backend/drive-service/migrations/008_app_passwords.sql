@@ -0,0 +1,18 @@
+-- NEXUS Drive Service: app passwords for WebDAV clients
+
+-- Passwords users generate for clients that sign in with a username and
+-- password rather than a token. They are random, so only their SHA-256 is
+-- kept. login is the username the password is used with: the user's email
+-- address when it was created, or else their user ID.
+CREATE TABLE app_passwords (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    login VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    password_hash VARCHAR(64) NOT NULL UNIQUE,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_app_passwords_user ON app_passwords(tenant_id, user_id);
@@ -0,0 +1,55 @@
+-- NEXUS Drive Service: change journal for sync clients
+
+-- Incremented by every change to a file's name, location, metadata, content
+-- or trash state, but not by access times or thumbnails. It is the file's
+-- ETag, so that clients can make changes conditional on what they last saw.
+ALTER TABLE files ADD COLUMN revision BIGINT NOT NULL DEFAULT 1;
+
+-- Each user's position in their journal. last_seq is the sequence number of
+-- their latest change; changes up to pruned_seq have been deleted. Changes
+-- are numbered by incrementing last_seq, which locks the row until the
+-- change commits, so sequence numbers become visible in order.
+CREATE TABLE change_cursors (
+    user_id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    last_seq BIGINT NOT NULL DEFAULT 0,
+    pruned_seq BIGINT NOT NULL DEFAULT 0
+);
+
+-- A change to a file or folder, recorded once for every user who could
+-- view it before or after the change
+CREATE TABLE changes (
+    user_id UUID NOT NULL,
+    seq BIGINT NOT NULL,
+    tenant_id UUID NOT NULL,
+    resource_type VARCHAR(50) NOT NULL CHECK (resource_type IN ('file', 'folder')),
+    resource_id UUID NOT NULL,
+    change_type VARCHAR(31) NOT NULL CHECK (change_type IN (
+        'created', 'updated', 'moved', 'renamed', 'trashed', 'restored', 'deleted', 'permission_changed'
+    )),
+    actor_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, seq)
+);
+
+CREATE INDEX idx_changes_created ON changes(created_at);
+
+-- The users who can view a resource: the owners of it and of the folders
+-- containing it, and the users and group members granted a role on any of
+-- them that has not expired
+CREATE OR REPLACE FUNCTION resource_audience(p_resource_type VARCHAR, p_resource_id UUID)
+RETURNS TABLE (user_id UUID) AS $$
+    WITH ancestry AS (
+        SELECT * FROM resource_ancestry(p_resource_type, p_resource_id)
+    ),
+    grants AS (
+        SELECT p.* FROM ancestry a
+        JOIN permissions p ON p.resource_type = a.resource_type AND p.resource_id = a.resource_id
+        WHERE p.expires_at IS NULL OR p.expires_at > NOW()
+    )
+    SELECT owner_id FROM ancestry
+    UNION
+    SELECT g.user_id FROM grants g WHERE g.user_id IS NOT NULL
+    UNION
+    SELECT m.user_id FROM grants g JOIN user_group_members m ON m.group_id = g.group_id
+$$ LANGUAGE sql STABLE;
@@ -0,0 +1,52 @@
+-- NEXUS Drive Service: storage quotas and usage warnings
+
+-- Quotas of particular users and tenants, set by the tenant's administrators
+-- and overriding the defaults from the configuration. A quota of 0 is
+-- unlimited. A user's quota applies to their files in tenant_id only.
+CREATE TABLE storage_quotas (
+    tenant_id UUID NOT NULL,
+    scope VARCHAR(31) NOT NULL CHECK (scope IN ('user', 'tenant')),
+    scope_id UUID NOT NULL, -- the user or tenant
+    quota_bytes BIGINT NOT NULL CHECK (quota_bytes >= 0),
+    updated_by UUID,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, scope, scope_id)
+);
+
+-- The highest warning threshold, in percent of the quota, each user's and
+-- tenant's usage has reached. A warning is raised when usage reaches a
+-- higher threshold; when usage falls, the threshold falls with it, so that
+-- reaching it again warns again.
+CREATE TABLE quota_thresholds (
+    scope VARCHAR(31) NOT NULL CHECK (scope IN ('user', 'tenant')),
+    scope_id UUID NOT NULL,
+    threshold INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (scope, scope_id)
+);
+
+-- Warnings raised when usage reaches a threshold, for user_id: the user
+-- whose quota it is, or for tenant quotas the user whose change reached it.
+-- Warnings are delivered to the notification service in the background;
+-- run_at is when delivery is next attempted.
+CREATE TABLE quota_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL,
+    scope VARCHAR(31) NOT NULL CHECK (scope IN ('user', 'tenant')),
+    scope_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    threshold INTEGER NOT NULL,
+    used_bytes BIGINT NOT NULL,
+    quota_bytes BIGINT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP
+);
+
+CREATE INDEX idx_quota_events_undelivered ON quota_events(run_at) WHERE delivered_at IS NULL;
+
+-- Usage is counted per distinct content: a user is charged once for
+-- content held by any number of their files, trashed files and versions,
+-- and a tenant once per stored blob
+CREATE INDEX idx_files_owner_storage_path ON files(owner_id, storage_path);
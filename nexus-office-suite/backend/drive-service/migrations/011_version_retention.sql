@@ -0,0 +1,22 @@
+-- NEXUS Drive Service: version retention and named versions
+
+-- Versions can be named, such as "Sent to client", or pinned. Named and
+-- pinned versions are never pruned.
+ALTER TABLE file_versions ADD COLUMN name VARCHAR(255);
+ALTER TABLE file_versions ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- How long each tenant keeps old versions, overriding the defaults from the
+-- configuration. A version is kept if it is one of the keep_last newest,
+-- younger than keep_days, or the newest of its day within daily_days or of
+-- its week within weekly_days; the newest and current versions are always
+-- kept. Windows of 0 keep nothing, and a policy of all zeros keeps every
+-- version.
+CREATE TABLE version_retention_policies (
+    tenant_id UUID PRIMARY KEY,
+    keep_last INTEGER NOT NULL DEFAULT 0 CHECK (keep_last >= 0),
+    keep_days INTEGER NOT NULL DEFAULT 0 CHECK (keep_days >= 0),
+    daily_days INTEGER NOT NULL DEFAULT 0 CHECK (daily_days >= 0),
+    weekly_days INTEGER NOT NULL DEFAULT 0 CHECK (weekly_days >= 0),
+    updated_by UUID NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
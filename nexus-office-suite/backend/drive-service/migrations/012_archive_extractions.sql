@@ -0,0 +1,28 @@
+-- NEXUS Drive Service: archive extraction
+
+-- Requests to extract a .zip, .tar.gz or .tar file into a new folder,
+-- processed in the background. The worker records its progress as it goes.
+-- run_at is when a pending extraction may be started, or when a running
+-- one's worker is presumed dead and it may be resumed.
+CREATE TABLE archive_extractions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    file_id UUID NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    folder_id UUID NOT NULL REFERENCES folders(id) ON DELETE CASCADE,
+    status VARCHAR(31) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    progress INTEGER NOT NULL DEFAULT 0, -- percent
+    entries_extracted INTEGER NOT NULL DEFAULT 0,
+    entries_skipped INTEGER NOT NULL DEFAULT 0,
+    bytes_extracted BIGINT NOT NULL DEFAULT 0,
+    error TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX idx_archive_extractions_queue ON archive_extractions(run_at)
+    WHERE status IN ('pending', 'running');
@@ -0,0 +1,23 @@
+-- NEXUS Drive Service: file locks and check-out
+
+-- Locks users hold on files while editing them. Nobody but the holders of a
+-- file's locks may change it. An exclusive lock has a single holder; any
+-- number of users may hold shared locks, but only while nobody holds an
+-- exclusive one. A check-out is an exclusive lock released by checking the
+-- file in with a comment; base_version is the file's version when it was
+-- locked. Expired locks are ignored and deleted when the file is next
+-- locked.
+CREATE TABLE file_locks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL,
+    file_id UUID NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL,
+    lock_type VARCHAR(31) NOT NULL CHECK (lock_type IN ('exclusive', 'shared')),
+    checked_out BOOLEAN NOT NULL DEFAULT false,
+    base_version INTEGER NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (file_id, user_id),
+    CHECK (NOT checked_out OR lock_type = 'exclusive')
+);
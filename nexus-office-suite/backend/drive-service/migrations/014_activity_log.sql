@@ -0,0 +1,39 @@
+-- NEXUS Drive Service: activity log
+
+-- Who did what to which file or folder, for audits. Activities outlive the
+-- resources they are about, so they keep the resource's name at the time.
+-- folder_ids are the folders that contained the resource at the time, and
+-- for moves those that contained it before, so that a folder's activity
+-- includes everything that happened inside it. actor_id is NULL for
+-- visitors of public share links.
+CREATE TABLE activities (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL,
+    actor_id UUID,
+    action VARCHAR(63) NOT NULL,
+    resource_type VARCHAR(50) NOT NULL CHECK (resource_type IN ('file', 'folder')),
+    resource_id UUID NOT NULL,
+    resource_name VARCHAR(255) NOT NULL,
+    from_folder_id UUID,
+    folder_ids UUID[] NOT NULL DEFAULT '{}',
+    share_link_id UUID,
+    ip_address VARCHAR(63),
+    user_agent TEXT,
+    details JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_activities_tenant ON activities(tenant_id, created_at DESC, id DESC);
+CREATE INDEX idx_activities_resource ON activities(resource_id, created_at DESC, id DESC);
+CREATE INDEX idx_activities_actor ON activities(actor_id, created_at DESC, id DESC);
+CREATE INDEX idx_activities_folders ON activities USING GIN(folder_ids);
+CREATE INDEX idx_activities_created ON activities(created_at);
+
+-- How long each tenant keeps its activity, overriding the configured
+-- default. 0 keeps it forever.
+CREATE TABLE activity_retention_policies (
+    tenant_id UUID PRIMARY KEY,
+    retention_days INTEGER NOT NULL DEFAULT 0,
+    updated_by UUID,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,22 @@
+-- NEXUS Drive Service: the users of each tenant
+
+-- Users who have signed in to each tenant, recorded as they use the
+-- service. Permissions and group memberships are only given to users of
+-- the tenant; others are shared with by email address.
+CREATE TABLE tenant_users (
+    tenant_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    email VARCHAR(255),
+    first_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, user_id)
+);
+
+-- Users who already own files or folders, created groups or granted
+-- permissions are users of their tenant
+INSERT INTO tenant_users (tenant_id, user_id)
+SELECT tenant_id, owner_id FROM files
+UNION SELECT tenant_id, owner_id FROM folders
+UNION SELECT tenant_id, created_by FROM user_groups
+UNION SELECT tenant_id, granted_by FROM permissions
+ON CONFLICT DO NOTHING;
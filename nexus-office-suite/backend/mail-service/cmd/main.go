@@ -12,6 +12,7 @@ import (
 
 	"nexus-mail-service/config"
 	"nexus-mail-service/internal/handler"
+	"nexus-mail-service/internal/middleware"
 	"nexus-mail-service/internal/repository"
 	"nexus-mail-service/internal/service"
 
@@ -56,14 +57,54 @@ func main() {
 	emailRepo := repository.NewEmailRepository(db)
 	folderRepo := repository.NewFolderRepository(db)
 	labelRepo := repository.NewLabelRepository(db)
+	policyRepo := repository.NewPolicyRepository(db)
+	spamRepo := repository.NewSpamRepository(db)
+	delegationRepo := repository.NewDelegationRepository(db)
+	autoResponderRepo := repository.NewAutoResponderRepository(db)
+	jobRepo := repository.NewJobRepository(db)
+	changeRepo := repository.NewChangeRepository(db)
+	listRepo := repository.NewListRepository(db)
+	quotaRepo := repository.NewQuotaRepository(db)
+	retentionRepo := repository.NewRetentionRepository(db)
+	cryptoKeyRepo := repository.NewCryptoKeyRepository(db)
+	tenantRepo := repository.NewTenantRepository(db)
 
 	// Initialize services
-	emailService, err := service.NewEmailService(cfg, emailRepo, folderRepo)
+	tenants := service.NewTenantDirectory(tenantRepo.TenantForDomain)
+
+	spamFilter := service.NewSpamFilter(cfg, spamRepo, tenants)
+
+	quotaService := service.NewQuotaService(cfg, quotaRepo)
+
+	securityService, err := service.NewSecurityService(cfg, cryptoKeyRepo)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize security service")
+	}
+
+	emailService, err := service.NewEmailService(cfg, emailRepo, folderRepo, spamFilter, quotaService, securityService)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to initialize email service")
 	}
 
-	spamFilter := service.NewSpamFilter(cfg)
+	retentionService := service.NewRetentionService(cfg, retentionRepo, emailService)
+	go retentionService.RunPurge()
+
+	contentFilter := service.NewContentFilter(cfg, service.NewContentScanner(cfg), policyRepo)
+
+	jwtManager := middleware.NewJWTManager(cfg.Server.JWTSecret)
+
+	autoResponder := service.NewAutoResponderService(cfg, autoResponderRepo, service.NewCalendarClient(cfg, jwtManager), tenants)
+
+	transferService := service.NewMailboxTransferService(cfg, emailRepo, folderRepo, jobRepo, emailService, contentFilter, tenants)
+
+	jmapService := service.NewJMAPService(cfg, emailRepo, folderRepo, changeRepo, delegationRepo, emailService)
+	go jmapService.PruneChanges()
+
+	listService := service.NewListService(cfg, listRepo, emailRepo, folderRepo, emailService)
+	go listService.RunDigests()
+
+	changeNotifier := service.NewChangeNotifier(cfg)
+	defer changeNotifier.Close()
 
 	// Initialize HTTP server
 	router := gin.Default()
@@ -72,7 +113,7 @@ func main() {
 	corsConfig := cors.Config{
 		AllowOrigins:     cfg.Server.AllowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", middleware.MailboxHeader},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
@@ -88,9 +129,43 @@ func main() {
 		})
 	})
 
-	// Register HTTP API routes
-	emailHandler := handler.NewEmailHandler(emailService, folderRepo, labelRepo)
-	emailHandler.RegisterRoutes(router)
+	// Register HTTP API routes; every API route requires a platform bearer
+	// token and may target a delegated mailbox via X-Mailbox-ID
+	api := router.Group("/api/v1",
+		middleware.Authenticate(jwtManager),
+		middleware.MailboxAccess(delegationRepo),
+	)
+
+	emailHandler := handler.NewEmailHandler(emailService, folderRepo, labelRepo, spamRepo)
+	emailHandler.RegisterRoutes(api)
+
+	policyHandler := handler.NewPolicyHandler(contentFilter, policyRepo)
+	policyHandler.RegisterRoutes(api)
+
+	delegationHandler := handler.NewDelegationHandler(delegationRepo)
+	delegationHandler.RegisterRoutes(api)
+
+	autoResponderHandler := handler.NewAutoResponderHandler(autoResponderRepo)
+	autoResponderHandler.RegisterRoutes(api)
+
+	mailboxJobHandler := handler.NewMailboxJobHandler(transferService, emailService, jobRepo, cfg.Email.MaxImportSize)
+	mailboxJobHandler.RegisterRoutes(api)
+
+	listHandler := handler.NewListHandler(listService, listRepo, emailService)
+	listHandler.RegisterRoutes(api)
+
+	quotaHandler := handler.NewQuotaHandler(quotaService, quotaRepo)
+	quotaHandler.RegisterRoutes(api)
+
+	retentionHandler := handler.NewRetentionHandler(retentionService, retentionRepo, quotaRepo)
+	retentionHandler.RegisterRoutes(api)
+
+	securityHandler := handler.NewSecurityHandler(securityService, emailService, cryptoKeyRepo)
+	securityHandler.RegisterRoutes(api)
+
+	jmapHandler := handler.NewJMAPHandler(jmapService, changeNotifier, cfg)
+	jmapHandler.RegisterRoutes(api)
+	jmapHandler.RegisterWellKnown(router)
 
 	// Start HTTP server
 	httpServer := &http.Server{
@@ -111,7 +186,7 @@ func main() {
 	// Start SMTP server if enabled
 	var smtpServer *service.SMTPServer
 	if cfg.SMTP.Enabled {
-		smtpServer = service.NewSMTPServer(cfg, emailRepo, folderRepo, emailService, spamFilter)
+		smtpServer = service.NewSMTPServer(cfg, emailRepo, folderRepo, emailService, spamFilter, contentFilter, jwtManager, autoResponder, listService, quotaService, tenants)
 		go func() {
 			if err := smtpServer.Start(); err != nil {
 				log.Error().Err(err).Msg("SMTP server failed")
@@ -122,7 +197,7 @@ func main() {
 	// Start IMAP server if enabled
 	var imapServer *service.IMAPServer
 	if cfg.IMAP.Enabled {
-		imapServer = service.NewIMAPServer(cfg, emailRepo, folderRepo)
+		imapServer = service.NewIMAPServer(cfg, emailRepo, folderRepo, jwtManager, quotaService.Status)
 		go func() {
 			if err := imapServer.Start(); err != nil {
 				log.Error().Err(err).Msg("IMAP server failed")
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -20,12 +22,59 @@ type Config struct {
 	Email      EmailConfig
 	Redis      RedisConfig
 	IDaaS      IDaaSConfig
+	Calendar   CalendarConfig
+	JMAP       JMAPConfig
+	Lists      ListsConfig
+	Retention  RetentionConfig
+	Crypto     CryptoConfig
 }
 
 type IDaaSConfig struct {
 	URL string
 }
 
+// CalendarConfig points at calendar-service, used to follow out-of-office
+// events. An empty URL disables the lookup.
+type CalendarConfig struct {
+	URL     string
+	Timeout time.Duration
+}
+
+// JMAPConfig controls the JMAP API. PublicURL is the externally visible
+// base URL (e.g. https://mail.example.com) used for the URLs in the session
+// resource; when empty it is derived from each request.
+type JMAPConfig struct {
+	PublicURL       string
+	ChangeRetention time.Duration // how long /changes can look back
+	PushPing        time.Duration // default event source keep-alive interval
+}
+
+// ListsConfig controls distribution lists. ArchiveURL, if set, is the web
+// address of list archives and is advertised in List-Archive headers.
+type ListsConfig struct {
+	ArchiveURL     string
+	DigestInterval time.Duration // how often digest members get a digest
+}
+
+// RetentionConfig holds the default retention periods, which tenants may
+// override, and how often the purge worker runs. A period of 0 keeps mail
+// forever.
+type RetentionConfig struct {
+	TrashDays     int
+	SpamDays      int
+	PurgeInterval time.Duration
+	PurgeBatch    int // emails deleted per statement
+}
+
+// CryptoConfig controls S/MIME and OpenPGP. KeyEncryptionKey protects the
+// private keys users upload and must be set.
+// TrustedRootsFile is a PEM bundle of CAs trusted for S/MIME signatures in
+// addition to the system roots.
+type CryptoConfig struct {
+	KeyEncryptionKey string
+	TrustedRootsFile string
+}
+
 type ServerConfig struct {
 	Port            string
 	Environment     string
@@ -92,6 +141,12 @@ type SecurityConfig struct {
 	ClamAVPort          string
 	EnableSpamFilter    bool
 	EnableVirusScanning bool
+	VirusScanner        string        // "clamd" or "fake"
+	ScanTimeout         time.Duration
+	BlockedExtensions   []string
+	BlockedMimeTypes    []string
+	BlockAction         string // "reject" or "quarantine"
+	ScannerDownAction   string // "tempfail" or "deliver"
 }
 
 type EmailConfig struct {
@@ -99,6 +154,7 @@ type EmailConfig struct {
 	MaxRecipientsPerEmail int
 	EnableReadReceipts  bool
 	DefaultQuotaMB      int64
+	MaxImportSize       int64 // largest mbox/zip archive accepted for import
 }
 
 type RedisConfig struct {
@@ -174,12 +230,21 @@ func Load() (*Config, error) {
 			ClamAVPort:          getEnv("CLAMAV_PORT", "3310"),
 			EnableSpamFilter:    getEnvBool("ENABLE_SPAM_FILTER", false),
 			EnableVirusScanning: getEnvBool("ENABLE_VIRUS_SCANNING", false),
+			VirusScanner:        getEnv("VIRUS_SCANNER", "clamd"),
+			ScanTimeout:         time.Duration(getEnvInt64("SCAN_TIMEOUT_SECONDS", 30)) * time.Second,
+			BlockedExtensions: getEnvList("BLOCKED_ATTACHMENT_EXTENSIONS",
+				".exe,.scr,.bat,.cmd,.com,.pif,.vbs,.vbe,.js,.jse,.wsf,.wsh,.msi,.msp,.hta,.cpl,.jar,.ps1,.lnk,.reg"),
+			BlockedMimeTypes: getEnvList("BLOCKED_ATTACHMENT_MIME_TYPES",
+				"application/x-msdownload,application/x-msdos-program,application/x-ms-installer,application/hta"),
+			BlockAction:       getEnv("ATTACHMENT_BLOCK_ACTION", "quarantine"),
+			ScannerDownAction: getEnv("SCANNER_DOWN_ACTION", "tempfail"),
 		},
 		Email: EmailConfig{
 			MaxAttachmentSize:     getEnvInt64("MAX_ATTACHMENT_SIZE", 26214400), // 25MB
 			MaxRecipientsPerEmail: int(getEnvInt64("MAX_RECIPIENTS_PER_EMAIL", 100)),
 			EnableReadReceipts:    getEnvBool("ENABLE_READ_RECEIPTS", true),
 			DefaultQuotaMB:        getEnvInt64("DEFAULT_QUOTA_MB", 5120), // 5GB
+			MaxImportSize:         getEnvInt64("MAX_IMPORT_SIZE", 2147483648), // 2GB
 		},
 		Redis: RedisConfig{
 			Host:     getEnv("REDIS_HOST", "localhost"),
@@ -190,6 +255,29 @@ func Load() (*Config, error) {
 		IDaaS: IDaaSConfig{
 			URL: getEnv("IDAAS_URL", "http://localhost:8100/api/v1"),
 		},
+		Calendar: CalendarConfig{
+			URL:     getEnv("CALENDAR_SERVICE_URL", "http://localhost:8083/api/v1"),
+			Timeout: time.Duration(getEnvInt64("CALENDAR_TIMEOUT_SECONDS", 5)) * time.Second,
+		},
+		JMAP: JMAPConfig{
+			PublicURL:       strings.TrimSuffix(getEnv("JMAP_PUBLIC_URL", ""), "/"),
+			ChangeRetention: time.Duration(getEnvInt64("JMAP_CHANGE_RETENTION_DAYS", 30)) * 24 * time.Hour,
+			PushPing:        time.Duration(getEnvInt64("JMAP_PUSH_PING_SECONDS", 30)) * time.Second,
+		},
+		Lists: ListsConfig{
+			ArchiveURL:     strings.TrimSuffix(getEnv("LISTS_ARCHIVE_URL", ""), "/"),
+			DigestInterval: time.Duration(getEnvInt64("LISTS_DIGEST_INTERVAL_HOURS", 24)) * time.Hour,
+		},
+		Retention: RetentionConfig{
+			TrashDays:     int(getEnvInt64("RETENTION_TRASH_DAYS", 30)),
+			SpamDays:      int(getEnvInt64("RETENTION_SPAM_DAYS", 14)),
+			PurgeInterval: time.Duration(getEnvInt64("RETENTION_PURGE_INTERVAL_MINUTES", 60)) * time.Minute,
+			PurgeBatch:    int(getEnvInt64("RETENTION_PURGE_BATCH", 500)),
+		},
+		Crypto: CryptoConfig{
+			KeyEncryptionKey: getEnv("MAIL_KEY_ENCRYPTION_KEY", ""),
+			TrustedRootsFile: getEnv("SMIME_TRUSTED_ROOTS_FILE", ""),
+		},
 	}
 
 	return config, nil
@@ -226,6 +314,17 @@ func getEnvBool(key string, defaultValue bool) bool {
 	return boolValue
 }
 
+func getEnvList(key, defaultValue string) []string {
+	value := getEnv(key, defaultValue)
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnvInt64(key string, defaultValue int64) int64 {
 	value := os.Getenv(key)
 	if value == "" {
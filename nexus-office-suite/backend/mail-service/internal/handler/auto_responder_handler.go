@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// AutoResponderHandler handles HTTP requests for out-of-office settings
+type AutoResponderHandler struct {
+	autoResponderRepo *repository.AutoResponderRepository
+}
+
+// NewAutoResponderHandler creates a new auto-responder handler
+func NewAutoResponderHandler(autoResponderRepo *repository.AutoResponderRepository) *AutoResponderHandler {
+	return &AutoResponderHandler{
+		autoResponderRepo: autoResponderRepo,
+	}
+}
+
+// RegisterRoutes registers HTTP routes on the authenticated API group
+func (h *AutoResponderHandler) RegisterRoutes(api *gin.RouterGroup) {
+	{
+		autoResponder := api.Group("/auto-responder")
+		{
+			autoResponder.GET("", h.GetAutoResponder)
+			autoResponder.PUT("", h.UpdateAutoResponder)
+			autoResponder.DELETE("", h.DeleteAutoResponder)
+		}
+	}
+}
+
+// GetAutoResponder returns the user's out-of-office settings
+func (h *AutoResponderHandler) GetAutoResponder(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	responder, err := h.autoResponderRepo.Get(userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, model.AutoResponder{
+			UserID:            userID,
+			ReplyIntervalDays: model.DefaultReplyIntervalDays,
+		})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get auto-responder")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get auto-responder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responder)
+}
+
+// UpdateAutoResponder creates or replaces the user's out-of-office settings
+func (h *AutoResponderHandler) UpdateAutoResponder(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var responder model.AutoResponder
+	if err := c.ShouldBindJSON(&responder); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	responder.ID = ""
+	responder.UserID = userID
+
+	if (responder.Enabled || responder.UseCalendar) && responder.Message == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message is required"})
+		return
+	}
+	if responder.StartDate != nil && responder.EndDate != nil && responder.EndDate.Before(*responder.StartDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must be after start_date"})
+		return
+	}
+	if responder.ReplyIntervalDays <= 0 {
+		responder.ReplyIntervalDays = model.DefaultReplyIntervalDays
+	}
+
+	if err := h.autoResponderRepo.Upsert(&responder); err != nil {
+		log.Error().Err(err).Msg("Failed to update auto-responder")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update auto-responder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responder)
+}
+
+// DeleteAutoResponder turns off and removes the user's out-of-office settings
+func (h *AutoResponderHandler) DeleteAutoResponder(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	if err := h.autoResponderRepo.Delete(userID); err != nil {
+		log.Error().Err(err).Msg("Failed to delete auto-responder")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete auto-responder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
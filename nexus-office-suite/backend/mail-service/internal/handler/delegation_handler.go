@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"net/http"
+
+	"nexus-mail-service/internal/middleware"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// DelegationHandler handles HTTP requests for mailbox delegation
+type DelegationHandler struct {
+	delegationRepo *repository.DelegationRepository
+}
+
+// NewDelegationHandler creates a new delegation handler
+func NewDelegationHandler(delegationRepo *repository.DelegationRepository) *DelegationHandler {
+	return &DelegationHandler{
+		delegationRepo: delegationRepo,
+	}
+}
+
+// RegisterRoutes registers HTTP routes on the authenticated API group.
+// Delegations are always managed by the authenticated user for their own
+// mailbox; a delegate cannot re-share a mailbox they were given.
+func (h *DelegationHandler) RegisterRoutes(api *gin.RouterGroup) {
+	{
+		delegations := api.Group("/delegations")
+		{
+			delegations.GET("", h.ListDelegations)
+			delegations.POST("", h.GrantDelegation)
+			delegations.DELETE("/:id", h.RevokeDelegation)
+			delegations.GET("/shared-with-me", h.ListSharedMailboxes)
+		}
+	}
+}
+
+// ListDelegations lists who has access to the caller's mailbox
+func (h *DelegationHandler) ListDelegations(c *gin.Context) {
+	actorID := c.GetString(middleware.ContextActorID)
+
+	delegations, err := h.delegationRepo.ListByMailbox(actorID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list delegations")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list delegations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"delegations": delegations})
+}
+
+// GrantDelegation gives another user of the same tenant access to the
+// caller's mailbox, or updates their existing access
+func (h *DelegationHandler) GrantDelegation(c *gin.Context) {
+	actorID := c.GetString(middleware.ContextActorID)
+
+	var delegation model.MailboxDelegation
+	if err := c.ShouldBindJSON(&delegation); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if delegation.DelegateID == actorID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delegate a mailbox to its owner"})
+		return
+	}
+
+	if delegation.Access == "" {
+		delegation.Access = model.DelegationAccessRead
+	}
+	if delegation.Access != model.DelegationAccessRead && delegation.Access != model.DelegationAccessFull {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "access must be read or full"})
+		return
+	}
+
+	delegation.ID = ""
+	delegation.MailboxID = actorID
+	delegation.TenantID = c.GetString(middleware.ContextTenantID)
+
+	if err := h.delegationRepo.Upsert(&delegation); err != nil {
+		log.Error().Err(err).Msg("Failed to grant delegation")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant delegation"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, delegation)
+}
+
+// RevokeDelegation removes a delegate's access to the caller's mailbox
+func (h *DelegationHandler) RevokeDelegation(c *gin.Context) {
+	actorID := c.GetString(middleware.ContextActorID)
+
+	if err := h.delegationRepo.Delete(c.Param("id"), actorID); err != nil {
+		log.Error().Err(err).Msg("Failed to revoke delegation")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke delegation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// ListSharedMailboxes lists the mailboxes the caller may open with X-Mailbox-ID
+func (h *DelegationHandler) ListSharedMailboxes(c *gin.Context) {
+	actorID := c.GetString(middleware.ContextActorID)
+
+	delegations, err := h.delegationRepo.ListByDelegate(actorID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list shared mailboxes")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list shared mailboxes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mailboxes": delegations})
+}
@@ -1,9 +1,12 @@
 package handler
 
 import (
+	"errors"
+	"mime"
 	"net/http"
 	"strconv"
 
+	"nexus-mail-service/internal/middleware"
 	"nexus-mail-service/internal/model"
 	"nexus-mail-service/internal/repository"
 	"nexus-mail-service/internal/service"
@@ -17,6 +20,7 @@ type EmailHandler struct {
 	emailService  *service.EmailService
 	folderRepo    *repository.FolderRepository
 	labelRepo     *repository.LabelRepository
+	spamRepo      *repository.SpamRepository
 }
 
 // NewEmailHandler creates a new email handler
@@ -24,17 +28,18 @@ func NewEmailHandler(
 	emailService *service.EmailService,
 	folderRepo *repository.FolderRepository,
 	labelRepo *repository.LabelRepository,
+	spamRepo *repository.SpamRepository,
 ) *EmailHandler {
 	return &EmailHandler{
 		emailService: emailService,
 		folderRepo:   folderRepo,
 		labelRepo:    labelRepo,
+		spamRepo:     spamRepo,
 	}
 }
 
-// RegisterRoutes registers HTTP routes
-func (h *EmailHandler) RegisterRoutes(r *gin.Engine) {
-	api := r.Group("/api/v1")
+// RegisterRoutes registers HTTP routes on the authenticated API group
+func (h *EmailHandler) RegisterRoutes(api *gin.RouterGroup) {
 	{
 		// Email routes
 		emails := api.Group("/emails")
@@ -49,6 +54,7 @@ func (h *EmailHandler) RegisterRoutes(r *gin.Engine) {
 			emails.PUT("/:id/move", h.MoveToFolder)
 			emails.DELETE("/:id", h.DeleteEmail)
 			emails.GET("/:id/thread", h.GetThread)
+			emails.GET("/:id/attachments/zip", h.DownloadAllAttachments)
 			emails.POST("/bulk", h.BulkAction)
 		}
 
@@ -72,10 +78,19 @@ func (h *EmailHandler) RegisterRoutes(r *gin.Engine) {
 			labels.DELETE("/:id", h.DeleteLabel)
 		}
 
+		// Sender allow/block list routes
+		senders := api.Group("/sender-lists")
+		{
+			senders.GET("", h.ListSenders)
+			senders.POST("", h.AddSender)
+			senders.DELETE("/:id", h.RemoveSender)
+		}
+
 		// Attachment routes
 		attachments := api.Group("/attachments")
 		{
 			attachments.GET("/:id/download", h.DownloadAttachment)
+			attachments.GET("/:id/inline", h.InlineAttachment)
 		}
 	}
 }
@@ -85,9 +100,6 @@ func (h *EmailHandler) RegisterRoutes(r *gin.Engine) {
 // SendEmail sends an email
 func (h *EmailHandler) SendEmail(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user" // TODO: Get from JWT token
-	}
 
 	var req model.ComposeEmailRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -95,7 +107,33 @@ func (h *EmailHandler) SendEmail(c *gin.Context) {
 		return
 	}
 
-	email, err := h.emailService.SendEmail(userID, &req)
+	// Delegates need send-as or send-on-behalf permission; on-behalf mail
+	// carries the delegate's address in the Sender header
+	sender := ""
+	if delegation, ok := middleware.GetDelegation(c); ok {
+		switch {
+		case delegation.SendAs:
+		case delegation.SendOnBehalf:
+			sender = c.GetString(middleware.ContextEmail)
+		default:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to send from this mailbox"})
+			return
+		}
+	}
+
+	email, err := h.emailService.SendEmail(userID, &req, sender)
+	if errors.Is(err, service.ErrQuotaExceeded) {
+		c.JSON(http.StatusInsufficientStorage, gin.H{"error": "Mailbox storage quota exceeded"})
+		return
+	}
+	if errors.Is(err, service.ErrSecureScheduled) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrNoSigningKey) || errors.Is(err, service.ErrRecipientKey) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to send email")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send email"})
@@ -108,9 +146,6 @@ func (h *EmailHandler) SendEmail(c *gin.Context) {
 // SaveDraft saves an email as draft
 func (h *EmailHandler) SaveDraft(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	var req model.ComposeEmailRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -131,9 +166,6 @@ func (h *EmailHandler) SaveDraft(c *gin.Context) {
 // ListEmails lists emails with pagination
 func (h *EmailHandler) ListEmails(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	folderID := c.Query("folder_id")
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -164,9 +196,6 @@ func (h *EmailHandler) ListEmails(c *gin.Context) {
 // GetEmail retrieves an email by ID
 func (h *EmailHandler) GetEmail(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	emailID := c.Param("id")
 
@@ -188,9 +217,6 @@ func (h *EmailHandler) GetEmail(c *gin.Context) {
 // SearchEmails searches emails
 func (h *EmailHandler) SearchEmails(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	var req model.SearchEmailRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -218,9 +244,6 @@ func (h *EmailHandler) SearchEmails(c *gin.Context) {
 // MarkAsRead marks an email as read/unread
 func (h *EmailHandler) MarkAsRead(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	emailID := c.Param("id")
 
@@ -245,9 +268,6 @@ func (h *EmailHandler) MarkAsRead(c *gin.Context) {
 // MarkAsStarred marks an email as starred/unstarred
 func (h *EmailHandler) MarkAsStarred(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	emailID := c.Param("id")
 
@@ -272,9 +292,6 @@ func (h *EmailHandler) MarkAsStarred(c *gin.Context) {
 // MoveToFolder moves an email to a different folder
 func (h *EmailHandler) MoveToFolder(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	emailID := c.Param("id")
 
@@ -299,9 +316,6 @@ func (h *EmailHandler) MoveToFolder(c *gin.Context) {
 // DeleteEmail deletes an email
 func (h *EmailHandler) DeleteEmail(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	emailID := c.Param("id")
 
@@ -318,9 +332,6 @@ func (h *EmailHandler) DeleteEmail(c *gin.Context) {
 // GetThread retrieves all emails in a thread
 func (h *EmailHandler) GetThread(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	threadID := c.Param("id")
 
@@ -337,9 +348,6 @@ func (h *EmailHandler) GetThread(c *gin.Context) {
 // BulkAction performs bulk actions on emails
 func (h *EmailHandler) BulkAction(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	var req model.BulkActionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -362,9 +370,6 @@ func (h *EmailHandler) BulkAction(c *gin.Context) {
 // ListFolders lists all folders
 func (h *EmailHandler) ListFolders(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	folders, err := h.folderRepo.List(userID)
 	if err != nil {
@@ -379,9 +384,6 @@ func (h *EmailHandler) ListFolders(c *gin.Context) {
 // CreateFolder creates a new folder
 func (h *EmailHandler) CreateFolder(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	var folder model.Folder
 	if err := c.ShouldBindJSON(&folder); err != nil {
@@ -405,9 +407,6 @@ func (h *EmailHandler) CreateFolder(c *gin.Context) {
 // GetFolder retrieves a folder by ID
 func (h *EmailHandler) GetFolder(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	folderID := c.Param("id")
 
@@ -424,9 +423,6 @@ func (h *EmailHandler) GetFolder(c *gin.Context) {
 // UpdateFolder updates a folder
 func (h *EmailHandler) UpdateFolder(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	folderID := c.Param("id")
 
@@ -452,9 +448,6 @@ func (h *EmailHandler) UpdateFolder(c *gin.Context) {
 // DeleteFolder deletes a folder
 func (h *EmailHandler) DeleteFolder(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	folderID := c.Param("id")
 
@@ -473,9 +466,6 @@ func (h *EmailHandler) DeleteFolder(c *gin.Context) {
 // ListLabels lists all labels
 func (h *EmailHandler) ListLabels(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	labels, err := h.labelRepo.List(userID)
 	if err != nil {
@@ -490,9 +480,6 @@ func (h *EmailHandler) ListLabels(c *gin.Context) {
 // CreateLabel creates a new label
 func (h *EmailHandler) CreateLabel(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	var label model.Label
 	if err := c.ShouldBindJSON(&label); err != nil {
@@ -515,9 +502,6 @@ func (h *EmailHandler) CreateLabel(c *gin.Context) {
 // GetLabel retrieves a label by ID
 func (h *EmailHandler) GetLabel(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	labelID := c.Param("id")
 
@@ -534,9 +518,6 @@ func (h *EmailHandler) GetLabel(c *gin.Context) {
 // UpdateLabel updates a label
 func (h *EmailHandler) UpdateLabel(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	labelID := c.Param("id")
 
@@ -562,9 +543,6 @@ func (h *EmailHandler) UpdateLabel(c *gin.Context) {
 // DeleteLabel deletes a label
 func (h *EmailHandler) DeleteLabel(c *gin.Context) {
 	userID := c.GetString("userID")
-	if userID == "" {
-		userID = "default-user"
-	}
 
 	labelID := c.Param("id")
 
@@ -578,8 +556,153 @@ func (h *EmailHandler) DeleteLabel(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
-// DownloadAttachment downloads an attachment
+// Sender List Handlers
+
+// ListSenders lists the user's allowed and blocked senders
+func (h *EmailHandler) ListSenders(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	entries, err := h.spamRepo.ListSenders(userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list sender lists")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sender lists"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"senders": entries})
+}
+
+// AddSender adds an address or domain to the user's allow or block list
+func (h *EmailHandler) AddSender(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var entry model.SenderListEntry
+	if err := c.ShouldBindJSON(&entry); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry.UserID = userID
+
+	err := h.spamRepo.AddSender(&entry)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to add sender list entry")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add sender list entry"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, entry)
+}
+
+// RemoveSender removes an entry from the user's sender lists
+func (h *EmailHandler) RemoveSender(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	err := h.spamRepo.RemoveSender(c.Param("id"), userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove sender list entry")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove sender list entry"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// Attachment Handlers
+
+// inlineSafeTypes are content types a browser may render inline without
+// risk of script execution
+var inlineSafeTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/bmp":       true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// DownloadAttachment downloads an attachment. Pass ?disposition=inline to
+// view safe content types in the browser.
 func (h *EmailHandler) DownloadAttachment(c *gin.Context) {
-	// TODO: Implement attachment download
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
+	h.serveAttachment(c, c.Query("disposition") == "inline")
+}
+
+// InlineAttachment serves an inline attachment (e.g. a cid: image) for display
+func (h *EmailHandler) InlineAttachment(c *gin.Context) {
+	h.serveAttachment(c, true)
+}
+
+// serveAttachment streams an attachment with range request support
+func (h *EmailHandler) serveAttachment(c *gin.Context, inline bool) {
+	userID := c.GetString("userID")
+
+	attachmentID := c.Param("id")
+
+	att, err := h.emailService.GetUserAttachment(attachmentID, userID)
+	if errors.Is(err, service.ErrAttachmentUnsafe) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Attachment blocked by content policy", "scan_status": att.ScanStatus})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("attachmentID", attachmentID).Msg("Failed to get attachment")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	object, err := h.emailService.OpenAttachment(att.StoragePath)
+	if err != nil {
+		log.Error().Err(err).Str("attachmentID", attachmentID).Msg("Failed to open attachment")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download attachment"})
+		return
+	}
+	defer object.Close()
+
+	contentType := att.ContentType
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || contentType == "" {
+		contentType = "application/octet-stream"
+		mediaType = contentType
+	}
+
+	disposition := "attachment"
+	if inline && inlineSafeTypes[mediaType] {
+		disposition = "inline"
+	}
+
+	filename := att.Filename
+	if filename == "" {
+		filename = "attachment"
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=3600")
+
+	// ServeContent handles Range / If-Range and sets Content-Length
+	http.ServeContent(c.Writer, c.Request, "", att.CreatedAt, object)
+}
+
+// DownloadAllAttachments streams every attachment of an email as a zip archive
+func (h *EmailHandler) DownloadAllAttachments(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	emailID := c.Param("id")
+
+	email, err := h.emailService.GetEmail(emailID, userID)
+	if err != nil {
+		log.Error().Err(err).Str("emailID", emailID).Msg("Failed to get email")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "attachments.zip"}))
+	c.Status(http.StatusOK)
+
+	if err := h.emailService.WriteAttachmentsZip(c.Writer, email); err != nil {
+		// Headers are already sent; the truncated archive signals the failure
+		log.Error().Err(err).Str("emailID", emailID).Msg("Failed to stream attachments zip")
+	}
 }
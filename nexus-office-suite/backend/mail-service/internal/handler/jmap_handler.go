@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/jmap"
+	"nexus-mail-service/internal/middleware"
+	"nexus-mail-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// JMAPHandler serves the JMAP API: session, method calls, blobs and push
+type JMAPHandler struct {
+	jmapService *service.JMAPService
+	notifier    *service.ChangeNotifier
+	config      *config.Config
+}
+
+// NewJMAPHandler creates a new JMAP handler
+func NewJMAPHandler(jmapService *service.JMAPService, notifier *service.ChangeNotifier, cfg *config.Config) *JMAPHandler {
+	return &JMAPHandler{
+		jmapService: jmapService,
+		notifier:    notifier,
+		config:      cfg,
+	}
+}
+
+// RegisterRoutes registers HTTP routes on the authenticated API group.
+// JMAP addresses delegated mailboxes by account ID rather than by the
+// X-Mailbox-ID header.
+func (h *JMAPHandler) RegisterRoutes(api *gin.RouterGroup) {
+	{
+		j := api.Group("/jmap")
+		{
+			j.GET("/session", h.GetSession)
+			j.POST("", h.API)
+			j.GET("/download/:accountId/:blobId/:name", h.Download)
+			j.POST("/upload/:accountId", h.Upload)
+			j.GET("/eventsource", h.EventSource)
+		}
+	}
+}
+
+// RegisterWellKnown registers the /.well-known/jmap autodiscovery redirect
+func (h *JMAPHandler) RegisterWellKnown(router *gin.Engine) {
+	router.GET("/.well-known/jmap", func(c *gin.Context) {
+		c.Redirect(http.StatusTemporaryRedirect, h.baseURL(c)+"/api/v1/jmap/session")
+	})
+}
+
+// baseURL returns the scheme and host the client used to reach us
+func (h *JMAPHandler) baseURL(c *gin.Context) string {
+	if h.config.JMAP.PublicURL != "" {
+		return h.config.JMAP.PublicURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if forwarded := c.GetHeader("X-Forwarded-Proto"); forwarded != "" {
+		scheme = forwarded
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// GetSession returns the JMAP session resource
+func (h *JMAPHandler) GetSession(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	session, err := h.jmapService.Session(claims, h.baseURL(c))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to build JMAP session")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build session"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
+	c.JSON(http.StatusOK, session)
+}
+
+// API executes a JMAP request
+func (h *JMAPHandler) API(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, service.JMAPMaxSizeRequest)
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		h.problem(c, &jmap.Problem{Type: jmap.ProblemLimit, Status: http.StatusRequestEntityTooLarge, Limit: "maxSizeRequest"})
+		return
+	}
+
+	var req jmap.Request
+	if !json.Valid(body) {
+		h.problem(c, &jmap.Problem{Type: jmap.ProblemNotJSON, Status: http.StatusBadRequest, Detail: "request body is not JSON"})
+		return
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Using == nil || req.MethodCalls == nil {
+		detail := "request must have using and methodCalls"
+		if err != nil {
+			detail = err.Error()
+		}
+		h.problem(c, &jmap.Problem{Type: jmap.ProblemNotRequest, Status: http.StatusBadRequest, Detail: detail})
+		return
+	}
+
+	response, problem := h.jmapService.Execute(claims, &req)
+	if problem != nil {
+		h.problem(c, problem)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// problem sends a request-level error as an RFC 7807 problem document
+func (h *JMAPHandler) problem(c *gin.Context, problem *jmap.Problem) {
+	data, _ := json.Marshal(problem)
+	c.Data(problem.Status, "application/problem+json", data)
+}
+
+// Download serves a blob
+func (h *JMAPHandler) Download(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	blob, err := h.jmapService.OpenBlob(claims, c.Param("accountId"), c.Param("blobId"))
+	if err != nil {
+		h.blobError(c, err)
+		return
+	}
+	defer blob.Close()
+
+	contentType := blob.Type
+	if t := c.Query("type"); t != "" {
+		contentType = t
+	}
+
+	name := c.Param("name")
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, immutable, max-age=31536000")
+	http.ServeContent(c.Writer, c.Request, name, blob.ModTime, blob.Content)
+}
+
+// Upload stores a blob for use in Email/set
+func (h *JMAPHandler) Upload(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.config.Email.MaxAttachmentSize)
+
+	result, err := h.jmapService.Upload(claims, c.Param("accountId"), c.ContentType(), c.Request.Body, c.Request.ContentLength)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.problem(c, &jmap.Problem{Type: jmap.ProblemLimit, Status: http.StatusRequestEntityTooLarge, Limit: "maxSizeUpload"})
+			return
+		}
+		h.blobError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// blobError maps blob and account errors to HTTP statuses
+func (h *JMAPHandler) blobError(c *gin.Context, err error) {
+	var methodErr *jmap.MethodError
+	switch {
+	case errors.Is(err, service.ErrBlobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Blob not found"})
+	case errors.As(err, &methodErr) && methodErr.Type == jmap.ErrorAccountNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+	case errors.As(err, &methodErr) && methodErr.Type == jmap.ErrorAccountReadOnly:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is read-only"})
+	default:
+		log.Error().Err(err).Msg("JMAP blob request failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process blob"})
+	}
+}
+
+// EventSource pushes StateChange events (RFC 8620 section 7.3) whenever
+// mail in one of the caller's accounts changes
+func (h *JMAPHandler) EventSource(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	accounts, err := h.jmapService.Accounts(claims)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load JMAP accounts")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load accounts"})
+		return
+	}
+
+	var types map[string]bool
+	if t := c.Query("types"); t != "" && t != "*" {
+		types = make(map[string]bool)
+		for _, name := range strings.Split(t, ",") {
+			types[strings.TrimSpace(name)] = true
+		}
+	}
+	closeAfterState := c.Query("closeafter") == "state"
+
+	ping := h.config.JMAP.PushPing
+	if p := c.Query("ping"); p != "" {
+		seconds, err := strconv.Atoi(p)
+		if err != nil || seconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ping must be a number of seconds"})
+			return
+		}
+		ping = time.Duration(seconds) * time.Second
+	}
+	if ping > 0 && ping < 30*time.Second {
+		// The spec lets us pick a longer interval than asked for
+		ping = 30 * time.Second
+	}
+
+	accountIDs := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		accountIDs = append(accountIDs, account.ID)
+	}
+
+	// Subscribe before reading states so no change falls in between
+	sub := h.notifier.Subscribe(accountIDs)
+	defer sub.Close()
+
+	known := make(map[string]map[string]string, len(accountIDs))
+	for _, accountID := range accountIDs {
+		states, err := h.jmapService.States(accountID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to load JMAP states")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load state"})
+			return
+		}
+		known[accountID] = states
+	}
+
+	// The stream outlives the server's write timeout
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Warn().Err(err).Msg("Failed to clear write deadline for event source")
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	var pingC <-chan time.Time
+	if ping > 0 {
+		ticker := time.NewTicker(ping)
+		defer ticker.Stop()
+		pingC = ticker.C
+	}
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+
+		case <-pingC:
+			fmt.Fprintf(c.Writer, "event: ping\ndata: {\"interval\":%d}\n\n", int(ping.Seconds()))
+			c.Writer.Flush()
+
+		case <-sub.Wake():
+			change := jmap.StateChange{Type: "StateChange", Changed: map[string]map[string]string{}}
+			for _, accountID := range sub.Changed() {
+				states, err := h.jmapService.States(accountID)
+				if err != nil {
+					log.Warn().Err(err).Str("accountID", accountID).Msg("Failed to load JMAP states")
+					continue
+				}
+
+				changed := map[string]string{}
+				for typeName, state := range states {
+					if state != known[accountID][typeName] && (types == nil || types[typeName]) {
+						changed[typeName] = state
+					}
+				}
+				known[accountID] = states
+				if len(changed) > 0 {
+					change.Changed[accountID] = changed
+				}
+			}
+			if len(change.Changed) == 0 {
+				continue
+			}
+
+			data, _ := json.Marshal(change)
+			fmt.Fprintf(c.Writer, "event: state\ndata: %s\n\n", data)
+			c.Writer.Flush()
+
+			if closeAfterState {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,532 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"nexus-mail-service/internal/middleware"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+	"nexus-mail-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// ListHandler handles HTTP requests for distribution lists
+type ListHandler struct {
+	listService  *service.ListService
+	listRepo     *repository.ListRepository
+	emailService *service.EmailService
+}
+
+// NewListHandler creates a new list handler
+func NewListHandler(
+	listService *service.ListService,
+	listRepo *repository.ListRepository,
+	emailService *service.EmailService,
+) *ListHandler {
+	return &ListHandler{
+		listService:  listService,
+		listRepo:     listRepo,
+		emailService: emailService,
+	}
+}
+
+// RegisterRoutes registers HTTP routes on the authenticated API group.
+// Tenant admins create and delete lists; owners (and admins) change
+// settings and membership, moderators work the moderation queue, and
+// members read the archive and manage their own subscription.
+func (h *ListHandler) RegisterRoutes(api *gin.RouterGroup) {
+	{
+		lists := api.Group("/lists")
+		{
+			lists.GET("", h.ListLists)
+			lists.POST("", middleware.RequireRole("admin"), h.CreateList)
+			lists.GET("/:id", h.GetList)
+			lists.PUT("/:id", h.UpdateList)
+			lists.DELETE("/:id", middleware.RequireRole("admin"), h.DeleteList)
+
+			lists.GET("/:id/members", h.ListMembers)
+			lists.POST("/:id/members", h.AddMember)
+			lists.PUT("/:id/members/:memberId", h.UpdateMember)
+			lists.DELETE("/:id/members/:memberId", h.RemoveMember)
+
+			lists.GET("/:id/moderation", h.ListPending)
+			lists.POST("/:id/moderation/:emailId/approve", h.ApprovePost)
+			lists.POST("/:id/moderation/:emailId/reject", h.RejectPost)
+
+			lists.GET("/:id/archive", h.ListArchive)
+			lists.GET("/:id/archive/:emailId", h.GetArchivedPost)
+			lists.GET("/:id/archive/attachments/:attachmentId", h.DownloadArchivedAttachment)
+		}
+	}
+}
+
+// listAccess is what the caller may do with a list
+type listAccess struct {
+	list   *model.DistributionList
+	member *model.ListMember // nil if the caller is not subscribed
+	admin  bool
+}
+
+func (a *listAccess) canManage() bool {
+	return a.admin || (a.member != nil && a.member.Role == model.ListRoleOwner)
+}
+
+func (a *listAccess) canModerate() bool {
+	return a.admin || (a.member != nil && a.member.CanModerate())
+}
+
+func (a *listAccess) canRead() bool {
+	return a.admin || a.member != nil
+}
+
+// access loads a list of the caller's tenant along with the caller's
+// membership. It writes the error response and returns nil on failure.
+func (h *ListHandler) access(c *gin.Context) *listAccess {
+	claims, _ := middleware.GetClaims(c)
+
+	list, err := h.listRepo.GetByID(c.Param("id"), claims.TenantID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Error().Err(err).Str("listID", c.Param("id")).Msg("Failed to get list")
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "List not found"})
+		return nil
+	}
+
+	member, err := h.listRepo.FindMember(list.ID, claims.UserID, claims.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Error().Err(err).Str("listID", list.ID).Msg("Failed to get list membership")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get list"})
+		return nil
+	}
+
+	access := &listAccess{list: list, member: member, admin: claims.HasRole("admin")}
+	if !access.canRead() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "List not found"})
+		return nil
+	}
+	return access
+}
+
+// ListLists returns the tenant's distribution lists
+func (h *ListHandler) ListLists(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	lists, err := h.listRepo.ListByTenant(claims.TenantID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list distribution lists")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list distribution lists"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lists": lists})
+}
+
+// CreateList creates a distribution list
+func (h *ListHandler) CreateList(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	var list model.DistributionList
+	if err := c.ShouldBindJSON(&list); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list.ID = ""
+	list.TenantID = claims.TenantID
+	list.CreatedBy = claims.UserID
+	if list.PostingPolicy == "" {
+		list.PostingPolicy = model.PostingMembers
+	}
+	if !validPostingPolicy(list.PostingPolicy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "posting_policy must be members, moderated or anyone"})
+		return
+	}
+
+	if existing, err := h.listService.Lookup(list.Address); err != nil || existing != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A list with this address already exists"})
+		return
+	}
+
+	if err := h.listRepo.Create(&list); err != nil {
+		log.Error().Err(err).Msg("Failed to create list")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create list"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, list)
+}
+
+// GetList returns a list's settings
+func (h *ListHandler) GetList(c *gin.Context) {
+	access := h.access(c)
+	if access == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"list": access.list, "membership": access.member})
+}
+
+// UpdateList changes a list's settings
+func (h *ListHandler) UpdateList(c *gin.Context) {
+	access := h.access(c)
+	if access == nil {
+		return
+	}
+	if !access.canManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only list owners may change list settings"})
+		return
+	}
+
+	var req model.DistributionList
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validPostingPolicy(req.PostingPolicy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "posting_policy must be members, moderated or anyone"})
+		return
+	}
+
+	list := access.list
+	list.Name = req.Name
+	list.Description = req.Description
+	list.PostingPolicy = req.PostingPolicy
+	list.SubjectPrefix = req.SubjectPrefix
+	list.ArchiveEnabled = req.ArchiveEnabled
+
+	if err := h.listRepo.Update(list); err != nil {
+		log.Error().Err(err).Msg("Failed to update list")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update list"})
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// DeleteList deletes a list and its membership. The archive stays in the
+// list's mailbox.
+func (h *ListHandler) DeleteList(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	if err := h.listRepo.Delete(c.Param("id"), claims.TenantID); err != nil {
+		log.Error().Err(err).Msg("Failed to delete list")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete list"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "List deleted"})
+}
+
+// ListMembers returns a list's members
+func (h *ListHandler) ListMembers(c *gin.Context) {
+	access := h.access(c)
+	if access == nil {
+		return
+	}
+	if !access.canModerate() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only list moderators may view the membership"})
+		return
+	}
+
+	members, err := h.listRepo.ListMembers(access.list.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list members")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// AddMember subscribes an address or a local user to a list
+func (h *ListHandler) AddMember(c *gin.Context) {
+	access := h.access(c)
+	if access == nil {
+		return
+	}
+	if !access.canManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only list owners may add members"})
+		return
+	}
+
+	var req model.ListMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member := &model.ListMember{
+		ListID:   access.list.ID,
+		Address:  strings.TrimSpace(req.Address),
+		UserID:   req.UserID,
+		Role:     req.Role,
+		Delivery: req.Delivery,
+	}
+	if member.Address == "" && member.UserID != nil {
+		member.Address = h.emailService.MailboxAddress(*member.UserID)
+	}
+	if member.Address == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "address or user_id is required"})
+		return
+	}
+	if member.Role == "" {
+		member.Role = model.ListRoleMember
+	}
+	if member.Delivery == "" {
+		member.Delivery = model.DeliveryEach
+	}
+	if !validListRole(member.Role) || !validDelivery(member.Delivery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role or delivery mode"})
+		return
+	}
+
+	if err := h.listRepo.UpsertMember(member); err != nil {
+		log.Error().Err(err).Msg("Failed to add list member")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, member)
+}
+
+// UpdateMember changes a member's role or delivery mode. Members may change
+// their own delivery mode; only owners may change roles.
+func (h *ListHandler) UpdateMember(c *gin.Context) {
+	access := h.access(c)
+	if access == nil {
+		return
+	}
+
+	member, err := h.listRepo.GetMember(access.list.ID, c.Param("memberId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	self := access.member != nil && access.member.ID == member.ID
+	if !self && !access.canManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only list owners may change other members"})
+		return
+	}
+
+	var req model.ListMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Role != "" && req.Role != member.Role {
+		if !access.canManage() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only list owners may change roles"})
+			return
+		}
+		member.Role = req.Role
+	}
+	if req.Delivery != "" {
+		member.Delivery = req.Delivery
+	}
+	if !validListRole(member.Role) || !validDelivery(member.Delivery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role or delivery mode"})
+		return
+	}
+
+	if err := h.listRepo.UpsertMember(member); err != nil {
+		log.Error().Err(err).Msg("Failed to update list member")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveMember unsubscribes a member. Members may remove themselves.
+func (h *ListHandler) RemoveMember(c *gin.Context) {
+	access := h.access(c)
+	if access == nil {
+		return
+	}
+
+	memberID := c.Param("memberId")
+	self := access.member != nil && access.member.ID == memberID
+	if !self && !access.canManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only list owners may remove other members"})
+		return
+	}
+
+	if err := h.listRepo.RemoveMember(access.list.ID, memberID); err != nil {
+		log.Error().Err(err).Msg("Failed to remove list member")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// ListPending returns the posts awaiting moderation
+func (h *ListHandler) ListPending(c *gin.Context) {
+	access := h.access(c)
+	if access == nil {
+		return
+	}
+	if !access.canModerate() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only list moderators may view the moderation queue"})
+		return
+	}
+
+	emails, err := h.listService.Pending(access.list)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list pending posts")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list pending posts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"emails": emails})
+}
+
+// ApprovePost releases a held post to the list
+func (h *ListHandler) ApprovePost(c *gin.Context) {
+	h.moderate(c, h.listService.Approve, "Post approved")
+}
+
+// RejectPost discards a held post
+func (h *ListHandler) RejectPost(c *gin.Context) {
+	h.moderate(c, h.listService.Reject, "Post rejected")
+}
+
+func (h *ListHandler) moderate(c *gin.Context, action func(*model.DistributionList, string, string) error, message string) {
+	access := h.access(c)
+	if access == nil {
+		return
+	}
+	if !access.canModerate() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only list moderators may moderate posts"})
+		return
+	}
+
+	claims, _ := middleware.GetClaims(c)
+	err := action(access.list, c.Param("emailId"), claims.UserID)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"message": message})
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, service.ErrNotPending):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post is not awaiting moderation"})
+	default:
+		log.Error().Err(err).Str("emailID", c.Param("emailId")).Msg("Failed to moderate list post")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to moderate post"})
+	}
+}
+
+// ListArchive returns a page of archived posts
+func (h *ListHandler) ListArchive(c *gin.Context) {
+	access := h.access(c)
+	if access == nil {
+		return
+	}
+	if !access.list.ArchiveEnabled && !access.canModerate() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "List archive is disabled"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 50
+	}
+
+	response, err := h.listService.Archive(access.list, page, pageSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list archive")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list archive"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetArchivedPost returns one archived post
+func (h *ListHandler) GetArchivedPost(c *gin.Context) {
+	access := h.access(c)
+	if access == nil {
+		return
+	}
+	if !access.list.ArchiveEnabled && !access.canModerate() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "List archive is disabled"})
+		return
+	}
+
+	email, err := h.listService.ArchivedPost(access.list, c.Param("emailId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, email)
+}
+
+// DownloadArchivedAttachment serves an attachment of an archived post
+func (h *ListHandler) DownloadArchivedAttachment(c *gin.Context) {
+	access := h.access(c)
+	if access == nil {
+		return
+	}
+	if !access.list.ArchiveEnabled && !access.canModerate() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "List archive is disabled"})
+		return
+	}
+
+	attachmentID := c.Param("attachmentId")
+	att, err := h.emailService.GetUserAttachment(attachmentID, access.list.ID)
+	if errors.Is(err, service.ErrAttachmentUnsafe) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Attachment blocked by content policy", "scan_status": att.ScanStatus})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	object, err := h.emailService.OpenAttachment(att.StoragePath)
+	if err != nil {
+		log.Error().Err(err).Str("attachmentID", attachmentID).Msg("Failed to open attachment")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download attachment"})
+		return
+	}
+	defer object.Close()
+
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	filename := att.Filename
+	if filename == "" {
+		filename = "attachment"
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	http.ServeContent(c.Writer, c.Request, "", att.CreatedAt, object)
+}
+
+func validPostingPolicy(policy string) bool {
+	return policy == model.PostingMembers || policy == model.PostingModerated || policy == model.PostingAnyone
+}
+
+func validListRole(role string) bool {
+	return role == model.ListRoleMember || role == model.ListRoleModerator || role == model.ListRoleOwner
+}
+
+func validDelivery(delivery string) bool {
+	return delivery == model.DeliveryEach || delivery == model.DeliveryDigest || delivery == model.DeliveryNone
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"mime"
+	"net/http"
+
+	"nexus-mail-service/internal/middleware"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+	"nexus-mail-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// MailboxJobHandler handles HTTP requests for mailbox import and export
+type MailboxJobHandler struct {
+	transferService *service.MailboxTransferService
+	emailService    *service.EmailService
+	jobRepo         *repository.JobRepository
+	maxImportSize   int64
+}
+
+// NewMailboxJobHandler creates a new mailbox job handler
+func NewMailboxJobHandler(
+	transferService *service.MailboxTransferService,
+	emailService *service.EmailService,
+	jobRepo *repository.JobRepository,
+	maxImportSize int64,
+) *MailboxJobHandler {
+	return &MailboxJobHandler{
+		transferService: transferService,
+		emailService:    emailService,
+		jobRepo:         jobRepo,
+		maxImportSize:   maxImportSize,
+	}
+}
+
+// RegisterRoutes registers HTTP routes on the authenticated API group
+func (h *MailboxJobHandler) RegisterRoutes(api *gin.RouterGroup) {
+	{
+		mailbox := api.Group("/mailbox")
+		{
+			mailbox.POST("/import", h.ImportMailbox)
+			mailbox.POST("/export", h.ExportMailbox)
+			mailbox.GET("/jobs", h.ListJobs)
+			mailbox.GET("/jobs/:id", h.GetJob)
+			mailbox.GET("/jobs/:id/download", h.DownloadExport)
+		}
+	}
+}
+
+// ImportMailbox accepts an mbox, EML or zip upload and starts an import job
+func (h *MailboxJobHandler) ImportMailbox(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxImportSize)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required and must not exceed the import size limit"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+		return
+	}
+	defer file.Close()
+
+	var folderID *string
+	if id := c.PostForm("folder_id"); id != "" {
+		folderID = &id
+	}
+
+	job, err := h.transferService.StartImport(
+		userID,
+		c.GetString(middleware.ContextEmail),
+		fileHeader.Filename,
+		file,
+		fileHeader.Size,
+		folderID,
+	)
+	if errors.Is(err, service.ErrUnsupportedArchive) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start mailbox import")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start import"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ExportMailbox starts an export job for a folder or the whole mailbox
+func (h *MailboxJobHandler) ExportMailbox(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var req model.ExportMailboxRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, err := h.transferService.StartExport(userID, &req)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start mailbox export")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ListJobs returns the user's recent import and export jobs
+func (h *MailboxJobHandler) ListJobs(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	jobs, err := h.jobRepo.List(userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list mailbox jobs")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
+
+// GetJob returns the status and progress of a job
+func (h *MailboxJobHandler) GetJob(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	job, err := h.jobRepo.GetByID(c.Param("id"), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// DownloadExport streams the archive of a completed export job
+func (h *MailboxJobHandler) DownloadExport(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	jobID := c.Param("id")
+
+	job, err := h.jobRepo.GetByID(jobID, userID)
+	if err != nil || job.Type != model.JobTypeExport {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if job.Status != model.JobStatusCompleted || job.StoragePath == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Export is not ready", "status": job.Status})
+		return
+	}
+
+	object, err := h.emailService.OpenAttachment(job.StoragePath)
+	if err != nil {
+		log.Error().Err(err).Str("jobID", jobID).Msg("Failed to open export")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download export"})
+		return
+	}
+	defer object.Close()
+
+	contentType := "application/mbox"
+	if job.Format == model.JobFormatEML {
+		contentType = "application/zip"
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": job.Filename}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, no-store")
+
+	completedAt := job.UpdatedAt
+	if job.CompletedAt != nil {
+		completedAt = *job.CompletedAt
+	}
+
+	// ServeContent handles Range requests so large exports can be resumed
+	http.ServeContent(c.Writer, c.Request, "", completedAt, object)
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"net/http"
+
+	"nexus-mail-service/internal/middleware"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+	"nexus-mail-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// PolicyHandler handles HTTP requests for tenant mail policies
+type PolicyHandler struct {
+	contentFilter *service.ContentFilter
+	policyRepo    *repository.PolicyRepository
+}
+
+// NewPolicyHandler creates a new policy handler
+func NewPolicyHandler(
+	contentFilter *service.ContentFilter,
+	policyRepo *repository.PolicyRepository,
+) *PolicyHandler {
+	return &PolicyHandler{
+		contentFilter: contentFilter,
+		policyRepo:    policyRepo,
+	}
+}
+
+// RegisterRoutes registers HTTP routes on the authenticated API group.
+// Policies are tenant-wide, so only admins may read or change them, and
+// only those of their own tenant.
+func (h *PolicyHandler) RegisterRoutes(api *gin.RouterGroup) {
+	{
+		policies := api.Group("/policies/attachments", middleware.RequireRole("admin"), requireOwnTenant)
+		{
+			policies.GET("/:tenantId", h.GetAttachmentPolicy)
+			policies.PUT("/:tenantId", h.UpdateAttachmentPolicy)
+			policies.DELETE("/:tenantId", h.DeleteAttachmentPolicy)
+		}
+	}
+}
+
+// requireOwnTenant rejects requests for a tenant other than the caller's
+func requireOwnTenant(c *gin.Context) {
+	tenantID := c.GetString(middleware.ContextTenantID)
+	if tenantID == "" || c.Param("tenantId") != tenantID {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+	c.Next()
+}
+
+// GetAttachmentPolicy returns the effective attachment policy for a tenant
+func (h *PolicyHandler) GetAttachmentPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, h.contentFilter.Policy(c.Param("tenantId")))
+}
+
+// UpdateAttachmentPolicy creates or replaces a tenant's attachment policy
+func (h *PolicyHandler) UpdateAttachmentPolicy(c *gin.Context) {
+	var policy model.AttachmentPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy.TenantID = c.Param("tenantId")
+
+	if policy.BlockAction != model.PolicyActionReject && policy.BlockAction != model.PolicyActionQuarantine {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "block_action must be reject or quarantine"})
+		return
+	}
+	if policy.ScannerDownAction != model.PolicyActionTempfail && policy.ScannerDownAction != model.PolicyActionDeliver {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scanner_down_action must be tempfail or deliver"})
+		return
+	}
+
+	if err := h.policyRepo.UpsertAttachmentPolicy(&policy); err != nil {
+		log.Error().Err(err).Msg("Failed to update attachment policy")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update attachment policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// DeleteAttachmentPolicy reverts a tenant to the default attachment policy
+func (h *PolicyHandler) DeleteAttachmentPolicy(c *gin.Context) {
+	if err := h.policyRepo.DeleteAttachmentPolicy(c.Param("tenantId")); err != nil {
+		log.Error().Err(err).Msg("Failed to delete attachment policy")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete attachment policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/middleware"
+	"nexus-mail-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newPolicyRouter serves the policy routes to an admin of tenant-a
+func newPolicyRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	api := router.Group("/api/v1", func(c *gin.Context) {
+		claims := &middleware.Claims{UserID: "admin-a", TenantID: "tenant-a", Roles: []string{"admin"}}
+		c.Set(middleware.ContextClaims, claims)
+		c.Set(middleware.ContextUserID, claims.UserID)
+		c.Set(middleware.ContextTenantID, claims.TenantID)
+		c.Next()
+	})
+
+	contentFilter := service.NewContentFilter(&config.Config{}, nil, nil)
+	NewPolicyHandler(contentFilter, nil).RegisterRoutes(api)
+	return router
+}
+
+func TestAttachmentPolicyCrossTenant(t *testing.T) {
+	router := newPolicyRouter()
+
+	body := `{"block_action":"reject","scanner_down_action":"tempfail"}`
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/api/v1/policies/attachments/tenant-b", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s of another tenant's policy returned %d, want %d", method, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestAttachmentPolicyOwnTenant(t *testing.T) {
+	router := newPolicyRouter()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/policies/attachments/tenant-a", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("GET of own tenant's policy returned %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"net/http"
+
+	"nexus-mail-service/internal/middleware"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+	"nexus-mail-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// QuotaHandler handles HTTP requests for storage quotas
+type QuotaHandler struct {
+	quotaService *service.QuotaService
+	quotaRepo    *repository.QuotaRepository
+}
+
+// NewQuotaHandler creates a new quota handler
+func NewQuotaHandler(quotaService *service.QuotaService, quotaRepo *repository.QuotaRepository) *QuotaHandler {
+	return &QuotaHandler{
+		quotaService: quotaService,
+		quotaRepo:    quotaRepo,
+	}
+}
+
+// RegisterRoutes registers HTTP routes on the authenticated API group.
+// Anyone may read their mailbox's quota; setting quotas is for admins,
+// within their own tenant.
+func (h *QuotaHandler) RegisterRoutes(api *gin.RouterGroup) {
+	{
+		api.GET("/quota", h.GetQuota)
+
+		quotas := api.Group("/quotas", middleware.RequireRole("admin"))
+		{
+			quotas.GET("/tenant", h.GetTenantQuota)
+			quotas.PUT("/tenant", h.SetTenantQuota)
+			quotas.DELETE("/tenant", h.DeleteTenantQuota)
+			quotas.GET("/users/:userId", h.GetUserQuota)
+			quotas.PUT("/users/:userId", h.SetUserQuota)
+			quotas.DELETE("/users/:userId", h.DeleteUserQuota)
+		}
+	}
+}
+
+// GetQuota returns the current mailbox's usage and limits
+func (h *QuotaHandler) GetQuota(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	status, err := h.quotaService.Status(userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get quota")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get quota"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// GetTenantQuota returns the caller's tenant quota and usage
+func (h *QuotaHandler) GetTenantQuota(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	usage, err := h.quotaRepo.TenantUsage(claims.TenantID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get tenant usage")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tenant quota"})
+		return
+	}
+
+	var limit int64
+	if quota, err := h.quotaRepo.GetQuota(model.QuotaScopeTenant, claims.TenantID); err == nil {
+		limit = quota.StorageLimitBytes
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tenant_id":           claims.TenantID,
+		"storage_bytes":       usage,
+		"storage_limit_bytes": limit,
+	})
+}
+
+// SetTenantQuota sets the caller's tenant storage limit
+func (h *QuotaHandler) SetTenantQuota(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+	h.setQuota(c, model.QuotaScopeTenant, claims.TenantID)
+}
+
+// DeleteTenantQuota makes the caller's tenant unlimited
+func (h *QuotaHandler) DeleteTenantQuota(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+	h.deleteQuota(c, model.QuotaScopeTenant, claims.TenantID)
+}
+
+// GetUserQuota returns a mailbox's usage and limits
+func (h *QuotaHandler) GetUserQuota(c *gin.Context) {
+	userID, ok := h.tenantMailbox(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.quotaService.Status(userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get quota")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get quota"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// SetUserQuota sets a mailbox's storage limit
+func (h *QuotaHandler) SetUserQuota(c *gin.Context) {
+	userID, ok := h.tenantMailbox(c)
+	if !ok {
+		return
+	}
+	h.setQuota(c, model.QuotaScopeUser, userID)
+}
+
+// DeleteUserQuota reverts a mailbox to the default storage limit
+func (h *QuotaHandler) DeleteUserQuota(c *gin.Context) {
+	userID, ok := h.tenantMailbox(c)
+	if !ok {
+		return
+	}
+	h.deleteQuota(c, model.QuotaScopeUser, userID)
+}
+
+// tenantMailbox resolves the :userId mailbox and checks that it belongs to
+// the caller's tenant. Mailboxes are tied to the tenant of the domain they
+// receive mail at, never by an admin's request.
+func (h *QuotaHandler) tenantMailbox(c *gin.Context) (string, bool) {
+	claims, _ := middleware.GetClaims(c)
+	userID := c.Param("userId")
+
+	usage, err := h.quotaRepo.GetUsage(userID)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Msg("Failed to get mailbox usage")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get mailbox"})
+		return "", false
+	}
+	if usage.TenantID == nil || *usage.TenantID != claims.TenantID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mailbox not found"})
+		return "", false
+	}
+
+	return userID, true
+}
+
+func (h *QuotaHandler) setQuota(c *gin.Context, scope, subjectID string) {
+	claims, _ := middleware.GetClaims(c)
+
+	var quota model.MailQuota
+	if err := c.ShouldBindJSON(&quota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	quota.Scope = scope
+	quota.SubjectID = subjectID
+	quota.UpdatedBy = claims.UserID
+
+	if err := h.quotaRepo.UpsertQuota(&quota); err != nil {
+		log.Error().Err(err).Msg("Failed to set quota")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set quota"})
+		return
+	}
+
+	c.JSON(http.StatusOK, quota)
+}
+
+func (h *QuotaHandler) deleteQuota(c *gin.Context, scope, subjectID string) {
+	if err := h.quotaRepo.DeleteQuota(scope, subjectID); err != nil {
+		log.Error().Err(err).Msg("Failed to delete quota")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete quota"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Quota removed"})
+}
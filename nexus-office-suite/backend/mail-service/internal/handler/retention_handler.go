@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"nexus-mail-service/internal/middleware"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+	"nexus-mail-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// RetentionHandler handles HTTP requests for retention policies and legal holds
+type RetentionHandler struct {
+	retentionService *service.RetentionService
+	retentionRepo    *repository.RetentionRepository
+	quotaRepo        *repository.QuotaRepository
+}
+
+// NewRetentionHandler creates a new retention handler
+func NewRetentionHandler(
+	retentionService *service.RetentionService,
+	retentionRepo *repository.RetentionRepository,
+	quotaRepo *repository.QuotaRepository,
+) *RetentionHandler {
+	return &RetentionHandler{
+		retentionService: retentionService,
+		retentionRepo:    retentionRepo,
+		quotaRepo:        quotaRepo,
+	}
+}
+
+// RegisterRoutes registers HTTP routes on the authenticated API group.
+// Retention and legal holds apply to the caller's tenant and are for admins.
+func (h *RetentionHandler) RegisterRoutes(api *gin.RouterGroup) {
+	{
+		retention := api.Group("/retention", middleware.RequireRole("admin"))
+		{
+			retention.GET("", h.GetPolicies)
+			retention.PUT("/:folderType", h.SetPolicy)
+			retention.DELETE("/:folderType", h.DeletePolicy)
+		}
+
+		holds := api.Group("/legal-holds", middleware.RequireRole("admin"))
+		{
+			holds.GET("", h.ListHolds)
+			holds.POST("", h.CreateHold)
+			holds.DELETE("/:id", h.ReleaseHold)
+		}
+	}
+}
+
+// GetPolicies returns the effective retention period of each folder type
+func (h *RetentionHandler) GetPolicies(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	overrides, err := h.retentionRepo.ListPolicies(claims.TenantID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list retention policies")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list retention policies"})
+		return
+	}
+
+	policies := make([]model.RetentionPolicy, 0, len(service.RetentionFolderTypes))
+	for _, folderType := range service.RetentionFolderTypes {
+		policy := model.RetentionPolicy{
+			TenantID:      claims.TenantID,
+			FolderType:    folderType,
+			RetentionDays: h.retentionService.DefaultRetentionDays(folderType),
+		}
+		for _, override := range overrides {
+			if override.FolderType == folderType {
+				policy = override
+			}
+		}
+		policies = append(policies, policy)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policies": policies})
+}
+
+// SetPolicy overrides the tenant's retention period for a folder type
+func (h *RetentionHandler) SetPolicy(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	folderType := c.Param("folderType")
+	if !validRetentionFolder(folderType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Retention applies to trash and spam only"})
+		return
+	}
+
+	var policy model.RetentionPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy.TenantID = claims.TenantID
+	policy.FolderType = folderType
+	policy.UpdatedBy = claims.UserID
+
+	if err := h.retentionRepo.UpsertPolicy(&policy); err != nil {
+		log.Error().Err(err).Msg("Failed to set retention policy")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set retention policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// DeletePolicy reverts a folder type to the default retention period
+func (h *RetentionHandler) DeletePolicy(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	if err := h.retentionRepo.DeletePolicy(claims.TenantID, c.Param("folderType")); err != nil {
+		log.Error().Err(err).Msg("Failed to delete retention policy")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete retention policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Retention policy removed"})
+}
+
+// ListHolds returns the tenant's legal holds; ?active=true omits released ones
+func (h *RetentionHandler) ListHolds(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	holds, err := h.retentionRepo.ListHolds(claims.TenantID, c.Query("active") == "true")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list legal holds")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list legal holds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"holds": holds})
+}
+
+// CreateHold places a mailbox of the tenant under legal hold
+func (h *RetentionHandler) CreateHold(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	var hold model.LegalHold
+	if err := c.ShouldBindJSON(&hold); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Holds only cover the tenant's own mailboxes
+	usage, err := h.quotaRepo.GetUsage(hold.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get mailbox usage")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create legal hold"})
+		return
+	}
+	if usage.TenantID == nil || *usage.TenantID != claims.TenantID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mailbox not found"})
+		return
+	}
+
+	hold.ID = ""
+	hold.TenantID = claims.TenantID
+	hold.CreatedBy = claims.UserID
+	hold.ReleasedBy = nil
+	hold.ReleasedAt = nil
+
+	if err := h.retentionRepo.CreateHold(&hold); err != nil {
+		log.Error().Err(err).Msg("Failed to create legal hold")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create legal hold"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, hold)
+}
+
+// ReleaseHold ends a legal hold; the mailbox is purged as usual again
+func (h *RetentionHandler) ReleaseHold(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	err := h.retentionRepo.ReleaseHold(c.Param("id"), claims.TenantID, claims.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Legal hold not found"})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to release legal hold")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release legal hold"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Legal hold released"})
+}
+
+func validRetentionFolder(folderType string) bool {
+	for _, t := range service.RetentionFolderTypes {
+		if t == folderType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+	"nexus-mail-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// SecurityHandler handles HTTP requests for S/MIME and OpenPGP keys and the
+// content of encrypted mail
+type SecurityHandler struct {
+	securityService *service.SecurityService
+	emailService    *service.EmailService
+	keyRepo         *repository.CryptoKeyRepository
+}
+
+// NewSecurityHandler creates a new security handler
+func NewSecurityHandler(
+	securityService *service.SecurityService,
+	emailService *service.EmailService,
+	keyRepo *repository.CryptoKeyRepository,
+) *SecurityHandler {
+	return &SecurityHandler{
+		securityService: securityService,
+		emailService:    emailService,
+		keyRepo:         keyRepo,
+	}
+}
+
+// RegisterRoutes registers HTTP routes on the authenticated API group
+func (h *SecurityHandler) RegisterRoutes(api *gin.RouterGroup) {
+	{
+		keys := api.Group("/crypto-keys")
+		{
+			keys.GET("", h.ListKeys)
+			keys.POST("", h.UploadKey)
+			keys.DELETE("/:id", h.DeleteKey)
+		}
+
+		api.GET("/emails/:id/secure-attachments/:index", h.DownloadSecureAttachment)
+	}
+}
+
+// ListKeys returns the mailbox's own keys and its correspondents' keys
+func (h *SecurityHandler) ListKeys(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	keys, err := h.keyRepo.List(userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list keys")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// UploadKey adds an S/MIME certificate or OpenPGP key. With a private key it
+// becomes the mailbox's own key; without, a correspondent's.
+func (h *SecurityHandler) UploadKey(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var req model.UploadKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	keys, err := h.securityService.UploadKey(userID, h.emailService.MailboxAddress(userID), &req)
+	if errors.Is(err, service.ErrInvalidKey) || errors.Is(err, service.ErrKeyAddress) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to upload key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"keys": keys})
+}
+
+// DeleteKey removes a key
+func (h *SecurityHandler) DeleteKey(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	err := h.keyRepo.Delete(c.Param("id"), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to delete key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Key deleted"})
+}
+
+// DownloadSecureAttachment decrypts an encrypted email and serves one of
+// the attachments inside it, as listed in the email's security attachments.
+// Such attachments could not be scanned on delivery, so they are always
+// served for download, never for display.
+func (h *SecurityHandler) DownloadSecureAttachment(c *gin.Context) {
+	userID := c.GetString("userID")
+	emailID := c.Param("id")
+
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment index"})
+		return
+	}
+
+	part, err := h.emailService.GetSecureAttachment(emailID, userID, index)
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, service.ErrAttachmentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("emailID", emailID).Msg("Failed to decrypt attachment")
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to decrypt message"})
+		return
+	}
+
+	filename := part.FileName
+	if filename == "" {
+		filename = "attachment"
+	}
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, "application/octet-stream", part.Content)
+}
@@ -0,0 +1,334 @@
+// Package jmap implements the JMAP (RFC 8620) request/response envelope:
+// method calls, result references, errors and the session resource. The
+// mail methods themselves (RFC 8621) live in the service package.
+package jmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Capabilities supported by the server
+const (
+	CapabilityCore       = "urn:ietf:params:jmap:core"
+	CapabilityMail       = "urn:ietf:params:jmap:mail"
+	CapabilitySubmission = "urn:ietf:params:jmap:submission"
+)
+
+// Request-level problem types (RFC 8620 section 3.6.1)
+const (
+	ProblemUnknownCapability = "urn:ietf:params:jmap:error:unknownCapability"
+	ProblemNotJSON           = "urn:ietf:params:jmap:error:notJSON"
+	ProblemNotRequest        = "urn:ietf:params:jmap:error:notRequest"
+	ProblemLimit             = "urn:ietf:params:jmap:error:limit"
+)
+
+// Method-level error types (RFC 8620 section 3.6.2, RFC 8621)
+const (
+	ErrorUnknownMethod          = "unknownMethod"
+	ErrorInvalidArguments       = "invalidArguments"
+	ErrorInvalidResultReference = "invalidResultReference"
+	ErrorForbidden              = "forbidden"
+	ErrorAccountNotFound        = "accountNotFound"
+	ErrorAccountReadOnly        = "accountReadOnly"
+	ErrorServerFail             = "serverFail"
+	ErrorCannotCalculateChanges = "cannotCalculateChanges"
+	ErrorStateMismatch          = "stateMismatch"
+	ErrorRequestTooLarge        = "requestTooLarge"
+	ErrorUnsupportedFilter      = "unsupportedFilter"
+	ErrorUnsupportedSort        = "unsupportedSort"
+	ErrorAnchorNotFound         = "anchorNotFound"
+)
+
+// SetError types used by /set methods
+const (
+	SetErrorForbidden         = "forbidden"
+	SetErrorNotFound          = "notFound"
+	SetErrorInvalidPatch      = "invalidPatch"
+	SetErrorInvalidProperties = "invalidProperties"
+	SetErrorTooLarge          = "tooLarge"
+	SetErrorBlobNotFound      = "blobNotFound"
+	SetErrorNoRecipients      = "noRecipients"
+	SetErrorInvalidEmail      = "invalidEmail"
+	SetErrorForbiddenFrom     = "forbiddenFrom"
+	SetErrorForbiddenToSend   = "forbiddenToSend"
+)
+
+// Request is a JMAP API request
+type Request struct {
+	Using       []string          `json:"using"`
+	MethodCalls []Call            `json:"methodCalls"`
+	CreatedIDs  map[string]string `json:"createdIds,omitempty"`
+}
+
+// Response is a JMAP API response
+type Response struct {
+	MethodResponses []Invocation      `json:"methodResponses"`
+	CreatedIDs      map[string]string `json:"createdIds,omitempty"`
+	SessionState    string            `json:"sessionState"`
+}
+
+// Call is a method call from a request: [name, arguments, callId]
+type Call struct {
+	Name   string
+	Args   map[string]json.RawMessage
+	CallID string
+}
+
+// UnmarshalJSON decodes the three-element array form of a method call
+func (c *Call) UnmarshalJSON(data []byte) error {
+	var parts []json.RawMessage
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	if len(parts) != 3 {
+		return fmt.Errorf("method call must have 3 elements, got %d", len(parts))
+	}
+	if err := json.Unmarshal(parts[0], &c.Name); err != nil {
+		return fmt.Errorf("invalid method name: %w", err)
+	}
+	if err := json.Unmarshal(parts[1], &c.Args); err != nil || c.Args == nil {
+		return fmt.Errorf("method arguments must be an object")
+	}
+	if err := json.Unmarshal(parts[2], &c.CallID); err != nil {
+		return fmt.Errorf("invalid method call id: %w", err)
+	}
+	return nil
+}
+
+// Decode unmarshals the call's (reference-resolved) arguments into v
+func (c *Call) Decode(v interface{}) error {
+	data, err := json.Marshal(c.Args)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return &MethodError{Type: ErrorInvalidArguments, Description: err.Error()}
+	}
+	return nil
+}
+
+// Invocation is a method response: [name, arguments, callId]
+type Invocation struct {
+	Name   string
+	Args   interface{}
+	CallID string
+}
+
+// MarshalJSON encodes the three-element array form of a method response
+func (i Invocation) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{i.Name, i.Args, i.CallID})
+}
+
+// Problem is a request-level error, sent as an RFC 7807 problem document
+type Problem struct {
+	Type   string `json:"type"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Limit  string `json:"limit,omitempty"`
+}
+
+// MethodError is a method-level error, returned as an "error" response
+type MethodError struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+func (e *MethodError) Error() string {
+	if e.Description == "" {
+		return e.Type
+	}
+	return e.Type + ": " + e.Description
+}
+
+// NewMethodError creates a method error
+func NewMethodError(errorType, format string, args ...interface{}) *MethodError {
+	return &MethodError{Type: errorType, Description: fmt.Sprintf(format, args...)}
+}
+
+// SetError reports why one object in a /set call was not created, updated
+// or destroyed
+type SetError struct {
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Properties  []string `json:"properties,omitempty"`
+}
+
+// NewSetError creates a set error
+func NewSetError(errorType, description string, properties ...string) *SetError {
+	return &SetError{Type: errorType, Description: description, Properties: properties}
+}
+
+func (e *SetError) Error() string {
+	return e.Type + ": " + e.Description
+}
+
+// ResultReference points at part of an earlier method response
+type ResultReference struct {
+	ResultOf string `json:"resultOf"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+}
+
+// ResolveReferences replaces "#name" arguments with the values their result
+// references point at in earlier responses (RFC 8620 section 3.7)
+func (c *Call) ResolveReferences(responses []Invocation) error {
+	for key, raw := range c.Args {
+		if !strings.HasPrefix(key, "#") {
+			continue
+		}
+		name := key[1:]
+		if _, ok := c.Args[name]; ok {
+			return NewMethodError(ErrorInvalidArguments, "both %s and %s given", name, key)
+		}
+
+		var ref ResultReference
+		if err := json.Unmarshal(raw, &ref); err != nil {
+			return NewMethodError(ErrorInvalidResultReference, "invalid reference for %s", key)
+		}
+
+		value, err := resolveReference(&ref, responses)
+		if err != nil {
+			return err
+		}
+
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		delete(c.Args, key)
+		c.Args[name] = encoded
+	}
+	return nil
+}
+
+func resolveReference(ref *ResultReference, responses []Invocation) (interface{}, error) {
+	for _, response := range responses {
+		if response.CallID != ref.ResultOf {
+			continue
+		}
+		if response.Name != ref.Name {
+			// The call failed, or produced an implicit response first
+			continue
+		}
+
+		// Round-trip through JSON so the pointer walks plain maps and slices
+		data, err := json.Marshal(response.Args)
+		if err != nil {
+			return nil, err
+		}
+		var doc interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+
+		value, ok := evaluatePointer(doc, ref.Path)
+		if !ok {
+			return nil, NewMethodError(ErrorInvalidResultReference, "path %s not found in %s response", ref.Path, ref.Name)
+		}
+		return value, nil
+	}
+	return nil, NewMethodError(ErrorInvalidResultReference, "no %s response for call %s", ref.Name, ref.ResultOf)
+}
+
+// evaluatePointer evaluates a JSON pointer with the JMAP "*" extension:
+// applied to an array, the rest of the path is evaluated on each item and
+// array results are flattened
+func evaluatePointer(doc interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return doc, true
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	token := strings.ReplaceAll(strings.ReplaceAll(tokens[0], "~1", "/"), "~0", "~")
+	rest := ""
+	if len(tokens) > 1 {
+		rest = "/" + strings.Join(tokens[1:], "/")
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[token]
+		if !ok {
+			return nil, false
+		}
+		return evaluatePointer(child, rest)
+
+	case []interface{}:
+		if token == "*" {
+			result := make([]interface{}, 0, len(node))
+			for _, item := range node {
+				value, ok := evaluatePointer(item, rest)
+				if !ok {
+					return nil, false
+				}
+				if list, isList := value.([]interface{}); isList {
+					result = append(result, list...)
+				} else {
+					result = append(result, value)
+				}
+			}
+			return result, true
+		}
+
+		var index int
+		if _, err := fmt.Sscanf(token, "%d", &index); err != nil || index < 0 || index >= len(node) {
+			return nil, false
+		}
+		return evaluatePointer(node[index], rest)
+	}
+
+	return nil, false
+}
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,255}$`)
+
+// ValidID reports whether s is a valid JMAP Id
+func ValidID(s string) bool {
+	return idPattern.MatchString(s)
+}
+
+// Session is the JMAP session resource (RFC 8620 section 2)
+type Session struct {
+	Capabilities    map[string]interface{} `json:"capabilities"`
+	Accounts        map[string]Account     `json:"accounts"`
+	PrimaryAccounts map[string]string      `json:"primaryAccounts"`
+	Username        string                 `json:"username"`
+	APIURL          string                 `json:"apiUrl"`
+	DownloadURL     string                 `json:"downloadUrl"`
+	UploadURL       string                 `json:"uploadUrl"`
+	EventSourceURL  string                 `json:"eventSourceUrl"`
+	State           string                 `json:"state"`
+}
+
+// Account is an account listed in the session resource
+type Account struct {
+	Name                string                 `json:"name"`
+	IsPersonal          bool                   `json:"isPersonal"`
+	IsReadOnly          bool                   `json:"isReadOnly"`
+	AccountCapabilities map[string]interface{} `json:"accountCapabilities"`
+}
+
+// CoreCapability advertises the server's limits
+type CoreCapability struct {
+	MaxSizeUpload         int64    `json:"maxSizeUpload"`
+	MaxConcurrentUpload   int      `json:"maxConcurrentUpload"`
+	MaxSizeRequest        int64    `json:"maxSizeRequest"`
+	MaxConcurrentRequests int      `json:"maxConcurrentRequests"`
+	MaxCallsInRequest     int      `json:"maxCallsInRequest"`
+	MaxObjectsInGet       int      `json:"maxObjectsInGet"`
+	MaxObjectsInSet       int      `json:"maxObjectsInSet"`
+	CollationAlgorithms   []string `json:"collationAlgorithms"`
+}
+
+// StateChange is pushed over the event source when data changes: account ID
+// to type name to new state
+type StateChange struct {
+	Type    string                       `json:"@type"`
+	Changed map[string]map[string]string `json:"changed"`
+}
@@ -0,0 +1,177 @@
+package jmap
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCallUnmarshalJSON(t *testing.T) {
+	var req Request
+	body := `{"using":["urn:ietf:params:jmap:core"],"methodCalls":[["Core/echo",{"hello":true},"c1"]]}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	call := req.MethodCalls[0]
+	if call.Name != "Core/echo" || call.CallID != "c1" || string(call.Args["hello"]) != "true" {
+		t.Errorf("decoded %+v", call)
+	}
+
+	for _, invalid := range []string{
+		`["Core/echo",{}]`,
+		`["Core/echo",{},"c1","extra"]`,
+		`["Core/echo",null,"c1"]`,
+		`["Core/echo",[],"c1"]`,
+		`[1,{},"c1"]`,
+		`{"name":"Core/echo"}`,
+	} {
+		var call Call
+		if err := json.Unmarshal([]byte(invalid), &call); err == nil {
+			t.Errorf("%s: decoded without error", invalid)
+		}
+	}
+}
+
+func TestInvocationMarshalJSON(t *testing.T) {
+	data, err := json.Marshal(Invocation{Name: "error", Args: &MethodError{Type: ErrorUnknownMethod}, CallID: "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `["error",{"type":"unknownMethod"},"c1"]`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
+
+func TestCallDecodeInvalidArguments(t *testing.T) {
+	call := Call{Args: map[string]json.RawMessage{"limit": json.RawMessage(`"ten"`)}}
+	var args struct {
+		Limit int `json:"limit"`
+	}
+	err := call.Decode(&args)
+	var methodErr *MethodError
+	if !errors.As(err, &methodErr) || methodErr.Type != ErrorInvalidArguments {
+		t.Errorf("got %v, want %s", err, ErrorInvalidArguments)
+	}
+}
+
+// queryResponses are the responses of an Email/query and an Email/get
+// call, as a client would chain them
+func queryResponses() []Invocation {
+	return []Invocation{
+		{Name: "Email/query", CallID: "q", Args: map[string]interface{}{"ids": []string{"e1", "e2"}}},
+		{Name: "Email/get", CallID: "g", Args: map[string]interface{}{
+			"list": []map[string]interface{}{
+				{"id": "e1", "threadId": "t1", "tags": []string{"a", "b"}},
+				{"id": "e2", "threadId": "t2", "tags": []string{"c"}},
+			},
+		}},
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	tests := []struct {
+		name string
+		ref  string
+		want interface{}
+	}{
+		{"whole list", `{"resultOf":"q","name":"Email/query","path":"/ids"}`, []interface{}{"e1", "e2"}},
+		{"list item", `{"resultOf":"q","name":"Email/query","path":"/ids/1"}`, "e2"},
+		{"property of each item", `{"resultOf":"g","name":"Email/get","path":"/list/*/threadId"}`, []interface{}{"t1", "t2"}},
+		{"lists of each item flattened", `{"resultOf":"g","name":"Email/get","path":"/list/*/tags"}`, []interface{}{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := Call{Name: "Thread/get", Args: map[string]json.RawMessage{
+				"accountId": json.RawMessage(`"a1"`),
+				"#ids":      json.RawMessage(tt.ref),
+			}}
+			if err := call.ResolveReferences(queryResponses()); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := call.Args["#ids"]; ok {
+				t.Error("reference argument was kept")
+			}
+			var got interface{}
+			if err := json.Unmarshal(call.Args["ids"], &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ids = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveReferencesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args map[string]json.RawMessage
+		want string
+	}{
+		{
+			name: "unknown call",
+			args: map[string]json.RawMessage{"#ids": json.RawMessage(`{"resultOf":"x","name":"Email/query","path":"/ids"}`)},
+			want: ErrorInvalidResultReference,
+		},
+		{
+			// A failed call's response is named "error"
+			name: "response of another method",
+			args: map[string]json.RawMessage{"#ids": json.RawMessage(`{"resultOf":"q","name":"Email/get","path":"/ids"}`)},
+			want: ErrorInvalidResultReference,
+		},
+		{
+			name: "missing path",
+			args: map[string]json.RawMessage{"#ids": json.RawMessage(`{"resultOf":"q","name":"Email/query","path":"/ids/5"}`)},
+			want: ErrorInvalidResultReference,
+		},
+		{
+			name: "malformed reference",
+			args: map[string]json.RawMessage{"#ids": json.RawMessage(`"q"`)},
+			want: ErrorInvalidResultReference,
+		},
+		{
+			name: "argument given both ways",
+			args: map[string]json.RawMessage{
+				"ids":  json.RawMessage(`["e1"]`),
+				"#ids": json.RawMessage(`{"resultOf":"q","name":"Email/query","path":"/ids"}`),
+			},
+			want: ErrorInvalidArguments,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := Call{Name: "Email/get", Args: tt.args}
+			err := call.ResolveReferences(queryResponses())
+			var methodErr *MethodError
+			if !errors.As(err, &methodErr) || methodErr.Type != tt.want {
+				t.Errorf("got %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluatePointerEscapes(t *testing.T) {
+	doc := map[string]interface{}{"a/b": map[string]interface{}{"c~d": 1.0}}
+	if got, ok := evaluatePointer(doc, "/a~1b/c~0d"); !ok || got != 1.0 {
+		t.Errorf("got %v, %v, want 1", got, ok)
+	}
+	if _, ok := evaluatePointer(doc, "a~1b"); ok {
+		t.Error("pointer without a leading slash was evaluated")
+	}
+}
+
+func TestValidID(t *testing.T) {
+	valid := []string{"abc", "A-Z_09", "7f3c1e2a-9b4d-4c1e-8a7f-0e2d3c4b5a69"}
+	invalid := []string{"", "has space", "<msg@example.com>", "a/b", string(make([]byte, 256))}
+	for _, id := range valid {
+		if !ValidID(id) {
+			t.Errorf("ValidID(%q) = false, want true", id)
+		}
+	}
+	for _, id := range invalid {
+		if ValidID(id) {
+			t.Errorf("ValidID(%q) = true, want false", id)
+		}
+	}
+}
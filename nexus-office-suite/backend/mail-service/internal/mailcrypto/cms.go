@@ -0,0 +1,615 @@
+// Package mailcrypto implements S/MIME (CMS, RFC 5652) and OpenPGP
+// (RFC 3156) signing and encryption of mail. Only the profiles mail clients
+// use are covered: SHA-256 signatures with RSA or ECDSA keys, and RSA key
+// transport (OAEP, and PKCS #1 v1.5 when reading) with AES-CBC content
+// encryption.
+package mailcrypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var (
+	// ErrNotForRecipient is returned when a message was not encrypted to the given certificate
+	ErrNotForRecipient = errors.New("message is not encrypted for this certificate")
+	// ErrSignatureInvalid is returned when a signature does not match the content
+	ErrSignatureInvalid = errors.New("signature does not match content")
+	// ErrUnsupported is returned for algorithms and structures outside the supported profile
+	ErrUnsupported = errors.New("unsupported CMS algorithm or structure")
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidAttrContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSAESOAEP       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidPSpecified      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 9}
+	oidSHA1WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidECPublicKey     = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+	oidAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type encapsulatedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []keyTransRecipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	RID                    issuerAndSerialNumber
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// rsaOAEPParams are the RSAES-OAEP-params of RFC 8017. Absent fields mean
+// SHA-1, MGF1 with SHA-1 and an empty label.
+type rsaOAEPParams struct {
+	HashAlgorithm    pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MaskGenAlgorithm pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	PSourceAlgorithm pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:2"`
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// Signer identifies who signed a CMS message
+type Signer struct {
+	Certificate *x509.Certificate
+	SigningTime time.Time // zero if the signer did not say
+}
+
+// Sign creates a detached CMS SignedData signature (smime.p7s) over content,
+// including cert and any intermediates so recipients can build the chain
+func Sign(content []byte, cert *x509.Certificate, key crypto.Signer, intermediates []*x509.Certificate) ([]byte, error) {
+	sigAlg, err := signatureAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := crypto.SHA256.New()
+	digest.Write(content)
+
+	signingTime, err := asn1.Marshal(time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	contentType, _ := asn1.Marshal(oidData)
+	messageDigest, _ := asn1.Marshal(digest.Sum(nil))
+
+	attrs := []attribute{
+		{Type: oidAttrContentType, Values: []asn1.RawValue{{FullBytes: contentType}}},
+		{Type: oidAttrMessageDigest, Values: []asn1.RawValue{{FullBytes: messageDigest}}},
+		{Type: oidAttrSigningTime, Values: []asn1.RawValue{{FullBytes: signingTime}}},
+	}
+	// The signature covers the attributes encoded as a SET OF; in the
+	// SignerInfo the same contents carry an implicit [0] tag instead
+	wrapped, err := asn1.Marshal(struct {
+		A []attribute `asn1:"set"`
+	}{attrs})
+	if err != nil {
+		return nil, err
+	}
+	var seq, set asn1.RawValue
+	if _, err := asn1.Unmarshal(wrapped, &seq); err != nil {
+		return nil, err
+	}
+	if _, err := asn1.Unmarshal(seq.Bytes, &set); err != nil {
+		return nil, err
+	}
+
+	hashed := crypto.SHA256.New()
+	hashed.Write(set.FullBytes)
+	signature, err := key.Sign(rand.Reader, hashed.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	var certBytes []byte
+	for _, c := range append([]*x509.Certificate{cert}, intermediates...) {
+		certBytes = append(certBytes, c.Raw...)
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}},
+		EncapContentInfo: encapsulatedContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certBytes},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: set.Bytes},
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+		}},
+	}
+
+	return wrapContentInfo(oidSignedData, sd)
+}
+
+// Verify checks a CMS SignedData signature. For a detached signature,
+// content is the signed data; for an opaque (encapsulated) one it is nil
+// and the encapsulated content is returned. The signer's certificate chain
+// is verified against roots (system roots if nil) and the result reported
+// in chainErr, so that callers can tell a forged signature (err) from an
+// untrusted one. The chain is checked as of at, or now if it is zero; the
+// signing time in the message is reported but never trusted, since the
+// signer chooses it.
+func Verify(der, content []byte, roots *x509.CertPool, at time.Time) (signer *Signer, signedContent []byte, chainErr error, err error) {
+	var sd signedData
+	if err := unwrapContentInfo(der, oidSignedData, &sd); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if content == nil {
+		var octets []byte
+		if len(sd.EncapContentInfo.Content.Bytes) == 0 {
+			return nil, nil, nil, errors.New("signature is detached but no content was given")
+		}
+		if _, err := asn1.Unmarshal(sd.EncapContentInfo.Content.Bytes, &octets); err != nil {
+			// Some producers use a constructed OCTET STRING; fall back to the raw contents
+			octets = sd.EncapContentInfo.Content.Bytes
+		}
+		content = octets
+	}
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse certificates: %w", err)
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, nil, nil, errors.New("message has no signers")
+	}
+
+	// Mail has one signer; the first is the one we report
+	si := sd.SignerInfos[0]
+	var cert *x509.Certificate
+	for _, c := range certs {
+		if c.SerialNumber.Cmp(si.SID.SerialNumber) == 0 && bytes.Equal(c.RawIssuer, si.SID.Issuer.FullBytes) {
+			cert = c
+			break
+		}
+	}
+	if cert == nil {
+		return nil, nil, nil, errors.New("signer certificate not included in message")
+	}
+
+	hash, err := hashForOID(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	h := hash.New()
+	h.Write(content)
+	contentDigest := h.Sum(nil)
+
+	signer = &Signer{Certificate: cert}
+	signedBytes := content
+	if len(si.SignedAttrs.FullBytes) > 0 {
+		var attrs []attribute
+		if _, err := asn1.UnmarshalWithParams(si.SignedAttrs.FullBytes, &attrs, "set,tag:0"); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to parse signed attributes: %w", err)
+		}
+
+		var digestOK bool
+		for _, attr := range attrs {
+			if len(attr.Values) == 0 {
+				continue
+			}
+			switch {
+			case attr.Type.Equal(oidAttrMessageDigest):
+				var digest []byte
+				if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &digest); err == nil {
+					digestOK = bytes.Equal(digest, contentDigest)
+				}
+			case attr.Type.Equal(oidAttrSigningTime):
+				var t time.Time
+				if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &t); err == nil {
+					signer.SigningTime = t
+				}
+			}
+		}
+		if !digestOK {
+			return signer, nil, nil, ErrSignatureInvalid
+		}
+
+		// The signature is over the attributes with a SET tag
+		signedBytes = append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+	}
+
+	if err := checkSignature(cert, si.SignatureAlgorithm.Algorithm, hash, signedBytes, si.Signature); err != nil {
+		return signer, nil, nil, ErrSignatureInvalid
+	}
+
+	if at.IsZero() {
+		at = time.Now()
+	}
+	pool := x509.NewCertPool()
+	for _, c := range certs {
+		if c != cert {
+			pool.AddCert(c)
+		}
+	}
+	_, chainErr = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: pool,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection, x509.ExtKeyUsageAny},
+	})
+
+	return signer, content, chainErr, nil
+}
+
+// Encrypt creates a CMS EnvelopedData (smime.p7m) of content for the given
+// recipients, which must have RSA keys. Content is encrypted with AES-256-CBC
+// and the content key with RSA-OAEP using SHA-256.
+func Encrypt(content []byte, recipients []*x509.Certificate) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padded := pkcs7Pad(content, aes.BlockSize)
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+
+	infos := make([]keyTransRecipientInfo, 0, len(recipients))
+	for _, cert := range recipients {
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: recipient %s does not have an RSA key", ErrUnsupported, cert.Subject.CommonName)
+		}
+		encryptedKey, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, pub, key, nil)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, keyTransRecipientInfo{
+			Version:                0,
+			RID:                    issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber},
+			KeyEncryptionAlgorithm: oaepAlgorithm,
+			EncryptedKey:           encryptedKey,
+		})
+	}
+
+	ivParam, _ := asn1.Marshal(iv)
+	ed := envelopedData{
+		Version:        0,
+		RecipientInfos: infos,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+			EncryptedContent:           asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: encrypted},
+		},
+	}
+
+	return wrapContentInfo(oidEnvelopedData, ed)
+}
+
+// Decrypt opens a CMS EnvelopedData with the recipient's certificate and
+// RSA private key. Content keys may be transported with RSA-OAEP or, as most
+// mail clients still do, PKCS #1 v1.5; content encrypted with anything but
+// AES is refused.
+func Decrypt(der []byte, cert *x509.Certificate, key crypto.Decrypter) ([]byte, error) {
+	var ed envelopedData
+	if err := unwrapContentInfo(der, oidEnvelopedData, &ed); err != nil {
+		return nil, err
+	}
+
+	var info *keyTransRecipientInfo
+	for i := range ed.RecipientInfos {
+		ri := &ed.RecipientInfos[i]
+		if ri.RID.SerialNumber != nil && ri.RID.SerialNumber.Cmp(cert.SerialNumber) == 0 && bytes.Equal(ri.RID.Issuer.FullBytes, cert.RawIssuer) {
+			info = ri
+			break
+		}
+	}
+	if info == nil {
+		return nil, ErrNotForRecipient
+	}
+	var opts crypto.DecrypterOpts
+	switch alg := info.KeyEncryptionAlgorithm; {
+	case alg.Algorithm.Equal(oidRSAEncryption):
+	case alg.Algorithm.Equal(oidRSAESOAEP):
+		oaep, err := parseOAEPParams(alg.Parameters.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		opts = oaep
+	default:
+		return nil, ErrUnsupported
+	}
+
+	contentKey, err := key.Decrypt(rand.Reader, info.EncryptedKey, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt content key: %w", err)
+	}
+
+	eci := ed.EncryptedContentInfo
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("failed to parse content encryption parameters: %w", err)
+	}
+
+	var block cipher.Block
+	switch alg := eci.ContentEncryptionAlgorithm.Algorithm; {
+	case alg.Equal(oidAES128CBC), alg.Equal(oidAES256CBC):
+		block, err = aes.NewCipher(contentKey)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := eci.EncryptedContent.Bytes
+	if eci.EncryptedContent.IsCompound {
+		// Constructed OCTET STRING: concatenate the primitive segments
+		encrypted, err = joinOctetStrings(eci.EncryptedContent.Bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(iv) != block.BlockSize() || len(encrypted)%block.BlockSize() != 0 || len(encrypted) == 0 {
+		return nil, errors.New("malformed encrypted content")
+	}
+
+	plain := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, encrypted)
+	return pkcs7Unpad(plain, block.BlockSize())
+}
+
+// oaepAlgorithm identifies RSA-OAEP with SHA-256 and MGF1 with SHA-256, the
+// key transport Encrypt uses
+var oaepAlgorithm = func() pkix.AlgorithmIdentifier {
+	sha256 := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	mgfParams, _ := asn1.Marshal(sha256)
+	params, _ := asn1.Marshal(rsaOAEPParams{
+		HashAlgorithm:    sha256,
+		MaskGenAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfParams}},
+	})
+	return pkix.AlgorithmIdentifier{Algorithm: oidRSAESOAEP, Parameters: asn1.RawValue{FullBytes: params}}
+}()
+
+// parseOAEPParams reads RSAES-OAEP-params into decryption options
+func parseOAEPParams(der []byte) (*rsa.OAEPOptions, error) {
+	var params rsaOAEPParams
+	if len(der) > 0 {
+		if _, err := asn1.Unmarshal(der, &params); err != nil {
+			return nil, fmt.Errorf("failed to parse RSA-OAEP parameters: %w", err)
+		}
+	}
+
+	opts := &rsa.OAEPOptions{Hash: crypto.SHA1, MGFHash: crypto.SHA1}
+	if len(params.HashAlgorithm.Algorithm) > 0 {
+		hash, err := hashForOID(params.HashAlgorithm.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		opts.Hash = hash
+	}
+	if mgf := params.MaskGenAlgorithm; len(mgf.Algorithm) > 0 {
+		if !mgf.Algorithm.Equal(oidMGF1) {
+			return nil, fmt.Errorf("%w: mask generation function %s", ErrUnsupported, mgf.Algorithm)
+		}
+		var mgfHash pkix.AlgorithmIdentifier
+		if _, err := asn1.Unmarshal(mgf.Parameters.FullBytes, &mgfHash); err != nil {
+			return nil, fmt.Errorf("failed to parse RSA-OAEP parameters: %w", err)
+		}
+		hash, err := hashForOID(mgfHash.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		opts.MGFHash = hash
+	}
+	if source := params.PSourceAlgorithm; len(source.Algorithm) > 0 {
+		if !source.Algorithm.Equal(oidPSpecified) {
+			return nil, fmt.Errorf("%w: OAEP label source %s", ErrUnsupported, source.Algorithm)
+		}
+		if _, err := asn1.Unmarshal(source.Parameters.FullBytes, &opts.Label); err != nil {
+			return nil, fmt.Errorf("failed to parse RSA-OAEP parameters: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+func wrapContentInfo(contentType asn1.ObjectIdentifier, content interface{}) ([]byte, error) {
+	inner, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: contentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+}
+
+func unwrapContentInfo(der []byte, want asn1.ObjectIdentifier, out interface{}) error {
+	var ci contentInfo
+	rest, err := asn1.Unmarshal(der, &ci)
+	if err != nil {
+		return fmt.Errorf("failed to parse CMS message: %w", err)
+	}
+	if len(bytes.TrimRight(rest, "\x00")) > 0 {
+		return errors.New("trailing data after CMS message")
+	}
+	if !ci.ContentType.Equal(want) {
+		return fmt.Errorf("%w: content type %s", ErrUnsupported, ci.ContentType)
+	}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, out); err != nil {
+		return fmt.Errorf("failed to parse CMS content: %w", err)
+	}
+	return nil
+}
+
+func signatureAlgorithm(key crypto.Signer) (pkix.AlgorithmIdentifier, error) {
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidSHA256WithRSA, Parameters: asn1.NullRawValue}, nil
+	case *ecdsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, nil
+	default:
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("%w: signing key type %T", ErrUnsupported, key.Public())
+	}
+}
+
+func hashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	case oid.Equal(oidSHA1):
+		return crypto.SHA1, nil
+	default:
+		return 0, fmt.Errorf("%w: digest %s", ErrUnsupported, oid)
+	}
+}
+
+// checkSignature verifies sig over data with the certificate's public key.
+// Signer infos name either a combined algorithm (sha256WithRSA) or just the
+// key type (rsaEncryption), so the hash comes from the digest algorithm.
+func checkSignature(cert *x509.Certificate, alg asn1.ObjectIdentifier, hash crypto.Hash, data, sig []byte) error {
+	h := hash.New()
+	h.Write(data)
+	hashed := h.Sum(nil)
+
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		switch {
+		case alg.Equal(oidRSAEncryption), alg.Equal(oidSHA1WithRSA), alg.Equal(oidSHA256WithRSA),
+			alg.Equal(oidSHA384WithRSA), alg.Equal(oidSHA512WithRSA):
+			return rsa.VerifyPKCS1v15(pub, hash, hashed, sig)
+		}
+	case *ecdsa.PublicKey:
+		switch {
+		case alg.Equal(oidECPublicKey), alg.Equal(oidECDSAWithSHA256), alg.Equal(oidECDSAWithSHA384),
+			alg.Equal(oidECDSAWithSHA512):
+			if ecdsa.VerifyASN1(pub, hashed, sig) {
+				return nil
+			}
+			return ErrSignatureInvalid
+		}
+	}
+	return fmt.Errorf("%w: signature algorithm %s", ErrUnsupported, alg)
+}
+
+func joinOctetStrings(der []byte) ([]byte, error) {
+	var out []byte
+	for len(der) > 0 {
+		var segment asn1.RawValue
+		rest, err := asn1.Unmarshal(der, &segment)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, segment.Bytes...)
+		der = rest
+	}
+	return out, nil
+}
+
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	n := blockSize - len(data)%blockSize
+	return append(append([]byte{}, data...), bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+func pkcs7Unpad(data []byte, blockSize int) ([]byte, error) {
+	n := int(data[len(data)-1])
+	if n == 0 || n > blockSize || n > len(data) {
+		return nil, errors.New("invalid padding")
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, errors.New("invalid padding")
+		}
+	}
+	return data[:len(data)-n], nil
+}
@@ -0,0 +1,304 @@
+package mailcrypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCA issues S/MIME certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-10 * 24 * time.Hour),
+		NotAfter:              time.Now().Add(10 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+var testSerial int64 = 1
+
+// issue creates a certificate for address valid from notBefore to notAfter
+func (ca *testCA) issue(t *testing.T, address string, key crypto.Signer, notBefore, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+
+	testSerial++
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(testSerial),
+		Subject:        pkix.Name{CommonName: address},
+		EmailAddresses: []string{address},
+		NotBefore:      notBefore,
+		NotAfter:       notAfter,
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSignVerify(t *testing.T) {
+	ca := newTestCA(t)
+	now := time.Now()
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, key := range map[string]crypto.Signer{"rsa": newRSAKey(t), "ecdsa": ecKey} {
+		t.Run(name, func(t *testing.T) {
+			cert := ca.issue(t, "alice@example.com", key, now.Add(-time.Hour), now.Add(time.Hour))
+			content := []byte("Content-Type: text/plain\r\n\r\nHello\r\n")
+
+			der, err := Sign(content, cert, key, nil)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			signer, signed, chainErr, err := Verify(der, content, ca.pool, time.Time{})
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if chainErr != nil {
+				t.Errorf("Verify chain: %v", chainErr)
+			}
+			if !signer.Certificate.Equal(cert) {
+				t.Errorf("signer is %s, want %s", signer.Certificate.Subject, cert.Subject)
+			}
+			if signer.SigningTime.IsZero() {
+				t.Error("signing time not reported")
+			}
+			if !bytes.Equal(signed, content) {
+				t.Errorf("signed content is %q, want %q", signed, content)
+			}
+		})
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	ca := newTestCA(t)
+	key := newRSAKey(t)
+	cert := ca.issue(t, "alice@example.com", key, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	content := []byte("Pay Bob 10 EUR")
+	der, err := Sign(content, cert, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, _, err = Verify(der, []byte("Pay Bob 1000 EUR"), ca.pool, time.Time{})
+	if !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("Verify of altered content returned %v, want %v", err, ErrSignatureInvalid)
+	}
+
+	// A signature by another key with the same certificate
+	forged, err := Sign(content, cert, newRSAKey(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = Verify(forged, content, ca.pool, time.Time{})
+	if !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("Verify of a forged signature returned %v, want %v", err, ErrSignatureInvalid)
+	}
+}
+
+func TestVerifyExpiredCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	key := newRSAKey(t)
+	now := time.Now()
+	cert := ca.issue(t, "alice@example.com", key, now.Add(-5*24*time.Hour), now.Add(-24*time.Hour))
+
+	content := []byte("Hello")
+	der, err := Sign(content, cert, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Checked now, the certificate has expired
+	_, _, chainErr, err := Verify(der, content, ca.pool, time.Time{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	var invalid x509.CertificateInvalidError
+	if !errors.As(chainErr, &invalid) || invalid.Reason != x509.Expired {
+		t.Errorf("Verify chain returned %v, want an expired certificate error", chainErr)
+	}
+
+	// Checked as of a receive time in its validity, it is fine
+	_, _, chainErr, err = Verify(der, content, ca.pool, now.Add(-2*24*time.Hour))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if chainErr != nil {
+		t.Errorf("Verify chain as of receive time: %v", chainErr)
+	}
+}
+
+func TestVerifyUntrusted(t *testing.T) {
+	ca := newTestCA(t)
+	key := newRSAKey(t)
+	cert := ca.issue(t, "alice@example.com", key, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	der, err := Sign([]byte("Hello"), cert, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, chainErr, err := Verify(der, []byte("Hello"), newTestCA(t).pool, time.Time{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if chainErr == nil {
+		t.Error("Verify chain accepted a certificate from an unknown CA")
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	ca := newTestCA(t)
+	now := time.Now()
+	aliceKey, bobKey := newRSAKey(t), newRSAKey(t)
+	alice := ca.issue(t, "alice@example.com", aliceKey, now.Add(-time.Hour), now.Add(time.Hour))
+	bob := ca.issue(t, "bob@example.com", bobKey, now.Add(-time.Hour), now.Add(time.Hour))
+
+	content := []byte("Content-Type: text/plain\r\n\r\nSecret\r\n")
+	der, err := Encrypt(content, []*x509.Certificate{alice, bob})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	var ed envelopedData
+	if err := unwrapContentInfo(der, oidEnvelopedData, &ed); err != nil {
+		t.Fatal(err)
+	}
+	for _, ri := range ed.RecipientInfos {
+		if !ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAESOAEP) {
+			t.Errorf("content key transported with %s, want RSA-OAEP", ri.KeyEncryptionAlgorithm.Algorithm)
+		}
+	}
+	if alg := ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm; !alg.Equal(oidAES256CBC) {
+		t.Errorf("content encrypted with %s, want AES-256-CBC", alg)
+	}
+
+	for name, recipient := range map[string]struct {
+		cert *x509.Certificate
+		key  crypto.Decrypter
+	}{"alice": {alice, aliceKey}, "bob": {bob, bobKey}} {
+		plain, err := Decrypt(der, recipient.cert, recipient.key)
+		if err != nil {
+			t.Fatalf("Decrypt for %s: %v", name, err)
+		}
+		if !bytes.Equal(plain, content) {
+			t.Errorf("Decrypt for %s returned %q, want %q", name, plain, content)
+		}
+	}
+
+	carolKey := newRSAKey(t)
+	carol := ca.issue(t, "carol@example.com", carolKey, now.Add(-time.Hour), now.Add(time.Hour))
+	if _, err := Decrypt(der, carol, carolKey); !errors.Is(err, ErrNotForRecipient) {
+		t.Errorf("Decrypt for a non-recipient returned %v, want %v", err, ErrNotForRecipient)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	ca := newTestCA(t)
+	key := newRSAKey(t)
+	cert := ca.issue(t, "alice@example.com", key, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	der, err := Encrypt([]byte("Secret"), []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ed envelopedData
+	if err := unwrapContentInfo(der, oidEnvelopedData, &ed); err != nil {
+		t.Fatal(err)
+	}
+	ed.RecipientInfos[0].EncryptedKey[10] ^= 0xff
+	tampered, err := wrapContentInfo(oidEnvelopedData, ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decrypt(tampered, cert, key); err == nil {
+		t.Error("Decrypt accepted an altered content key")
+	}
+}
+
+func TestDecryptRejectsTripleDES(t *testing.T) {
+	ca := newTestCA(t)
+	key := newRSAKey(t)
+	cert := ca.issue(t, "alice@example.com", key, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	der, err := Encrypt([]byte("Secret"), []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ed envelopedData
+	if err := unwrapContentInfo(der, oidEnvelopedData, &ed); err != nil {
+		t.Fatal(err)
+	}
+	ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	desEDE3, err := wrapContentInfo(oidEnvelopedData, ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decrypt(desEDE3, cert, key); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Decrypt of DES-EDE3-CBC content returned %v, want %v", err, ErrUnsupported)
+	}
+}
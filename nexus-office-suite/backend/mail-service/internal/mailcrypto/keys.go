@@ -0,0 +1,222 @@
+package mailcrypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// SMIMEKey is an S/MIME certificate with its chain and, for the user's own
+// certificates, the private key
+type SMIMEKey struct {
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate // intermediates, sent along with signatures
+	PrivateKey  crypto.Signer
+}
+
+// ParseSMIMEKey reads a certificate from PEM (certificates and an optional
+// private key in any order) or from a PKCS#12 file, raw or base64, unlocked
+// with password. The first certificate matching the private key, or else
+// the first certificate, is the user's; the others form the chain.
+func ParseSMIMEKey(data []byte, password string) (*SMIMEKey, error) {
+	var blocks []*pem.Block
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		for rest := data; ; {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			blocks = append(blocks, block)
+		}
+	} else {
+		der := data
+		if decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), "")); err == nil {
+			der = decoded
+		}
+		var err error
+		blocks, err = pkcs12.ToPEM(der, password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PKCS#12 file: %w", err)
+		}
+	}
+
+	key := &SMIMEKey{}
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse certificate: %w", err)
+			}
+			certs = append(certs, cert)
+		case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+			signer, err := ParsePrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			key.PrivateKey = signer
+		}
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+
+	owner := 0
+	if key.PrivateKey != nil {
+		owner = -1
+		for i, cert := range certs {
+			if publicKeysEqual(cert.PublicKey, key.PrivateKey.Public()) {
+				owner = i
+				break
+			}
+		}
+		if owner < 0 {
+			return nil, errors.New("private key does not match any certificate")
+		}
+	}
+	key.Certificate = certs[owner]
+	for i, cert := range certs {
+		if i != owner {
+			key.Chain = append(key.Chain, cert)
+		}
+	}
+
+	return key, nil
+}
+
+// ParseCertificates reads a PEM certificate chain, user's certificate first
+func ParseCertificates(data string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := []byte(data); ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certs, nil
+}
+
+// EncodeCertificates writes certificates as a PEM chain
+func EncodeCertificates(certs ...*x509.Certificate) string {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.String()
+}
+
+// MarshalPrivateKey encodes a private key as PKCS#8
+func MarshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+// ParsePrivateKey reads a PKCS#8, PKCS#1 or SEC 1 private key
+func ParsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("%w: private key type %T", ErrUnsupported, key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("failed to parse private key")
+}
+
+// CertificateAddresses returns the email addresses a certificate is issued
+// for, from its subject alternative names or subject
+func CertificateAddresses(cert *x509.Certificate) []string {
+	var addresses []string
+	for _, addr := range cert.EmailAddresses {
+		addresses = append(addresses, strings.ToLower(addr))
+	}
+	if len(addresses) == 0 {
+		for _, name := range cert.Subject.Names {
+			if addr, ok := name.Value.(string); ok && name.Type.Equal(oidEmailAddress) {
+				addresses = append(addresses, strings.ToLower(addr))
+			}
+		}
+	}
+	return addresses
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of a certificate
+// in upper-case hex
+func CertificateFingerprint(cert *x509.Certificate) string {
+	return fmt.Sprintf("%X", sha256.Sum256(cert.Raw))
+}
+
+// SealPrivateKey encrypts a private key for storage with AES-256-GCM under
+// the key encryption key
+func SealPrivateKey(kek, key []byte) ([]byte, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, key, nil), nil
+}
+
+// OpenPrivateKey decrypts a key sealed by SealPrivateKey
+func OpenPrivateKey(kek, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed key is too short")
+	}
+	key, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("failed to unseal private key; was the key encryption key changed?")
+	}
+	return key, nil
+}
+
+func newGCM(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
@@ -0,0 +1,361 @@
+package mailcrypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"strings"
+)
+
+// Protocols
+const (
+	ProtocolSMIME = "smime"
+	ProtocolPGP   = "pgp"
+)
+
+// Kinds of secured MIME structures
+const (
+	KindSigned    = "signed"    // multipart/signed, or opaque S/MIME signed-data
+	KindEncrypted = "encrypted" // S/MIME enveloped-data or PGP/MIME encrypted
+)
+
+// ErrNotSecure is returned by Parse for messages that are neither signed nor encrypted
+var ErrNotSecure = errors.New("message is not signed or encrypted")
+
+// Secured is the security layer of a parsed message
+type Secured struct {
+	Protocol string
+	Kind     string
+	// Entity is the signed MIME entity, for detached signatures
+	Entity []byte
+	// Payload is the signature, the CMS structure or the armored PGP message
+	Payload []byte
+	// Opaque is set for S/MIME signed-data, where the content is inside Payload
+	Opaque bool
+}
+
+// SplitMessage separates a message into its transport headers and the MIME
+// entity (the Content-* headers and body) that gets signed or encrypted.
+// Line endings are canonicalized to CRLF.
+func SplitMessage(raw []byte) (header, entity []byte, err error) {
+	raw = Canonicalize(raw)
+
+	end := bytes.Index(raw, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, nil, errors.New("message has no body")
+	}
+	body := raw[end+4:]
+
+	var outer, inner bytes.Buffer
+	for _, field := range headerFields(raw[:end+2]) {
+		name := strings.ToLower(strings.TrimSpace(string(field[:bytes.IndexByte(field, ':')])))
+		switch {
+		case name == "mime-version":
+			// Re-added by the envelope
+		case strings.HasPrefix(name, "content-"):
+			inner.Write(field)
+		default:
+			outer.Write(field)
+		}
+	}
+	if inner.Len() == 0 {
+		inner.WriteString("Content-Type: text/plain; charset=us-ascii\r\n")
+	}
+	inner.WriteString("\r\n")
+	inner.Write(body)
+
+	return outer.Bytes(), inner.Bytes(), nil
+}
+
+// Canonicalize converts bare LF line endings to CRLF
+func Canonicalize(raw []byte) []byte {
+	if !bytes.Contains(raw, []byte("\n")) || bytes.Count(raw, []byte("\n")) == bytes.Count(raw, []byte("\r\n")) {
+		return raw
+	}
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(raw, []byte("\n"), []byte("\r\n"))
+}
+
+// SignedMessage assembles a multipart/signed message (RFC 1847) from the
+// transport header, the signed entity and its signature
+func SignedMessage(header, entity, signature []byte, protocol string) []byte {
+	boundary := newBoundary()
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.Write(signedEntity(entity, signature, protocol, boundary))
+	return buf.Bytes()
+}
+
+// SignedEntity returns a multipart/signed MIME entity, for signing before
+// encrypting
+func SignedEntity(entity, signature []byte, protocol string) []byte {
+	return signedEntity(entity, signature, protocol, newBoundary())
+}
+
+func signedEntity(entity, signature []byte, protocol, boundary string) []byte {
+	var buf bytes.Buffer
+	switch protocol {
+	case ProtocolPGP:
+		fmt.Fprintf(&buf, "Content-Type: multipart/signed; boundary=\"%s\";\r\n\tmicalg=pgp-sha256; protocol=\"application/pgp-signature\"\r\n\r\n", boundary)
+	default:
+		fmt.Fprintf(&buf, "Content-Type: multipart/signed; boundary=\"%s\";\r\n\tmicalg=sha-256; protocol=\"application/pkcs7-signature\"\r\n\r\n", boundary)
+	}
+
+	buf.WriteString("This is a cryptographically signed message in MIME format.\r\n\r\n")
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	buf.Write(entity)
+	fmt.Fprintf(&buf, "\r\n--%s\r\n", boundary)
+
+	switch protocol {
+	case ProtocolPGP:
+		buf.WriteString("Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n")
+		buf.WriteString("Content-Description: OpenPGP digital signature\r\n")
+		buf.WriteString("Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n")
+		buf.Write(Canonicalize(signature))
+	default:
+		buf.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+		buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+		buf.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+		writeBase64(&buf, signature)
+	}
+	fmt.Fprintf(&buf, "\r\n--%s--\r\n", boundary)
+	return buf.Bytes()
+}
+
+// EncryptedMessage assembles an encrypted message from the transport header
+// and the encrypted payload: S/MIME enveloped-data (RFC 8551) or PGP/MIME
+// multipart/encrypted (RFC 3156)
+func EncryptedMessage(header, payload []byte, protocol string) []byte {
+	var buf bytes.Buffer
+	buf.Write(header)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	switch protocol {
+	case ProtocolPGP:
+		boundary := newBoundary()
+		fmt.Fprintf(&buf, "Content-Type: multipart/encrypted; boundary=\"%s\";\r\n\tprotocol=\"application/pgp-encrypted\"\r\n\r\n", boundary)
+		buf.WriteString("This is an OpenPGP/MIME encrypted message (RFC 3156).\r\n\r\n")
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		buf.WriteString("Content-Type: application/pgp-encrypted\r\nContent-Description: PGP/MIME version identification\r\n\r\nVersion: 1\r\n\r\n")
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		buf.WriteString("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n")
+		buf.WriteString("Content-Description: OpenPGP encrypted message\r\n")
+		buf.WriteString("Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n")
+		buf.Write(Canonicalize(payload))
+		fmt.Fprintf(&buf, "\r\n--%s--\r\n", boundary)
+	default:
+		buf.WriteString("Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"\r\n")
+		buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+		buf.WriteString("Content-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n")
+		writeBase64(&buf, payload)
+	}
+	return buf.Bytes()
+}
+
+// Parse finds the security layer of a message or MIME entity. It returns
+// ErrNotSecure for plain messages.
+func Parse(raw []byte) (*Secured, error) {
+	raw = Canonicalize(raw)
+
+	end := bytes.Index(raw, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, ErrNotSecure
+	}
+	header := textproto.MIMEHeader{}
+	for _, field := range headerFields(raw[:end+2]) {
+		colon := bytes.IndexByte(field, ':')
+		name := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(string(field[:colon])))
+		header.Add(name, strings.TrimSpace(unfold(string(field[colon+1:]))))
+	}
+	body := raw[end+4:]
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return nil, ErrNotSecure
+	}
+	protocol := strings.ToLower(params["protocol"])
+
+	switch mediaType {
+	case "multipart/signed":
+		parts, err := splitParts(body, params["boundary"])
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) != 2 {
+			return nil, errors.New("multipart/signed must have two parts")
+		}
+		sig, err := decodePart(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		secured := &Secured{Kind: KindSigned, Entity: parts[0], Payload: sig}
+		switch protocol {
+		case "application/pkcs7-signature", "application/x-pkcs7-signature":
+			secured.Protocol = ProtocolSMIME
+		case "application/pgp-signature":
+			secured.Protocol = ProtocolPGP
+		default:
+			return nil, fmt.Errorf("%w: signature protocol %q", ErrUnsupported, protocol)
+		}
+		return secured, nil
+
+	case "multipart/encrypted":
+		if protocol != "application/pgp-encrypted" {
+			return nil, fmt.Errorf("%w: encryption protocol %q", ErrUnsupported, protocol)
+		}
+		parts, err := splitParts(body, params["boundary"])
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) != 2 {
+			return nil, errors.New("multipart/encrypted must have two parts")
+		}
+		payload, err := decodePart(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Secured{Protocol: ProtocolPGP, Kind: KindEncrypted, Payload: payload}, nil
+
+	case "application/pkcs7-mime", "application/x-pkcs7-mime":
+		payload, err := decodeBody(header.Get("Content-Transfer-Encoding"), body)
+		if err != nil {
+			return nil, err
+		}
+		secured := &Secured{Protocol: ProtocolSMIME, Payload: payload}
+		switch strings.ToLower(params["smime-type"]) {
+		case "signed-data":
+			secured.Kind, secured.Opaque = KindSigned, true
+		case "enveloped-data", "":
+			// smime-type is optional; older clients only name the file
+			secured.Kind = KindEncrypted
+		default:
+			return nil, fmt.Errorf("%w: smime-type %q", ErrUnsupported, params["smime-type"])
+		}
+		return secured, nil
+	}
+
+	return nil, ErrNotSecure
+}
+
+// headerFields splits a header block into fields, keeping folded lines
+// with their field and the trailing CRLF
+func headerFields(block []byte) [][]byte {
+	var fields [][]byte
+	for _, line := range bytes.SplitAfter(block, []byte("\r\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] = append(fields[len(fields)-1], line...)
+			continue
+		}
+		if bytes.IndexByte(line, ':') < 0 {
+			continue
+		}
+		fields = append(fields, append([]byte{}, line...))
+	}
+	return fields
+}
+
+func unfold(value string) string {
+	return strings.NewReplacer("\r\n", "", "\n", "").Replace(value)
+}
+
+// splitParts returns the raw bytes of each body part of a multipart body.
+// Signed parts must be verified over exactly these bytes, so the parts are
+// cut out rather than parsed.
+func splitParts(body []byte, boundary string) ([][]byte, error) {
+	if boundary == "" {
+		return nil, errors.New("multipart body without boundary")
+	}
+	delimiter := []byte("\r\n--" + boundary)
+
+	// The first delimiter may start the body, without a preceding CRLF
+	body = append([]byte("\r\n"), body...)
+
+	var parts [][]byte
+	start := -1
+	for offset := 0; ; {
+		i := bytes.Index(body[offset:], delimiter)
+		if i < 0 {
+			return nil, errors.New("multipart body is not terminated")
+		}
+		i += offset
+		after := body[i+len(delimiter):]
+		if start >= 0 {
+			parts = append(parts, body[start:i])
+		}
+		if bytes.HasPrefix(after, []byte("--")) {
+			return parts, nil
+		}
+		// Skip transport padding up to the end of the delimiter line
+		eol := bytes.Index(after, []byte("\r\n"))
+		if eol < 0 {
+			return nil, errors.New("multipart body is not terminated")
+		}
+		start = i + len(delimiter) + eol + 2
+		offset = start
+	}
+}
+
+// decodePart returns the decoded body of a raw body part
+func decodePart(part []byte) ([]byte, error) {
+	end := bytes.Index(part, []byte("\r\n\r\n"))
+	if end < 0 {
+		if bytes.HasPrefix(part, []byte("\r\n")) {
+			return part[2:], nil
+		}
+		return nil, errors.New("malformed body part")
+	}
+	header := textproto.MIMEHeader{}
+	for _, field := range headerFields(part[:end+2]) {
+		colon := bytes.IndexByte(field, ':')
+		header.Add(strings.TrimSpace(string(field[:colon])), strings.TrimSpace(unfold(string(field[colon+1:]))))
+	}
+	return decodeBody(header.Get("Content-Transfer-Encoding"), part[end+4:])
+}
+
+func decodeBody(encoding string, body []byte) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		clean := bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+			return r
+		}, body)
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(len(clean)))
+		n, err := base64.StdEncoding.Decode(decoded, clean)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode body part: %w", err)
+		}
+		return decoded[:n], nil
+	case "", "7bit", "8bit", "binary":
+		return body, nil
+	default:
+		return nil, fmt.Errorf("%w: transfer encoding %q", ErrUnsupported, encoding)
+	}
+}
+
+func writeBase64(buf *bytes.Buffer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\r\n")
+}
+
+func newBoundary() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("----=_Part_%x", b)
+}
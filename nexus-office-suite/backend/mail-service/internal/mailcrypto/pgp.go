@@ -0,0 +1,218 @@
+package mailcrypto
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+var (
+	// ErrUnknownKey is returned when a signature was made by a key that is
+	// not in the keyring
+	ErrUnknownKey = errors.New("signed by an unknown key")
+	// ErrKeyExpired is returned when a signature or the key that made it
+	// had expired at the time it was checked against
+	ErrKeyExpired = errors.New("signature or signing key expired")
+)
+
+var pgpConfig = &packet.Config{DefaultHash: crypto.SHA256}
+
+// ReadPGPKey parses an ASCII-armored OpenPGP key. A protected private key is
+// decrypted with passphrase, so that it can be re-sealed for storage.
+func ReadPGPKey(armored, passphrase string) (*openpgp.Entity, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenPGP key: %w", err)
+	}
+	if len(entities) != 1 {
+		return nil, fmt.Errorf("expected one OpenPGP key, found %d", len(entities))
+	}
+	entity := entities[0]
+
+	keys := []*packet.PrivateKey{entity.PrivateKey}
+	for _, sub := range entity.Subkeys {
+		keys = append(keys, sub.PrivateKey)
+	}
+	for _, key := range keys {
+		if key != nil && key.Encrypted {
+			if err := key.Decrypt([]byte(passphrase)); err != nil {
+				return nil, errors.New("wrong passphrase for OpenPGP key")
+			}
+		}
+	}
+
+	return entity, nil
+}
+
+// ArmorPGPPublicKey returns the armored public part of a key
+func ArmorPGPPublicKey(entity *openpgp.Entity) (string, error) {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := entity.Serialize(w); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// SerializePGPPrivateKey returns the unprotected binary private key
+func SerializePGPPrivateKey(entity *openpgp.Entity) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := entity.SerializePrivate(&buf, pgpConfig); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ParsePGPPrivateKey reads a key written by SerializePGPPrivateKey
+func ParsePGPPrivateKey(data []byte) (*openpgp.Entity, error) {
+	return openpgp.ReadEntity(packet.NewReader(bytes.NewReader(data)))
+}
+
+// PGPFingerprint returns a key's fingerprint in upper-case hex
+func PGPFingerprint(entity *openpgp.Entity) string {
+	return fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+}
+
+// PGPIdentities returns the email addresses of a key's user IDs
+func PGPIdentities(entity *openpgp.Entity) []string {
+	addresses := make([]string, 0, len(entity.Identities))
+	for _, id := range entity.Identities {
+		if id.UserId != nil && id.UserId.Email != "" {
+			addresses = append(addresses, strings.ToLower(id.UserId.Email))
+		}
+	}
+	return addresses
+}
+
+// PGPSign creates an armored detached signature over content
+func PGPSign(content []byte, signer *openpgp.Entity) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&buf, signer, bytes.NewReader(content), pgpConfig); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PGPVerify checks a detached signature, armored or binary, and returns the
+// signing key. Expiry is checked at the given time, or now if it is zero.
+func PGPVerify(content, signature []byte, keyring openpgp.EntityList, at time.Time) (*openpgp.Entity, error) {
+	config := pgpConfigAt(at)
+	sig := bytes.NewReader(signature)
+	var signer *openpgp.Entity
+	var err error
+	if bytes.Contains(signature, []byte("-----BEGIN PGP")) {
+		signer, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(content), sig, config)
+	} else {
+		signer, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(content), sig, config)
+	}
+	return signer, pgpError(err)
+}
+
+// PGPEncrypt encrypts content to the given keys and returns an armored
+// message. If signer is set the message is signed as well.
+func PGPEncrypt(content []byte, to []*openpgp.Entity, signer *openpgp.Entity) ([]byte, error) {
+	var buf bytes.Buffer
+	aw, err := armor.Encode(&buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+	w, err := openpgp.Encrypt(aw, to, signer, nil, pgpConfig)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PGPMessage is a decrypted OpenPGP message
+type PGPMessage struct {
+	Content        []byte
+	Signed         bool
+	Signer         *openpgp.Entity // nil if the signing key is unknown
+	SignatureError error
+}
+
+// PGPDecrypt decrypts an armored message with a private key in keyring.
+// Public keys in keyring are used to check an embedded signature, whose
+// expiry is checked at the given time, or now if it is zero.
+func PGPDecrypt(armored []byte, keyring openpgp.EntityList, at time.Time) (*PGPMessage, error) {
+	block, err := armor.Decode(bytes.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenPGP message: %w", err)
+	}
+
+	md, err := openpgp.ReadMessage(block.Body, keyring, nil, pgpConfigAt(at))
+	if err != nil {
+		if errors.Is(err, pgperrors.ErrKeyIncorrect) {
+			return nil, ErrNotForRecipient
+		}
+		return nil, fmt.Errorf("failed to decrypt OpenPGP message: %w", err)
+	}
+
+	// The signature is only checked once the body has been read
+	content, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt OpenPGP message: %w", err)
+	}
+
+	msg := &PGPMessage{Content: content, Signed: md.IsSigned}
+	if md.IsSigned {
+		if md.SignedBy == nil {
+			msg.SignatureError = ErrUnknownKey
+		} else {
+			msg.Signer = md.SignedBy.Entity
+			msg.SignatureError = pgpError(md.SignatureError)
+		}
+	}
+	return msg, nil
+}
+
+// pgpConfigAt returns pgpConfig with the clock set to at, unless it is zero
+func pgpConfigAt(at time.Time) *packet.Config {
+	if at.IsZero() {
+		return pgpConfig
+	}
+	config := *pgpConfig
+	config.Time = func() time.Time { return at }
+	return &config
+}
+
+func pgpError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, pgperrors.ErrUnknownIssuer):
+		return ErrUnknownKey
+	case errors.Is(err, pgperrors.ErrSignatureExpired), errors.Is(err, pgperrors.ErrKeyExpired):
+		return ErrKeyExpired
+	default:
+		var sigErr pgperrors.SignatureError
+		if errors.As(err, &sigErr) {
+			return ErrSignatureInvalid
+		}
+		return err
+	}
+}
@@ -0,0 +1,185 @@
+package mailcrypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// newPGPEntity creates a key for address that expires after lifetime, or
+// never if it is zero
+func newPGPEntity(t *testing.T, address string, lifetime time.Duration) *openpgp.Entity {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity(address, "", address, &packet.Config{
+		Algorithm:       packet.PubKeyAlgoEdDSA,
+		KeyLifetimeSecs: uint32(lifetime / time.Second),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+// publicOnly returns the entity as a correspondent knows it
+func publicOnly(t *testing.T, entity *openpgp.Entity) *openpgp.Entity {
+	t.Helper()
+
+	armored, err := ArmorPGPPublicKey(entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := ReadPGPKey(armored, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public
+}
+
+func TestPGPSignVerify(t *testing.T) {
+	alice := newPGPEntity(t, "alice@example.com", 0)
+	keyring := openpgp.EntityList{publicOnly(t, alice)}
+	content := []byte("Content-Type: text/plain\r\n\r\nHello\r\n")
+
+	signature, err := PGPSign(content, alice)
+	if err != nil {
+		t.Fatalf("PGPSign: %v", err)
+	}
+
+	signer, err := PGPVerify(content, signature, keyring, time.Time{})
+	if err != nil {
+		t.Fatalf("PGPVerify: %v", err)
+	}
+	if PGPFingerprint(signer) != PGPFingerprint(alice) {
+		t.Errorf("signer is %s, want %s", PGPFingerprint(signer), PGPFingerprint(alice))
+	}
+}
+
+func TestPGPVerifyTampered(t *testing.T) {
+	alice := newPGPEntity(t, "alice@example.com", 0)
+	keyring := openpgp.EntityList{publicOnly(t, alice)}
+
+	signature, err := PGPSign([]byte("Pay Bob 10 EUR"), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = PGPVerify([]byte("Pay Bob 1000 EUR"), signature, keyring, time.Time{})
+	if !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("PGPVerify of altered content returned %v, want %v", err, ErrSignatureInvalid)
+	}
+
+	mallory := newPGPEntity(t, "mallory@example.com", 0)
+	_, err = PGPVerify([]byte("Pay Bob 10 EUR"), signature, openpgp.EntityList{publicOnly(t, mallory)}, time.Time{})
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("PGPVerify with another keyring returned %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestPGPVerifyExpiredKey(t *testing.T) {
+	alice := newPGPEntity(t, "alice@example.com", time.Hour)
+	keyring := openpgp.EntityList{publicOnly(t, alice)}
+	content := []byte("Hello")
+
+	signature, err := PGPSign(content, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := PGPVerify(content, signature, keyring, time.Now().Add(time.Minute)); err != nil {
+		t.Errorf("PGPVerify while the key is valid: %v", err)
+	}
+
+	_, err = PGPVerify(content, signature, keyring, time.Now().Add(2*time.Hour))
+	if !errors.Is(err, ErrKeyExpired) {
+		t.Errorf("PGPVerify after the key expired returned %v, want %v", err, ErrKeyExpired)
+	}
+}
+
+func TestPGPEncryptDecrypt(t *testing.T) {
+	alice := newPGPEntity(t, "alice@example.com", 0)
+	bob := newPGPEntity(t, "bob@example.com", 0)
+	content := []byte("Content-Type: text/plain\r\n\r\nSecret\r\n")
+
+	armored, err := PGPEncrypt(content, []*openpgp.Entity{publicOnly(t, bob)}, alice)
+	if err != nil {
+		t.Fatalf("PGPEncrypt: %v", err)
+	}
+
+	// Bob knows Alice's public key, so the signature checks out
+	msg, err := PGPDecrypt(armored, openpgp.EntityList{bob, publicOnly(t, alice)}, time.Time{})
+	if err != nil {
+		t.Fatalf("PGPDecrypt: %v", err)
+	}
+	if !bytes.Equal(msg.Content, content) {
+		t.Errorf("PGPDecrypt returned %q, want %q", msg.Content, content)
+	}
+	if !msg.Signed || msg.SignatureError != nil {
+		t.Errorf("signed = %v, signature error = %v, want a valid signature", msg.Signed, msg.SignatureError)
+	}
+	if msg.Signer == nil || PGPFingerprint(msg.Signer) != PGPFingerprint(alice) {
+		t.Errorf("signer is %v, want alice", msg.Signer)
+	}
+
+	// Without it the content is readable but the signer unknown
+	msg, err = PGPDecrypt(armored, openpgp.EntityList{bob}, time.Time{})
+	if err != nil {
+		t.Fatalf("PGPDecrypt: %v", err)
+	}
+	if !errors.Is(msg.SignatureError, ErrUnknownKey) {
+		t.Errorf("signature error is %v, want %v", msg.SignatureError, ErrUnknownKey)
+	}
+
+	carol := newPGPEntity(t, "carol@example.com", 0)
+	if _, err := PGPDecrypt(armored, openpgp.EntityList{carol}, time.Time{}); !errors.Is(err, ErrNotForRecipient) {
+		t.Errorf("PGPDecrypt for a non-recipient returned %v, want %v", err, ErrNotForRecipient)
+	}
+}
+
+func TestPGPDecryptTampered(t *testing.T) {
+	bob := newPGPEntity(t, "bob@example.com", 0)
+
+	var buf bytes.Buffer
+	w, err := openpgp.Encrypt(&buf, []*openpgp.Entity{publicOnly(t, bob)}, nil, nil, pgpConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("Secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a byte of the encrypted data, past the key packet
+	binary := buf.Bytes()
+	binary[len(binary)-5] ^= 0xff
+	armored := armorMessage(t, binary)
+
+	if msg, err := PGPDecrypt(armored, openpgp.EntityList{bob}, time.Time{}); err == nil {
+		t.Errorf("PGPDecrypt accepted an altered message and returned %q", msg.Content)
+	}
+}
+
+// armorMessage armors a binary OpenPGP message
+func armorMessage(t *testing.T, binary []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, "PGP MESSAGE", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(binary); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
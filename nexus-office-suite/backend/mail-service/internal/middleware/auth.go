@@ -0,0 +1,243 @@
+package middleware
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+)
+
+// Context keys set by the auth middleware. "userID" is the mailbox being
+// operated on, which differs from "actorID" when acting as a delegate.
+const (
+	ContextUserID    = "userID"
+	ContextActorID   = "actorID"
+	ContextTenantID  = "tenantID"
+	ContextEmail     = "email"
+	ContextClaims    = "claims"
+	ContextDelegated = "delegation"
+)
+
+// MailboxHeader selects a shared or delegated mailbox to act on
+const MailboxHeader = "X-Mailbox-ID"
+
+// Claims mirrors the claims issued by the platform auth service
+// (bac-platform/shared/go/auth)
+type Claims struct {
+	UserID      string   `json:"user_id"`
+	TenantID    string   `json:"tenant_id"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	jwt.RegisteredClaims
+}
+
+// HasRole reports whether the claims include any of the given roles
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, have := range c.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// JWTManager verifies platform bearer tokens
+type JWTManager struct {
+	secretKey string
+}
+
+// NewJWTManager creates a new JWT manager
+func NewJWTManager(secretKey string) *JWTManager {
+	return &JWTManager{secretKey: secretKey}
+}
+
+// Verify parses and validates a bearer token
+func (m *JWTManager) Verify(tokenString string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidToken
+		}
+		return []byte(m.secretKey), nil
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrExpiredToken
+		}
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid || claims.UserID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(time.Now()) {
+		return nil, ErrExpiredToken
+	}
+
+	return claims, nil
+}
+
+// Generate issues a short-lived token for calling other platform services
+// on a user's behalf (e.g. reading their calendar during delivery)
+func (m *JWTManager) Generate(userID, tenantID string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID:   userID,
+		TenantID: tenantID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "nexus-mail-service",
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(m.secretKey))
+}
+
+// VerifyLogin authenticates an IMAP/SMTP login where the password is a
+// platform access token. The username must be the token's user ID or email.
+func (m *JWTManager) VerifyLogin(username, password string) (*Claims, error) {
+	claims, err := m.Verify(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if username != claims.UserID && !strings.EqualFold(username, claims.Email) {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// Authenticate validates the bearer token and stores the caller's identity
+// in the context. GET requests may pass the token as ?access_token= so that
+// inline images and event streams work from the browser.
+func Authenticate(jwtManager *JWTManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := ""
+
+		authHeader := c.GetHeader("Authorization")
+		if authHeader != "" {
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+				return
+			}
+			tokenString = parts[1]
+		} else if c.Request.Method == http.MethodGet {
+			tokenString = c.Query("access_token")
+		}
+
+		if tokenString == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing authorization header"})
+			return
+		}
+
+		claims, err := jwtManager.Verify(tokenString)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+
+		c.Set(ContextClaims, claims)
+		c.Set(ContextUserID, claims.UserID)
+		c.Set(ContextActorID, claims.UserID)
+		c.Set(ContextTenantID, claims.TenantID)
+		c.Set(ContextEmail, claims.Email)
+
+		c.Next()
+	}
+}
+
+// MailboxAccess lets a caller act on another user's mailbox (shared mailbox
+// or delegation) by sending the X-Mailbox-ID header. On success "userID" is
+// replaced by the mailbox owner so every downstream query is scoped to that
+// mailbox, while "actorID" keeps the authenticated user.
+func MailboxAccess(delegationRepo *repository.DelegationRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mailboxID := c.GetHeader(MailboxHeader)
+		actorID := c.GetString(ContextActorID)
+		if mailboxID == "" || mailboxID == actorID {
+			c.Next()
+			return
+		}
+
+		delegation, err := delegationRepo.Get(mailboxID, actorID)
+		if err == sql.ErrNoRows || (err == nil && delegation.TenantID != c.GetString(ContextTenantID)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "No access to mailbox"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check mailbox access"})
+			return
+		}
+
+		if delegation.Access == model.DelegationAccessRead && !isReadRequest(c.Request) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Read-only access to mailbox"})
+			return
+		}
+
+		c.Set(ContextUserID, mailboxID)
+		c.Set(ContextDelegated, delegation)
+
+		c.Next()
+	}
+}
+
+// RequireRole rejects callers without any of the given roles
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetClaims(c)
+		if !ok || !claims.HasRole(roles...) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetClaims returns the authenticated caller's claims
+func GetClaims(c *gin.Context) (*Claims, bool) {
+	value, ok := c.Get(ContextClaims)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := value.(*Claims)
+	return claims, ok
+}
+
+// GetDelegation returns the delegation in use when acting on another mailbox
+func GetDelegation(c *gin.Context) (*model.MailboxDelegation, bool) {
+	value, ok := c.Get(ContextDelegated)
+	if !ok {
+		return nil, false
+	}
+	delegation, ok := value.(*model.MailboxDelegation)
+	return delegation, ok
+}
+
+// isReadRequest reports whether a request only reads mailbox data
+func isReadRequest(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	return r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/emails/search")
+}
@@ -42,6 +42,19 @@ type Email struct {
 	Attachments     []Attachment    `json:"attachments,omitempty" db:"-"`
 	Labels          []Label         `json:"labels,omitempty" db:"-"`
 	Headers         Headers         `json:"headers,omitempty" db:"headers"`
+	Highlight       *SearchHighlight `json:"highlight,omitempty" db:"-"`
+	SecurityProtocol *string         `json:"security_protocol,omitempty" db:"security_protocol"` // smime, pgp
+	IsSigned        bool            `json:"is_signed" db:"is_signed"`
+	IsEncrypted     bool            `json:"is_encrypted" db:"is_encrypted"`
+	SecureMIMEPath  *string         `json:"-" db:"secure_mime_path"`
+	Security        *MessageSecurity `json:"security,omitempty" db:"-"`
+}
+
+// SearchHighlight holds ranking and highlighted snippets for a search hit
+type SearchHighlight struct {
+	Rank    float64 `json:"rank"`
+	Subject string  `json:"subject,omitempty"`
+	Body    string  `json:"body,omitempty"`
 }
 
 // Folder represents an email folder
@@ -87,9 +100,42 @@ type Attachment struct {
 	StoragePath string    `json:"storage_path" db:"storage_path"`
 	ContentID   *string   `json:"content_id,omitempty" db:"content_id"` // For inline images
 	IsInline    bool      `json:"is_inline" db:"is_inline"`
+	ExtractedText string  `json:"-" db:"extracted_text"` // Indexed for search
+	ScanStatus  string     `json:"scan_status" db:"scan_status"` // clean, infected, blocked, unscanned, disabled
+	ScanResult  string     `json:"scan_result,omitempty" db:"scan_result"` // Signature name or block reason
+	ScannedAt   *time.Time `json:"scanned_at,omitempty" db:"scanned_at"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
+// Attachment scan statuses
+const (
+	ScanStatusClean     = "clean"
+	ScanStatusInfected  = "infected"
+	ScanStatusBlocked   = "blocked"
+	ScanStatusUnscanned = "unscanned"
+	ScanStatusDisabled  = "disabled"
+)
+
+// AttachmentPolicy controls content scanning and attachment blocking for a tenant.
+// For inbound SMTP the tenant is resolved from the recipient's domain.
+type AttachmentPolicy struct {
+	TenantID          string      `json:"tenant_id" db:"tenant_id"`
+	ScanEnabled       bool        `json:"scan_enabled" db:"scan_enabled"`
+	BlockedExtensions StringArray `json:"blocked_extensions" db:"blocked_extensions"`
+	BlockedMimeTypes  StringArray `json:"blocked_mime_types" db:"blocked_mime_types"`
+	BlockAction       string      `json:"block_action" db:"block_action"`             // reject, quarantine
+	ScannerDownAction string      `json:"scanner_down_action" db:"scanner_down_action"` // tempfail, deliver
+	UpdatedAt         time.Time   `json:"updated_at" db:"updated_at"`
+}
+
+// Attachment policy actions
+const (
+	PolicyActionReject     = "reject"
+	PolicyActionQuarantine = "quarantine"
+	PolicyActionTempfail   = "tempfail"
+	PolicyActionDeliver    = "deliver"
+)
+
 // Contact represents an email contact
 type Contact struct {
 	ID        string    `json:"id" db:"id"`
@@ -149,19 +195,314 @@ type Signature struct {
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
 
-// AutoResponder represents an auto-responder configuration
+// AutoResponder represents an auto-responder (out-of-office) configuration.
+// Subject and Message are sent to senders in the user's own domain; external
+// senders get the External* message, if ReplyToExternal is set.
 type AutoResponder struct {
-	ID        string     `json:"id" db:"id"`
-	UserID    string     `json:"user_id" db:"user_id"`
-	Enabled   bool       `json:"enabled" db:"enabled"`
-	Subject   string     `json:"subject" db:"subject"`
-	Message   string     `json:"message" db:"message"`
-	StartDate *time.Time `json:"start_date,omitempty" db:"start_date"`
-	EndDate   *time.Time `json:"end_date,omitempty" db:"end_date"`
-	CreatedAt time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+	ID                string     `json:"id" db:"id"`
+	UserID            string     `json:"user_id" db:"user_id"`
+	Enabled           bool       `json:"enabled" db:"enabled"`
+	Subject           string     `json:"subject" db:"subject"`
+	Message           string     `json:"message" db:"message"`
+	ReplyToExternal   bool       `json:"reply_to_external" db:"reply_to_external"`
+	ExternalSubject   string     `json:"external_subject" db:"external_subject"`
+	ExternalMessage   string     `json:"external_message" db:"external_message"`
+	StartDate         *time.Time `json:"start_date,omitempty" db:"start_date"`
+	EndDate           *time.Time `json:"end_date,omitempty" db:"end_date"`
+	UseCalendar       bool       `json:"use_calendar" db:"use_calendar"` // also reply during calendar out-of-office events
+	ReplyIntervalDays int        `json:"reply_interval_days" db:"reply_interval_days"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// DefaultReplyIntervalDays is how long to wait before auto-replying to the
+// same sender again (RFC 3834 recommends no more than once a week)
+const DefaultReplyIntervalDays = 7
+
+// ActiveAt reports whether the scheduled window (if any) covers t
+func (a *AutoResponder) ActiveAt(t time.Time) bool {
+	if !a.Enabled {
+		return false
+	}
+	if a.StartDate != nil && t.Before(*a.StartDate) {
+		return false
+	}
+	if a.EndDate != nil && t.After(*a.EndDate) {
+		return false
+	}
+	return true
+}
+
+// SenderListEntry is a per-user allow or block list entry. Address may be a
+// full address (alice@example.com) or a domain (example.com).
+type SenderListEntry struct {
+	ID        string    `json:"id" db:"id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	Address   string    `json:"address" db:"address" binding:"required"`
+	ListType  string    `json:"list_type" db:"list_type" binding:"required,oneof=allow block"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Sender list types
+const (
+	SenderListAllow = "allow"
+	SenderListBlock = "block"
+)
+
+// MailboxDelegation grants another user access to a mailbox. Shared
+// mailboxes (e.g. support@) are mailboxes whose members all hold delegations.
+type MailboxDelegation struct {
+	ID           string    `json:"id" db:"id"`
+	TenantID     string    `json:"tenant_id" db:"tenant_id"`
+	MailboxID    string    `json:"mailbox_id" db:"mailbox_id"` // user ID of the mailbox owner
+	DelegateID   string    `json:"delegate_id" db:"delegate_id" binding:"required"`
+	Access       string    `json:"access" db:"access"` // read, full
+	SendAs       bool      `json:"send_as" db:"send_as"`
+	SendOnBehalf bool      `json:"send_on_behalf" db:"send_on_behalf"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Delegation access levels
+const (
+	DelegationAccessRead = "read"
+	DelegationAccessFull = "full"
+)
+
+// MailboxJob is a background mailbox import or export
+type MailboxJob struct {
+	ID          string     `json:"id" db:"id"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	TenantID    string     `json:"tenant_id" db:"tenant_id"`
+	Type        string     `json:"type" db:"type"`     // import, export
+	Format      string     `json:"format" db:"format"` // mbox, eml
+	FolderID    *string    `json:"folder_id,omitempty" db:"folder_id"`
+	Status      string     `json:"status" db:"status"` // pending, running, completed, failed
+	Total       int        `json:"total" db:"total"`
+	Processed   int        `json:"processed" db:"processed"`
+	Imported    int        `json:"imported" db:"imported"`
+	Skipped     int        `json:"skipped" db:"skipped"` // duplicates (same Message-ID)
+	Failed      int        `json:"failed" db:"failed"`
+	Error       string     `json:"error,omitempty" db:"error"`
+	StoragePath string     `json:"-" db:"storage_path"` // uploaded archive or export result
+	Filename    string     `json:"filename" db:"filename"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+}
+
+// Mailbox job types, formats and statuses
+const (
+	JobTypeImport = "import"
+	JobTypeExport = "export"
+
+	JobFormatMbox = "mbox"
+	JobFormatEML  = "eml"
+
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+)
+
+// MailChange is one entry of a user's change log, written by database
+// triggers and used for JMAP state strings and /changes
+type MailChange struct {
+	ID         int64     `json:"id" db:"id"`
+	UserID     string    `json:"user_id" db:"user_id"`
+	Seq        int64     `json:"seq" db:"seq"`
+	ObjectType string    `json:"object_type" db:"object_type"`
+	ObjectID   string    `json:"object_id" db:"object_id"`
+	ChangeType string    `json:"change_type" db:"change_type"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// Change log object and change types
+const (
+	ChangeObjectEmail   = "Email"
+	ChangeObjectThread  = "Thread"
+	ChangeObjectMailbox = "Mailbox"
+
+	ChangeCreated   = "created"
+	ChangeUpdated   = "updated"
+	ChangeDestroyed = "destroyed"
+)
+
+// MailboxUsage is the storage a mailbox uses, kept current by trigger
+type MailboxUsage struct {
+	UserID       string  `json:"user_id" db:"user_id"`
+	TenantID     *string `json:"tenant_id,omitempty" db:"tenant_id"`
+	StorageBytes int64   `json:"storage_bytes" db:"storage_bytes"`
+	MessageCount int64   `json:"message_count" db:"message_count"`
+}
+
+// MailQuota is a storage limit for a mailbox or a tenant. A limit of 0
+// means unlimited.
+type MailQuota struct {
+	Scope             string    `json:"scope" db:"scope"` // user, tenant
+	SubjectID         string    `json:"subject_id" db:"subject_id"`
+	StorageLimitBytes int64     `json:"storage_limit_bytes" db:"storage_limit_bytes" binding:"min=0"`
+	UpdatedBy         string    `json:"updated_by" db:"updated_by"`
+	UpdatedAt         time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Quota scopes
+const (
+	QuotaScopeUser   = "user"
+	QuotaScopeTenant = "tenant"
+)
+
+// QuotaStatus reports a mailbox's usage against its own and its tenant's limits
+type QuotaStatus struct {
+	UserID             string `json:"user_id"`
+	TenantID           string `json:"tenant_id,omitempty"`
+	StorageBytes       int64  `json:"storage_bytes"`
+	MessageCount       int64  `json:"message_count"`
+	StorageLimitBytes  int64  `json:"storage_limit_bytes"` // 0 = unlimited
+	TenantStorageBytes int64  `json:"tenant_storage_bytes,omitempty"`
+	TenantLimitBytes   int64  `json:"tenant_limit_bytes,omitempty"`
+}
+
+// RetentionPolicy is how long mail stays in a folder type before it is
+// purged. RetentionDays of 0 keeps mail forever.
+type RetentionPolicy struct {
+	TenantID      string    `json:"tenant_id" db:"tenant_id"`
+	FolderType    string    `json:"folder_type" db:"folder_type"`
+	RetentionDays int       `json:"retention_days" db:"retention_days" binding:"min=0"`
+	UpdatedBy     string    `json:"updated_by" db:"updated_by"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// LegalHold exempts a mailbox from retention purges until it is released
+type LegalHold struct {
+	ID         string     `json:"id" db:"id"`
+	TenantID   string     `json:"tenant_id" db:"tenant_id"`
+	UserID     string     `json:"user_id" db:"user_id" binding:"required"`
+	Reason     string     `json:"reason" db:"reason"`
+	CreatedBy  string     `json:"created_by" db:"created_by"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ReleasedBy *string    `json:"released_by,omitempty" db:"released_by"`
+	ReleasedAt *time.Time `json:"released_at,omitempty" db:"released_at"`
+}
+
+// Protocols for signing and encrypting mail
+const (
+	SecurityProtocolSMIME = "smime"
+	SecurityProtocolPGP   = "pgp"
+)
+
+// Results of verifying a signature
+const (
+	SignatureValid      = "valid"       // intact, and the signer's certificate is trusted
+	SignatureUntrusted  = "untrusted"   // intact, but the certificate does not chain to a trusted root or had expired
+	SignatureUnknownKey = "unknown_key" // the signing OpenPGP key is not on file
+	SignatureInvalid    = "invalid"     // the message was altered after it was signed
+	SignatureError      = "error"       // the signature could not be checked
+)
+
+// MailCryptoKey is an S/MIME certificate or OpenPGP key for an address.
+// A user's own keys carry a private key, sealed at rest; keys without one
+// are correspondents' public keys, used to encrypt mail to them and to
+// check their signatures.
+type MailCryptoKey struct {
+	ID            string     `json:"id" db:"id"`
+	UserID        string     `json:"user_id" db:"user_id"`
+	Protocol      string     `json:"protocol" db:"protocol"` // smime, pgp
+	Address       string     `json:"address" db:"address"`
+	Fingerprint   string     `json:"fingerprint" db:"fingerprint"`
+	PublicKey     string     `json:"public_key" db:"public_key"`
+	PrivateKey    []byte     `json:"-" db:"private_key"`
+	HasPrivateKey bool       `json:"has_private_key" db:"-"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
 }
 
+// UploadKeyRequest adds an S/MIME certificate or OpenPGP key. S/MIME data
+// is PEM (certificate chain, optionally with the private key) or base64
+// PKCS#12; OpenPGP data is an armored public or secret key. Passphrase
+// unlocks a PKCS#12 file or protected OpenPGP secret key.
+type UploadKeyRequest struct {
+	Protocol   string `json:"protocol" binding:"required,oneof=smime pgp"`
+	Data       string `json:"data" binding:"required"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// MessageSecurity describes how a message was signed or encrypted and what
+// came of verifying and decrypting it when it was read
+type MessageSecurity struct {
+	Protocol          string             `json:"protocol"`
+	Signed            bool               `json:"signed"`
+	SignatureStatus   string             `json:"signature_status,omitempty"`
+	Signer            string             `json:"signer,omitempty"` // address on the signing key or certificate
+	SignerFingerprint string             `json:"signer_fingerprint,omitempty"`
+	SignerMatchesFrom bool               `json:"signer_matches_from"`
+	SignedAt          *time.Time         `json:"signed_at,omitempty"`
+	Encrypted         bool               `json:"encrypted"`
+	Decrypted         bool               `json:"decrypted"`
+	Error             string             `json:"error,omitempty"`
+	Attachments       []SecureAttachment `json:"attachments,omitempty"` // attachments inside the encrypted part
+}
+
+// SecureAttachment is an attachment carried inside an encrypted message.
+// It is decrypted on each download and addressed by its index.
+type SecureAttachment struct {
+	Index       int    `json:"index"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// DistributionList is a group address (e.g. sales@) whose posts are
+// delivered to its members. Its archive and moderation queue live in a
+// mailbox whose user ID is the list ID.
+type DistributionList struct {
+	ID             string    `json:"id" db:"id"`
+	TenantID       string    `json:"tenant_id" db:"tenant_id"`
+	Address        string    `json:"address" db:"address" binding:"required,email"`
+	Name           string    `json:"name" db:"name" binding:"required"`
+	Description    string    `json:"description" db:"description"`
+	PostingPolicy  string    `json:"posting_policy" db:"posting_policy"` // members, moderated, anyone
+	SubjectPrefix  string    `json:"subject_prefix" db:"subject_prefix"`
+	ArchiveEnabled bool      `json:"archive_enabled" db:"archive_enabled"`
+	CreatedBy      string    `json:"created_by" db:"created_by"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// ListMember is a subscriber of a distribution list. Members with a UserID
+// get copies in their mailbox; others are relayed over SMTP.
+type ListMember struct {
+	ID           string    `json:"id" db:"id"`
+	ListID       string    `json:"list_id" db:"list_id"`
+	Address      string    `json:"address" db:"address"`
+	UserID       *string   `json:"user_id,omitempty" db:"user_id"`
+	Role         string    `json:"role" db:"role"`         // member, moderator, owner
+	Delivery     string    `json:"delivery" db:"delivery"` // each, digest, none
+	LastDigestAt time.Time `json:"last_digest_at" db:"last_digest_at"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// CanModerate reports whether the member may approve posts and manage the list
+func (m *ListMember) CanModerate() bool {
+	return m.Role == ListRoleModerator || m.Role == ListRoleOwner
+}
+
+// Distribution list posting policies, member roles and delivery modes
+const (
+	PostingMembers   = "members"
+	PostingModerated = "moderated"
+	PostingAnyone    = "anyone"
+
+	ListRoleMember    = "member"
+	ListRoleModerator = "moderator"
+	ListRoleOwner     = "owner"
+
+	DeliveryEach   = "each"
+	DeliveryDigest = "digest"
+	DeliveryNone   = "none"
+)
+
 // Alias represents an email alias
 type Alias struct {
 	ID        string    `json:"id" db:"id"`
@@ -271,6 +612,9 @@ type ComposeEmailRequest struct {
 	SignatureID    string            `json:"signature_id,omitempty"`
 	InReplyTo      string            `json:"in_reply_to,omitempty"`
 	References     []string          `json:"references,omitempty"`
+	Sign           bool              `json:"sign,omitempty"`
+	Encrypt        bool              `json:"encrypt,omitempty"`
+	SecurityProtocol string          `json:"security_protocol,omitempty" binding:"omitempty,oneof=smime pgp"` // default smime
 }
 
 type EmailListResponse struct {
@@ -293,9 +637,21 @@ type SearchEmailRequest struct {
 	PageSize    int       `json:"page_size"`
 }
 
+type ExportMailboxRequest struct {
+	Format   string  `json:"format" binding:"required,oneof=mbox eml"`
+	FolderID *string `json:"folder_id,omitempty"` // nil exports the whole account
+}
+
+type ListMemberRequest struct {
+	Address  string  `json:"address,omitempty"`
+	UserID   *string `json:"user_id,omitempty"`
+	Role     string  `json:"role,omitempty"`
+	Delivery string  `json:"delivery,omitempty"`
+}
+
 type BulkActionRequest struct {
 	EmailIDs  []string `json:"email_ids" binding:"required"`
-	Action    string   `json:"action" binding:"required"` // mark_read, mark_unread, star, unstar, delete, move, add_label
+	Action    string   `json:"action" binding:"required"` // mark_read, mark_unread, star, unstar, delete, move, add_label, mark_spam, mark_not_spam
 	FolderID  string   `json:"folder_id,omitempty"`
 	LabelIDs  []string `json:"label_ids,omitempty"`
 }
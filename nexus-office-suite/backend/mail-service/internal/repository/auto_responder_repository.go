@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"nexus-mail-service/internal/model"
+
+	"github.com/google/uuid"
+)
+
+type AutoResponderRepository struct {
+	db *sql.DB
+}
+
+func NewAutoResponderRepository(db *sql.DB) *AutoResponderRepository {
+	return &AutoResponderRepository{db: db}
+}
+
+// Get retrieves a user's auto-responder
+func (r *AutoResponderRepository) Get(userID string) (*model.AutoResponder, error) {
+	a := &model.AutoResponder{}
+	query := `
+		SELECT id, user_id, enabled, subject, message, reply_to_external, external_subject,
+			external_message, start_date, end_date, use_calendar, reply_interval_days,
+			created_at, updated_at
+		FROM auto_responders
+		WHERE user_id = $1
+	`
+
+	err := r.db.QueryRow(query, userID).Scan(
+		&a.ID, &a.UserID, &a.Enabled, &a.Subject, &a.Message, &a.ReplyToExternal, &a.ExternalSubject,
+		&a.ExternalMessage, &a.StartDate, &a.EndDate, &a.UseCalendar, &a.ReplyIntervalDays,
+		&a.CreatedAt, &a.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Upsert creates or replaces a user's auto-responder. Changing the settings
+// forgets who was already answered, so everyone gets the new message.
+func (r *AutoResponderRepository) Upsert(a *model.AutoResponder) error {
+	if a.ID == "" {
+		a.ID = uuid.New().String()
+	}
+
+	now := time.Now()
+	a.CreatedAt = now
+	a.UpdatedAt = now
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO auto_responders (
+			id, user_id, enabled, subject, message, reply_to_external, external_subject,
+			external_message, start_date, end_date, use_calendar, reply_interval_days,
+			created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (user_id) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			subject = EXCLUDED.subject,
+			message = EXCLUDED.message,
+			reply_to_external = EXCLUDED.reply_to_external,
+			external_subject = EXCLUDED.external_subject,
+			external_message = EXCLUDED.external_message,
+			start_date = EXCLUDED.start_date,
+			end_date = EXCLUDED.end_date,
+			use_calendar = EXCLUDED.use_calendar,
+			reply_interval_days = EXCLUDED.reply_interval_days,
+			updated_at = EXCLUDED.updated_at
+		RETURNING id, created_at
+	`
+
+	err = tx.QueryRow(
+		query,
+		a.ID, a.UserID, a.Enabled, a.Subject, a.Message, a.ReplyToExternal, a.ExternalSubject,
+		a.ExternalMessage, a.StartDate, a.EndDate, a.UseCalendar, a.ReplyIntervalDays,
+		a.CreatedAt, a.UpdatedAt,
+	).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM auto_responder_replies WHERE user_id = $1`, a.UserID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a user's auto-responder
+func (r *AutoResponderRepository) Delete(userID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM auto_responders WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM auto_responder_replies WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ClaimReply records that a sender is being answered. It returns false when
+// the sender was already answered within the interval, so concurrent
+// deliveries never produce two replies.
+func (r *AutoResponderRepository) ClaimReply(userID, sender string, interval time.Duration) (bool, error) {
+	sender = strings.ToLower(strings.TrimSpace(sender))
+	now := time.Now()
+
+	query := `
+		INSERT INTO auto_responder_replies (user_id, sender, replied_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, sender) DO UPDATE SET replied_at = EXCLUDED.replied_at
+		WHERE auto_responder_replies.replied_at < $4
+		RETURNING sender
+	`
+
+	var claimed string
+	err := r.db.QueryRow(query, userID, sender, now, now.Add(-interval)).Scan(&claimed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
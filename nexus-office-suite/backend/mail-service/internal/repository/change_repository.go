@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"nexus-mail-service/internal/model"
+)
+
+// ChangeRepository reads the per-user change log maintained by the
+// mail_changes triggers
+type ChangeRepository struct {
+	db *sql.DB
+}
+
+func NewChangeRepository(db *sql.DB) *ChangeRepository {
+	return &ChangeRepository{db: db}
+}
+
+// State returns the sequence number of the user's latest change to objects
+// of a type, or 0 if there is none
+func (r *ChangeRepository) State(userID, objectType string) (int64, error) {
+	var seq int64
+	query := `SELECT COALESCE(MAX(seq), 0) FROM mail_changes WHERE user_id = $1 AND object_type = $2`
+	err := r.db.QueryRow(query, userID, objectType).Scan(&seq)
+	return seq, err
+}
+
+// Changes returns up to limit changes to objects of a type made after
+// sinceSeq, oldest first
+func (r *ChangeRepository) Changes(userID, objectType string, sinceSeq int64, limit int) ([]model.MailChange, error) {
+	query := `
+		SELECT id, user_id, seq, object_type, object_id, change_type, created_at
+		FROM mail_changes
+		WHERE user_id = $1 AND object_type = $2 AND seq > $3
+		ORDER BY seq ASC
+		LIMIT $4
+	`
+
+	rows, err := r.db.Query(query, userID, objectType, sinceSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []model.MailChange
+	for rows.Next() {
+		var change model.MailChange
+		err := rows.Scan(
+			&change.ID, &change.UserID, &change.Seq, &change.ObjectType,
+			&change.ObjectID, &change.ChangeType, &change.CreatedAt,
+		)
+		if err != nil {
+			continue
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
+
+// PrunedUpTo returns the highest of the user's sequence numbers removed by
+// Prune; changes since an earlier state can no longer be calculated
+func (r *ChangeRepository) PrunedUpTo(userID string) (int64, error) {
+	var seq int64
+	err := r.db.QueryRow(`SELECT pruned_up_to FROM mail_change_seq WHERE user_id = $1`, userID).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// Prune deletes changes recorded before the cutoff and remembers, per user,
+// how far the log was truncated
+func (r *ChangeRepository) Prune(before time.Time) (int64, error) {
+	query := `
+		WITH pruned AS (
+			DELETE FROM mail_changes WHERE created_at < $1 RETURNING user_id, seq
+		), watermarks AS (
+			SELECT user_id, MAX(seq) AS seq, COUNT(*) AS n FROM pruned GROUP BY user_id
+		), updated AS (
+			UPDATE mail_change_seq s SET pruned_up_to = GREATEST(s.pruned_up_to, w.seq)
+			FROM watermarks w
+			WHERE s.user_id = w.user_id
+		)
+		SELECT COALESCE(SUM(n), 0) FROM watermarks
+	`
+
+	var deleted int64
+	err := r.db.QueryRow(query, before).Scan(&deleted)
+	return deleted, err
+}
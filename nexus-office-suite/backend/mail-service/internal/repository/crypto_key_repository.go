@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"nexus-mail-service/internal/model"
+
+	"github.com/google/uuid"
+)
+
+type CryptoKeyRepository struct {
+	db *sql.DB
+}
+
+func NewCryptoKeyRepository(db *sql.DB) *CryptoKeyRepository {
+	return &CryptoKeyRepository{db: db}
+}
+
+// Save stores a key. Uploading a key again replaces its public part and
+// adds the private key if it was missing.
+func (r *CryptoKeyRepository) Save(key *model.MailCryptoKey) error {
+	if key.ID == "" {
+		key.ID = uuid.New().String()
+	}
+	key.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO mail_crypto_keys (
+			id, user_id, protocol, address, fingerprint, public_key, private_key, expires_at, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (user_id, protocol, fingerprint, address) DO UPDATE SET
+			public_key = EXCLUDED.public_key,
+			private_key = COALESCE(EXCLUDED.private_key, mail_crypto_keys.private_key),
+			expires_at = EXCLUDED.expires_at
+		RETURNING id, created_at, private_key IS NOT NULL
+	`
+
+	return r.db.QueryRow(
+		query,
+		key.ID, key.UserID, key.Protocol, key.Address, key.Fingerprint,
+		key.PublicKey, key.PrivateKey, key.ExpiresAt, key.CreatedAt,
+	).Scan(&key.ID, &key.CreatedAt, &key.HasPrivateKey)
+}
+
+// List lists a user's keys, without their private keys
+func (r *CryptoKeyRepository) List(userID string) ([]model.MailCryptoKey, error) {
+	query := `
+		SELECT id, user_id, protocol, address, fingerprint, public_key,
+			private_key IS NOT NULL, expires_at, created_at
+		FROM mail_crypto_keys
+		WHERE user_id = $1
+		ORDER BY protocol ASC, address ASC, created_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []model.MailCryptoKey
+	for rows.Next() {
+		var key model.MailCryptoKey
+		err := rows.Scan(
+			&key.ID, &key.UserID, &key.Protocol, &key.Address, &key.Fingerprint,
+			&key.PublicKey, &key.HasPrivateKey, &key.ExpiresAt, &key.CreatedAt,
+		)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Delete removes one of a user's keys. Returns sql.ErrNoRows if the user
+// has no key with this ID.
+func (r *CryptoKeyRepository) Delete(keyID, userID string) error {
+	result, err := r.db.Exec(`DELETE FROM mail_crypto_keys WHERE id = $1 AND user_id = $2`, keyID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PrivateKeys returns a user's own unexpired keys for a protocol, newest
+// first, with their sealed private keys
+func (r *CryptoKeyRepository) PrivateKeys(userID, protocol string) ([]model.MailCryptoKey, error) {
+	query := `
+		SELECT id, user_id, protocol, address, fingerprint, public_key, private_key, expires_at, created_at
+		FROM mail_crypto_keys
+		WHERE user_id = $1 AND protocol = $2 AND private_key IS NOT NULL
+			AND (expires_at IS NULL OR expires_at > $3)
+		ORDER BY created_at DESC
+	`
+	return r.queryKeys(query, userID, protocol, time.Now())
+}
+
+// PublicKeys returns the unexpired keys a user can use for an address:
+// those the user stored for it, then the address owner's own keys. Private
+// keys are not returned.
+func (r *CryptoKeyRepository) PublicKeys(userID, protocol, address string) ([]model.MailCryptoKey, error) {
+	query := `
+		SELECT id, user_id, protocol, address, fingerprint, public_key, NULL::bytea, expires_at, created_at
+		FROM mail_crypto_keys
+		WHERE protocol = $2 AND address = $3
+			AND (user_id = $1 OR private_key IS NOT NULL)
+			AND (expires_at IS NULL OR expires_at > $4)
+		ORDER BY (user_id = $1) DESC, created_at DESC
+	`
+	return r.queryKeys(query, userID, protocol, address, time.Now())
+}
+
+func (r *CryptoKeyRepository) queryKeys(query string, args ...interface{}) ([]model.MailCryptoKey, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []model.MailCryptoKey
+	for rows.Next() {
+		var key model.MailCryptoKey
+		err := rows.Scan(
+			&key.ID, &key.UserID, &key.Protocol, &key.Address, &key.Fingerprint,
+			&key.PublicKey, &key.PrivateKey, &key.ExpiresAt, &key.CreatedAt,
+		)
+		if err != nil {
+			continue
+		}
+		key.HasPrivateKey = key.PrivateKey != nil
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
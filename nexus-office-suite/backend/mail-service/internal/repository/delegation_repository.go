@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"nexus-mail-service/internal/model"
+
+	"github.com/google/uuid"
+)
+
+type DelegationRepository struct {
+	db *sql.DB
+}
+
+func NewDelegationRepository(db *sql.DB) *DelegationRepository {
+	return &DelegationRepository{db: db}
+}
+
+// Upsert grants or updates a delegate's access to a mailbox
+func (r *DelegationRepository) Upsert(delegation *model.MailboxDelegation) error {
+	if delegation.ID == "" {
+		delegation.ID = uuid.New().String()
+	}
+
+	now := time.Now()
+	delegation.CreatedAt = now
+	delegation.UpdatedAt = now
+
+	query := `
+		INSERT INTO mailbox_delegations (
+			id, tenant_id, mailbox_id, delegate_id, access, send_as, send_on_behalf, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (mailbox_id, delegate_id) DO UPDATE SET
+			access = EXCLUDED.access,
+			send_as = EXCLUDED.send_as,
+			send_on_behalf = EXCLUDED.send_on_behalf,
+			updated_at = EXCLUDED.updated_at
+		RETURNING id, created_at, updated_at
+	`
+
+	return r.db.QueryRow(
+		query,
+		delegation.ID, delegation.TenantID, delegation.MailboxID, delegation.DelegateID,
+		delegation.Access, delegation.SendAs, delegation.SendOnBehalf,
+		delegation.CreatedAt, delegation.UpdatedAt,
+	).Scan(&delegation.ID, &delegation.CreatedAt, &delegation.UpdatedAt)
+}
+
+// Get retrieves the delegation of a mailbox to a delegate
+func (r *DelegationRepository) Get(mailboxID, delegateID string) (*model.MailboxDelegation, error) {
+	d := &model.MailboxDelegation{}
+	query := `
+		SELECT id, tenant_id, mailbox_id, delegate_id, access, send_as, send_on_behalf, created_at, updated_at
+		FROM mailbox_delegations
+		WHERE mailbox_id = $1 AND delegate_id = $2
+	`
+
+	err := r.db.QueryRow(query, mailboxID, delegateID).Scan(
+		&d.ID, &d.TenantID, &d.MailboxID, &d.DelegateID, &d.Access,
+		&d.SendAs, &d.SendOnBehalf, &d.CreatedAt, &d.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// ListByMailbox lists who has access to a mailbox
+func (r *DelegationRepository) ListByMailbox(mailboxID string) ([]model.MailboxDelegation, error) {
+	return r.list(`WHERE mailbox_id = $1 ORDER BY created_at ASC`, mailboxID)
+}
+
+// ListByDelegate lists the mailboxes a user has been given access to
+func (r *DelegationRepository) ListByDelegate(delegateID string) ([]model.MailboxDelegation, error) {
+	return r.list(`WHERE delegate_id = $1 ORDER BY created_at ASC`, delegateID)
+}
+
+// Delete revokes a delegation; only the mailbox owner may do so
+func (r *DelegationRepository) Delete(delegationID, mailboxID string) error {
+	_, err := r.db.Exec(`DELETE FROM mailbox_delegations WHERE id = $1 AND mailbox_id = $2`, delegationID, mailboxID)
+	return err
+}
+
+func (r *DelegationRepository) list(where string, arg string) ([]model.MailboxDelegation, error) {
+	query := `
+		SELECT id, tenant_id, mailbox_id, delegate_id, access, send_as, send_on_behalf, created_at, updated_at
+		FROM mailbox_delegations
+	` + where
+
+	rows, err := r.db.Query(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var delegations []model.MailboxDelegation
+	for rows.Next() {
+		var d model.MailboxDelegation
+		err := rows.Scan(
+			&d.ID, &d.TenantID, &d.MailboxID, &d.DelegateID, &d.Access,
+			&d.SendAs, &d.SendOnBehalf, &d.CreatedAt, &d.UpdatedAt,
+		)
+		if err != nil {
+			continue
+		}
+		delegations = append(delegations, d)
+	}
+
+	return delegations, nil
+}
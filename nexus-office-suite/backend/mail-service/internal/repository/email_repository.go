@@ -3,10 +3,12 @@ package repository
 import (
 	"database/sql"
 	"fmt"
+	"html"
 	"strings"
 	"time"
 
 	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/search"
 
 	"github.com/google/uuid"
 )
@@ -34,7 +36,9 @@ func (r *EmailRepository) Create(email *model.Email) error {
 	now := time.Now()
 	email.CreatedAt = now
 	email.UpdatedAt = now
-	email.ReceivedAt = now
+	if email.ReceivedAt.IsZero() {
+		email.ReceivedAt = now // imported mail keeps its original date
+	}
 
 	query := `
 		INSERT INTO emails (
@@ -42,10 +46,12 @@ func (r *EmailRepository) Create(email *model.Email) error {
 			from_address, from_name, to_addresses, cc_addresses, bcc_addresses,
 			subject, body, body_html, folder_id, is_read, is_starred, is_draft,
 			is_spam, is_deleted, has_attachments, priority, spam_score, size,
-			received_at, sent_at, scheduled_at, headers, created_at, updated_at
+			received_at, sent_at, scheduled_at, headers, created_at, updated_at,
+			security_protocol, is_signed, is_encrypted, secure_mime_path
 		) VALUES (
 			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15,
-			$16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30
+			$16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30,
+			$31, $32, $33, $34
 		) RETURNING id, created_at, updated_at
 	`
 
@@ -58,6 +64,7 @@ func (r *EmailRepository) Create(email *model.Email) error {
 		email.HasAttachments, email.Priority, email.SpamScore, email.Size,
 		email.ReceivedAt, email.SentAt, email.ScheduledAt, email.Headers,
 		email.CreatedAt, email.UpdatedAt,
+		email.SecurityProtocol, email.IsSigned, email.IsEncrypted, email.SecureMIMEPath,
 	).Scan(&email.ID, &email.CreatedAt, &email.UpdatedAt)
 }
 
@@ -69,7 +76,8 @@ func (r *EmailRepository) GetByID(emailID string, userID string) (*model.Email,
 			from_address, from_name, to_addresses, cc_addresses, bcc_addresses,
 			subject, body, body_html, folder_id, is_read, is_starred, is_draft,
 			is_spam, is_deleted, has_attachments, priority, spam_score, size,
-			received_at, sent_at, scheduled_at, read_at, headers, created_at, updated_at
+			received_at, sent_at, scheduled_at, read_at, headers, created_at, updated_at,
+			security_protocol, is_signed, is_encrypted, secure_mime_path
 		FROM emails
 		WHERE id = $1 AND user_id = $2 AND is_deleted = false
 	`
@@ -82,6 +90,7 @@ func (r *EmailRepository) GetByID(emailID string, userID string) (*model.Email,
 		&email.HasAttachments, &email.Priority, &email.SpamScore, &email.Size,
 		&email.ReceivedAt, &email.SentAt, &email.ScheduledAt, &email.ReadAt,
 		&email.Headers, &email.CreatedAt, &email.UpdatedAt,
+		&email.SecurityProtocol, &email.IsSigned, &email.IsEncrypted, &email.SecureMIMEPath,
 	)
 
 	if err != nil {
@@ -129,6 +138,12 @@ func (r *EmailRepository) List(userID, folderID string, page, pageSize int, filt
 		argIndex++
 	}
 
+	if receivedAfter, ok := filters["received_after"].(time.Time); ok {
+		whereClause += fmt.Sprintf(" AND received_at > $%d", argIndex)
+		args = append(args, receivedAfter)
+		argIndex++
+	}
+
 	// Count total
 	var total int
 	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM emails %s", whereClause)
@@ -179,6 +194,43 @@ func (r *EmailRepository) List(userID, folderID string, page, pageSize int, filt
 	return emails, total, nil
 }
 
+// ListIDs returns the IDs of a user's emails, oldest first; an empty
+// folderID lists every folder
+func (r *EmailRepository) ListIDs(userID, folderID string) ([]string, error) {
+	query := `SELECT id FROM emails WHERE user_id = $1 AND is_deleted = false`
+	args := []interface{}{userID}
+	if folderID != "" {
+		query += ` AND folder_id = $2`
+		args = append(args, folderID)
+	}
+	query += ` ORDER BY received_at ASC`
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// ExistsByMessageID reports whether the user already has a message with this Message-ID
+func (r *EmailRepository) ExistsByMessageID(userID, messageID string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS(SELECT 1 FROM emails WHERE user_id = $1 AND message_id = $2)`
+	err := r.db.QueryRow(query, userID, messageID).Scan(&exists)
+	return exists, err
+}
+
 // Update updates an email
 func (r *EmailRepository) Update(email *model.Email) error {
 	email.UpdatedAt = time.Now()
@@ -281,44 +333,87 @@ func (r *EmailRepository) GetThread(threadID, userID string) ([]model.Email, err
 	return emails, nil
 }
 
-// Search searches emails
-func (r *EmailRepository) Search(userID, query string, page, pageSize int) ([]model.Email, int, error) {
+// searchHighlightStart and searchHighlightStop surround the matching words
+// of snippets as the database returns them. They are control characters
+// rather than markup because the snippets are still plain text, which is
+// escaped before the delimiters are turned into <mark> elements.
+const (
+	searchHighlightStart = "\x02"
+	searchHighlightStop  = "\x03"
+)
+
+var (
+	searchHighlightDelimiters = searchHighlightStart + searchHighlightStop
+	subjectHeadlineOptions    = fmt.Sprintf(
+		`StartSel="%s", StopSel="%s", HighlightAll=true`,
+		searchHighlightStart, searchHighlightStop,
+	)
+	bodyHeadlineOptions = fmt.Sprintf(
+		`StartSel="%s", StopSel="%s", MaxFragments=2, MaxWords=25, MinWords=10`,
+		searchHighlightStart, searchHighlightStop,
+	)
+)
+
+// highlightSnippet turns a snippet returned by the database into HTML, with
+// the matching words in <mark> elements
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, searchHighlightStart, "<mark>")
+	return strings.ReplaceAll(snippet, searchHighlightStop, "</mark>")
+}
+
+// Search runs a parsed search query and returns ranked, highlighted results
+func (r *EmailRepository) Search(userID string, q *search.Query, page, pageSize int) ([]model.Email, int, error) {
 	offset := (page - 1) * pageSize
 
-	// Simple full-text search using PostgreSQL
-	whereClause := `
-		WHERE user_id = $1 AND is_deleted = false
-		AND (
-			subject ILIKE $2 OR
-			body ILIKE $2 OR
-			from_address ILIKE $2 OR
-			to_addresses::text ILIKE $2
-		)
-	`
-	searchPattern := "%" + query + "%"
+	args := []interface{}{userID}
+	whereClause := "WHERE user_id = $1 AND is_deleted = false" + buildSearchClause(q, &args)
 
 	// Count total
 	var total int
 	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM emails %s", whereClause)
-	err := r.db.QueryRow(countQuery, userID, searchPattern).Scan(&total)
+	err := r.db.QueryRow(countQuery, args...).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	// Get emails
+	// Rank and highlight only when there is free text to match against
+	rankExpr := "0"
+	subjectHighlight := "''"
+	bodyHighlight := "''"
+	orderBy := "received_at DESC"
+	if text := q.WebSearch(); text != "" {
+		args = append(args, text)
+		tsQuery := fmt.Sprintf("websearch_to_tsquery('english', $%d)", len(args))
+		rankExpr = fmt.Sprintf("ts_rank_cd(search_vector, %s)", tsQuery)
+		// The delimiters are stripped from the text first, so that only
+		// ts_headline can produce them
+		args = append(args, searchHighlightDelimiters, subjectHeadlineOptions, bodyHeadlineOptions)
+		delimiters := len(args) - 2
+		subjectHighlight = fmt.Sprintf(
+			"ts_headline('english', translate(subject, $%d, ''), %s, $%d)", delimiters, tsQuery, delimiters+1)
+		bodyHighlight = fmt.Sprintf(
+			"ts_headline('english', translate(COALESCE(NULLIF(body, ''), regexp_replace(COALESCE(body_html, ''), '<[^>]+>', ' ', 'g')), $%d, ''), %s, $%d)",
+			delimiters, tsQuery, delimiters+2)
+		orderBy = "rank DESC, received_at DESC"
+	}
+
 	sqlQuery := fmt.Sprintf(`
 		SELECT id, user_id, message_id, thread_id, in_reply_to, references,
 			from_address, from_name, to_addresses, cc_addresses, bcc_addresses,
 			subject, body, body_html, folder_id, is_read, is_starred, is_draft,
 			is_spam, is_deleted, has_attachments, priority, spam_score, size,
-			received_at, sent_at, scheduled_at, read_at, headers, created_at, updated_at
+			received_at, sent_at, scheduled_at, read_at, headers, created_at, updated_at,
+			%s AS rank, %s AS subject_highlight, %s AS body_highlight
 		FROM emails
 		%s
-		ORDER BY received_at DESC
-		LIMIT $3 OFFSET $4
-	`, whereClause)
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d
+	`, rankExpr, subjectHighlight, bodyHighlight, whereClause, orderBy, len(args)+1, len(args)+2)
 
-	rows, err := r.db.Query(sqlQuery, userID, searchPattern, pageSize, offset)
+	args = append(args, pageSize, offset)
+
+	rows, err := r.db.Query(sqlQuery, args...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -327,6 +422,7 @@ func (r *EmailRepository) Search(userID, query string, page, pageSize int) ([]mo
 	var emails []model.Email
 	for rows.Next() {
 		var email model.Email
+		var highlight model.SearchHighlight
 		err := rows.Scan(
 			&email.ID, &email.UserID, &email.MessageID, &email.ThreadID, &email.InReplyTo,
 			&email.References, &email.From, &email.FromName, &email.To, &email.CC, &email.BCC,
@@ -335,16 +431,203 @@ func (r *EmailRepository) Search(userID, query string, page, pageSize int) ([]mo
 			&email.HasAttachments, &email.Priority, &email.SpamScore, &email.Size,
 			&email.ReceivedAt, &email.SentAt, &email.ScheduledAt, &email.ReadAt,
 			&email.Headers, &email.CreatedAt, &email.UpdatedAt,
+			&highlight.Rank, &highlight.Subject, &highlight.Body,
 		)
 		if err != nil {
 			continue
 		}
+		if highlight.Subject != "" || highlight.Body != "" {
+			highlight.Subject = highlightSnippet(highlight.Subject)
+			highlight.Body = highlightSnippet(highlight.Body)
+			email.Highlight = &highlight
+		}
 		emails = append(emails, email)
 	}
 
 	return emails, total, nil
 }
 
+// SearchIDs returns the IDs of all emails matching a query, oldest first.
+// It is used by IMAP SEARCH, which needs the full result set.
+func (r *EmailRepository) SearchIDs(userID string, q *search.Query) ([]string, error) {
+	args := []interface{}{userID}
+	whereClause := "WHERE user_id = $1 AND is_deleted = false" + buildSearchClause(q, &args)
+
+	rows, err := r.db.Query(fmt.Sprintf("SELECT id FROM emails %s ORDER BY received_at ASC", whereClause), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// QuerySortColumns maps the sort keys accepted by QueryIDs to SQL expressions
+var QuerySortColumns = map[string]string{
+	"receivedAt": "received_at",
+	"sentAt":     "COALESCE(sent_at, received_at)",
+	"size":       "size",
+	"from":       "LOWER(COALESCE(NULLIF(from_name, ''), from_address))",
+	"subject":    "LOWER(subject)",
+}
+
+// QueryIDs returns one page of the IDs of emails matching a query, in the
+// given sort order, and the total number of matches. With collapseThreads
+// only the first matching email of each thread is returned. A negative
+// limit returns every match from offset on.
+func (r *EmailRepository) QueryIDs(userID string, q *search.Query, sortBy string, ascending, collapseThreads bool, offset, limit int) ([]string, int, error) {
+	sortColumn, ok := QuerySortColumns[sortBy]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported sort: %s", sortBy)
+	}
+	direction := "DESC"
+	if ascending {
+		direction = "ASC"
+	}
+	orderBy := fmt.Sprintf("%s %s, id %s", sortColumn, direction, direction)
+
+	args := []interface{}{userID}
+	whereClause := "WHERE user_id = $1 AND is_deleted = false" + buildSearchClause(q, &args)
+
+	source := fmt.Sprintf("SELECT id, %s AS sort_key FROM emails %s", sortColumn, whereClause)
+	if collapseThreads {
+		source = fmt.Sprintf(`
+			SELECT id, sort_key FROM (
+				SELECT id, %s AS sort_key, ROW_NUMBER() OVER (PARTITION BY thread_id ORDER BY %s) AS thread_rank
+				FROM emails %s
+			) ranked
+			WHERE thread_rank = 1`, sortColumn, orderBy, whereClause)
+	}
+
+	var total int
+	if err := r.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM (%s) matches", source), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf("SELECT id FROM (%s) matches ORDER BY sort_key %s, id %s OFFSET %d", source, direction, direction, offset)
+	if limit >= 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, total, rows.Err()
+}
+
+// buildSearchClause translates query terms into SQL conditions, appending
+// bind values to args. The returned string starts with " AND" when non-empty.
+func buildSearchClause(q *search.Query, args *[]interface{}) string {
+	var conditions []string
+
+	bind := func(value interface{}) string {
+		*args = append(*args, value)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+	like := func(value string) string {
+		return bind("%" + value + "%")
+	}
+
+	if text := q.WebSearch(); text != "" {
+		conditions = append(conditions, fmt.Sprintf("search_vector @@ websearch_to_tsquery('english', %s)", bind(text)))
+	}
+
+	for _, term := range q.Terms {
+		var cond string
+
+		switch term.Field {
+		case search.FieldFrom:
+			p := like(term.Value)
+			cond = fmt.Sprintf("(from_address ILIKE %s OR from_name ILIKE %s)", p, p)
+		case search.FieldTo:
+			cond = fmt.Sprintf("to_addresses::text ILIKE %s", like(term.Value))
+		case search.FieldCC:
+			cond = fmt.Sprintf("cc_addresses::text ILIKE %s", like(term.Value))
+		case search.FieldBCC:
+			cond = fmt.Sprintf("bcc_addresses::text ILIKE %s", like(term.Value))
+		case search.FieldSubject:
+			cond = fmt.Sprintf("subject ILIKE %s", like(term.Value))
+		case search.FieldFilename:
+			cond = fmt.Sprintf(
+				"EXISTS (SELECT 1 FROM attachments a WHERE a.email_id = emails.id AND a.filename ILIKE %s)", like(term.Value))
+		case search.FieldLabel:
+			cond = fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM email_labels el INNER JOIN labels l ON l.id = el.label_id
+				WHERE el.email_id = emails.id AND (l.id = %[1]s OR LOWER(l.name) = LOWER(%[1]s)))`, bind(term.Value))
+		case search.FieldIn:
+			if term.Value == "anywhere" || term.Value == "all" {
+				continue
+			}
+			cond = fmt.Sprintf(
+				"folder_id IN (SELECT id FROM folders WHERE user_id = $1 AND (id = %[1]s OR type = %[1]s OR LOWER(name) = %[1]s))",
+				bind(term.Value))
+		case search.FieldHas:
+			cond = "has_attachments = true"
+		case search.FieldIs:
+			switch term.Value {
+			case "unread":
+				cond = "is_read = false"
+			case "read":
+				cond = "is_read = true"
+			case "starred":
+				cond = "is_starred = true"
+			case "unstarred":
+				cond = "is_starred = false"
+			case "draft":
+				cond = "is_draft = true"
+			}
+		case search.FieldBefore:
+			cond = fmt.Sprintf("received_at < %s", bind(term.Time))
+		case search.FieldAfter:
+			cond = fmt.Sprintf("received_at >= %s", bind(term.Time))
+		case search.FieldLarger:
+			cond = fmt.Sprintf("size > %s", bind(term.Size))
+		case search.FieldSmaller:
+			cond = fmt.Sprintf("size < %s", bind(term.Size))
+		}
+
+		if cond == "" {
+			continue
+		}
+		if term.Negated {
+			cond = "NOT " + cond
+		}
+		conditions = append(conditions, cond)
+	}
+
+	// Like Gmail, spam and trash are only searched when asked for explicitly
+	if !q.HasField(search.FieldIn) {
+		conditions = append(conditions,
+			"folder_id NOT IN (SELECT id FROM folders WHERE user_id = $1 AND type IN ('spam', 'trash'))")
+	}
+
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " AND " + strings.Join(conditions, " AND ")
+}
+
 // Attachment operations
 
 func (r *EmailRepository) CreateAttachment(attachment *model.Attachment) error {
@@ -353,22 +636,31 @@ func (r *EmailRepository) CreateAttachment(attachment *model.Attachment) error {
 	}
 
 	query := `
-		INSERT INTO attachments (id, email_id, filename, content_type, size, storage_path, content_id, is_inline, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO attachments (
+			id, email_id, filename, content_type, size, storage_path, content_id, is_inline,
+			extracted_text, scan_status, scan_result, scanned_at, created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 		RETURNING id, created_at
 	`
 
+	if attachment.ScanStatus == "" {
+		attachment.ScanStatus = model.ScanStatusUnscanned
+	}
+
 	return r.db.QueryRow(
 		query,
 		attachment.ID, attachment.EmailID, attachment.Filename, attachment.ContentType,
 		attachment.Size, attachment.StoragePath, attachment.ContentID, attachment.IsInline,
+		attachment.ExtractedText, attachment.ScanStatus, attachment.ScanResult, attachment.ScannedAt,
 		time.Now(),
 	).Scan(&attachment.ID, &attachment.CreatedAt)
 }
 
 func (r *EmailRepository) GetAttachments(emailID string) ([]model.Attachment, error) {
 	query := `
-		SELECT id, email_id, filename, content_type, size, storage_path, content_id, is_inline, created_at
+		SELECT id, email_id, filename, content_type, size, storage_path, content_id, is_inline,
+			scan_status, scan_result, scanned_at, created_at
 		FROM attachments
 		WHERE email_id = $1
 		ORDER BY created_at ASC
@@ -385,7 +677,8 @@ func (r *EmailRepository) GetAttachments(emailID string) ([]model.Attachment, er
 		var att model.Attachment
 		err := rows.Scan(
 			&att.ID, &att.EmailID, &att.Filename, &att.ContentType,
-			&att.Size, &att.StoragePath, &att.ContentID, &att.IsInline, &att.CreatedAt,
+			&att.Size, &att.StoragePath, &att.ContentID, &att.IsInline,
+			&att.ScanStatus, &att.ScanResult, &att.ScannedAt, &att.CreatedAt,
 		)
 		if err != nil {
 			continue
@@ -396,17 +689,51 @@ func (r *EmailRepository) GetAttachments(emailID string) ([]model.Attachment, er
 	return attachments, nil
 }
 
+// GetAttachmentByID retrieves an attachment if its email belongs to the user
+func (r *EmailRepository) GetAttachmentByID(attachmentID, userID string) (*model.Attachment, error) {
+	att := &model.Attachment{}
+	query := `
+		SELECT a.id, a.email_id, a.filename, a.content_type, a.size, a.storage_path, a.content_id, a.is_inline,
+			a.scan_status, a.scan_result, a.scanned_at, a.created_at
+		FROM attachments a
+		INNER JOIN emails e ON e.id = a.email_id
+		WHERE a.id = $1 AND e.user_id = $2 AND e.is_deleted = false
+	`
+
+	err := r.db.QueryRow(query, attachmentID, userID).Scan(
+		&att.ID, &att.EmailID, &att.Filename, &att.ContentType,
+		&att.Size, &att.StoragePath, &att.ContentID, &att.IsInline,
+		&att.ScanStatus, &att.ScanResult, &att.ScannedAt, &att.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return att, nil
+}
+
 // Label operations
 
-func (r *EmailRepository) AddLabel(emailID, labelID string) error {
-	query := `INSERT INTO email_labels (email_id, label_id, created_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
-	_, err := r.db.Exec(query, emailID, labelID, time.Now())
+// AddLabel labels an email; both the email and the label must belong to the user
+func (r *EmailRepository) AddLabel(emailID, labelID, userID string) error {
+	query := `
+		INSERT INTO email_labels (email_id, label_id, created_at)
+		SELECT e.id, l.id, $4
+		FROM emails e, labels l
+		WHERE e.id = $1 AND e.user_id = $3 AND l.id = $2 AND l.user_id = $3
+		ON CONFLICT DO NOTHING
+	`
+	_, err := r.db.Exec(query, emailID, labelID, userID, time.Now())
 	return err
 }
 
-func (r *EmailRepository) RemoveLabel(emailID, labelID string) error {
-	query := `DELETE FROM email_labels WHERE email_id = $1 AND label_id = $2`
-	_, err := r.db.Exec(query, emailID, labelID)
+func (r *EmailRepository) RemoveLabel(emailID, labelID, userID string) error {
+	query := `
+		DELETE FROM email_labels
+		WHERE email_id = $1 AND label_id = $2
+			AND EXISTS (SELECT 1 FROM emails WHERE id = $1 AND user_id = $3)
+	`
+	_, err := r.db.Exec(query, emailID, labelID, userID)
 	return err
 }
 
@@ -0,0 +1,23 @@
+package repository
+
+import "testing"
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		snippet string
+		want    string
+	}{
+		{"", ""},
+		{"plain text", "plain text"},
+		{"the \x02quarterly\x03 \x02report\x03", "the <mark>quarterly</mark> <mark>report</mark>"},
+		{"<script>alert(\"x\")</script> \x02hit\x03", "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; <mark>hit</mark>"},
+		{"<img src=x onerror=alert(1)> \x02hit\x03 & more", "&lt;img src=x onerror=alert(1)&gt; <mark>hit</mark> &amp; more"},
+		{"a <mark>fake</mark> mark", "a &lt;mark&gt;fake&lt;/mark&gt; mark"},
+	}
+
+	for _, tt := range tests {
+		if got := highlightSnippet(tt.snippet); got != tt.want {
+			t.Errorf("highlightSnippet(%q) = %q, want %q", tt.snippet, got, tt.want)
+		}
+	}
+}
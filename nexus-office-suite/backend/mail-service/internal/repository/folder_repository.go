@@ -226,6 +226,32 @@ func (r *FolderRepository) InitializeDefaultFolders(userID string) error {
 	return nil
 }
 
+// systemFolders are folders created on first use rather than at sign-up
+var systemFolders = map[string]model.Folder{
+	"quarantine": {Name: "Quarantine", Type: "quarantine", Icon: "gpp_bad", Color: "#b71c1c", Order: 7},
+	"archive":    {Name: "Archive", Type: "archive", Icon: "archive", Color: "#546e7a", Order: 8},
+	"moderation": {Name: "Awaiting Moderation", Type: "moderation", Icon: "pending", Color: "#ef6c00", Order: 9},
+}
+
+// GetOrCreateByType retrieves a system folder by type, creating it if missing
+func (r *FolderRepository) GetOrCreateByType(folderType, userID string) (*model.Folder, error) {
+	folder, err := r.GetByType(folderType, userID)
+	if err == nil || err != sql.ErrNoRows {
+		return folder, err
+	}
+
+	template, ok := systemFolders[folderType]
+	if !ok {
+		return nil, err
+	}
+
+	template.UserID = userID
+	if err := r.Create(&template); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
 // GetUnreadCount gets the unread email count for a folder
 func (r *FolderRepository) GetUnreadCount(folderID, userID string) int {
 	var count int
@@ -250,6 +276,18 @@ func (r *FolderRepository) GetTotalCount(folderID, userID string) int {
 	return count
 }
 
+// GetThreadCounts gets the number of threads with mail in a folder, and of
+// those with unread mail there
+func (r *FolderRepository) GetThreadCounts(folderID, userID string) (total, unread int) {
+	query := `
+		SELECT COUNT(DISTINCT thread_id), COUNT(DISTINCT thread_id) FILTER (WHERE is_read = false)
+		FROM emails
+		WHERE folder_id = $1 AND user_id = $2 AND is_deleted = false
+	`
+	r.db.QueryRow(query, folderID, userID).Scan(&total, &unread)
+	return total, unread
+}
+
 // Label Repository
 
 type LabelRepository struct {
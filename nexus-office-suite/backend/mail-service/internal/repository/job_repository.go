@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"nexus-mail-service/internal/model"
+
+	"github.com/google/uuid"
+)
+
+type JobRepository struct {
+	db *sql.DB
+}
+
+func NewJobRepository(db *sql.DB) *JobRepository {
+	return &JobRepository{db: db}
+}
+
+// Create creates a new mailbox job
+func (r *JobRepository) Create(job *model.MailboxJob) error {
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	if job.Status == "" {
+		job.Status = model.JobStatusPending
+	}
+
+	now := time.Now()
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	query := `
+		INSERT INTO mailbox_jobs (
+			id, user_id, tenant_id, type, format, folder_id, status, storage_path, filename, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+
+	_, err := r.db.Exec(
+		query,
+		job.ID, job.UserID, job.TenantID, job.Type, job.Format, job.FolderID, job.Status,
+		job.StoragePath, job.Filename, job.CreatedAt, job.UpdatedAt,
+	)
+
+	return err
+}
+
+// GetByID retrieves a job by ID
+func (r *JobRepository) GetByID(jobID, userID string) (*model.MailboxJob, error) {
+	job := &model.MailboxJob{}
+	query := `
+		SELECT id, user_id, tenant_id, type, format, folder_id, status, total, processed, imported,
+			skipped, failed, error, storage_path, filename, created_at, updated_at, completed_at
+		FROM mailbox_jobs
+		WHERE id = $1 AND user_id = $2
+	`
+
+	err := r.db.QueryRow(query, jobID, userID).Scan(
+		&job.ID, &job.UserID, &job.TenantID, &job.Type, &job.Format, &job.FolderID, &job.Status,
+		&job.Total, &job.Processed, &job.Imported, &job.Skipped, &job.Failed, &job.Error,
+		&job.StoragePath, &job.Filename, &job.CreatedAt, &job.UpdatedAt, &job.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// List retrieves a user's jobs, newest first
+func (r *JobRepository) List(userID string) ([]model.MailboxJob, error) {
+	query := `
+		SELECT id, user_id, tenant_id, type, format, folder_id, status, total, processed, imported,
+			skipped, failed, error, storage_path, filename, created_at, updated_at, completed_at
+		FROM mailbox_jobs
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT 100
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []model.MailboxJob
+	for rows.Next() {
+		var job model.MailboxJob
+		err := rows.Scan(
+			&job.ID, &job.UserID, &job.TenantID, &job.Type, &job.Format, &job.FolderID, &job.Status,
+			&job.Total, &job.Processed, &job.Imported, &job.Skipped, &job.Failed, &job.Error,
+			&job.StoragePath, &job.Filename, &job.CreatedAt, &job.UpdatedAt, &job.CompletedAt,
+		)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// UpdateProgress stores a running job's counters
+func (r *JobRepository) UpdateProgress(job *model.MailboxJob) error {
+	job.UpdatedAt = time.Now()
+
+	query := `
+		UPDATE mailbox_jobs SET
+			status = $1, total = $2, processed = $3, imported = $4, skipped = $5, failed = $6, updated_at = $7
+		WHERE id = $8
+	`
+
+	_, err := r.db.Exec(
+		query,
+		job.Status, job.Total, job.Processed, job.Imported, job.Skipped, job.Failed, job.UpdatedAt, job.ID,
+	)
+
+	return err
+}
+
+// Finish marks a job completed or failed
+func (r *JobRepository) Finish(job *model.MailboxJob) error {
+	now := time.Now()
+	job.UpdatedAt = now
+	job.CompletedAt = &now
+
+	query := `
+		UPDATE mailbox_jobs SET
+			status = $1, total = $2, processed = $3, imported = $4, skipped = $5, failed = $6,
+			error = $7, storage_path = $8, filename = $9, updated_at = $10, completed_at = $11
+		WHERE id = $12
+	`
+
+	_, err := r.db.Exec(
+		query,
+		job.Status, job.Total, job.Processed, job.Imported, job.Skipped, job.Failed,
+		job.Error, job.StoragePath, job.Filename, job.UpdatedAt, job.CompletedAt, job.ID,
+	)
+
+	return err
+}
+
+// FailInterrupted fails jobs left running or pending by a previous process
+func (r *JobRepository) FailInterrupted() (int64, error) {
+	result, err := r.db.Exec(`
+		UPDATE mailbox_jobs SET status = $1, error = $2, updated_at = $3, completed_at = $3
+		WHERE status IN ($4, $5)
+	`, model.JobStatusFailed, "interrupted by service restart", time.Now(), model.JobStatusPending, model.JobStatusRunning)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
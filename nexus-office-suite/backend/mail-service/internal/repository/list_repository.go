@@ -0,0 +1,237 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"nexus-mail-service/internal/model"
+
+	"github.com/google/uuid"
+)
+
+type ListRepository struct {
+	db *sql.DB
+}
+
+func NewListRepository(db *sql.DB) *ListRepository {
+	return &ListRepository{db: db}
+}
+
+const listColumns = `id, tenant_id, address, name, description, posting_policy, subject_prefix,
+	archive_enabled, created_by, created_at, updated_at`
+
+func scanList(row interface{ Scan(...interface{}) error }, list *model.DistributionList) error {
+	return row.Scan(
+		&list.ID, &list.TenantID, &list.Address, &list.Name, &list.Description,
+		&list.PostingPolicy, &list.SubjectPrefix, &list.ArchiveEnabled, &list.CreatedBy,
+		&list.CreatedAt, &list.UpdatedAt,
+	)
+}
+
+// Create creates a distribution list
+func (r *ListRepository) Create(list *model.DistributionList) error {
+	if list.ID == "" {
+		list.ID = uuid.New().String()
+	}
+	list.Address = strings.ToLower(list.Address)
+
+	now := time.Now()
+	list.CreatedAt = now
+	list.UpdatedAt = now
+
+	query := `
+		INSERT INTO distribution_lists (` + listColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+
+	_, err := r.db.Exec(
+		query,
+		list.ID, list.TenantID, list.Address, list.Name, list.Description, list.PostingPolicy,
+		list.SubjectPrefix, list.ArchiveEnabled, list.CreatedBy, list.CreatedAt, list.UpdatedAt,
+	)
+	return err
+}
+
+// GetByID retrieves a list of a tenant
+func (r *ListRepository) GetByID(listID, tenantID string) (*model.DistributionList, error) {
+	list := &model.DistributionList{}
+	query := `SELECT ` + listColumns + ` FROM distribution_lists WHERE id = $1 AND tenant_id = $2`
+	if err := scanList(r.db.QueryRow(query, listID, tenantID), list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Get retrieves a list regardless of tenant, for background jobs
+func (r *ListRepository) Get(listID string) (*model.DistributionList, error) {
+	list := &model.DistributionList{}
+	query := `SELECT ` + listColumns + ` FROM distribution_lists WHERE id = $1`
+	if err := scanList(r.db.QueryRow(query, listID), list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// GetByAddress retrieves the list with a posting address
+func (r *ListRepository) GetByAddress(address string) (*model.DistributionList, error) {
+	list := &model.DistributionList{}
+	query := `SELECT ` + listColumns + ` FROM distribution_lists WHERE address = $1`
+	if err := scanList(r.db.QueryRow(query, strings.ToLower(address)), list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ListByTenant lists a tenant's distribution lists
+func (r *ListRepository) ListByTenant(tenantID string) ([]model.DistributionList, error) {
+	query := `SELECT ` + listColumns + ` FROM distribution_lists WHERE tenant_id = $1 ORDER BY name ASC`
+
+	rows, err := r.db.Query(query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lists []model.DistributionList
+	for rows.Next() {
+		var list model.DistributionList
+		if err := scanList(rows, &list); err != nil {
+			continue
+		}
+		lists = append(lists, list)
+	}
+
+	return lists, nil
+}
+
+// Update updates a list's settings; the address cannot change
+func (r *ListRepository) Update(list *model.DistributionList) error {
+	list.UpdatedAt = time.Now()
+
+	query := `
+		UPDATE distribution_lists SET
+			name = $1, description = $2, posting_policy = $3, subject_prefix = $4,
+			archive_enabled = $5, updated_at = $6
+		WHERE id = $7 AND tenant_id = $8
+	`
+
+	_, err := r.db.Exec(
+		query,
+		list.Name, list.Description, list.PostingPolicy, list.SubjectPrefix,
+		list.ArchiveEnabled, list.UpdatedAt, list.ID, list.TenantID,
+	)
+	return err
+}
+
+// Delete deletes a list together with its members
+func (r *ListRepository) Delete(listID, tenantID string) error {
+	_, err := r.db.Exec(`DELETE FROM distribution_lists WHERE id = $1 AND tenant_id = $2`, listID, tenantID)
+	return err
+}
+
+// Members
+
+const memberColumns = `id, list_id, address, user_id, role, delivery, last_digest_at, created_at, updated_at`
+
+func scanMember(row interface{ Scan(...interface{}) error }, m *model.ListMember) error {
+	return row.Scan(
+		&m.ID, &m.ListID, &m.Address, &m.UserID, &m.Role, &m.Delivery,
+		&m.LastDigestAt, &m.CreatedAt, &m.UpdatedAt,
+	)
+}
+
+// UpsertMember adds a member to a list, or updates their role and delivery
+func (r *ListRepository) UpsertMember(member *model.ListMember) error {
+	if member.ID == "" {
+		member.ID = uuid.New().String()
+	}
+	member.Address = strings.ToLower(member.Address)
+
+	now := time.Now()
+	member.LastDigestAt = now // digests start from when the member joined
+	member.CreatedAt = now
+	member.UpdatedAt = now
+
+	query := `
+		INSERT INTO distribution_list_members (` + memberColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (list_id, address) DO UPDATE SET
+			user_id = EXCLUDED.user_id,
+			role = EXCLUDED.role,
+			delivery = EXCLUDED.delivery,
+			updated_at = EXCLUDED.updated_at
+		RETURNING ` + memberColumns
+
+	return scanMember(r.db.QueryRow(
+		query,
+		member.ID, member.ListID, member.Address, member.UserID, member.Role, member.Delivery,
+		member.LastDigestAt, member.CreatedAt, member.UpdatedAt,
+	), member)
+}
+
+// GetMember retrieves a member of a list by member ID
+func (r *ListRepository) GetMember(listID, memberID string) (*model.ListMember, error) {
+	member := &model.ListMember{}
+	query := `SELECT ` + memberColumns + ` FROM distribution_list_members WHERE list_id = $1 AND id = $2`
+	if err := scanMember(r.db.QueryRow(query, listID, memberID), member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// FindMember looks up a list member by local user ID or by address
+func (r *ListRepository) FindMember(listID, userID, address string) (*model.ListMember, error) {
+	member := &model.ListMember{}
+	query := `
+		SELECT ` + memberColumns + ` FROM distribution_list_members
+		WHERE list_id = $1 AND ((user_id = $2 AND $2 <> '') OR address = $3)
+		ORDER BY (user_id = $2) DESC NULLS LAST
+		LIMIT 1
+	`
+	if err := scanMember(r.db.QueryRow(query, listID, userID, strings.ToLower(address)), member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// ListMembers lists the members of a list
+func (r *ListRepository) ListMembers(listID string) ([]model.ListMember, error) {
+	return r.listMembers(`WHERE list_id = $1 ORDER BY address ASC`, listID)
+}
+
+// DigestsDue lists digest members whose last digest is older than before
+func (r *ListRepository) DigestsDue(before time.Time) ([]model.ListMember, error) {
+	return r.listMembers(`WHERE delivery = 'digest' AND last_digest_at <= $1 ORDER BY list_id`, before)
+}
+
+// MarkDigestSent records that a member has been sent everything up to sentAt
+func (r *ListRepository) MarkDigestSent(memberID string, sentAt time.Time) error {
+	_, err := r.db.Exec(`UPDATE distribution_list_members SET last_digest_at = $1 WHERE id = $2`, sentAt, memberID)
+	return err
+}
+
+// RemoveMember removes a member from a list
+func (r *ListRepository) RemoveMember(listID, memberID string) error {
+	_, err := r.db.Exec(`DELETE FROM distribution_list_members WHERE list_id = $1 AND id = $2`, listID, memberID)
+	return err
+}
+
+func (r *ListRepository) listMembers(where string, arg interface{}) ([]model.ListMember, error) {
+	rows, err := r.db.Query(`SELECT `+memberColumns+` FROM distribution_list_members `+where, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []model.ListMember
+	for rows.Next() {
+		var member model.ListMember
+		if err := scanMember(rows, &member); err != nil {
+			continue
+		}
+		members = append(members, member)
+	}
+
+	return members, nil
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"nexus-mail-service/internal/model"
+)
+
+type PolicyRepository struct {
+	db *sql.DB
+}
+
+func NewPolicyRepository(db *sql.DB) *PolicyRepository {
+	return &PolicyRepository{db: db}
+}
+
+// GetAttachmentPolicy retrieves a tenant's attachment policy.
+// Returns sql.ErrNoRows when the tenant has no policy of its own.
+func (r *PolicyRepository) GetAttachmentPolicy(tenantID string) (*model.AttachmentPolicy, error) {
+	policy := &model.AttachmentPolicy{}
+	query := `
+		SELECT tenant_id, scan_enabled, blocked_extensions, blocked_mime_types,
+			block_action, scanner_down_action, updated_at
+		FROM attachment_policies
+		WHERE tenant_id = $1
+	`
+
+	err := r.db.QueryRow(query, tenantID).Scan(
+		&policy.TenantID, &policy.ScanEnabled, &policy.BlockedExtensions, &policy.BlockedMimeTypes,
+		&policy.BlockAction, &policy.ScannerDownAction, &policy.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// UpsertAttachmentPolicy creates or replaces a tenant's attachment policy
+func (r *PolicyRepository) UpsertAttachmentPolicy(policy *model.AttachmentPolicy) error {
+	policy.UpdatedAt = time.Now()
+
+	query := `
+		INSERT INTO attachment_policies (
+			tenant_id, scan_enabled, blocked_extensions, blocked_mime_types,
+			block_action, scanner_down_action, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (tenant_id) DO UPDATE SET
+			scan_enabled = EXCLUDED.scan_enabled,
+			blocked_extensions = EXCLUDED.blocked_extensions,
+			blocked_mime_types = EXCLUDED.blocked_mime_types,
+			block_action = EXCLUDED.block_action,
+			scanner_down_action = EXCLUDED.scanner_down_action,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	_, err := r.db.Exec(
+		query,
+		policy.TenantID, policy.ScanEnabled, policy.BlockedExtensions, policy.BlockedMimeTypes,
+		policy.BlockAction, policy.ScannerDownAction, policy.UpdatedAt,
+	)
+
+	return err
+}
+
+// DeleteAttachmentPolicy removes a tenant's policy, reverting it to the defaults
+func (r *PolicyRepository) DeleteAttachmentPolicy(tenantID string) error {
+	_, err := r.db.Exec(`DELETE FROM attachment_policies WHERE tenant_id = $1`, tenantID)
+	return err
+}
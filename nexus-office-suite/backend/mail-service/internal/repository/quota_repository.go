@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"nexus-mail-service/internal/model"
+)
+
+type QuotaRepository struct {
+	db *sql.DB
+}
+
+func NewQuotaRepository(db *sql.DB) *QuotaRepository {
+	return &QuotaRepository{db: db}
+}
+
+// GetUsage returns a mailbox's storage usage. Mailboxes that never stored
+// mail have zero usage.
+func (r *QuotaRepository) GetUsage(userID string) (*model.MailboxUsage, error) {
+	usage := &model.MailboxUsage{UserID: userID}
+	query := `SELECT tenant_id, storage_bytes, message_count FROM mailbox_usage WHERE user_id = $1`
+
+	err := r.db.QueryRow(query, userID).Scan(&usage.TenantID, &usage.StorageBytes, &usage.MessageCount)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// AssignTenant records the tenant a mailbox belongs to, unless one was
+// recorded already
+func (r *QuotaRepository) AssignTenant(userID, tenantID string) error {
+	query := `
+		INSERT INTO mailbox_usage (user_id, tenant_id) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET tenant_id = EXCLUDED.tenant_id
+		WHERE mailbox_usage.tenant_id IS NULL
+	`
+	_, err := r.db.Exec(query, userID, tenantID)
+	return err
+}
+
+// TenantUsage returns the storage used by all mailboxes of a tenant
+func (r *QuotaRepository) TenantUsage(tenantID string) (int64, error) {
+	var bytes int64
+	query := `SELECT COALESCE(SUM(storage_bytes), 0) FROM mailbox_usage WHERE tenant_id = $1`
+	err := r.db.QueryRow(query, tenantID).Scan(&bytes)
+	return bytes, err
+}
+
+// GetQuota retrieves a quota. Returns sql.ErrNoRows when none is set.
+func (r *QuotaRepository) GetQuota(scope, subjectID string) (*model.MailQuota, error) {
+	quota := &model.MailQuota{}
+	query := `
+		SELECT scope, subject_id, storage_limit_bytes, updated_by, updated_at
+		FROM mail_quotas
+		WHERE scope = $1 AND subject_id = $2
+	`
+
+	err := r.db.QueryRow(query, scope, subjectID).Scan(
+		&quota.Scope, &quota.SubjectID, &quota.StorageLimitBytes, &quota.UpdatedBy, &quota.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return quota, nil
+}
+
+// UpsertQuota creates or replaces a quota
+func (r *QuotaRepository) UpsertQuota(quota *model.MailQuota) error {
+	quota.UpdatedAt = time.Now()
+
+	query := `
+		INSERT INTO mail_quotas (scope, subject_id, storage_limit_bytes, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (scope, subject_id) DO UPDATE SET
+			storage_limit_bytes = EXCLUDED.storage_limit_bytes,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	_, err := r.db.Exec(query, quota.Scope, quota.SubjectID, quota.StorageLimitBytes, quota.UpdatedBy, quota.UpdatedAt)
+	return err
+}
+
+// DeleteQuota removes a quota, reverting a mailbox to the default limit or
+// a tenant to unlimited
+func (r *QuotaRepository) DeleteQuota(scope, subjectID string) error {
+	_, err := r.db.Exec(`DELETE FROM mail_quotas WHERE scope = $1 AND subject_id = $2`, scope, subjectID)
+	return err
+}
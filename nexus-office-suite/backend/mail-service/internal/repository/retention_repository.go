@@ -0,0 +1,237 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"nexus-mail-service/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type RetentionRepository struct {
+	db *sql.DB
+}
+
+func NewRetentionRepository(db *sql.DB) *RetentionRepository {
+	return &RetentionRepository{db: db}
+}
+
+// ListPolicies lists a tenant's retention overrides
+func (r *RetentionRepository) ListPolicies(tenantID string) ([]model.RetentionPolicy, error) {
+	query := `
+		SELECT tenant_id, folder_type, retention_days, updated_by, updated_at
+		FROM retention_policies
+		WHERE tenant_id = $1
+		ORDER BY folder_type ASC
+	`
+
+	rows, err := r.db.Query(query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []model.RetentionPolicy
+	for rows.Next() {
+		var policy model.RetentionPolicy
+		if err := rows.Scan(&policy.TenantID, &policy.FolderType, &policy.RetentionDays, &policy.UpdatedBy, &policy.UpdatedAt); err != nil {
+			continue
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// UpsertPolicy creates or replaces a tenant's retention period for a folder type
+func (r *RetentionRepository) UpsertPolicy(policy *model.RetentionPolicy) error {
+	policy.UpdatedAt = time.Now()
+
+	query := `
+		INSERT INTO retention_policies (tenant_id, folder_type, retention_days, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (tenant_id, folder_type) DO UPDATE SET
+			retention_days = EXCLUDED.retention_days,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	_, err := r.db.Exec(query, policy.TenantID, policy.FolderType, policy.RetentionDays, policy.UpdatedBy, policy.UpdatedAt)
+	return err
+}
+
+// DeletePolicy reverts a tenant's folder type to the default retention period
+func (r *RetentionRepository) DeletePolicy(tenantID, folderType string) error {
+	_, err := r.db.Exec(`DELETE FROM retention_policies WHERE tenant_id = $1 AND folder_type = $2`, tenantID, folderType)
+	return err
+}
+
+// CreateHold places a mailbox under legal hold
+func (r *RetentionRepository) CreateHold(hold *model.LegalHold) error {
+	if hold.ID == "" {
+		hold.ID = uuid.New().String()
+	}
+	hold.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO legal_holds (id, tenant_id, user_id, reason, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err := r.db.Exec(query, hold.ID, hold.TenantID, hold.UserID, hold.Reason, hold.CreatedBy, hold.CreatedAt)
+	return err
+}
+
+// ListHolds lists a tenant's legal holds, newest first
+func (r *RetentionRepository) ListHolds(tenantID string, activeOnly bool) ([]model.LegalHold, error) {
+	query := `
+		SELECT id, tenant_id, user_id, reason, created_by, created_at, released_by, released_at
+		FROM legal_holds
+		WHERE tenant_id = $1 AND (released_at IS NULL OR NOT $2)
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(query, tenantID, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holds []model.LegalHold
+	for rows.Next() {
+		var hold model.LegalHold
+		err := rows.Scan(
+			&hold.ID, &hold.TenantID, &hold.UserID, &hold.Reason, &hold.CreatedBy,
+			&hold.CreatedAt, &hold.ReleasedBy, &hold.ReleasedAt,
+		)
+		if err != nil {
+			continue
+		}
+		holds = append(holds, hold)
+	}
+
+	return holds, nil
+}
+
+// ReleaseHold ends a legal hold. Returns sql.ErrNoRows if the tenant has
+// no active hold with this ID.
+func (r *RetentionRepository) ReleaseHold(holdID, tenantID, releasedBy string) error {
+	query := `
+		UPDATE legal_holds SET released_by = $1, released_at = $2
+		WHERE id = $3 AND tenant_id = $4 AND released_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, releasedBy, time.Now(), holdID, tenantID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ExpiredEmails returns up to limit emails that have been in a folder of
+// folderType for longer than their tenant's retention period (or
+// defaultDays when the tenant has no override). Mailboxes under an active
+// legal hold are skipped.
+func (r *RetentionRepository) ExpiredEmails(folderType string, defaultDays int, now time.Time, limit int) ([]string, error) {
+	query := `
+		SELECT e.id
+		FROM emails e
+		JOIN folders f ON f.id = e.folder_id
+		LEFT JOIN mailbox_usage u ON u.user_id = e.user_id
+		LEFT JOIN retention_policies p ON p.tenant_id = u.tenant_id AND p.folder_type = f.type
+		WHERE f.type = $1
+			AND COALESCE(p.retention_days, $2) > 0
+			AND e.folder_changed_at < $3::timestamp - make_interval(days => COALESCE(p.retention_days, $2))
+			AND NOT EXISTS (
+				SELECT 1 FROM legal_holds h WHERE h.user_id = e.user_id AND h.released_at IS NULL
+			)
+		LIMIT $4
+	`
+
+	rows, err := r.db.Query(query, folderType, defaultDays, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// PurgeEmails permanently deletes emails and returns the storage paths of
+// their attachments that no remaining attachment refers to, together with
+// their stored signed or encrypted originals. Attachment paths can be
+// shared, e.g. by list copies of a post.
+func (r *RetentionRepository) PurgeEmails(emailIDs []string) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var paths []string
+	rows, err := tx.Query(`SELECT DISTINCT storage_path FROM attachments WHERE email_id = ANY($1)`, pq.Array(emailIDs))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	rows.Close()
+
+	var orphaned []string
+	rows, err = tx.Query(`SELECT secure_mime_path FROM emails WHERE id = ANY($1) AND secure_mime_path IS NOT NULL`, pq.Array(emailIDs))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err == nil {
+			orphaned = append(orphaned, path)
+		}
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(`DELETE FROM emails WHERE id = ANY($1)`, pq.Array(emailIDs)); err != nil {
+		return nil, err
+	}
+
+	if len(paths) > 0 {
+		query := `
+			SELECT p FROM unnest($1::text[]) AS p
+			WHERE NOT EXISTS (SELECT 1 FROM attachments a WHERE a.storage_path = p)
+		`
+		rows, err := tx.Query(query, pq.Array(paths))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var path string
+			if err := rows.Scan(&path); err == nil {
+				orphaned = append(orphaned, path)
+			}
+		}
+		rows.Close()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return orphaned, nil
+}
@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"nexus-mail-service/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// TokenCount holds how often a token was seen in spam and ham messages
+type TokenCount struct {
+	Spam int
+	Ham  int
+}
+
+// SpamRepository stores Bayesian spam training data and sender lists
+type SpamRepository struct {
+	db *sql.DB
+}
+
+func NewSpamRepository(db *sql.DB) *SpamRepository {
+	return &SpamRepository{db: db}
+}
+
+// GetCorpus returns the number of spam and ham messages trained for a tenant
+func (r *SpamRepository) GetCorpus(tenantID string) (spam, ham int, err error) {
+	query := `SELECT spam_messages, ham_messages FROM spam_corpus WHERE tenant_id = $1`
+	err = r.db.QueryRow(query, tenantID).Scan(&spam, &ham)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	return spam, ham, err
+}
+
+// GetTokenCounts returns the counts for the given tokens; unknown tokens are omitted
+func (r *SpamRepository) GetTokenCounts(tenantID string, tokens []string) (map[string]TokenCount, error) {
+	counts := make(map[string]TokenCount, len(tokens))
+	if len(tokens) == 0 {
+		return counts, nil
+	}
+
+	query := `
+		SELECT token, spam_count, ham_count
+		FROM spam_tokens
+		WHERE tenant_id = $1 AND token = ANY($2)
+	`
+
+	rows, err := r.db.Query(query, tenantID, pq.Array(tokens))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token string
+		var count TokenCount
+		if err := rows.Scan(&token, &count.Spam, &count.Ham); err != nil {
+			continue
+		}
+		counts[token] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// Train adds (delta = 1) or removes (delta = -1) a message's tokens from the
+// spam or ham corpus of a tenant
+func (r *SpamRepository) Train(tenantID string, tokens []string, isSpam bool, delta int) error {
+	spamDelta, hamDelta := 0, delta
+	if isSpam {
+		spamDelta, hamDelta = delta, 0
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO spam_tokens (tenant_id, token, spam_count, ham_count)
+		SELECT $1, t, GREATEST($3, 0), GREATEST($4, 0) FROM unnest($2::text[]) AS t
+		ON CONFLICT (tenant_id, token) DO UPDATE SET
+			spam_count = GREATEST(spam_tokens.spam_count + $3, 0),
+			ham_count = GREATEST(spam_tokens.ham_count + $4, 0)
+	`, tenantID, pq.Array(tokens), spamDelta, hamDelta)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO spam_corpus (tenant_id, spam_messages, ham_messages, updated_at)
+		VALUES ($1, GREATEST($2, 0), GREATEST($3, 0), NOW())
+		ON CONFLICT (tenant_id) DO UPDATE SET
+			spam_messages = GREATEST(spam_corpus.spam_messages + $2, 0),
+			ham_messages = GREATEST(spam_corpus.ham_messages + $3, 0),
+			updated_at = NOW()
+	`, tenantID, spamDelta, hamDelta)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetTrainedClass returns how an email was last trained ("spam", "ham" or "")
+func (r *SpamRepository) GetTrainedClass(emailID string) (string, error) {
+	var class string
+	err := r.db.QueryRow(`SELECT class FROM spam_training WHERE email_id = $1`, emailID).Scan(&class)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return class, err
+}
+
+// SetTrainedClass records how an email was trained
+func (r *SpamRepository) SetTrainedClass(emailID, tenantID, class string) error {
+	query := `
+		INSERT INTO spam_training (email_id, tenant_id, class, trained_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (email_id) DO UPDATE SET class = EXCLUDED.class, trained_at = EXCLUDED.trained_at
+	`
+	_, err := r.db.Exec(query, emailID, tenantID, class, time.Now())
+	return err
+}
+
+// Sender list operations
+
+// ListSenders returns a user's allow and block list entries
+func (r *SpamRepository) ListSenders(userID string) ([]model.SenderListEntry, error) {
+	query := `
+		SELECT id, user_id, address, list_type, created_at
+		FROM sender_lists
+		WHERE user_id = $1
+		ORDER BY list_type ASC, address ASC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []model.SenderListEntry
+	for rows.Next() {
+		var entry model.SenderListEntry
+		err := rows.Scan(&entry.ID, &entry.UserID, &entry.Address, &entry.ListType, &entry.CreatedAt)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// AddSender adds or moves an address to the user's allow or block list
+func (r *SpamRepository) AddSender(entry *model.SenderListEntry) error {
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	entry.Address = strings.ToLower(strings.TrimSpace(entry.Address))
+
+	query := `
+		INSERT INTO sender_lists (id, user_id, address, list_type, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, address) DO UPDATE SET list_type = EXCLUDED.list_type
+		RETURNING id, created_at
+	`
+
+	return r.db.QueryRow(
+		query, entry.ID, entry.UserID, entry.Address, entry.ListType, time.Now(),
+	).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// RemoveSender removes an entry from the user's sender lists
+func (r *SpamRepository) RemoveSender(entryID, userID string) error {
+	_, err := r.db.Exec(`DELETE FROM sender_lists WHERE id = $1 AND user_id = $2`, entryID, userID)
+	return err
+}
+
+// LookupSender returns the list type ("allow", "block" or "") for a sender,
+// matching the full address first and then its domain
+func (r *SpamRepository) LookupSender(userID, address string) (string, error) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	domain := address
+	if idx := strings.LastIndex(address, "@"); idx >= 0 {
+		domain = address[idx+1:]
+	}
+
+	query := `
+		SELECT list_type
+		FROM sender_lists
+		WHERE user_id = $1 AND address IN ($2, $3, '@' || $3)
+		ORDER BY (address = $2) DESC
+		LIMIT 1
+	`
+
+	var listType string
+	err := r.db.QueryRow(query, userID, address, domain).Scan(&listType)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return listType, err
+}
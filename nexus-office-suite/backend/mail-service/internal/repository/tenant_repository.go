@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+type TenantRepository struct {
+	db *sql.DB
+}
+
+func NewTenantRepository(db *sql.DB) *TenantRepository {
+	return &TenantRepository{db: db}
+}
+
+// TenantForDomain returns the tenant a mail domain is registered to, or ""
+// if none is
+func (r *TenantRepository) TenantForDomain(domain string) (string, error) {
+	var tenantID string
+	query := `SELECT tenant_id FROM tenant_domains WHERE domain = $1`
+
+	err := r.db.QueryRow(query, strings.ToLower(domain)).Scan(&tenantID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return tenantID, nil
+}
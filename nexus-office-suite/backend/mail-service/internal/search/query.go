@@ -0,0 +1,293 @@
+package search
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Field identifies what a search term matches against
+type Field string
+
+const (
+	FieldText     Field = "text"     // full-text (subject, body, participants, attachments)
+	FieldFrom     Field = "from"     // sender address or display name
+	FieldTo       Field = "to"       // To recipients
+	FieldCC       Field = "cc"       // Cc recipients
+	FieldBCC      Field = "bcc"      // Bcc recipients
+	FieldSubject  Field = "subject"  // subject line
+	FieldFilename Field = "filename" // attachment filename
+	FieldLabel    Field = "label"    // label name
+	FieldIn       Field = "in"       // folder type or name (inbox, sent, trash, anywhere, ...)
+	FieldHas      Field = "has"      // attachment
+	FieldIs       Field = "is"       // unread, read, starred, unstarred, draft
+	FieldBefore   Field = "before"   // received strictly before date
+	FieldAfter    Field = "after"    // received on or after date
+	FieldLarger   Field = "larger"   // size in bytes greater than
+	FieldSmaller  Field = "smaller"  // size in bytes smaller than
+)
+
+// operators maps the Gmail-style "op:" prefixes to fields
+var operators = map[string]Field{
+	"from":     FieldFrom,
+	"to":       FieldTo,
+	"cc":       FieldCC,
+	"bcc":      FieldBCC,
+	"subject":  FieldSubject,
+	"filename": FieldFilename,
+	"label":    FieldLabel,
+	"in":       FieldIn,
+	"has":      FieldHas,
+	"is":       FieldIs,
+	"before":   FieldBefore,
+	"older":    FieldBefore,
+	"after":    FieldAfter,
+	"newer":    FieldAfter,
+	"larger":   FieldLarger,
+	"smaller":  FieldSmaller,
+}
+
+// Term is a single search condition
+type Term struct {
+	Field   Field
+	Value   string
+	Negated bool
+	Phrase  bool // value was quoted
+
+	// Parsed values for date and size fields
+	Time time.Time
+	Size int64
+}
+
+// Query is a parsed mail search query. All terms must match.
+type Query struct {
+	Terms []Term
+}
+
+// Parse parses a Gmail-style search string such as
+// `from:alice has:attachment "quarterly report" -draft after:2024/01/01`.
+// Operators with values that cannot be parsed (bad dates, sizes) are
+// treated as free text so that nothing the user typed is silently dropped.
+func Parse(input string) *Query {
+	q := &Query{}
+
+	for _, tok := range tokenize(input) {
+		term := Term{Field: FieldText, Value: tok.value, Negated: tok.negated, Phrase: tok.quoted}
+
+		if !tok.quoted {
+			if idx := strings.Index(tok.value, ":"); idx > 0 {
+				op := strings.ToLower(tok.value[:idx])
+				value := strings.Trim(tok.value[idx+1:], `"`)
+				if field, ok := operators[op]; ok && value != "" {
+					term.Field = field
+					term.Value = value
+					term.Phrase = tok.opQuoted
+					if !term.parseValue() {
+						term = Term{Field: FieldText, Value: tok.value, Negated: tok.negated}
+					}
+				}
+			}
+		}
+
+		q.Terms = append(q.Terms, term)
+	}
+
+	return q
+}
+
+// Add appends a term to the query
+func (q *Query) Add(field Field, value string) {
+	term := Term{Field: field, Value: value}
+	if term.parseValue() {
+		q.Terms = append(q.Terms, term)
+	}
+}
+
+// AddTime appends a date term to the query
+func (q *Query) AddTime(field Field, t time.Time) {
+	q.Terms = append(q.Terms, Term{Field: field, Value: t.Format("2006-01-02"), Time: t})
+}
+
+// TextTerms returns the free-text terms of the query
+func (q *Query) TextTerms() []Term {
+	var terms []Term
+	for _, t := range q.Terms {
+		if t.Field == FieldText {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// HasField reports whether the query contains a term for the field
+func (q *Query) HasField(field Field) bool {
+	for _, t := range q.Terms {
+		if t.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// WebSearch renders the free-text terms in PostgreSQL websearch_to_tsquery
+// syntax. It returns an empty string when the query has no free text.
+func (q *Query) WebSearch() string {
+	var parts []string
+	for _, t := range q.TextTerms() {
+		value := strings.ReplaceAll(t.Value, `"`, " ")
+		if t.Phrase {
+			value = `"` + value + `"`
+		}
+		if t.Negated {
+			value = "-" + value
+		}
+		parts = append(parts, value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// String renders the query back into search syntax
+func (q *Query) String() string {
+	parts := make([]string, 0, len(q.Terms))
+	for _, t := range q.Terms {
+		value := t.Value
+		if t.Phrase || strings.ContainsFunc(value, unicode.IsSpace) {
+			value = `"` + value + `"`
+		}
+		if t.Field != FieldText {
+			value = string(t.Field) + ":" + value
+		}
+		if t.Negated {
+			value = "-" + value
+		}
+		parts = append(parts, value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseValue validates and normalizes the term value for its field
+func (t *Term) parseValue() bool {
+	switch t.Field {
+	case FieldBefore, FieldAfter:
+		parsed, ok := parseDate(t.Value)
+		if !ok {
+			return false
+		}
+		t.Time = parsed
+	case FieldLarger, FieldSmaller:
+		size, ok := parseSize(t.Value)
+		if !ok {
+			return false
+		}
+		t.Size = size
+	case FieldHas:
+		t.Value = strings.ToLower(t.Value)
+		return t.Value == "attachment" || t.Value == "attachments"
+	case FieldIs:
+		t.Value = strings.ToLower(t.Value)
+		switch t.Value {
+		case "unread", "read", "starred", "unstarred", "draft":
+		default:
+			return false
+		}
+	case FieldIn:
+		t.Value = strings.ToLower(t.Value)
+	}
+	return true
+}
+
+var dateLayouts = []string{"2006/01/02", "2006-01-02", "2006/1/2", "2006-1-2", "01/02/2006"}
+
+func parseDate(value string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseSize parses sizes such as 1048576, 500K, 10M or 1G
+func parseSize(value string) (int64, bool) {
+	value = strings.ToUpper(strings.TrimSuffix(strings.ToUpper(value), "B"))
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return int64(n * float64(multiplier)), true
+}
+
+type token struct {
+	value    string
+	negated  bool
+	quoted   bool // the whole token was a quoted phrase
+	opQuoted bool // the operator value was quoted (from:"Jane Doe")
+}
+
+// tokenize splits the input on whitespace, keeping quoted phrases together
+// and recognising a leading "-" as negation
+func tokenize(input string) []token {
+	var tokens []token
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		tok := token{}
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			tok.negated = true
+			i++
+		}
+
+		if runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			tok.value = string(runes[i+1 : min(end, len(runes))])
+			tok.quoted = true
+			i = end + 1
+		} else {
+			var b strings.Builder
+			for i < len(runes) && !unicode.IsSpace(runes[i]) {
+				if runes[i] == '"' {
+					// Quoted operator value, e.g. from:"Jane Doe"
+					tok.opQuoted = true
+					i++
+					for i < len(runes) && runes[i] != '"' {
+						b.WriteRune(runes[i])
+						i++
+					}
+					i++
+					continue
+				}
+				b.WriteRune(runes[i])
+				i++
+			}
+			tok.value = b.String()
+		}
+
+		if strings.TrimSpace(tok.value) != "" {
+			tokens = append(tokens, tok)
+		}
+	}
+
+	return tokens
+}
@@ -0,0 +1,182 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  []Term
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  nil,
+		},
+		{
+			name:  "whitespace only",
+			input: "   \t ",
+			want:  nil,
+		},
+		{
+			name:  "free text",
+			input: "quarterly report",
+			want: []Term{
+				{Field: FieldText, Value: "quarterly"},
+				{Field: FieldText, Value: "report"},
+			},
+		},
+		{
+			name:  "quoted phrase",
+			input: `"quarterly report" draft`,
+			want: []Term{
+				{Field: FieldText, Value: "quarterly report", Phrase: true},
+				{Field: FieldText, Value: "draft"},
+			},
+		},
+		{
+			name:  "unterminated phrase",
+			input: `"quarterly report`,
+			want: []Term{
+				{Field: FieldText, Value: "quarterly report", Phrase: true},
+			},
+		},
+		{
+			name:  "negation",
+			input: `-draft -"out of office"`,
+			want: []Term{
+				{Field: FieldText, Value: "draft", Negated: true},
+				{Field: FieldText, Value: "out of office", Negated: true, Phrase: true},
+			},
+		},
+		{
+			name:  "lone dash is text",
+			input: "a - b",
+			want: []Term{
+				{Field: FieldText, Value: "a"},
+				{Field: FieldText, Value: "-"},
+				{Field: FieldText, Value: "b"},
+			},
+		},
+		{
+			name:  "from and to",
+			input: "from:alice@example.com TO:bob",
+			want: []Term{
+				{Field: FieldFrom, Value: "alice@example.com"},
+				{Field: FieldTo, Value: "bob"},
+			},
+		},
+		{
+			name:  "quoted operator value",
+			input: `from:"Jane Doe"`,
+			want: []Term{
+				{Field: FieldFrom, Value: "Jane Doe", Phrase: true},
+			},
+		},
+		{
+			name:  "negated operator",
+			input: "-from:alice",
+			want: []Term{
+				{Field: FieldFrom, Value: "alice", Negated: true},
+			},
+		},
+		{
+			name:  "has attachment",
+			input: "has:Attachment has:attachments",
+			want: []Term{
+				{Field: FieldHas, Value: "attachment"},
+				{Field: FieldHas, Value: "attachments"},
+			},
+		},
+		{
+			name:  "unknown has value is text",
+			input: "has:drive",
+			want: []Term{
+				{Field: FieldText, Value: "has:drive"},
+			},
+		},
+		{
+			name:  "before and after",
+			input: "after:2024/01/01 before:2024-02-15 older:3/4/2024",
+			want: []Term{
+				{Field: FieldAfter, Value: "2024/01/01", Time: date(2024, time.January, 1)},
+				{Field: FieldBefore, Value: "2024-02-15", Time: date(2024, time.February, 15)},
+				{Field: FieldText, Value: "older:3/4/2024"},
+			},
+		},
+		{
+			name:  "bad dates are text",
+			input: "before:yesterday after:2024/13/01 -after:2024-02-30",
+			want: []Term{
+				{Field: FieldText, Value: "before:yesterday"},
+				{Field: FieldText, Value: "after:2024/13/01"},
+				{Field: FieldText, Value: "after:2024-02-30", Negated: true},
+			},
+		},
+		{
+			name:  "unknown operator is text",
+			input: "foo:bar",
+			want: []Term{
+				{Field: FieldText, Value: "foo:bar"},
+			},
+		},
+		{
+			name:  "empty operator value is text",
+			input: "from:",
+			want: []Term{
+				{Field: FieldText, Value: "from:"},
+			},
+		},
+		{
+			name:  "quoted phrase is not an operator",
+			input: `"from:alice"`,
+			want: []Term{
+				{Field: FieldText, Value: "from:alice", Phrase: true},
+			},
+		},
+		{
+			name:  "sizes",
+			input: "larger:10M smaller:500kb larger:big",
+			want: []Term{
+				{Field: FieldLarger, Value: "10M", Size: 10 << 20},
+				{Field: FieldSmaller, Value: "500kb", Size: 500 << 10},
+				{Field: FieldText, Value: "larger:big"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.input)
+			if !reflect.DeepEqual(got.Terms, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.input, got.Terms, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryWebSearch(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{"from:alice has:attachment", ""},
+		{`budget "quarterly report" -draft from:alice`, `budget "quarterly report" -draft`},
+		{`-"out of office"`, `-"out of office"`},
+	}
+
+	for _, tt := range tests {
+		if got := Parse(tt.input).WebSearch(); got != tt.want {
+			t.Errorf("Parse(%q).WebSearch() = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"database/sql"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/gomail.v2"
+)
+
+// noReplyLocalParts are sender mailboxes that never get auto-replies
+var noReplyLocalParts = map[string]bool{
+	"mailer-daemon": true,
+	"postmaster":    true,
+	"noreply":       true,
+	"no-reply":      true,
+	"donotreply":    true,
+	"do-not-reply":  true,
+	"listserv":      true,
+	"majordomo":     true,
+}
+
+// AutoResponderService sends vacation / out-of-office replies to inbound mail
+type AutoResponderService struct {
+	config   *config.Config
+	repo     *repository.AutoResponderRepository
+	calendar *CalendarClient
+	tenants  *TenantDirectory
+}
+
+// NewAutoResponderService creates a new auto-responder; calendar may be nil
+func NewAutoResponderService(
+	cfg *config.Config,
+	repo *repository.AutoResponderRepository,
+	calendar *CalendarClient,
+	tenants *TenantDirectory,
+) *AutoResponderService {
+	return &AutoResponderService{
+		config:   cfg,
+		repo:     repo,
+		calendar: calendar,
+		tenants:  tenants,
+	}
+}
+
+// Respond sends the user's auto-reply for a delivered email, if one is
+// active and RFC 3834 allows it. recipient is the address the mail was
+// delivered to and returnPath the SMTP envelope sender, which is where the
+// reply goes.
+func (s *AutoResponderService) Respond(email *model.Email, recipient, returnPath string) {
+	if reason := autoReplySuppressed(email, recipient, returnPath); reason != "" {
+		log.Debug().Str("emailID", email.ID).Str("reason", reason).Msg("Auto-reply suppressed")
+		return
+	}
+
+	responder, err := s.repo.Get(email.UserID)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("userID", email.UserID).Msg("Failed to load auto-responder")
+		return
+	}
+
+	now := time.Now()
+	active := responder.ActiveAt(now)
+	if !active && responder.UseCalendar && s.calendar != nil {
+		active, err = s.calendar.OutOfOffice(email.UserID, now)
+		if err != nil {
+			log.Warn().Err(err).Str("userID", email.UserID).Msg("Failed to check calendar for out-of-office")
+		}
+	}
+	if !active {
+		return
+	}
+
+	// Senders in the recipient's own tenant are internal
+	senderTenant, err := s.tenants.TenantForAddress(returnPath)
+	if err != nil {
+		log.Error().Err(err).Str("userID", email.UserID).Msg("Failed to resolve sender tenant for auto-reply")
+		return
+	}
+	recipientTenant, err := s.tenants.TenantForAddress(recipient)
+	if err != nil {
+		log.Error().Err(err).Str("userID", email.UserID).Msg("Failed to resolve recipient tenant for auto-reply")
+		return
+	}
+
+	subject, message := responder.Subject, responder.Message
+	if senderTenant != recipientTenant {
+		if !responder.ReplyToExternal {
+			return
+		}
+		if responder.ExternalMessage != "" {
+			subject, message = responder.ExternalSubject, responder.ExternalMessage
+		}
+	}
+	if subject == "" {
+		subject = "Auto: " + email.Subject
+	}
+
+	days := responder.ReplyIntervalDays
+	if days <= 0 {
+		days = model.DefaultReplyIntervalDays
+	}
+
+	claimed, err := s.repo.ClaimReply(email.UserID, returnPath, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		log.Error().Err(err).Str("userID", email.UserID).Msg("Failed to record auto-reply")
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	if err := s.send(email, recipient, returnPath, subject, message); err != nil {
+		log.Error().Err(err).Str("userID", email.UserID).Msg("Failed to send auto-reply")
+		return
+	}
+
+	log.Info().Str("userID", email.UserID).Str("to", returnPath).Msg("Auto-reply sent")
+}
+
+// send delivers the reply with a null envelope sender so that it can never
+// trigger another auto-reply or a bounce loop (RFC 3834 section 3.3)
+func (s *AutoResponderService) send(email *model.Email, recipient, returnPath, subject, message string) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", recipient)
+	m.SetHeader("To", returnPath)
+	m.SetHeader("Subject", subject)
+	m.SetHeader("Auto-Submitted", "auto-replied")
+	m.SetHeader("X-Auto-Response-Suppress", "All")
+	if email.MessageID != "" {
+		m.SetHeader("In-Reply-To", email.MessageID)
+		m.SetHeader("References", strings.TrimSpace(strings.Join(email.References, " ")+" "+email.MessageID))
+	}
+	m.SetBody("text/plain", message)
+
+	d := gomail.NewDialer(s.config.SMTP.Host, 587, "", "")
+	sc, err := d.Dial()
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+
+	return sc.Send("", []string{returnPath}, m)
+}
+
+// autoReplySuppressed returns why an email must not be auto-replied to per
+// RFC 3834 section 2, or "" if a reply is allowed
+func autoReplySuppressed(email *model.Email, recipient, returnPath string) string {
+	if email.IsSpam {
+		return "spam"
+	}
+
+	sender := strings.ToLower(strings.Trim(strings.TrimSpace(returnPath), "<>"))
+	if sender == "" {
+		return "null return path"
+	}
+	if strings.EqualFold(sender, recipient) {
+		return "sent to self"
+	}
+
+	local := sender
+	if idx := strings.LastIndex(sender, "@"); idx >= 0 {
+		local = sender[:idx]
+	}
+	if noReplyLocalParts[local] || strings.HasPrefix(local, "owner-") ||
+		strings.HasSuffix(local, "-request") || strings.HasPrefix(local, "bounce") {
+		return "automated sender"
+	}
+
+	if v := strings.ToLower(headerValue(email.Headers, "Auto-Submitted")); v != "" && v != "no" {
+		return "auto-submitted"
+	}
+
+	switch strings.ToLower(headerValue(email.Headers, "Precedence")) {
+	case "bulk", "list", "junk":
+		return "bulk precedence"
+	}
+
+	for _, key := range []string{"List-Id", "List-Unsubscribe", "List-Post", "List-Help"} {
+		if headerValue(email.Headers, key) != "" {
+			return "mailing list"
+		}
+	}
+
+	suppress := strings.ToLower(headerValue(email.Headers, "X-Auto-Response-Suppress"))
+	if strings.Contains(suppress, "all") || strings.Contains(suppress, "oof") {
+		return "suppressed by sender"
+	}
+
+	// Only reply when the user is named directly, not via Bcc or a list
+	addressed := append(parseEmailList(headerValue(email.Headers, "To")), parseEmailList(headerValue(email.Headers, "Cc"))...)
+	for _, addr := range addressed {
+		if strings.EqualFold(addr, recipient) {
+			return ""
+		}
+	}
+	return "not a direct recipient"
+}
+
+// headerValue returns the first value of a message header
+func headerValue(headers model.Headers, key string) string {
+	values := headers[textproto.CanonicalMIMEHeaderKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
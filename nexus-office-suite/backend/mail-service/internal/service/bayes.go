@@ -0,0 +1,191 @@
+package service
+
+import (
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+)
+
+const (
+	// bayesMinCorpus is the number of spam and ham messages a tenant must
+	// have trained before the classifier's opinion is used
+	bayesMinCorpus = 10
+	// bayesInterestingTokens is how many of the most decisive tokens are combined
+	bayesInterestingTokens = 15
+	// bayesMaxTokens caps the tokens extracted from one message
+	bayesMaxTokens = 1000
+	// Robinson's prior strength and assumed probability for rare tokens
+	bayesPriorStrength = 1.0
+	bayesPriorProb     = 0.5
+)
+
+var (
+	bayesWordPattern = regexp.MustCompile(`[\p{L}\p{N}$€£'-]{3,30}`)
+	bayesURLPattern  = regexp.MustCompile(`https?://[^\s"'<>]+`)
+)
+
+// BayesClassifier is a per-tenant token classifier using Robinson's
+// probability estimate combined with Fisher's chi-square method
+type BayesClassifier struct {
+	spamRepo *repository.SpamRepository
+}
+
+// NewBayesClassifier creates a new classifier
+func NewBayesClassifier(spamRepo *repository.SpamRepository) *BayesClassifier {
+	return &BayesClassifier{spamRepo: spamRepo}
+}
+
+// Classify returns the spam probability (0-1) for an email. ok is false when
+// the tenant has not trained enough messages for a meaningful result.
+func (b *BayesClassifier) Classify(tenantID string, email *model.Email) (float64, bool, error) {
+	spamMsgs, hamMsgs, err := b.spamRepo.GetCorpus(tenantID)
+	if err != nil {
+		return bayesPriorProb, false, err
+	}
+	if spamMsgs < bayesMinCorpus || hamMsgs < bayesMinCorpus {
+		return bayesPriorProb, false, nil
+	}
+
+	tokens := bayesTokens(email)
+	counts, err := b.spamRepo.GetTokenCounts(tenantID, tokens)
+	if err != nil {
+		return bayesPriorProb, false, err
+	}
+
+	probs := make([]float64, 0, len(counts))
+	for _, count := range counts {
+		probs = append(probs, tokenProbability(count, spamMsgs, hamMsgs))
+	}
+
+	return fisherCombine(probs), true, nil
+}
+
+// Train adds an email to the spam or ham corpus, first removing it from the
+// other corpus if it was previously trained the opposite way
+func (b *BayesClassifier) Train(tenantID string, email *model.Email, isSpam bool) error {
+	class := "ham"
+	if isSpam {
+		class = "spam"
+	}
+
+	previous, err := b.spamRepo.GetTrainedClass(email.ID)
+	if err != nil {
+		return err
+	}
+	if previous == class {
+		return nil
+	}
+
+	tokens := bayesTokens(email)
+	if previous != "" {
+		if err := b.spamRepo.Train(tenantID, tokens, previous == "spam", -1); err != nil {
+			return err
+		}
+	}
+	if err := b.spamRepo.Train(tenantID, tokens, isSpam, 1); err != nil {
+		return err
+	}
+
+	return b.spamRepo.SetTrainedClass(email.ID, tenantID, class)
+}
+
+// tokenProbability computes Robinson's f(w) for a token
+func tokenProbability(count repository.TokenCount, spamMsgs, hamMsgs int) float64 {
+	spamRatio := math.Min(float64(count.Spam)/float64(spamMsgs), 1)
+	hamRatio := math.Min(float64(count.Ham)/float64(hamMsgs), 1)
+	if spamRatio+hamRatio == 0 {
+		return bayesPriorProb
+	}
+
+	p := spamRatio / (spamRatio + hamRatio)
+	n := float64(count.Spam + count.Ham)
+	return (bayesPriorStrength*bayesPriorProb + n*p) / (bayesPriorStrength + n)
+}
+
+// fisherCombine combines the most decisive token probabilities into a
+// single spam indicator between 0 (ham) and 1 (spam)
+func fisherCombine(probs []float64) float64 {
+	sort.Slice(probs, func(i, j int) bool {
+		return math.Abs(probs[i]-0.5) > math.Abs(probs[j]-0.5)
+	})
+	if len(probs) > bayesInterestingTokens {
+		probs = probs[:bayesInterestingTokens]
+	}
+	if len(probs) == 0 {
+		return bayesPriorProb
+	}
+
+	var sumLogP, sumLogNotP float64
+	for _, p := range probs {
+		p = math.Min(math.Max(p, 0.01), 0.99)
+		sumLogP += math.Log(p)
+		sumLogNotP += math.Log(1 - p)
+	}
+
+	// Each chi-square test asks how unlikely the evidence would be if the
+	// tokens were random; strong spam evidence makes sumLogNotP very negative
+	n := 2 * len(probs)
+	return (1 + chi2Q(-2*sumLogP, n) - chi2Q(-2*sumLogNotP, n)) / 2
+}
+
+// chi2Q returns the probability that a chi-square value with v (even)
+// degrees of freedom is at least x2
+func chi2Q(x2 float64, v int) float64 {
+	m := x2 / 2
+	sum := math.Exp(-m)
+	term := sum
+	for i := 1; i < v/2; i++ {
+		term *= m / float64(i)
+		sum += term
+	}
+	return math.Min(sum, 1)
+}
+
+// bayesTokens extracts the unique tokens of an email used for classification
+func bayesTokens(email *model.Email) []string {
+	seen := make(map[string]bool)
+	var tokens []string
+	add := func(token string) {
+		if len(tokens) >= bayesMaxTokens || seen[token] {
+			return
+		}
+		seen[token] = true
+		tokens = append(tokens, token)
+	}
+
+	for _, word := range bayesWordPattern.FindAllString(strings.ToLower(email.Subject), -1) {
+		add("subject:" + word)
+	}
+
+	from := strings.ToLower(email.From)
+	if idx := strings.LastIndex(from, "@"); idx >= 0 {
+		add("from:" + from[idx+1:])
+	}
+
+	body := email.Body
+	if body == "" {
+		body = htmlTagPattern.ReplaceAllString(email.BodyHTML, " ")
+	}
+	body = strings.ToLower(body)
+
+	for _, link := range bayesURLPattern.FindAllString(body+" "+strings.ToLower(email.BodyHTML), -1) {
+		if u, err := url.Parse(link); err == nil && u.Host != "" {
+			add("url:" + u.Host)
+		}
+	}
+
+	for _, word := range bayesWordPattern.FindAllString(body, -1) {
+		add(word)
+	}
+
+	for _, att := range email.Attachments {
+		add("attachment:" + strings.ToLower(att.ContentType))
+	}
+
+	return tokens
+}
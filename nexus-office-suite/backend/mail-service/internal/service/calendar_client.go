@@ -0,0 +1,85 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/middleware"
+)
+
+// busyStatusOutOfOffice matches the calendar-service event BusyStatus
+const busyStatusOutOfOffice = "out-of-office"
+
+// CalendarClient reads a user's calendar from calendar-service
+type CalendarClient struct {
+	config     *config.Config
+	jwtManager *middleware.JWTManager
+	httpClient *http.Client
+}
+
+// NewCalendarClient creates a new calendar client, or nil when no calendar
+// service is configured
+func NewCalendarClient(cfg *config.Config, jwtManager *middleware.JWTManager) *CalendarClient {
+	if cfg.Calendar.URL == "" {
+		return nil
+	}
+
+	return &CalendarClient{
+		config:     cfg,
+		jwtManager: jwtManager,
+		httpClient: &http.Client{Timeout: cfg.Calendar.Timeout},
+	}
+}
+
+// OutOfOffice reports whether the user has an out-of-office event at t
+func (c *CalendarClient) OutOfOffice(userID string, t time.Time) (bool, error) {
+	token, err := c.jwtManager.Generate(userID, "", time.Minute)
+	if err != nil {
+		return false, err
+	}
+
+	query := url.Values{}
+	query.Set("start_time", t.UTC().Format(time.RFC3339))
+	query.Set("end_time", t.UTC().Format(time.RFC3339))
+
+	req, err := http.NewRequest(http.MethodGet, c.config.Calendar.URL+"/events/?"+query.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to call calendar service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("calendar service returned status: %d", resp.StatusCode)
+	}
+
+	var events []struct {
+		Event struct {
+			StartTime  time.Time `json:"start_time"`
+			EndTime    time.Time `json:"end_time"`
+			Status     string    `json:"status"`
+			BusyStatus string    `json:"busy_status"`
+		} `json:"event"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+		return false, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	for _, e := range events {
+		if e.Event.BusyStatus == busyStatusOutOfOffice && e.Event.Status != "cancelled" &&
+			!t.Before(e.Event.StartTime) && t.Before(e.Event.EndTime) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"nexus-mail-service/config"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// mailChangesChannel is the PostgreSQL channel the change log triggers notify
+const mailChangesChannel = "mail_changes"
+
+// ChangeNotifier listens for change log notifications from PostgreSQL and
+// wakes JMAP event source connections of the affected accounts
+type ChangeNotifier struct {
+	listener *pq.Listener
+
+	mu            sync.Mutex
+	subscriptions map[string]map[*ChangeSubscription]struct{} // user ID -> subscriptions
+}
+
+// NewChangeNotifier creates a change notifier with its own database connection
+func NewChangeNotifier(cfg *config.Config) *ChangeNotifier {
+	n := &ChangeNotifier{
+		subscriptions: make(map[string]map[*ChangeSubscription]struct{}),
+	}
+
+	n.listener = pq.NewListener(cfg.GetDatabaseDSN(), 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn().Err(err).Msg("Mail change listener connection problem")
+		}
+	})
+	if err := n.listener.Listen(mailChangesChannel); err != nil {
+		// The listener keeps retrying and listens once it reconnects
+		log.Warn().Err(err).Msg("Failed to listen for mail changes")
+	}
+
+	go n.run()
+	return n
+}
+
+// Close stops listening
+func (n *ChangeNotifier) Close() error {
+	return n.listener.Close()
+}
+
+func (n *ChangeNotifier) run() {
+	ticker := time.NewTicker(90 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case notification, ok := <-n.listener.Notify:
+			if !ok {
+				return
+			}
+			if notification == nil {
+				// The connection was re-established; notifications may have
+				// been lost, so let every subscriber re-check its state
+				n.broadcast()
+				continue
+			}
+			n.notify(notification.Extra)
+
+		case <-ticker.C:
+			go n.listener.Ping()
+		}
+	}
+}
+
+// Subscribe returns a subscription to changes in the given accounts
+func (n *ChangeNotifier) Subscribe(userIDs []string) *ChangeSubscription {
+	sub := &ChangeSubscription{
+		notifier: n,
+		userIDs:  userIDs,
+		wake:     make(chan struct{}, 1),
+		pending:  make(map[string]bool),
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, userID := range userIDs {
+		if n.subscriptions[userID] == nil {
+			n.subscriptions[userID] = make(map[*ChangeSubscription]struct{})
+		}
+		n.subscriptions[userID][sub] = struct{}{}
+	}
+
+	return sub
+}
+
+func (n *ChangeNotifier) notify(userID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for sub := range n.subscriptions[userID] {
+		sub.mark(userID)
+	}
+}
+
+func (n *ChangeNotifier) broadcast() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for userID, subs := range n.subscriptions {
+		for sub := range subs {
+			sub.mark(userID)
+		}
+	}
+}
+
+func (n *ChangeNotifier) unsubscribe(sub *ChangeSubscription) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, userID := range sub.userIDs {
+		delete(n.subscriptions[userID], sub)
+		if len(n.subscriptions[userID]) == 0 {
+			delete(n.subscriptions, userID)
+		}
+	}
+}
+
+// ChangeSubscription collects the accounts that changed since it was last
+// drained. Notifications are coalesced, never dropped.
+type ChangeSubscription struct {
+	notifier *ChangeNotifier
+	userIDs  []string
+	wake     chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+// Wake is signalled when Changed has something to return
+func (s *ChangeSubscription) Wake() <-chan struct{} {
+	return s.wake
+}
+
+// Changed returns and clears the accounts changed since the last call
+func (s *ChangeSubscription) Changed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := make([]string, 0, len(s.pending))
+	for userID := range s.pending {
+		changed = append(changed, userID)
+	}
+	s.pending = make(map[string]bool)
+	return changed
+}
+
+// Close ends the subscription
+func (s *ChangeSubscription) Close() {
+	s.notifier.unsubscribe(s)
+}
+
+func (s *ChangeSubscription) mark(userID string) {
+	s.mu.Lock()
+	s.pending[userID] = true
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"mime"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/rs/zerolog/log"
+)
+
+// AttachmentVerdict is the scan outcome for a single MIME part
+type AttachmentVerdict struct {
+	Status    string // model.ScanStatus*
+	Result    string // signature name or block reason
+	ScannedAt *time.Time
+}
+
+// ContentVerdict is the combined outcome for all parts of a message
+type ContentVerdict struct {
+	Policy             *model.AttachmentPolicy
+	Parts              []AttachmentVerdict // aligned with the inspected parts
+	Infected           bool
+	Blocked            bool
+	ScannerUnavailable bool
+}
+
+// Quarantined reports whether the message must be held in quarantine
+func (v *ContentVerdict) Quarantined() bool {
+	return v.Infected || (v.Blocked && v.Policy.BlockAction == model.PolicyActionQuarantine)
+}
+
+// ContentFilter applies tenant attachment policy and malware scanning to
+// inbound mail
+type ContentFilter struct {
+	config     *config.Config
+	scanner    ContentScanner
+	policyRepo *repository.PolicyRepository
+}
+
+// NewContentFilter creates a new content filter
+func NewContentFilter(cfg *config.Config, scanner ContentScanner, policyRepo *repository.PolicyRepository) *ContentFilter {
+	return &ContentFilter{
+		config:     cfg,
+		scanner:    scanner,
+		policyRepo: policyRepo,
+	}
+}
+
+// DefaultPolicy returns the policy configured for tenants without their own
+func (f *ContentFilter) DefaultPolicy() *model.AttachmentPolicy {
+	return &model.AttachmentPolicy{
+		TenantID:          "default",
+		ScanEnabled:       f.config.Security.EnableVirusScanning,
+		BlockedExtensions: model.StringArray(f.config.Security.BlockedExtensions),
+		BlockedMimeTypes:  model.StringArray(f.config.Security.BlockedMimeTypes),
+		BlockAction:       f.config.Security.BlockAction,
+		ScannerDownAction: f.config.Security.ScannerDownAction,
+	}
+}
+
+// Policy returns the tenant's attachment policy, falling back to the default
+func (f *ContentFilter) Policy(tenantID string) *model.AttachmentPolicy {
+	if f.policyRepo != nil && tenantID != "" {
+		policy, err := f.policyRepo.GetAttachmentPolicy(tenantID)
+		if err == nil {
+			return policy
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Warn().Err(err).Str("tenantID", tenantID).Msg("Failed to load attachment policy, using default")
+		}
+	}
+	return f.DefaultPolicy()
+}
+
+// Inspect checks every part against the tenant policy and scans it for malware
+func (f *ContentFilter) Inspect(ctx context.Context, tenantID string, parts []*enmime.Part) *ContentVerdict {
+	policy := f.Policy(tenantID)
+	verdict := &ContentVerdict{
+		Policy: policy,
+		Parts:  make([]AttachmentVerdict, len(parts)),
+	}
+
+	for i, part := range parts {
+		if reason := blockedReason(policy, part.FileName, part.ContentType); reason != "" {
+			verdict.Parts[i] = AttachmentVerdict{Status: model.ScanStatusBlocked, Result: reason}
+			verdict.Blocked = true
+			continue
+		}
+
+		if !policy.ScanEnabled || f.scanner == nil {
+			verdict.Parts[i] = AttachmentVerdict{Status: model.ScanStatusDisabled}
+			continue
+		}
+
+		// Once the scanner is known to be down, don't wait on it for every part
+		if verdict.ScannerUnavailable {
+			verdict.Parts[i] = AttachmentVerdict{Status: model.ScanStatusUnscanned, Result: "scanner unavailable"}
+			continue
+		}
+
+		result, err := f.scanner.Scan(ctx, bytes.NewReader(part.Content))
+		now := time.Now()
+		switch {
+		case err != nil:
+			log.Error().Err(err).Str("filename", part.FileName).Msg("Attachment scan failed")
+			verdict.Parts[i] = AttachmentVerdict{Status: model.ScanStatusUnscanned, Result: "scanner unavailable"}
+			verdict.ScannerUnavailable = true
+		case result.Infected:
+			verdict.Parts[i] = AttachmentVerdict{Status: model.ScanStatusInfected, Result: result.Signature, ScannedAt: &now}
+			verdict.Infected = true
+		default:
+			verdict.Parts[i] = AttachmentVerdict{Status: model.ScanStatusClean, ScannedAt: &now}
+		}
+	}
+
+	return verdict
+}
+
+// blockedReason returns why a file is blocked by policy, or "" if allowed
+func blockedReason(policy *model.AttachmentPolicy, filename, contentType string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, blocked := range policy.BlockedExtensions {
+		blocked = strings.ToLower(blocked)
+		if !strings.HasPrefix(blocked, ".") {
+			blocked = "." + blocked
+		}
+		if ext != "" && ext == blocked {
+			return "blocked extension " + ext
+		}
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	for _, blocked := range policy.BlockedMimeTypes {
+		blocked = strings.ToLower(blocked)
+		if mediaType == blocked ||
+			(strings.HasSuffix(blocked, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(blocked, "*"))) {
+			return "blocked content type " + mediaType
+		}
+	}
+
+	return ""
+}
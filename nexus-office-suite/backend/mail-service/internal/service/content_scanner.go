@@ -0,0 +1,226 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"nexus-mail-service/config"
+)
+
+// ErrScannerUnavailable is returned when the content scanner cannot be reached
+var ErrScannerUnavailable = errors.New("content scanner unavailable")
+
+// ScanResult is the outcome of scanning a single stream
+type ScanResult struct {
+	Infected  bool
+	Signature string // virus/signature name when infected
+}
+
+// ContentScanner scans attachment content for malware
+type ContentScanner interface {
+	Scan(ctx context.Context, r io.Reader) (*ScanResult, error)
+	Ping(ctx context.Context) error
+}
+
+// NewContentScanner creates the scanner selected in configuration
+func NewContentScanner(cfg *config.Config) ContentScanner {
+	switch cfg.Security.VirusScanner {
+	case "fake":
+		return NewFakeScanner()
+	default:
+		return NewClamdScanner(
+			net.JoinHostPort(cfg.Security.ClamAVHost, cfg.Security.ClamAVPort),
+			cfg.Security.ScanTimeout,
+		)
+	}
+}
+
+// ClamdScanner streams content to clamd using the INSTREAM command
+type ClamdScanner struct {
+	addr      string
+	timeout   time.Duration
+	chunkSize int
+}
+
+// NewClamdScanner creates a clamd scanner for the given TCP address
+func NewClamdScanner(addr string, timeout time.Duration) *ClamdScanner {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &ClamdScanner{
+		addr:      addr,
+		timeout:   timeout,
+		chunkSize: 64 * 1024,
+	}
+}
+
+// Scan streams r to clamd and parses the verdict
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (*ScanResult, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+	}
+
+	// Each chunk is prefixed with its length as a 4-byte big-endian integer;
+	// a zero-length chunk terminates the stream
+	buf := make([]byte, c.chunkSize)
+	header := make([]byte, 4)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(header, uint32(n))
+			if _, err := conn.Write(header); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+
+	binary.BigEndian.PutUint32(header, 0)
+	if _, err := conn.Write(header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+	}
+
+	response, err := readClamdResponse(conn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+	}
+
+	return parseClamdResponse(response)
+}
+
+// Ping checks that clamd is alive
+func (c *ClamdScanner) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+	}
+
+	response, err := readClamdResponse(conn)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+	}
+	if response != "PONG" {
+		return fmt.Errorf("%w: unexpected response %q", ErrScannerUnavailable, response)
+	}
+	return nil
+}
+
+func (c *ClamdScanner) dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	return conn, nil
+}
+
+// readClamdResponse reads a NUL-terminated clamd reply
+func readClamdResponse(conn net.Conn) (string, error) {
+	var response bytes.Buffer
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if idx := bytes.IndexByte(buf[:n], 0); idx >= 0 {
+				response.Write(buf[:idx])
+				break
+			}
+			response.Write(buf[:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSpace(response.String()), nil
+}
+
+// parseClamdResponse parses replies such as "stream: OK",
+// "stream: Eicar-Test-Signature FOUND" and "INSTREAM size limit exceeded. ERROR"
+func parseClamdResponse(response string) (*ScanResult, error) {
+	switch {
+	case strings.HasSuffix(response, "FOUND"):
+		signature := strings.TrimSuffix(response, "FOUND")
+		if idx := strings.Index(signature, ":"); idx >= 0 {
+			signature = signature[idx+1:]
+		}
+		return &ScanResult{Infected: true, Signature: strings.TrimSpace(signature)}, nil
+	case strings.HasSuffix(response, "OK"):
+		return &ScanResult{}, nil
+	default:
+		return nil, fmt.Errorf("clamd scan failed: %s", response)
+	}
+}
+
+// eicarSignature is the standard anti-virus test string
+const eicarSignature = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// FakeScanner is an in-process scanner for development and tests. It flags
+// content containing the EICAR test string and can simulate an outage.
+type FakeScanner struct {
+	Unavailable bool
+}
+
+// NewFakeScanner creates a fake scanner
+func NewFakeScanner() *FakeScanner {
+	return &FakeScanner{}
+}
+
+// Scan reports content containing the EICAR string as infected
+func (f *FakeScanner) Scan(ctx context.Context, r io.Reader) (*ScanResult, error) {
+	if f.Unavailable {
+		return nil, ErrScannerUnavailable
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(data, []byte(eicarSignature)) {
+		return &ScanResult{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return &ScanResult{}, nil
+}
+
+// Ping reports whether the fake scanner is simulating an outage
+func (f *FakeScanner) Ping(ctx context.Context) error {
+	if f.Unavailable {
+		return ErrScannerUnavailable
+	}
+	return nil
+}
@@ -1,27 +1,50 @@
 package service
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"path"
+	"regexp"
+	"strings"
 	"time"
 
 	"nexus-mail-service/config"
 	"nexus-mail-service/internal/model"
 	"nexus-mail-service/internal/repository"
+	"nexus-mail-service/internal/search"
 
 	"github.com/google/uuid"
+	"github.com/jhillyerd/enmime"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/gomail.v2"
 )
 
+// ErrAttachmentUnsafe is returned for attachments that are infected or blocked by policy
+var ErrAttachmentUnsafe = errors.New("attachment blocked by content policy")
+
+// ErrAttachmentNotFound is returned for attachments an email does not have
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// ErrSecureScheduled is returned for scheduled mail that should be signed or
+// encrypted; sealing needs the sender's keys at the time of sending
+var ErrSecureScheduled = errors.New("scheduled mail cannot be signed or encrypted")
+
 // EmailService handles email business logic
 type EmailService struct {
 	config      *config.Config
 	emailRepo   *repository.EmailRepository
 	folderRepo  *repository.FolderRepository
+	spamFilter  *SpamFilter
+	quotas      *QuotaService
+	security    *SecurityService
 	minioClient *minio.Client
 }
 
@@ -30,6 +53,9 @@ func NewEmailService(
 	cfg *config.Config,
 	emailRepo *repository.EmailRepository,
 	folderRepo *repository.FolderRepository,
+	spamFilter *SpamFilter,
+	quotas *QuotaService,
+	security *SecurityService,
 ) (*EmailService, error) {
 	// Initialize MinIO client for attachments
 	minioClient, err := minio.New(cfg.Storage.Endpoint, &minio.Options{
@@ -57,12 +83,18 @@ func NewEmailService(
 		config:      cfg,
 		emailRepo:   emailRepo,
 		folderRepo:  folderRepo,
+		spamFilter:  spamFilter,
+		quotas:      quotas,
+		security:    security,
 		minioClient: minioClient,
 	}, nil
 }
 
 // SendEmail sends an email
-func (s *EmailService) SendEmail(userID string, req *model.ComposeEmailRequest) (*model.Email, error) {
+// SendEmail sends an email from the user's mailbox. sender is the address of
+// the delegate when sending on behalf of the mailbox owner and becomes the
+// Sender header; it is empty when the owner (or a send-as delegate) sends.
+func (s *EmailService) SendEmail(userID string, req *model.ComposeEmailRequest, sender string) (*model.Email, error) {
 	// Get sent folder
 	sentFolder, err := s.folderRepo.GetByType("sent", userID)
 	if err != nil {
@@ -72,7 +104,7 @@ func (s *EmailService) SendEmail(userID string, req *model.ComposeEmailRequest)
 	// Get user profile
 	userProfile, err := s.getUserProfile(userID)
 	fromName := userID
-	fromEmail := fmt.Sprintf("%s@nexusmail.local", userID)
+	fromEmail := s.MailboxAddress(userID)
 
 	if err == nil && userProfile != nil {
 		if userProfile.FirstName != "" || userProfile.LastName != "" {
@@ -111,6 +143,10 @@ func (s *EmailService) SendEmail(userID string, req *model.ComposeEmailRequest)
 		email.Priority = "normal"
 	}
 
+	if sender != "" {
+		email.Headers["Sender"] = []string{sender}
+	}
+
 	// Handle threading (reply/forward)
 	if req.InReplyTo != "" {
 		email.InReplyTo = &req.InReplyTo
@@ -121,6 +157,26 @@ func (s *EmailService) SendEmail(userID string, req *model.ComposeEmailRequest)
 	// Calculate size
 	email.Size = int64(len(email.Body) + len(email.BodyHTML))
 
+	// The copy in Sent counts against the sender's quota
+	if s.quotas != nil {
+		if err := s.quotas.Check(userID, "", email.Size); err != nil {
+			return nil, err
+		}
+	}
+
+	// Signing and encryption happen now, so that a missing key is reported
+	// to the sender rather than failing in the background
+	var sealed []byte
+	if req.Sign || req.Encrypt {
+		if req.ScheduledAt != nil && req.ScheduledAt.After(time.Now()) {
+			return nil, ErrSecureScheduled
+		}
+		sealed, err = s.sealMessage(userID, email, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// If scheduled, save as draft
 	if req.ScheduledAt != nil && req.ScheduledAt.After(time.Now()) {
 		draftsFolder, _ := s.folderRepo.GetByType("drafts", userID)
@@ -140,7 +196,12 @@ func (s *EmailService) SendEmail(userID string, req *model.ComposeEmailRequest)
 	if req.ScheduledAt == nil || req.ScheduledAt.Before(time.Now()) {
 		// Send asynchronously to improve performance
 		go func(e *model.Email) {
-			err := s.sendViaSMTP(e)
+			var err error
+			if sealed != nil {
+				err = s.sendRawViaSMTP(e.From, emailRecipients(e), sealed)
+			} else {
+				err = s.sendViaSMTP(e)
+			}
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to send email via SMTP")
 			} else {
@@ -161,10 +222,136 @@ func (s *EmailService) SendEmail(userID string, req *model.ComposeEmailRequest)
 	return email, nil
 }
 
+// MailboxAddress returns the address mail from a mailbox is sent as
+func (s *EmailService) MailboxAddress(userID string) string {
+	return fmt.Sprintf("%s@nexusmail.local", userID)
+}
+
+// SendDraft sends an existing message, such as a draft composed over JMAP.
+// sender has the same meaning as for SendEmail. Filing the message (e.g.
+// moving it to Sent) is left to the caller.
+func (s *EmailService) SendDraft(email *model.Email, sender string) error {
+	if len(email.To)+len(email.CC)+len(email.BCC) == 0 {
+		return fmt.Errorf("message has no recipients")
+	}
+
+	if sender != "" {
+		if email.Headers == nil {
+			email.Headers = make(model.Headers)
+		}
+		email.Headers["Sender"] = []string{sender}
+	}
+
+	// Send asynchronously, like SendEmail
+	go func(e *model.Email) {
+		if err := s.sendViaSMTP(e); err != nil {
+			log.Error().Err(err).Str("emailID", e.ID).Msg("Failed to send email via SMTP")
+		}
+	}(email)
+
+	log.Info().
+		Str("emailID", email.ID).
+		Str("from", email.From).
+		Strs("to", email.To).
+		Str("subject", email.Subject).
+		Msg("Draft submitted for sending")
+
+	return nil
+}
+
 // sendViaSMTP sends email via external SMTP (for outgoing mail)
 func (s *EmailService) sendViaSMTP(email *model.Email) error {
+	m := outgoingMessage(email)
+
+	// In production, configure proper SMTP credentials for outgoing mail
+	// For now, this is a placeholder
+	d := gomail.NewDialer(s.config.SMTP.Host, 587, "", "")
+
+	// Send email
+	if err := d.DialAndSend(m); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// sealMessage signs and/or encrypts an outgoing email as the compose
+// request asks. The stored copy keeps the plain content and records what
+// was applied.
+func (s *EmailService) sealMessage(userID string, email *model.Email, req *model.ComposeEmailRequest) ([]byte, error) {
+	protocol := req.SecurityProtocol
+	if protocol == "" {
+		protocol = model.SecurityProtocolSMIME
+	}
+
+	var raw bytes.Buffer
+	if _, err := outgoingMessage(email).WriteTo(&raw); err != nil {
+		return nil, err
+	}
+
+	sealed, err := s.security.Seal(userID, email.From, raw.Bytes(), emailRecipients(email), protocol, req.Sign, req.Encrypt)
+	if err != nil {
+		return nil, err
+	}
+
+	email.SecurityProtocol = &protocol
+	email.IsSigned = req.Sign
+	email.IsEncrypted = req.Encrypt
+	return sealed, nil
+}
+
+// emailRecipients returns every recipient of an email, Bcc included
+func emailRecipients(email *model.Email) []string {
+	recipients := make([]string, 0, len(email.To)+len(email.CC)+len(email.BCC))
+	recipients = append(recipients, email.To...)
+	recipients = append(recipients, email.CC...)
+	return append(recipients, email.BCC...)
+}
+
+// sendRawViaSMTP sends an already encoded message, such as a signed one
+// that must go out byte for byte
+func (s *EmailService) sendRawViaSMTP(envelopeFrom string, recipients []string, raw []byte) error {
+	d := gomail.NewDialer(s.config.SMTP.Host, 587, "", "")
+	sender, err := d.Dial()
+	if err != nil {
+		return err
+	}
+	defer sender.Close()
+
+	return sender.Send(envelopeFrom, recipients, rawMessage(raw))
+}
+
+// rawMessage writes encoded message bytes as they are
+type rawMessage []byte
+
+func (m rawMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
+// relayViaSMTP sends email to the given envelope recipients rather than to
+// the addresses in its headers, as for distribution list copies
+func (s *EmailService) relayViaSMTP(email *model.Email, envelopeFrom string, recipients []string) error {
+	d := gomail.NewDialer(s.config.SMTP.Host, 587, "", "")
+	sender, err := d.Dial()
+	if err != nil {
+		return err
+	}
+	defer sender.Close()
+
+	return sender.Send(envelopeFrom, recipients, outgoingMessage(email))
+}
+
+// listHeaders are passed through to outgoing mail
+var listHeaders = []string{"List-Id", "List-Post", "List-Archive", "List-Help", "Precedence"}
+
+// outgoingMessage builds the SMTP message for an email
+func outgoingMessage(email *model.Email) *gomail.Message {
 	m := gomail.NewMessage()
 	m.SetHeader("From", email.From)
+	if sender := email.Headers["Sender"]; len(sender) > 0 {
+		m.SetHeader("Sender", sender[0])
+	}
 	m.SetHeader("To", email.To...)
 	if len(email.CC) > 0 {
 		m.SetHeader("Cc", email.CC...)
@@ -191,16 +378,13 @@ func (s *EmailService) sendViaSMTP(email *model.Email) error {
 		m.SetHeader("X-Priority", "5")
 	}
 
-	// In production, configure proper SMTP credentials for outgoing mail
-	// For now, this is a placeholder
-	d := gomail.NewDialer(s.config.SMTP.Host, 587, "", "")
-
-	// Send email
-	if err := d.DialAndSend(m); err != nil {
-		return err
+	for _, name := range listHeaders {
+		if values := email.Headers[name]; len(values) > 0 {
+			m.SetHeader(name, values...)
+		}
 	}
 
-	return nil
+	return m
 }
 
 // SaveDraft saves an email as a draft
@@ -212,7 +396,7 @@ func (s *EmailService) SaveDraft(userID string, req *model.ComposeEmailRequest)
 
 	email := &model.Email{
 		UserID:         userID,
-		From:           fmt.Sprintf("%s@nexusmail.local", userID),
+		From:           s.MailboxAddress(userID),
 		FromName:       userID,
 		To:             model.StringArray(req.To),
 		CC:             model.StringArray(req.CC),
@@ -236,9 +420,113 @@ func (s *EmailService) SaveDraft(userID string, req *model.ComposeEmailRequest)
 	return email, nil
 }
 
-// GetEmail retrieves an email by ID
+// GetEmail retrieves an email by ID, with inline images resolved through the
+// API. Signed and encrypted mail is verified and decrypted on each read, so
+// the plain content of encrypted mail is never stored.
 func (s *EmailService) GetEmail(emailID, userID string) (*model.Email, error) {
-	return s.emailRepo.GetByID(emailID, userID)
+	email, err := s.emailRepo.GetByID(emailID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.openSecureMessage(email)
+	rewriteInlineImages(email)
+	return email, nil
+}
+
+// openSecureMessage fills in an email's Security and, for encrypted mail,
+// its decrypted body and the list of attachments inside
+func (s *EmailService) openSecureMessage(email *model.Email) {
+	if email.SecureMIMEPath == nil {
+		// Sent copies are stored plain and only record what was applied
+		if email.IsSigned || email.IsEncrypted {
+			email.Security = &model.MessageSecurity{
+				Signed:    email.IsSigned,
+				Encrypted: email.IsEncrypted,
+				Decrypted: email.IsEncrypted,
+			}
+			if email.SecurityProtocol != nil {
+				email.Security.Protocol = *email.SecurityProtocol
+			}
+		}
+		return
+	}
+
+	security, envelope, err := s.openSecureMIME(email)
+	if err != nil {
+		email.Security = &model.MessageSecurity{
+			Signed:    email.IsSigned,
+			Encrypted: email.IsEncrypted,
+			Error:     err.Error(),
+		}
+		return
+	}
+	email.Security = security
+
+	if envelope != nil {
+		email.Body = envelope.Text
+		email.BodyHTML = envelope.HTML
+		for i, part := range secureParts(envelope) {
+			security.Attachments = append(security.Attachments, model.SecureAttachment{
+				Index:       i,
+				Filename:    part.FileName,
+				ContentType: part.ContentType,
+				Size:        int64(len(part.Content)),
+			})
+		}
+	}
+}
+
+// openSecureMIME verifies and decrypts an email's stored original. The
+// envelope is nil unless decryption revealed content enmime could not see.
+func (s *EmailService) openSecureMIME(email *model.Email) (*model.MessageSecurity, *enmime.Envelope, error) {
+	raw, err := s.GetAttachment(*email.SecureMIMEPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load original message: %w", err)
+	}
+
+	security, inner := s.security.Open(email.UserID, email.From, raw, email.ReceivedAt)
+	if inner == nil {
+		return security, nil, nil
+	}
+
+	envelope, err := enmime.ReadEnvelope(bytes.NewReader(inner))
+	if err != nil {
+		security.Error = fmt.Sprintf("failed to parse decrypted content: %v", err)
+		return security, nil, nil
+	}
+	return security, envelope, nil
+}
+
+// GetSecureAttachment decrypts an encrypted email and returns the
+// attachment at index among those inside it
+func (s *EmailService) GetSecureAttachment(emailID, userID string, index int) (*enmime.Part, error) {
+	email, err := s.emailRepo.GetByID(emailID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if email.SecureMIMEPath == nil {
+		return nil, ErrAttachmentNotFound
+	}
+
+	_, envelope, err := s.openSecureMIME(email)
+	if err != nil {
+		return nil, err
+	}
+	if envelope == nil {
+		return nil, ErrAttachmentNotFound
+	}
+
+	parts := secureParts(envelope)
+	if index < 0 || index >= len(parts) {
+		return nil, ErrAttachmentNotFound
+	}
+	return parts[index], nil
+}
+
+// secureParts lists the attachments and inline parts of decrypted content
+func secureParts(envelope *enmime.Envelope) []*enmime.Part {
+	return append(append([]*enmime.Part{}, envelope.Attachments...), envelope.Inlines...)
 }
 
 // ListEmails lists emails with pagination and filtering
@@ -259,9 +547,30 @@ func (s *EmailService) ListEmails(userID, folderID string, page, pageSize int, f
 	}, nil
 }
 
-// SearchEmails searches emails
+// SearchEmails searches emails. The query string accepts Gmail-style
+// operators; structured request fields are merged in as extra terms.
 func (s *EmailService) SearchEmails(userID string, req *model.SearchEmailRequest) (*model.EmailListResponse, error) {
-	emails, total, err := s.emailRepo.Search(userID, req.Query, req.Page, req.PageSize)
+	q := search.Parse(req.Query)
+	if req.FolderID != "" {
+		q.Add(search.FieldIn, req.FolderID)
+	}
+	for _, label := range req.Labels {
+		q.Add(search.FieldLabel, label)
+	}
+	if req.HasAttachment {
+		q.Add(search.FieldHas, "attachment")
+	}
+	if req.IsUnread {
+		q.Add(search.FieldIs, "unread")
+	}
+	if req.DateFrom != nil {
+		q.AddTime(search.FieldAfter, *req.DateFrom)
+	}
+	if req.DateTo != nil {
+		q.AddTime(search.FieldBefore, *req.DateTo)
+	}
+
+	emails, total, err := s.emailRepo.Search(userID, q, req.Page, req.PageSize)
 	if err != nil {
 		return nil, err
 	}
@@ -287,9 +596,50 @@ func (s *EmailService) MarkAsStarred(emailID, userID string, isStarred bool) err
 	return s.emailRepo.MarkAsStarred(emailID, userID, isStarred)
 }
 
-// MoveToFolder moves an email to a different folder
+// MoveToFolder moves an email to a different folder. Moving mail into or
+// out of the spam folder trains the spam classifier.
 func (s *EmailService) MoveToFolder(emailID, userID, folderID string) error {
-	return s.emailRepo.MoveToFolder(emailID, userID, folderID)
+	return s.moveEmails([]string{emailID}, userID, folderID)
+}
+
+// moveEmails moves emails to a folder and learns from spam decisions
+func (s *EmailService) moveEmails(emailIDs []string, userID, folderID string) error {
+	target, err := s.folderRepo.GetByID(folderID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get folder: %w", err)
+	}
+
+	spamFolder, _ := s.folderRepo.GetByType("spam", userID)
+
+	for _, emailID := range emailIDs {
+		email, err := s.emailRepo.GetByID(emailID, userID)
+		if err != nil {
+			return err
+		}
+		if email.FolderID == target.ID {
+			continue
+		}
+
+		wasSpam := spamFolder != nil && email.FolderID == spamFolder.ID
+		isSpam := target.Type == "spam"
+
+		email.FolderID = target.ID
+		if isSpam || wasSpam {
+			email.IsSpam = isSpam
+		}
+		if err := s.emailRepo.Update(email); err != nil {
+			return err
+		}
+
+		// Reporting spam, or rescuing mail from spam (other than deleting it), is a training signal
+		if s.spamFilter != nil && (isSpam || (wasSpam && target.Type != "trash")) {
+			if err := s.spamFilter.Learn(email, isSpam); err != nil {
+				log.Warn().Err(err).Str("emailID", emailID).Msg("Failed to train spam classifier")
+			}
+		}
+	}
+
+	return nil
 }
 
 // DeleteEmail soft deletes an email (moves to trash)
@@ -335,14 +685,23 @@ func (s *EmailService) BulkAction(userID string, req *model.BulkActionRequest) e
 		if req.FolderID == "" {
 			return fmt.Errorf("folder_id required for move action")
 		}
-		for _, emailID := range req.EmailIDs {
-			s.emailRepo.MoveToFolder(emailID, userID, req.FolderID)
+		return s.moveEmails(req.EmailIDs, userID, req.FolderID)
+	case "mark_spam":
+		spamFolder, err := s.folderRepo.GetByType("spam", userID)
+		if err != nil {
+			return err
 		}
-		return nil
+		return s.moveEmails(req.EmailIDs, userID, spamFolder.ID)
+	case "mark_not_spam":
+		inbox, err := s.folderRepo.GetByType("inbox", userID)
+		if err != nil {
+			return err
+		}
+		return s.moveEmails(req.EmailIDs, userID, inbox.ID)
 	case "add_label":
 		for _, emailID := range req.EmailIDs {
 			for _, labelID := range req.LabelIDs {
-				s.emailRepo.AddLabel(emailID, labelID)
+				s.emailRepo.AddLabel(emailID, labelID, userID)
 			}
 		}
 		return nil
@@ -376,6 +735,71 @@ func (s *EmailService) SaveAttachment(emailID, filename string, data []byte) (st
 	return objectName, nil
 }
 
+// SaveMessageParts stores the attachments and inline parts of a parsed
+// message. verdict.Parts covers the attachments followed by the inlines.
+func (s *EmailService) SaveMessageParts(emailID string, attachments, inlines []*enmime.Part, verdict *ContentVerdict) {
+	for i, part := range append(append([]*enmime.Part{}, attachments...), inlines...) {
+		v := verdict.Parts[i]
+		attachment := &model.Attachment{
+			EmailID:     emailID,
+			Filename:    part.FileName,
+			ContentType: part.ContentType,
+			Size:        int64(len(part.Content)),
+			IsInline:    i >= len(attachments),
+			ScanStatus:  v.Status,
+			ScanResult:  v.Result,
+			ScannedAt:   v.ScannedAt,
+		}
+
+		if attachment.IsInline {
+			if part.ContentID != "" {
+				attachment.ContentID = &part.ContentID
+			}
+		} else if v.Status != model.ScanStatusInfected && v.Status != model.ScanStatusBlocked {
+			attachment.ExtractedText = extractAttachmentText(part.ContentType, part.Content)
+		}
+
+		// Save attachment to storage (MinIO/S3)
+		storagePath, err := s.SaveAttachment(emailID, part.FileName, part.Content)
+		if err != nil {
+			log.Error().Err(err).Str("emailID", emailID).Str("filename", part.FileName).Msg("Failed to store attachment")
+			continue
+		}
+		attachment.StoragePath = storagePath
+		if err := s.emailRepo.CreateAttachment(attachment); err != nil {
+			log.Error().Err(err).Str("emailID", emailID).Msg("Failed to save attachment")
+		}
+	}
+}
+
+// StoreObject streams an object such as a mailbox archive into storage
+func (s *EmailService) StoreObject(objectName string, r io.Reader, size int64, contentType string) error {
+	if s.minioClient == nil {
+		return fmt.Errorf("storage not configured")
+	}
+
+	ctx := context.Background()
+	_, err := s.minioClient.PutObject(
+		ctx,
+		s.config.Storage.BucketName,
+		objectName,
+		r,
+		size,
+		minio.PutObjectOptions{ContentType: contentType},
+	)
+	return err
+}
+
+// RemoveObject deletes a stored object
+func (s *EmailService) RemoveObject(objectName string) error {
+	if s.minioClient == nil {
+		return fmt.Errorf("storage not configured")
+	}
+
+	ctx := context.Background()
+	return s.minioClient.RemoveObject(ctx, s.config.Storage.BucketName, objectName, minio.RemoveObjectOptions{})
+}
+
 // GetAttachment retrieves an attachment from MinIO/S3
 func (s *EmailService) GetAttachment(storagePath string) ([]byte, error) {
 	if s.minioClient == nil {
@@ -397,3 +821,218 @@ func (s *EmailService) GetAttachment(storagePath string) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// GetUserAttachment retrieves attachment metadata the user is allowed to see.
+// Attachments that failed scanning or policy are returned with ErrAttachmentUnsafe.
+func (s *EmailService) GetUserAttachment(attachmentID, userID string) (*model.Attachment, error) {
+	att, err := s.emailRepo.GetAttachmentByID(attachmentID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !attachmentIsSafe(att) {
+		return att, ErrAttachmentUnsafe
+	}
+
+	return att, nil
+}
+
+// OpenAttachment opens a stored attachment (or other stored object, such as
+// a mailbox archive) for streaming. The returned object supports seeking, so
+// it can serve HTTP range requests.
+func (s *EmailService) OpenAttachment(storagePath string) (*minio.Object, error) {
+	if s.minioClient == nil {
+		return nil, fmt.Errorf("storage not configured")
+	}
+
+	ctx := context.Background()
+	return s.minioClient.GetObject(ctx, s.config.Storage.BucketName, storagePath, minio.GetObjectOptions{})
+}
+
+// WriteAttachmentsZip streams all safe attachments of an email into a zip archive
+func (s *EmailService) WriteAttachmentsZip(w io.Writer, email *model.Email) error {
+	zw := zip.NewWriter(w)
+	names := make(map[string]int)
+
+	for _, att := range email.Attachments {
+		if att.IsInline || !attachmentIsSafe(&att) {
+			continue
+		}
+
+		object, err := s.OpenAttachment(att.StoragePath)
+		if err != nil {
+			return err
+		}
+
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     uniqueZipName(names, att.Filename),
+			Method:   zip.Deflate,
+			Modified: att.CreatedAt,
+		})
+		if err == nil {
+			_, err = io.Copy(entry, object)
+		}
+		object.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// EncodeMessage writes an email as an RFC 5322 message. Flags are kept in
+// Status and X-Status and, if folderName is set, the folder in X-Nexus-Folder
+// so that re-importing the message restores them.
+func (s *EmailService) EncodeMessage(w io.Writer, email *model.Email, folderName string) error {
+	from := email.From
+	if from == "" {
+		from = "MAILER-DAEMON@" + s.config.SMTP.Domain
+	}
+
+	date := email.ReceivedAt
+	if email.SentAt != nil {
+		date = *email.SentAt
+	}
+
+	builder := enmime.Builder().
+		From(email.FromName, from).
+		Subject(email.Subject).
+		Date(date).
+		ToAddrs(mailAddresses(email.To)).
+		CCAddrs(mailAddresses(email.CC))
+
+	if len(email.BCC) > 0 {
+		builder = builder.BCCAddrs(mailAddresses(email.BCC)).Header("Bcc", strings.Join(email.BCC, ", "))
+	}
+	if len(email.To)+len(email.CC)+len(email.BCC) == 0 {
+		// Drafts may have no recipients; the builder insists on one, and a
+		// Bcc recipient satisfies it without appearing in the headers
+		builder = builder.Header("To", "undisclosed-recipients:;").BCC("", from)
+	}
+
+	if email.MessageID != "" {
+		builder = builder.Header("Message-ID", email.MessageID)
+	}
+	if email.InReplyTo != nil && *email.InReplyTo != "" {
+		builder = builder.Header("In-Reply-To", *email.InReplyTo)
+	}
+	if len(email.References) > 0 {
+		builder = builder.Header("References", strings.Join(email.References, " "))
+	}
+
+	status, xStatus := "O", ""
+	if email.IsRead {
+		status = "RO"
+	}
+	if email.IsStarred {
+		xStatus += "F"
+	}
+	if email.IsDraft {
+		xStatus += "T"
+	}
+	builder = builder.Header("Status", status)
+	if xStatus != "" {
+		builder = builder.Header("X-Status", xStatus)
+	}
+	if folderName != "" {
+		builder = builder.Header("X-Nexus-Folder", mime.QEncoding.Encode("utf-8", folderName))
+	}
+
+	if email.Body != "" || email.BodyHTML == "" {
+		builder = builder.Text([]byte(email.Body))
+	}
+	if email.BodyHTML != "" {
+		builder = builder.HTML([]byte(email.BodyHTML))
+	}
+
+	for i := range email.Attachments {
+		att := &email.Attachments[i]
+		// Infected or blocked attachments are never handed out, not even in exports
+		if !attachmentIsSafe(att) {
+			continue
+		}
+
+		content, err := s.GetAttachment(att.StoragePath)
+		if err != nil {
+			return fmt.Errorf("failed to read attachment %s: %w", att.Filename, err)
+		}
+
+		if att.IsInline && att.ContentID != nil {
+			builder = builder.AddInline(content, att.ContentType, att.Filename, strings.Trim(*att.ContentID, "<>"))
+		} else {
+			builder = builder.AddAttachment(content, att.ContentType, att.Filename)
+		}
+	}
+
+	root, err := builder.Build()
+	if err != nil {
+		return err
+	}
+
+	return root.Encode(w)
+}
+
+// mailAddresses converts bare addresses for the message builder
+func mailAddresses(addresses []string) []mail.Address {
+	list := make([]mail.Address, 0, len(addresses))
+	for _, addr := range addresses {
+		if parsed, err := mail.ParseAddress(addr); err == nil {
+			list = append(list, *parsed)
+		} else {
+			list = append(list, mail.Address{Address: addr})
+		}
+	}
+	return list
+}
+
+// attachmentIsSafe reports whether an attachment may be delivered to clients
+func attachmentIsSafe(att *model.Attachment) bool {
+	return att.ScanStatus != model.ScanStatusInfected && att.ScanStatus != model.ScanStatusBlocked
+}
+
+// uniqueZipName returns a flat, unique entry name for a zip archive
+func uniqueZipName(names map[string]int, filename string) string {
+	name := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		name = "attachment"
+	}
+
+	count := names[name]
+	names[name] = count + 1
+	if count == 0 {
+		return name
+	}
+
+	ext := path.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), count, ext)
+}
+
+var cidPattern = regexp.MustCompile(`(?i)cid:<?([^"'\s)>]+)>?`)
+
+// rewriteInlineImages points cid: references in the HTML body at the inline
+// attachment endpoint so that embedded images render in web clients
+func rewriteInlineImages(email *model.Email) {
+	if email.BodyHTML == "" || len(email.Attachments) == 0 {
+		return
+	}
+
+	byContentID := make(map[string]string)
+	for _, att := range email.Attachments {
+		if att.ContentID != nil && *att.ContentID != "" && attachmentIsSafe(&att) {
+			cid := strings.ToLower(strings.Trim(*att.ContentID, "<>"))
+			byContentID[cid] = att.ID
+		}
+	}
+	if len(byContentID) == 0 {
+		return
+	}
+
+	email.BodyHTML = cidPattern.ReplaceAllStringFunc(email.BodyHTML, func(match string) string {
+		cid := strings.ToLower(cidPattern.FindStringSubmatch(match)[1])
+		if attachmentID, ok := byContentID[cid]; ok {
+			return fmt.Sprintf("/api/v1/attachments/%s/inline", attachmentID)
+		}
+		return match
+	})
+}
@@ -0,0 +1,568 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nexus-mail-service/internal/model"
+
+	"github.com/rs/zerolog/log"
+)
+
+// IMAP QUOTA (RFC 9208) support. go-imap's imapserver rejects commands it
+// does not know, so every connection is relayed through an imapRelay that
+// answers GETQUOTA and GETQUOTAROOT and adds QUOTA to the capabilities. The
+// relay passes those commands on to the server as NOOP, so their responses
+// keep their place among the others, and puts the quota responses in front
+// of the NOOP's completion. It terminates STARTTLS on both sides, so it
+// always sees the protocol in the clear; the server's side uses a
+// certificate generated at startup.
+
+const (
+	// imapLineBuffer bounds how much of a line the relay holds; longer
+	// lines are passed through without being inspected
+	imapLineBuffer = 64 * 1024
+
+	// quotaRootTenant is the quota root of the tenant's shared limit; ""
+	// is the mailbox's own
+	quotaRootTenant = "tenant"
+
+	relayServerName = "imap-relay.invalid"
+)
+
+// quotaCaps are added to the capabilities once a client is logged in
+var quotaCaps = []byte(" QUOTA QUOTA=RES-STORAGE")
+
+// QuotaLookup returns a mailbox's storage usage and limits
+type QuotaLookup func(userID string) (*model.QuotaStatus, error)
+
+// imapRelayListener hands the IMAP server one end of a pipe for every
+// connection it accepts, relaying the other end to the client
+type imapRelayListener struct {
+	net.Listener
+	server *IMAPServer
+}
+
+// relayedConn is the server's end of a relayed connection
+type relayedConn struct {
+	net.Conn
+	relay  *imapRelay
+	remote net.Addr
+}
+
+func (c *relayedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Read reports reads from a closed connection the way network connections
+// do, which the server expects when it is stopped
+func (c *relayedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if errors.Is(err, io.ErrClosedPipe) {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+// Accept waits for a client and returns the server's end of its relay
+func (l *imapRelayListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	serverEnd, relayEnd := net.Pipe()
+	relay := &imapRelay{
+		server:     l.server,
+		client:     conn,
+		backend:    relayEnd,
+		commands:   make(map[string]relayedCommand),
+		tlsReady:   make(chan bool, 1),
+		clientTLS:  make(chan net.Conn, 1),
+		backendTLS: make(chan net.Conn, 1),
+		done:       make(chan struct{}),
+	}
+	go relay.run()
+
+	return &relayedConn{Conn: serverEnd, relay: relay, remote: conn.RemoteAddr()}, nil
+}
+
+// imapRelay copies one client connection to and from the IMAP server
+type imapRelay struct {
+	server  *IMAPServer
+	client  net.Conn // to the client, TLS once STARTTLS completes
+	backend net.Conn // to the server, likewise
+
+	mu       sync.Mutex
+	userID   string                    // set once the client has logged in
+	commands map[string]relayedCommand // by the tag the relay gave them
+	seq      int
+
+	tlsReady   chan bool     // whether STARTTLS succeeded, to the command side
+	clientTLS  chan net.Conn // client's side after STARTTLS, to the response side
+	backendTLS chan net.Conn // server's side after STARTTLS, to the command side
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// relayedCommand is a command the relay answers or follows the outcome of.
+// It is sent to the server under a tag of the relay's, so its completion
+// cannot be mistaken for that of a client command reusing its tag.
+type relayedCommand struct {
+	tag  string // the client's
+	name string // GETQUOTA, GETQUOTAROOT (sent as NOOP) or STARTTLS
+	arg  string // quota root or mailbox
+	bad  bool   // arguments could not be parsed
+}
+
+func (r *imapRelay) setUserID(userID string) {
+	r.mu.Lock()
+	r.userID = userID
+	r.mu.Unlock()
+}
+
+func (r *imapRelay) loggedInUser() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.userID
+}
+
+func (r *imapRelay) run() {
+	go func() {
+		defer r.close()
+		if err := r.relayResponses(); err != nil && !isClosedConnError(err) {
+			log.Debug().Err(err).Msg("IMAP relay stopped relaying responses")
+		}
+	}()
+
+	defer r.close()
+	if err := r.relayCommands(); err != nil && !isClosedConnError(err) {
+		log.Debug().Err(err).Msg("IMAP relay stopped relaying commands")
+	}
+}
+
+func (r *imapRelay) close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		r.client.Close()
+		r.backend.Close()
+	})
+}
+
+// relayCommands copies commands from the client to the server, turning
+// quota commands into NOOP
+func (r *imapRelay) relayCommands() error {
+	in := bufio.NewReaderSize(r.client, imapLineBuffer)
+	out := bufio.NewWriter(r.backend)
+	lineStart := true
+
+	for {
+		line, err := in.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			if _, err := out.Write(line); err != nil {
+				return err
+			}
+			lineStart = false
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// Literal data follows the line as is; commands with literals are
+		// left to the server
+		n, ok := literalSize(line)
+		command := line
+		var startTLS bool
+		if lineStart && !ok {
+			command, startTLS = r.inspectCommand(line)
+		}
+		if _, err := out.Write(command); err != nil {
+			return err
+		}
+		if ok {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(out, in, n); err != nil {
+				return err
+			}
+		}
+		lineStart = !ok
+
+		if in.Buffered() == 0 || startTLS {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+		}
+
+		if startTLS {
+			var ok bool
+			select {
+			case ok = <-r.tlsReady:
+			case <-r.done:
+				return nil
+			}
+			if !ok {
+				continue
+			}
+
+			// Anything the client sent ahead is the start of its handshake
+			client := r.client
+			if n := in.Buffered(); n > 0 {
+				buffered, _ := in.Peek(n)
+				client = &prefixedConn{Conn: r.client, r: io.MultiReader(bytes.NewReader(append([]byte{}, buffered...)), r.client)}
+			}
+			clientTLS := tls.Server(client, r.server.tlsConfig)
+			if err := clientTLS.Handshake(); err != nil {
+				return fmt.Errorf("client TLS handshake: %w", err)
+			}
+			r.clientTLS <- clientTLS
+
+			var backendTLS net.Conn
+			select {
+			case backendTLS = <-r.backendTLS:
+			case <-r.done:
+				return nil
+			}
+			in.Reset(clientTLS)
+			out.Reset(backendTLS)
+			lineStart = true
+		}
+	}
+}
+
+// inspectCommand returns the command line to send to the server, and
+// whether it is STARTTLS
+func (r *imapRelay) inspectCommand(line []byte) ([]byte, bool) {
+	tag, name, args, ok := splitCommand(line)
+	if !ok {
+		return line, false
+	}
+
+	cmd := relayedCommand{tag: tag, name: name}
+	switch {
+	case name == "STARTTLS" && r.server.tlsConfig != nil:
+		return []byte(r.relay(cmd) + " STARTTLS\r\n"), true
+	case (name == "GETQUOTA" || name == "GETQUOTAROOT") && r.server.quotas != nil:
+		var rest string
+		cmd.arg, rest, ok = parseAString(args)
+		cmd.bad = !ok || rest != ""
+		return []byte(r.relay(cmd) + " NOOP\r\n"), false
+	}
+	return line, false
+}
+
+// relay records a command and returns the tag it is sent to the server with
+func (r *imapRelay) relay(cmd relayedCommand) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	tag := fmt.Sprintf("relay.%d", r.seq)
+	r.commands[tag] = cmd
+	return tag
+}
+
+// relayResponses copies responses from the server to the client, adding
+// QUOTA to capabilities and the responses to quota commands
+func (r *imapRelay) relayResponses() error {
+	in := bufio.NewReaderSize(r.backend, imapLineBuffer)
+	out := bufio.NewWriter(r.client)
+	lineStart := true
+
+	for {
+		line, err := in.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			if _, err := out.Write(line); err != nil {
+				return err
+			}
+			lineStart = false
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		response := line
+		var startTLS, tlsOK bool
+		if lineStart {
+			response, startTLS, tlsOK = r.inspectResponse(line)
+		}
+		if _, err := out.Write(response); err != nil {
+			return err
+		}
+
+		n, ok := literalSize(line)
+		if ok {
+			if _, err := io.CopyN(out, in, n); err != nil {
+				return err
+			}
+		}
+		lineStart = !ok
+
+		if in.Buffered() == 0 || startTLS {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+		}
+
+		if startTLS {
+			r.tlsReady <- tlsOK
+			if !tlsOK {
+				continue
+			}
+
+			backendTLS := tls.Client(r.backend, &tls.Config{
+				RootCAs:    r.server.relayRoots,
+				ServerName: relayServerName,
+			})
+			if err := backendTLS.Handshake(); err != nil {
+				return fmt.Errorf("server TLS handshake: %w", err)
+			}
+			r.backendTLS <- backendTLS
+
+			var clientTLS net.Conn
+			select {
+			case clientTLS = <-r.clientTLS:
+			case <-r.done:
+				return nil
+			}
+			in.Reset(backendTLS)
+			out.Reset(clientTLS)
+			lineStart = true
+		}
+	}
+}
+
+// inspectResponse returns the response line to send to the client, whether
+// it completes STARTTLS and if so whether TLS starts
+func (r *imapRelay) inspectResponse(line []byte) ([]byte, bool, bool) {
+	tag, status, text, ok := splitCommand(line)
+	if !ok {
+		return line, false, false
+	}
+
+	r.mu.Lock()
+	cmd, relayed := r.commands[tag]
+	delete(r.commands, tag)
+	r.mu.Unlock()
+
+	switch {
+	case relayed && cmd.name == "STARTTLS":
+		return append([]byte(cmd.tag), line[len(tag):]...), true, status == "OK"
+	case relayed:
+		return r.quotaResponse(&cmd), false, false
+	case r.server.quotas == nil || r.loggedInUser() == "":
+		return line, false, false
+	case tag == "*" && status == "CAPABILITY":
+		return insertQuotaCaps(line, len(bytes.TrimRight(line, "\r\n"))), false, false
+	case (status == "OK" || status == "PREAUTH") && strings.HasPrefix(text, "[CAPABILITY "):
+		if end := bytes.IndexByte(line, ']'); end >= 0 {
+			return insertQuotaCaps(line, end), false, false
+		}
+	}
+	return line, false, false
+}
+
+// quotaResponse answers a quota command for the logged in user
+func (r *imapRelay) quotaResponse(cmd *relayedCommand) []byte {
+	var resp bytes.Buffer
+	userID := r.loggedInUser()
+	switch {
+	case userID == "":
+		fmt.Fprintf(&resp, "%s BAD Not logged in\r\n", cmd.tag)
+		return resp.Bytes()
+	case cmd.bad:
+		fmt.Fprintf(&resp, "%s BAD Invalid arguments\r\n", cmd.tag)
+		return resp.Bytes()
+	}
+
+	status, err := r.server.quotas(userID)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Msg("Failed to get IMAP quota")
+		fmt.Fprintf(&resp, "%s NO Failed to get quota\r\n", cmd.tag)
+		return resp.Bytes()
+	}
+
+	// Every mailbox is under the mailbox's own root, and its tenant's if
+	// the tenant has a limit
+	roots := []string{""}
+	if status.TenantLimitBytes > 0 {
+		roots = append(roots, quotaRootTenant)
+	}
+
+	if cmd.name == "GETQUOTAROOT" {
+		fmt.Fprintf(&resp, "* QUOTAROOT %s", quoteIMAP(cmd.arg))
+		for _, root := range roots {
+			fmt.Fprintf(&resp, " %s", quoteIMAP(root))
+		}
+		resp.WriteString("\r\n")
+	} else {
+		found := false
+		for _, root := range roots {
+			found = found || root == cmd.arg
+		}
+		if !found {
+			fmt.Fprintf(&resp, "%s NO [NONEXISTENT] No such quota root\r\n", cmd.tag)
+			return resp.Bytes()
+		}
+		roots = []string{cmd.arg}
+	}
+
+	for _, root := range roots {
+		used, limit := status.StorageBytes, status.StorageLimitBytes
+		if root == quotaRootTenant {
+			used, limit = status.TenantStorageBytes, status.TenantLimitBytes
+		}
+		// STORAGE is counted in units of 1024 octets
+		if limit > 0 {
+			fmt.Fprintf(&resp, "* QUOTA %s (STORAGE %d %d)\r\n", quoteIMAP(root), (used+1023)/1024, limit/1024)
+		} else {
+			fmt.Fprintf(&resp, "* QUOTA %s ()\r\n", quoteIMAP(root))
+		}
+	}
+	fmt.Fprintf(&resp, "%s OK %s completed\r\n", cmd.tag, cmd.name)
+	return resp.Bytes()
+}
+
+// insertQuotaCaps adds the QUOTA capabilities to a list of capabilities
+// ending at end
+func insertQuotaCaps(line []byte, end int) []byte {
+	added := make([]byte, 0, len(line)+len(quotaCaps))
+	added = append(added, line[:end]...)
+	added = append(added, quotaCaps...)
+	return append(added, line[end:]...)
+}
+
+// splitCommand splits a command or response line into its tag, upper-cased
+// command or status, and arguments
+func splitCommand(line []byte) (tag, name, args string, ok bool) {
+	text := strings.TrimRight(string(line), "\r\n")
+	tag, rest, ok := strings.Cut(text, " ")
+	if !ok || tag == "" {
+		return "", "", "", false
+	}
+	name, args, _ = strings.Cut(rest, " ")
+	return tag, strings.ToUpper(name), args, true
+}
+
+// parseAString parses an atom or quoted string, returning it and what
+// follows
+func parseAString(s string) (string, string, bool) {
+	if s == "" {
+		return "", "", false
+	}
+	if s[0] != '"' {
+		value, rest, _ := strings.Cut(s, " ")
+		if strings.ContainsAny(value, `(){%*"\`) {
+			return "", "", false
+		}
+		return value, rest, true
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i == len(s) || (s[i] != '\\' && s[i] != '"') {
+				return "", "", false
+			}
+			value.WriteByte(s[i])
+		case '"':
+			return value.String(), s[i+1:], true
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return "", "", false
+}
+
+// quoteIMAP returns s as an IMAP quoted string
+func quoteIMAP(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// literalSize returns the size of the literal a line announces, if any
+func literalSize(line []byte) (int64, bool) {
+	text := bytes.TrimRight(line, "\r\n")
+	if !bytes.HasSuffix(text, []byte("}")) {
+		return 0, false
+	}
+	open := bytes.LastIndexByte(text, '{')
+	if open < 0 {
+		return 0, false
+	}
+	digits := bytes.TrimSuffix(bytes.TrimSuffix(text[open+1:len(text)-1], []byte("+")), []byte("-"))
+	n, err := strconv.ParseInt(string(digits), 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// prefixedConn reads data already buffered before its connection
+type prefixedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefixedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func isClosedConnError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed)
+}
+
+// relayCertificate returns a certificate for the server's side of relayed
+// connections, and a pool trusting only it
+func relayCertificate() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: relayServerName},
+		DNSNames:              []string{relayServerName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, roots, nil
+}
@@ -2,13 +2,16 @@ package service
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"strings"
-	"time"
 
 	"nexus-mail-service/config"
+	"nexus-mail-service/internal/middleware"
 	"nexus-mail-service/internal/model"
 	"nexus-mail-service/internal/repository"
+	"nexus-mail-service/internal/search"
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapserver"
@@ -21,36 +24,58 @@ type IMAPServer struct {
 	server     *imapserver.Server
 	emailRepo  *repository.EmailRepository
 	folderRepo *repository.FolderRepository
+	jwtManager *middleware.JWTManager
+	quotas     QuotaLookup
+	tlsConfig  *tls.Config    // for STARTTLS with clients
+	relayRoots *x509.CertPool // trusts the server's side of relayed STARTTLS
 }
 
-// NewIMAPServer creates a new IMAP server
+// NewIMAPServer creates a new IMAP server; with quotas nil, QUOTA is not
+// offered
 func NewIMAPServer(
 	cfg *config.Config,
 	emailRepo *repository.EmailRepository,
 	folderRepo *repository.FolderRepository,
+	jwtManager *middleware.JWTManager,
+	quotas QuotaLookup,
 ) *IMAPServer {
 	s := &IMAPServer{
 		config:     cfg,
 		emailRepo:  emailRepo,
 		folderRepo: folderRepo,
+		jwtManager: jwtManager,
+		quotas:     quotas,
 	}
 
 	options := &imapserver.Options{
 		NewSession: s.newSession,
+		Caps: imap.CapSet{
+			imap.CapIMAP4rev1: {},
+		},
+		InsecureAuth: !cfg.IMAP.TLSEnabled,
 	}
 
-	server := imapserver.New(options)
-
+	// Clients' STARTTLS ends at the connection relay, which starts TLS with
+	// the server in turn
 	if cfg.IMAP.TLSEnabled && cfg.IMAP.CertFile != "" && cfg.IMAP.KeyFile != "" {
 		cert, err := tls.LoadX509KeyPair(cfg.IMAP.CertFile, cfg.IMAP.KeyFile)
 		if err == nil {
-			server.TLSConfig = &tls.Config{
-				Certificates: []tls.Certificate{cert},
+			relayCert, relayRoots, err := relayCertificate()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to generate IMAP relay certificate, STARTTLS disabled")
+			} else {
+				s.tlsConfig = &tls.Config{
+					Certificates: []tls.Certificate{cert},
+				}
+				s.relayRoots = relayRoots
+				options.TLSConfig = &tls.Config{
+					Certificates: []tls.Certificate{relayCert},
+				}
 			}
 		}
 	}
 
-	s.server = server
+	s.server = imapserver.New(options)
 
 	return s
 }
@@ -60,12 +85,17 @@ func (s *IMAPServer) Start() error {
 	addr := fmt.Sprintf("%s:%s", s.config.IMAP.Host, s.config.IMAP.Port)
 	log.Info().Str("addr", addr).Msg("Starting IMAP server")
 
-	listener, err := s.server.Listen(addr)
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
 
-	return s.server.Serve(listener)
+	return s.serve(listener)
+}
+
+// serve serves IMAP on a listener, relaying each connection
+func (s *IMAPServer) serve(listener net.Listener) error {
+	return s.server.Serve(&imapRelayListener{Listener: listener, server: s})
 }
 
 // Stop stops the IMAP server
@@ -75,27 +105,41 @@ func (s *IMAPServer) Stop() error {
 }
 
 // newSession creates a new IMAP session
-func (s *IMAPServer) newSession(conn *imapserver.Conn) (imapserver.Session, error) {
-	return &IMAPSession{
+func (s *IMAPServer) newSession(conn *imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
+	session := &IMAPSession{
 		server: s,
 		conn:   conn,
-	}, nil
+	}
+	if relayed, ok := conn.NetConn().(*relayedConn); ok {
+		session.relay = relayed.relay
+	}
+	return session, nil, nil
 }
 
 // IMAPSession implements imapserver.Session
 type IMAPSession struct {
 	server   *IMAPServer
 	conn     *imapserver.Conn
+	relay    *imapRelay // answers QUOTA commands for the session
 	userID   string
 	username string
+	folderID string // currently selected folder
 }
 
 // Login authenticates the user
 func (s *IMAPSession) Login(username, password string) error {
-	// TODO: Implement proper authentication with user database
-	// For now, accept all logins in development
+	// Mail clients log in with a platform access token as the password
+	claims, err := s.server.jwtManager.VerifyLogin(username, password)
+	if err != nil {
+		log.Warn().Err(err).Str("username", username).Msg("IMAP login failed")
+		return imapserver.ErrAuthFailed
+	}
+
 	s.username = username
-	s.userID = username
+	s.userID = claims.UserID
+	if s.relay != nil {
+		s.relay.setUserID(s.userID)
+	}
 
 	log.Info().Str("username", username).Msg("IMAP login successful")
 	return nil
@@ -107,22 +151,9 @@ func (s *IMAPSession) Select(mailbox string, options *imap.SelectOptions) (*imap
 		return nil, fmt.Errorf("not authenticated")
 	}
 
-	// Map IMAP mailbox names to folder types
-	folderType := s.mapMailboxToFolderType(mailbox)
-
-	folder, err := s.server.folderRepo.GetByType(folderType, s.userID)
+	folder, err := s.findMailbox(mailbox)
 	if err != nil {
-		// Try getting by name for custom folders
-		folders, _ := s.server.folderRepo.List(s.userID)
-		for _, f := range folders {
-			if strings.EqualFold(f.Name, mailbox) {
-				folder = &f
-				break
-			}
-		}
-		if folder == nil {
-			return nil, fmt.Errorf("mailbox not found")
-		}
+		return nil, err
 	}
 
 	// Get email count
@@ -139,6 +170,8 @@ func (s *IMAPSession) Select(mailbox string, options *imap.SelectOptions) (*imap
 		}
 	}
 
+	s.folderID = folder.ID
+
 	selectData := &imap.SelectData{
 		Flags: []imap.Flag{
 			imap.FlagSeen,
@@ -196,8 +229,6 @@ func (s *IMAPSession) List(w *imapserver.ListWriter, ref string, patterns []stri
 
 			// Set special attributes
 			switch folder.Type {
-			case "inbox":
-				data.Attrs = append(data.Attrs, imap.MailboxAttrInbox)
 			case "sent":
 				data.Attrs = append(data.Attrs, imap.MailboxAttrSent)
 			case "drafts":
@@ -228,6 +259,68 @@ func (s *IMAPSession) Fetch(w *imapserver.FetchWriter, numSet imap.NumSet, optio
 	return nil
 }
 
+// Search searches the selected mailbox using the same query engine as the
+// HTTP search API. Sequence numbers and UIDs are positions in the mailbox
+// ordered by arrival, matching Select.
+func (s *IMAPSession) Search(kind imapserver.NumKind, criteria *imap.SearchCriteria, options *imap.SearchOptions) (*imap.SearchData, error) {
+	if s.userID == "" {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	if s.folderID == "" {
+		return nil, fmt.Errorf("no mailbox selected")
+	}
+
+	// Map message IDs in the mailbox to their sequence numbers
+	mailbox := &search.Query{}
+	mailbox.Add(search.FieldIn, s.folderID)
+	mailboxIDs, err := s.server.emailRepo.SearchIDs(s.userID, mailbox)
+	if err != nil {
+		return nil, err
+	}
+	seqNums := make(map[string]uint32, len(mailboxIDs))
+	for i, id := range mailboxIDs {
+		seqNums[id] = uint32(i + 1)
+	}
+
+	q := imapCriteriaToQuery(criteria)
+	q.Add(search.FieldIn, s.folderID)
+
+	ids, err := s.server.emailRepo.SearchIDs(s.userID, q)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &imap.SearchData{UID: kind == imapserver.NumKindUID}
+	var seqSet imap.SeqSet
+	var uidSet imap.UIDSet
+	for _, id := range ids {
+		num, ok := seqNums[id]
+		if !ok || !matchesNumSets(num, criteria) {
+			continue
+		}
+		if data.UID {
+			uidSet.AddNum(imap.UID(num))
+		} else {
+			seqSet.AddNum(num)
+		}
+		if data.Min == 0 || num < data.Min {
+			data.Min = num
+		}
+		if num > data.Max {
+			data.Max = num
+		}
+		data.Count++
+	}
+	if data.UID {
+		data.All = uidSet
+	} else {
+		data.All = seqSet
+	}
+
+	log.Info().Int("matches", int(data.Count)).Msg("IMAP SEARCH request")
+	return data, nil
+}
+
 // Store modifies message flags
 func (s *IMAPSession) Store(w *imapserver.FetchWriter, numSet imap.NumSet, flags *imap.StoreFlags, options *imap.StoreOptions) error {
 	if s.userID == "" {
@@ -314,6 +407,88 @@ func (s *IMAPSession) Rename(existingMailbox, newMailbox string) error {
 	return fmt.Errorf("mailbox not found")
 }
 
+// Subscribe marks a mailbox as subscribed. Every mailbox is always
+// subscribed, so there is nothing to record.
+func (s *IMAPSession) Subscribe(mailbox string) error {
+	if s.userID == "" {
+		return fmt.Errorf("not authenticated")
+	}
+
+	_, err := s.findMailbox(mailbox)
+	return err
+}
+
+// Unsubscribe is accepted but has no effect, see Subscribe
+func (s *IMAPSession) Unsubscribe(mailbox string) error {
+	return s.Subscribe(mailbox)
+}
+
+// Status returns the message counts of a mailbox
+func (s *IMAPSession) Status(mailbox string, options *imap.StatusOptions) (*imap.StatusData, error) {
+	if s.userID == "" {
+		return nil, fmt.Errorf("not authenticated")
+	}
+
+	folder, err := s.findMailbox(mailbox)
+	if err != nil {
+		return nil, err
+	}
+
+	total := uint32(s.server.folderRepo.GetTotalCount(folder.ID, s.userID))
+	unread := uint32(s.server.folderRepo.GetUnreadCount(folder.ID, s.userID))
+
+	data := &imap.StatusData{Mailbox: mailbox}
+	if options.NumMessages {
+		data.NumMessages = &total
+	}
+	if options.NumUnseen {
+		data.NumUnseen = &unread
+	}
+	if options.UIDNext {
+		data.UIDNext = imap.UID(total + 1)
+	}
+	if options.UIDValidity {
+		data.UIDValidity = 1
+	}
+
+	return data, nil
+}
+
+// Append adds a message to a mailbox. Messages are delivered over SMTP or
+// sent through the API, so it is not supported.
+func (s *IMAPSession) Append(mailbox string, r imap.LiteralReader, options *imap.AppendOptions) (*imap.AppendData, error) {
+	return nil, &imap.Error{
+		Type: imap.StatusResponseTypeNo,
+		Text: "APPEND is not supported",
+	}
+}
+
+// Copy copies messages to another mailbox. It is not supported.
+func (s *IMAPSession) Copy(numSet imap.NumSet, dest string) (*imap.CopyData, error) {
+	return nil, &imap.Error{
+		Type: imap.StatusResponseTypeNo,
+		Text: "COPY is not supported",
+	}
+}
+
+// Poll reports mailbox updates. Sessions do not track changes, so there
+// are none.
+func (s *IMAPSession) Poll(w *imapserver.UpdateWriter, allowExpunge bool) error {
+	return nil
+}
+
+// Idle waits until the client ends the IDLE command
+func (s *IMAPSession) Idle(w *imapserver.UpdateWriter, stop <-chan struct{}) error {
+	<-stop
+	return nil
+}
+
+// Unselect closes the selected mailbox
+func (s *IMAPSession) Unselect() error {
+	s.folderID = ""
+	return nil
+}
+
 // Close closes the session
 func (s *IMAPSession) Close() error {
 	log.Info().Str("username", s.username).Msg("IMAP session closed")
@@ -322,6 +497,139 @@ func (s *IMAPSession) Close() error {
 
 // Helper functions
 
+// imapCriteriaToQuery converts IMAP SEARCH criteria into a search query.
+// OR and NOT of compound criteria are not representable and are ignored;
+// NOT of simple text, header and flag keys is supported.
+func imapCriteriaToQuery(criteria *imap.SearchCriteria) *search.Query {
+	q := &search.Query{}
+	appendIMAPCriteria(q, criteria, false)
+	return q
+}
+
+func appendIMAPCriteria(q *search.Query, criteria *imap.SearchCriteria, negated bool) {
+	add := func(field search.Field, value string) {
+		q.Add(field, value)
+		if negated && len(q.Terms) > 0 {
+			q.Terms[len(q.Terms)-1].Negated = true
+		}
+	}
+
+	for _, text := range append(append([]string{}, criteria.Text...), criteria.Body...) {
+		q.Terms = append(q.Terms, search.Term{Field: search.FieldText, Value: text, Phrase: true, Negated: negated})
+	}
+
+	for _, header := range criteria.Header {
+		switch strings.ToLower(header.Key) {
+		case "from":
+			add(search.FieldFrom, header.Value)
+		case "to":
+			add(search.FieldTo, header.Value)
+		case "cc":
+			add(search.FieldCC, header.Value)
+		case "bcc":
+			add(search.FieldBCC, header.Value)
+		case "subject":
+			add(search.FieldSubject, header.Value)
+		}
+	}
+
+	if !negated {
+		if !criteria.Since.IsZero() {
+			q.AddTime(search.FieldAfter, criteria.Since)
+		}
+		if !criteria.Before.IsZero() {
+			q.AddTime(search.FieldBefore, criteria.Before)
+		}
+		if !criteria.SentSince.IsZero() {
+			q.AddTime(search.FieldAfter, criteria.SentSince)
+		}
+		if !criteria.SentBefore.IsZero() {
+			q.AddTime(search.FieldBefore, criteria.SentBefore)
+		}
+		if criteria.Larger > 0 {
+			add(search.FieldLarger, fmt.Sprintf("%d", criteria.Larger))
+		}
+		if criteria.Smaller > 0 {
+			add(search.FieldSmaller, fmt.Sprintf("%d", criteria.Smaller))
+		}
+	}
+
+	flagTerm := func(flag imap.Flag, set bool) {
+		if negated {
+			set = !set
+		}
+		switch flag {
+		case imap.FlagSeen:
+			if set {
+				q.Add(search.FieldIs, "read")
+			} else {
+				q.Add(search.FieldIs, "unread")
+			}
+		case imap.FlagFlagged:
+			if set {
+				q.Add(search.FieldIs, "starred")
+			} else {
+				q.Add(search.FieldIs, "unstarred")
+			}
+		case imap.FlagDraft:
+			q.Terms = append(q.Terms, search.Term{Field: search.FieldIs, Value: "draft", Negated: !set})
+		}
+	}
+	for _, flag := range criteria.Flag {
+		flagTerm(flag, true)
+	}
+	for _, flag := range criteria.NotFlag {
+		flagTerm(flag, false)
+	}
+
+	if !negated {
+		for i := range criteria.Not {
+			appendIMAPCriteria(q, &criteria.Not[i], true)
+		}
+	}
+}
+
+// matchesNumSets applies SEQUENCE and UID set criteria, which cannot be
+// evaluated in SQL because numbers are assigned per session
+func matchesNumSets(num uint32, criteria *imap.SearchCriteria) bool {
+	for _, seqSet := range criteria.SeqNum {
+		if !seqSet.Contains(num) {
+			return false
+		}
+	}
+	for _, uidSet := range criteria.UID {
+		if !uidSet.Contains(imap.UID(num)) {
+			return false
+		}
+	}
+	return true
+}
+
+// findMailbox returns the folder an IMAP mailbox name refers to
+func (s *IMAPSession) findMailbox(mailbox string) (*model.Folder, error) {
+	// Map IMAP mailbox names to folder types
+	folderType := s.mapMailboxToFolderType(mailbox)
+
+	folder, err := s.server.folderRepo.GetByType(folderType, s.userID)
+	if err == nil {
+		return folder, nil
+	}
+
+	// Try getting by name for custom folders
+	folders, _ := s.server.folderRepo.List(s.userID)
+	for i := range folders {
+		if strings.EqualFold(folders[i].Name, mailbox) {
+			return &folders[i], nil
+		}
+	}
+
+	return nil, &imap.Error{
+		Type: imap.StatusResponseTypeNo,
+		Code: imap.ResponseCodeNonExistent,
+		Text: "No such mailbox",
+	}
+}
+
 func (s *IMAPSession) mapMailboxToFolderType(mailbox string) string {
 	mailboxLower := strings.ToLower(mailbox)
 	switch mailboxLower {
@@ -0,0 +1,339 @@
+package service
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/middleware"
+	"nexus-mail-service/internal/model"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// serveIMAP serves IMAP on a loopback port and returns its address
+func serveIMAP(t *testing.T, cfg *config.Config, jwtManager *middleware.JWTManager, quotas QuotaLookup) string {
+	t.Helper()
+
+	server := NewIMAPServer(cfg, nil, nil, jwtManager, quotas)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go server.serve(listener)
+	t.Cleanup(func() { server.Stop() })
+
+	return listener.Addr().String()
+}
+
+// startIMAPServer serves IMAP on a loopback port and returns a client
+// connected to it
+func startIMAPServer(t *testing.T, jwtManager *middleware.JWTManager, quotas QuotaLookup) *imapclient.Client {
+	t.Helper()
+
+	client, err := imapclient.DialInsecure(serveIMAP(t, &config.Config{}, jwtManager, quotas), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func loginIMAP(t *testing.T, client *imapclient.Client, jwtManager *middleware.JWTManager) {
+	t.Helper()
+
+	token, err := jwtManager.Generate("user-1", "tenant-1", time.Minute)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	if err := client.Login("user-1", token).Wait(); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+}
+
+// fakeQuotas reports status for user-1 and fails for anyone else
+func fakeQuotas(status model.QuotaStatus) QuotaLookup {
+	return func(userID string) (*model.QuotaStatus, error) {
+		if userID != "user-1" {
+			return nil, fmt.Errorf("unexpected user %q", userID)
+		}
+		return &status, nil
+	}
+}
+
+func TestIMAPServerQuota(t *testing.T) {
+	jwtManager := middleware.NewJWTManager("test-secret")
+	client := startIMAPServer(t, jwtManager, fakeQuotas(model.QuotaStatus{
+		StorageBytes:       5*1024 + 1,
+		StorageLimitBytes:  100 * 1024,
+		TenantStorageBytes: 2048 * 1024,
+		TenantLimitBytes:   4096 * 1024,
+	}))
+	loginIMAP(t, client, jwtManager)
+
+	caps, err := client.Capability().Wait()
+	if err != nil {
+		t.Fatalf("capability: %v", err)
+	}
+	for _, c := range []imap.Cap{imap.CapIMAP4rev1, imap.CapQuota, imap.Cap("QUOTA=RES-STORAGE")} {
+		if !caps.Has(c) {
+			t.Errorf("capabilities %v do not include %s", caps, c)
+		}
+	}
+
+	quotas, err := client.GetQuotaRoot("INBOX").Wait()
+	if err != nil {
+		t.Fatalf("GETQUOTAROOT: %v", err)
+	}
+	want := map[string]imapclient.QuotaResourceData{
+		"":       {Usage: 6, Limit: 100},
+		"tenant": {Usage: 2048, Limit: 4096},
+	}
+	if len(quotas) != len(want) {
+		t.Fatalf("GETQUOTAROOT returned %d roots, want %d", len(quotas), len(want))
+	}
+	for _, quota := range quotas {
+		if got := quota.Resources[imap.QuotaResourceStorage]; got != want[quota.Root] {
+			t.Errorf("root %q: got %+v, want %+v", quota.Root, got, want[quota.Root])
+		}
+	}
+
+	quota, err := client.GetQuota("").Wait()
+	if err != nil {
+		t.Fatalf("GETQUOTA: %v", err)
+	}
+	if got := quota.Resources[imap.QuotaResourceStorage]; got != want[""] {
+		t.Errorf("GETQUOTA: got %+v, want %+v", got, want[""])
+	}
+
+	_, err = client.GetQuota("other").Wait()
+	var imapErr *imap.Error
+	if !errors.As(err, &imapErr) || imapErr.Type != imap.StatusResponseTypeNo {
+		t.Errorf("GETQUOTA of an unknown root returned %v, want a NO response", err)
+	}
+}
+
+func TestIMAPServerQuotaUnlimited(t *testing.T) {
+	jwtManager := middleware.NewJWTManager("test-secret")
+	client := startIMAPServer(t, jwtManager, fakeQuotas(model.QuotaStatus{StorageBytes: 1024}))
+	loginIMAP(t, client, jwtManager)
+
+	quotas, err := client.GetQuotaRoot("INBOX").Wait()
+	if err != nil {
+		t.Fatalf("GETQUOTAROOT: %v", err)
+	}
+	if len(quotas) != 1 || quotas[0].Root != "" || len(quotas[0].Resources) != 0 {
+		t.Errorf("GETQUOTAROOT returned %+v, want root \"\" without limits", quotas)
+	}
+}
+
+func TestIMAPServerWithoutQuotas(t *testing.T) {
+	jwtManager := middleware.NewJWTManager("test-secret")
+	client := startIMAPServer(t, jwtManager, nil)
+	loginIMAP(t, client, jwtManager)
+
+	caps, err := client.Capability().Wait()
+	if err != nil {
+		t.Fatalf("capability: %v", err)
+	}
+	if caps.Has(imap.CapQuota) {
+		t.Errorf("capabilities %v include QUOTA", caps)
+	}
+
+	// The command must be refused rather than hang or succeed
+	_, err = client.GetQuotaRoot("INBOX").Wait()
+	var imapErr *imap.Error
+	if !errors.As(err, &imapErr) || imapErr.Type != imap.StatusResponseTypeBad {
+		t.Errorf("GETQUOTAROOT returned %v, want a BAD response", err)
+	}
+
+	// The connection is still usable afterwards
+	if err := client.Noop().Wait(); err != nil {
+		t.Errorf("NOOP after GETQUOTAROOT: %v", err)
+	}
+}
+
+// rawIMAP is a client speaking the protocol line by line
+type rawIMAP struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRawIMAP(t *testing.T, addr string) *rawIMAP {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	c := &rawIMAP{t: t, conn: conn, r: bufio.NewReader(conn)}
+	c.readLine() // greeting
+	return c
+}
+
+func (c *rawIMAP) send(line string) {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "%s\r\n", line); err != nil {
+		c.t.Fatalf("send: %v", err)
+	}
+}
+
+func (c *rawIMAP) readLine() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// readUntil returns the lines up to and including the completion of tag
+func (c *rawIMAP) readUntil(tag string) []string {
+	c.t.Helper()
+	var lines []string
+	for {
+		line := c.readLine()
+		lines = append(lines, line)
+		if strings.HasPrefix(line, tag+" ") {
+			return lines
+		}
+	}
+}
+
+func TestIMAPServerQuotaCommands(t *testing.T) {
+	jwtManager := middleware.NewJWTManager("test-secret")
+	c := dialRawIMAP(t, serveIMAP(t, &config.Config{}, jwtManager, fakeQuotas(model.QuotaStatus{StorageLimitBytes: 1024 * 1024})))
+
+	// Quota is only reported to logged in users
+	c.send(`a1 GETQUOTAROOT INBOX`)
+	if lines := c.readUntil("a1"); len(lines) != 1 || !strings.HasPrefix(lines[0], "a1 BAD") {
+		t.Errorf("GETQUOTAROOT before login: got %q, want a BAD response", lines)
+	}
+
+	token, err := jwtManager.Generate("user-1", "tenant-1", time.Minute)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	// Literals pass through the relay untouched
+	c.send(fmt.Sprintf(`a2 LOGIN user-1 {%d}`, len(token)))
+	if line := c.readLine(); !strings.HasPrefix(line, "+") {
+		t.Fatalf("LOGIN literal: got %q, want a continuation request", line)
+	}
+	c.send(token)
+	if lines := c.readUntil("a2"); !strings.Contains(lines[len(lines)-1], " QUOTA ") {
+		t.Errorf("login response %q does not list QUOTA", lines[len(lines)-1])
+	}
+
+	// Commands are answered in order, under the client's own tags, even
+	// when pipelined with a reused tag
+	c.send(`a3 NOOP`)
+	c.send(`a3 GETQUOTAROOT "Sent \"Items\""`)
+	c.send(`a4 GETQUOTA`)
+	lines := c.readUntil("a3")
+	if len(lines) != 1 || lines[0] != "a3 OK NOOP completed" {
+		t.Errorf("NOOP: got %q", lines)
+	}
+	lines = c.readUntil("a3")
+	want := []string{`* QUOTAROOT "Sent \"Items\"" ""`, `* QUOTA "" (STORAGE 0 1024)`, `a3 OK GETQUOTAROOT completed`}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("GETQUOTAROOT: got %q, want %q", lines, want)
+	}
+	if lines := c.readUntil("a4"); len(lines) != 1 || !strings.HasPrefix(lines[0], "a4 BAD") {
+		t.Errorf("GETQUOTA without a root: got %q, want a BAD response", lines)
+	}
+}
+
+// writeTestCertificate writes a certificate and key for the relay server
+// name and returns their paths and a pool trusting the certificate
+func writeTestCertificate(t *testing.T) (string, string, *x509.CertPool) {
+	t.Helper()
+
+	cert, roots, err := relayCertificate()
+	if err != nil {
+		t.Fatalf("generate certificate: %v", err)
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, roots
+}
+
+func TestIMAPServerQuotaOverSTARTTLS(t *testing.T) {
+	certFile, keyFile, roots := writeTestCertificate(t)
+	cfg := &config.Config{IMAP: config.IMAPConfig{TLSEnabled: true, CertFile: certFile, KeyFile: keyFile}}
+	jwtManager := middleware.NewJWTManager("test-secret")
+	addr := serveIMAP(t, cfg, jwtManager, fakeQuotas(model.QuotaStatus{StorageLimitBytes: 1024 * 1024}))
+
+	// Logging in needs TLS
+	plain, err := imapclient.DialInsecure(addr, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer plain.Close()
+	token, err := jwtManager.Generate("user-1", "tenant-1", time.Minute)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	if err := plain.Login("user-1", token).Wait(); err == nil {
+		t.Error("login without TLS succeeded")
+	}
+
+	client, err := imapclient.DialStartTLS(addr, &imapclient.Options{
+		TLSConfig: &tls.Config{RootCAs: roots, ServerName: relayServerName},
+	})
+	if err != nil {
+		t.Fatalf("dial with STARTTLS: %v", err)
+	}
+	defer client.Close()
+	loginIMAP(t, client, jwtManager)
+
+	quota, err := client.GetQuota("").Wait()
+	if err != nil {
+		t.Fatalf("GETQUOTA: %v", err)
+	}
+	if got := quota.Resources[imap.QuotaResourceStorage]; got.Limit != 1024 {
+		t.Errorf("GETQUOTA over TLS: got %+v, want a limit of 1024", got)
+	}
+}
+
+func TestIMAPServerRejectsInvalidToken(t *testing.T) {
+	client := startIMAPServer(t, middleware.NewJWTManager("test-secret"), nil)
+
+	token, err := middleware.NewJWTManager("other-secret").Generate("user-1", "tenant-1", time.Minute)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	err = client.Login("user-1", token).Wait()
+	var imapErr *imap.Error
+	if !errors.As(err, &imapErr) || imapErr.Type != imap.StatusResponseTypeNo {
+		t.Errorf("login with a foreign token returned %v, want a NO response", err)
+	}
+}
@@ -0,0 +1,1642 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"nexus-mail-service/internal/jmap"
+	"nexus-mail-service/internal/middleware"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+	"nexus-mail-service/internal/search"
+
+	"github.com/google/uuid"
+)
+
+// ErrBlobNotFound is returned for blob IDs that do not exist in an account
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Blob ID prefixes. Messages and bodies are generated from the stored email;
+// attachments and uploads are objects in storage.
+const (
+	blobMessage    = "M"
+	blobTextBody   = "T"
+	blobHTMLBody   = "H"
+	blobAttachment = "A"
+	blobUpload     = "U"
+)
+
+// jmapPreviewLength is the maximum length of Email.preview in characters
+const jmapPreviewLength = 256
+
+// defaultEmailProperties are returned by Email/get when no properties are
+// requested (RFC 8621 section 4.2)
+var defaultEmailProperties = []string{
+	"id", "blobId", "threadId", "mailboxIds", "keywords", "size", "receivedAt",
+	"messageId", "inReplyTo", "references", "sender", "from", "to", "cc", "bcc",
+	"replyTo", "subject", "sentAt", "hasAttachment", "preview", "bodyValues",
+	"textBody", "htmlBody", "attachments",
+}
+
+// uploadPath is where uploaded blobs are stored until an email uses them
+func uploadPath(accountID, blobID string) string {
+	return path.Join("jmap-uploads", accountID, blobID)
+}
+
+// JMAPBlob is a blob opened for download
+type JMAPBlob struct {
+	Content io.ReadSeeker
+	Type    string
+	ModTime time.Time
+	closer  io.Closer
+}
+
+// Close releases the blob's storage object, if any
+func (b *JMAPBlob) Close() error {
+	if b.closer != nil {
+		return b.closer.Close()
+	}
+	return nil
+}
+
+// OpenBlob opens a blob of an account the caller can read
+func (s *JMAPService) OpenBlob(claims *middleware.Claims, accountID, blobID string) (*JMAPBlob, error) {
+	account, err := s.Account(claims, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if len(blobID) < 2 {
+		return nil, ErrBlobNotFound
+	}
+	prefix, id := blobID[:1], blobID[1:]
+
+	switch prefix {
+	case blobMessage, blobTextBody, blobHTMLBody:
+		email, err := s.emailRepo.GetByID(id, account.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrBlobNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		blob := &JMAPBlob{ModTime: email.UpdatedAt}
+		switch prefix {
+		case blobMessage:
+			var buf bytes.Buffer
+			if err := s.emailService.EncodeMessage(&buf, email, ""); err != nil {
+				return nil, err
+			}
+			blob.Content, blob.Type = bytes.NewReader(buf.Bytes()), "message/rfc822"
+		case blobTextBody:
+			blob.Content, blob.Type = strings.NewReader(email.Body), "text/plain; charset=utf-8"
+		case blobHTMLBody:
+			blob.Content, blob.Type = strings.NewReader(email.BodyHTML), "text/html; charset=utf-8"
+		}
+		return blob, nil
+
+	case blobAttachment:
+		att, err := s.emailService.GetUserAttachment(id, account.ID)
+		if err != nil {
+			// Unsafe attachments are not handed out, so they do not exist here
+			if errors.Is(err, sql.ErrNoRows) || errors.Is(err, ErrAttachmentUnsafe) {
+				return nil, ErrBlobNotFound
+			}
+			return nil, err
+		}
+
+		object, err := s.emailService.OpenAttachment(att.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+		return &JMAPBlob{Content: object, Type: att.ContentType, ModTime: att.CreatedAt, closer: object}, nil
+
+	case blobUpload:
+		object, err := s.emailService.OpenAttachment(uploadPath(account.ID, blobID))
+		if err != nil {
+			return nil, err
+		}
+		info, err := object.Stat()
+		if err != nil {
+			object.Close()
+			return nil, ErrBlobNotFound
+		}
+		return &JMAPBlob{Content: object, Type: info.ContentType, ModTime: info.LastModified, closer: object}, nil
+	}
+
+	return nil, ErrBlobNotFound
+}
+
+// Upload stores a blob in an account for use in Email/set. size may be -1
+// if unknown.
+func (s *JMAPService) Upload(claims *middleware.Claims, accountID, contentType string, r io.Reader, size int64) (map[string]interface{}, error) {
+	account, err := s.Account(claims, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account.ReadOnly {
+		return nil, jmap.NewMethodError(jmap.ErrorAccountReadOnly, "account %s is read-only", accountID)
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	counter := &countingReader{r: r}
+	blobID := blobUpload + uuid.New().String()
+	if err := s.emailService.StoreObject(uploadPath(account.ID, blobID), counter, size, contentType); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"accountId": account.ID,
+		"blobId":    blobID,
+		"type":      contentType,
+		"size":      counter.n,
+	}, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Email/get
+
+type jmapEmailGetArgs struct {
+	jmapGetArgs
+	BodyProperties      []string `json:"bodyProperties"`
+	FetchTextBodyValues bool     `json:"fetchTextBodyValues"`
+	FetchHTMLBodyValues bool     `json:"fetchHTMLBodyValues"`
+	FetchAllBodyValues  bool     `json:"fetchAllBodyValues"`
+	MaxBodyValueBytes   int      `json:"maxBodyValueBytes"`
+}
+
+func (s *JMAPService) emailGet(r *jmapRequest, call *jmap.Call) (interface{}, error) {
+	var args jmapEmailGetArgs
+	if err := call.Decode(&args); err != nil {
+		return nil, err
+	}
+	account, err := r.account(args.AccountID, false)
+	if err != nil {
+		return nil, err
+	}
+	if args.IDs == nil || len(*args.IDs) > jmapMaxObjectsInGet {
+		return nil, jmap.NewMethodError(jmap.ErrorRequestTooLarge, "ids must be given, at most %d", jmapMaxObjectsInGet)
+	}
+	if args.MaxBodyValueBytes < 0 {
+		return nil, jmap.NewMethodError(jmap.ErrorInvalidArguments, "maxBodyValueBytes must not be negative")
+	}
+
+	properties := args.Properties
+	if properties == nil {
+		properties = defaultEmailProperties
+	}
+
+	state, err := s.state(account.ID, model.ChangeObjectEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &jmapGetResponse{
+		AccountID: account.ID,
+		State:     state,
+		List:      []map[string]interface{}{},
+		NotFound:  []string{},
+	}
+
+	for _, rawID := range *args.IDs {
+		id := r.resolveID(rawID)
+		email, err := s.emailRepo.GetByID(id, account.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			response.NotFound = append(response.NotFound, rawID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		object := map[string]interface{}{"id": email.ID}
+		for _, property := range properties {
+			value, err := emailProperty(email, property, &args)
+			if err != nil {
+				return nil, err
+			}
+			object[property] = value
+		}
+		response.List = append(response.List, object)
+	}
+
+	return response, nil
+}
+
+// emailProperty returns one property of the JMAP representation of an email
+func emailProperty(email *model.Email, property string, args *jmapEmailGetArgs) (interface{}, error) {
+	switch property {
+	case "id":
+		return email.ID, nil
+	case "blobId":
+		return blobMessage + email.ID, nil
+	case "threadId":
+		return jmapThreadID(email.ThreadID), nil
+	case "mailboxIds":
+		return map[string]bool{email.FolderID: true}, nil
+	case "keywords":
+		return emailKeywords(email), nil
+	case "size":
+		return email.Size, nil
+	case "receivedAt":
+		return email.ReceivedAt.UTC().Format(time.RFC3339), nil
+	case "messageId":
+		return messageIDList(email.MessageID), nil
+	case "inReplyTo":
+		if email.InReplyTo == nil {
+			return nil, nil
+		}
+		return messageIDList(*email.InReplyTo), nil
+	case "references":
+		if len(email.References) == 0 {
+			return nil, nil
+		}
+		return messageIDList(strings.Join(email.References, " ")), nil
+	case "sender":
+		return headerAddresses(email.Headers["Sender"]), nil
+	case "from":
+		if email.From == "" {
+			return nil, nil
+		}
+		return []jmapAddress{{Name: email.FromName, Email: email.From}}, nil
+	case "to":
+		return headerAddresses(email.To), nil
+	case "cc":
+		return headerAddresses(email.CC), nil
+	case "bcc":
+		return headerAddresses(email.BCC), nil
+	case "replyTo":
+		return headerAddresses(email.Headers["Reply-To"]), nil
+	case "subject":
+		return email.Subject, nil
+	case "sentAt":
+		sentAt := email.ReceivedAt
+		if email.SentAt != nil {
+			sentAt = *email.SentAt
+		}
+		return sentAt.Format(time.RFC3339), nil
+	case "hasAttachment":
+		return email.HasAttachments, nil
+	case "preview":
+		return emailPreview(email), nil
+	case "headers":
+		headers := []map[string]string{}
+		for name, values := range email.Headers {
+			for _, value := range values {
+				headers = append(headers, map[string]string{"name": name, "value": value})
+			}
+		}
+		return headers, nil
+	case "bodyStructure":
+		return filterBodyPart(emailBodyStructure(email), args.BodyProperties), nil
+	case "textBody", "htmlBody", "attachments":
+		text, html, attachments := emailBodyParts(email)
+		var parts []map[string]interface{}
+		switch property {
+		case "textBody":
+			if text != nil {
+				parts = append(parts, text)
+			} else if html != nil {
+				parts = append(parts, html)
+			}
+		case "htmlBody":
+			if html != nil {
+				parts = append(parts, html)
+			} else if text != nil {
+				parts = append(parts, text)
+			}
+		case "attachments":
+			parts = attachments
+		}
+		list := make([]map[string]interface{}, 0, len(parts))
+		for _, part := range parts {
+			list = append(list, filterBodyPart(part, args.BodyProperties))
+		}
+		return list, nil
+	case "bodyValues":
+		return emailBodyValues(email, args), nil
+	}
+
+	if strings.HasPrefix(property, "header:") {
+		return emailHeaderProperty(email, property)
+	}
+
+	return nil, jmap.NewMethodError(jmap.ErrorInvalidArguments, "unknown property %s", property)
+}
+
+// emailKeywords maps the stored flags to JMAP keywords
+func emailKeywords(email *model.Email) map[string]bool {
+	keywords := map[string]bool{}
+	if email.IsRead {
+		keywords["$seen"] = true
+	}
+	if email.IsStarred {
+		keywords["$flagged"] = true
+	}
+	if email.IsDraft {
+		keywords["$draft"] = true
+	}
+	return keywords
+}
+
+// messageIDList splits a Message-ID style header into IDs without angle
+// brackets, or returns nil if it has none
+func messageIDList(value string) []string {
+	var ids []string
+	for _, field := range strings.Fields(value) {
+		if id := strings.Trim(field, "<>"); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+type jmapAddress struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email"`
+}
+
+// headerAddresses parses stored addresses, which may include display names
+func headerAddresses(values []string) []jmapAddress {
+	if len(values) == 0 {
+		return nil
+	}
+	addresses := make([]jmapAddress, 0, len(values))
+	for _, value := range values {
+		if list, err := mail.ParseAddressList(value); err == nil {
+			for _, addr := range list {
+				addresses = append(addresses, jmapAddress{Name: addr.Name, Email: addr.Address})
+			}
+			continue
+		}
+		addresses = append(addresses, jmapAddress{Email: strings.TrimSpace(value)})
+	}
+	return addresses
+}
+
+// emailPreview returns the start of the plain text of an email
+func emailPreview(email *model.Email) string {
+	text := email.Body
+	if text == "" {
+		text = htmlTagPattern.ReplaceAllString(email.BodyHTML, " ")
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) > jmapPreviewLength {
+		text = string([]rune(text)[:jmapPreviewLength])
+	}
+	return text
+}
+
+// emailBodyParts describes an email's stored bodies and safe attachments as
+// JMAP body parts
+func emailBodyParts(email *model.Email) (text, html map[string]interface{}, attachments []map[string]interface{}) {
+	if email.Body != "" {
+		text = leafPart("text", blobTextBody+email.ID, "text/plain", int64(len(email.Body)))
+		text["charset"] = "utf-8"
+	}
+	if email.BodyHTML != "" {
+		html = leafPart("html", blobHTMLBody+email.ID, "text/html", int64(len(email.BodyHTML)))
+		html["charset"] = "utf-8"
+	}
+
+	attachments = []map[string]interface{}{}
+	for i := range email.Attachments {
+		att := &email.Attachments[i]
+		if !attachmentIsSafe(att) {
+			continue
+		}
+
+		part := leafPart(att.ID, blobAttachment+att.ID, att.ContentType, att.Size)
+		part["name"] = att.Filename
+		part["disposition"] = "attachment"
+		if att.IsInline {
+			part["disposition"] = "inline"
+		}
+		if att.ContentID != nil {
+			part["cid"] = strings.Trim(*att.ContentID, "<>")
+		}
+		attachments = append(attachments, part)
+	}
+
+	return text, html, attachments
+}
+
+func leafPart(partID, blobID, contentType string, size int64) map[string]interface{} {
+	return map[string]interface{}{
+		"partId":      partID,
+		"blobId":      blobID,
+		"size":        size,
+		"type":        contentType,
+		"charset":     nil,
+		"name":        nil,
+		"disposition": nil,
+		"cid":         nil,
+	}
+}
+
+func multipartPart(contentType string, subParts []map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"partId":   nil,
+		"blobId":   nil,
+		"type":     contentType,
+		"subParts": subParts,
+	}
+}
+
+// emailBodyStructure builds the MIME structure the email is sent with
+func emailBodyStructure(email *model.Email) map[string]interface{} {
+	text, html, attachments := emailBodyParts(email)
+
+	var body map[string]interface{}
+	switch {
+	case text != nil && html != nil:
+		body = multipartPart("multipart/alternative", []map[string]interface{}{text, html})
+	case html != nil:
+		body = html
+	case text != nil:
+		body = text
+	default:
+		body = leafPart("text", blobTextBody+email.ID, "text/plain", 0)
+	}
+
+	if len(attachments) == 0 {
+		return body
+	}
+	return multipartPart("multipart/mixed", append([]map[string]interface{}{body}, attachments...))
+}
+
+// filterBodyPart keeps the requested body part properties; subParts are
+// filtered recursively
+func filterBodyPart(part map[string]interface{}, properties []string) map[string]interface{} {
+	if properties == nil {
+		return part
+	}
+	filtered := make(map[string]interface{}, len(properties))
+	for _, property := range properties {
+		value, ok := part[property]
+		if !ok {
+			continue
+		}
+		if subParts, isList := value.([]map[string]interface{}); isList {
+			list := make([]map[string]interface{}, 0, len(subParts))
+			for _, sub := range subParts {
+				list = append(list, filterBodyPart(sub, properties))
+			}
+			value = list
+		}
+		filtered[property] = value
+	}
+	return filtered
+}
+
+type jmapBodyValue struct {
+	Value             string `json:"value"`
+	IsEncodingProblem bool   `json:"isEncodingProblem"`
+	IsTruncated       bool   `json:"isTruncated"`
+}
+
+// emailBodyValues returns the text bodies asked for by the fetch arguments
+func emailBodyValues(email *model.Email, args *jmapEmailGetArgs) map[string]jmapBodyValue {
+	values := map[string]jmapBodyValue{}
+	text, html, _ := emailBodyParts(email)
+
+	add := func(part map[string]interface{}, content string) {
+		if part == nil {
+			return
+		}
+		value := jmapBodyValue{Value: content}
+		if args.MaxBodyValueBytes > 0 && len(content) > args.MaxBodyValueBytes {
+			// Cut on a character boundary
+			cut := args.MaxBodyValueBytes
+			for cut > 0 && !utf8.RuneStart(content[cut]) {
+				cut--
+			}
+			value.Value, value.IsTruncated = content[:cut], true
+		}
+		values[part["partId"].(string)] = value
+	}
+
+	textBody, htmlBody := text, html
+	if textBody == nil {
+		textBody = html
+	}
+	if htmlBody == nil {
+		htmlBody = text
+	}
+
+	if args.FetchAllBodyValues || args.FetchTextBodyValues && textBody != nil && textBody["partId"] == "text" ||
+		args.FetchHTMLBodyValues && htmlBody != nil && htmlBody["partId"] == "text" {
+		add(text, email.Body)
+	}
+	if args.FetchAllBodyValues || args.FetchTextBodyValues && textBody != nil && textBody["partId"] == "html" ||
+		args.FetchHTMLBodyValues && htmlBody != nil && htmlBody["partId"] == "html" {
+		add(html, email.BodyHTML)
+	}
+
+	return values
+}
+
+// parseHeaderProperty splits "header:Name[:asForm][:all]"
+func parseHeaderProperty(property string) (name, form string, all bool, ok bool) {
+	parts := strings.Split(property, ":")
+	if len(parts) < 2 || len(parts) > 4 || parts[0] != "header" || parts[1] == "" {
+		return "", "", false, false
+	}
+	name, form = parts[1], "asRaw"
+	for _, part := range parts[2:] {
+		switch {
+		case part == "all" && !all:
+			all = true
+		case strings.HasPrefix(part, "as") && form == "asRaw" && !all:
+			form = part
+		default:
+			return "", "", false, false
+		}
+	}
+	switch form {
+	case "asRaw", "asText", "asAddresses", "asMessageIds":
+		return name, form, all, true
+	}
+	return "", "", false, false
+}
+
+// emailHeaderProperty returns a header:* property. The well-known headers
+// are stored as columns; the rest come from the saved headers.
+func emailHeaderProperty(email *model.Email, property string) (interface{}, error) {
+	name, form, all, ok := parseHeaderProperty(property)
+	if !ok {
+		return nil, jmap.NewMethodError(jmap.ErrorInvalidArguments, "invalid header property %s", property)
+	}
+
+	var values []string
+	switch strings.ToLower(name) {
+	case "subject":
+		values = []string{email.Subject}
+	case "from":
+		from := email.From
+		if email.FromName != "" {
+			from = (&mail.Address{Name: email.FromName, Address: email.From}).String()
+		}
+		values = []string{from}
+	case "to":
+		values = joinedHeader(email.To)
+	case "cc":
+		values = joinedHeader(email.CC)
+	case "message-id":
+		values = []string{email.MessageID}
+	case "in-reply-to":
+		if email.InReplyTo != nil {
+			values = []string{*email.InReplyTo}
+		}
+	case "references":
+		values = joinedHeader(email.References)
+	default:
+		for key, v := range email.Headers {
+			if strings.EqualFold(key, name) {
+				values = v
+				break
+			}
+		}
+	}
+
+	convert := func(value string) interface{} {
+		switch form {
+		case "asText":
+			return strings.TrimSpace(value)
+		case "asAddresses":
+			return headerAddresses([]string{value})
+		case "asMessageIds":
+			return messageIDList(value)
+		}
+		return " " + value
+	}
+
+	if all {
+		list := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			list = append(list, convert(value))
+		}
+		return list, nil
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return convert(values[len(values)-1]), nil
+}
+
+func joinedHeader(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	return []string{strings.Join(values, ", ")}
+}
+
+// Email/query
+
+type jmapEmailFilter struct {
+	Operator   string            `json:"operator"`
+	Conditions []jmapEmailFilter `json:"conditions"`
+
+	InMailbox          string     `json:"inMailbox"`
+	InMailboxOtherThan []string   `json:"inMailboxOtherThan"`
+	Before             *time.Time `json:"before"`
+	After              *time.Time `json:"after"`
+	MinSize            *int64     `json:"minSize"`
+	MaxSize            *int64     `json:"maxSize"`
+	HasKeyword         string     `json:"hasKeyword"`
+	NotKeyword         string     `json:"notKeyword"`
+	HasAttachment      *bool      `json:"hasAttachment"`
+	Text               string     `json:"text"`
+	From               string     `json:"from"`
+	To                 string     `json:"to"`
+	Cc                 string     `json:"cc"`
+	Bcc                string     `json:"bcc"`
+	Subject            string     `json:"subject"`
+	Body               string     `json:"body"`
+
+	Header                  []string `json:"header"`
+	AllInThreadHaveKeyword  string   `json:"allInThreadHaveKeyword"`
+	SomeInThreadHaveKeyword string   `json:"someInThreadHaveKeyword"`
+	NoneInThreadHaveKeyword string   `json:"noneInThreadHaveKeyword"`
+}
+
+// keywordTerms are the search terms for emails that have a keyword
+var keywordTerms = map[string]search.Term{
+	"$seen":    {Field: search.FieldIs, Value: "read"},
+	"$flagged": {Field: search.FieldIs, Value: "starred"},
+	"$draft":   {Field: search.FieldIs, Value: "draft"},
+}
+
+// terms translates a filter into search terms that must all match. The
+// search syntax has no OR, so only filters expressible as a conjunction are
+// supported. none reports a filter that can match nothing.
+func (f *jmapEmailFilter) terms(r *jmapRequest) (terms []search.Term, none bool, err error) {
+	unsupported := func() ([]search.Term, bool, error) {
+		return nil, false, jmap.NewMethodError(jmap.ErrorUnsupportedFilter, "filter cannot be expressed as a search")
+	}
+
+	switch f.Operator {
+	case "":
+	case "AND":
+		for i := range f.Conditions {
+			t, n, err := f.Conditions[i].terms(r)
+			if err != nil {
+				return nil, false, err
+			}
+			terms, none = append(terms, t...), none || n
+		}
+		return terms, none, nil
+	case "OR":
+		if len(f.Conditions) != 1 {
+			return unsupported()
+		}
+		return f.Conditions[0].terms(r)
+	case "NOT":
+		// NOT matches emails matching none of the conditions, which is a
+		// conjunction as long as each condition is a single term
+		for i := range f.Conditions {
+			t, n, err := f.Conditions[i].terms(r)
+			if err != nil {
+				return nil, false, err
+			}
+			switch {
+			case n:
+				// NOT of nothing is everything
+			case len(t) == 0:
+				none = true
+			case len(t) == 1:
+				t[0].Negated = !t[0].Negated
+				terms = append(terms, t[0])
+			default:
+				return unsupported()
+			}
+		}
+		return terms, none, nil
+	default:
+		return nil, false, jmap.NewMethodError(jmap.ErrorInvalidArguments, "unknown operator %s", f.Operator)
+	}
+
+	if len(f.Header) > 0 || f.AllInThreadHaveKeyword != "" || f.SomeInThreadHaveKeyword != "" || f.NoneInThreadHaveKeyword != "" {
+		return unsupported()
+	}
+
+	add := func(field search.Field, value string) {
+		terms = append(terms, search.Term{Field: field, Value: value})
+	}
+
+	if f.InMailbox != "" {
+		add(search.FieldIn, r.resolveID(f.InMailbox))
+	}
+	for _, id := range f.InMailboxOtherThan {
+		terms = append(terms, search.Term{Field: search.FieldIn, Value: r.resolveID(id), Negated: true})
+	}
+	if f.Before != nil {
+		terms = append(terms, search.Term{Field: search.FieldBefore, Value: f.Before.Format(time.RFC3339), Time: *f.Before})
+	}
+	if f.After != nil {
+		terms = append(terms, search.Term{Field: search.FieldAfter, Value: f.After.Format(time.RFC3339), Time: *f.After})
+	}
+	if f.MinSize != nil {
+		terms = append(terms, search.Term{Field: search.FieldLarger, Value: strconv.FormatInt(*f.MinSize-1, 10), Size: *f.MinSize - 1})
+	}
+	if f.MaxSize != nil {
+		terms = append(terms, search.Term{Field: search.FieldSmaller, Value: strconv.FormatInt(*f.MaxSize, 10), Size: *f.MaxSize})
+	}
+	if f.HasKeyword != "" {
+		if term, ok := keywordTerms[f.HasKeyword]; ok {
+			terms = append(terms, term)
+		} else {
+			// Other keywords are never stored
+			none = true
+		}
+	}
+	if f.NotKeyword != "" {
+		if term, ok := keywordTerms[f.NotKeyword]; ok {
+			term.Negated = true
+			terms = append(terms, term)
+		}
+	}
+	if f.HasAttachment != nil {
+		terms = append(terms, search.Term{Field: search.FieldHas, Value: "attachment", Negated: !*f.HasAttachment})
+	}
+	for _, condition := range []struct {
+		field search.Field
+		value string
+	}{
+		{search.FieldText, f.Text},
+		{search.FieldText, f.Body},
+		{search.FieldFrom, f.From},
+		{search.FieldTo, f.To},
+		{search.FieldCC, f.Cc},
+		{search.FieldBCC, f.Bcc},
+		{search.FieldSubject, f.Subject},
+	} {
+		if condition.value != "" {
+			add(condition.field, condition.value)
+		}
+	}
+
+	if none {
+		return nil, true, nil
+	}
+	return terms, false, nil
+}
+
+type jmapComparator struct {
+	Property    string `json:"property"`
+	IsAscending *bool  `json:"isAscending"`
+}
+
+type jmapEmailQueryArgs struct {
+	AccountID       string           `json:"accountId"`
+	Filter          *jmapEmailFilter `json:"filter"`
+	Sort            []jmapComparator `json:"sort"`
+	Position        int              `json:"position"`
+	Anchor          *string          `json:"anchor"`
+	AnchorOffset    int              `json:"anchorOffset"`
+	Limit           *int             `json:"limit"`
+	CalculateTotal  bool             `json:"calculateTotal"`
+	CollapseThreads bool             `json:"collapseThreads"`
+}
+
+func (s *JMAPService) emailQuery(r *jmapRequest, call *jmap.Call) (interface{}, error) {
+	var args jmapEmailQueryArgs
+	if err := call.Decode(&args); err != nil {
+		return nil, err
+	}
+	account, err := r.account(args.AccountID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &search.Query{}
+	none := false
+	if args.Filter != nil {
+		q.Terms, none, err = args.Filter.terms(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !q.HasField(search.FieldIn) {
+		// JMAP queries cover every mailbox, spam and trash included
+		q.Terms = append(q.Terms, search.Term{Field: search.FieldIn, Value: "anywhere"})
+	}
+
+	sortBy, ascending := "receivedAt", false
+	if len(args.Sort) > 0 {
+		// Only the first comparator is honoured; ties are broken by ID
+		sortBy = args.Sort[0].Property
+		ascending = args.Sort[0].IsAscending == nil || *args.Sort[0].IsAscending
+		if _, ok := repository.QuerySortColumns[sortBy]; !ok {
+			return nil, jmap.NewMethodError(jmap.ErrorUnsupportedSort, "cannot sort by %s", sortBy)
+		}
+	}
+
+	limit := jmapDefaultQueryLimit
+	limited := false
+	if args.Limit != nil {
+		if *args.Limit < 0 {
+			return nil, jmap.NewMethodError(jmap.ErrorInvalidArguments, "limit must not be negative")
+		}
+		limit = *args.Limit
+		if limit > jmapMaxQueryLimit {
+			limit, limited = jmapMaxQueryLimit, true
+		}
+	}
+
+	state, err := s.state(account.ID, model.ChangeObjectEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	var total, position int
+	switch {
+	case none:
+		ids = []string{}
+
+	case args.Anchor != nil || args.Position < 0:
+		// Both need the whole result to find the window
+		all, count, err := s.emailRepo.QueryIDs(account.ID, q, sortBy, ascending, args.CollapseThreads, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		total = count
+
+		if args.Anchor != nil {
+			index := indexOf(all, r.resolveID(*args.Anchor))
+			if index < 0 {
+				return nil, jmap.NewMethodError(jmap.ErrorAnchorNotFound, "%s is not in the results", *args.Anchor)
+			}
+			position = index + args.AnchorOffset
+		} else {
+			position = len(all) + args.Position
+		}
+		if position < 0 {
+			position = 0
+		}
+		if position > len(all) {
+			position = len(all)
+		}
+		end := position + limit
+		if end > len(all) {
+			end = len(all)
+		}
+		ids = all[position:end]
+
+	default:
+		position = args.Position
+		ids, total, err = s.emailRepo.QueryIDs(account.ID, q, sortBy, ascending, args.CollapseThreads, position, limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response := map[string]interface{}{
+		"accountId":           account.ID,
+		"queryState":          state,
+		"canCalculateChanges": false,
+		"position":            position,
+		"ids":                 nonNilStrings(ids),
+	}
+	if args.CalculateTotal {
+		response["total"] = total
+	}
+	if limited {
+		response["limit"] = limit
+	}
+	return response, nil
+}
+
+func indexOf(list []string, value string) int {
+	for i, item := range list {
+		if item == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func nonNilStrings(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// Email/changes
+
+func (s *JMAPService) emailChanges(r *jmapRequest, call *jmap.Call) (interface{}, error) {
+	return s.changes(r, model.ChangeObjectEmail, call, identityID)
+}
+
+// Email/set
+
+type jmapSetArgs struct {
+	AccountID string                                `json:"accountId"`
+	IfInState *string                               `json:"ifInState"`
+	Create    map[string]map[string]json.RawMessage `json:"create"`
+	Update    map[string]map[string]json.RawMessage `json:"update"`
+	Destroy   []string                              `json:"destroy"`
+}
+
+type jmapSetResponse struct {
+	AccountID    string                    `json:"accountId"`
+	OldState     string                    `json:"oldState"`
+	NewState     string                    `json:"newState"`
+	Created      map[string]interface{}    `json:"created"`
+	Updated      map[string]interface{}    `json:"updated"`
+	Destroyed    []string                  `json:"destroyed"`
+	NotCreated   map[string]*jmap.SetError `json:"notCreated"`
+	NotUpdated   map[string]*jmap.SetError `json:"notUpdated"`
+	NotDestroyed map[string]*jmap.SetError `json:"notDestroyed"`
+}
+
+func (resp *jmapSetResponse) created(creationID string, object interface{}) {
+	if resp.Created == nil {
+		resp.Created = make(map[string]interface{})
+	}
+	resp.Created[creationID] = object
+}
+
+func (resp *jmapSetResponse) updated(id string) {
+	if resp.Updated == nil {
+		resp.Updated = make(map[string]interface{})
+	}
+	resp.Updated[id] = nil
+}
+
+func (resp *jmapSetResponse) failed(errs *map[string]*jmap.SetError, id string, err *jmap.SetError) {
+	if *errs == nil {
+		*errs = make(map[string]*jmap.SetError)
+	}
+	(*errs)[id] = err
+}
+
+// setError converts an error from a create, update or destroy into a
+// SetError, or returns it unchanged if it is a server failure
+func setError(err error) (*jmap.SetError, error) {
+	var setErr *jmap.SetError
+	if errors.As(err, &setErr) {
+		return setErr, nil
+	}
+	return nil, err
+}
+
+// beginSet checks the common /set arguments
+func (s *JMAPService) beginSet(r *jmapRequest, args *jmapSetArgs, objectType string) (*JMAPAccount, *jmapSetResponse, error) {
+	account, err := r.account(args.AccountID, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(args.Create)+len(args.Update)+len(args.Destroy) > jmapMaxObjectsInSet {
+		return nil, nil, jmap.NewMethodError(jmap.ErrorRequestTooLarge, "at most %d objects per call", jmapMaxObjectsInSet)
+	}
+
+	state, err := s.state(account.ID, objectType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if args.IfInState != nil && *args.IfInState != state {
+		return nil, nil, jmap.NewMethodError(jmap.ErrorStateMismatch, "state is %s", state)
+	}
+
+	return account, &jmapSetResponse{AccountID: account.ID, OldState: state}, nil
+}
+
+func (s *JMAPService) emailSet(r *jmapRequest, call *jmap.Call) (interface{}, error) {
+	var args jmapSetArgs
+	if err := call.Decode(&args); err != nil {
+		return nil, err
+	}
+	account, response, err := s.beginSet(r, &args, model.ChangeObjectEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	for creationID, properties := range args.Create {
+		email, err := s.createEmail(r, account, properties)
+		if err != nil {
+			setErr, err := setError(err)
+			if err != nil {
+				return nil, err
+			}
+			response.failed(&response.NotCreated, creationID, setErr)
+			continue
+		}
+
+		r.createdIDs[creationID] = email.ID
+		response.created(creationID, map[string]interface{}{
+			"id":       email.ID,
+			"blobId":   blobMessage + email.ID,
+			"threadId": jmapThreadID(email.ThreadID),
+			"size":     email.Size,
+		})
+	}
+
+	for rawID, patch := range args.Update {
+		if err := s.updateEmail(account, r.resolveID(rawID), patch, r); err != nil {
+			setErr, err := setError(err)
+			if err != nil {
+				return nil, err
+			}
+			response.failed(&response.NotUpdated, rawID, setErr)
+			continue
+		}
+		response.updated(rawID)
+	}
+
+	for _, rawID := range args.Destroy {
+		id := r.resolveID(rawID)
+		if _, err := s.emailRepo.GetByID(id, account.ID); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return nil, err
+			}
+			response.failed(&response.NotDestroyed, rawID, jmap.NewSetError(jmap.SetErrorNotFound, ""))
+			continue
+		}
+		if err := s.emailRepo.PermanentDelete(id, account.ID); err != nil {
+			return nil, err
+		}
+		response.Destroyed = append(response.Destroyed, rawID)
+	}
+
+	response.NewState, err = s.state(account.ID, model.ChangeObjectEmail)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+type jmapBodyPartRef struct {
+	PartID      string  `json:"partId"`
+	BlobID      string  `json:"blobId"`
+	Type        string  `json:"type"`
+	Name        *string `json:"name"`
+	Disposition *string `json:"disposition"`
+	Cid         *string `json:"cid"`
+}
+
+type jmapEmailCreate struct {
+	MailboxIDs  map[string]bool          `json:"mailboxIds"`
+	Keywords    map[string]bool          `json:"keywords"`
+	From        []jmapAddress            `json:"from"`
+	To          []jmapAddress            `json:"to"`
+	Cc          []jmapAddress            `json:"cc"`
+	Bcc         []jmapAddress            `json:"bcc"`
+	ReplyTo     []jmapAddress            `json:"replyTo"`
+	Subject     string                   `json:"subject"`
+	SentAt      *time.Time               `json:"sentAt"`
+	ReceivedAt  *time.Time               `json:"receivedAt"`
+	MessageID   []string                 `json:"messageId"`
+	InReplyTo   []string                 `json:"inReplyTo"`
+	References  []string                 `json:"references"`
+	TextBody    []jmapBodyPartRef        `json:"textBody"`
+	HTMLBody    []jmapBodyPartRef        `json:"htmlBody"`
+	Attachments []jmapBodyPartRef        `json:"attachments"`
+	BodyValues  map[string]jmapBodyValue `json:"bodyValues"`
+}
+
+// emailCreateProperties are the properties Email/set accepts on create,
+// besides header:* properties
+var emailCreateProperties = map[string]bool{
+	"mailboxIds": true, "keywords": true, "from": true, "to": true, "cc": true,
+	"bcc": true, "replyTo": true, "subject": true, "sentAt": true, "receivedAt": true,
+	"messageId": true, "inReplyTo": true, "references": true, "textBody": true,
+	"htmlBody": true, "attachments": true, "bodyValues": true,
+}
+
+// createEmail stores a new email, typically a draft, from Email/set
+// properties
+func (s *JMAPService) createEmail(r *jmapRequest, account *JMAPAccount, properties map[string]json.RawMessage) (*model.Email, error) {
+	headers := make(model.Headers)
+	for property, raw := range properties {
+		if emailCreateProperties[property] {
+			continue
+		}
+		name, form, all, ok := parseHeaderProperty(property)
+		if !ok || all || (form != "asText" && form != "asRaw") {
+			return nil, jmap.NewSetError(jmap.SetErrorInvalidProperties, "unsupported property", property)
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, jmap.NewSetError(jmap.SetErrorInvalidProperties, "header values must be strings", property)
+		}
+		headers[name] = []string{strings.TrimSpace(value)}
+	}
+
+	var create jmapEmailCreate
+	data, _ := json.Marshal(properties)
+	if err := json.Unmarshal(data, &create); err != nil {
+		return nil, jmap.NewSetError(jmap.SetErrorInvalidProperties, err.Error())
+	}
+
+	folderID, err := s.singleMailbox(r, account, create.MailboxIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	email := &model.Email{
+		UserID:   account.ID,
+		Subject:  create.Subject,
+		FolderID: folderID,
+		To:       addressStrings(create.To),
+		CC:       addressStrings(create.Cc),
+		BCC:      addressStrings(create.Bcc),
+		Priority: "normal",
+		SentAt:   create.SentAt,
+		Headers:  headers,
+	}
+	if create.ReceivedAt != nil {
+		email.ReceivedAt = *create.ReceivedAt
+	}
+	if len(create.ReplyTo) > 0 {
+		email.Headers["Reply-To"] = addressStrings(create.ReplyTo)
+	}
+
+	switch len(create.From) {
+	case 0:
+		email.From, email.FromName = s.emailService.MailboxAddress(account.ID), account.ID
+	case 1:
+		email.From, email.FromName = create.From[0].Email, create.From[0].Name
+	default:
+		return nil, jmap.NewSetError(jmap.SetErrorInvalidProperties, "only one from address is supported", "from")
+	}
+
+	for keyword, set := range create.Keywords {
+		if !set {
+			continue
+		}
+		if err := applyKeyword(email, keyword, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(create.MessageID) > 1 {
+		return nil, jmap.NewSetError(jmap.SetErrorInvalidProperties, "only one message ID is allowed", "messageId")
+	}
+	if len(create.MessageID) == 1 {
+		email.MessageID = "<" + create.MessageID[0] + ">"
+	}
+	if len(create.InReplyTo) > 0 {
+		inReplyTo := "<" + create.InReplyTo[0] + ">"
+		// Threaded like replies received over SMTP
+		email.InReplyTo, email.ThreadID = &inReplyTo, inReplyTo
+	}
+	for _, ref := range create.References {
+		email.References = append(email.References, "<"+ref+">")
+	}
+
+	if email.Body, err = bodyValue(create.TextBody, create.BodyValues, "text/plain", "textBody"); err != nil {
+		return nil, err
+	}
+	if email.BodyHTML, err = bodyValue(create.HTMLBody, create.BodyValues, "text/html", "htmlBody"); err != nil {
+		return nil, err
+	}
+	email.Size = int64(len(email.Body) + len(email.BodyHTML))
+
+	attachments := make([]model.Attachment, 0, len(create.Attachments))
+	for _, ref := range create.Attachments {
+		att, err := s.attachmentFromBlob(account, &ref)
+		if err != nil {
+			return nil, err
+		}
+		email.Size += att.Size
+		attachments = append(attachments, *att)
+	}
+	email.HasAttachments = len(attachments) > 0
+
+	if email.Size > s.config.SMTP.MaxMessageSize {
+		return nil, jmap.NewSetError(jmap.SetErrorTooLarge, fmt.Sprintf("messages are limited to %d bytes", s.config.SMTP.MaxMessageSize))
+	}
+
+	if err := s.emailRepo.Create(email); err != nil {
+		return nil, err
+	}
+	for i := range attachments {
+		attachments[i].EmailID = email.ID
+		if err := s.emailRepo.CreateAttachment(&attachments[i]); err != nil {
+			return nil, err
+		}
+	}
+	email.Attachments = attachments
+
+	return email, nil
+}
+
+// singleMailbox returns the one mailbox an email is set to be in
+func (s *JMAPService) singleMailbox(r *jmapRequest, account *JMAPAccount, mailboxIDs map[string]bool) (string, error) {
+	var ids []string
+	for id, in := range mailboxIDs {
+		if in {
+			ids = append(ids, r.resolveID(id))
+		}
+	}
+	if len(ids) != 1 {
+		return "", jmap.NewSetError(jmap.SetErrorInvalidProperties, "an email must be in exactly one mailbox", "mailboxIds")
+	}
+
+	if _, err := s.folderRepo.GetByID(ids[0], account.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", jmap.NewSetError(jmap.SetErrorInvalidProperties, "no such mailbox", "mailboxIds")
+		}
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// applyKeyword sets or clears one of the keywords stored as flags
+func applyKeyword(email *model.Email, keyword string, set bool) error {
+	switch keyword {
+	case "$seen":
+		email.IsRead = set
+		if set && email.ReadAt == nil {
+			now := time.Now()
+			email.ReadAt = &now
+		}
+	case "$flagged":
+		email.IsStarred = set
+	case "$draft":
+		email.IsDraft = set
+	default:
+		if set {
+			return jmap.NewSetError(jmap.SetErrorInvalidProperties, fmt.Sprintf("keyword %s is not supported", keyword), "keywords")
+		}
+	}
+	return nil
+}
+
+// bodyValue returns the content of a textBody or htmlBody given inline
+func bodyValue(parts []jmapBodyPartRef, values map[string]jmapBodyValue, contentType, property string) (string, error) {
+	if len(parts) == 0 {
+		return "", nil
+	}
+	if len(parts) > 1 || parts[0].PartID == "" || (parts[0].Type != "" && parts[0].Type != contentType) {
+		return "", jmap.NewSetError(jmap.SetErrorInvalidProperties, fmt.Sprintf("%s must be one %s part with a body value", property, contentType), property)
+	}
+	value, ok := values[parts[0].PartID]
+	if !ok {
+		return "", jmap.NewSetError(jmap.SetErrorInvalidProperties, "missing body value "+parts[0].PartID, "bodyValues")
+	}
+	return value.Value, nil
+}
+
+// attachmentFromBlob builds an attachment from an uploaded blob or from an
+// attachment of another email. The stored object is shared, not copied.
+func (s *JMAPService) attachmentFromBlob(account *JMAPAccount, ref *jmapBodyPartRef) (*model.Attachment, error) {
+	att := &model.Attachment{
+		ContentType: ref.Type,
+		ScanStatus:  model.ScanStatusUnscanned,
+		IsInline:    ref.Disposition != nil && *ref.Disposition == "inline",
+		ContentID:   ref.Cid,
+	}
+	if ref.Name != nil {
+		att.Filename = *ref.Name
+	}
+
+	switch {
+	case strings.HasPrefix(ref.BlobID, blobAttachment):
+		source, err := s.emailService.GetUserAttachment(strings.TrimPrefix(ref.BlobID, blobAttachment), account.ID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) || errors.Is(err, ErrAttachmentUnsafe) {
+				return nil, jmap.NewSetError(jmap.SetErrorBlobNotFound, ref.BlobID, "attachments")
+			}
+			return nil, err
+		}
+		att.StoragePath, att.Size = source.StoragePath, source.Size
+		att.ScanStatus, att.ScanResult, att.ScannedAt = source.ScanStatus, source.ScanResult, source.ScannedAt
+		if att.ContentType == "" {
+			att.ContentType = source.ContentType
+		}
+		if att.Filename == "" {
+			att.Filename = source.Filename
+		}
+
+	case strings.HasPrefix(ref.BlobID, blobUpload):
+		att.StoragePath = uploadPath(account.ID, ref.BlobID)
+		object, err := s.emailService.OpenAttachment(att.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+		info, err := object.Stat()
+		object.Close()
+		if err != nil {
+			return nil, jmap.NewSetError(jmap.SetErrorBlobNotFound, ref.BlobID, "attachments")
+		}
+		att.Size = info.Size
+		if att.ContentType == "" {
+			att.ContentType = info.ContentType
+		}
+
+	default:
+		return nil, jmap.NewSetError(jmap.SetErrorBlobNotFound, ref.BlobID, "attachments")
+	}
+
+	if att.ContentType == "" {
+		att.ContentType = "application/octet-stream"
+	}
+	if att.Filename == "" {
+		att.Filename = "attachment"
+	}
+	return att, nil
+}
+
+// addressStrings formats JMAP addresses for storage
+func addressStrings(addresses []jmapAddress) model.StringArray {
+	list := make(model.StringArray, 0, len(addresses))
+	for _, addr := range addresses {
+		if addr.Name != "" {
+			list = append(list, (&mail.Address{Name: addr.Name, Address: addr.Email}).String())
+		} else {
+			list = append(list, addr.Email)
+		}
+	}
+	return list
+}
+
+// updateEmail applies an Email/set patch. Only mailboxIds and keywords can
+// change; everything else about an email is immutable.
+func (s *JMAPService) updateEmail(account *JMAPAccount, id string, patch map[string]json.RawMessage, r *jmapRequest) error {
+	email, err := s.emailRepo.GetByID(id, account.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return jmap.NewSetError(jmap.SetErrorNotFound, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	folderID := email.FolderID
+	mailboxes := map[string]bool{email.FolderID: true}
+	mailboxesPatched := false
+
+	for path, raw := range patch {
+		switch {
+		case path == "mailboxIds":
+			mailboxes = map[string]bool{}
+			if err := json.Unmarshal(raw, &mailboxes); err != nil {
+				return jmap.NewSetError(jmap.SetErrorInvalidPatch, err.Error(), path)
+			}
+			mailboxesPatched = true
+
+		case strings.HasPrefix(path, "mailboxIds/"):
+			var in *bool
+			if err := json.Unmarshal(raw, &in); err != nil {
+				return jmap.NewSetError(jmap.SetErrorInvalidPatch, err.Error(), path)
+			}
+			mailboxID := r.resolveID(strings.TrimPrefix(path, "mailboxIds/"))
+			if in != nil && *in {
+				mailboxes[mailboxID] = true
+			} else {
+				delete(mailboxes, mailboxID)
+			}
+			mailboxesPatched = true
+
+		case path == "keywords":
+			var keywords map[string]bool
+			if err := json.Unmarshal(raw, &keywords); err != nil {
+				return jmap.NewSetError(jmap.SetErrorInvalidPatch, err.Error(), path)
+			}
+			for keyword := range keywordTerms {
+				if err := applyKeyword(email, keyword, keywords[keyword]); err != nil {
+					return err
+				}
+			}
+			for keyword, set := range keywords {
+				if err := applyKeyword(email, keyword, set); err != nil {
+					return err
+				}
+			}
+
+		case strings.HasPrefix(path, "keywords/"):
+			var set *bool
+			if err := json.Unmarshal(raw, &set); err != nil {
+				return jmap.NewSetError(jmap.SetErrorInvalidPatch, err.Error(), path)
+			}
+			if err := applyKeyword(email, strings.TrimPrefix(path, "keywords/"), set != nil && *set); err != nil {
+				return err
+			}
+
+		default:
+			return jmap.NewSetError(jmap.SetErrorInvalidProperties, "property cannot be changed", path)
+		}
+	}
+
+	if mailboxesPatched {
+		if folderID, err = s.singleMailbox(r, account, mailboxes); err != nil {
+			return err
+		}
+	}
+
+	if err := s.emailRepo.Update(email); err != nil {
+		return err
+	}
+	if folderID != email.FolderID {
+		// Moves go through the email service so spam training happens
+		if err := s.emailService.MoveToFolder(email.ID, account.ID, folderID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EmailSubmission/set
+
+type jmapEnvelope struct {
+	MailFrom struct {
+		Email string `json:"email"`
+	} `json:"mailFrom"`
+	RcptTo []struct {
+		Email string `json:"email"`
+	} `json:"rcptTo"`
+}
+
+type jmapSubmissionCreate struct {
+	IdentityID string        `json:"identityId"`
+	EmailID    string        `json:"emailId"`
+	Envelope   *jmapEnvelope `json:"envelope"`
+}
+
+type jmapSubmissionSetArgs struct {
+	jmapSetArgs
+	OnSuccessUpdateEmail  map[string]map[string]json.RawMessage `json:"onSuccessUpdateEmail"`
+	OnSuccessDestroyEmail []string                              `json:"onSuccessDestroyEmail"`
+}
+
+// emailSubmissionSet sends emails. Submissions are handed to the SMTP relay
+// immediately, so they are final and are not kept afterwards.
+func (s *JMAPService) emailSubmissionSet(r *jmapRequest, call *jmap.Call) (interface{}, error) {
+	var args jmapSubmissionSetArgs
+	if err := call.Decode(&args); err != nil {
+		return nil, err
+	}
+	account, err := r.account(args.AccountID, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(args.Create)+len(args.Update)+len(args.Destroy) > jmapMaxObjectsInSet {
+		return nil, jmap.NewMethodError(jmap.ErrorRequestTooLarge, "at most %d objects per call", jmapMaxObjectsInSet)
+	}
+	if args.IfInState != nil && *args.IfInState != "0" {
+		return nil, jmap.NewMethodError(jmap.ErrorStateMismatch, "state is 0")
+	}
+
+	response := &jmapSetResponse{AccountID: account.ID, OldState: "0", NewState: "0"}
+	sentEmails := make(map[string]string) // submission creation ID -> email ID
+
+	for creationID, properties := range args.Create {
+		var create jmapSubmissionCreate
+		data, _ := json.Marshal(properties)
+		if err := json.Unmarshal(data, &create); err != nil {
+			response.failed(&response.NotCreated, creationID, jmap.NewSetError(jmap.SetErrorInvalidProperties, err.Error()))
+			continue
+		}
+
+		emailID, err := s.submitEmail(r, account, &create)
+		if err != nil {
+			setErr, err := setError(err)
+			if err != nil {
+				return nil, err
+			}
+			response.failed(&response.NotCreated, creationID, setErr)
+			continue
+		}
+
+		submissionID := uuid.New().String()
+		r.createdIDs[creationID] = submissionID
+		sentEmails[creationID] = emailID
+		response.created(creationID, map[string]interface{}{
+			"id":         submissionID,
+			"undoStatus": "final",
+			"sendAt":     time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+
+	for id := range args.Update {
+		response.failed(&response.NotUpdated, id, jmap.NewSetError(jmap.SetErrorNotFound, "submissions are final once sent"))
+	}
+	for _, id := range args.Destroy {
+		response.failed(&response.NotDestroyed, id, jmap.NewSetError(jmap.SetErrorNotFound, "submissions are final once sent"))
+	}
+
+	// Follow-up changes to the sent emails, e.g. moving drafts to Sent
+	update := map[string]map[string]json.RawMessage{}
+	for ref, patch := range args.OnSuccessUpdateEmail {
+		if emailID, ok := sentEmails[strings.TrimPrefix(ref, "#")]; ok {
+			update[emailID] = patch
+		}
+	}
+	var destroy []string
+	for _, ref := range args.OnSuccessDestroyEmail {
+		if emailID, ok := sentEmails[strings.TrimPrefix(ref, "#")]; ok {
+			destroy = append(destroy, emailID)
+		}
+	}
+
+	if len(update)+len(destroy) > 0 {
+		implicitArgs, err := json.Marshal(map[string]interface{}{
+			"accountId": account.ID,
+			"update":    update,
+			"destroy":   destroy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		implicit := &jmap.Call{Name: "Email/set", CallID: call.CallID}
+		if err := json.Unmarshal(implicitArgs, &implicit.Args); err != nil {
+			return nil, err
+		}
+
+		result, err := s.emailSet(r, implicit)
+		if err != nil {
+			return nil, err
+		}
+		r.implicit = append(r.implicit, jmap.Invocation{Name: "Email/set", Args: result, CallID: call.CallID})
+	}
+
+	return response, nil
+}
+
+// submitEmail checks and sends one submission, returning the email's ID
+func (s *JMAPService) submitEmail(r *jmapRequest, account *JMAPAccount, create *jmapSubmissionCreate) (string, error) {
+	if !account.MaySend() {
+		return "", jmap.NewSetError(jmap.SetErrorForbiddenToSend, "no permission to send from this account")
+	}
+	if r.resolveID(create.IdentityID) != account.ID {
+		return "", jmap.NewSetError(jmap.SetErrorInvalidProperties, "no such identity", "identityId")
+	}
+
+	email, err := s.emailRepo.GetByID(r.resolveID(create.EmailID), account.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", jmap.NewSetError(jmap.SetErrorInvalidProperties, "no such email", "emailId")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	address := s.emailService.MailboxAddress(account.ID)
+	if !strings.EqualFold(email.From, address) {
+		return "", jmap.NewSetError(jmap.SetErrorForbiddenFrom, "from must be "+address)
+	}
+
+	recipients := make(map[string]bool)
+	for _, list := range [][]string{email.To, email.CC, email.BCC} {
+		for _, addr := range headerAddresses(list) {
+			recipients[strings.ToLower(addr.Email)] = true
+		}
+	}
+	if len(recipients) == 0 {
+		return "", jmap.NewSetError(jmap.SetErrorNoRecipients, "the email has no recipients")
+	}
+
+	if create.Envelope != nil {
+		// The relay sends to the message's recipients, so a custom envelope
+		// is only accepted if it says the same
+		rcptTo := make(map[string]bool)
+		for _, rcpt := range create.Envelope.RcptTo {
+			rcptTo[strings.ToLower(rcpt.Email)] = true
+		}
+		if !strings.EqualFold(create.Envelope.MailFrom.Email, address) || len(rcptTo) != len(recipients) {
+			return "", jmap.NewSetError(jmap.SetErrorInvalidProperties, "envelope must match the email", "envelope")
+		}
+		for rcpt := range rcptTo {
+			if !recipients[rcpt] {
+				return "", jmap.NewSetError(jmap.SetErrorInvalidProperties, "envelope must match the email", "envelope")
+			}
+		}
+	}
+
+	// Same rule as the REST send endpoint: on-behalf delegates are named in
+	// the Sender header
+	sender := ""
+	if !account.Personal && !account.SendAs {
+		sender = r.claims.Email
+	}
+
+	if err := s.emailService.SendDraft(email, sender); err != nil {
+		return "", err
+	}
+	return email.ID, nil
+}
@@ -0,0 +1,815 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/jmap"
+	"nexus-mail-service/internal/middleware"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+
+	"github.com/rs/zerolog/log"
+)
+
+// JMAP limits advertised in the session resource
+const (
+	JMAPMaxSizeRequest        = 10 << 20
+	jmapMaxConcurrentRequests = 4
+	jmapMaxConcurrentUpload   = 4
+	jmapMaxCallsInRequest     = 16
+	jmapMaxObjectsInGet       = 500
+	jmapMaxObjectsInSet       = 500
+	jmapMaxChanges            = 500
+	jmapDefaultQueryLimit     = 256
+	jmapMaxQueryLimit         = 1000
+)
+
+// mailboxRoles maps folder types to JMAP mailbox roles
+var mailboxRoles = map[string]string{
+	"inbox":   "inbox",
+	"sent":    "sent",
+	"drafts":  "drafts",
+	"trash":   "trash",
+	"spam":    "junk",
+	"archive": "archive",
+}
+
+// JMAPAccount is a mailbox the caller can use over JMAP: their own, or one
+// delegated to them. Account IDs are mailbox (user) IDs.
+type JMAPAccount struct {
+	ID           string
+	Name         string
+	Personal     bool
+	ReadOnly     bool
+	SendAs       bool
+	SendOnBehalf bool
+}
+
+// MaySend reports whether the caller may send mail from the account
+func (a *JMAPAccount) MaySend() bool {
+	return !a.ReadOnly && (a.Personal || a.SendAs || a.SendOnBehalf)
+}
+
+// JMAPService implements JMAP Core and Mail (RFC 8620/8621) on top of the
+// mail repositories. Mailboxes are folders, and an email is in exactly one.
+type JMAPService struct {
+	config         *config.Config
+	emailRepo      *repository.EmailRepository
+	folderRepo     *repository.FolderRepository
+	changeRepo     *repository.ChangeRepository
+	delegationRepo *repository.DelegationRepository
+	emailService   *EmailService
+}
+
+// NewJMAPService creates a new JMAP service
+func NewJMAPService(
+	cfg *config.Config,
+	emailRepo *repository.EmailRepository,
+	folderRepo *repository.FolderRepository,
+	changeRepo *repository.ChangeRepository,
+	delegationRepo *repository.DelegationRepository,
+	emailService *EmailService,
+) *JMAPService {
+	return &JMAPService{
+		config:         cfg,
+		emailRepo:      emailRepo,
+		folderRepo:     folderRepo,
+		changeRepo:     changeRepo,
+		delegationRepo: delegationRepo,
+		emailService:   emailService,
+	}
+}
+
+// jmapMethod is a method handler and the capability that enables it
+type jmapMethod struct {
+	capability string
+	handler    func(s *JMAPService, r *jmapRequest, call *jmap.Call) (interface{}, error)
+}
+
+var jmapMethods = map[string]jmapMethod{
+	"Core/echo":           {jmap.CapabilityCore, (*JMAPService).coreEcho},
+	"Mailbox/get":         {jmap.CapabilityMail, (*JMAPService).mailboxGet},
+	"Mailbox/changes":     {jmap.CapabilityMail, (*JMAPService).mailboxChanges},
+	"Thread/get":          {jmap.CapabilityMail, (*JMAPService).threadGet},
+	"Thread/changes":      {jmap.CapabilityMail, (*JMAPService).threadChanges},
+	"Email/get":           {jmap.CapabilityMail, (*JMAPService).emailGet},
+	"Email/query":         {jmap.CapabilityMail, (*JMAPService).emailQuery},
+	"Email/changes":       {jmap.CapabilityMail, (*JMAPService).emailChanges},
+	"Email/set":           {jmap.CapabilityMail, (*JMAPService).emailSet},
+	"Identity/get":        {jmap.CapabilitySubmission, (*JMAPService).identityGet},
+	"EmailSubmission/set": {jmap.CapabilitySubmission, (*JMAPService).emailSubmissionSet},
+}
+
+// jmapRequest is the state shared by the method calls of one request
+type jmapRequest struct {
+	claims     *middleware.Claims
+	accounts   map[string]*JMAPAccount
+	createdIDs map[string]string
+	implicit   []jmap.Invocation // extra responses produced by the current call
+}
+
+// account returns an account the caller can access, writable if asked
+func (r *jmapRequest) account(accountID string, write bool) (*JMAPAccount, error) {
+	account, ok := r.accounts[accountID]
+	if !ok {
+		return nil, jmap.NewMethodError(jmap.ErrorAccountNotFound, "no access to account %s", accountID)
+	}
+	if write && account.ReadOnly {
+		return nil, jmap.NewMethodError(jmap.ErrorAccountReadOnly, "account %s is read-only", accountID)
+	}
+	return account, nil
+}
+
+// resolveID resolves a "#creationId" reference to the ID created earlier in
+// the request
+func (r *jmapRequest) resolveID(id string) string {
+	if strings.HasPrefix(id, "#") {
+		if created, ok := r.createdIDs[id[1:]]; ok {
+			return created
+		}
+	}
+	return id
+}
+
+// Accounts returns the accounts the caller can access, their own first
+func (s *JMAPService) Accounts(claims *middleware.Claims) ([]JMAPAccount, error) {
+	name := claims.Email
+	if name == "" {
+		name = claims.UserID
+	}
+	accounts := []JMAPAccount{{ID: claims.UserID, Name: name, Personal: true}}
+
+	delegations, err := s.delegationRepo.ListByDelegate(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range delegations {
+		// Same tenant check as MailboxAccess
+		if d.TenantID != claims.TenantID {
+			continue
+		}
+		accounts = append(accounts, JMAPAccount{
+			ID:           d.MailboxID,
+			Name:         d.MailboxID,
+			ReadOnly:     d.Access == model.DelegationAccessRead,
+			SendAs:       d.SendAs,
+			SendOnBehalf: d.SendOnBehalf,
+		})
+	}
+
+	return accounts, nil
+}
+
+// Account returns one account the caller can access
+func (s *JMAPService) Account(claims *middleware.Claims, accountID string) (*JMAPAccount, error) {
+	accounts, err := s.Accounts(claims)
+	if err != nil {
+		return nil, err
+	}
+	for i := range accounts {
+		if accounts[i].ID == accountID {
+			return &accounts[i], nil
+		}
+	}
+	return nil, jmap.NewMethodError(jmap.ErrorAccountNotFound, "no access to account %s", accountID)
+}
+
+// Session builds the JMAP session resource. baseURL is used for the API
+// URLs unless a public URL is configured.
+func (s *JMAPService) Session(claims *middleware.Claims, baseURL string) (*jmap.Session, error) {
+	accounts, err := s.Accounts(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.config.JMAP.PublicURL != "" {
+		baseURL = s.config.JMAP.PublicURL
+	}
+
+	sortOptions := make([]string, 0, len(repository.QuerySortColumns))
+	for option := range repository.QuerySortColumns {
+		sortOptions = append(sortOptions, option)
+	}
+	sort.Strings(sortOptions)
+
+	session := &jmap.Session{
+		Capabilities: map[string]interface{}{
+			jmap.CapabilityCore: jmap.CoreCapability{
+				MaxSizeUpload:         s.config.Email.MaxAttachmentSize,
+				MaxConcurrentUpload:   jmapMaxConcurrentUpload,
+				MaxSizeRequest:        JMAPMaxSizeRequest,
+				MaxConcurrentRequests: jmapMaxConcurrentRequests,
+				MaxCallsInRequest:     jmapMaxCallsInRequest,
+				MaxObjectsInGet:       jmapMaxObjectsInGet,
+				MaxObjectsInSet:       jmapMaxObjectsInSet,
+				CollationAlgorithms:   []string{"i;ascii-casemap"},
+			},
+			jmap.CapabilityMail:       map[string]interface{}{},
+			jmap.CapabilitySubmission: map[string]interface{}{},
+		},
+		Accounts: make(map[string]jmap.Account, len(accounts)),
+		PrimaryAccounts: map[string]string{
+			jmap.CapabilityMail:       claims.UserID,
+			jmap.CapabilitySubmission: claims.UserID,
+		},
+		Username:       accounts[0].Name,
+		APIURL:         baseURL + "/api/v1/jmap",
+		DownloadURL:    baseURL + "/api/v1/jmap/download/{accountId}/{blobId}/{name}?type={type}",
+		UploadURL:      baseURL + "/api/v1/jmap/upload/{accountId}",
+		EventSourceURL: baseURL + "/api/v1/jmap/eventsource?types={types}&closeafter={closeafter}&ping={ping}",
+		State:          jmapSessionState(accounts),
+	}
+
+	for _, account := range accounts {
+		capabilities := map[string]interface{}{
+			jmap.CapabilityMail: map[string]interface{}{
+				"maxMailboxesPerEmail":       1,
+				"maxMailboxDepth":            nil,
+				"maxSizeMailboxName":         255,
+				"maxSizeAttachmentsPerEmail": s.config.Email.MaxAttachmentSize,
+				"emailQuerySortOptions":      sortOptions,
+				"mayCreateTopLevelMailbox":   false,
+			},
+		}
+		if account.MaySend() {
+			capabilities[jmap.CapabilitySubmission] = map[string]interface{}{
+				"maxDelayedSend":       0,
+				"submissionExtensions": map[string]interface{}{},
+			}
+		}
+
+		session.Accounts[account.ID] = jmap.Account{
+			Name:                account.Name,
+			IsPersonal:          account.Personal,
+			IsReadOnly:          account.ReadOnly,
+			AccountCapabilities: capabilities,
+		}
+	}
+
+	return session, nil
+}
+
+// jmapSessionState changes whenever the caller's set of accounts or their
+// rights change, telling clients to refetch the session
+func jmapSessionState(accounts []JMAPAccount) string {
+	h := sha256.New()
+	for _, a := range accounts {
+		fmt.Fprintf(h, "%s:%t:%t:%t;", a.ID, a.ReadOnly, a.SendAs, a.SendOnBehalf)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Execute runs the method calls of a JMAP request in order. Request-level
+// failures are returned as a problem; method failures become "error"
+// responses.
+func (s *JMAPService) Execute(claims *middleware.Claims, req *jmap.Request) (*jmap.Response, *jmap.Problem) {
+	using := make(map[string]bool, len(req.Using))
+	for _, capability := range req.Using {
+		switch capability {
+		case jmap.CapabilityCore, jmap.CapabilityMail, jmap.CapabilitySubmission:
+			using[capability] = true
+		default:
+			return nil, &jmap.Problem{
+				Type:   jmap.ProblemUnknownCapability,
+				Status: 400,
+				Detail: fmt.Sprintf("unsupported capability %s", capability),
+			}
+		}
+	}
+
+	if len(req.MethodCalls) > jmapMaxCallsInRequest {
+		return nil, &jmap.Problem{
+			Type:   jmap.ProblemLimit,
+			Status: 400,
+			Limit:  "maxCallsInRequest",
+			Detail: fmt.Sprintf("at most %d method calls per request", jmapMaxCallsInRequest),
+		}
+	}
+
+	accounts, err := s.Accounts(claims)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load JMAP accounts")
+		return nil, &jmap.Problem{Type: "about:blank", Status: 500, Detail: "failed to load accounts"}
+	}
+
+	r := &jmapRequest{
+		claims:     claims,
+		accounts:   make(map[string]*JMAPAccount, len(accounts)),
+		createdIDs: make(map[string]string),
+	}
+	for i := range accounts {
+		r.accounts[accounts[i].ID] = &accounts[i]
+	}
+	for creationID, id := range req.CreatedIDs {
+		r.createdIDs[creationID] = id
+	}
+
+	response := &jmap.Response{
+		MethodResponses: make([]jmap.Invocation, 0, len(req.MethodCalls)),
+		SessionState:    jmapSessionState(accounts),
+	}
+
+	for i := range req.MethodCalls {
+		call := &req.MethodCalls[i]
+		r.implicit = nil
+
+		result, err := s.call(r, call, using, response.MethodResponses)
+		if err != nil {
+			var methodErr *jmap.MethodError
+			if !errors.As(err, &methodErr) {
+				log.Error().Err(err).Str("method", call.Name).Msg("JMAP method failed")
+				methodErr = &jmap.MethodError{Type: jmap.ErrorServerFail}
+			}
+			response.MethodResponses = append(response.MethodResponses, jmap.Invocation{Name: "error", Args: methodErr, CallID: call.CallID})
+			continue
+		}
+
+		response.MethodResponses = append(response.MethodResponses, jmap.Invocation{Name: call.Name, Args: result, CallID: call.CallID})
+		response.MethodResponses = append(response.MethodResponses, r.implicit...)
+	}
+
+	if req.CreatedIDs != nil {
+		response.CreatedIDs = r.createdIDs
+	}
+
+	return response, nil
+}
+
+func (s *JMAPService) call(r *jmapRequest, call *jmap.Call, using map[string]bool, responses []jmap.Invocation) (interface{}, error) {
+	method, ok := jmapMethods[call.Name]
+	if !ok || !using[method.capability] {
+		return nil, jmap.NewMethodError(jmap.ErrorUnknownMethod, "%s", call.Name)
+	}
+	if err := call.ResolveReferences(responses); err != nil {
+		return nil, err
+	}
+	return method.handler(s, r, call)
+}
+
+// States returns the current state of each data type in an account, as
+// pushed over the event source
+func (s *JMAPService) States(accountID string) (map[string]string, error) {
+	states := make(map[string]string, 3)
+	for _, objectType := range []string{model.ChangeObjectEmail, model.ChangeObjectThread, model.ChangeObjectMailbox} {
+		state, err := s.state(accountID, objectType)
+		if err != nil {
+			return nil, err
+		}
+		states[objectType] = state
+	}
+	return states, nil
+}
+
+// PruneChanges periodically removes change log entries older than the
+// configured retention. It runs until the process exits.
+func (s *JMAPService) PruneChanges() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		n, err := s.changeRepo.Prune(time.Now().Add(-s.config.JMAP.ChangeRetention))
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to prune mail change log")
+		} else if n > 0 {
+			log.Info().Int64("changes", n).Msg("Pruned mail change log")
+		}
+		<-ticker.C
+	}
+}
+
+// Shared /get and /changes plumbing
+
+type jmapGetArgs struct {
+	AccountID  string    `json:"accountId"`
+	IDs        *[]string `json:"ids"`
+	Properties []string  `json:"properties"`
+}
+
+type jmapGetResponse struct {
+	AccountID string                   `json:"accountId"`
+	State     string                   `json:"state"`
+	List      []map[string]interface{} `json:"list"`
+	NotFound  []string                 `json:"notFound"`
+}
+
+type jmapChangesArgs struct {
+	AccountID  string `json:"accountId"`
+	SinceState string `json:"sinceState"`
+	MaxChanges *int   `json:"maxChanges"`
+}
+
+type jmapChangesResponse struct {
+	AccountID      string   `json:"accountId"`
+	OldState       string   `json:"oldState"`
+	NewState       string   `json:"newState"`
+	HasMoreChanges bool     `json:"hasMoreChanges"`
+	Created        []string `json:"created"`
+	Updated        []string `json:"updated"`
+	Destroyed      []string `json:"destroyed"`
+}
+
+func (s *JMAPService) state(accountID, objectType string) (string, error) {
+	seq, err := s.changeRepo.State(accountID, objectType)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(seq, 10), nil
+}
+
+// changes implements /changes from the change log. Several changes to one
+// object collapse into one entry; objects created and destroyed since the
+// old state are left out.
+func (s *JMAPService) changes(r *jmapRequest, objectType string, call *jmap.Call, mapID func(string) string) (*jmapChangesResponse, error) {
+	var args jmapChangesArgs
+	if err := call.Decode(&args); err != nil {
+		return nil, err
+	}
+	account, err := r.account(args.AccountID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	since, err := strconv.ParseInt(args.SinceState, 10, 64)
+	if err != nil || since < 0 {
+		return nil, jmap.NewMethodError(jmap.ErrorCannotCalculateChanges, "unknown state %q", args.SinceState)
+	}
+
+	current, err := s.changeRepo.State(account.ID, objectType)
+	if err != nil {
+		return nil, err
+	}
+	prunedUpTo, err := s.changeRepo.PrunedUpTo(account.ID)
+	if err != nil {
+		return nil, err
+	}
+	if since > current || since < prunedUpTo {
+		return nil, jmap.NewMethodError(jmap.ErrorCannotCalculateChanges, "state %q is too old or unknown", args.SinceState)
+	}
+
+	limit := jmapMaxChanges
+	if args.MaxChanges != nil {
+		if *args.MaxChanges <= 0 {
+			return nil, jmap.NewMethodError(jmap.ErrorInvalidArguments, "maxChanges must be positive")
+		}
+		if *args.MaxChanges < limit {
+			limit = *args.MaxChanges
+		}
+	}
+
+	rows, err := s.changeRepo.Changes(account.ID, objectType, since, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &jmapChangesResponse{
+		AccountID: account.ID,
+		OldState:  args.SinceState,
+		NewState:  args.SinceState,
+		Created:   []string{},
+		Updated:   []string{},
+		Destroyed: []string{},
+	}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		response.HasMoreChanges = true
+	}
+	if len(rows) > 0 {
+		response.NewState = strconv.FormatInt(rows[len(rows)-1].Seq, 10)
+	}
+
+	var order []string
+	status := make(map[string]string)
+	for _, row := range rows {
+		prev, seen := status[row.ObjectID]
+		switch row.ChangeType {
+		case model.ChangeCreated:
+			if seen && prev == model.ChangeDestroyed {
+				// Existed at the old state, was removed and came back
+				status[row.ObjectID] = model.ChangeUpdated
+			} else {
+				status[row.ObjectID] = model.ChangeCreated
+			}
+		case model.ChangeUpdated:
+			if !seen {
+				status[row.ObjectID] = model.ChangeUpdated
+			}
+		case model.ChangeDestroyed:
+			if seen && prev == model.ChangeCreated {
+				delete(status, row.ObjectID)
+				continue
+			}
+			status[row.ObjectID] = model.ChangeDestroyed
+		}
+		if !seen {
+			order = append(order, row.ObjectID)
+		}
+	}
+
+	for _, objectID := range order {
+		id := mapID(objectID)
+		switch status[objectID] {
+		case model.ChangeCreated:
+			response.Created = append(response.Created, id)
+		case model.ChangeUpdated:
+			response.Updated = append(response.Updated, id)
+		case model.ChangeDestroyed:
+			response.Destroyed = append(response.Destroyed, id)
+		}
+	}
+
+	return response, nil
+}
+
+// filterProperties keeps the requested properties of an object; "id" is
+// always returned. Unknown properties are an error.
+func filterProperties(object map[string]interface{}, properties []string) (map[string]interface{}, error) {
+	if properties == nil {
+		return object, nil
+	}
+	filtered := map[string]interface{}{"id": object["id"]}
+	for _, property := range properties {
+		value, ok := object[property]
+		if !ok {
+			return nil, jmap.NewMethodError(jmap.ErrorInvalidArguments, "unknown property %s", property)
+		}
+		filtered[property] = value
+	}
+	return filtered, nil
+}
+
+// jmapThreadID returns a JMAP-safe thread ID. Threads started by replies
+// are keyed by the parent's Message-ID, which contains characters JMAP IDs
+// may not, so such keys are base64url-encoded.
+func jmapThreadID(threadID string) string {
+	if jmap.ValidID(threadID) {
+		return threadID
+	}
+	return "t" + base64.RawURLEncoding.EncodeToString([]byte(threadID))
+}
+
+// threadIDFromJMAP reverses jmapThreadID
+func threadIDFromJMAP(id string) string {
+	if strings.HasPrefix(id, "t") {
+		if raw, err := base64.RawURLEncoding.DecodeString(id[1:]); err == nil && !jmap.ValidID(string(raw)) {
+			return string(raw)
+		}
+	}
+	return id
+}
+
+func identityID(id string) string {
+	return id
+}
+
+// Core
+
+func (s *JMAPService) coreEcho(r *jmapRequest, call *jmap.Call) (interface{}, error) {
+	return call.Args, nil
+}
+
+// Mailbox
+
+func (s *JMAPService) mailboxGet(r *jmapRequest, call *jmap.Call) (interface{}, error) {
+	var args jmapGetArgs
+	if err := call.Decode(&args); err != nil {
+		return nil, err
+	}
+	account, err := r.account(args.AccountID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := s.state(account.ID, model.ChangeObjectMailbox)
+	if err != nil {
+		return nil, err
+	}
+
+	folders, err := s.folderRepo.List(account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var wanted map[string]bool
+	if args.IDs != nil {
+		if len(*args.IDs) > jmapMaxObjectsInGet {
+			return nil, jmap.NewMethodError(jmap.ErrorRequestTooLarge, "at most %d ids", jmapMaxObjectsInGet)
+		}
+		wanted = make(map[string]bool, len(*args.IDs))
+		for _, id := range *args.IDs {
+			wanted[r.resolveID(id)] = true
+		}
+	}
+
+	response := &jmapGetResponse{
+		AccountID: account.ID,
+		State:     state,
+		List:      []map[string]interface{}{},
+		NotFound:  []string{},
+	}
+
+	found := make(map[string]bool)
+	for i := range folders {
+		folder := &folders[i]
+		if wanted != nil && !wanted[folder.ID] {
+			continue
+		}
+		found[folder.ID] = true
+
+		object, err := filterProperties(s.mailboxObject(folder, account), args.Properties)
+		if err != nil {
+			return nil, err
+		}
+		response.List = append(response.List, object)
+	}
+
+	for id := range wanted {
+		if !found[id] {
+			response.NotFound = append(response.NotFound, id)
+		}
+	}
+
+	return response, nil
+}
+
+func (s *JMAPService) mailboxObject(folder *model.Folder, account *JMAPAccount) map[string]interface{} {
+	totalThreads, unreadThreads := s.folderRepo.GetThreadCounts(folder.ID, account.ID)
+
+	var role interface{}
+	if r, ok := mailboxRoles[folder.Type]; ok {
+		role = r
+	}
+
+	writable := !account.ReadOnly
+	custom := folder.Type == "custom"
+
+	return map[string]interface{}{
+		"id":            folder.ID,
+		"name":          folder.Name,
+		"parentId":      folder.ParentID,
+		"role":          role,
+		"sortOrder":     folder.Order,
+		"totalEmails":   folder.TotalCount,
+		"unreadEmails":  folder.UnreadCount,
+		"totalThreads":  totalThreads,
+		"unreadThreads": unreadThreads,
+		"myRights": map[string]bool{
+			"mayReadItems":   true,
+			"mayAddItems":    writable,
+			"mayRemoveItems": writable,
+			"maySetSeen":     writable,
+			"maySetKeywords": writable,
+			"mayCreateChild": false,
+			"mayRename":      false,
+			"mayDelete":      false,
+			"maySubmit":      writable && custom,
+		},
+		"isSubscribed": true,
+	}
+}
+
+func (s *JMAPService) mailboxChanges(r *jmapRequest, call *jmap.Call) (interface{}, error) {
+	response, err := s.changes(r, model.ChangeObjectMailbox, call, identityID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Mailbox/changes must say which properties changed; null means any
+	return struct {
+		*jmapChangesResponse
+		UpdatedProperties []string `json:"updatedProperties"`
+	}{jmapChangesResponse: response}, nil
+}
+
+// Thread
+
+func (s *JMAPService) threadGet(r *jmapRequest, call *jmap.Call) (interface{}, error) {
+	var args jmapGetArgs
+	if err := call.Decode(&args); err != nil {
+		return nil, err
+	}
+	account, err := r.account(args.AccountID, false)
+	if err != nil {
+		return nil, err
+	}
+	if args.IDs == nil || len(*args.IDs) > jmapMaxObjectsInGet {
+		return nil, jmap.NewMethodError(jmap.ErrorRequestTooLarge, "ids must be given, at most %d", jmapMaxObjectsInGet)
+	}
+
+	state, err := s.state(account.ID, model.ChangeObjectThread)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &jmapGetResponse{
+		AccountID: account.ID,
+		State:     state,
+		List:      []map[string]interface{}{},
+		NotFound:  []string{},
+	}
+
+	for _, id := range *args.IDs {
+		emails, err := s.emailRepo.GetThread(threadIDFromJMAP(id), account.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(emails) == 0 {
+			response.NotFound = append(response.NotFound, id)
+			continue
+		}
+
+		emailIDs := make([]string, 0, len(emails))
+		for _, email := range emails {
+			emailIDs = append(emailIDs, email.ID)
+		}
+
+		object, err := filterProperties(map[string]interface{}{"id": id, "emailIds": emailIDs}, args.Properties)
+		if err != nil {
+			return nil, err
+		}
+		response.List = append(response.List, object)
+	}
+
+	return response, nil
+}
+
+func (s *JMAPService) threadChanges(r *jmapRequest, call *jmap.Call) (interface{}, error) {
+	return s.changes(r, model.ChangeObjectThread, call, jmapThreadID)
+}
+
+// Identity
+
+// identities returns the identities the caller may send as from an account:
+// one per sendable mailbox, using the mailbox's address
+func (s *JMAPService) identities(account *JMAPAccount) []map[string]interface{} {
+	if !account.MaySend() {
+		return nil
+	}
+	return []map[string]interface{}{{
+		"id":            account.ID,
+		"name":          "",
+		"email":         s.emailService.MailboxAddress(account.ID),
+		"replyTo":       nil,
+		"bcc":           nil,
+		"textSignature": "",
+		"htmlSignature": "",
+		"mayDelete":     false,
+	}}
+}
+
+func (s *JMAPService) identityGet(r *jmapRequest, call *jmap.Call) (interface{}, error) {
+	var args jmapGetArgs
+	if err := call.Decode(&args); err != nil {
+		return nil, err
+	}
+	account, err := r.account(args.AccountID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &jmapGetResponse{
+		AccountID: account.ID,
+		State:     "0",
+		List:      []map[string]interface{}{},
+		NotFound:  []string{},
+	}
+
+	identities := s.identities(account)
+	for _, identity := range identities {
+		if args.IDs != nil && !containsString(*args.IDs, identity["id"].(string)) {
+			continue
+		}
+		object, err := filterProperties(identity, args.Properties)
+		if err != nil {
+			return nil, err
+		}
+		response.List = append(response.List, object)
+	}
+
+	if args.IDs != nil {
+		for _, id := range *args.IDs {
+			if len(identities) == 0 || identities[0]["id"] != id {
+				response.NotFound = append(response.NotFound, id)
+			}
+		}
+	}
+
+	return response, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
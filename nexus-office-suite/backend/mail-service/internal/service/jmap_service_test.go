@@ -0,0 +1,311 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"nexus-mail-service/internal/jmap"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/search"
+)
+
+func TestExecuteProblems(t *testing.T) {
+	// Request-level problems are found before any account is loaded
+	s := &JMAPService{}
+
+	_, problem := s.Execute(nil, &jmap.Request{Using: []string{jmap.CapabilityCore, "urn:example:unknown"}})
+	if problem == nil || problem.Type != jmap.ProblemUnknownCapability {
+		t.Errorf("unknown capability: got %+v, want %s", problem, jmap.ProblemUnknownCapability)
+	}
+
+	calls := make([]jmap.Call, jmapMaxCallsInRequest+1)
+	_, problem = s.Execute(nil, &jmap.Request{Using: []string{jmap.CapabilityCore}, MethodCalls: calls})
+	if problem == nil || problem.Type != jmap.ProblemLimit || problem.Limit != "maxCallsInRequest" {
+		t.Errorf("too many calls: got %+v, want the maxCallsInRequest limit", problem)
+	}
+}
+
+func TestCallMethods(t *testing.T) {
+	s := &JMAPService{}
+	r := &jmapRequest{accounts: map[string]*JMAPAccount{}, createdIDs: map[string]string{}}
+	using := map[string]bool{jmap.CapabilityCore: true}
+	echo := map[string]json.RawMessage{"hello": json.RawMessage(`"world"`)}
+
+	result, err := s.call(r, &jmap.Call{Name: "Core/echo", Args: echo, CallID: "c1"}, using, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, echo) {
+		t.Errorf("Core/echo = %v, want its arguments", result)
+	}
+
+	// Methods of capabilities the client did not ask for do not exist
+	for _, name := range []string{"Mailbox/get", "Email/unknown"} {
+		_, err := s.call(r, &jmap.Call{Name: name, Args: map[string]json.RawMessage{}}, using, nil)
+		var methodErr *jmap.MethodError
+		if !errors.As(err, &methodErr) || methodErr.Type != jmap.ErrorUnknownMethod {
+			t.Errorf("%s: got %v, want %s", name, err, jmap.ErrorUnknownMethod)
+		}
+	}
+
+	// Result references are resolved before the method runs
+	responses := []jmap.Invocation{{Name: "Core/echo", Args: echo, CallID: "c1"}}
+	result, err = s.call(r, &jmap.Call{Name: "Core/echo", Args: map[string]json.RawMessage{
+		"#copy": json.RawMessage(`{"resultOf":"c1","name":"Core/echo","path":"/hello"}`),
+	}}, using, responses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(result.(map[string]json.RawMessage)["copy"]); got != `"world"` {
+		t.Errorf("resolved reference = %s, want \"world\"", got)
+	}
+}
+
+func TestRequestAccount(t *testing.T) {
+	r := &jmapRequest{accounts: map[string]*JMAPAccount{
+		"own":    {ID: "own", Personal: true},
+		"shared": {ID: "shared", ReadOnly: true},
+	}}
+
+	if _, err := r.account("own", true); err != nil {
+		t.Errorf("own account: %v", err)
+	}
+	if _, err := r.account("shared", false); err != nil {
+		t.Errorf("reading a read-only account: %v", err)
+	}
+	tests := []struct {
+		id    string
+		write bool
+		want  string
+	}{
+		{"shared", true, jmap.ErrorAccountReadOnly},
+		{"other", false, jmap.ErrorAccountNotFound},
+	}
+	for _, tt := range tests {
+		_, err := r.account(tt.id, tt.write)
+		var methodErr *jmap.MethodError
+		if !errors.As(err, &methodErr) || methodErr.Type != tt.want {
+			t.Errorf("account %s: got %v, want %s", tt.id, err, tt.want)
+		}
+	}
+}
+
+// Thread IDs are the ID of the first email or the Message-ID it replied to
+func TestJMAPThreadID(t *testing.T) {
+	for _, threadID := range []string{"7f3c1e2a-9b4d-4c1e-8a7f-0e2d3c4b5a69", "<parent@example.com>"} {
+		id := jmapThreadID(threadID)
+		if !jmap.ValidID(id) {
+			t.Errorf("jmapThreadID(%q) = %q, not a valid JMAP ID", threadID, id)
+		}
+		if got := threadIDFromJMAP(id); got != threadID {
+			t.Errorf("threadIDFromJMAP(%q) = %q, want %q", id, got, threadID)
+		}
+	}
+}
+
+func TestFilterProperties(t *testing.T) {
+	object := map[string]interface{}{"id": "e1", "subject": "Hi", "size": 10}
+
+	filtered, err := filterProperties(object, []string{"subject"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"id": "e1", "subject": "Hi"}; !reflect.DeepEqual(filtered, want) {
+		t.Errorf("got %v, want %v", filtered, want)
+	}
+	if all, _ := filterProperties(object, nil); !reflect.DeepEqual(all, object) {
+		t.Errorf("without properties got %v, want every property", all)
+	}
+	if _, err := filterProperties(object, []string{"colour"}); err == nil {
+		t.Error("unknown property accepted")
+	}
+}
+
+func TestParseHeaderProperty(t *testing.T) {
+	tests := []struct {
+		property string
+		name     string
+		form     string
+		all      bool
+		ok       bool
+	}{
+		{"header:Subject", "Subject", "asRaw", false, true},
+		{"header:From:asAddresses", "From", "asAddresses", false, true},
+		{"header:Received:all", "Received", "asRaw", true, true},
+		{"header:References:asMessageIds:all", "References", "asMessageIds", true, true},
+		{"header:X:all:asText", "", "", false, false},
+		{"header:X:asDate", "", "", false, false},
+		{"header:", "", "", false, false},
+		{"subject", "", "", false, false},
+	}
+	for _, tt := range tests {
+		name, form, all, ok := parseHeaderProperty(tt.property)
+		if name != tt.name || form != tt.form || all != tt.all || ok != tt.ok {
+			t.Errorf("parseHeaderProperty(%q) = %q, %q, %v, %v", tt.property, name, form, all, ok)
+		}
+	}
+}
+
+func TestEmailHeaderProperty(t *testing.T) {
+	parent := "<parent@example.com>"
+	email := &model.Email{
+		Subject:   "Lunch",
+		From:      "alice@example.com",
+		FromName:  "Alice",
+		To:        model.StringArray{"bob@example.com", "Carol <carol@example.com>"},
+		InReplyTo: &parent,
+		Headers:   model.Headers{"X-Tag": {"one", "two "}},
+	}
+
+	tests := []struct {
+		property string
+		want     interface{}
+	}{
+		{"header:Subject", " Lunch"},
+		{"header:subject:asText", "Lunch"},
+		{"header:From:asAddresses", []jmapAddress{{Name: "Alice", Email: "alice@example.com"}}},
+		{"header:To:asAddresses", []jmapAddress{{Email: "bob@example.com"}, {Name: "Carol", Email: "carol@example.com"}}},
+		{"header:In-Reply-To:asMessageIds", []string{"parent@example.com"}},
+		{"header:x-tag:asText", "two"},
+		{"header:X-Tag:asText:all", []interface{}{"one", "two"}},
+		{"header:Cc", nil},
+		{"header:Cc:all", []interface{}{}},
+	}
+	for _, tt := range tests {
+		got, err := emailHeaderProperty(email, tt.property)
+		if err != nil {
+			t.Errorf("%s: %v", tt.property, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.property, got, tt.want)
+		}
+	}
+
+	if _, err := emailHeaderProperty(email, "header:X:asDate"); err == nil {
+		t.Error("unsupported header form accepted")
+	}
+}
+
+func TestApplyKeyword(t *testing.T) {
+	email := &model.Email{}
+	for _, keyword := range []string{"$seen", "$flagged", "$draft"} {
+		if err := applyKeyword(email, keyword, true); err != nil {
+			t.Fatalf("%s: %v", keyword, err)
+		}
+	}
+	if !email.IsRead || email.ReadAt == nil || !email.IsStarred || !email.IsDraft {
+		t.Errorf("after setting keywords got %+v", email)
+	}
+
+	// Keywords that are not stored cannot be set, but removing them is a no-op
+	var setErr *jmap.SetError
+	if err := applyKeyword(email, "$important", true); !errors.As(err, &setErr) || setErr.Type != jmap.SetErrorInvalidProperties {
+		t.Errorf("setting an unknown keyword: got %v, want %s", err, jmap.SetErrorInvalidProperties)
+	}
+	if err := applyKeyword(email, "$important", false); err != nil {
+		t.Errorf("removing an unknown keyword: %v", err)
+	}
+}
+
+func TestEmailFilterTerms(t *testing.T) {
+	r := &jmapRequest{createdIDs: map[string]string{"new": "folder-2"}}
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	minSize := int64(100)
+	noAttachment := false
+
+	tests := []struct {
+		name   string
+		filter string
+		want   []search.Term
+		none   bool
+	}{
+		{
+			name:   "conditions",
+			filter: `{"inMailbox":"folder-1","from":"alice","after":"2024-01-01T00:00:00Z","minSize":100,"hasAttachment":false}`,
+			want: []search.Term{
+				{Field: search.FieldIn, Value: "folder-1"},
+				{Field: search.FieldAfter, Value: "2024-01-01T00:00:00Z", Time: after},
+				{Field: search.FieldLarger, Value: "99", Size: minSize - 1},
+				{Field: search.FieldHas, Value: "attachment", Negated: !noAttachment},
+				{Field: search.FieldFrom, Value: "alice"},
+			},
+		},
+		{
+			name:   "created mailbox",
+			filter: `{"inMailboxOtherThan":["#new"]}`,
+			want:   []search.Term{{Field: search.FieldIn, Value: "folder-2", Negated: true}},
+		},
+		{
+			name:   "AND",
+			filter: `{"operator":"AND","conditions":[{"hasKeyword":"$seen"},{"subject":"report"}]}`,
+			want:   []search.Term{{Field: search.FieldIs, Value: "read"}, {Field: search.FieldSubject, Value: "report"}},
+		},
+		{
+			name:   "OR of one condition",
+			filter: `{"operator":"OR","conditions":[{"text":"invoice"}]}`,
+			want:   []search.Term{{Field: search.FieldText, Value: "invoice"}},
+		},
+		{
+			name:   "NOT",
+			filter: `{"operator":"NOT","conditions":[{"hasKeyword":"$flagged"},{"to":"bob"}]}`,
+			want: []search.Term{
+				{Field: search.FieldIs, Value: "starred", Negated: true},
+				{Field: search.FieldTo, Value: "bob", Negated: true},
+			},
+		},
+		{
+			name:   "keyword that is never stored",
+			filter: `{"hasKeyword":"$important","from":"alice"}`,
+			none:   true,
+		},
+		{
+			name:   "NOT of everything",
+			filter: `{"operator":"NOT","conditions":[{}]}`,
+			none:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filter jmapEmailFilter
+			if err := json.Unmarshal([]byte(tt.filter), &filter); err != nil {
+				t.Fatal(err)
+			}
+			terms, none, err := filter.terms(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if none != tt.none || !reflect.DeepEqual(terms, tt.want) {
+				t.Errorf("got %+v, none %v; want %+v, none %v", terms, none, tt.want, tt.none)
+			}
+		})
+	}
+}
+
+func TestEmailFilterTermsUnsupported(t *testing.T) {
+	r := &jmapRequest{}
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{`{"operator":"OR","conditions":[{"from":"alice"},{"from":"bob"}]}`, jmap.ErrorUnsupportedFilter},
+		{`{"operator":"NOT","conditions":[{"from":"alice","to":"bob"}]}`, jmap.ErrorUnsupportedFilter},
+		{`{"header":["X-Tag"]}`, jmap.ErrorUnsupportedFilter},
+		{`{"someInThreadHaveKeyword":"$seen"}`, jmap.ErrorUnsupportedFilter},
+		{`{"operator":"XOR","conditions":[]}`, jmap.ErrorInvalidArguments},
+	}
+	for _, tt := range tests {
+		var filter jmapEmailFilter
+		if err := json.Unmarshal([]byte(tt.filter), &filter); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err := filter.terms(r)
+		var methodErr *jmap.MethodError
+		if !errors.As(err, &methodErr) || methodErr.Type != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.filter, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrNotListMember is returned when a non-member posts to a members-only list
+var ErrNotListMember = errors.New("only list members may post to this list")
+
+// ErrNotPending is returned when moderating a post that is not awaiting moderation
+var ErrNotPending = errors.New("post is not awaiting moderation")
+
+// maxDigestPosts caps how many posts go into a single digest
+const maxDigestPosts = 200
+
+// ListService handles distribution list delivery, moderation, digests and
+// the archive. Posts are stored in the list's own mailbox (user ID = list
+// ID): accepted posts in its archive folder, held posts in its moderation
+// folder. Members get copies of accepted posts.
+type ListService struct {
+	config       *config.Config
+	listRepo     *repository.ListRepository
+	emailRepo    *repository.EmailRepository
+	folderRepo   *repository.FolderRepository
+	emailService *EmailService
+}
+
+// NewListService creates a new list service
+func NewListService(
+	cfg *config.Config,
+	listRepo *repository.ListRepository,
+	emailRepo *repository.EmailRepository,
+	folderRepo *repository.FolderRepository,
+	emailService *EmailService,
+) *ListService {
+	return &ListService{
+		config:       cfg,
+		listRepo:     listRepo,
+		emailRepo:    emailRepo,
+		folderRepo:   folderRepo,
+		emailService: emailService,
+	}
+}
+
+// Lookup returns the list with a posting address, or nil if the address is
+// not a list
+func (s *ListService) Lookup(address string) (*model.DistributionList, error) {
+	list, err := s.listRepo.GetByAddress(address)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return list, err
+}
+
+// CheckPoster enforces the members-only posting policy. sender is the
+// envelope sender; userID is set when the sender authenticated.
+func (s *ListService) CheckPoster(list *model.DistributionList, sender, userID string) error {
+	if list.PostingPolicy != model.PostingMembers {
+		return nil
+	}
+	_, err := s.listRepo.FindMember(list.ID, userID, sender)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotListMember
+	}
+	return err
+}
+
+// Receive accepts a post to a list. Posts to moderated lists from anyone
+// but moderators, and posts whose attachments were quarantined, are held
+// for moderation; everything else is archived and sent to the members.
+func (s *ListService) Receive(list *model.DistributionList, email *model.Email, attachments, inlines []*enmime.Part, verdict *ContentVerdict, sender, userID string) error {
+	listID := listIDHeader(list)
+	for _, value := range email.Headers["List-Id"] {
+		if strings.Contains(value, listID) {
+			log.Warn().Str("list", list.Address).Str("from", sender).Msg("Dropping looped list message")
+			return nil
+		}
+	}
+
+	held := verdict.Quarantined()
+	if list.PostingPolicy == model.PostingModerated && !held {
+		member, err := s.listRepo.FindMember(list.ID, userID, sender)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		held = member == nil || !member.CanModerate()
+	}
+
+	folderType := "archive"
+	if held {
+		folderType = "moderation"
+	}
+	folder, err := s.folderRepo.GetOrCreateByType(folderType, list.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get list %s folder: %w", folderType, err)
+	}
+
+	email.ID = ""
+	email.UserID = list.ID
+	email.FolderID = folder.ID
+	email.IsRead = true
+
+	if exists, err := s.emailRepo.ExistsByMessageID(list.ID, email.MessageID); err == nil && exists && email.MessageID != "" {
+		// Already posted, e.g. a retry after a lost reply to DATA
+		return nil
+	}
+	if err := s.emailRepo.Create(email); err != nil {
+		return err
+	}
+	s.emailService.SaveMessageParts(email.ID, attachments, inlines, verdict)
+
+	if held {
+		log.Info().Str("list", list.Address).Str("emailID", email.ID).Str("from", sender).Msg("List post held for moderation")
+		return nil
+	}
+
+	return s.distribute(list, email.ID)
+}
+
+// distribute sends an archived post to every member who gets each message
+func (s *ListService) distribute(list *model.DistributionList, postID string) error {
+	post, err := s.emailRepo.GetByID(postID, list.ID)
+	if err != nil {
+		return err
+	}
+
+	members, err := s.listRepo.ListMembers(list.ID)
+	if err != nil {
+		return err
+	}
+
+	delivered := 0
+	for i := range members {
+		member := &members[i]
+		if member.Delivery != model.DeliveryEach {
+			continue
+		}
+
+		msg := s.listCopy(list, post)
+		if err := s.deliver(list, member, msg); err != nil {
+			log.Error().Err(err).Str("list", list.Address).Str("member", member.Address).Msg("Failed to deliver list post")
+			continue
+		}
+		delivered++
+	}
+
+	log.Info().
+		Str("list", list.Address).
+		Str("emailID", post.ID).
+		Int("delivered", delivered).
+		Msg("List post distributed")
+
+	return nil
+}
+
+// listCopy prepares a member's copy of a post: List-* headers and the
+// subject prefix are added, and attachments point at the archived objects
+func (s *ListService) listCopy(list *model.DistributionList, post *model.Email) *model.Email {
+	msg := *post
+	msg.ID = ""
+	msg.IsRead = false
+	msg.Labels = nil
+
+	msg.Headers = make(model.Headers, len(post.Headers)+5)
+	for name, values := range post.Headers {
+		msg.Headers[name] = values
+	}
+	for name, value := range s.listHeaders(list) {
+		msg.Headers[name] = []string{value}
+	}
+
+	if list.SubjectPrefix != "" && !strings.Contains(msg.Subject, list.SubjectPrefix) {
+		msg.Subject = list.SubjectPrefix + " " + msg.Subject
+	}
+
+	msg.Attachments = make([]model.Attachment, len(post.Attachments))
+	for i, att := range post.Attachments {
+		att.ID = ""
+		msg.Attachments[i] = att
+	}
+
+	return &msg
+}
+
+// listHeaders returns the RFC 2369/2919 headers for a list
+func (s *ListService) listHeaders(list *model.DistributionList) map[string]string {
+	headers := map[string]string{
+		"List-Id":    fmt.Sprintf("%s <%s>", list.Name, listIDHeader(list)),
+		"List-Post":  "<mailto:" + list.Address + ">",
+		"Precedence": "list",
+	}
+	if list.PostingPolicy == model.PostingModerated {
+		headers["List-Post"] += " (Postings are Moderated)"
+	}
+	if list.ArchiveEnabled && s.config.Lists.ArchiveURL != "" {
+		headers["List-Archive"] = "<" + s.config.Lists.ArchiveURL + "/" + list.ID + ">"
+	}
+	return headers
+}
+
+// listIDHeader is the list identifier of RFC 2919: the address with the @
+// replaced by a dot
+func listIDHeader(list *model.DistributionList) string {
+	return strings.Replace(list.Address, "@", ".", 1)
+}
+
+// deliver puts a message in a local member's inbox, or relays it to an
+// external member
+func (s *ListService) deliver(list *model.DistributionList, member *model.ListMember, email *model.Email) error {
+	if member.UserID == nil {
+		return s.emailService.relayViaSMTP(email, list.Address, []string{member.Address})
+	}
+
+	userID := *member.UserID
+	if exists, err := s.emailRepo.ExistsByMessageID(userID, email.MessageID); err != nil || exists {
+		// The member already has this message, e.g. as a direct recipient
+		return err
+	}
+
+	inbox, err := s.folderRepo.GetByType("inbox", userID)
+	if err != nil {
+		return fmt.Errorf("failed to get inbox folder: %w", err)
+	}
+
+	email.UserID = userID
+	email.FolderID = inbox.ID
+	if err := s.emailRepo.Create(email); err != nil {
+		return err
+	}
+
+	for i := range email.Attachments {
+		att := &email.Attachments[i]
+		att.EmailID = email.ID
+		if err := s.emailRepo.CreateAttachment(att); err != nil {
+			log.Error().Err(err).Str("emailID", email.ID).Msg("Failed to save list attachment")
+		}
+	}
+
+	return nil
+}
+
+// Pending lists the posts awaiting moderation, oldest first
+func (s *ListService) Pending(list *model.DistributionList) ([]model.Email, error) {
+	folder, err := s.folderRepo.GetOrCreateByType("moderation", list.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	emails, _, err := s.emailRepo.List(list.ID, folder.ID, 1, 500, nil)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(emails)-1; i < j; i, j = i+1, j-1 {
+		emails[i], emails[j] = emails[j], emails[i]
+	}
+	return emails, nil
+}
+
+// pendingPost returns a post from the moderation queue
+func (s *ListService) pendingPost(list *model.DistributionList, emailID string) (*model.Email, *model.Folder, error) {
+	folder, err := s.folderRepo.GetOrCreateByType("moderation", list.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	email, err := s.emailRepo.GetByID(emailID, list.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if email.FolderID != folder.ID {
+		return nil, nil, ErrNotPending
+	}
+	return email, folder, nil
+}
+
+// Approve archives a held post and sends it to the members
+func (s *ListService) Approve(list *model.DistributionList, emailID, moderatorID string) error {
+	email, _, err := s.pendingPost(list, emailID)
+	if err != nil {
+		return err
+	}
+
+	archive, err := s.folderRepo.GetOrCreateByType("archive", list.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.emailRepo.MoveToFolder(email.ID, list.ID, archive.ID); err != nil {
+		return err
+	}
+
+	log.Info().Str("list", list.Address).Str("emailID", email.ID).Str("moderator", moderatorID).Msg("List post approved")
+	return s.distribute(list, email.ID)
+}
+
+// Reject discards a held post
+func (s *ListService) Reject(list *model.DistributionList, emailID, moderatorID string) error {
+	email, _, err := s.pendingPost(list, emailID)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("list", list.Address).Str("emailID", email.ID).Str("moderator", moderatorID).Msg("List post rejected")
+	return s.emailRepo.PermanentDelete(email.ID, list.ID)
+}
+
+// Archive returns a page of a list's archived posts, newest first
+func (s *ListService) Archive(list *model.DistributionList, page, pageSize int) (*model.EmailListResponse, error) {
+	folder, err := s.folderRepo.GetOrCreateByType("archive", list.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	emails, total, err := s.emailRepo.List(list.ID, folder.ID, page, pageSize, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.EmailListResponse{
+		Emails:   emails,
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+		HasMore:  page*pageSize < total,
+	}, nil
+}
+
+// ArchivedPost returns one archived post with its attachments
+func (s *ListService) ArchivedPost(list *model.DistributionList, emailID string) (*model.Email, error) {
+	folder, err := s.folderRepo.GetOrCreateByType("archive", list.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := s.emailRepo.GetByID(emailID, list.ID)
+	if err != nil {
+		return nil, err
+	}
+	if email.FolderID != folder.ID {
+		return nil, sql.ErrNoRows
+	}
+	return email, nil
+}
+
+// RunDigests periodically sends digests to members in digest mode. It runs
+// until the process exits.
+func (s *ListService) RunDigests() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.sendDigests()
+	}
+}
+
+func (s *ListService) sendDigests() {
+	members, err := s.listRepo.DigestsDue(time.Now().Add(-s.config.Lists.DigestInterval))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find due list digests")
+		return
+	}
+
+	lists := make(map[string]*model.DistributionList)
+	for i := range members {
+		member := &members[i]
+
+		list, ok := lists[member.ListID]
+		if !ok {
+			if list, err = s.listRepo.Get(member.ListID); err != nil {
+				log.Error().Err(err).Str("listID", member.ListID).Msg("Failed to load list for digest")
+				continue
+			}
+			lists[member.ListID] = list
+		}
+
+		if err := s.sendDigest(list, member); err != nil {
+			log.Error().Err(err).Str("list", list.Address).Str("member", member.Address).Msg("Failed to send list digest")
+		}
+	}
+}
+
+// sendDigest sends one member the posts archived since their last digest
+func (s *ListService) sendDigest(list *model.DistributionList, member *model.ListMember) error {
+	folder, err := s.folderRepo.GetOrCreateByType("archive", list.ID)
+	if err != nil {
+		return err
+	}
+
+	posts, _, err := s.emailRepo.List(list.ID, folder.ID, 1, maxDigestPosts, map[string]interface{}{
+		"received_after": member.LastDigestAt,
+	})
+	if err != nil || len(posts) == 0 {
+		return err
+	}
+
+	// Posts come newest first; the digest reads oldest first
+	newest := posts[0].ReceivedAt
+	for i, j := 0, len(posts)-1; i < j; i, j = i+1, j-1 {
+		posts[i], posts[j] = posts[j], posts[i]
+	}
+
+	domain := list.Address[strings.LastIndex(list.Address, "@")+1:]
+	digest := &model.Email{
+		MessageID: fmt.Sprintf("<digest.%s.%d@%s>", member.ID, newest.Unix(), domain),
+		From:      list.Address,
+		FromName:  list.Name,
+		To:        model.StringArray{member.Address},
+		Subject:   fmt.Sprintf("%s digest, %d messages", list.Name, len(posts)),
+		Body:      digestBody(list, posts),
+		Priority:  "normal",
+		Headers:   make(model.Headers),
+	}
+	for name, value := range s.listHeaders(list) {
+		digest.Headers[name] = []string{value}
+	}
+	digest.Size = int64(len(digest.Body))
+
+	if err := s.deliver(list, member, digest); err != nil {
+		return err
+	}
+	return s.listRepo.MarkDigestSent(member.ID, newest)
+}
+
+// digestBody lays out posts as a plain-text digest: a table of contents,
+// then each message
+func digestBody(list *model.DistributionList, posts []model.Email) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s digest\n\nToday's topics:\n\n", list.Name)
+	for i, post := range posts {
+		fmt.Fprintf(&b, "  %d. %s (%s)\n", i+1, post.Subject, post.From)
+	}
+
+	for i, post := range posts {
+		body := post.Body
+		if body == "" {
+			body = strings.TrimSpace(htmlTagPattern.ReplaceAllString(post.BodyHTML, " "))
+		}
+
+		fmt.Fprintf(&b, "\n----------------------------------------------------------------------\n\n")
+		fmt.Fprintf(&b, "Message: %d\nFrom: %s\nDate: %s\nSubject: %s\n\n%s\n",
+			i+1, post.From, post.ReceivedAt.Format(time.RFC1123Z), post.Subject, strings.TrimSpace(body))
+	}
+
+	fmt.Fprintf(&b, "\n----------------------------------------------------------------------\n\nEnd of %s digest\n", list.Name)
+	return b.String()
+}
@@ -0,0 +1,837 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/jhillyerd/enmime"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrUnsupportedArchive is returned for uploads that are not mbox or EML
+var ErrUnsupportedArchive = errors.New("unsupported archive: upload an .mbox file, an .eml file or a .zip of .eml files")
+
+// errDuplicateMessage marks an imported message whose Message-ID already exists
+var errDuplicateMessage = errors.New("duplicate message")
+
+const (
+	// mailboxJobConcurrency caps how many imports/exports run at once
+	mailboxJobConcurrency = 2
+	// jobProgressInterval is how many messages are processed between progress updates
+	jobProgressInterval = 25
+	// mboxDateLayout is the asctime date of an mbox "From " separator line
+	mboxDateLayout = "Mon Jan _2 15:04:05 2006"
+)
+
+// importFolderTypes maps common folder names from other mail systems to
+// system folder types
+var importFolderTypes = map[string]string{
+	"inbox":            "inbox",
+	"sent":             "sent",
+	"sent mail":        "sent",
+	"sent items":       "sent",
+	"sent messages":    "sent",
+	"drafts":           "drafts",
+	"draft":            "drafts",
+	"trash":            "trash",
+	"bin":              "trash",
+	"deleted items":    "trash",
+	"deleted messages": "trash",
+	"spam":             "spam",
+	"junk":             "spam",
+	"junk e-mail":      "spam",
+	"junk email":       "spam",
+}
+
+// gmailStateLabels are X-Gmail-Labels values that are flags, not folders
+var gmailStateLabels = map[string]bool{
+	"important": true,
+	"opened":    true,
+	"unread":    true,
+	"starred":   true,
+	"archived":  true,
+}
+
+// MailboxTransferService imports and exports mailboxes as mbox or EML
+// archives in background jobs
+type MailboxTransferService struct {
+	config        *config.Config
+	emailRepo     *repository.EmailRepository
+	folderRepo    *repository.FolderRepository
+	jobRepo       *repository.JobRepository
+	emailService  *EmailService
+	contentFilter *ContentFilter
+	tenants       *TenantDirectory
+	slots         chan struct{}
+}
+
+// NewMailboxTransferService creates a new transfer service. Jobs left
+// unfinished by a previous process are marked failed.
+func NewMailboxTransferService(
+	cfg *config.Config,
+	emailRepo *repository.EmailRepository,
+	folderRepo *repository.FolderRepository,
+	jobRepo *repository.JobRepository,
+	emailService *EmailService,
+	contentFilter *ContentFilter,
+	tenants *TenantDirectory,
+) *MailboxTransferService {
+	if n, err := jobRepo.FailInterrupted(); err != nil {
+		log.Warn().Err(err).Msg("Failed to clean up interrupted mailbox jobs")
+	} else if n > 0 {
+		log.Warn().Int64("jobs", n).Msg("Marked interrupted mailbox jobs as failed")
+	}
+
+	return &MailboxTransferService{
+		config:        cfg,
+		emailRepo:     emailRepo,
+		folderRepo:    folderRepo,
+		jobRepo:       jobRepo,
+		emailService:  emailService,
+		contentFilter: contentFilter,
+		tenants:       tenants,
+		slots:         make(chan struct{}, mailboxJobConcurrency),
+	}
+}
+
+// StartImport stores an uploaded archive and imports it in the background.
+// If folderID is set every message goes there; otherwise the folders
+// recorded in the archive are used (created as needed). Attachments are
+// checked against the content policy of the uploader's tenant.
+func (s *MailboxTransferService) StartImport(userID, address, filename string, r io.Reader, size int64, folderID *string) (*model.MailboxJob, error) {
+	format, contentType := importFormat(filename)
+	if format == "" {
+		return nil, ErrUnsupportedArchive
+	}
+
+	if folderID != nil {
+		if _, err := s.folderRepo.GetByID(*folderID, userID); err != nil {
+			return nil, fmt.Errorf("failed to get folder: %w", err)
+		}
+	}
+
+	tenantID, err := s.tenants.TenantForAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve tenant: %w", err)
+	}
+
+	job := &model.MailboxJob{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		TenantID: tenantID,
+		Type:     model.JobTypeImport,
+		Format:   format,
+		FolderID: folderID,
+		Filename: path.Base(strings.ReplaceAll(filename, "\\", "/")),
+	}
+	job.StoragePath = fmt.Sprintf("mailbox-jobs/%s/%s/%s", userID, job.ID, job.Filename)
+
+	if err := s.emailService.StoreObject(job.StoragePath, r, size, contentType); err != nil {
+		return nil, fmt.Errorf("failed to store archive: %w", err)
+	}
+
+	if err := s.jobRepo.Create(job); err != nil {
+		s.emailService.RemoveObject(job.StoragePath)
+		return nil, err
+	}
+
+	go s.run(job)
+	return job, nil
+}
+
+// StartExport exports a folder, or the whole account, in the background
+func (s *MailboxTransferService) StartExport(userID string, req *model.ExportMailboxRequest) (*model.MailboxJob, error) {
+	if req.FolderID != nil {
+		if _, err := s.folderRepo.GetByID(*req.FolderID, userID); err != nil {
+			return nil, fmt.Errorf("failed to get folder: %w", err)
+		}
+	}
+
+	job := &model.MailboxJob{
+		UserID:   userID,
+		Type:     model.JobTypeExport,
+		Format:   req.Format,
+		FolderID: req.FolderID,
+	}
+
+	if err := s.jobRepo.Create(job); err != nil {
+		return nil, err
+	}
+
+	go s.run(job)
+	return job, nil
+}
+
+// run executes a job once a slot is free
+func (s *MailboxTransferService) run(job *model.MailboxJob) {
+	s.slots <- struct{}{}
+	defer func() { <-s.slots }()
+
+	job.Status = model.JobStatusRunning
+	if err := s.jobRepo.UpdateProgress(job); err != nil {
+		log.Error().Err(err).Str("jobID", job.ID).Msg("Failed to update mailbox job")
+	}
+
+	var err error
+	if job.Type == model.JobTypeImport {
+		err = s.runImport(job)
+
+		// The uploaded archive is only needed while importing
+		if rmErr := s.emailService.RemoveObject(job.StoragePath); rmErr != nil {
+			log.Warn().Err(rmErr).Str("jobID", job.ID).Msg("Failed to remove imported archive")
+		}
+		job.StoragePath = ""
+	} else {
+		err = s.runExport(job)
+	}
+
+	job.Status = model.JobStatusCompleted
+	if err != nil {
+		job.Status = model.JobStatusFailed
+		job.Error = err.Error()
+		log.Error().Err(err).Str("jobID", job.ID).Str("type", job.Type).Msg("Mailbox job failed")
+	}
+
+	if err := s.jobRepo.Finish(job); err != nil {
+		log.Error().Err(err).Str("jobID", job.ID).Msg("Failed to finish mailbox job")
+	}
+
+	log.Info().
+		Str("jobID", job.ID).
+		Str("type", job.Type).
+		Str("status", job.Status).
+		Int("processed", job.Processed).
+		Int("imported", job.Imported).
+		Int("skipped", job.Skipped).
+		Int("failed", job.Failed).
+		Msg("Mailbox job finished")
+}
+
+// progress periodically stores a running job's counters
+func (s *MailboxTransferService) progress(job *model.MailboxJob) {
+	if job.Processed%jobProgressInterval != 0 {
+		return
+	}
+	if err := s.jobRepo.UpdateProgress(job); err != nil {
+		log.Warn().Err(err).Str("jobID", job.ID).Msg("Failed to update mailbox job progress")
+	}
+}
+
+// Import
+
+func (s *MailboxTransferService) runImport(job *model.MailboxJob) error {
+	object, err := s.emailService.OpenAttachment(job.StoragePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer object.Close()
+
+	importer := &mailImporter{service: s, job: job, folders: make(map[string]*model.Folder)}
+	maxSize := s.config.SMTP.MaxMessageSize
+
+	switch {
+	case job.Format == model.JobFormatMbox:
+		total, err := countMboxMessages(object)
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		job.Total = total
+		if _, err := object.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		return readMbox(object, maxSize, func(raw []byte, date time.Time) {
+			importer.importMessage(raw, "", date)
+		})
+
+	case strings.EqualFold(path.Ext(job.Filename), ".zip"):
+		info, err := object.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		zr, err := zip.NewReader(object, info.Size)
+		if err != nil {
+			return fmt.Errorf("failed to read zip archive: %w", err)
+		}
+
+		var files []*zip.File
+		for _, f := range zr.File {
+			if !f.FileInfo().IsDir() && strings.EqualFold(path.Ext(f.Name), ".eml") {
+				files = append(files, f)
+			}
+		}
+		job.Total = len(files)
+
+		for _, f := range files {
+			raw, err := readZipEntry(f, maxSize)
+			if err != nil {
+				job.Processed++
+				job.Failed++
+				log.Warn().Err(err).Str("jobID", job.ID).Str("entry", f.Name).Msg("Failed to read archived message")
+				s.progress(job)
+				continue
+			}
+
+			// Directories in the archive are folders
+			folder := path.Dir(strings.ReplaceAll(f.Name, "\\", "/"))
+			if folder == "." {
+				folder = ""
+			}
+			importer.importMessage(raw, folder, f.Modified)
+		}
+		return nil
+
+	default:
+		job.Total = 1
+		raw, err := io.ReadAll(io.LimitReader(object, maxSize+1))
+		if err != nil {
+			return fmt.Errorf("failed to read message: %w", err)
+		}
+		if int64(len(raw)) > maxSize {
+			raw = nil
+		}
+		importer.importMessage(raw, "", time.Time{})
+		return nil
+	}
+}
+
+// mailImporter imports the messages of one job
+type mailImporter struct {
+	service *MailboxTransferService
+	job     *model.MailboxJob
+	folders map[string]*model.Folder // resolved folders by lower-case name
+}
+
+// importMessage imports one raw message and updates the job counters. A nil
+// raw message was too large to import.
+func (m *mailImporter) importMessage(raw []byte, folderHint string, fallbackDate time.Time) {
+	m.job.Processed++
+	defer m.service.progress(m.job)
+
+	if raw == nil {
+		m.job.Failed++
+		log.Warn().Str("jobID", m.job.ID).Msg("Skipped message larger than the maximum message size")
+		return
+	}
+
+	err := m.store(raw, folderHint, fallbackDate)
+	switch {
+	case errors.Is(err, errDuplicateMessage):
+		m.job.Skipped++
+	case err != nil:
+		m.job.Failed++
+		log.Warn().Err(err).Str("jobID", m.job.ID).Msg("Failed to import message")
+	default:
+		m.job.Imported++
+	}
+}
+
+// store parses and saves one message with its attachments
+func (m *mailImporter) store(raw []byte, folderHint string, fallbackDate time.Time) error {
+	s := m.service
+	userID := m.job.UserID
+
+	envelope, err := enmime.ReadEnvelope(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("failed to parse message: %w", err)
+	}
+
+	messageID := strings.TrimSpace(envelope.GetHeader("Message-ID"))
+	if messageID != "" {
+		exists, err := s.emailRepo.ExistsByMessageID(userID, messageID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errDuplicateMessage
+		}
+	}
+
+	folder, err := m.folder(importFolderHint(envelope, folderHint))
+	if err != nil {
+		return fmt.Errorf("failed to resolve folder: %w", err)
+	}
+
+	date, err := envelope.Date()
+	if err != nil || date.IsZero() {
+		date = fallbackDate
+	}
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	isRead, isStarred, isDraft := importFlags(envelope)
+
+	email := &model.Email{
+		UserID:         userID,
+		MessageID:      messageID,
+		To:             model.StringArray(envelopeAddresses(envelope, "To")),
+		CC:             model.StringArray(envelopeAddresses(envelope, "Cc")),
+		BCC:            model.StringArray(envelopeAddresses(envelope, "Bcc")),
+		Subject:        envelope.GetHeader("Subject"),
+		Body:           envelope.Text,
+		BodyHTML:       envelope.HTML,
+		FolderID:       folder.ID,
+		IsRead:         isRead,
+		IsStarred:      isStarred,
+		IsDraft:        isDraft || folder.Type == "drafts",
+		IsSpam:         folder.Type == "spam",
+		HasAttachments: len(envelope.Attachments)+len(envelope.Inlines) > 0,
+		Priority:       "normal",
+		Size:           int64(len(raw)),
+		ReceivedAt:     date,
+		Headers:        make(model.Headers),
+	}
+
+	if from, err := envelope.AddressList("From"); err == nil && len(from) > 0 {
+		email.From = from[0].Address
+		email.FromName = from[0].Name
+	}
+
+	if folder.Type == "sent" {
+		email.SentAt = &date
+	}
+
+	for _, key := range envelope.GetHeaderKeys() {
+		email.Headers[key] = envelope.GetHeaderValues(key)
+	}
+
+	if inReplyTo := envelope.GetHeader("In-Reply-To"); inReplyTo != "" {
+		email.InReplyTo = &inReplyTo
+		email.ThreadID = inReplyTo
+	}
+	if references := envelope.GetHeader("References"); references != "" {
+		email.References = model.StringArray(strings.Fields(references))
+	}
+
+	// Imported attachments get the same policy and malware checks as
+	// delivered mail; unsafe ones are kept but cannot be downloaded
+	parts := append(append([]*enmime.Part{}, envelope.Attachments...), envelope.Inlines...)
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Security.ScanTimeout*2)
+	defer cancel()
+	verdict := s.contentFilter.Inspect(ctx, m.job.TenantID, parts)
+
+	if err := s.emailRepo.Create(email); err != nil {
+		return fmt.Errorf("failed to save email: %w", err)
+	}
+
+	s.emailService.SaveMessageParts(email.ID, envelope.Attachments, envelope.Inlines, verdict)
+	return nil
+}
+
+// folder resolves the folder a message is imported into
+func (m *mailImporter) folder(name string) (*model.Folder, error) {
+	s := m.service
+	userID := m.job.UserID
+
+	if m.job.FolderID != nil {
+		name = "id:" + *m.job.FolderID
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Inbox"
+	}
+
+	key := strings.ToLower(name)
+	if folder, ok := m.folders[key]; ok {
+		return folder, nil
+	}
+
+	var folder *model.Folder
+	var err error
+	if m.job.FolderID != nil {
+		folder, err = s.folderRepo.GetByID(*m.job.FolderID, userID)
+	} else if folderType, ok := importFolderTypes[key]; ok {
+		folder, err = s.folderRepo.GetByType(folderType, userID)
+	} else {
+		folder, err = m.customFolder(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.folders[key] = folder
+	return folder, nil
+}
+
+// customFolder finds a custom folder by name, creating it if needed
+func (m *mailImporter) customFolder(name string) (*model.Folder, error) {
+	folders, err := m.service.folderRepo.List(m.job.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range folders {
+		if folders[i].Type == "custom" && strings.EqualFold(folders[i].Name, name) {
+			return &folders[i], nil
+		}
+	}
+
+	folder := &model.Folder{
+		UserID: m.job.UserID,
+		Name:   name,
+		Type:   "custom",
+	}
+	if err := m.service.folderRepo.Create(folder); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+// importFolderHint returns the folder a message was in, from headers
+// written by NEXUS, Thunderbird or Gmail exports, or the archive path
+func importFolderHint(envelope *enmime.Envelope, pathHint string) string {
+	if folder := envelope.GetHeader("X-Nexus-Folder"); folder != "" {
+		return folder
+	}
+	if folder := envelope.GetHeader("X-Folder"); folder != "" {
+		return folder
+	}
+	if labels := envelope.GetHeader("X-Gmail-Labels"); labels != "" {
+		for _, label := range strings.Split(labels, ",") {
+			label = strings.TrimSpace(label)
+			lower := strings.ToLower(label)
+			if label == "" || gmailStateLabels[lower] || strings.HasPrefix(lower, "category ") {
+				continue
+			}
+			return label
+		}
+	}
+	return pathHint
+}
+
+// importFlags reads read/starred/draft state from mbox Status and X-Status
+// headers and Gmail labels. Mail without any state is treated as read.
+func importFlags(envelope *enmime.Envelope) (isRead, isStarred, isDraft bool) {
+	isRead = true
+	if status := envelope.GetHeader("Status"); status != "" {
+		isRead = strings.Contains(status, "R")
+	}
+
+	xStatus := envelope.GetHeader("X-Status")
+	isStarred = strings.Contains(xStatus, "F")
+	isDraft = strings.Contains(xStatus, "T")
+
+	for _, label := range strings.Split(envelope.GetHeader("X-Gmail-Labels"), ",") {
+		switch strings.ToLower(strings.TrimSpace(label)) {
+		case "unread":
+			isRead = false
+		case "starred":
+			isStarred = true
+		}
+	}
+
+	return isRead, isStarred, isDraft
+}
+
+// envelopeAddresses returns the bare addresses of an address header
+func envelopeAddresses(envelope *enmime.Envelope, header string) []string {
+	list, err := envelope.AddressList(header)
+	if err != nil {
+		return nil
+	}
+	addresses := make([]string, 0, len(list))
+	for _, addr := range list {
+		addresses = append(addresses, addr.Address)
+	}
+	return addresses
+}
+
+// importFormat returns the job format and content type for an upload
+func importFormat(filename string) (format, contentType string) {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".mbox", ".mbx", "":
+		return model.JobFormatMbox, "application/mbox"
+	case ".eml":
+		return model.JobFormatEML, "message/rfc822"
+	case ".zip":
+		return model.JobFormatEML, "application/zip"
+	}
+	return "", ""
+}
+
+// readZipEntry reads an archived message, refusing ones over maxSize
+func readZipEntry(f *zip.File, maxSize int64) ([]byte, error) {
+	if int64(f.UncompressedSize64) > maxSize {
+		return nil, fmt.Errorf("message larger than %d bytes", maxSize)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(raw)) > maxSize {
+		return nil, fmt.Errorf("message larger than %d bytes", maxSize)
+	}
+	return raw, nil
+}
+
+// countMboxMessages counts the "From " separator lines of an mbox
+func countMboxMessages(r io.Reader) (int, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	count := 0
+	for {
+		line, err := br.ReadSlice('\n')
+		if bytes.HasPrefix(line, []byte("From ")) {
+			count++
+		}
+		// Skip the remainder of lines longer than the buffer
+		for err == bufio.ErrBufferFull {
+			_, err = br.ReadSlice('\n')
+		}
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+// readMbox splits an mbox (mboxo or mboxrd) into messages, undoing ">From "
+// escaping. Messages over maxSize are passed as nil.
+func readMbox(r io.Reader, maxSize int64, fn func(raw []byte, date time.Time)) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var msg bytes.Buffer
+	var date time.Time
+	inMessage, tooLarge := false, false
+
+	flush := func() {
+		if !inMessage {
+			return
+		}
+		if tooLarge {
+			fn(nil, date)
+		} else {
+			fn(append([]byte(nil), msg.Bytes()...), date)
+		}
+		msg.Reset()
+	}
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if bytes.HasPrefix(line, []byte("From ")) {
+				flush()
+				inMessage, tooLarge = true, false
+				date = parseMboxFromLine(line)
+			} else if inMessage && !tooLarge {
+				if isEscapedFromLine(line) {
+					line = line[1:]
+				}
+				if int64(msg.Len()+len(line)) > maxSize {
+					tooLarge = true
+					msg.Reset()
+				} else {
+					msg.Write(line)
+				}
+			}
+		}
+		if err == io.EOF {
+			flush()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isEscapedFromLine reports whether a line is ">From ", ">>From ", etc.
+func isEscapedFromLine(line []byte) bool {
+	trimmed := bytes.TrimLeft(line, ">")
+	return len(trimmed) < len(line) && bytes.HasPrefix(trimmed, []byte("From "))
+}
+
+// parseMboxFromLine returns the date of a "From sender date" line, or zero
+func parseMboxFromLine(line []byte) time.Time {
+	fields := strings.Fields(string(line))
+	if len(fields) < 3 {
+		return time.Time{}
+	}
+	value := strings.Join(fields[2:], " ")
+	for _, layout := range []string{"Mon Jan 2 15:04:05 2006", "Mon Jan 2 15:04:05 -0700 2006", "Mon Jan 2 15:04:05 MST 2006"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Export
+
+func (s *MailboxTransferService) runExport(job *model.MailboxJob) error {
+	folderID := ""
+	if job.FolderID != nil {
+		folderID = *job.FolderID
+	}
+
+	ids, err := s.emailRepo.ListIDs(job.UserID, folderID)
+	if err != nil {
+		return fmt.Errorf("failed to list emails: %w", err)
+	}
+	job.Total = len(ids)
+
+	folders, err := s.folderRepo.List(job.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to list folders: %w", err)
+	}
+	folderNames := make(map[string]string, len(folders))
+	for _, folder := range folders {
+		folderNames[folder.ID] = folder.Name
+	}
+
+	tmp, err := os.CreateTemp("", "nexus-export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	out := bufio.NewWriter(tmp)
+	var zw *zip.Writer
+	if job.Format == model.JobFormatEML {
+		zw = zip.NewWriter(out)
+	}
+
+	for i, id := range ids {
+		job.Processed++
+
+		email, err := s.emailRepo.GetByID(id, job.UserID)
+		if err != nil {
+			job.Failed++
+			s.progress(job)
+			continue
+		}
+
+		folderName := folderNames[email.FolderID]
+		var buf bytes.Buffer
+		if err := s.emailService.EncodeMessage(&buf, email, folderName); err != nil {
+			job.Failed++
+			log.Warn().Err(err).Str("jobID", job.ID).Str("emailID", id).Msg("Failed to export message")
+			s.progress(job)
+			continue
+		}
+
+		if zw != nil {
+			w, err := zw.CreateHeader(&zip.FileHeader{
+				Name:     fmt.Sprintf("%s/%06d.eml", archiveFolderName(folderName), i+1),
+				Method:   zip.Deflate,
+				Modified: email.ReceivedAt,
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				return err
+			}
+		} else if err := writeMboxMessage(out, email, buf.Bytes()); err != nil {
+			return err
+		}
+
+		s.progress(job)
+	}
+
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	name := "mailbox"
+	if job.FolderID != nil {
+		name = archiveFolderName(folderNames[*job.FolderID])
+	}
+	contentType := "application/mbox"
+	job.Filename = fmt.Sprintf("%s-%s.mbox", name, job.CreatedAt.Format("2006-01-02"))
+	if job.Format == model.JobFormatEML {
+		contentType = "application/zip"
+		job.Filename = fmt.Sprintf("%s-%s.zip", name, job.CreatedAt.Format("2006-01-02"))
+	}
+	job.StoragePath = fmt.Sprintf("mailbox-jobs/%s/%s/%s", job.UserID, job.ID, job.Filename)
+
+	if err := s.emailService.StoreObject(job.StoragePath, tmp, info.Size(), contentType); err != nil {
+		return fmt.Errorf("failed to store export: %w", err)
+	}
+
+	return nil
+}
+
+// writeMboxMessage appends a message to an mboxrd file
+func writeMboxMessage(w io.Writer, email *model.Email, raw []byte) error {
+	sender := email.From
+	if sender == "" {
+		sender = "MAILER-DAEMON"
+	}
+
+	if _, err := fmt.Fprintf(w, "From %s %s\n", sender, email.ReceivedAt.UTC().Format(mboxDateLayout)); err != nil {
+		return err
+	}
+
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	for _, line := range bytes.SplitAfter(raw, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			if _, err := w.Write([]byte(">")); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+
+	if !bytes.HasSuffix(raw, []byte("\n")) {
+		if _, err := w.Write([]byte("\n")); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte("\n"))
+	return err
+}
+
+// archiveFolderName makes a folder name safe as a file or directory name
+func archiveFolderName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return "mailbox"
+	}
+	return name
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+)
+
+// ErrQuotaExceeded is returned when storing a message would take a mailbox
+// or its tenant over its storage quota
+var ErrQuotaExceeded = errors.New("mailbox storage quota exceeded")
+
+// QuotaService enforces mailbox and tenant storage quotas. Usage is kept
+// current by database trigger; mailboxes without a quota of their own get
+// DEFAULT_QUOTA_MB and tenants without one are unlimited.
+type QuotaService struct {
+	config    *config.Config
+	quotaRepo *repository.QuotaRepository
+}
+
+// NewQuotaService creates a new quota service
+func NewQuotaService(cfg *config.Config, quotaRepo *repository.QuotaRepository) *QuotaService {
+	return &QuotaService{
+		config:    cfg,
+		quotaRepo: quotaRepo,
+	}
+}
+
+// Status returns a mailbox's usage and limits
+func (s *QuotaService) Status(userID string) (*model.QuotaStatus, error) {
+	usage, err := s.quotaRepo.GetUsage(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := s.limit(model.QuotaScopeUser, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &model.QuotaStatus{
+		UserID:            userID,
+		StorageBytes:      usage.StorageBytes,
+		MessageCount:      usage.MessageCount,
+		StorageLimitBytes: limit,
+	}
+
+	if usage.TenantID != nil {
+		status.TenantID = *usage.TenantID
+		if status.TenantLimitBytes, err = s.limit(model.QuotaScopeTenant, *usage.TenantID); err != nil {
+			return nil, err
+		}
+		if status.TenantLimitBytes > 0 {
+			if status.TenantStorageBytes, err = s.quotaRepo.TenantUsage(*usage.TenantID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return status, nil
+}
+
+// Check returns ErrQuotaExceeded if storing incoming more bytes would take
+// the mailbox or its tenant over quota. With incoming 0 (size unknown) it
+// only fails once a quota is already used up. tenantID, if set, is the
+// tenant the address mail is being delivered to is registered to, and is
+// recorded as the mailbox's tenant unless it already has one.
+func (s *QuotaService) Check(userID, tenantID string, incoming int64) error {
+	if tenantID != "" {
+		if err := s.quotaRepo.AssignTenant(userID, tenantID); err != nil {
+			return err
+		}
+	}
+
+	status, err := s.Status(userID)
+	if err != nil {
+		return err
+	}
+
+	if overQuota(status.StorageBytes, incoming, status.StorageLimitBytes) ||
+		overQuota(status.TenantStorageBytes, incoming, status.TenantLimitBytes) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// limit returns the storage limit for a mailbox or tenant; 0 is unlimited
+func (s *QuotaService) limit(scope, subjectID string) (int64, error) {
+	quota, err := s.quotaRepo.GetQuota(scope, subjectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if scope == model.QuotaScopeUser {
+			return s.config.Email.DefaultQuotaMB * 1024 * 1024, nil
+		}
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return quota.StorageLimitBytes, nil
+}
+
+func overQuota(used, incoming, limit int64) bool {
+	if limit <= 0 {
+		return false
+	}
+	if incoming == 0 {
+		return used >= limit
+	}
+	return used+incoming > limit
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"time"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/repository"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RetentionService purges mail that has outlived its folder's retention
+// period, together with attachment blobs nothing else refers to
+type RetentionService struct {
+	config        *config.Config
+	retentionRepo *repository.RetentionRepository
+	emailService  *EmailService
+}
+
+// NewRetentionService creates a new retention service
+func NewRetentionService(
+	cfg *config.Config,
+	retentionRepo *repository.RetentionRepository,
+	emailService *EmailService,
+) *RetentionService {
+	return &RetentionService{
+		config:        cfg,
+		retentionRepo: retentionRepo,
+		emailService:  emailService,
+	}
+}
+
+// RetentionFolderTypes are the folder types retention policies apply to
+var RetentionFolderTypes = []string{"trash", "spam"}
+
+// DefaultRetentionDays returns the configured retention period for a
+// folder type, used when a tenant has no override
+func (s *RetentionService) DefaultRetentionDays(folderType string) int {
+	switch folderType {
+	case "trash":
+		return s.config.Retention.TrashDays
+	case "spam":
+		return s.config.Retention.SpamDays
+	default:
+		return 0
+	}
+}
+
+// RunPurge purges expired mail every PurgeInterval. It runs until the
+// process exits.
+func (s *RetentionService) RunPurge() {
+	ticker := time.NewTicker(s.config.Retention.PurgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.Purge()
+	}
+}
+
+// Purge deletes all currently expired mail and returns how many emails
+// were deleted
+func (s *RetentionService) Purge() int {
+	now := time.Now()
+	total := 0
+
+	for _, folderType := range RetentionFolderTypes {
+		for {
+			ids, err := s.retentionRepo.ExpiredEmails(folderType, s.DefaultRetentionDays(folderType), now, s.config.Retention.PurgeBatch)
+			if err != nil {
+				log.Error().Err(err).Str("folder", folderType).Msg("Failed to find expired emails")
+				break
+			}
+			if len(ids) == 0 {
+				break
+			}
+
+			orphaned, err := s.retentionRepo.PurgeEmails(ids)
+			if err != nil {
+				log.Error().Err(err).Str("folder", folderType).Msg("Failed to purge expired emails")
+				break
+			}
+			total += len(ids)
+
+			for _, path := range orphaned {
+				if err := s.emailService.RemoveObject(path); err != nil {
+					log.Warn().Err(err).Str("path", path).Msg("Failed to remove purged attachment")
+				}
+			}
+
+			if len(ids) < s.config.Retention.PurgeBatch {
+				break
+			}
+		}
+	}
+
+	if total > 0 {
+		log.Info().Int("emails", total).Msg("Purged expired mail")
+	}
+	return total
+}
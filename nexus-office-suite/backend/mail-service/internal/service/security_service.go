@@ -0,0 +1,555 @@
+package service
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/mailcrypto"
+	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrInvalidKey is returned for uploads that are not a usable certificate or key
+	ErrInvalidKey = errors.New("invalid certificate or key")
+	// ErrKeyAddress is returned for private keys not issued for the mailbox's address
+	ErrKeyAddress = errors.New("private key is not issued for this mailbox's address")
+	// ErrNoSigningKey is returned when signing or decrypting without an own key
+	ErrNoSigningKey = errors.New("no private key on file for this protocol")
+	// ErrRecipientKey is returned when encrypting to a recipient without a known key
+	ErrRecipientKey = errors.New("no key on file for recipient")
+)
+
+// SecurityService signs and encrypts outgoing mail and verifies and
+// decrypts incoming mail with S/MIME or OpenPGP, using the keys users keep
+// with the service
+type SecurityService struct {
+	keyRepo *repository.CryptoKeyRepository
+	kek     []byte
+	roots   *x509.CertPool
+}
+
+// NewSecurityService creates a new security service. It fails without a
+// key encryption key, since private keys would otherwise be sealed under a
+// secret shared with other services.
+func NewSecurityService(cfg *config.Config, keyRepo *repository.CryptoKeyRepository) (*SecurityService, error) {
+	if cfg.Crypto.KeyEncryptionKey == "" {
+		return nil, errors.New("MAIL_KEY_ENCRYPTION_KEY must be set")
+	}
+	kek := sha256.Sum256([]byte(cfg.Crypto.KeyEncryptionKey))
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if cfg.Crypto.TrustedRootsFile != "" {
+		bundle, err := os.ReadFile(cfg.Crypto.TrustedRootsFile)
+		if err != nil || !roots.AppendCertsFromPEM(bundle) {
+			log.Warn().Err(err).Str("file", cfg.Crypto.TrustedRootsFile).Msg("Failed to load S/MIME trusted roots")
+		}
+	}
+
+	return &SecurityService{
+		keyRepo: keyRepo,
+		kek:     kek[:],
+		roots:   roots,
+	}, nil
+}
+
+// UploadKey stores a certificate or key for each address it names.
+// Private keys are only accepted for the mailbox's own address.
+func (s *SecurityService) UploadKey(userID, mailbox string, req *model.UploadKeyRequest) ([]model.MailCryptoKey, error) {
+	mailbox = strings.ToLower(mailbox)
+
+	var (
+		addresses   []string
+		fingerprint string
+		publicKey   string
+		privateKey  []byte
+		expiresAt   *time.Time
+	)
+
+	switch req.Protocol {
+	case model.SecurityProtocolSMIME:
+		key, err := mailcrypto.ParseSMIMEKey([]byte(req.Data), req.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		addresses = mailcrypto.CertificateAddresses(key.Certificate)
+		fingerprint = mailcrypto.CertificateFingerprint(key.Certificate)
+		publicKey = mailcrypto.EncodeCertificates(append([]*x509.Certificate{key.Certificate}, key.Chain...)...)
+		notAfter := key.Certificate.NotAfter
+		expiresAt = &notAfter
+		if key.PrivateKey != nil {
+			if privateKey, err = mailcrypto.MarshalPrivateKey(key.PrivateKey); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+			}
+		}
+
+	case model.SecurityProtocolPGP:
+		entity, err := mailcrypto.ReadPGPKey(req.Data, req.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		addresses = mailcrypto.PGPIdentities(entity)
+		fingerprint = mailcrypto.PGPFingerprint(entity)
+		if publicKey, err = mailcrypto.ArmorPGPPublicKey(entity); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		expiresAt = pgpExpiry(entity)
+		if entity.PrivateKey != nil {
+			if privateKey, err = mailcrypto.SerializePGPPrivateKey(entity); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: unknown protocol %q", ErrInvalidKey, req.Protocol)
+	}
+
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("%w: it names no email address", ErrInvalidKey)
+	}
+
+	// An own key must be for the mailbox: other mailboxes find it by address
+	if privateKey != nil {
+		if !containsAddress(addresses, mailbox) {
+			return nil, ErrKeyAddress
+		}
+		addresses = []string{mailbox}
+
+		sealed, err := mailcrypto.SealPrivateKey(s.kek, privateKey)
+		if err != nil {
+			return nil, err
+		}
+		privateKey = sealed
+	}
+
+	keys := make([]model.MailCryptoKey, 0, len(addresses))
+	for _, address := range addresses {
+		key := model.MailCryptoKey{
+			UserID:      userID,
+			Protocol:    req.Protocol,
+			Address:     address,
+			Fingerprint: fingerprint,
+			PublicKey:   publicKey,
+			PrivateKey:  privateKey,
+			ExpiresAt:   expiresAt,
+		}
+		if err := s.keyRepo.Save(&key); err != nil {
+			return nil, fmt.Errorf("failed to save key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Seal signs and/or encrypts a complete outgoing message. Encrypted mail is
+// also encrypted to the sender's own key, if there is one, so that it stays
+// readable in other clients' Sent folders.
+func (s *SecurityService) Seal(userID, from string, raw []byte, recipients []string, protocol string, sign, encrypt bool) ([]byte, error) {
+	header, entity, err := mailcrypto.SplitMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	switch protocol {
+	case model.SecurityProtocolSMIME:
+		own, err := s.ownSMIMEKey(userID, from)
+		if err != nil && (sign || !errors.Is(err, ErrNoSigningKey)) {
+			return nil, err
+		}
+
+		if sign {
+			sig, err := mailcrypto.Sign(entity, own.Certificate, own.PrivateKey, own.Chain)
+			if err != nil {
+				return nil, fmt.Errorf("failed to sign message: %w", err)
+			}
+			if !encrypt {
+				return mailcrypto.SignedMessage(header, entity, sig, protocol), nil
+			}
+			entity = mailcrypto.SignedEntity(entity, sig, protocol)
+		}
+
+		certs := make([]*x509.Certificate, 0, len(recipients)+1)
+		for _, addr := range recipients {
+			cert, err := s.recipientCertificate(userID, addr)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		}
+		if own != nil {
+			certs = append(certs, own.Certificate)
+		}
+
+		p7m, err := mailcrypto.Encrypt(entity, certs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt message: %w", err)
+		}
+		return mailcrypto.EncryptedMessage(header, p7m, protocol), nil
+
+	case model.SecurityProtocolPGP:
+		own, err := s.ownPGPKey(userID)
+		if err != nil && (sign || !errors.Is(err, ErrNoSigningKey)) {
+			return nil, err
+		}
+
+		if !encrypt {
+			sig, err := mailcrypto.PGPSign(entity, own)
+			if err != nil {
+				return nil, fmt.Errorf("failed to sign message: %w", err)
+			}
+			return mailcrypto.SignedMessage(header, entity, sig, protocol), nil
+		}
+
+		to := make([]*openpgp.Entity, 0, len(recipients)+1)
+		for _, addr := range recipients {
+			entity, err := s.recipientPGPKey(userID, addr)
+			if err != nil {
+				return nil, err
+			}
+			to = append(to, entity)
+		}
+		if own != nil {
+			to = append(to, own)
+		}
+
+		var signer *openpgp.Entity
+		if sign {
+			signer = own
+		}
+		armored, err := mailcrypto.PGPEncrypt(entity, to, signer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt message: %w", err)
+		}
+		return mailcrypto.EncryptedMessage(header, armored, protocol), nil
+	}
+
+	return nil, fmt.Errorf("unknown security protocol %q", protocol)
+}
+
+// Open verifies and decrypts a stored signed or encrypted message for the
+// mailbox userID, as sent by from. Signatures are checked as of receivedAt,
+// so that neither a later expiry nor a backdated signing time changes the
+// result. It returns what it found and, when the content was not readable
+// without it (encrypted or opaque-signed mail), the innermost MIME entity.
+func (s *SecurityService) Open(userID, from string, raw []byte, receivedAt time.Time) (*model.MessageSecurity, []byte) {
+	security := &model.MessageSecurity{}
+	var inner []byte
+
+	data := raw
+	// Layers nest at most as sign-then-encrypt; allow a little more
+	for depth := 0; depth < 4; depth++ {
+		layer, err := mailcrypto.Parse(data)
+		if errors.Is(err, mailcrypto.ErrNotSecure) {
+			break
+		}
+		if err != nil {
+			security.Error = err.Error()
+			break
+		}
+		security.Protocol = layer.Protocol
+
+		if layer.Kind == mailcrypto.KindEncrypted {
+			security.Encrypted = true
+			plain, err := s.decrypt(userID, from, layer, security, receivedAt)
+			if err != nil {
+				security.Error = err.Error()
+				break
+			}
+			security.Decrypted = true
+			data, inner = plain, plain
+			continue
+		}
+
+		security.Signed = true
+		content := s.verify(userID, from, layer, security, receivedAt)
+		if content == nil {
+			break
+		}
+		data = content
+		if layer.Opaque || security.Encrypted {
+			inner = content
+		}
+	}
+
+	return security, inner
+}
+
+// verify checks a signature layer and returns the signed entity
+func (s *SecurityService) verify(userID, from string, layer *mailcrypto.Secured, security *model.MessageSecurity, receivedAt time.Time) []byte {
+	from = strings.ToLower(from)
+
+	if layer.Protocol == model.SecurityProtocolPGP {
+		keyring := s.correspondentPGPKeys(userID, from)
+		signer, err := mailcrypto.PGPVerify(layer.Entity, layer.Payload, keyring, receivedAt)
+		setPGPSignatureStatus(security, signer, err, from)
+		return layer.Entity
+	}
+
+	var content []byte
+	if !layer.Opaque {
+		content = layer.Entity
+	}
+	signer, signed, chainErr, err := mailcrypto.Verify(layer.Payload, content, s.roots, receivedAt)
+	switch {
+	case errors.Is(err, mailcrypto.ErrSignatureInvalid):
+		security.SignatureStatus = model.SignatureInvalid
+	case err != nil:
+		security.SignatureStatus = model.SignatureError
+		security.Error = err.Error()
+	case chainErr != nil && !s.trustsCertificate(userID, signer.Certificate):
+		security.SignatureStatus = model.SignatureUntrusted
+	default:
+		security.SignatureStatus = model.SignatureValid
+	}
+
+	if signer != nil {
+		addresses := mailcrypto.CertificateAddresses(signer.Certificate)
+		security.Signer = signer.Certificate.Subject.CommonName
+		if len(addresses) > 0 {
+			security.Signer = addresses[0]
+		}
+		security.SignerFingerprint = mailcrypto.CertificateFingerprint(signer.Certificate)
+		security.SignerMatchesFrom = containsAddress(addresses, from)
+		if !signer.SigningTime.IsZero() {
+			signedAt := signer.SigningTime
+			security.SignedAt = &signedAt
+		}
+	}
+
+	return signed
+}
+
+// decrypt opens an encryption layer with the mailbox's own keys. OpenPGP
+// messages may carry their signature inside, which is checked as well.
+func (s *SecurityService) decrypt(userID, from string, layer *mailcrypto.Secured, security *model.MessageSecurity, receivedAt time.Time) ([]byte, error) {
+	keys, err := s.keyRepo.PrivateKeys(userID, layer.Protocol)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+
+	if layer.Protocol == model.SecurityProtocolPGP {
+		keyring := s.correspondentPGPKeys(userID, strings.ToLower(from))
+		for i := range keys {
+			entity, err := s.pgpKey(&keys[i])
+			if err != nil {
+				log.Warn().Err(err).Str("keyID", keys[i].ID).Msg("Failed to load OpenPGP key")
+				continue
+			}
+			keyring = append(keyring, entity)
+		}
+
+		msg, err := mailcrypto.PGPDecrypt(layer.Payload, keyring, receivedAt)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Signed {
+			security.Signed = true
+			setPGPSignatureStatus(security, msg.Signer, msg.SignatureError, strings.ToLower(from))
+		}
+		return msg.Content, nil
+	}
+
+	for i := range keys {
+		key, err := s.smimeKey(&keys[i])
+		if err != nil {
+			log.Warn().Err(err).Str("keyID", keys[i].ID).Msg("Failed to load S/MIME key")
+			continue
+		}
+		decrypter, ok := key.PrivateKey.(crypto.Decrypter)
+		if !ok {
+			continue
+		}
+		plain, err := mailcrypto.Decrypt(layer.Payload, key.Certificate, decrypter)
+		if errors.Is(err, mailcrypto.ErrNotForRecipient) {
+			continue
+		}
+		return plain, err
+	}
+
+	return nil, mailcrypto.ErrNotForRecipient
+}
+
+func setPGPSignatureStatus(security *model.MessageSecurity, signer *openpgp.Entity, err error, from string) {
+	switch {
+	case errors.Is(err, mailcrypto.ErrUnknownKey):
+		security.SignatureStatus = model.SignatureUnknownKey
+	case errors.Is(err, mailcrypto.ErrSignatureInvalid):
+		security.SignatureStatus = model.SignatureInvalid
+	case errors.Is(err, mailcrypto.ErrKeyExpired):
+		security.SignatureStatus = model.SignatureUntrusted
+		security.Error = err.Error()
+	case err != nil:
+		security.SignatureStatus = model.SignatureError
+		security.Error = err.Error()
+	default:
+		// Keys come from the user's own store or the address owner, so a
+		// key on file for the sender is trusted
+		security.SignatureStatus = model.SignatureValid
+	}
+
+	if signer != nil {
+		identities := mailcrypto.PGPIdentities(signer)
+		if len(identities) > 0 {
+			security.Signer = identities[0]
+		}
+		security.SignerFingerprint = mailcrypto.PGPFingerprint(signer)
+		security.SignerMatchesFrom = containsAddress(identities, from)
+	}
+}
+
+// ownSMIMEKey returns the mailbox's newest S/MIME certificate with its key
+func (s *SecurityService) ownSMIMEKey(userID, from string) (*mailcrypto.SMIMEKey, error) {
+	keys, err := s.keyRepo.PrivateKeys(userID, model.SecurityProtocolSMIME)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if keys[i].Address != strings.ToLower(from) {
+			continue
+		}
+		return s.smimeKey(&keys[i])
+	}
+	return nil, ErrNoSigningKey
+}
+
+// ownPGPKey returns the mailbox's newest OpenPGP key with its private key
+func (s *SecurityService) ownPGPKey(userID string) (*openpgp.Entity, error) {
+	keys, err := s.keyRepo.PrivateKeys(userID, model.SecurityProtocolPGP)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+	return s.pgpKey(&keys[0])
+}
+
+func (s *SecurityService) recipientCertificate(userID, address string) (*x509.Certificate, error) {
+	keys, err := s.keyRepo.PublicKeys(userID, model.SecurityProtocolSMIME, strings.ToLower(address))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		certs, err := mailcrypto.ParseCertificates(key.PublicKey)
+		if err == nil {
+			return certs[0], nil
+		}
+	}
+	return nil, fmt.Errorf("%w %s", ErrRecipientKey, address)
+}
+
+func (s *SecurityService) recipientPGPKey(userID, address string) (*openpgp.Entity, error) {
+	keys, err := s.keyRepo.PublicKeys(userID, model.SecurityProtocolPGP, strings.ToLower(address))
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if entity, err := s.pgpKey(&keys[i]); err == nil {
+			return entity, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %s", ErrRecipientKey, address)
+}
+
+// correspondentPGPKeys returns the public keys on file for an address
+func (s *SecurityService) correspondentPGPKeys(userID, address string) openpgp.EntityList {
+	keys, err := s.keyRepo.PublicKeys(userID, model.SecurityProtocolPGP, address)
+	if err != nil {
+		log.Warn().Err(err).Str("address", address).Msg("Failed to look up OpenPGP keys")
+		return nil
+	}
+
+	var keyring openpgp.EntityList
+	for i := range keys {
+		if entity, err := s.pgpKey(&keys[i]); err == nil {
+			keyring = append(keyring, entity)
+		}
+	}
+	return keyring
+}
+
+// trustsCertificate reports whether the user stored this certificate
+// themselves, which vouches for it like a trusted root would
+func (s *SecurityService) trustsCertificate(userID string, cert *x509.Certificate) bool {
+	fingerprint := mailcrypto.CertificateFingerprint(cert)
+	for _, address := range mailcrypto.CertificateAddresses(cert) {
+		keys, err := s.keyRepo.PublicKeys(userID, model.SecurityProtocolSMIME, address)
+		if err != nil {
+			return false
+		}
+		for _, key := range keys {
+			if key.UserID == userID && key.Fingerprint == fingerprint {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *SecurityService) smimeKey(key *model.MailCryptoKey) (*mailcrypto.SMIMEKey, error) {
+	certs, err := mailcrypto.ParseCertificates(key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	smimeKey := &mailcrypto.SMIMEKey{Certificate: certs[0], Chain: certs[1:]}
+
+	if key.PrivateKey != nil {
+		der, err := mailcrypto.OpenPrivateKey(s.kek, key.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		if smimeKey.PrivateKey, err = mailcrypto.ParsePrivateKey(der); err != nil {
+			return nil, err
+		}
+	}
+	return smimeKey, nil
+}
+
+func (s *SecurityService) pgpKey(key *model.MailCryptoKey) (*openpgp.Entity, error) {
+	if key.PrivateKey == nil {
+		return mailcrypto.ReadPGPKey(key.PublicKey, "")
+	}
+	data, err := mailcrypto.OpenPrivateKey(s.kek, key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return mailcrypto.ParsePGPPrivateKey(data)
+}
+
+// pgpExpiry returns when a key's primary self-signature expires, if ever
+func pgpExpiry(entity *openpgp.Entity) *time.Time {
+	for _, identity := range entity.Identities {
+		sig := identity.SelfSignature
+		if sig != nil && sig.KeyLifetimeSecs != nil && *sig.KeyLifetimeSecs > 0 {
+			expires := entity.PrimaryKey.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+			return &expires
+		}
+	}
+	return nil
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, a := range addresses {
+		if strings.EqualFold(a, address) {
+			return true
+		}
+	}
+	return false
+}
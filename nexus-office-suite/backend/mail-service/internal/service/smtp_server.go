@@ -1,17 +1,25 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"nexus-mail-service/config"
+	"nexus-mail-service/internal/mailcrypto"
+	"nexus-mail-service/internal/middleware"
 	"nexus-mail-service/internal/model"
 	"nexus-mail-service/internal/repository"
 
 	"github.com/emersion/go-smtp"
+	"github.com/google/uuid"
 	"github.com/jhillyerd/enmime"
 	"github.com/rs/zerolog/log"
 )
@@ -24,6 +32,12 @@ type SMTPServer struct {
 	folderRepo       *repository.FolderRepository
 	emailService     *EmailService
 	spamFilter       *SpamFilter
+	contentFilter    *ContentFilter
+	jwtManager       *middleware.JWTManager
+	autoResponder    *AutoResponderService
+	listService      *ListService
+	quotaService     *QuotaService
+	tenants          *TenantDirectory
 }
 
 // NewSMTPServer creates a new SMTP server
@@ -33,19 +47,31 @@ func NewSMTPServer(
 	folderRepo *repository.FolderRepository,
 	emailService *EmailService,
 	spamFilter *SpamFilter,
+	contentFilter *ContentFilter,
+	jwtManager *middleware.JWTManager,
+	autoResponder *AutoResponderService,
+	listService *ListService,
+	quotaService *QuotaService,
+	tenants *TenantDirectory,
 ) *SMTPServer {
 	s := &SMTPServer{
-		config:       cfg,
-		emailRepo:    emailRepo,
-		folderRepo:   folderRepo,
-		emailService: emailService,
-		spamFilter:   spamFilter,
+		config:        cfg,
+		emailRepo:     emailRepo,
+		folderRepo:    folderRepo,
+		emailService:  emailService,
+		spamFilter:    spamFilter,
+		contentFilter: contentFilter,
+		jwtManager:    jwtManager,
+		autoResponder: autoResponder,
+		listService:   listService,
+		quotaService:  quotaService,
+		tenants:       tenants,
 	}
 
 	server := smtp.NewServer(&Backend{smtpServer: s})
 	server.Addr = fmt.Sprintf("%s:%s", cfg.SMTP.Host, cfg.SMTP.Port)
 	server.Domain = cfg.SMTP.Domain
-	server.MaxMessageBytes = int(cfg.SMTP.MaxMessageSize)
+	server.MaxMessageBytes = int64(cfg.SMTP.MaxMessageSize)
 	server.MaxRecipients = cfg.Email.MaxRecipientsPerEmail
 	server.AllowInsecureAuth = !cfg.SMTP.TLSEnabled
 	server.ReadTimeout = 10 * time.Second
@@ -96,25 +122,89 @@ type Session struct {
 	conn    *smtp.Conn
 	from    string
 	to      []string
+	size    int64                     // declared with MAIL FROM SIZE=, 0 if unknown
+	lists   []*model.DistributionList // list recipients, delivered by the list service
+	userID  string                    // set when the client authenticated for submission
 }
 
 // AuthPlain authenticates using PLAIN mechanism
 func (s *Session) AuthPlain(username, password string) error {
-	// TODO: Implement authentication with user database
-	// For now, accept all authentication attempts in development
-	log.Info().Str("username", username).Msg("SMTP authentication attempt")
+	// The password is a platform access token, as for IMAP
+	claims, err := s.backend.smtpServer.jwtManager.VerifyLogin(username, password)
+	if err != nil {
+		log.Warn().Err(err).Str("username", username).Msg("SMTP authentication failed")
+		return smtp.ErrAuthFailed
+	}
+
+	s.userID = claims.UserID
+	log.Info().Str("username", username).Msg("SMTP authentication successful")
 	return nil
 }
 
 // Mail sets the sender for the email
 func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
 	s.from = from
+	if opts != nil {
+		s.size = opts.Size
+	}
 	log.Debug().Str("from", from).Msg("SMTP MAIL FROM")
 	return nil
 }
 
 // Rcpt adds a recipient for the email
 func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
+	if listService := s.backend.smtpServer.listService; listService != nil {
+		list, err := listService.Lookup(to)
+		if err != nil {
+			log.Error().Err(err).Str("to", to).Msg("Failed to look up distribution list")
+			return err
+		}
+		if list != nil {
+			if err := listService.CheckPoster(list, s.from, s.userID); err != nil {
+				log.Warn().Err(err).Str("list", list.Address).Str("from", s.from).Msg("List post rejected")
+				return &smtp.SMTPError{
+					Code:         550,
+					EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+					Message:      "Only members may post to this list",
+				}
+			}
+			s.lists = append(s.lists, list)
+			log.Debug().Str("to", to).Msg("SMTP RCPT TO list")
+			return nil
+		}
+	}
+
+	// Only registered domains tie a mailbox to a tenant
+	tenantID, err := s.backend.smtpServer.tenants.RegisteredTenant(to)
+	if err != nil {
+		log.Error().Err(err).Str("to", to).Msg("Failed to resolve recipient tenant")
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Temporary failure, please try again later",
+		}
+	}
+
+	if quotas := s.backend.smtpServer.quotaService; quotas != nil {
+		err := quotas.Check(s.getUserIDFromEmail(to), tenantID, s.size)
+		if errors.Is(err, ErrQuotaExceeded) {
+			log.Warn().Str("to", to).Int64("size", s.size).Msg("Recipient mailbox over quota")
+			return &smtp.SMTPError{
+				Code:         452,
+				EnhancedCode: smtp.EnhancedCode{4, 2, 2},
+				Message:      "Mailbox full",
+			}
+		}
+		if err != nil {
+			log.Error().Err(err).Str("to", to).Msg("Failed to check mailbox quota")
+			return &smtp.SMTPError{
+				Code:         451,
+				EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+				Message:      "Temporary failure, please try again later",
+			}
+		}
+	}
+
 	s.to = append(s.to, to)
 	log.Debug().Str("to", to).Msg("SMTP RCPT TO")
 	return nil
@@ -122,8 +212,15 @@ func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
 
 // Data receives the email data
 func (s *Session) Data(r io.Reader) error {
+	// Keep the raw message: signatures are checked over its exact bytes
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read email")
+		return err
+	}
+
 	// Parse the email
-	envelope, err := enmime.ReadEnvelope(r)
+	envelope, err := enmime.ReadEnvelope(bytes.NewReader(raw))
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to parse email")
 		return err
@@ -133,7 +230,7 @@ func (s *Session) Data(r io.Reader) error {
 	email := &model.Email{
 		From:     s.from,
 		FromName: extractName(envelope.GetHeader("From")),
-		To:       model.StringArray(s.to),
+		To:       model.StringArray(s.recipients()),
 		Subject:  envelope.GetHeader("Subject"),
 		Body:     envelope.Text,
 		BodyHTML: envelope.HTML,
@@ -150,8 +247,8 @@ func (s *Session) Data(r io.Reader) error {
 	}
 
 	// Extract message headers
-	for key, values := range envelope.Header {
-		email.Headers[key] = values
+	for _, key := range envelope.GetHeaderKeys() {
+		email.Headers[key] = envelope.GetHeaderValues(key)
 	}
 
 	// Set Message-ID if present
@@ -187,10 +284,64 @@ func (s *Session) Data(r io.Reader) error {
 		}
 	}
 
-	// Determine recipient user ID from "To" address
-	// In a real system, you'd look up the user from the email address
-	userID := s.getUserIDFromEmail(s.to[0])
+	// Every recipient's tenant applies its own attachment policy. SMTP accepts
+	// or refuses a message as a whole, so any of them deferring or rejecting
+	// it does so for all recipients, before anything is stored.
+	parts := append(append([]*enmime.Part{}, envelope.Attachments...), envelope.Inlines...)
+	ctx, cancel := context.WithTimeout(context.Background(), s.backend.smtpServer.config.Security.ScanTimeout*2)
+	defer cancel()
+	verdicts := make(map[string]*ContentVerdict, len(s.to)) // by recipient
+	tenantVerdicts := make(map[string]*ContentVerdict)
+	for _, to := range s.to {
+		tenantID, err := s.backend.smtpServer.tenants.TenantForAddress(to)
+		if err != nil {
+			log.Error().Err(err).Str("to", to).Msg("Failed to resolve recipient tenant")
+			return &smtp.SMTPError{
+				Code:         451,
+				EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+				Message:      "Temporary failure, please try again later",
+			}
+		}
+
+		verdict, ok := tenantVerdicts[tenantID]
+		if !ok {
+			verdict = s.backend.smtpServer.contentFilter.Inspect(ctx, tenantID, parts)
+			if err := refuseContent(verdict); err != nil {
+				log.Warn().Str("from", s.from).Str("to", to).Msg("Message refused by recipient's attachment policy")
+				return err
+			}
+			tenantVerdicts[tenantID] = verdict
+		}
+		verdicts[to] = verdict
+	}
+
+	// Posts to distribution lists are archived and distributed by the list
+	// service; any other recipients get the message as usual
+	for _, list := range s.lists {
+		if err := s.deliverToList(list, copyEmail(email), envelope); err != nil {
+			return err
+		}
+	}
 
+	// Each mailbox gets its own copy, filed by its recipient's verdict
+	delivered := make(map[string]bool, len(s.to))
+	for _, to := range s.to {
+		userID := s.getUserIDFromEmail(to)
+		if delivered[userID] {
+			continue
+		}
+		delivered[userID] = true
+
+		if err := s.deliver(copyEmail(email), userID, to, envelope, raw, verdicts[to]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliver files a copy of a message in a recipient's mailbox
+func (s *Session) deliver(email *model.Email, userID, to string, envelope *enmime.Envelope, raw []byte, verdict *ContentVerdict) error {
 	// Get inbox folder for the user
 	inbox, err := s.backend.smtpServer.folderRepo.GetByType("inbox", userID)
 	if err != nil {
@@ -203,8 +354,27 @@ func (s *Session) Data(r io.Reader) error {
 	email.IsRead = false
 	email.ReceivedAt = time.Now()
 
+	if verdict.ScannerUnavailable {
+		email.Headers["X-Nexus-Scan-Status"] = []string{model.ScanStatusUnscanned}
+		email.Headers["X-Nexus-Scan-Warning"] = []string{"Attachments were not scanned for malware"}
+	}
+
+	if verdict.Quarantined() {
+		quarantine, err := s.backend.smtpServer.folderRepo.GetOrCreateByType("quarantine", userID)
+		if err != nil {
+			log.Error().Err(err).Str("userID", userID).Msg("Failed to get quarantine folder")
+			return err
+		}
+		email.FolderID = quarantine.ID
+		if verdict.Infected {
+			email.Headers["X-Nexus-Scan-Status"] = []string{model.ScanStatusInfected}
+		} else {
+			email.Headers["X-Nexus-Scan-Status"] = []string{model.ScanStatusBlocked}
+		}
+	}
+
 	// Run spam filter if enabled
-	if s.backend.smtpServer.config.Security.EnableSpamFilter {
+	if s.backend.smtpServer.config.Security.EnableSpamFilter && !verdict.Quarantined() {
 		spamScore, isSpam := s.backend.smtpServer.spamFilter.CheckSpam(email)
 		email.SpamScore = spamScore
 		email.IsSpam = isSpam
@@ -218,6 +388,11 @@ func (s *Session) Data(r io.Reader) error {
 		}
 	}
 
+	// Signed and encrypted mail keeps its original for reading
+	if secured, err := mailcrypto.Parse(raw); err == nil {
+		s.storeSecureOriginal(email, secured, raw)
+	}
+
 	// Save email to database
 	err = s.backend.smtpServer.emailRepo.Create(email)
 	if err != nil {
@@ -225,48 +400,12 @@ func (s *Session) Data(r io.Reader) error {
 		return err
 	}
 
-	// Process and save attachments
-	if len(envelope.Attachments) > 0 {
-		for _, att := range envelope.Attachments {
-			attachment := &model.Attachment{
-				EmailID:     email.ID,
-				Filename:    att.FileName,
-				ContentType: att.ContentType,
-				Size:        int64(len(att.Content)),
-				IsInline:    false,
-			}
+	// Process and save attachments and inline images
+	s.backend.smtpServer.emailService.SaveMessageParts(email.ID, envelope.Attachments, envelope.Inlines, verdict)
 
-			// Save attachment to storage (MinIO/S3)
-			storagePath, err := s.backend.smtpServer.emailService.SaveAttachment(email.ID, att.FileName, att.Content)
-			if err == nil {
-				attachment.StoragePath = storagePath
-				s.backend.smtpServer.emailRepo.CreateAttachment(attachment)
-			}
-		}
-	}
-
-	// Process inline images
-	if len(envelope.Inlines) > 0 {
-		for _, inline := range envelope.Inlines {
-			attachment := &model.Attachment{
-				EmailID:     email.ID,
-				Filename:    inline.FileName,
-				ContentType: inline.ContentType,
-				Size:        int64(len(inline.Content)),
-				IsInline:    true,
-			}
-
-			if inline.ContentID != "" {
-				attachment.ContentID = &inline.ContentID
-			}
-
-			// Save inline attachment
-			storagePath, err := s.backend.smtpServer.emailService.SaveAttachment(email.ID, inline.FileName, inline.Content)
-			if err == nil {
-				attachment.StoragePath = storagePath
-				s.backend.smtpServer.emailRepo.CreateAttachment(attachment)
-			}
-		}
+	// Out-of-office replies only go out for mail that reached the inbox
+	if email.FolderID == inbox.ID && s.backend.smtpServer.autoResponder != nil {
+		go s.backend.smtpServer.autoResponder.Respond(email, to, s.from)
 	}
 
 	log.Info().
@@ -283,6 +422,8 @@ func (s *Session) Data(r io.Reader) error {
 func (s *Session) Reset() {
 	s.from = ""
 	s.to = []string{}
+	s.lists = nil
+	s.size = 0
 }
 
 // Logout closes the session
@@ -292,6 +433,89 @@ func (s *Session) Logout() error {
 
 // Helper functions
 
+// recipients returns every envelope recipient, lists included
+func (s *Session) recipients() []string {
+	recipients := append([]string{}, s.to...)
+	for _, list := range s.lists {
+		recipients = append(recipients, list.Address)
+	}
+	return recipients
+}
+
+// deliverToList applies the list tenant's attachment policy to a post and
+// hands it to the list service
+func (s *Session) deliverToList(list *model.DistributionList, email *model.Email, envelope *enmime.Envelope) error {
+	email.ReceivedAt = time.Now()
+
+	parts := append(append([]*enmime.Part{}, envelope.Attachments...), envelope.Inlines...)
+	ctx, cancel := context.WithTimeout(context.Background(), s.backend.smtpServer.config.Security.ScanTimeout*2)
+	defer cancel()
+	verdict := s.backend.smtpServer.contentFilter.Inspect(ctx, list.TenantID, parts)
+
+	if err := refuseContent(verdict); err != nil {
+		log.Warn().Str("list", list.Address).Msg("List post refused by attachment policy")
+		return err
+	}
+	if verdict.ScannerUnavailable {
+		email.Headers["X-Nexus-Scan-Status"] = []string{model.ScanStatusUnscanned}
+	}
+
+	if err := s.backend.smtpServer.listService.Receive(list, email, envelope.Attachments, envelope.Inlines, verdict, s.from, s.userID); err != nil {
+		log.Error().Err(err).Str("list", list.Address).Msg("Failed to process list post")
+		return err
+	}
+	return nil
+}
+
+// refuseContent returns the error a message is deferred or rejected with
+// under the verdict's policy, or nil if it may be delivered
+func refuseContent(verdict *ContentVerdict) error {
+	if verdict.ScannerUnavailable && verdict.Policy.ScannerDownAction != model.PolicyActionDeliver {
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Content scanner unavailable, please try again later",
+		}
+	}
+	if verdict.Blocked && verdict.Policy.BlockAction == model.PolicyActionReject {
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      "Message contains an attachment type that is not allowed",
+		}
+	}
+	return nil
+}
+
+// copyEmail returns a copy of a message with headers of its own, to be
+// filed separately
+func copyEmail(email *model.Email) *model.Email {
+	copied := *email
+	copied.Headers = make(model.Headers, len(email.Headers))
+	for key, values := range email.Headers {
+		copied.Headers[key] = values
+	}
+	return &copied
+}
+
+// storeSecureOriginal stores the raw message of signed or encrypted mail,
+// which is verified and decrypted when the recipient reads it
+func (s *Session) storeSecureOriginal(email *model.Email, secured *mailcrypto.Secured, raw []byte) {
+	email.ID = uuid.New().String()
+	path := fmt.Sprintf("secure/%s.eml", email.ID)
+
+	err := s.backend.smtpServer.emailService.StoreObject(path, bytes.NewReader(raw), int64(len(raw)), "message/rfc822")
+	if err != nil {
+		log.Error().Err(err).Str("emailID", email.ID).Msg("Failed to store signed or encrypted original")
+		return
+	}
+
+	email.SecurityProtocol = &secured.Protocol
+	email.IsSigned = secured.Kind == mailcrypto.KindSigned
+	email.IsEncrypted = secured.Kind == mailcrypto.KindEncrypted
+	email.SecureMIMEPath = &path
+}
+
 func (s *Session) getUserIDFromEmail(email string) string {
 	// In a real system, look up user ID from email address in database
 	// For now, extract username from email
@@ -302,6 +526,36 @@ func (s *Session) getUserIDFromEmail(email string) string {
 	return "default-user"
 }
 
+// maxExtractedText caps how much attachment text is stored for indexing
+const maxExtractedText = 64 * 1024
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]+>`)
+
+// extractAttachmentText returns searchable text for text-based attachments
+func extractAttachmentText(contentType string, content []byte) string {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+
+	var text string
+	switch {
+	case mediaType == "text/html":
+		text = htmlTagPattern.ReplaceAllString(string(content), " ")
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json",
+		mediaType == "application/xml":
+		text = string(content)
+	default:
+		return ""
+	}
+
+	if !utf8.ValidString(text) {
+		text = strings.ToValidUTF8(text, "")
+	}
+	if len(text) > maxExtractedText {
+		text = strings.ToValidUTF8(text[:maxExtractedText], "")
+	}
+	return text
+}
+
 func extractName(fromHeader string) string {
 	// Extract name from "Name <email@domain.com>" format
 	if idx := strings.Index(fromHeader, "<"); idx > 0 {
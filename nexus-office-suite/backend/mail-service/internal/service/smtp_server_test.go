@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"nexus-mail-service/config"
+	"nexus-mail-service/internal/model"
+
+	"github.com/emersion/go-smtp"
+)
+
+// flakyScanner finds every attachment clean until it has scanned failAfter
+// of them, then fails
+type flakyScanner struct {
+	scans     int
+	failAfter int
+}
+
+func (f *flakyScanner) Scan(ctx context.Context, r io.Reader) (*ScanResult, error) {
+	f.scans++
+	if f.scans > f.failAfter {
+		return nil, errors.New("scanner unavailable")
+	}
+	return &ScanResult{}, nil
+}
+
+func (f *flakyScanner) Ping(ctx context.Context) error { return nil }
+
+const messageWithAttachment = "From: sender@remote.test\r\n" +
+	"Subject: Report\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=b\r\n" +
+	"\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"See attached.\r\n" +
+	"--b\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=report.pdf\r\n" +
+	"\r\n" +
+	"%PDF-1.4\r\n" +
+	"--b--\r\n"
+
+// newTestSession returns an SMTP session whose server has no storage, so
+// it fails if a message it should refuse is delivered
+func newTestSession(cfg *config.Config, scanner ContentScanner, lookup TenantLookup) *Session {
+	server := &SMTPServer{
+		config:        cfg,
+		contentFilter: NewContentFilter(cfg, scanner, nil),
+		tenants:       NewTenantDirectory(lookup),
+	}
+	return &Session{backend: &Backend{smtpServer: server}, from: "sender@remote.test"}
+}
+
+func TestDataInspectsEveryRecipientsTenant(t *testing.T) {
+	cfg := &config.Config{Security: config.SecurityConfig{
+		EnableVirusScanning: true,
+		ScanTimeout:         time.Second,
+		ScannerDownAction:   model.PolicyActionTempfail,
+	}}
+	domains := map[string]string{"example.com": "tenant-a", "example.org": "tenant-b"}
+
+	tests := []struct {
+		name   string
+		lookup TenantLookup
+		scans  int
+		want   int
+	}{
+		{
+			// tenant-a's scan succeeds, tenant-b's finds the scanner down
+			name:   "second recipient's policy defers",
+			lookup: func(domain string) (string, error) { return domains[domain], nil },
+			scans:  2,
+			want:   451,
+		},
+		{
+			name: "second recipient's tenant cannot be resolved",
+			lookup: func(domain string) (string, error) {
+				if domain == "example.org" {
+					return "", errors.New("database down")
+				}
+				return domains[domain], nil
+			},
+			scans: 1,
+			want:  451,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := &flakyScanner{failAfter: 1}
+			session := newTestSession(cfg, scanner, tt.lookup)
+			session.to = []string{"alice@example.com", "bob@example.org"}
+
+			err := session.Data(strings.NewReader(messageWithAttachment))
+			var smtpErr *smtp.SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Code != tt.want {
+				t.Fatalf("got %v, want SMTP %d", err, tt.want)
+			}
+			if scanner.scans != tt.scans {
+				t.Errorf("scanned %d times, want %d", scanner.scans, tt.scans)
+			}
+		})
+	}
+}
+
+func TestDataRejectsBeforeDelivering(t *testing.T) {
+	cfg := &config.Config{Security: config.SecurityConfig{
+		ScanTimeout:       time.Second,
+		BlockedExtensions: []string{".pdf"},
+		BlockAction:       model.PolicyActionReject,
+	}}
+	session := newTestSession(cfg, nil, func(string) (string, error) { return "", nil })
+	session.to = []string{"alice@example.com", "bob@example.org"}
+
+	err := session.Data(strings.NewReader(messageWithAttachment))
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
+		t.Fatalf("got %v, want SMTP 550", err)
+	}
+}
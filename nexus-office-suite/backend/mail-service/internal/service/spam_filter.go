@@ -2,25 +2,37 @@ package service
 
 import (
 	"fmt"
+	"math"
 	"net"
 	"strings"
 	"time"
 
 	"nexus-mail-service/config"
 	"nexus-mail-service/internal/model"
+	"nexus-mail-service/internal/repository"
 
 	"github.com/rs/zerolog/log"
 )
 
+// bayesWeight is how far (in score points) a confident Bayesian verdict can
+// move the combined score in either direction
+const bayesWeight = 8.0
+
 // SpamFilter handles spam detection
 type SpamFilter struct {
-	config *config.Config
+	config   *config.Config
+	spamRepo *repository.SpamRepository
+	bayes    *BayesClassifier
+	tenants  *TenantDirectory
 }
 
 // NewSpamFilter creates a new spam filter
-func NewSpamFilter(cfg *config.Config) *SpamFilter {
+func NewSpamFilter(cfg *config.Config, spamRepo *repository.SpamRepository, tenants *TenantDirectory) *SpamFilter {
 	return &SpamFilter{
-		config: cfg,
+		config:   cfg,
+		spamRepo: spamRepo,
+		bayes:    NewBayesClassifier(spamRepo),
+		tenants:  tenants,
 	}
 }
 
@@ -31,6 +43,18 @@ func (f *SpamFilter) CheckSpam(email *model.Email) (float64, bool) {
 		return 0.0, false
 	}
 
+	// Per-user allow/block lists override scoring entirely
+	listType, err := f.spamRepo.LookupSender(email.UserID, email.From)
+	if err != nil {
+		log.Warn().Err(err).Msg("Sender list lookup failed")
+	}
+	switch listType {
+	case model.SenderListAllow:
+		return 0.0, false
+	case model.SenderListBlock:
+		return 10.0, true
+	}
+
 	score := 0.0
 
 	// Check with SpamAssassin if enabled
@@ -48,21 +72,46 @@ func (f *SpamFilter) CheckSpam(email *model.Email) (float64, bool) {
 		score = f.heuristicSpamCheck(email)
 	}
 
+	// Shift the score by the tenant's trained Bayesian classifier
+	var bayesProb float64
+	var trained bool
+	tenantID, err := f.tenants.TenantForEmail(email, f.config.SMTP.Domain)
+	if err == nil {
+		bayesProb, trained, err = f.bayes.Classify(tenantID, email)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Bayesian classification failed")
+	}
+	if trained {
+		score += (bayesProb - 0.5) * 2 * bayesWeight
+	}
+
+	score = math.Max(0, math.Min(score, 10.0))
 	isSpam := score > 5.0
 
 	log.Debug().
 		Str("emailID", email.ID).
 		Float64("spamScore", score).
+		Float64("bayesProb", bayesProb).
 		Bool("isSpam", isSpam).
 		Msg("Spam check completed")
 
 	return score, isSpam
 }
 
+// Learn trains the tenant's classifier from a user's spam / not-spam decision
+func (f *SpamFilter) Learn(email *model.Email, isSpam bool) error {
+	tenantID, err := f.tenants.TenantForEmail(email, f.config.SMTP.Domain)
+	if err != nil {
+		return err
+	}
+	return f.bayes.Train(tenantID, email, isSpam)
+}
+
 // checkSpamAssassin checks email with SpamAssassin
 func (f *SpamFilter) checkSpamAssassin(email *model.Email) (float64, error) {
 	// Connect to SpamAssassin spamd
-	addr := fmt.Sprintf("%s:%s", f.config.Security.SpamAssassinHost, f.config.Security.SpamAssassinPort)
+	addr := net.JoinHostPort(f.config.Security.SpamAssassinHost, f.config.Security.SpamAssassinPort)
 	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
 	if err != nil {
 		return 0.0, err
@@ -239,39 +288,3 @@ func parseSpamScore(response string) float64 {
 	}
 	return 0.0
 }
-
-// CheckVirus checks email for viruses using ClamAV
-func (f *SpamFilter) CheckVirus(email *model.Email) (bool, error) {
-	if !f.config.Security.EnableVirusScanning {
-		return false, nil
-	}
-
-	// Connect to ClamAV
-	addr := fmt.Sprintf("%s:%s", f.config.Security.ClamAVHost, f.config.Security.ClamAVPort)
-	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
-	if err != nil {
-		return false, err
-	}
-	defer conn.Close()
-
-	// Send PING command to check if ClamAV is alive
-	_, err = conn.Write([]byte("zPING\x00"))
-	if err != nil {
-		return false, err
-	}
-
-	buffer := make([]byte, 4096)
-	n, err := conn.Read(buffer)
-	if err != nil {
-		return false, err
-	}
-
-	response := string(buffer[:n])
-	if !strings.Contains(response, "PONG") {
-		return false, fmt.Errorf("ClamAV not responding")
-	}
-
-	// In production, scan attachments using INSTREAM command
-	// For now, return false (no virus detected)
-	return false, nil
-}
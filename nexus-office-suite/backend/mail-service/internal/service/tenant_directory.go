@@ -0,0 +1,107 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"nexus-mail-service/internal/model"
+)
+
+// tenantCacheTTL is how long the tenant of a domain is remembered
+const tenantCacheTTL = time.Minute
+
+// unregisteredTenantPrefix names the tenants of unregistered domains, so
+// they never match a platform tenant ID
+const unregisteredTenantPrefix = "domain:"
+
+// TenantLookup returns the tenant a mail domain is registered to, or "" if
+// none is
+type TenantLookup func(domain string) (string, error)
+
+// TenantDirectory resolves the tenant an address belongs to from the
+// domains registered to each tenant. It is the only source of tenants for
+// inbound mail and mailboxes, so that the policies, quotas and spam
+// classifiers admins manage under their platform tenant apply to the mail
+// their domains receive. Addresses at unregistered domains are treated as a
+// tenant of their own, named by the domain, which no platform admin manages.
+type TenantDirectory struct {
+	lookup TenantLookup
+
+	mu    sync.Mutex
+	cache map[string]cachedTenant // domain -> registered tenant
+}
+
+type cachedTenant struct {
+	tenantID string
+	expires  time.Time
+}
+
+// NewTenantDirectory creates a tenant directory that looks domains up with
+// lookup
+func NewTenantDirectory(lookup TenantLookup) *TenantDirectory {
+	return &TenantDirectory{
+		lookup: lookup,
+		cache:  make(map[string]cachedTenant),
+	}
+}
+
+// TenantForAddress returns the tenant of an address's domain
+func (d *TenantDirectory) TenantForAddress(address string) (string, error) {
+	tenantID, err := d.RegisteredTenant(address)
+	if err != nil || tenantID != "" {
+		return tenantID, err
+	}
+	if domain := addressDomain(address); domain != "" {
+		return unregisteredTenantPrefix + domain, nil
+	}
+	return "", nil
+}
+
+// RegisteredTenant returns the tenant an address's domain is registered to,
+// or "" if it is not registered
+func (d *TenantDirectory) RegisteredTenant(address string) (string, error) {
+	domain := addressDomain(address)
+	if domain == "" {
+		return "", nil
+	}
+
+	d.mu.Lock()
+	cached, ok := d.cache[domain]
+	d.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.tenantID, nil
+	}
+
+	tenantID, err := d.lookup(domain)
+	if err != nil {
+		return "", err
+	}
+
+	d.mu.Lock()
+	d.cache[domain] = cachedTenant{tenantID: tenantID, expires: time.Now().Add(tenantCacheTTL)}
+	d.mu.Unlock()
+
+	return tenantID, nil
+}
+
+// TenantForEmail returns the tenant that owns a mailbox message, from the
+// recipient address belonging to the mailbox owner, or defaultDomain's if
+// none does
+func (d *TenantDirectory) TenantForEmail(email *model.Email, defaultDomain string) (string, error) {
+	recipients := append(append([]string{}, email.To...), email.CC...)
+	for _, addr := range recipients {
+		if strings.HasPrefix(strings.ToLower(addr), strings.ToLower(email.UserID)+"@") {
+			return d.TenantForAddress(addr)
+		}
+	}
+	return d.TenantForAddress("@" + defaultDomain)
+}
+
+// addressDomain returns the lower-cased domain of an address
+func addressDomain(address string) string {
+	if idx := strings.LastIndex(address, "@"); idx >= 0 {
+		return strings.ToLower(address[idx+1:])
+	}
+	return ""
+}
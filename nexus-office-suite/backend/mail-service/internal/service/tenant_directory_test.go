@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"nexus-mail-service/internal/model"
+)
+
+// fakeTenantDomains returns a TenantLookup over domains and counts its calls
+func fakeTenantDomains(domains map[string]string, calls *int) TenantLookup {
+	return func(domain string) (string, error) {
+		*calls++
+		return domains[domain], nil
+	}
+}
+
+func TestTenantForAddress(t *testing.T) {
+	var calls int
+	tenants := NewTenantDirectory(fakeTenantDomains(map[string]string{"example.com": "tenant-a"}, &calls))
+
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"alice@example.com", "tenant-a"},
+		{"Bob@EXAMPLE.COM", "tenant-a"},
+		{"carol@unregistered.org", "domain:unregistered.org"},
+		// A domain named like a tenant does not become that tenant
+		{"mallory@tenant-a", "domain:tenant-a"},
+		{"no-domain", ""},
+	}
+	for _, tt := range tests {
+		got, err := tenants.TenantForAddress(tt.address)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.address, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got tenant %q, want %q", tt.address, got, tt.want)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("looked domains up %d times, want 3", calls)
+	}
+}
+
+func TestRegisteredTenant(t *testing.T) {
+	var calls int
+	tenants := NewTenantDirectory(fakeTenantDomains(map[string]string{"example.com": "tenant-a"}, &calls))
+
+	if tenantID, err := tenants.RegisteredTenant("alice@example.com"); err != nil || tenantID != "tenant-a" {
+		t.Errorf("registered domain: got %q, %v, want tenant-a", tenantID, err)
+	}
+	if tenantID, err := tenants.RegisteredTenant("alice@unregistered.org"); err != nil || tenantID != "" {
+		t.Errorf("unregistered domain: got %q, %v, want no tenant", tenantID, err)
+	}
+}
+
+func TestTenantForAddressLookupError(t *testing.T) {
+	lookupErr := errors.New("database down")
+	tenants := NewTenantDirectory(func(string) (string, error) { return "", lookupErr })
+
+	// Falling back to the domain would put the mail under the wrong tenant
+	if tenantID, err := tenants.TenantForAddress("alice@example.com"); !errors.Is(err, lookupErr) {
+		t.Errorf("got tenant %q and error %v, want %v", tenantID, err, lookupErr)
+	}
+}
+
+func TestTenantForEmail(t *testing.T) {
+	var calls int
+	tenants := NewTenantDirectory(fakeTenantDomains(map[string]string{
+		"example.com": "tenant-a",
+		"example.org": "tenant-b",
+	}, &calls))
+
+	tests := []struct {
+		name  string
+		email *model.Email
+		want  string
+	}{
+		{
+			name:  "mailbox owner among the recipients",
+			email: &model.Email{UserID: "alice", To: []string{"bob@example.com"}, CC: []string{"Alice@example.org"}},
+			want:  "tenant-b",
+		},
+		{
+			name:  "mailbox owner not among the recipients",
+			email: &model.Email{UserID: "alice", To: []string{"list@example.org"}},
+			want:  "tenant-a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tenants.TenantForEmail(tt.email, "example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got tenant %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+-- NEXUS Mail Service: full-text search
+-- Maintains a weighted tsvector per email covering subject (A), participants (B),
+-- plain and HTML-stripped body (C) and attachment filenames/extracted text (D).
+
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS extracted_text TEXT;
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS attachment_text TEXT DEFAULT '';
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS search_vector TSVECTOR;
+
+CREATE OR REPLACE FUNCTION emails_search_vector_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('english', COALESCE(NEW.subject, '')), 'A') ||
+        setweight(to_tsvector('simple',
+            COALESCE(NEW.from_address, '') || ' ' ||
+            COALESCE(NEW.from_name, '') || ' ' ||
+            translate(COALESCE(NEW.to_addresses::text, ''), '[]",', '    ') || ' ' ||
+            translate(COALESCE(NEW.cc_addresses::text, ''), '[]",', '    ')), 'B') ||
+        setweight(to_tsvector('english',
+            COALESCE(NEW.body, '') || ' ' ||
+            regexp_replace(COALESCE(NEW.body_html, ''), '<[^>]+>', ' ', 'g')), 'C') ||
+        setweight(to_tsvector('english', COALESCE(NEW.attachment_text, '')), 'D');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS update_emails_search_vector ON emails;
+CREATE TRIGGER update_emails_search_vector
+    BEFORE INSERT OR UPDATE OF subject, from_address, from_name, to_addresses, cc_addresses,
+        body, body_html, attachment_text
+    ON emails
+    FOR EACH ROW EXECUTE FUNCTION emails_search_vector_update();
+
+-- Fold attachment filenames and text into the parent email's index
+CREATE OR REPLACE FUNCTION attachments_search_text_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE emails
+    SET attachment_text = COALESCE(attachment_text, '') || ' ' ||
+        NEW.filename || ' ' || COALESCE(NEW.extracted_text, '')
+    WHERE id = NEW.email_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS update_attachments_search_text ON attachments;
+CREATE TRIGGER update_attachments_search_text
+    AFTER INSERT ON attachments
+    FOR EACH ROW EXECUTE FUNCTION attachments_search_text_update();
+
+-- Backfill existing rows
+UPDATE emails e
+SET attachment_text = COALESCE((
+    SELECT string_agg(a.filename || ' ' || COALESCE(a.extracted_text, ''), ' ')
+    FROM attachments a
+    WHERE a.email_id = e.id
+), '');
+
+CREATE INDEX IF NOT EXISTS idx_emails_search_vector ON emails USING gin(search_vector);
+CREATE INDEX IF NOT EXISTS idx_emails_user_received ON emails(user_id, received_at DESC);
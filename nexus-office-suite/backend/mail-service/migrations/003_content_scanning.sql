@@ -0,0 +1,19 @@
+-- NEXUS Mail Service: content scanning and attachment policy
+
+-- Per-attachment scan verdicts
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS scan_status VARCHAR(20) NOT NULL DEFAULT 'unscanned'; -- clean, infected, blocked, unscanned, disabled
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS scan_result TEXT NOT NULL DEFAULT '';
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_attachments_scan_status ON attachments(scan_status);
+
+-- Tenant attachment policies (tenants without a row use the service defaults)
+CREATE TABLE IF NOT EXISTS attachment_policies (
+    tenant_id VARCHAR(255) PRIMARY KEY,
+    scan_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    blocked_extensions JSONB NOT NULL DEFAULT '[]',
+    blocked_mime_types JSONB NOT NULL DEFAULT '[]',
+    block_action VARCHAR(20) NOT NULL DEFAULT 'quarantine', -- reject, quarantine
+    scanner_down_action VARCHAR(20) NOT NULL DEFAULT 'tempfail', -- tempfail, deliver
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,38 @@
+-- NEXUS Mail Service: Bayesian spam learning and sender lists
+
+-- Per-tenant token counts
+CREATE TABLE IF NOT EXISTS spam_tokens (
+    tenant_id VARCHAR(255) NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    spam_count INTEGER NOT NULL DEFAULT 0,
+    ham_count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant_id, token)
+);
+
+-- Per-tenant number of trained messages
+CREATE TABLE IF NOT EXISTS spam_corpus (
+    tenant_id VARCHAR(255) PRIMARY KEY,
+    spam_messages INTEGER NOT NULL DEFAULT 0,
+    ham_messages INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- How each email was last trained, so retraining can undo the previous class
+CREATE TABLE IF NOT EXISTS spam_training (
+    email_id VARCHAR(36) PRIMARY KEY REFERENCES emails(id) ON DELETE CASCADE,
+    tenant_id VARCHAR(255) NOT NULL,
+    class VARCHAR(10) NOT NULL, -- spam, ham
+    trained_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Per-user allow/block lists (full address or domain)
+CREATE TABLE IF NOT EXISTS sender_lists (
+    id VARCHAR(36) PRIMARY KEY DEFAULT uuid_generate_v4()::VARCHAR,
+    user_id VARCHAR(36) NOT NULL,
+    address VARCHAR(255) NOT NULL,
+    list_type VARCHAR(10) NOT NULL, -- allow, block
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, address)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sender_lists_user_id ON sender_lists(user_id);
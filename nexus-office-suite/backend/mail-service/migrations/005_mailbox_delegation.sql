@@ -0,0 +1,16 @@
+-- NEXUS Mail Service: delegated and shared mailbox access
+
+CREATE TABLE IF NOT EXISTS mailbox_delegations (
+    id UUID PRIMARY KEY,
+    tenant_id VARCHAR(255) NOT NULL,
+    mailbox_id VARCHAR(255) NOT NULL, -- user ID of the mailbox owner
+    delegate_id VARCHAR(255) NOT NULL,
+    access VARCHAR(20) NOT NULL DEFAULT 'read', -- read, full
+    send_as BOOLEAN NOT NULL DEFAULT FALSE,
+    send_on_behalf BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(mailbox_id, delegate_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_mailbox_delegations_delegate ON mailbox_delegations(delegate_id);
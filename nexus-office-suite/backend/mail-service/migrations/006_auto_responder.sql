@@ -0,0 +1,18 @@
+-- NEXUS Mail Service: vacation auto-responder / out-of-office
+
+ALTER TABLE auto_responders ADD COLUMN IF NOT EXISTS reply_to_external BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE auto_responders ADD COLUMN IF NOT EXISTS external_subject VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE auto_responders ADD COLUMN IF NOT EXISTS external_message TEXT NOT NULL DEFAULT '';
+ALTER TABLE auto_responders ADD COLUMN IF NOT EXISTS use_calendar BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE auto_responders ADD COLUMN IF NOT EXISTS reply_interval_days INTEGER NOT NULL DEFAULT 7;
+
+-- One auto-responder per user
+CREATE UNIQUE INDEX IF NOT EXISTS idx_auto_responders_user_unique ON auto_responders(user_id);
+
+-- Senders already answered, so each gets at most one reply per interval
+CREATE TABLE IF NOT EXISTS auto_responder_replies (
+    user_id VARCHAR(255) NOT NULL,
+    sender VARCHAR(255) NOT NULL,
+    replied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, sender)
+);
@@ -0,0 +1,27 @@
+-- NEXUS Mail Service: mailbox import/export jobs
+
+CREATE TABLE IF NOT EXISTS mailbox_jobs (
+    id UUID PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    tenant_id VARCHAR(255) NOT NULL DEFAULT '',
+    type VARCHAR(20) NOT NULL, -- import, export
+    format VARCHAR(20) NOT NULL, -- mbox, eml
+    folder_id VARCHAR(255),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, running, completed, failed
+    total INTEGER NOT NULL DEFAULT 0,
+    processed INTEGER NOT NULL DEFAULT 0,
+    imported INTEGER NOT NULL DEFAULT 0,
+    skipped INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    storage_path TEXT NOT NULL DEFAULT '',
+    filename VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_mailbox_jobs_user_id ON mailbox_jobs(user_id, created_at DESC);
+
+-- Imports dedupe on Message-ID
+CREATE INDEX IF NOT EXISTS idx_emails_user_message_id ON emails(user_id, message_id);
@@ -0,0 +1,113 @@
+-- NEXUS Mail Service: change tracking for JMAP
+-- Every change to an email, thread or folder is appended to mail_changes by
+-- trigger, whichever path made it (HTTP API, IMAP, SMTP delivery, import).
+-- seq is a per-user counter taken under a row lock, so it increases in commit
+-- order for each user; the highest seq per object type is that type's JMAP
+-- state string.
+
+CREATE TABLE IF NOT EXISTS mail_change_seq (
+    user_id VARCHAR(36) PRIMARY KEY,
+    seq BIGINT NOT NULL DEFAULT 0,
+    pruned_up_to BIGINT NOT NULL DEFAULT 0 -- changes up to here were pruned
+);
+
+CREATE TABLE IF NOT EXISTS mail_changes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    seq BIGINT NOT NULL,
+    object_type VARCHAR(20) NOT NULL, -- Email, Thread, Mailbox
+    object_id VARCHAR(255) NOT NULL,
+    change_type VARCHAR(20) NOT NULL, -- created, updated, destroyed
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_mail_changes_user_type_seq ON mail_changes(user_id, object_type, seq);
+CREATE INDEX IF NOT EXISTS idx_mail_changes_created_at ON mail_changes(created_at);
+
+CREATE OR REPLACE FUNCTION record_mail_change(
+    p_user_id VARCHAR, p_object_type VARCHAR, p_object_id VARCHAR, p_change_type VARCHAR
+) RETURNS VOID AS $$
+DECLARE
+    next_seq BIGINT;
+BEGIN
+    INSERT INTO mail_change_seq (user_id, seq) VALUES (p_user_id, 1)
+    ON CONFLICT (user_id) DO UPDATE SET seq = mail_change_seq.seq + 1
+    RETURNING seq INTO next_seq;
+
+    INSERT INTO mail_changes (user_id, seq, object_type, object_id, change_type)
+    VALUES (p_user_id, next_seq, p_object_type, p_object_id, p_change_type);
+
+    -- Wakes JMAP EventSource connections; repeated payloads in one
+    -- transaction are delivered once
+    PERFORM pg_notify('mail_changes', p_user_id);
+END;
+$$ LANGUAGE plpgsql;
+
+-- Emails: soft-deleted rows are invisible to clients, so deleting or
+-- restoring one is recorded as destroying or creating it
+CREATE OR REPLACE FUNCTION emails_record_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    was_visible BOOLEAN := TG_OP <> 'INSERT' AND NOT OLD.is_deleted;
+    is_visible BOOLEAN := TG_OP <> 'DELETE' AND NOT NEW.is_deleted;
+BEGIN
+    IF is_visible AND NOT was_visible THEN
+        PERFORM record_mail_change(NEW.user_id, 'Email', NEW.id, 'created');
+        PERFORM record_mail_change(NEW.user_id, 'Thread', NEW.thread_id,
+            CASE WHEN EXISTS (
+                SELECT 1 FROM emails
+                WHERE user_id = NEW.user_id AND thread_id = NEW.thread_id AND id <> NEW.id AND is_deleted = false
+            ) THEN 'updated' ELSE 'created' END);
+        PERFORM record_mail_change(NEW.user_id, 'Mailbox', NEW.folder_id, 'updated');
+
+    ELSIF was_visible AND NOT is_visible THEN
+        PERFORM record_mail_change(OLD.user_id, 'Email', OLD.id, 'destroyed');
+        PERFORM record_mail_change(OLD.user_id, 'Thread', OLD.thread_id,
+            CASE WHEN EXISTS (
+                SELECT 1 FROM emails
+                WHERE user_id = OLD.user_id AND thread_id = OLD.thread_id AND id <> OLD.id AND is_deleted = false
+            ) THEN 'updated' ELSE 'destroyed' END);
+        PERFORM record_mail_change(OLD.user_id, 'Mailbox', OLD.folder_id, 'updated');
+
+    ELSIF was_visible AND is_visible THEN
+        -- Only mailbox and keyword changes are visible to JMAP clients
+        IF NEW.folder_id IS DISTINCT FROM OLD.folder_id
+            OR NEW.is_read IS DISTINCT FROM OLD.is_read
+            OR NEW.is_starred IS DISTINCT FROM OLD.is_starred
+            OR NEW.is_draft IS DISTINCT FROM OLD.is_draft THEN
+            PERFORM record_mail_change(NEW.user_id, 'Email', NEW.id, 'updated');
+            PERFORM record_mail_change(NEW.user_id, 'Mailbox', NEW.folder_id, 'updated');
+        END IF;
+        IF NEW.folder_id IS DISTINCT FROM OLD.folder_id THEN
+            PERFORM record_mail_change(OLD.user_id, 'Mailbox', OLD.folder_id, 'updated');
+        END IF;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS record_emails_change ON emails;
+CREATE TRIGGER record_emails_change
+    AFTER INSERT OR UPDATE OF folder_id, is_read, is_starred, is_draft, is_deleted OR DELETE
+    ON emails
+    FOR EACH ROW EXECUTE FUNCTION emails_record_change();
+
+CREATE OR REPLACE FUNCTION folders_record_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        PERFORM record_mail_change(NEW.user_id, 'Mailbox', NEW.id, 'created');
+    ELSIF TG_OP = 'UPDATE' THEN
+        PERFORM record_mail_change(NEW.user_id, 'Mailbox', NEW.id, 'updated');
+    ELSE
+        PERFORM record_mail_change(OLD.user_id, 'Mailbox', OLD.id, 'destroyed');
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS record_folders_change ON folders;
+CREATE TRIGGER record_folders_change
+    AFTER INSERT OR UPDATE OR DELETE ON folders
+    FOR EACH ROW EXECUTE FUNCTION folders_record_change();
@@ -0,0 +1,43 @@
+-- NEXUS Mail Service: distribution lists with moderation, digests and archive
+--
+-- Each list has its own mailbox (user_id = list id) holding the archive and
+-- the moderation queue, and members receive copies of accepted posts.
+
+CREATE TABLE IF NOT EXISTS distribution_lists (
+    id UUID PRIMARY KEY,
+    tenant_id VARCHAR(255) NOT NULL,
+    address VARCHAR(255) NOT NULL UNIQUE, -- lowercased, e.g. sales@example.com
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    posting_policy VARCHAR(20) NOT NULL DEFAULT 'members', -- members, moderated, anyone
+    subject_prefix VARCHAR(100) NOT NULL DEFAULT '',
+    archive_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_distribution_lists_tenant ON distribution_lists(tenant_id);
+
+CREATE TABLE IF NOT EXISTS distribution_list_members (
+    id UUID PRIMARY KEY,
+    list_id UUID NOT NULL REFERENCES distribution_lists(id) ON DELETE CASCADE,
+    address VARCHAR(255) NOT NULL, -- lowercased
+    user_id VARCHAR(255), -- local mailbox to deliver into; NULL for external members
+    role VARCHAR(20) NOT NULL DEFAULT 'member', -- member, moderator, owner
+    delivery VARCHAR(20) NOT NULL DEFAULT 'each', -- each, digest, none
+    last_digest_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(list_id, address)
+);
+
+CREATE INDEX IF NOT EXISTS idx_distribution_list_members_user ON distribution_list_members(user_id);
+CREATE INDEX IF NOT EXISTS idx_distribution_list_members_digest
+    ON distribution_list_members(last_digest_at) WHERE delivery = 'digest';
+
+-- List posts are delivered into many mailboxes with the same Message-ID, so
+-- Message-IDs only need to be unique per mailbox
+ALTER TABLE emails DROP CONSTRAINT IF EXISTS emails_message_id_key;
+DROP INDEX IF EXISTS idx_emails_user_message_id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_emails_user_message_id ON emails(user_id, message_id);
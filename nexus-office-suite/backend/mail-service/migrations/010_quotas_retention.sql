@@ -0,0 +1,112 @@
+-- NEXUS Mail Service: storage quotas, retention policies and legal holds
+--
+-- mailbox_usage keeps each mailbox's stored bytes and message count current
+-- by trigger, so quota checks do not scan emails. A mailbox's tenant is
+-- recorded the first time it is seen with one (SMTP delivery or an admin
+-- setting its quota) and is what tenant quotas and retention policies match.
+
+CREATE TABLE IF NOT EXISTS mailbox_usage (
+    user_id VARCHAR(255) PRIMARY KEY,
+    tenant_id VARCHAR(255),
+    storage_bytes BIGINT NOT NULL DEFAULT 0,
+    message_count BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_mailbox_usage_tenant ON mailbox_usage(tenant_id);
+
+CREATE OR REPLACE FUNCTION emails_track_usage()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('DELETE', 'UPDATE') THEN
+        UPDATE mailbox_usage SET
+            storage_bytes = storage_bytes - COALESCE(OLD.size, 0),
+            message_count = message_count - 1,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE user_id = OLD.user_id;
+    END IF;
+
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO mailbox_usage (user_id, storage_bytes, message_count)
+        VALUES (NEW.user_id, COALESCE(NEW.size, 0), 1)
+        ON CONFLICT (user_id) DO UPDATE SET
+            storage_bytes = mailbox_usage.storage_bytes + EXCLUDED.storage_bytes,
+            message_count = mailbox_usage.message_count + 1,
+            updated_at = CURRENT_TIMESTAMP;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS track_emails_usage ON emails;
+CREATE TRIGGER track_emails_usage
+    AFTER INSERT OR DELETE OR UPDATE OF size, user_id ON emails
+    FOR EACH ROW EXECUTE FUNCTION emails_track_usage();
+
+INSERT INTO mailbox_usage (user_id, storage_bytes, message_count)
+SELECT user_id, COALESCE(SUM(size), 0), COUNT(*) FROM emails GROUP BY user_id
+ON CONFLICT (user_id) DO UPDATE SET
+    storage_bytes = EXCLUDED.storage_bytes,
+    message_count = EXCLUDED.message_count;
+
+-- Storage limits for a mailbox (scope 'user') or a whole tenant (scope
+-- 'tenant'). Mailboxes without a row get DEFAULT_QUOTA_MB; tenants without
+-- a row are unlimited. A limit of 0 means unlimited.
+CREATE TABLE IF NOT EXISTS mail_quotas (
+    scope VARCHAR(10) NOT NULL, -- user, tenant
+    subject_id VARCHAR(255) NOT NULL,
+    storage_limit_bytes BIGINT NOT NULL DEFAULT 0,
+    updated_by VARCHAR(255) NOT NULL DEFAULT '',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (scope, subject_id)
+);
+
+-- Per-tenant overrides of how long mail stays in a folder type before the
+-- purge worker deletes it. 0 keeps mail forever.
+CREATE TABLE IF NOT EXISTS retention_policies (
+    tenant_id VARCHAR(255) NOT NULL,
+    folder_type VARCHAR(50) NOT NULL, -- trash, spam
+    retention_days INTEGER NOT NULL,
+    updated_by VARCHAR(255) NOT NULL DEFAULT '',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, folder_type)
+);
+
+-- Mailboxes under an active legal hold are never purged
+CREATE TABLE IF NOT EXISTS legal_holds (
+    id UUID PRIMARY KEY,
+    tenant_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    released_by VARCHAR(255),
+    released_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_legal_holds_tenant ON legal_holds(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_legal_holds_active ON legal_holds(user_id) WHERE released_at IS NULL;
+
+-- Retention counts from when mail entered its folder, not from later flag
+-- changes, so track the time of the last move
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS folder_changed_at TIMESTAMP;
+UPDATE emails SET folder_changed_at = COALESCE(updated_at, created_at) WHERE folder_changed_at IS NULL;
+ALTER TABLE emails ALTER COLUMN folder_changed_at SET DEFAULT CURRENT_TIMESTAMP;
+
+CREATE OR REPLACE FUNCTION emails_track_folder_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.folder_id IS DISTINCT FROM OLD.folder_id THEN
+        NEW.folder_changed_at := CURRENT_TIMESTAMP;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS track_emails_folder_change ON emails;
+CREATE TRIGGER track_emails_folder_change
+    BEFORE UPDATE OF folder_id ON emails
+    FOR EACH ROW EXECUTE FUNCTION emails_track_folder_change();
+
+CREATE INDEX IF NOT EXISTS idx_emails_folder_changed_at ON emails(folder_id, folder_changed_at);
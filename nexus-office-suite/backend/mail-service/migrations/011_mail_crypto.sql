@@ -0,0 +1,29 @@
+-- NEXUS Mail Service: S/MIME and OpenPGP
+--
+-- mail_crypto_keys holds each user's own keys, with the private key sealed
+-- by the service's key encryption key, and the public keys of the people
+-- they write to (private_key NULL). Keys are matched to mail by address.
+
+CREATE TABLE IF NOT EXISTS mail_crypto_keys (
+    id UUID PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    protocol VARCHAR(10) NOT NULL, -- smime, pgp
+    address VARCHAR(255) NOT NULL, -- lower case
+    fingerprint VARCHAR(128) NOT NULL,
+    public_key TEXT NOT NULL, -- PEM certificate chain or armored OpenPGP public key
+    private_key BYTEA,
+    expires_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, protocol, fingerprint, address)
+);
+
+CREATE INDEX IF NOT EXISTS idx_mail_crypto_keys_user ON mail_crypto_keys(user_id, protocol);
+CREATE INDEX IF NOT EXISTS idx_mail_crypto_keys_address ON mail_crypto_keys(address, protocol) WHERE private_key IS NOT NULL;
+
+-- Signed or encrypted mail keeps its original MIME in object storage
+-- (secure_mime_path) so that it can be verified and decrypted when read.
+-- Sent copies are stored decrypted and only record what was applied.
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS security_protocol VARCHAR(10);
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS is_signed BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS is_encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS secure_mime_path VARCHAR(500);
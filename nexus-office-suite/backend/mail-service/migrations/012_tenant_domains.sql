@@ -0,0 +1,29 @@
+-- NEXUS Mail Service: the mail domains of each tenant
+--
+-- Inbound mail, mailboxes and addresses are tied to tenants through the
+-- domains registered here, so SMTP delivery and the platform tokens admins
+-- manage policies and quotas with agree on tenant IDs. Domains are
+-- registered by operators; no API request can change them. Addresses at
+-- unregistered domains are treated as a tenant of their own, named by the
+-- domain (prefixed "domain:" so it cannot match a platform tenant ID), which
+-- no platform admin manages.
+
+CREATE TABLE IF NOT EXISTS tenant_domains (
+    domain VARCHAR(255) PRIMARY KEY, -- lower case
+    tenant_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_tenant_domains_tenant ON tenant_domains(tenant_id);
+
+-- Spam classifiers were trained per recipient domain until now. They stay
+-- with their domain until it is registered; its tenant's classifier then
+-- starts over.
+UPDATE spam_tokens SET tenant_id = 'domain:' || tenant_id WHERE tenant_id NOT LIKE 'domain:%';
+UPDATE spam_corpus SET tenant_id = 'domain:' || tenant_id WHERE tenant_id NOT LIKE 'domain:%';
+UPDATE spam_training SET tenant_id = 'domain:' || tenant_id WHERE tenant_id NOT LIKE 'domain:%';
+
+-- Mailbox tenants recorded until now came from recipient domains or from
+-- whichever admin first named the mailbox. They are recorded again, from
+-- registered domains only, as mail is delivered.
+UPDATE mailbox_usage SET tenant_id = NULL;